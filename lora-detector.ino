@@ -30,6 +30,10 @@
 #include <WiFi.h>
 #include <HTTPClient.h>
 #include "secrets.h"  // WiFi credentials and server URL
+#ifdef UPLOAD_SIGNING_KEY
+#include <time.h>
+#include "mbedtls/md.h"
+#endif
 
 // ============================================
 // CONFIGURATION
@@ -43,6 +47,10 @@
 #define DISPLAY_UPDATE_MS   100
 #define FREQ_HOP_SCANS      3
 
+// Print a "Payload: {...}" stats line this often for the server's serial bridge
+// (lora-detector-server -serial /dev/ttyUSB0). Set to 0 to disable.
+#define SERIAL_STATS_MS     60000
+
 // Display Y offset - increase if top of screen is cut off by case
 // Set to 0 for no offset, 4-8 if top is obscured
 #define DISPLAY_Y_OFFSET    6
@@ -160,6 +168,7 @@ char terminalLog[TERMINAL_LINES][TERMINAL_LINE_LEN];
 int terminalIndex = 0;
 unsigned long uptimeSeconds = 0;
 unsigned long lastSecond = 0;
+unsigned long lastSerialStats = 0;
 
 // Meter needle smoothing
 float smoothedPercent = 0;
@@ -219,6 +228,7 @@ void updateStats();
 void connectWiFiAndUpload();
 void drawUploadScreen();
 bool uploadStats();
+String buildStatsJson();
 
 // ============================================
 // SETUP
@@ -329,6 +339,12 @@ void loop() {
 
   // Update stats
   updateStats();
+
+  // Stats line for a USB-attached server
+  if (SERIAL_STATS_MS > 0 && now - lastSerialStats >= SERIAL_STATS_MS) {
+    lastSerialStats = now;
+    Serial.println("Payload: " + buildStatsJson());
+  }
 }
 
 // ============================================
@@ -1068,17 +1084,8 @@ void connectWiFiAndUpload() {
   Serial.println("WiFi disconnected");
 }
 
-bool uploadStats() {
-  HTTPClient http;
-
-  Serial.print("Uploading to: ");
-  Serial.println(SERVER_URL);
-
-  http.begin(SERVER_URL);
-  http.addHeader("Content-Type", "application/json");
-  http.setTimeout(10000);  // 10 second timeout
-
-  // Build JSON payload
+// Stats as the JSON /upload payload, also printed for the serial bridge
+String buildStatsJson() {
   String json = "{";
   json += "\"device_id\":\"" + String(DEVICE_ID) + "\",";
   json += "\"uptime_seconds\":" + String(uptimeSeconds) + ",";
@@ -1092,9 +1099,53 @@ bool uploadStats() {
     if (i < NUM_FREQUENCIES - 1) json += ",";
   }
   json += "]}";
+  return json;
+}
 
+#ifdef UPLOAD_SIGNING_KEY
+// Hex HMAC-SHA256 of "time\npath\nbody" with the device's signing key, for X-Signature
+String signUpload(const String& ts, const char* path, const String& body) {
+  String msg = ts + "\n" + path + "\n" + body;
+  uint8_t mac[32];
+  mbedtls_md_context_t ctx;
+  mbedtls_md_init(&ctx);
+  mbedtls_md_setup(&ctx, mbedtls_md_info_from_type(MBEDTLS_MD_SHA256), 1);
+  mbedtls_md_hmac_starts(&ctx, (const unsigned char*)UPLOAD_SIGNING_KEY, strlen(UPLOAD_SIGNING_KEY));
+  mbedtls_md_hmac_update(&ctx, (const unsigned char*)msg.c_str(), msg.length());
+  mbedtls_md_hmac_finish(&ctx, mac);
+  mbedtls_md_free(&ctx);
+  char hex[65];
+  for (int i = 0; i < 32; i++) sprintf(hex + 2 * i, "%02x", mac[i]);
+  return String(hex);
+}
+#endif
+
+bool uploadStats() {
+  HTTPClient http;
+
+  Serial.print("Uploading to: ");
+  Serial.println(SERVER_URL);
+
+  http.begin(SERVER_URL);
+  http.addHeader("Content-Type", "application/json");
+  http.setTimeout(10000);  // 10 second timeout
+
+  String json = buildStatsJson();
   Serial.println("Payload: " + json);
 
+#ifdef UPLOAD_SIGNING_KEY
+  // Signatures carry the time, so set the clock first (no RTC on the board)
+  configTime(0, 0, "pool.ntp.org");
+  time_t now = time(nullptr);
+  for (int i = 0; i < 50 && now < 1700000000; i++) {
+    delay(100);
+    now = time(nullptr);
+  }
+  String ts = String((unsigned long)now);
+  http.addHeader("X-Signature-Time", ts);
+  http.addHeader("X-Signature", signUpload(ts, "/upload", json));
+#endif
+
   int httpCode = http.POST(json);
 
   Serial.print("HTTP Response: ");
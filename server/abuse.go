@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A server exposed to the internet gets floods of junk on its public ingest
+// endpoints: malformed JSON, uploads that fail validation, and posts with bad
+// signatures (detectors', relays' or federation peers') or webhook tokens. Wrong
+// admin passwords count too. With ABUSE_MAX_FAILURES set, each of these is
+// counted against the sender's IP, and one that fails ABUSE_MAX_FAILURES times
+// within ABUSE_WINDOW_MIN is banned from every device ingest path (see
+// deviceIngestPaths) for ABUSE_BAN_MIN, doubling with each ban after the first
+// up to abuseMaxBan; banned requests get 429 with Retry-After and go no
+// further. Clock skew isn't counted, since a detector with a wrong clock is told
+// the time and fixes itself.
+//
+// Banning is off by default: behind a proxy every request comes from the
+// proxy's address, and one misbehaving client would ban them all. Requests
+// from TRUSTED_PROXIES count against the client the proxy names (see
+// proxyAuth.clientAddr), so set TRUSTED_PROXIES (and on Fly.io
+// PROXY_CLIENT_HEADER=Fly-Client-IP) before turning it on behind a proxy. Loopback peers are never banned, and requests over
+// a unix socket or from a proxy that names no client have no IP to count.
+// Offenders are kept in memory only, at most abuseMaxTracked of them, and
+// listed on /admin and /api/admin/abuse.
+const (
+	abuseMaxBan     = 24 * time.Hour
+	abuseMaxTracked = 10000
+)
+
+// abuseConfig is when a sender is banned; MaxFailures 0 turns banning off
+type abuseConfig struct {
+	MaxFailures int
+	Window      time.Duration
+	Ban         time.Duration
+}
+
+func abuseConfigFromEnv() abuseConfig {
+	c := abuseConfig{Window: 10 * time.Minute, Ban: 15 * time.Minute}
+	if v, err := strconv.Atoi(os.Getenv("ABUSE_MAX_FAILURES")); err == nil && v >= 0 {
+		c.MaxFailures = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ABUSE_WINDOW_MIN")); err == nil && v > 0 {
+		c.Window = time.Duration(v) * time.Minute
+	}
+	if v, err := strconv.Atoi(os.Getenv("ABUSE_BAN_MIN")); err == nil && v > 0 {
+		c.Ban = time.Duration(v) * time.Minute
+	}
+	return c
+}
+
+// Offender is one IP's failed requests, for /api/admin/abuse
+type Offender struct {
+	IP          string     `json:"ip"`
+	Recent      int        `json:"recent"` // Failures within the window
+	Failures    int        `json:"failures"`
+	LastReason  string     `json:"last_reason"`
+	LastPath    string     `json:"last_path"`
+	LastSeen    time.Time  `json:"last_seen"`
+	Bans        int        `json:"bans,omitempty"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+	Blocked     int        `json:"blocked,omitempty"` // Requests refused while banned
+}
+
+// offender is what's tracked per IP
+type offender struct {
+	Offender
+	recent      []time.Time
+	bannedUntil time.Time
+}
+
+// abuseTracker counts failures per IP and bans those over the limit
+type abuseTracker struct {
+	config abuseConfig
+
+	mu  sync.Mutex
+	ips map[netip.Addr]*offender
+}
+
+var abuse = &abuseTracker{config: abuseConfigFromEnv(), ips: make(map[netip.Addr]*offender)}
+
+// clientIP is the address a request came from: the client a trusted proxy
+// names, otherwise the peer. It reports false for unix socket peers and for
+// proxies that name no client.
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	if proxy != nil && proxy.fromTrustedProxy(r) {
+		return proxy.clientAddr(r)
+	}
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false // Unix socket
+	}
+	return ap.Addr().Unmap(), true
+}
+
+// abuseIP is the address a request's failures count against, or false for
+// peers that are never banned
+func abuseIP(r *http.Request) (netip.Addr, bool) {
+	addr, ok := clientIP(r)
+	if !ok || addr.IsLoopback() {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+// noteAbuse counts a failed request against its sender, banning the sender
+// once it's over the limit
+func noteAbuse(r *http.Request, reason string) {
+	addr, ok := abuseIP(r)
+	if !ok || abuse.config.MaxFailures == 0 {
+		return
+	}
+	now := timeNow()
+	a := abuse
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o := a.ips[addr]
+	if o == nil {
+		if len(a.ips) >= abuseMaxTracked {
+			a.prune(now)
+			if len(a.ips) >= abuseMaxTracked {
+				return
+			}
+		}
+		o = &offender{Offender: Offender{IP: addr.String()}}
+		a.ips[addr] = o
+	}
+	o.Failures++
+	o.LastReason, o.LastPath, o.LastSeen = reason, r.URL.Path, now
+	o.recent = append(o.recent, now)
+	o.recent = o.recent[o.recentStart(now, a.config.Window):]
+	if len(o.recent) < a.config.MaxFailures || now.Before(o.bannedUntil) {
+		return
+	}
+	ban := a.config.Ban << min(o.Bans, 16)
+	ban = min(ban, abuseMaxBan)
+	o.Bans++
+	o.bannedUntil = now.Add(ban)
+	o.recent = nil
+	log.Printf("Banning %s for %s: %d failed requests within %s, the last %s on %s",
+		addr, ban, a.config.MaxFailures, a.config.Window, reason, r.URL.Path)
+}
+
+// recentStart is the index of the first failure still within the window
+func (o *offender) recentStart(now time.Time, window time.Duration) int {
+	i := 0
+	for i < len(o.recent) && now.Sub(o.recent[i]) > window {
+		i++
+	}
+	return i
+}
+
+// prune forgets IPs that are neither banned nor failing recently; a.mu must be held
+func (a *abuseTracker) prune(now time.Time) {
+	for addr, o := range a.ips {
+		if now.After(o.bannedUntil) && now.Sub(o.LastSeen) > a.config.Window {
+			delete(a.ips, addr)
+		}
+	}
+}
+
+// banned reports how much longer the sender of a request is banned for
+func (a *abuseTracker) banned(r *http.Request) time.Duration {
+	addr, ok := abuseIP(r)
+	if !ok {
+		return 0
+	}
+	now := timeNow()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o := a.ips[addr]
+	if o == nil || !now.Before(o.bannedUntil) {
+		return 0
+	}
+	o.Blocked++
+	return o.bannedUntil.Sub(now)
+}
+
+// unban lifts a ban and forgets the IP's failures, reporting whether it was tracked
+func (a *abuseTracker) unban(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.ips[addr.Unmap()]
+	delete(a.ips, addr.Unmap())
+	return ok
+}
+
+// offenders lists tracked IPs, banned ones first, then by recent failures
+func (a *abuseTracker) offenders() []Offender {
+	now := timeNow()
+	a.mu.Lock()
+	a.prune(now)
+	list := make([]Offender, 0, len(a.ips))
+	for _, o := range a.ips {
+		off := o.Offender
+		off.Recent = len(o.recent) - o.recentStart(now, a.config.Window)
+		if now.Before(o.bannedUntil) {
+			until := o.bannedUntil
+			off.BannedUntil = &until
+		}
+		list = append(list, off)
+	}
+	a.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].BannedUntil != nil) != (list[j].BannedUntil != nil) {
+			return list[i].BannedUntil != nil
+		}
+		if list[i].Recent != list[j].Recent {
+			return list[i].Recent > list[j].Recent
+		}
+		return list[i].IP < list[j].IP
+	})
+	return list
+}
+
+// abuseGuard refuses banned senders on the device ingest paths
+func abuseGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deviceIngestPaths[r.URL.Path] {
+			if wait := abuse.banned(r); wait > 0 {
+				io.Copy(io.Discard, io.LimitReader(r.Body, 1<<16))
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "Too many failed requests; try again later", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAPIAdminAbuse lists offenders (GET /api/admin/abuse) or lifts an IP's
+// ban (POST ip=ADDR). Admin only once an admin user exists.
+func handleAPIAdminAbuse(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"max_failures": abuse.config.MaxFailures,
+			"window_min":   int(abuse.config.Window.Minutes()),
+			"ban_min":      int(abuse.config.Ban.Minutes()),
+			"offenders":    abuse.offenders(),
+		})
+	case http.MethodPost:
+		ip := r.FormValue("ip")
+		if !abuse.unban(ip) {
+			http.Error(w, "Unknown IP", http.StatusNotFound)
+			return
+		}
+		log.Printf("Unbanned %s", ip)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "ip": ip})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// renderAbuseCard lists offenders on /admin, each with a button lifting its ban
+func renderAbuseCard(w io.Writer) {
+	cfg := abuse.config
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Abuse</h2>
+`)
+	if cfg.MaxFailures == 0 {
+		fmt.Fprintf(w, `        <p>Banning is off; set ABUSE_MAX_FAILURES to turn it on.</p>
+    </div>
+`)
+		return
+	}
+	fmt.Fprintf(w, `        <p>IPs failing %d requests within %d minutes are banned for %d minutes, doubling for repeat offenders.</p>
+`, cfg.MaxFailures, int(cfg.Window.Minutes()), int(cfg.Ban.Minutes()))
+	list := abuse.offenders()
+	if len(list) == 0 {
+		fmt.Fprintf(w, `        <p class="admin-ok">No failed requests.</p>
+    </div>
+`)
+		return
+	}
+	fmt.Fprintf(w, `        <table class="admin-table">
+            <tr><th>IP</th><th>Recent</th><th>Total</th><th>Last failure</th><th>Banned until</th><th></th></tr>
+`)
+	for _, o := range list {
+		until, class := "", ""
+		if o.BannedUntil != nil {
+			until, class = o.BannedUntil.Format("2006-01-02 15:04:05")+fmt.Sprintf(" (%d blocked)", o.Blocked), ` class="admin-bad"`
+		}
+		fmt.Fprintf(w, `            <tr><td%s>%s</td><td>%d</td><td>%d</td><td>%s · %s on %s</td><td>%s</td>
+                <td><form method="post" action="/admin" class="admin-actions"><button type="submit" name="unban" value="%s">Forget</button></form></td></tr>
+`, class, html.EscapeString(o.IP), o.Recent, o.Failures, o.LastSeen.Format("15:04:05"),
+			html.EscapeString(o.LastReason), html.EscapeString(o.LastPath), until, html.EscapeString(o.IP))
+	}
+	fmt.Fprintf(w, `        </table>
+    </div>
+`)
+}
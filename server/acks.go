@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// Acks are issued by the upload writer (see writer.go) in the transaction that
+// stores the upload, so a save that fails doesn't use up an ack the device
+// never received, which it would then report missing. An upload that fails to
+// save (see savefailures.go) gets no ack; the device resends it with its
+// last_ack level with the server's, and no gap is recorded.
+const (
+	selectAckSQL = `SELECT last_ack FROM upload_acks WHERE device_id = ?`
+	upsertAckSQL = `INSERT INTO upload_acks (device_id, last_ack, missed) VALUES (?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET last_ack = excluded.last_ack, missed = missed + excluded.missed`
+)
+
+// ackRequest asks the writer to issue an ack for an upload
+type ackRequest struct {
+	lastAck     *int64 // The last ack the device says it received
+	ack, missed int64  // Set once the upload is committed
+}
+
+// issueAck hands out the next per-device upload sequence number within tx,
+// using the prepared selectAckSQL and upsertAckSQL. lastAck is the most recent
+// ack the device says it received; if it trails the last one issued, the device
+// never saw those responses (lost in transit or timed out), which is recorded as
+// a gap. Returns the new ack and how many acks the device missed.
+func issueAck(ctx context.Context, tx *sql.Tx, stmts []*sql.Stmt, deviceID string, lastAck *int64) (ack, missed int64, err error) {
+	var prev int64
+	err = tx.StmtContext(ctx, stmts[0]).QueryRowContext(ctx, deviceID).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+
+	if lastAck != nil {
+		switch {
+		case *lastAck < prev:
+			missed = prev - *lastAck
+		case *lastAck > prev:
+			// The device has acks we never issued: the database was reset or restored
+			log.Printf("Device %s reports ack %d but last issued was %d", deviceID, *lastAck, prev)
+			prev = *lastAck
+		}
+	}
+
+	ack = prev + 1
+	if _, err = tx.StmtContext(ctx, stmts[1]).ExecContext(ctx, deviceID, ack, missed); err != nil {
+		return 0, 0, err
+	}
+	return ack, missed, nil
+}
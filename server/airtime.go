@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LoRa packet settings assumed for airtime estimates: the usual 8-symbol
+// preamble, explicit header, CRC on and coding rate 4/5. Events without a
+// payload length are counted at defaultPayloadBytes.
+const (
+	loraPreambleSymbols = 8
+	loraCodingRate      = 1 // 4/(4+CR)
+	defaultPayloadBytes = 20
+	maxPayloadBytes     = 255
+)
+
+// loraAirtime is the time on air of one LoRa packet (Semtech AN1200.13)
+func loraAirtime(sf int, bwKHz float64, payload int) time.Duration {
+	symbol := math.Pow(2, float64(sf)) / (bwKHz * 1000)
+	lowRate := 0.0
+	if symbol > 0.016 {
+		lowRate = 1
+	}
+	preamble := (loraPreambleSymbols + 4.25) * symbol
+	bits := float64(8*payload-4*sf+28+16) / (4 * (float64(sf) - 2*lowRate))
+	symbols := 8 + math.Max(math.Ceil(bits)*(loraCodingRate+4), 0)
+	return time.Duration((preamble + symbols*symbol) * float64(time.Second))
+}
+
+// payloadLen returns the event's payload length as stored: nil when not
+// reported or longer than a LoRa packet can be
+func (e DetectionEvent) payloadLen() interface{} {
+	if e.Len == nil || *e.Len < 0 || *e.Len > maxPayloadBytes {
+		return nil
+	}
+	return *e.Len
+}
+
+// CategoryAirtime is one category's estimated time on air
+type CategoryAirtime struct {
+	Category string    `json:"category"`
+	Seconds  []float64 `json:"seconds"` // per hour, oldest first
+	Total    float64   `json:"total_seconds"`
+	PeakPct  float64   `json:"peak_pct"`            // busiest hour, % of each of the category's channels
+	LimitPct float64   `json:"limit_pct,omitempty"` // band duty-cycle limit, if there is one
+	Events   int       `json:"events"`
+	Assumed  int       `json:"assumed_length"` // events counted at defaultPayloadBytes
+}
+
+// AirtimeReport is a device's estimated airtime per category per hour
+type AirtimeReport struct {
+	Hours      []time.Time       `json:"hours"`
+	Categories []CategoryAirtime `json:"categories"`
+}
+
+// channelAirtime is one plan slot's estimated time on air
+type channelAirtime struct {
+	Seconds []float64 // per hour, oldest first
+	Events  int
+	Assumed int // events counted at defaultPayloadBytes
+}
+
+// getChannelAirtime estimates airtime per plan slot per hour from a device's
+// events that carry SF and bandwidth
+func (s *Store) getChannelAirtime(ctx context.Context, deviceID string, start time.Time, hours int) []channelAirtime {
+	channels := make([]channelAirtime, len(frequencies()))
+	for i := range channels {
+		channels[i].Seconds = make([]float64, hours)
+	}
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT timestamp, freq_index, sf, bw, payload_len FROM events
+		WHERE device_id = ? AND timestamp >= ? AND sf IS NOT NULL AND bw IS NOT NULL
+	`, deviceID, start.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading airtime: %v", err)
+		return channels
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts string
+		var fi, sf int
+		var bw float64
+		var length sql.NullInt64
+		if err := rows.Scan(&ts, &fi, &sf, &bw, &length); err != nil {
+			continue
+		}
+		h := int(parseDBTime(ts).Sub(start) / time.Hour)
+		if h < 0 || h >= hours || fi < 0 || fi >= len(channels) {
+			continue
+		}
+		c := &channels[fi]
+		payload := defaultPayloadBytes
+		if length.Valid {
+			payload = int(length.Int64)
+		} else {
+			c.Assumed++
+		}
+		c.Seconds[h] += loraAirtime(sf, bw, payload).Seconds()
+		c.Events++
+	}
+	return channels
+}
+
+// getAirtime estimates airtime per category per hour. Utilization is airtime
+// over the hour, spread across the category's channels, so it can be held
+// against the band's duty-cycle limit.
+func (s *Store) getAirtime(ctx context.Context, deviceID string, hours int) AirtimeReport {
+	end := timeNow().Truncate(time.Hour).Add(time.Hour)
+	start := end.Add(-time.Duration(hours) * time.Hour)
+	report := AirtimeReport{Hours: make([]time.Time, hours)}
+	for h := range report.Hours {
+		report.Hours[h] = start.Add(time.Duration(h) * time.Hour)
+	}
+	channels := s.getChannelAirtime(ctx, deviceID, start, hours)
+
+	for _, info := range planCategoryInfo() {
+		c := CategoryAirtime{Category: info.Name, Seconds: make([]float64, hours), LimitPct: categoryDutyCycle(info.Name)}
+		slots := 0
+		for i, f := range frequencies() {
+			if !f.Scanned() || f.Category != info.Name {
+				continue
+			}
+			slots++
+			for h, secs := range channels[i].Seconds {
+				c.Seconds[h] += secs
+				c.Total += secs
+			}
+			c.Events += channels[i].Events
+			c.Assumed += channels[i].Assumed
+		}
+		if c.Events == 0 {
+			continue
+		}
+		for _, secs := range c.Seconds {
+			c.PeakPct = max(c.PeakPct, secs*100/3600/float64(max(slots, 1)))
+		}
+		report.Categories = append(report.Categories, c)
+	}
+	return report
+}
+
+// categoryDutyCycle is the duty-cycle limit that applies to a category's
+// channels, or 0 when they have none or their bands disagree
+func categoryDutyCycle(name string) float64 {
+	limit := -1.0
+	for _, f := range frequencies() {
+		if !f.Scanned() || f.Category != name {
+			continue
+		}
+		d := f.band().DutyCycle
+		if limit >= 0 && d != limit {
+			return 0
+		}
+		limit = d
+	}
+	return max(limit, 0)
+}
+
+// formatAirtime renders seconds on air compactly
+func formatAirtime(l Lang, secs float64) string {
+	if secs < 60 {
+		return l.Tf("%.1f s", secs)
+	}
+	return l.Tf("%.1f min", secs/60)
+}
+
+// renderAirtimeCard draws hourly airtime by category and each category's
+// busiest hour against its duty-cycle limit
+func renderAirtimeCard(w io.Writer, l Lang, r AirtimeReport) {
+	series := make([][]float64, len(r.Hours))
+	colors := make([]string, len(r.Categories))
+	for i, c := range r.Categories {
+		info, _ := categoryByName(c.Category)
+		colors[i] = info.Color
+	}
+	for h := range series {
+		series[h] = make([]float64, len(r.Categories))
+		for i, c := range r.Categories {
+			series[h][i] = c.Seconds[h]
+		}
+	}
+	fmt.Fprintf(w, `        <div class="chart-label">%s</div>
+        `, l.T("Estimated airtime per hour"))
+	renderCategoryBars(w, series, colors, 600, 80)
+	fmt.Fprintf(w, `
+        <table class="freq-table airtime-table">
+            <caption class="sr-only">%s</caption>
+            <thead><tr><th scope="col">%s</th><th scope="col">%s</th><th scope="col">%s</th><th scope="col">%s</th></tr></thead>
+            <tbody>
+`, html.EscapeString(l.T("Airtime and duty cycle per category")), l.T("Category"), l.T("Airtime"), l.T("Busiest hour"), l.T("Duty-cycle limit"))
+	for _, c := range r.Categories {
+		info, _ := categoryByName(c.Category)
+		limit, class := l.T("none"), ""
+		if c.LimitPct > 0 {
+			limit = fmt.Sprintf("%g%%", c.LimitPct)
+			if c.PeakPct > c.LimitPct {
+				class = ` class="over-limit"`
+			}
+		}
+		fmt.Fprintf(w, `            <tr class="freq-row"><th scope="row" style="color: %s;">%s %s</th><td class="freq-count">%s</td><td class="freq-count"%s>%.2f%%</td><td class="freq-count">%s</td></tr>
+`, info.Color, info.Icon, html.EscapeString(l.T(info.Title)), formatAirtime(l, c.Total), class, c.PeakPct, limit)
+	}
+	fmt.Fprintf(w, `            </tbody>
+        </table>
+        <p class="baseline-note">%s</p>
+`, l.Tf("Estimated from spreading factor, bandwidth and packet length; packets without a length count as %d bytes. The busiest hour is spread across the category's channels.", defaultPayloadBytes))
+}
+
+// handleAPIAirtime returns estimated airtime per category per hour: GET /api/airtime?device=ID&hours=24
+func handleAPIAirtime(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.getAirtime(r.Context(), deviceID, hours))
+}
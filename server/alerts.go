@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Alert is a notable condition raised while processing uploads
+type Alert struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+}
+
+// alertCooldown stops the same alert kind firing repeatedly for one device
+const alertCooldown = time.Hour
+
+// raiseAlert records an alert unless an identical kind fired recently for the device
+func (s *Store) raiseAlert(ctx context.Context, deviceID, kind, message string) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	var recent int
+	s.queryRow(ctx, `
+		SELECT COUNT(*) FROM alerts
+		WHERE device_id = ? AND kind = ? AND timestamp > ?
+	`, deviceID, kind, timeNow().Add(-alertCooldown).Format("2006-01-02 15:04:05")).Scan(&recent)
+	if recent > 0 {
+		return
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO alerts (device_id, timestamp, kind, message) VALUES (?, ?, ?, ?)
+	`, deviceID, timeNow().Format("2006-01-02 15:04:05"), kind, message)
+	if err != nil {
+		log.Printf("Error saving alert: %v", err)
+		return
+	}
+	log.Printf("ALERT [%s] %s: %s", kind, deviceID, message)
+	s.fireWebhooks(ctx, WebhookEvent{Event: webhookAlert, DeviceID: deviceID, Time: timeNow(), Kind: kind, Message: message})
+	syslogOut.alert(deviceID, kind, message, timeNow())
+}
+
+// getRecentAlerts returns the newest alerts first
+func (s *Store) getRecentAlerts(ctx context.Context, limit int, deviceIDs ...string) []Alert {
+	cond, args := deviceFilter(deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, device_id, timestamp, kind, message
+		FROM alerts WHERE `+cond+` ORDER BY id DESC LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		log.Printf("Error loading alerts: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		var a Alert
+		var ts string
+		if err := rows.Scan(&a.ID, &a.DeviceID, &ts, &a.Kind, &a.Message); err != nil {
+			log.Printf("Error scanning alert: %v", err)
+			continue
+		}
+		a.Timestamp = parseDBTime(ts)
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation is a note pinned to a time range, explaining a change in the data
+// ("moved antenna to roof"). An empty DeviceID applies it to every detector.
+type Annotation struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+	Color     string    `json:"-"` // Shading on charts; empty for annotationColor
+}
+
+// annotationColor shades annotated ranges on charts
+const annotationColor = "#ffd54f"
+
+// addAnnotation saves a note and returns its ID
+func (s *Store) addAnnotation(ctx context.Context, a Annotation) (int64, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	res, err := s.exec(ctx, `
+		INSERT INTO annotations (device_id, start_time, end_time, note, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, a.DeviceID, a.Start.Format("2006-01-02 15:04:05"), a.End.Format("2006-01-02 15:04:05"),
+		a.Note, timeNow().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// deleteAnnotation removes a note, reporting whether it existed
+func (s *Store) deleteAnnotation(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	res, err := s.exec(ctx, `DELETE FROM annotations WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// getAnnotations returns notes overlapping since..until, oldest first. With
+// device IDs it returns theirs and the fleet-wide ones; nil returns every note.
+func (s *Store) getAnnotations(ctx context.Context, deviceIDs []string, since, until time.Time) []Annotation {
+	cond, args := deviceFilter(deviceIDs)
+	if deviceIDs != nil {
+		cond = "(device_id = '' OR " + cond + ")"
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, device_id, start_time, end_time, note, created_at
+		FROM annotations
+		WHERE `+cond+` AND end_time >= ? AND start_time <= ?
+		ORDER BY start_time, id
+	`, append(args, since.Format("2006-01-02 15:04:05"), until.Format("2006-01-02 15:04:05"))...)
+	if err != nil {
+		log.Printf("Error loading annotations: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var notes []Annotation
+	for rows.Next() {
+		var a Annotation
+		var start, end, created string
+		if err := rows.Scan(&a.ID, &a.DeviceID, &start, &end, &a.Note, &created); err != nil {
+			log.Printf("Error scanning annotation: %v", err)
+			continue
+		}
+		a.Start, a.End, a.CreatedAt = parseDBTime(start), parseDBTime(end), parseDBTime(created)
+		notes = append(notes, a)
+	}
+	return notes
+}
+
+// parseFormTime accepts RFC 3339, or a date or datetime-local form value
+// ("2024-05-01", "2024-05-01T18:30") read in loc
+func parseFormTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(time.Local), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.In(time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't read time %q", s)
+}
+
+// annotationFromForm reads start, end (optional, defaults to start) and note
+// for deviceID. Times without a zone are read in loc.
+func annotationFromForm(r *http.Request, deviceID string, loc *time.Location) (Annotation, error) {
+	a := Annotation{DeviceID: deviceID, Note: strings.TrimSpace(r.FormValue("note"))}
+	if a.Note == "" {
+		return a, errors.New("note required")
+	}
+	var err error
+	if a.Start, err = parseFormTime(r.FormValue("start"), loc); err != nil {
+		return a, err
+	}
+	a.End = a.Start
+	if end := r.FormValue("end"); strings.TrimSpace(end) != "" {
+		if a.End, err = parseFormTime(end, loc); err != nil {
+			return a, err
+		}
+	}
+	if a.End.Before(a.Start) {
+		return a, errors.New("end is before start")
+	}
+	return a, nil
+}
+
+// handleAPIAnnotations lists, adds and deletes notes:
+// GET /api/annotations?device=ID&days=30, POST device, start, end, note,
+// DELETE /api/annotations?id=N
+func handleAPIAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodPost:
+		// Times without a zone are in the detector's timezone
+		deviceID, loc := strings.TrimSpace(r.FormValue("device")), time.Local
+		if deviceID != "" {
+			loc = store.getDevice(ctx, deviceID).Location()
+		}
+		a, err := annotationFromForm(r, deviceID, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if a.ID, err = store.addAnnotation(ctx, a); err != nil {
+			log.Printf("Error saving annotation: %v", err)
+			http.Error(w, "Failed to save annotation", http.StatusInternalServerError)
+			return
+		}
+		a.CreatedAt = timeNow()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+		return
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id required", http.StatusBadRequest)
+			return
+		}
+		found, err := store.deleteAnnotation(ctx, id)
+		if err != nil {
+			log.Printf("Error deleting annotation: %v", err)
+			http.Error(w, "Failed to delete annotation", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Unknown annotation", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": id})
+		return
+	}
+
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	var deviceIDs []string
+	if device := r.URL.Query().Get("device"); device != "" {
+		deviceIDs = []string{device}
+	}
+	notes := store.getAnnotations(ctx, deviceIDs, timeNow().AddDate(0, 0, -days), timeNow())
+	if notes == nil {
+		notes = []Annotation{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// annotationWhen formats a note's time or range
+func annotationWhen(a Annotation) string {
+	when := a.Start.Format("Jan 2 15:04")
+	if !a.End.Equal(a.Start) {
+		when += " – " + a.End.Format("Jan 2 15:04")
+	}
+	return when
+}
+
+// annotationLabel is the chart tooltip for a note
+func annotationLabel(a Annotation) string {
+	when := annotationWhen(a)
+	if a.DeviceID != "" {
+		when += " · " + a.DeviceID
+	}
+	return when + ": " + a.Note
+}
+
+// renderAnnotationBands shades each note's range on a chart whose x axis runs
+// from t0 over span seconds. Instants get a thin line.
+func renderAnnotationBands(w io.Writer, notes []Annotation, t0 time.Time, span float64, width, height int) {
+	for _, a := range notes {
+		x0 := a.Start.Sub(t0).Seconds() / span * float64(width)
+		x1 := a.End.Sub(t0).Seconds() / span * float64(width)
+		x0, x1 = max(x0, 0), min(x1, float64(width))
+		if x1 < x0 {
+			continue
+		}
+		color := a.Color
+		if color == "" {
+			color = annotationColor
+		}
+		fmt.Fprintf(w, `<rect x="%.1f" y="0" width="%.1f" height="%d" fill="%s" fill-opacity="0.35"><title>%s</title></rect>`,
+			x0, max(x1-x0, float64(width)/240), height, color, html.EscapeString(annotationLabel(a)))
+	}
+}
+
+// renderAnnotationStrip writes a thin chart row marking notes between from and
+// to, for placing under bar charts with the same time axis
+func renderAnnotationStrip(w io.Writer, notes []Annotation, from, to time.Time, width int) {
+	if len(notes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, `<svg class="chart" viewBox="0 0 %d 8" preserveAspectRatio="none" width="100%%" height="8">`, width)
+	renderAnnotationBands(w, notes, from, max(to.Sub(from).Seconds(), 1), width, 8)
+	fmt.Fprintf(w, `</svg>`)
+}
+
+// activitySpan is the time range ActivityBuckets.Days covers in loc
+func activitySpan(a ActivityBuckets, loc *time.Location) (time.Time, time.Time, bool) {
+	if len(a.Days) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	from, err1 := time.ParseInLocation("2006-01-02", a.Days[0], loc)
+	to, err2 := time.ParseInLocation("2006-01-02", a.Days[len(a.Days)-1], loc)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to.AddDate(0, 0, 1), true
+}
+
+// renderAnnotationList writes the device page's card listing notes, with
+// delete buttons and a form to add one unless the database is read-only
+func renderAnnotationList(w io.Writer, notes []Annotation, deviceID string, days int) {
+	action := fmt.Sprintf("/device?device=%s&amp;days=%d", url.QueryEscape(deviceID), days)
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>📝 Notes</h2>
+`)
+	if len(notes) == 0 {
+		fmt.Fprintf(w, `        <p class="chart-label">No notes in this period. Notes are shaded on the charts above.</p>
+`)
+	} else {
+		fmt.Fprintf(w, `        <table class="event-log">
+`)
+		for i := len(notes) - 1; i >= 0; i-- {
+			a := notes[i]
+			scope := "all detectors"
+			if a.DeviceID != "" {
+				scope = a.DeviceID
+			}
+			fmt.Fprintf(w, `            <tr><td class="timestamp">%s</td><td>%s</td><td>%s</td>`,
+				annotationWhen(a), html.EscapeString(scope), html.EscapeString(a.Note))
+			if !store.readOnly {
+				fmt.Fprintf(w, `<td><form method="post" action="%s"><input type="hidden" name="delete" value="%d"><button>Delete</button></form></td>`,
+					action, a.ID)
+			}
+			fmt.Fprintf(w, `</tr>
+`)
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	if !store.readOnly {
+		fmt.Fprintf(w, `        <form class="annotation-form" method="post" action="%s">
+            <input type="datetime-local" name="start" required>
+            <input type="datetime-local" name="end" title="End (optional)">
+            <input type="text" name="note" placeholder="e.g. moved antenna to roof" required>
+            <label><input type="checkbox" name="all" value="1"> all detectors</label>
+            <button>Add note</button>
+        </form>
+`, action)
+	}
+	fmt.Fprintf(w, `    </div>
+`)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Availability compares the uploads each detector sent with the uploads its
+// cadence calls for, per calendar day, week (from Monday) or month in the
+// detector's timezone. Raw uploads and rollups both count, so reports reach
+// as far back as the rollups do. The current interval is applied to the whole
+// report; periods before a detector's first upload aren't expected to have any.
+
+// availabilityPeriods are the period kinds with their default and maximum counts
+var availabilityPeriods = map[string]struct{ def, max int }{
+	"day":   {14, 366},
+	"week":  {8, 104},
+	"month": {6, 36},
+}
+
+// AvailabilityPeriod is one detector's uploads over one period
+type AvailabilityPeriod struct {
+	Start           time.Time `json:"start"`
+	Label           string    `json:"label"`
+	Received        int       `json:"received"`
+	Expected        float64   `json:"expected"`
+	AvailabilityPct *float64  `json:"availability_pct"` // nil when no uploads were expected
+}
+
+// Availability is one detector's report
+type Availability struct {
+	DeviceID            string               `json:"device_id"`
+	ExpectedIntervalSec int                  `json:"expected_interval_sec"`
+	Configured          bool                 `json:"configured"`
+	Timezone            string               `json:"timezone"`
+	Received            int                  `json:"received"`
+	Expected            float64              `json:"expected"`
+	AvailabilityPct     *float64             `json:"availability_pct"`
+	Periods             []AvailabilityPeriod `json:"periods"` // Oldest first
+}
+
+// periodStarts returns the starts of the last n periods in now's timezone,
+// oldest first, followed by the start of the next one
+func periodStarts(kind string, n int, now time.Time) []time.Time {
+	y, m, d := now.Date()
+	var start time.Time
+	var step func(time.Time, int) time.Time
+	switch kind {
+	case "week":
+		start = time.Date(y, m, d-(int(now.Weekday())+6)%7, 0, 0, 0, 0, now.Location())
+		step = func(t time.Time, i int) time.Time { return t.AddDate(0, 0, 7*i) }
+	case "month":
+		start = time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+		step = func(t time.Time, i int) time.Time { return t.AddDate(0, i, 0) }
+	default:
+		start = time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+		step = func(t time.Time, i int) time.Time { return t.AddDate(0, 0, i) }
+	}
+	starts := make([]time.Time, n+1)
+	for i := range starts {
+		starts[i] = step(start, i-n+1)
+	}
+	return starts
+}
+
+// periodLabel names a period for tables
+func periodLabel(kind string, start time.Time) string {
+	switch kind {
+	case "week":
+		return start.Format("Jan 2") + " wk"
+	case "month":
+		return start.Format("Jan 2006")
+	}
+	return start.Format("Mon Jan 2")
+}
+
+// availabilityPct is received as a share of expected, capped at 100
+func availabilityPct(received int, expected float64) *float64 {
+	if expected <= 0 {
+		return nil
+	}
+	pct := math.Round(1000*min(float64(received)/expected, 1)) / 10
+	return &pct
+}
+
+// firstUpload returns when a detector was first heard from, in any tier
+func (s *Store) firstUpload(ctx context.Context, deviceID string) (time.Time, bool) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var first sql.NullString
+	s.queryRow(ctx, `
+		SELECT MIN(t) FROM (
+			SELECT MIN(timestamp) AS t FROM uploads WHERE device_id = ?
+			UNION ALL SELECT MIN(period) FROM upload_rollups WHERE device_id = ?
+		)
+	`, deviceID, deviceID).Scan(&first)
+	if !first.Valid {
+		return time.Time{}, false
+	}
+	return parseDBTime(first.String), true
+}
+
+// getAvailability reports one detector over the last n periods
+func (s *Store) getAvailability(ctx context.Context, device DeviceInfo, kind string, n int) Availability {
+	loc := device.Location()
+	c := device.Cadence()
+	a := Availability{
+		DeviceID: device.DeviceID, Timezone: locationName(loc), Configured: c.Configured,
+		ExpectedIntervalSec: int(c.Interval / time.Second),
+	}
+	now := timeNow()
+	starts := periodStarts(kind, n, now.In(loc))
+	for _, start := range starts[:n] {
+		a.Periods = append(a.Periods, AvailabilityPeriod{Start: start, Label: periodLabel(kind, start)})
+	}
+
+	first, ok := s.firstUpload(ctx, device.DeviceID)
+	if !ok {
+		return a
+	}
+	query, args := uploadsSince(starts[0].In(time.Local).Format("2006-01-02 15:04:05"), []string{device.DeviceID})
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT timestamp, uploads FROM (`+query+`)`, args...)
+	if err != nil {
+		log.Printf("Error loading availability: %v", err)
+		return a
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts string
+		var uploads int
+		if rows.Scan(&ts, &uploads) != nil {
+			continue
+		}
+		t := parseDBTime(ts)
+		i := sort.Search(n, func(i int) bool { return starts[i+1].After(t) })
+		if i < n && !t.Before(starts[0]) {
+			a.Periods[i].Received += uploads
+		}
+	}
+
+	for i := range a.Periods {
+		from, to := starts[i], starts[i+1]
+		if first.After(from) {
+			from = first
+		}
+		if now.Before(to) {
+			to = now
+		}
+		if to.After(from) {
+			a.Periods[i].Expected = math.Round(10*to.Sub(from).Seconds()/c.Interval.Seconds()) / 10
+		}
+		a.Periods[i].AvailabilityPct = availabilityPct(a.Periods[i].Received, a.Periods[i].Expected)
+		a.Received += a.Periods[i].Received
+		a.Expected += a.Periods[i].Expected
+	}
+	a.Expected = math.Round(10*a.Expected) / 10
+	a.AvailabilityPct = availabilityPct(a.Received, a.Expected)
+	return a
+}
+
+// availabilityQuery reads period, count, and device or group; it returns the
+// devices to report on, every known detector by default
+func availabilityQuery(ctx context.Context, r *http.Request) (kind string, n int, devices []DeviceInfo, err error) {
+	q := r.URL.Query()
+	kind = q.Get("period")
+	if kind == "" {
+		kind = "day"
+	}
+	limits, ok := availabilityPeriods[kind]
+	if !ok {
+		return "", 0, nil, errors.New("period must be day, week or month")
+	}
+	n = limits.def
+	if v, err := strconv.Atoi(q.Get("count")); err == nil && v > 0 {
+		n = min(v, limits.max)
+	}
+
+	var ids []string
+	if device := q.Get("device"); device != "" {
+		ids = []string{device}
+	} else if group := q.Get("group"); group != "" {
+		if ids = store.getGroupDevices(ctx, group); ids == nil {
+			return "", 0, nil, errors.New("unknown group")
+		}
+	} else {
+		for id := range store.latestStats() {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	for _, id := range ids {
+		devices = append(devices, store.getDevice(ctx, id))
+	}
+	return kind, n, devices, nil
+}
+
+// handleAPIAvailability returns availability reports:
+// GET /api/availability?period=day|week|month&count=N&device=ID (or group=NAME)
+func handleAPIAvailability(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	kind, n, devices, err := availabilityQuery(ctx, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := []Availability{}
+	for _, d := range devices {
+		out = append(out, store.getAvailability(ctx, d, kind, n))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// availabilityClass colours a percentage cell
+func availabilityClass(pct *float64) string {
+	switch {
+	case pct == nil:
+		return "avail-none"
+	case *pct >= 99:
+		return "avail-good"
+	case *pct >= 95:
+		return "avail-fair"
+	}
+	return "avail-poor"
+}
+
+// availabilityCell formats a percentage cell
+func availabilityCell(pct *float64, received int, expected float64) string {
+	if pct == nil {
+		return fmt.Sprintf(`<td class="%s">–</td>`, availabilityClass(pct))
+	}
+	return fmt.Sprintf(`<td class="%s" title="%d of %.1f expected uploads">%.1f%%</td>`,
+		availabilityClass(pct), received, expected, *pct)
+}
+
+// handleAvailability renders the availability report: GET /availability?period=week
+func handleAvailability(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	kind, n, devices, err := availabilityQuery(ctx, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reports := make([]Availability, 0, len(devices))
+	for _, d := range devices {
+		reports = append(reports, store.getAvailability(ctx, d, kind, n))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Detector Availability", `    <style>
+        .avail-table { border-collapse: collapse; font-size: 0.85em; }
+        .avail-table th, .avail-table td { padding: 4px 8px; text-align: right; white-space: nowrap; }
+        .avail-table th:first-child, .avail-table td:first-child { text-align: left; }
+        .avail-good { color: #4caf50; }
+        .avail-fair { color: #ffc107; }
+        .avail-poor { color: #f44336; font-weight: bold; }
+        .avail-none { color: #555; }
+        .period-links a { color: #00d4ff; margin-right: 10px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>📈 Detector Availability</h1>
+    <p class="subtitle">Uploads received vs expected from each detector's upload interval · last %d %ss</p>
+    <p class="period-links">`, n, kind)
+	filter := url.Values{}
+	for _, k := range []string{"device", "group"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			filter.Set(k, v)
+		}
+	}
+	for _, k := range []string{"day", "week", "month"} {
+		filter.Set("period", k)
+		fmt.Fprintf(w, `<a href="/availability?%s">%s</a>`, html.EscapeString(filter.Encode()), k)
+	}
+	fmt.Fprintf(w, `</p>
+    <div class="card" style="overflow-x: auto;">
+`)
+	if len(reports) == 0 {
+		fmt.Fprintf(w, `        <div class="no-data"><p>No detectors yet.</p></div>
+`)
+	} else {
+		fmt.Fprintf(w, `        <table class="avail-table">
+            <tr><th>Detector</th><th>Interval</th>`)
+		for _, p := range reports[0].Periods {
+			fmt.Fprintf(w, `<th>%s</th>`, html.EscapeString(p.Label))
+		}
+		fmt.Fprintf(w, `<th>Overall</th></tr>
+`)
+		for _, a := range reports {
+			interval := (time.Duration(a.ExpectedIntervalSec) * time.Second).String()
+			if !a.Configured {
+				interval += "*"
+			}
+			fmt.Fprintf(w, `            <tr><td><a class="device-id" href="/device?device=%s">%s</a></td><td>%s</td>`,
+				url.QueryEscape(a.DeviceID), html.EscapeString(a.DeviceID), interval)
+			for _, p := range a.Periods {
+				fmt.Fprint(w, availabilityCell(p.AvailabilityPct, p.Received, p.Expected))
+			}
+			fmt.Fprintf(w, "%s</tr>\n", availabilityCell(a.AvailabilityPct, a.Received, a.Expected))
+		}
+		fmt.Fprintf(w, `        </table>
+        <p class="chart-label">* No upload interval set; expected every OFFLINE_AFTER_MIN. Hover a cell for upload counts. <a href="/api/availability?%s" style="color: #00d4ff;">JSON</a></p>
+`, html.EscapeString(r.URL.RawQuery))
+	}
+	fmt.Fprintf(w, `    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
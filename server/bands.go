@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BandInfo is a radio band a detector can be built for. The frequency plan can
+// mix bands (a dual-radio detector, or SX1262 and SX1280 detectors sharing a
+// server); frequency tables are then grouped by band.
+type BandInfo struct {
+	Name           string          // Used by the admin editor
+	Title          string          // Display name
+	MinMHz, MaxMHz float64         // Channels in this range belong to the band
+	DutyCycle      float64         // Regulatory duty-cycle limit in percent; 0 for none
+	Profile        []FrequencyInfo // Suggested channels, added from the admin editor
+}
+
+// band24Frequencies are the 2.4 GHz channels Semtech's SX1280 reference
+// designs use; 2.4 GHz LoRa has no regional channel plan, so these are a start
+var band24Frequencies = []FrequencyInfo{
+	{"2403", "LoRa 2.4 Ch0", "lora24", "SX1280 sensors, 2.4 GHz LoRaWAN", "#E040FB"},
+	{"2425", "LoRa 2.4 Ch1", "lora24", "Long-range 2.4 GHz telemetry", "#BA68C8"},
+	{"2479", "LoRa 2.4 Ch2", "lora24", "Ranging tags, RC links", "#9C27B0"},
+}
+
+// band433Frequencies and band868Frequencies are where OOK/FSK gadgets
+// cluster, for firmware builds that also sniff non-LoRa modulations
+var band433Frequencies = []FrequencyInfo{
+	{"433.92", "OOK 433.92", "remote", "Doorbells, remotes, tire sensors", "#F06292"},
+}
+
+var band868Frequencies = []FrequencyInfo{
+	{"868.3", "FSK 868.3", "weather", "Weather stations, home sensors", "#FFD54F"},
+}
+
+// bandInfo lists the bands in dashboard order, lowest first. Duty-cycle limits
+// are ETSI EN 300 220's for 433.05-434.79 MHz and the 868.0-868.6 MHz sub-band
+// LoRaWAN uses; the US 900 MHz band and 2.4 GHz limit dwell time instead.
+var bandInfo = []BandInfo{
+	{Name: "433", Title: "433 MHz", MinMHz: 433.05, MaxMHz: 434.79, DutyCycle: 10, Profile: band433Frequencies},
+	{Name: "868", Title: "868 MHz", MinMHz: 863, MaxMHz: 870, DutyCycle: 1, Profile: band868Frequencies},
+	{Name: "900", Title: "900 MHz", MinMHz: 902, MaxMHz: 928, Profile: defaultFrequencies},
+	{Name: "2400", Title: "2.4 GHz", MinMHz: 2400, MaxMHz: 2483.5, Profile: band24Frequencies},
+}
+
+// otherBand holds channels outside every known band
+var otherBand = BandInfo{Name: "other", Title: "Other"}
+
+// band returns the band a channel is in
+func (f FrequencyInfo) band() BandInfo {
+	mhz, err := strconv.ParseFloat(f.MHz, 64)
+	if err != nil {
+		return otherBand
+	}
+	for _, b := range bandInfo {
+		if mhz >= b.MinMHz && mhz <= b.MaxMHz {
+			return b
+		}
+	}
+	return otherBand
+}
+
+// bandOrder is the channel's band's position in bandInfo, other bands last
+func (f FrequencyInfo) bandOrder() int {
+	b := f.band()
+	if i := slices.IndexFunc(bandInfo, func(c BandInfo) bool { return c.Name == b.Name }); i >= 0 {
+		return i
+	}
+	return len(bandInfo)
+}
+
+// bandByName looks up a band
+func bandByName(name string) (BandInfo, bool) {
+	i := slices.IndexFunc(bandInfo, func(b BandInfo) bool { return b.Name == name })
+	if i < 0 {
+		return BandInfo{}, false
+	}
+	return bandInfo[i], true
+}
+
+// planBands returns the bands the frequency plan scans, in dashboard order
+func planBands() []BandInfo {
+	var bands []BandInfo
+	for _, b := range append(slices.Clone(bandInfo), otherBand) {
+		if slices.ContainsFunc(frequencies(), func(f FrequencyInfo) bool { return f.Scanned() && f.band().Name == b.Name }) {
+			bands = append(bands, b)
+		}
+	}
+	return bands
+}
+
+// addBandProfile appends a band's channels that aren't already in the plan
+func addBandProfile(plan []FrequencyInfo, b BandInfo) []FrequencyInfo {
+	plan = slices.Clone(plan)
+	for _, f := range b.Profile {
+		if !slices.ContainsFunc(plan, func(g FrequencyInfo) bool { return g.MHz == f.MHz }) {
+			plan = append(plan, f)
+		}
+	}
+	return plan
+}
+
+// bandSubtitle is the dashboard subtitle for the bands the plan scans
+func bandSubtitle(l Lang) string {
+	var names, titles []string
+	for _, b := range planBands() {
+		names = append(names, b.Name)
+		titles = append(titles, l.T(b.Title))
+	}
+	switch strings.Join(names, ",") {
+	case "", "900":
+		return l.T("900 MHz ISM Band Activity Monitor")
+	case "900,2400":
+		return l.T("900 MHz and 2.4 GHz LoRa Activity Monitor")
+	case "2400":
+		return l.T("2.4 GHz ISM Band Activity Monitor")
+	}
+	return l.Tf("ISM Band Activity Monitor: %s", strings.Join(titles, ", "))
+}
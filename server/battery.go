@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Battery levels, from least to most urgent
+const (
+	batteryOK       = "ok"
+	batteryLow      = "low"
+	batteryCritical = "critical"
+)
+
+// batteryTrendWindow is how far back to look when projecting time to critical
+const batteryTrendWindow = 24 * time.Hour
+
+// defaultBatteryThresholds returns the server-wide low and critical voltages.
+// The defaults suit a single Li-ion cell.
+func defaultBatteryThresholds() (low, critical float64) {
+	low, critical = 3.5, 3.3
+	if v, err := strconv.ParseFloat(os.Getenv("BATTERY_LOW_V"), 64); err == nil && v > 0 {
+		low = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("BATTERY_CRITICAL_V"), 64); err == nil && v > 0 {
+		critical = v
+	}
+	return low, critical
+}
+
+// BatteryThresholds returns the device's voltages, falling back to the server defaults
+func (d DeviceInfo) BatteryThresholds() (low, critical float64) {
+	low, critical = defaultBatteryThresholds()
+	if d.BatteryLowV != nil {
+		low = *d.BatteryLowV
+	}
+	if d.BatteryCriticalV != nil {
+		critical = *d.BatteryCriticalV
+	}
+	return low, critical
+}
+
+// BatteryStatus is a device's latest battery reading judged against its thresholds
+type BatteryStatus struct {
+	Voltage         float64   `json:"voltage"`
+	Time            time.Time `json:"time"`
+	Level           string    `json:"level"`
+	LowV            float64   `json:"low_v"`
+	CriticalV       float64   `json:"critical_v"`
+	HoursToCritical *float64  `json:"hours_to_critical,omitempty"` // Linear projection from the last day, once low
+}
+
+// getBatteryStatus judges the most recent battery reading; ok is false if the
+// device hasn't sent one in the last two days. It runs on every upload, so the
+// trend is only fitted once the battery is low, and in SQL rather than by
+// loading the history.
+func (s *Store) getBatteryStatus(ctx context.Context, deviceID string) (BatteryStatus, bool) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var ts string
+	var st BatteryStatus
+	err := s.queryRow(ctx, `
+		SELECT timestamp, battery_v FROM device_health
+		WHERE device_id = ? AND timestamp > ? AND battery_v IS NOT NULL
+		ORDER BY timestamp DESC LIMIT 1
+	`, deviceID, dbTimeAgo(2*batteryTrendWindow)).Scan(&ts, &st.Voltage)
+	if err != nil {
+		return BatteryStatus{}, false
+	}
+	st.Time = parseDBTime(ts)
+	st.LowV, st.CriticalV = s.getDevice(ctx, deviceID).BatteryThresholds()
+	switch {
+	case st.Voltage <= st.CriticalV:
+		st.Level = batteryCritical
+	case st.Voltage <= st.LowV:
+		st.Level = batteryLow
+	default:
+		st.Level = batteryOK
+	}
+	if st.Level != batteryLow {
+		return st, true
+	}
+
+	// Least-squares slope over the trend window, x in hours before the latest
+	// reading; solar detectors charge by day, so only a net decline over the
+	// window produces a projection
+	var n, sx, sy, sxx, sxy float64
+	err = s.queryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(x), 0), COALESCE(SUM(v), 0), COALESCE(SUM(x * x), 0), COALESCE(SUM(x * v), 0)
+		FROM (
+			SELECT (julianday(timestamp) - julianday(?)) * 24 AS x, battery_v AS v
+			FROM device_health
+			WHERE device_id = ? AND timestamp >= ? AND battery_v IS NOT NULL
+		)
+	`, ts, deviceID, st.Time.Add(-batteryTrendWindow).Format("2006-01-02 15:04:05")).Scan(&n, &sx, &sy, &sxx, &sxy)
+	if err != nil {
+		log.Printf("Error computing battery trend for %s: %v", deviceID, err)
+		return st, true
+	}
+	if n >= 3 && sxx*n-sx*sx > 0 {
+		slope := (n*sxy - sx*sy) / (n*sxx - sx*sx) // volts per hour
+		if slope < 0 {
+			h := (st.Voltage - st.CriticalV) / -slope
+			st.HoursToCritical = &h
+		}
+	}
+	return st, true
+}
+
+// checkBattery raises an alert when a fresh reading crosses a device's thresholds
+func (s *Store) checkBattery(ctx context.Context, deviceID string) {
+	st, ok := s.getBatteryStatus(ctx, deviceID)
+	if !ok {
+		return
+	}
+	switch st.Level {
+	case batteryCritical:
+		s.raiseAlert(ctx, deviceID, "battery_critical", fmt.Sprintf("Battery critical: %.2f V (threshold %.2f V)", st.Voltage, st.CriticalV))
+	case batteryLow:
+		msg := fmt.Sprintf("Battery low: %.2f V (threshold %.2f V)", st.Voltage, st.LowV)
+		if st.HoursToCritical != nil {
+			msg += ", critical " + defaultLang.Hours(*st.HoursToCritical)
+		}
+		s.raiseAlert(ctx, deviceID, "battery_low", msg)
+	}
+	if st.Level != batteryOK {
+		log.Printf("  %s battery %s: %.2f V", deviceID, st.Level, st.Voltage)
+	}
+}
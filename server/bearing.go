@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// bearingSectors is how many slices the compass rose is divided into
+const bearingSectors = 16
+
+// BearingProfile holds detections per compass sector for each frequency
+type BearingProfile struct {
+	DeviceID   string  `json:"device_id"`
+	SectorDeg  float64 `json:"sector_degrees"`
+	Detections [][]int `json:"detections"` // [frequency][sector]
+	Uploads    int     `json:"uploads"`
+}
+
+// normalizeBearing wraps a heading into [0, 360)
+func normalizeBearing(b float64) float64 {
+	b = math.Mod(b, 360)
+	if b < 0 {
+		b += 360
+	}
+	return b
+}
+
+// bearingSector maps a heading onto a sector index, with sector 0 centred on north
+func bearingSector(b float64) int {
+	width := 360.0 / bearingSectors
+	return int(normalizeBearing(b+width/2)/width) % bearingSectors
+}
+
+// getBearingProfile attributes detections to the antenna heading they were heard on.
+// Firmware counters are cumulative per boot, so each upload contributes the
+// increase since the previous upload of the same session.
+func (s *Store) getBearingProfile(ctx context.Context, deviceID string, days int) BearingProfile {
+	profile := BearingProfile{
+		DeviceID:   deviceID,
+		SectorDeg:  360.0 / bearingSectors,
+		Detections: make([][]int, len(frequencies())),
+	}
+	for i := range profile.Detections {
+		profile.Detections[i] = make([]int, bearingSectors)
+	}
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT uptime_seconds, freqs, bearing
+		FROM uploads
+		WHERE device_id = ? AND timestamp > ?
+		ORDER BY timestamp, id
+	`, deviceID, dbTimeAgo(time.Duration(days)*24*time.Hour))
+	if err != nil {
+		log.Printf("Error loading bearing data: %v", err)
+		return profile
+	}
+	defer rows.Close()
+
+	prevUptime := -1
+	prev := make([]int, len(profile.Detections))
+	for rows.Next() {
+		var uptime int
+		var freqs string
+		var bearing sql.NullFloat64
+		if err := rows.Scan(&uptime, &freqs, &bearing); err != nil {
+			continue
+		}
+		f := fitCounts(parseCounts(freqs), len(prev))
+
+		sameSession := prevUptime >= 0 && uptime >= prevUptime
+		if bearing.Valid {
+			sector := bearingSector(bearing.Float64)
+			for i := range f {
+				delta := f[i]
+				if sameSession {
+					delta = f[i] - prev[i]
+				}
+				if delta > 0 {
+					profile.Detections[i][sector] += delta
+				}
+			}
+			profile.Uploads++
+		}
+		prevUptime = uptime
+		copy(prev, f)
+	}
+	return profile
+}
+
+// handleAPIBearing returns detections by bearing: GET /api/bearing?device=ID&days=30
+func handleAPIBearing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.getBearingProfile(ctx, deviceID, days))
+}
+
+// handleBearing renders polar plots of detections by bearing: GET /bearing?device=ID&days=30
+func handleBearing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	profile := store.getBearingProfile(ctx, deviceID, days)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Detections by Bearing", `    <style>
+        .polar-grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(200px, 1fr)); gap: 20px; }
+        .polar-cell { text-align: center; }
+        .polar-cell .freq-mhz { display: block; margin-top: 5px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🧭 Detections by Bearing</h1>
+    <p class="subtitle"><span class="device-id">%s</span> · last %d days · %d uploads with a heading</p>
+    <div class="card">
+`, html.EscapeString(deviceID), days, profile.Uploads)
+
+	if profile.Uploads == 0 {
+		fmt.Fprintf(w, `        <div class="no-data"><p>No uploads from this detector included <code>bearing_deg</code>.</p></div>
+`)
+	} else {
+		fmt.Fprintf(w, `        <div class="polar-grid">
+`)
+		for i, freq := range frequencies() {
+			if !freq.Scanned() {
+				continue
+			}
+			fmt.Fprintf(w, `            <div class="polar-cell">`)
+			renderPolarPlot(w, profile.Detections[i], freq.Color, 180)
+			fmt.Fprintf(w, `<span class="freq-mhz">%s</span><span class="freq-label">%s</span></div>
+`, freq.MHz, freq.Label)
+		}
+		fmt.Fprintf(w, `        </div>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errorLog counts the errors that the store logs rather than returns
+type errorLog struct{ n atomic.Int64 }
+
+func (e *errorLog) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("Error")) {
+		e.n.Add(1)
+	}
+	return len(p), nil
+}
+
+// benchResult collects latencies for one kind of operation
+type benchResult struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    atomic.Int64
+}
+
+func (b *benchResult) record(d time.Duration, err error) {
+	if err != nil {
+		b.errors.Add(1)
+		return
+	}
+	b.mu.Lock()
+	b.latencies = append(b.latencies, d)
+	b.mu.Unlock()
+}
+
+// report prints throughput and latency percentiles over elapsed
+func (b *benchResult) report(name string, elapsed time.Duration) float64 {
+	sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+	n := len(b.latencies)
+	rate := float64(n) / elapsed.Seconds()
+	if n == 0 {
+		fmt.Printf("%-8s 0 ok, %d errors\n", name, b.errors.Load())
+		return 0
+	}
+	pct := func(p int) time.Duration { return b.latencies[min(n-1, n*p/100)].Round(time.Microsecond) }
+	fmt.Printf("%-8s %8.0f/s  %7d ok  %5d errors  p50 %-10s p99 %-10s max %s\n",
+		name, rate, n, b.errors.Load(), pct(50), pct(99), b.latencies[n-1].Round(time.Microsecond))
+	return rate
+}
+
+// runBench hammers the ingest path and the summary queries concurrently against
+// a scratch database, to size a deployment (see "Capacity" in CLAUDE.md)
+func runBench(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("bench", "")
+	dbPath := fs.String("db", "", "database to benchmark against (default: a fresh scratch file, deleted afterwards)")
+	devices := fs.Int("devices", 100, "distinct device IDs")
+	history := fs.Int("history", 30, "days of 5-minute history per device to seed before measuring")
+	writers := fs.Int("writers", 8, "concurrent uploaders")
+	readers := fs.Int("readers", 2, "concurrent dashboard readers running getSummary")
+	duration := fs.Duration("duration", 10*time.Second, "how long to measure")
+	full := fs.Bool("full", true, "run the whole ingest pipeline (acks, health, calibration), not just saveUpload")
+	cpuProfile := fs.String("cpuprofile", "", "write a CPU profile of the measured run to this file (go tool pprof)")
+	fs.Parse(args)
+
+	cfg := Config{DBPath: *dbPath}
+	if cfg.DBPath == "" {
+		dir, err := os.MkdirTemp("", "lora-bench-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		cfg.DBPath = filepath.Join(dir, "bench.db")
+	}
+	if _, err := openStore(cfg); err != nil {
+		return err
+	}
+	defer store.db.Close()
+
+	sims := make([]*simDevice, *devices)
+	for i := range sims {
+		sims[i] = newSimDevice(fmt.Sprintf("bench-%d", i+1), simMixed, uint64(i+1))
+	}
+	if *history > 0 {
+		start := time.Now()
+		n, err := simulateBackfill(sims, time.Duration(*history)*24*time.Hour, 5*time.Minute)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Seeded %d uploads in %s\n", n, time.Since(start).Round(time.Millisecond))
+	}
+
+	// ingestStats logs every upload; that would dominate the measurement
+	var logged errorLog
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	var writes, fleetReads, deviceReads benchResult
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(*duration)
+	for w := 0; w < *writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; time.Now().Before(deadline); i += *writers {
+				st := sims[i%len(sims)].step(time.Now(), time.Minute)
+				start := time.Now()
+				var err error
+				if *full {
+					_, err = ingestStats(ctx, &st)
+				} else {
+					err = store.saveUpload(ctx, st, nil)
+				}
+				writes.record(time.Since(start), err)
+			}
+		}()
+	}
+	for r := 0; r < *readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := r; time.Now().Before(deadline); i++ {
+				days := []int{1, 7, 30}[i%3]
+				start := time.Now()
+				if i%2 == 0 {
+					store.getSummary(ctx, days)
+					fleetReads.record(time.Since(start), nil)
+				} else {
+					store.getSummary(ctx, days, sims[i%len(sims)].id)
+					deviceReads.record(time.Since(start), nil)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Printf("\n%d devices, %d writers, %d readers, %s\n", *devices, *writers, *readers, *duration)
+	rate := writes.report("uploads", *duration)
+	fleetReads.report("summary", *duration)
+	deviceReads.report("device", *duration)
+	if n := logged.n.Load(); n > 0 {
+		fmt.Printf("%d errors logged by the store (database locked?)\n", n)
+	}
+	fmt.Printf("\nSustains about %.0f detectors uploading once a minute\n", rate*60)
+	return nil
+}
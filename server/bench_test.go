@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The benchmarks measure the store alone against a scratch database seeded
+// with benchHistory of 5-minute uploads from benchDevices detectors; the bench
+// command runs the full pipeline with writers and readers at once. Run them
+// with go test -run '^$' -bench . -cpu 1,4 on the hardware being sized.
+const (
+	benchDevices = 20
+	benchHistory = 7 * 24 * time.Hour
+)
+
+// benchStore opens and seeds the scratch database, returning the simulated
+// detectors. The store's logging is discarded.
+func benchStore(b *testing.B) []*simDevice {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if _, err := openStore(Config{DBPath: filepath.Join(b.TempDir(), "bench.db")}); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { store.db.Close() })
+	sims := make([]*simDevice, benchDevices)
+	for i := range sims {
+		sims[i] = newSimDevice(fmt.Sprintf("bench-%d", i+1), simMixed, uint64(i+1))
+	}
+	if _, err := simulateBackfill(sims, benchHistory, 5*time.Minute); err != nil {
+		b.Fatal(err)
+	}
+	return sims
+}
+
+func BenchmarkSaveUpload(b *testing.B) {
+	benchStore(b)
+	ctx := context.Background()
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// A detector per goroutine, since a simDevice isn't safe to share
+		n := next.Add(1)
+		sim := newSimDevice(fmt.Sprintf("bench-writer-%d", n), simMixed, n)
+		for pb.Next() {
+			if err := store.saveUpload(ctx, sim.step(time.Now(), time.Minute), nil); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkGetSummary(b *testing.B) {
+	sims := benchStore(b)
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name  string
+		days  int
+		fleet bool
+	}{{"device-1d", 1, false}, {"device-7d", 7, false}, {"fleet-1d", 1, true}, {"fleet-7d", 7, true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			var next atomic.Uint64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if bc.fleet {
+						store.getSummary(ctx, bc.days)
+					} else {
+						store.getSummary(ctx, bc.days, sims[next.Add(1)%uint64(len(sims))].id)
+					}
+				}
+			})
+		})
+	}
+}
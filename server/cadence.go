@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A detector can be given the upload interval it's expected to keep. It's then
+// offline once it has missed uploadMissesOffline uploads, and any longer
+// silence between uploads is a gap that counts against its uptime. Detectors
+// without one are expected to upload at least every OFFLINE_AFTER_MIN.
+const (
+	uploadMissesOffline = 3
+	uptimeMaxGaps       = 200 // Newest gaps returned by getUptime
+	maxUploadInterval   = 7 * 24 * 60 * 60
+)
+
+// Cadence is how often a detector is expected to upload
+type Cadence struct {
+	Interval     time.Duration // Expected time between uploads
+	OfflineAfter time.Duration // Silence before the detector counts as offline
+	Configured   bool          // Set for this device rather than defaulted
+}
+
+// defaultCadence applies to detectors without an upload interval
+func defaultCadence() Cadence {
+	return Cadence{Interval: offlineAfter(), OfflineAfter: offlineAfter()}
+}
+
+// Cadence returns the device's expected upload cadence
+func (d DeviceInfo) Cadence() Cadence {
+	if d.UploadIntervalSec == nil {
+		return defaultCadence()
+	}
+	interval := time.Duration(*d.UploadIntervalSec) * time.Second
+	return Cadence{Interval: interval, OfflineAfter: uploadMissesOffline * interval, Configured: true}
+}
+
+// Offline reports whether a detector last heard from at last has gone silent
+func (c Cadence) Offline(last, now time.Time) bool {
+	return now.Sub(last) > c.OfflineAfter
+}
+
+// byLastHeard orders detectors by their latest upload, most recent first, so
+// the ones still reporting lead and the silent ones sink
+func byLastHeard(latest map[string]Stats) []string {
+	ids := make([]string, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := latest[ids[i]].Timestamp, latest[ids[j]].Timestamp
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// cadences maps device IDs to their cadence
+type cadences map[string]Cadence
+
+// of returns a device's cadence, the default for devices with no metadata
+func (c cadences) of(deviceID string) Cadence {
+	if v, ok := c[deviceID]; ok {
+		return v
+	}
+	return defaultCadence()
+}
+
+// getCadences loads every device's configured cadence
+func (s *Store) getCadences(ctx context.Context) cadences {
+	c := make(cadences)
+	for _, d := range s.getDevices(ctx) {
+		c[d.DeviceID] = d.Cadence()
+	}
+	return c
+}
+
+// setUploadInterval records how often a detector should upload; 0 clears it
+func (s *Store) setUploadInterval(ctx context.Context, deviceID string, seconds int) error {
+	var value interface{}
+	if seconds > 0 {
+		value = seconds
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, upload_interval_sec) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET upload_interval_sec = excluded.upload_interval_sec
+	`, deviceID, value)
+	return err
+}
+
+// parseUploadInterval reads an interval in seconds; empty or 0 clears it
+func parseUploadInterval(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > maxUploadInterval {
+		return 0, fmt.Errorf("upload_interval_sec must be 0-%d seconds", maxUploadInterval)
+	}
+	return n, nil
+}
+
+// UploadGap is a silence longer than the device's offline threshold.
+// End is nil while the gap is ongoing.
+type UploadGap struct {
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+	Seconds int64      `json:"seconds"`
+}
+
+// Uptime is how well a detector kept to its cadence over a period
+type Uptime struct {
+	DeviceID            string      `json:"device_id"`
+	Days                int         `json:"days"`
+	ExpectedIntervalSec int         `json:"expected_interval_sec"`
+	OfflineAfterSec     int         `json:"offline_after_sec"`
+	Configured          bool        `json:"configured"`
+	Since               *time.Time  `json:"since,omitempty"` // Start of the measured period, nil without uploads
+	Uploads             int         `json:"uploads"`
+	ExpectedUploads     int         `json:"expected_uploads"`
+	UptimePct           float64     `json:"uptime_pct"`
+	DowntimeSec         int64       `json:"downtime_sec"`
+	Online              bool        `json:"online"`
+	Gaps                []UploadGap `json:"gaps"` // Newest first
+}
+
+// getUptime measures a detector's raw uploads over the last days against its
+// cadence. The period starts at its first upload if that's more recent. Time
+// past an expected upload counts as down once a silence exceeds the offline
+// threshold, including a silence that's still going on.
+func (s *Store) getUptime(ctx context.Context, deviceID string, c Cadence, days int) Uptime {
+	now := timeNow()
+	since := now.AddDate(0, 0, -days)
+	u := Uptime{
+		DeviceID: deviceID, Days: days, Configured: c.Configured, Gaps: []UploadGap{},
+		ExpectedIntervalSec: int(c.Interval / time.Second), OfflineAfterSec: int(c.OfflineAfter / time.Second),
+	}
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var times []time.Time
+	var prev sql.NullString
+	s.queryRow(ctx, `SELECT MAX(timestamp) FROM uploads WHERE device_id = ? AND timestamp < ?`,
+		deviceID, since.Format("2006-01-02 15:04:05")).Scan(&prev)
+	if prev.Valid {
+		times = append(times, parseDBTime(prev.String))
+	}
+	rows, err := s.query(ctx, `SELECT timestamp FROM uploads WHERE device_id = ? AND timestamp >= ? ORDER BY timestamp`,
+		deviceID, since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading upload times: %v", err)
+		return u
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts string
+		if rows.Scan(&ts) == nil {
+			times = append(times, parseDBTime(ts))
+			u.Uploads++
+		}
+	}
+	if len(times) == 0 {
+		return u
+	}
+
+	start := since
+	if !prev.Valid {
+		start = times[0]
+	}
+	u.Since = &start
+	u.Online = !c.Offline(times[len(times)-1], now)
+	var down time.Duration
+	for i, t := range times {
+		next := now
+		if i+1 < len(times) {
+			next = times[i+1]
+		}
+		if next.Sub(t) <= c.OfflineAfter {
+			continue
+		}
+		// Down from the missed upload on, within the period
+		from := t.Add(c.Interval)
+		if from.Before(start) {
+			from = start
+		}
+		if next.After(from) {
+			down += next.Sub(from)
+		}
+		gap := UploadGap{Start: t, Seconds: int64(next.Sub(t) / time.Second)}
+		if i+1 < len(times) {
+			gap.End = &times[i+1]
+		}
+		u.Gaps = append(u.Gaps, gap)
+	}
+	// Newest first, keeping the newest uptimeMaxGaps
+	for i, j := 0, len(u.Gaps)-1; i < j; i, j = i+1, j-1 {
+		u.Gaps[i], u.Gaps[j] = u.Gaps[j], u.Gaps[i]
+	}
+	u.Gaps = u.Gaps[:min(len(u.Gaps), uptimeMaxGaps)]
+
+	period := now.Sub(start)
+	u.DowntimeSec = int64(down / time.Second)
+	u.ExpectedUploads = int(period / c.Interval)
+	u.UptimePct = 100
+	if period > 0 {
+		u.UptimePct = math.Round(1000*(1-down.Seconds()/period.Seconds())) / 10
+	}
+	return u
+}
+
+// handleAPIUptime returns uptime and gaps against each detector's cadence:
+// GET /api/uptime?device=ID&days=7 (every detector without device)
+func handleAPIUptime(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days := 7
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	c := store.getCadences(ctx)
+	w.Header().Set("Content-Type", "application/json")
+	if deviceID := r.URL.Query().Get("device"); deviceID != "" {
+		json.NewEncoder(w).Encode(store.getUptime(ctx, deviceID, c.of(deviceID), days))
+		return
+	}
+	var ids []string
+	for id := range store.latestStats() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := []Uptime{}
+	for _, id := range ids {
+		out = append(out, store.getUptime(ctx, id, c.of(id), days))
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// renderUptime writes the device page's uptime card with a form to set the interval
+func renderUptime(w http.ResponseWriter, u Uptime, days int) {
+	expected := "every " + (time.Duration(u.ExpectedIntervalSec) * time.Second).String()
+	if !u.Configured {
+		expected += " (default, from OFFLINE_AFTER_MIN)"
+	}
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Uptime</h2>
+`)
+	if u.Since == nil {
+		fmt.Fprintf(w, `        <p>No uploads in the last %d days. Expected upload %s.</p>
+`, days, html.EscapeString(expected))
+	} else {
+		fmt.Fprintf(w, `        <p><span class="health-now">%.1f%%</span> since %s · %d of ~%d expected uploads · expected %s, offline after %s</p>
+`, u.UptimePct, u.Since.Format("Jan 2 15:04"), u.Uploads, u.ExpectedUploads, html.EscapeString(expected),
+			time.Duration(u.OfflineAfterSec)*time.Second)
+	}
+	if len(u.Gaps) > 0 {
+		fmt.Fprintf(w, `        <table class="event-log">
+`)
+		for i, g := range u.Gaps {
+			if i == 20 {
+				break
+			}
+			end := "ongoing"
+			if g.End != nil {
+				end = g.End.Format("Jan 2 15:04")
+			}
+			fmt.Fprintf(w, `            <tr><td class="timestamp">%s</td><td>→ %s</td><td>%s silent</td></tr>
+`, g.Start.Format("Jan 2 15:04"), end, time.Duration(g.Seconds)*time.Second)
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	current := ""
+	if u.Configured {
+		current = strconv.Itoa(u.ExpectedIntervalSec)
+	}
+	fmt.Fprintf(w, `        <form method="POST" class="annotation-form">
+            <label>Expected upload interval (seconds, empty for the default)
+            <input type="number" name="upload_interval_sec" min="0" max="%d" value="%s"></label>
+            <button type="submit">Save</button>
+        </form>
+        <p class="chart-label"><a href="/availability?device=%s&amp;period=week" style="color: #00d4ff;">Availability by day, week and month →</a></p>
+    </div>
+`, maxUploadInterval, current, url.QueryEscape(u.DeviceID))
+}
+
+// timeSpan is the half-open period [From, To)
+type timeSpan struct{ From, To time.Time }
+
+// onlineSpans returns when any of the detectors (nil for every one) was online
+// between from and to, merged and in order. A detector is online from the
+// interval an upload covers until it would count as offline after it;
+// rolled-up hours and days count as online throughout.
+func (s *Store) onlineSpans(ctx context.Context, deviceIDs []string, from, to time.Time) []timeSpan {
+	c := s.getCadences(ctx)
+	lookback := max(24*time.Hour, defaultCadence().OfflineAfter)
+	for _, v := range c {
+		lookback = max(lookback, v.OfflineAfter)
+	}
+	lo, hi := from.Add(-lookback).Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")
+	cond, args := deviceFilter(deviceIDs)
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, timestamp, '' FROM uploads WHERE timestamp >= ? AND timestamp < ? AND `+cond+`
+		UNION ALL
+		SELECT device_id, period, tier FROM upload_rollups WHERE period >= ? AND period < ? AND `+cond,
+		append(append([]interface{}{lo, hi}, args...), append([]interface{}{lo, hi}, args...)...)...)
+	if err != nil {
+		log.Printf("Error loading online spans: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var spans []timeSpan
+	for rows.Next() {
+		var deviceID, ts, tier string
+		if rows.Scan(&deviceID, &ts, &tier) != nil {
+			continue
+		}
+		t := parseDBTime(ts)
+		switch tier {
+		case tierHour:
+			spans = append(spans, timeSpan{t, t.Add(time.Hour)})
+		case tierDay:
+			spans = append(spans, timeSpan{t, t.AddDate(0, 0, 1)})
+		default:
+			cd := c.of(deviceID)
+			spans = append(spans, timeSpan{t.Add(-cd.Interval), t.Add(cd.OfflineAfter)})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].From.Before(spans[j].From) })
+	var merged []timeSpan
+	for _, sp := range spans {
+		if n := len(merged); n > 0 && !sp.From.After(merged[n-1].To) {
+			if sp.To.After(merged[n-1].To) {
+				merged[n-1].To = sp.To
+			}
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
+
+// coverage reports, for each bucket between consecutive bounds, whether a
+// span overlaps it. Buckets that none does are gaps: no detector was online.
+func coverage(spans []timeSpan, bounds []time.Time) []bool {
+	if len(bounds) < 2 {
+		return nil
+	}
+	covered := make([]bool, len(bounds)-1)
+	for i := range covered {
+		// First span ending after the bucket starts
+		j := sort.Search(len(spans), func(j int) bool { return spans[j].To.After(bounds[i]) })
+		covered[i] = j < len(spans) && spans[j].From.Before(bounds[i+1])
+	}
+	return covered
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Calibration tracks a device's baseline recording window
+type Calibration struct {
+	DeviceID      string    `json:"device_id"`
+	StartedAt     time.Time `json:"started_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	WindowMinutes int       `json:"window_minutes"`
+	Completed     bool      `json:"completed"`
+}
+
+// Baseline holds the calibrated per-frequency detection rates for a device
+type Baseline struct {
+	DeviceID   string    `json:"device_id"`
+	Rates      []float64 `json:"rates_per_min"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// defaultCalibrationWindow is used when a calibration request doesn't specify one
+func defaultCalibrationWindow() int {
+	if v, err := strconv.Atoi(os.Getenv("CALIBRATION_WINDOW_MIN")); err == nil && v > 0 {
+		return v
+	}
+	return 60
+}
+
+// baselineAlertPct is the deviation from baseline (in percent) that raises an alert
+func baselineAlertPct() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("BASELINE_ALERT_PCT"), 64); err == nil && v > 0 {
+		return v
+	}
+	return 200
+}
+
+// freqRates converts cumulative per-frequency counts into detections per minute
+func freqRates(stats Stats) []float64 {
+	rates := make([]float64, len(frequencies()))
+	if stats.Uptime <= 0 {
+		return rates
+	}
+	minutes := float64(stats.Uptime) / 60
+	for i := range rates {
+		if i < len(stats.FreqDetections) {
+			rates[i] = float64(stats.FreqDetections[i]) / minutes
+		}
+	}
+	return rates
+}
+
+// startCalibration begins (or restarts) a baseline window for a device
+func (s *Store) startCalibration(ctx context.Context, deviceID string, windowMinutes int) (Calibration, error) {
+	now := timeNow()
+	cal := Calibration{
+		DeviceID:      deviceID,
+		StartedAt:     now,
+		EndsAt:        now.Add(time.Duration(windowMinutes) * time.Minute),
+		WindowMinutes: windowMinutes,
+	}
+
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT OR REPLACE INTO calibrations (device_id, started_at, ends_at, window_minutes, completed)
+		VALUES (?, ?, ?, ?, 0)
+	`, deviceID, cal.StartedAt.Format("2006-01-02 15:04:05"),
+		cal.EndsAt.Format("2006-01-02 15:04:05"), windowMinutes)
+	return cal, err
+}
+
+func (s *Store) getCalibration(ctx context.Context, deviceID string) (Calibration, bool) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var cal Calibration
+	var started, ends string
+	err := s.queryRow(ctx, `
+		SELECT device_id, started_at, ends_at, window_minutes, completed
+		FROM calibrations WHERE device_id = ?
+	`, deviceID).Scan(&cal.DeviceID, &started, &ends, &cal.WindowMinutes, &cal.Completed)
+	if err != nil {
+		return cal, false
+	}
+	cal.StartedAt = parseDBTime(started)
+	cal.EndsAt = parseDBTime(ends)
+	return cal, true
+}
+
+// finishCalibration averages per-upload rates over the window and stores them as the baseline
+func (s *Store) finishCalibration(ctx context.Context, cal Calibration) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT uptime_seconds, freqs
+		FROM uploads
+		WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?
+	`, cal.DeviceID, cal.StartedAt.Format("2006-01-02 15:04:05"), cal.EndsAt.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+
+	sums := make([]float64, len(frequencies()))
+	samples := 0
+	for rows.Next() {
+		var stats Stats
+		var freqs string
+		if err := rows.Scan(&stats.Uptime, &freqs); err != nil {
+			log.Printf("Error scanning calibration row: %v", err)
+			continue
+		}
+		if stats.Uptime <= 0 {
+			continue
+		}
+		stats.FreqDetections = parseCounts(freqs)
+		for i, r := range freqRates(stats) {
+			if i < len(sums) {
+				sums[i] += r
+			}
+		}
+		samples++
+	}
+	rows.Close()
+
+	if samples == 0 {
+		return fmt.Errorf("no uploads received during calibration window")
+	}
+
+	now := timeNow().Format("2006-01-02 15:04:05")
+	for i, sum := range sums {
+		_, err := s.exec(ctx, `
+			INSERT OR REPLACE INTO baselines (device_id, freq_index, rate_per_min, computed_at)
+			VALUES (?, ?, ?, ?)
+		`, cal.DeviceID, i, sum/float64(samples), now)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.exec(ctx, `UPDATE calibrations SET completed = 1 WHERE device_id = ?`, cal.DeviceID)
+	return err
+}
+
+func (s *Store) getBaseline(ctx context.Context, deviceID string) (Baseline, bool) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT freq_index, rate_per_min, computed_at FROM baselines WHERE device_id = ?
+	`, deviceID)
+	if err != nil {
+		return Baseline{}, false
+	}
+	defer rows.Close()
+
+	b := Baseline{DeviceID: deviceID, Rates: make([]float64, len(frequencies()))}
+	found := false
+	for rows.Next() {
+		var idx int
+		var rate float64
+		var ts string
+		if err := rows.Scan(&idx, &rate, &ts); err != nil {
+			continue
+		}
+		if idx >= 0 && idx < len(b.Rates) {
+			b.Rates[idx] = rate
+		}
+		b.ComputedAt = parseDBTime(ts)
+		found = true
+	}
+	return b, found
+}
+
+// baselineDeviation returns the percent change of each frequency's rate versus baseline.
+// Frequencies with no baseline activity report 0.
+func baselineDeviation(stats Stats, b Baseline) []float64 {
+	rates := freqRates(stats)
+	dev := make([]float64, len(rates))
+	for i, r := range rates {
+		if i < len(b.Rates) && b.Rates[i] > 0 {
+			dev[i] = (r - b.Rates[i]) / b.Rates[i] * 100
+		}
+	}
+	return dev
+}
+
+// processCalibration completes due calibrations and checks the upload against any baseline
+func (s *Store) processCalibration(ctx context.Context, stats Stats) {
+	if cal, ok := s.getCalibration(ctx, stats.DeviceID); ok && !cal.Completed {
+		if timeNow().Before(cal.EndsAt) {
+			return
+		}
+		if err := s.finishCalibration(ctx, cal); err != nil {
+			log.Printf("Calibration for %s failed: %v", stats.DeviceID, err)
+			return
+		}
+		log.Printf("Calibration for %s complete (%d min window)", stats.DeviceID, cal.WindowMinutes)
+	}
+
+	b, ok := s.getBaseline(ctx, stats.DeviceID)
+	if !ok {
+		return
+	}
+
+	threshold := baselineAlertPct()
+	freqs := frequencies()
+	for i, d := range baselineDeviation(stats, b) {
+		if d >= threshold && i < len(freqs) && freqs[i].Scanned() {
+			s.raiseAlert(ctx, stats.DeviceID, "baseline:"+freqs[i].MHz,
+				fmt.Sprintf("%s MHz (%s) is %.0f%% above baseline", freqs[i].MHz, freqs[i].Label, d))
+		}
+	}
+}
+
+// handleAPICalibrate starts a calibration window: POST /api/calibrate?device=ID&window=MINUTES
+func handleAPICalibrate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID := r.FormValue("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+
+	window := defaultCalibrationWindow()
+	if v := r.FormValue("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "window must be a positive number of minutes", http.StatusBadRequest)
+			return
+		}
+		window = n
+	}
+
+	cal, err := store.startCalibration(ctx, deviceID, window)
+	if err != nil {
+		log.Printf("Error starting calibration: %v", err)
+		http.Error(w, "Failed to start calibration", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Calibration started for %s (%d min window)", deviceID, window)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cal)
+}
+
+// handleAPIBaselines returns calibration state and baselines for every known device
+func handleAPIBaselines(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	store.mu.RLock()
+	devices := make([]string, 0, len(store.latest))
+	for id := range store.latest {
+		devices = append(devices, id)
+	}
+	store.mu.RUnlock()
+
+	result := make(map[string]interface{})
+	for _, id := range devices {
+		entry := map[string]interface{}{}
+		if cal, ok := store.getCalibration(ctx, id); ok {
+			entry["calibration"] = cal
+		}
+		if b, ok := store.getBaseline(ctx, id); ok {
+			entry["baseline"] = b
+		}
+		result[id] = entry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
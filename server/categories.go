@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// CategoryInfo describes a kind of LoRa traffic. Channels belong to one through
+// FrequencyInfo.Category; the dashboard cards and /category pages are built from this.
+type CategoryInfo struct {
+	Name     string    // FrequencyInfo.Category
+	Title    string    // Display name
+	Icon     string    // Emoji shown before the title
+	Color    string    // Legend and card accent colour
+	Examples []string  // Typical devices, translated on the dashboard
+	Nouns    [2]string // One transmitter, several (for estimates)
+	About    []string  // Explanation paragraphs for the category page
+	// Modulation is set for traffic that isn't LoRa ("OOK/FSK"), which only
+	// firmware builds that also sniff other modulations report
+	Modulation string
+}
+
+// categoryInfo lists the categories in dashboard order
+var categoryInfo = []CategoryInfo{
+	{
+		Name: "sidewalk", Title: "Amazon Sidewalk", Icon: "🏠", Color: "#00BCD4",
+		Examples: []string{"Ring doorbells & cameras", "Echo (4th gen+) speakers", "Tile trackers", "Level smart locks"},
+		Nouns:    [2]string{"Sidewalk device", "Sidewalk devices"},
+		About: []string{
+			"Amazon Sidewalk is a shared neighbourhood network. Echo speakers and Ring floodlights and doorbells act as bridges, lending a slice of their owners' internet connection to low-power devices nearby.",
+			"Its long-range link is 900 MHz LoRa. Tile trackers, smart locks and pet or garden sensors use it to reach a bridge up to about a kilometre away, even when they are nowhere near their own Wi-Fi.",
+			"Bridges are on by default, so steady Sidewalk activity usually means Echo or Ring devices in range rather than anything you set up. Bursts tend to follow tracked items moving past.",
+		},
+	},
+	{
+		Name: "meshtastic", Title: "Meshtastic", Icon: "🥾", Color: "#FF9800",
+		Examples: []string{"Off-grid mesh communicators", "Hiker/outdoor devices", "Emergency comms", "DIY LoRa nodes"},
+		Nouns:    [2]string{"Meshtastic node", "Meshtastic nodes"},
+		About: []string{
+			"Meshtastic is open-source firmware that turns cheap LoRa boards into an off-grid text messenger. Every node relays what it hears, so messages hop across a mesh without any internet or phone network.",
+			"Hikers, event crews, preppers and hobbyists carry or mount them, and many nodes beacon their position and telemetry every few minutes. Regular, evenly spaced detections are usually a fixed node or router; scattered bursts are people chatting or passing through.",
+		},
+	},
+	{
+		Name: "lorawan", Title: "LoRaWAN / IoT", Icon: "🏭", Color: "#4CAF50",
+		Examples: []string{"Smart utility meters", "Parking sensors", "Agricultural monitors", "Industrial sensors"},
+		Nouns:    [2]string{"LoRaWAN sensor", "LoRaWAN sensors"},
+		About: []string{
+			"LoRaWAN is the standard network for battery-powered sensors. Devices send short uplinks to gateways run by utilities, cities, farms or community networks such as The Things Network and Helium, which forward them to an application server.",
+			"The US915 band spreads uplinks over dozens of channels and answers on the downlink channels, so the detector samples several of them. Meters and environmental sensors usually report on a fixed schedule, every few minutes to every few hours, so LoRaWAN traffic is often highly periodic.",
+		},
+	},
+	{
+		Name: "lora24", Title: "2.4 GHz LoRa", Icon: "📡", Color: "#E040FB",
+		Examples: []string{"ExpressLRS RC links", "SX1280 ranging tags", "Meshtastic 2.4 GHz nodes", "2.4 GHz LoRaWAN sensors"},
+		Nouns:    [2]string{"2.4 GHz LoRa device", "2.4 GHz LoRa devices"},
+		About: []string{
+			"LoRa also runs in the worldwide 2.4 GHz ISM band on Semtech's SX1280 radios. The band is the same everywhere, so one build works in any country, and wider channels give faster data and time-of-flight ranging at the cost of shorter range than 900 MHz.",
+			"Radio-control links such as ExpressLRS are the most common source: a model aircraft or drone and its transmitter hop across the band many times a second while flying, so expect dense bursts that stop when the flight ends. Asset and ranging tags, Meshtastic nodes on the 2.4 GHz region and experimental LoRaWAN sensors make steadier, sparser traffic.",
+			"A 900 MHz detector can't hear these; they need an SX1280-based detector, which reports to the same server with its channels added to the frequency plan.",
+		},
+	},
+	{
+		Name: "weather", Title: "Weather Stations", Icon: "🌦️", Color: "#FFD54F", Modulation: "OOK/FSK",
+		Examples: []string{"Outdoor temperature/humidity sensors", "Rain gauges", "Anemometers", "Pool and soil thermometers"},
+		Nouns:    [2]string{"weather sensor", "weather sensors"},
+		About: []string{
+			"Home weather stations are the busiest non-LoRa senders in the ISM bands. The outdoor unit of an Acurite, Oregon Scientific, Fine Offset (Ambient, Ecowitt) or La Crosse station sends a short OOK or FSK burst every 16 to 60 seconds, on 433.92 MHz, 868 MHz or, in the Americas, 915 MHz.",
+			"They never stop and keep to a strict interval, so weather traffic is a steady background that shows up as highly periodic transmitters. Every neighbour's station adds its own interval, which is how several can be told apart.",
+			"These aren't LoRa, so a LoRa-only detector doesn't see them; they are reported by firmware that also decodes simple OOK/FSK packets.",
+		},
+	},
+	{
+		Name: "tpms", Title: "Tire Pressure Sensors", Icon: "🚗", Color: "#A1887F", Modulation: "OOK/FSK",
+		Examples: []string{"Car tire pressure monitors (TPMS)", "Truck and trailer tire sensors", "Motorcycle TPMS"},
+		Nouns:    [2]string{"tire sensor", "tire sensors"},
+		About: []string{
+			"Every car sold in the US since 2008 and the EU since 2014 has a pressure sensor in each wheel. They send a short FSK or OOK packet on 315 or 433.92 MHz about once a minute while the car is moving, and more often when pressure changes.",
+			"TPMS traffic follows the road: rush-hour peaks next to a street, quiet nights, and bursts of four sensors at once as a car passes or parks. A parked car's sensors go quiet after a few minutes.",
+			"These aren't LoRa, so a LoRa-only detector doesn't see them; they are reported by firmware that also decodes simple OOK/FSK packets.",
+		},
+	},
+	{
+		Name: "remote", Title: "Doorbells & Remotes", Icon: "🔔", Color: "#F06292", Modulation: "OOK/FSK",
+		Examples: []string{"Wireless doorbells", "Garage and gate remotes", "Car key fobs", "Alarm and door sensors"},
+		Nouns:    [2]string{"remote", "remotes"},
+		About: []string{
+			"Wireless doorbells, garage and gate openers, key fobs, remote sockets and cheap alarm sensors send a burst of simple OOK pulses, usually on 433.92 MHz (868 MHz in parts of Europe), only when a button is pressed or a door opens.",
+			"Unlike weather stations they have no schedule, so this traffic follows people: mornings and evenings, deliveries and visitors. A sudden run of detections at night may be worth a look.",
+			"These aren't LoRa, so a LoRa-only detector doesn't see them; they are reported by firmware that also decodes simple OOK/FSK packets.",
+		},
+	},
+}
+
+// planCategoryInfo returns the categories the frequency plan scans, in
+// dashboard order, so a 900 MHz-only plan has no empty 2.4 GHz card
+func planCategoryInfo() []CategoryInfo {
+	var cats []CategoryInfo
+	for _, c := range categoryInfo {
+		if slices.Contains(categories(), c.Name) {
+			cats = append(cats, c)
+		}
+	}
+	return cats
+}
+
+// modulationNote marks a category that isn't LoRa in a subtitle
+func modulationNote(c CategoryInfo) string {
+	if c.Modulation == "" {
+		return ""
+	}
+	return " · " + html.EscapeString(c.Modulation) + ", not LoRa"
+}
+
+// categoryByName looks up a category, falling back to a plain one for names
+// that only appear in the frequency table
+func categoryByName(name string) (CategoryInfo, bool) {
+	for _, c := range categoryInfo {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return CategoryInfo{Name: name, Title: name, Nouns: [2]string{name + " device", name + " devices"}}, false
+}
+
+// categoryCount totals one category's entries in a per-frequency slice
+func categoryCount(counts []int, name string) int {
+	n := 0
+	for i, f := range frequencies() {
+		if f.Category == name && i < len(counts) {
+			n += counts[i]
+		}
+	}
+	return n
+}
+
+// handleCategory renders every channel in a category together: trend, per-channel
+// totals, hour-of-day profile and what the traffic is. GET /category/{name}?days=30
+func handleCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cat, ok := categoryByName(r.PathValue("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	var channels []int
+	for i, f := range frequencies() {
+		if f.Category == cat.Name {
+			channels = append(channels, i)
+		}
+	}
+	loc := store.locationFor(ctx, nil)
+	activity := store.getActivityBuckets(ctx, nil, days, loc)
+	totals := make([]int, len(frequencies()))
+	for _, day := range activity.Daily {
+		for i, c := range day {
+			totals[i] += c
+		}
+	}
+	total := categoryCount(totals, cat.Name)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, cat.Title, `    <style>
+        .category-about p { line-height: 1.6; color: #ccc; }
+        .category-links a { color: #00d4ff; margin-right: 12px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>%s %s</h1>
+    <p class="subtitle">%d channels · last %d days · %d detections%s</p>
+    <div class="card">
+        <h2>Daily Detections</h2>
+`, cat.Icon, html.EscapeString(cat.Title), len(channels), days, total, modulationNote(cat))
+
+	// Trend: the last week against the one before, once there are two to compare
+	if n := len(activity.Daily); n >= 14 {
+		week := func(from int) int {
+			sum := 0
+			for _, day := range activity.Daily[from : from+7] {
+				sum += categoryCount(day, cat.Name)
+			}
+			return sum
+		}
+		last, prev := week(n-7), week(n-14)
+		trend := "no detections in the week before"
+		if prev > 0 {
+			trend = fmt.Sprintf("%+.0f%% on the week before (%d)", 100*float64(last-prev)/float64(prev), prev)
+		}
+		fmt.Fprintf(w, `        <div class="chart-label">Last 7 days: %d detections, %s</div>
+`, last, trend)
+	}
+	fmt.Fprintf(w, `        `)
+	renderStackedBars(w, onlyFrequencies(activity.Daily, channels...), 480, 100)
+	if from, to, ok := activitySpan(activity, loc); ok {
+		notes := slices.Concat(store.getAnnotations(ctx, nil, from, to), store.solarAnnotations(ctx, from, to))
+		renderAnnotationStrip(w, notes, from, to, 480)
+		fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>%s</span><span>%s</span></div>
+`, activity.Days[0], activity.Days[len(activity.Days)-1])
+	}
+	fmt.Fprintf(w, `    </div>
+    <div class="card">
+        <h2>Channels</h2>
+`)
+	rows := make([]FreqRow, 0, len(channels))
+	for _, i := range channels {
+		f := frequencyAt(i)
+		rows = append(rows, FreqRow{Freq: f, Href: fmt.Sprintf("/frequency/%s?days=%d", f.MHz, days), Devices: f.Devices, Count: totals[i]})
+	}
+	renderFreqTable(w, defaultLang, "Detections per channel", rows)
+	fmt.Fprintf(w, `    </div>
+    <div class="card">
+        <h2>Activity by Hour of Day</h2>
+        <div class="chart-label">Detections over the last %d days by local hour (%s)</div>
+        `, days, html.EscapeString(activity.Timezone))
+	renderStackedBars(w, onlyFrequencies(activity.Hourly, channels...), 480, 100)
+	fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>00:00</span><span>12:00</span><span>23:00</span></div>
+    </div>
+    <div class="card category-about">
+        <h2>About %s</h2>
+`, html.EscapeString(cat.Title))
+	for _, p := range cat.About {
+		fmt.Fprintf(w, `        <p>%s</p>
+`, html.EscapeString(p))
+	}
+	fmt.Fprintf(w, `        <div class="chart-label">Typical devices</div>
+        <ul>
+`)
+	for _, ex := range cat.Examples {
+		fmt.Fprintf(w, `            <li>%s</li>
+`, html.EscapeString(ex))
+	}
+	fmt.Fprintf(w, `        </ul>
+        <p class="category-links">`)
+	for _, c := range planCategoryInfo() {
+		if c.Name != cat.Name {
+			fmt.Fprintf(w, `<a href="/category/%s?days=%d">%s %s</a>`, url.PathEscape(c.Name), days, c.Icon, html.EscapeString(c.Title))
+		}
+	}
+	fmt.Fprintf(w, `</p>
+    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A step change is a channel's daily detections settling at a new level and
+// staying there, such as a LoRaWAN gateway going up nearby. Once a day each
+// detector's channels are searched over the last changeWindowDays for the
+// split that best separates an earlier level from a later one. A split
+// counts if both sides are long enough, the rates differ by changeMinRatio
+// and the difference is well beyond day-to-day noise. Each change is recorded
+// once, with its before and after rates, and pinned to the detector's charts
+// as a note.
+const (
+	changeWindowDays = 42
+	changeMinBefore  = 14 // days
+	changeMinAfter   = 7
+	changeMinRatio   = 2
+	changeMinT       = 5    // Welch's t
+	changeMinPerDay  = 10.0 // the busier side's rate; quieter channels are noise
+	changeInterval   = 24 * time.Hour
+)
+
+// ChangePoint is a sustained step in one channel's daily detections
+type ChangePoint struct {
+	ID           int64     `json:"id"`
+	DeviceID     string    `json:"device_id"`
+	MHz          string    `json:"mhz"`
+	Day          string    `json:"day"` // first day at the new level, in the detector's timezone
+	BeforePerDay float64   `json:"before_per_day"`
+	AfterPerDay  float64   `json:"after_per_day"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// meanVar is a series' mean and sample variance
+func meanVar(v []float64) (float64, float64) {
+	var sum, sumSq float64
+	for _, x := range v {
+		sum += x
+	}
+	mean := sum / float64(len(v))
+	for _, x := range v {
+		sumSq += (x - mean) * (x - mean)
+	}
+	if len(v) < 2 {
+		return mean, 0
+	}
+	return mean, sumSq / float64(len(v)-1)
+}
+
+// findStep returns the split of daily counts that best separates two levels,
+// and whether it is a sustained step. Variances are floored at the mean, as
+// for Poisson counts, so a quiet, even channel doesn't look infinitely sure.
+func findStep(daily []float64) (split int, before, after float64, ok bool) {
+	bestT := 0.0
+	for k := changeMinBefore; k <= len(daily)-changeMinAfter; k++ {
+		mb, vb := meanVar(daily[:k])
+		ma, va := meanVar(daily[k:])
+		vb, va = max(vb, mb, 1), max(va, ma, 1)
+		t := math.Abs(ma-mb) / math.Sqrt(vb/float64(k)+va/float64(len(daily)-k))
+		if t > bestT {
+			bestT, split, before, after = t, k, mb, ma
+		}
+	}
+	if bestT < changeMinT || max(before, after) < changeMinPerDay {
+		return 0, 0, 0, false
+	}
+	ratio := (after + 1) / (before + 1)
+	return split, before, after, ratio >= changeMinRatio || ratio <= 1.0/changeMinRatio
+}
+
+// detectChangePoints searches a detector's channels and records new steps
+func (s *Store) detectChangePoints(ctx context.Context, deviceID string) {
+	loc := s.getDevice(ctx, deviceID).Location()
+	activity := s.getActivityBuckets(ctx, []string{deviceID}, changeWindowDays+1, loc)
+	if len(activity.Days) < 2 {
+		return
+	}
+	// Today is still under way
+	days, daily := activity.Days[:len(activity.Days)-1], activity.Daily[:len(activity.Daily)-1]
+
+	for i, f := range frequencies() {
+		if !f.Scanned() {
+			continue
+		}
+		var series []float64
+		var seriesDays []string
+		for d, counts := range daily {
+			if counts != nil {
+				series = append(series, float64(countAt(counts, i)))
+				seriesDays = append(seriesDays, days[d])
+			}
+		}
+		split, before, after, ok := findStep(series)
+		if !ok {
+			continue
+		}
+		cp := ChangePoint{DeviceID: deviceID, MHz: f.MHz, Day: seriesDays[split],
+			BeforePerDay: math.Round(before*10) / 10, AfterPerDay: math.Round(after*10) / 10}
+		if err := s.recordChangePoint(ctx, cp, f, loc); err != nil {
+			log.Printf("Error recording change point for %s: %v", deviceID, err)
+		}
+	}
+}
+
+// recordChangePoint saves a step and its note, unless the same channel already
+// has one within changeMinBefore days: a step found again as the window moves
+func (s *Store) recordChangePoint(ctx context.Context, cp ChangePoint, f FrequencyInfo, loc *time.Location) error {
+	start, err := time.ParseInLocation("2006-01-02", cp.Day, loc)
+	if err != nil {
+		return err
+	}
+	readCtx, cancel := dbContext(ctx, dbReadTimeout)
+	var known int
+	err = s.queryRow(readCtx, `
+		SELECT COUNT(*) FROM change_points
+		WHERE device_id = ? AND mhz = ? AND day BETWEEN ? AND ?
+	`, cp.DeviceID, cp.MHz, start.AddDate(0, 0, -changeMinBefore).Format("2006-01-02"),
+		start.AddDate(0, 0, changeMinBefore).Format("2006-01-02")).Scan(&known)
+	cancel()
+	if err != nil || known > 0 {
+		return err
+	}
+
+	direction := "up"
+	if cp.AfterPerDay < cp.BeforePerDay {
+		direction = "down"
+	}
+	note := fmt.Sprintf("Step %s on %s MHz (%s): %.0f → %.0f detections a day (found automatically)",
+		direction, f.MHz, f.Label, cp.BeforePerDay, cp.AfterPerDay)
+	noteID, err := s.addAnnotation(ctx, Annotation{DeviceID: cp.DeviceID, Start: start.In(time.Local), End: start.In(time.Local), Note: note})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err = s.exec(ctx, `
+		INSERT INTO change_points (device_id, mhz, day, before_per_day, after_per_day, annotation_id, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, cp.DeviceID, cp.MHz, cp.Day, cp.BeforePerDay, cp.AfterPerDay, noteID, timeNow().Format("2006-01-02 15:04:05"))
+	if err == nil {
+		log.Printf("Step change for %s: %s", cp.DeviceID, note)
+	}
+	return err
+}
+
+// watchChangePoints searches every detector once a day
+func (s *Store) watchChangePoints() {
+	ctx := context.Background()
+	for {
+		for id := range s.latestStats() {
+			s.detectChangePoints(ctx, id)
+		}
+		time.Sleep(changeInterval)
+	}
+}
+
+// getChangePoints returns steps found since a day, newest first; an empty
+// deviceID returns every detector's
+func (s *Store) getChangePoints(ctx context.Context, deviceID string, since time.Time) []ChangePoint {
+	var ids []string
+	if deviceID != "" {
+		ids = []string{deviceID}
+	}
+	cond, args := deviceFilter(ids)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, device_id, mhz, day, before_per_day, after_per_day, detected_at
+		FROM change_points WHERE `+cond+` AND day >= ? ORDER BY day DESC, id DESC
+	`, append(args, since.Format("2006-01-02"))...)
+	if err != nil {
+		log.Printf("Error loading change points: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var out []ChangePoint
+	for rows.Next() {
+		var cp ChangePoint
+		var detected string
+		if err := rows.Scan(&cp.ID, &cp.DeviceID, &cp.MHz, &cp.Day, &cp.BeforePerDay, &cp.AfterPerDay, &detected); err != nil {
+			log.Printf("Error scanning change point: %v", err)
+			continue
+		}
+		cp.DetectedAt = parseDBTime(detected)
+		out = append(out, cp)
+	}
+	return out
+}
+
+// handleAPIChangePoints lists step changes found in channels' daily
+// detections: GET /api/changepoints?device=ID&days=90
+func handleAPIChangePoints(w http.ResponseWriter, r *http.Request) {
+	days := 90
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	changes := store.getChangePoints(r.Context(), r.URL.Query().Get("device"), timeNow().AddDate(0, 0, -days))
+	if changes == nil {
+		changes = []ChangePoint{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// Per-frequency counts are stored as one text column of comma-separated
+// integers in frequency plan order ("12,0,3"), so a detector can scan any
+// number of channels. SQLite can't add those up by itself, so these functions
+// are registered with the driver:
+//
+//	counts_sum(freqs)    aggregate: element-wise sum, NULL over no rows
+//	counts_add(a, b)     element-wise sum of two rows
+//	counts_total(freqs)  one row's counts added together
+//	counts_min(freqs)    one row's smallest count, for the integrity checks
+//	hist_of(value)       a histogram of one value (see stats.go)
+//
+// A detector's channel map (DeviceInfo.Channels) says which plan frequency
+// each of its scan slots is; without one, slot i is plan slot i. Counts are
+// re-indexed by the map as they arrive, so everything stored is in plan order
+// and detectors with different scan lists add up.
+
+// maxChannels caps the frequency plan and the counts an upload can carry
+const maxChannels = 256
+
+// encodeCounts formats counts for a freqs column
+func encodeCounts(counts []int) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = strconv.Itoa(c)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseCounts reads a freqs column as stored; anything unreadable counts as 0
+func parseCounts(s string) []int {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	counts := make([]int, len(parts))
+	for i, p := range parts {
+		counts[i], _ = strconv.Atoi(p)
+	}
+	return counts
+}
+
+// planCounts reads a freqs column as one count per frequency in the plan
+func planCounts(s string) []int {
+	return fitCounts(parseCounts(s), len(frequencies()))
+}
+
+// fitCounts pads counts with zeros, or cuts them, to n entries
+func fitCounts(counts []int, n int) []int {
+	fitted := make([]int, n)
+	copy(fitted, counts)
+	return fitted
+}
+
+// countAt returns counts[i], or 0 past the end
+func countAt(counts []int, i int) int {
+	if i < len(counts) {
+		return counts[i]
+	}
+	return 0
+}
+
+// addCounts adds b into a element-wise, growing a if b is longer
+func addCounts(a, b []int) []int {
+	for i, c := range b {
+		if i < len(a) {
+			a[i] += c
+		} else {
+			a = append(a, c)
+		}
+	}
+	return a
+}
+
+// countsArg reads a freqs argument passed to one of the SQL functions
+func countsArg(v driver.Value) ([]int, bool) {
+	switch s := v.(type) {
+	case string:
+		return parseCounts(s), true
+	case []byte:
+		return parseCounts(string(s)), true
+	}
+	return nil, false
+}
+
+// countsSum is the counts_sum aggregate
+type countsSum struct {
+	sum  []int
+	seen bool
+}
+
+func (a *countsSum) Step(_ *sqlite.FunctionContext, args []driver.Value) error {
+	if c, ok := countsArg(args[0]); ok {
+		a.sum, a.seen = addCounts(a.sum, c), true
+	}
+	return nil
+}
+
+func (a *countsSum) WindowInverse(_ *sqlite.FunctionContext, args []driver.Value) error {
+	if c, ok := countsArg(args[0]); ok {
+		for i := range c {
+			c[i] = -c[i]
+		}
+		a.sum = addCounts(a.sum, c)
+	}
+	return nil
+}
+
+func (a *countsSum) WindowValue(*sqlite.FunctionContext) (driver.Value, error) {
+	if !a.seen {
+		return nil, nil
+	}
+	return encodeCounts(a.sum), nil
+}
+
+func (a *countsSum) Final(*sqlite.FunctionContext) {}
+
+func init() {
+	sqlite.MustRegisterFunction("counts_sum", &sqlite.FunctionImpl{
+		NArgs: 1, Deterministic: true,
+		MakeAggregate: func(sqlite.FunctionContext) (sqlite.AggregateFunction, error) { return &countsSum{}, nil },
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("counts_add", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		a, _ := countsArg(args[0])
+		b, _ := countsArg(args[1])
+		return encodeCounts(addCounts(a, b)), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("counts_total", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		c, _ := countsArg(args[0])
+		total := int64(0)
+		for _, n := range c {
+			total += int64(n)
+		}
+		return total, nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("counts_min", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		c, _ := countsArg(args[0])
+		low := int64(0)
+		for _, n := range c {
+			low = min(low, int64(n))
+		}
+		return low, nil
+	})
+}
+
+// countColumns are the fixed-width column sets older databases stored counts
+// in, and the freqs column each one became
+var countColumns = []struct{ table, prefix, column string }{
+	{"uploads", "freq_", "freqs"},
+	{"upload_rollups", "freq_", "freqs"},
+	{"upload_rollups", "new_", "new_freqs"},
+	{"rollup_cursors", "freq_", "freqs"},
+	{"community_reports", "freq_", "freqs"},
+}
+
+// migrateCountColumns moves counts from the eight freq_0..freq_7 (and
+// new_0..new_7) columns of older databases into freqs columns, in one
+// transaction. It does nothing once they have moved.
+func migrateCountColumns(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	migrated := false
+	for _, m := range countColumns {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, m.table, m.prefix+"0").Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s TEXT NOT NULL DEFAULT ''`, m.table, m.column)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = %s`, m.table, m.column, joinCountColumns(m.prefix, 8))); err != nil {
+			return err
+		}
+		for i := range 8 {
+			if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s%d`, m.table, m.prefix, i)); err != nil {
+				return err
+			}
+		}
+		migrated = true
+	}
+	if !migrated {
+		return nil
+	}
+	log.Printf("Moved per-frequency counts into freqs columns")
+	return tx.Commit()
+}
+
+// joinCountColumns is SQL reading prefix0..prefix(n-1) as a freqs value
+func joinCountColumns(prefix string, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("COALESCE(%s%d, 0)", prefix, i)
+	}
+	return strings.Join(parts, " || ',' || ")
+}
+
+// channelMap takes a detector's scan slots to plan slots; -1 drops a slot.
+// nil is the identity.
+type channelMap []int
+
+// channelMap builds a detector's map from its Channels, by MHz
+func (s *Store) channelMap(ctx context.Context, deviceID string) channelMap {
+	channels := s.getDevice(ctx, deviceID).Channels
+	if len(channels) == 0 {
+		return nil
+	}
+	plan := frequencies()
+	m := make(channelMap, len(channels))
+	for i, mhz := range channels {
+		m[i] = -1
+		for j, f := range plan {
+			if f.Scanned() && f.MHz == mhz {
+				m[i] = j
+				break
+			}
+		}
+	}
+	return m
+}
+
+// index returns the plan slot for a detector's scan slot
+func (m channelMap) index(slot int) (int, bool) {
+	if m == nil {
+		return slot, slot >= 0 && slot < maxChannels
+	}
+	if slot < 0 || slot >= len(m) || m[slot] < 0 {
+		return 0, false
+	}
+	return m[slot], true
+}
+
+// counts re-indexes a detector's per-slot counts in plan order
+func (m channelMap) counts(counts []int) []int {
+	if m == nil {
+		return counts[:min(len(counts), maxChannels)]
+	}
+	var planned []int
+	for slot, c := range counts {
+		if i, ok := m.index(slot); ok {
+			if i >= len(planned) {
+				planned = append(planned, make([]int, i+1-len(planned))...)
+			}
+			planned[i] += c
+		}
+	}
+	return planned
+}
+
+// events re-indexes an event upload's frequencies in plan order
+func (m channelMap) events(up EventUpload) EventUpload {
+	if m == nil {
+		return up
+	}
+	events := make([]DetectionEvent, 0, len(up.Events))
+	for _, e := range up.Events {
+		if i, ok := m.index(e.FreqIndex); ok {
+			e.FreqIndex = i
+			events = append(events, e)
+		}
+	}
+	up.Events = events
+	bins := make([]MinuteBin, len(up.Bins))
+	for i, b := range up.Bins {
+		b.Counts = m.counts(b.Counts)
+		bins[i] = b
+	}
+	up.Bins = bins
+	return up
+}
+
+// parseChannels reads a channel map given as comma-separated MHz, one per scan
+// slot; an empty entry is a slot to ignore. Every frequency must be in the plan.
+func parseChannels(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	channels := strings.Split(s, ",")
+	if len(channels) > maxChannels {
+		return nil, fmt.Errorf("at most %d channels", maxChannels)
+	}
+	for i, mhz := range channels {
+		mhz = strings.TrimSpace(mhz)
+		if mhz == "" {
+			channels[i] = ""
+			continue
+		}
+		v, err := strconv.ParseFloat(mhz, 64)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %q is not a frequency in MHz", i, mhz)
+		}
+		mhz = strconv.FormatFloat(v, 'f', -1, 64)
+		if _, ok := frequencyIndex(mhz); !ok {
+			return nil, fmt.Errorf("channel %d: %s MHz is not in the frequency plan", i, mhz)
+		}
+		channels[i] = mhz
+	}
+	return channels, nil
+}
+
+// setDeviceChannels records which plan frequency each of a detector's scan
+// slots is; nil clears the map
+func (s *Store) setDeviceChannels(ctx context.Context, deviceID string, channels []string) error {
+	var value interface{}
+	if len(channels) > 0 {
+		value = strings.Join(channels, ",")
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, channels) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET channels = excluded.channels
+	`, deviceID, value)
+	return err
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// renderStackedBars writes an inline SVG bar chart with one stacked bar per
+// bucket, coloured by frequency to match the rest of the dashboard. Gap (nil)
+// buckets, when no detector was online, are shaded so they don't pass for
+// quiet ones.
+func renderStackedBars(w io.Writer, series [][]int, width, height int) {
+	maxTotal := 1
+	for _, bucket := range series {
+		total := 0
+		for _, c := range bucket {
+			total += c
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+	}
+
+	fmt.Fprintf(w, `<svg class="chart" viewBox="0 0 %d %d" preserveAspectRatio="none" width="100%%" height="%d" role="img" aria-label="Bar chart: %d bars, busiest %d detections">`,
+		width, height, height, len(series), maxTotal)
+	if len(series) == 0 {
+		fmt.Fprintf(w, `</svg>`)
+		return
+	}
+
+	barWidth := float64(width) / float64(len(series))
+	for i, bucket := range series {
+		if bucket == nil {
+			fmt.Fprintf(w, `<rect x="%.1f" y="0" width="%.1f" height="%d" fill="#888" fill-opacity="0.15"><title>No detector online</title></rect>`,
+				float64(i)*barWidth, barWidth*0.9, height)
+			continue
+		}
+		y := float64(height)
+		for f, c := range bucket {
+			if c == 0 || f >= len(frequencies()) {
+				continue
+			}
+			h := float64(c) * float64(height) / float64(maxTotal)
+			y -= h
+			fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				float64(i)*barWidth, y, barWidth*0.9, h, frequencyAt(f).Color)
+		}
+	}
+	fmt.Fprintf(w, `</svg>`)
+}
+
+// renderPolarPlot writes an SVG rose with one wedge per sector, scaled to the busiest sector
+func renderPolarPlot(w io.Writer, counts []int, color string, size int) {
+	maxCount := 1
+	for _, c := range counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	c := float64(size) / 2
+	r := c - 12
+	fmt.Fprintf(w, `<svg class="polar" viewBox="0 0 %d %d" width="%d" height="%d">`, size, size, size, size)
+	for _, frac := range []float64{0.33, 0.66, 1} {
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="none" stroke="rgba(255,255,255,0.15)"/>`, c, c, r*frac)
+	}
+	fmt.Fprintf(w, `<text x="%.1f" y="9" fill="#888" font-size="9" text-anchor="middle">N</text>`, c)
+
+	width := 2 * math.Pi / float64(len(counts))
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		length := r * float64(n) / float64(maxCount)
+		// Compass bearings run clockwise from north; SVG angles from +x
+		a0 := float64(i)*width - width/2 - math.Pi/2
+		a1 := a0 + width
+		fmt.Fprintf(w, `<path d="M%.1f,%.1f L%.1f,%.1f A%.1f,%.1f 0 0,1 %.1f,%.1f Z" fill="%s" fill-opacity="0.8"><title>%.0f°: %d</title></path>`,
+			c, c, c+length*math.Cos(a0), c+length*math.Sin(a0), length, length,
+			c+length*math.Cos(a1), c+length*math.Sin(a1), color, float64(i)*360/float64(len(counts)), n)
+	}
+	fmt.Fprintf(w, `</svg>`)
+}
+
+// renderLineChart writes an SVG line chart of values against time, scaled to
+// their range, with any notes shaded behind the line. NaN values are gaps,
+// where the line breaks.
+func renderLineChart(w io.Writer, times []time.Time, values []float64, color string, width, height int, notes ...Annotation) {
+	fmt.Fprintf(w, `<svg class="chart" viewBox="0 0 %d %d" preserveAspectRatio="none" width="100%%" height="%d">`, width, height, height)
+	if len(values) < 2 {
+		fmt.Fprintf(w, `</svg>`)
+		return
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		fmt.Fprintf(w, `</svg>`)
+		return
+	}
+	if hi == lo {
+		lo, hi = lo-1, hi+1
+	}
+	t0 := times[0]
+	span := times[len(times)-1].Sub(t0).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+	renderAnnotationBands(w, notes, t0, span, width, height)
+
+	open := false
+	for i, v := range values {
+		if math.IsNaN(v) {
+			if open {
+				fmt.Fprintf(w, `"/>`)
+				open = false
+			}
+			continue
+		}
+		if !open {
+			fmt.Fprintf(w, `<polyline fill="none" stroke="%s" stroke-width="1.5" vector-effect="non-scaling-stroke" points="`, color)
+			open = true
+		}
+		x := times[i].Sub(t0).Seconds() / span * float64(width)
+		y := float64(height) - (v-lo)/(hi-lo)*float64(height-4) - 2
+		fmt.Fprintf(w, "%.1f,%.1f ", x, y)
+	}
+	if open {
+		fmt.Fprintf(w, `"/>`)
+	}
+	fmt.Fprintf(w, `</svg>`)
+}
+
+// FreqRow is one channel in a frequency breakdown table
+type FreqRow struct {
+	Freq    FrequencyInfo
+	Href    string // Link for the MHz cell; empty for none
+	Devices string // Text inside the bar, already translated
+	Count   int
+	Note    string // Trusted HTML after the count, such as a baseline deviation
+}
+
+// renderFreqTable writes a frequency breakdown as a real table: the bars are
+// decoration, labelled with their share of the busiest channel, and the counts
+// are cells a screen reader can read by row and column
+func renderFreqTable(w io.Writer, l Lang, caption string, rows []FreqRow) {
+	maxCount := 1
+	for _, r := range rows {
+		maxCount = max(maxCount, r.Count)
+	}
+	fmt.Fprintf(w, `        <table class="freq-table">
+            <caption class="sr-only">%s</caption>
+            <thead class="sr-only"><tr><th scope="col">%s</th><th scope="col">%s</th><th scope="col">%s</th><th scope="col">%s</th></tr></thead>
+            <tbody>
+`, html.EscapeString(caption), l.T("Frequency"), l.T("Band"), l.T("Share of busiest channel"), l.T("Detections"))
+	// A dual-band plan is shown band by band, each under its own heading
+	rows = slices.Clone(rows)
+	slices.SortStableFunc(rows, func(a, b FreqRow) int { return cmp.Compare(a.Freq.bandOrder(), b.Freq.bandOrder()) })
+	grouped, band := len(planBands()) > 1, ""
+	for _, r := range rows {
+		if !r.Freq.Scanned() {
+			continue
+		}
+		if b := r.Freq.band(); grouped && b.Name != band {
+			band = b.Name
+			fmt.Fprintf(w, `            <tr class="freq-band"><th colspan="4" scope="rowgroup">%s</th></tr>
+`, html.EscapeString(l.T(b.Title)))
+		}
+		pct := r.Count * 100 / maxCount
+		barWidth := pct
+		if barWidth < 2 && r.Count > 0 {
+			barWidth = 2
+		}
+		mhz := html.EscapeString(r.Freq.MHz) + `<span class="sr-only"> MHz</span>`
+		if r.Href != "" {
+			mhz = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(r.Href), mhz)
+		}
+		fmt.Fprintf(w, `            <tr class="freq-row">
+                <th scope="row" class="freq-mhz">%s</th>
+                <td class="freq-label">%s</td>
+                <td><div class="freq-bar-container" role="img" aria-label="%s">
+                    <div class="freq-bar" style="width: %d%%; background: %s;" aria-hidden="true">%s</div>
+                </div></td>
+                <td class="freq-count">%d%s</td>
+            </tr>
+`, mhz, html.EscapeString(r.Freq.Label), html.EscapeString(l.Tf("%d%% of the busiest channel", pct)),
+			barWidth, r.Freq.Color, html.EscapeString(r.Devices), r.Count, r.Note)
+	}
+	fmt.Fprintf(w, `            </tbody>
+        </table>
+`)
+}
+
+// renderCategoryBars writes an SVG stacked bar chart with one bar per bucket,
+// each value drawn in its series colour
+func renderCategoryBars(w io.Writer, series [][]float64, colors []string, width, height int) {
+	maxTotal := 0.0
+	for _, bucket := range series {
+		total := 0.0
+		for _, v := range bucket {
+			total += v
+		}
+		maxTotal = max(maxTotal, total)
+	}
+
+	fmt.Fprintf(w, `<svg class="chart" viewBox="0 0 %d %d" preserveAspectRatio="none" width="100%%" height="%d" role="img" aria-label="Bar chart: %d bars, busiest %.1f">`,
+		width, height, height, len(series), maxTotal)
+	if len(series) == 0 || maxTotal == 0 {
+		fmt.Fprintf(w, `</svg>`)
+		return
+	}
+
+	barWidth := float64(width) / float64(len(series))
+	for i, bucket := range series {
+		y := float64(height)
+		for s, v := range bucket {
+			if v == 0 || s >= len(colors) {
+				continue
+			}
+			h := v * float64(height) / maxTotal
+			y -= h
+			fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				float64(i)*barWidth, y, barWidth*0.9, h, colors[s])
+		}
+	}
+	fmt.Fprintf(w, `</svg>`)
+}
+
+// renderRangePicker writes a form choosing a custom date range for the page at
+// action, keeping the hidden parameters; with a range chosen, reset links back
+// to the page's fixed windows
+func renderRangePicker(w io.Writer, l Lang, action string, hidden url.Values, r *DateRange, reset string) {
+	var from, to string
+	if r != nil {
+		from, to = r.From(), r.To()
+	}
+	fmt.Fprintf(w, `        <form class="range-picker" method="get" action="%s">
+`, html.EscapeString(action))
+	for _, k := range slices.Sorted(maps.Keys(hidden)) {
+		fmt.Fprintf(w, `            <input type="hidden" name="%s" value="%s">
+`, html.EscapeString(k), html.EscapeString(hidden.Get(k)))
+	}
+	fmt.Fprintf(w, `            <label>%s <input type="date" name="from" value="%s" required></label>
+            <label>%s <input type="date" name="to" value="%s"></label>
+            <button type="submit">%s</button>
+`, l.T("From"), from, l.T("To"), to, l.T("Show range"))
+	if r != nil {
+		fmt.Fprintf(w, `            <a href="%s" style="color: #00d4ff;">%s</a>
+`, html.EscapeString(reset), l.T("Back to the usual periods"))
+	}
+	fmt.Fprintf(w, `        </form>
+`)
+}
+
+// renderZoomableBars writes a daily bar chart that can be zoomed: dragging
+// across days (or clicking one) opens href with from= and to= set to them.
+// The page includes zoomScript once; the range picker is the keyboard way to
+// the same views.
+func renderZoomableBars(w io.Writer, days []string, series [][]int, href string, width, height int) {
+	fmt.Fprintf(w, `<div class="zoomable" data-days="%s" data-href="%s" title="Drag across days to zoom in">`,
+		strings.Join(days, ","), html.EscapeString(href))
+	renderStackedBars(w, series, width, height)
+	fmt.Fprintf(w, `</div>`)
+}
+
+// zoomScript draws the selection on zoomable charts and follows it
+const zoomScript = `    <script>
+    document.querySelectorAll('.zoomable').forEach(function (box) {
+        var days = box.dataset.days.split(','), from = -1, sel = document.createElement('div');
+        sel.className = 'zoom-select';
+        box.appendChild(sel);
+        function at(e) {
+            var r = box.getBoundingClientRect();
+            return Math.min(days.length - 1, Math.max(0, Math.floor((e.clientX - r.left) / r.width * days.length)));
+        }
+        function show(a, b) {
+            sel.style.left = (Math.min(a, b) / days.length * 100) + '%';
+            sel.style.width = ((Math.abs(a - b) + 1) / days.length * 100) + '%';
+            sel.style.display = 'block';
+        }
+        box.addEventListener('pointerdown', function (e) {
+            from = at(e);
+            box.setPointerCapture(e.pointerId);
+            show(from, from);
+            e.preventDefault();
+        });
+        box.addEventListener('pointermove', function (e) { if (from >= 0) show(from, at(e)); });
+        box.addEventListener('pointercancel', function () { from = -1; sel.style.display = 'none'; });
+        box.addEventListener('pointerup', function (e) {
+            if (from < 0) return;
+            var to = at(e), a = Math.min(from, to), b = Math.max(from, to);
+            from = -1;
+            location.href = box.dataset.href + '&from=' + days[a] + '&to=' + days[b];
+        });
+    });
+    </script>
+`
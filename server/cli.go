@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// command is one subcommand of the server binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because "help" refers back to the list
+	commands = []command{
+		{"serve", "run the server (the default when no command is given)", runServe},
+		{"export", "write uploads as CSV or Parquet", runExport},
+		{"import", "merge uploads from a CSV export, raw log or another lora.db", runImport},
+		{"reprocess", "rebuild a database from the raw upload log", runReprocess},
+		{"prune", "delete data older than a cutoff", runPrune},
+		{"stats", "summarise what the database holds", runStats},
+		{"check", "check the database for corruption and impossible data", runCheck},
+		{"adduser", "create a user or reset their password", runAddUser},
+		{"gen-key", "generate a random device token", runGenKey},
+		{"simulate", "generate synthetic uploads from fake devices", runSimulate},
+		{"bench", "measure ingest and summary throughput", runBench},
+		{"replay", "re-post captured uploads to a server", runReplay},
+		{"tui", "watch a server's detections live in the terminal", runTUI},
+		{"help", "list commands", func([]string) error { printUsage(os.Stdout); return nil }},
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [command] [flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for a command's flags.\n", os.Args[0])
+}
+
+// newFlagSet creates the flag set for a subcommand; args describes its positional arguments
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags] %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runCLI dispatches to a subcommand and returns the exit status. Bare flags
+// (or nothing) mean serve, so existing deployments keep working.
+func runCLI(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				return 1
+			}
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+// openStoreFlags parses the shared database flags for a maintenance command and opens the store
+func openStoreFlags(fs *flag.FlagSet, args []string) error {
+	var cfg Config
+	addDBFlags(fs, &cfg)
+	fs.Parse(args)
+	_, err := openStore(cfg)
+	return err
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "")
+	out := fs.String("o", "-", "output file, - for stdout")
+	device := fs.String("device", "", "only this device")
+	days := fs.Int("days", 0, "only the last N days (0 = everything)")
+	format := fs.String("format", "csv", "csv or parquet")
+	if err := openStoreFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "parquet" {
+		return errors.New("-format must be csv or parquet")
+	}
+	var since time.Time
+	if *days > 0 {
+		since = timeNow().AddDate(0, 0, -*days)
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		var err error
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	n, err := store.exportUploads(context.Background(), w, *format, *device, since)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d uploads\n", n)
+	return nil
+}
+
+func runImport(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("import", "file.csv|uploads.ndjson|lora.db ...")
+	tz := fs.String("tz", "", "timezone the source server ran in, for databases and bare CSV times (default: this server's)")
+	if err := openStoreFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("at least one file required")
+	}
+	loc := time.Local
+	if *tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range fs.Args() {
+		res, err := store.importFile(ctx, path, loc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Printf("%s: imported %d uploads, skipped %d already present\n", path, res.Imported, res.Skipped)
+	}
+	return nil
+}
+
+func runPrune(args []string) error {
+	fs := newFlagSet("prune", "")
+	days := fs.Int("days", 365, "keep this many days of data")
+	vacuum := fs.Bool("vacuum", false, "reclaim disk space afterwards (rewrites the whole file)")
+	if err := openStoreFlags(fs, args); err != nil {
+		return err
+	}
+	if *days < 1 {
+		return errors.New("-days must be at least 1")
+	}
+
+	ctx := context.Background() // An operator is watching; no timeout
+	cutoff := dbTimeAgo(time.Duration(*days) * 24 * time.Hour)
+	for _, t := range timedTables {
+		res, err := store.exec(ctx, `DELETE FROM `+t.table+` WHERE `+t.column+` < ?`, cutoff)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.table, err)
+		}
+		n, _ := res.RowsAffected()
+		fmt.Printf("%-14s %d rows deleted\n", t.table, n)
+	}
+	if *vacuum {
+		if _, err := store.db.ExecContext(ctx, `VACUUM`); err != nil {
+			return err
+		}
+		fmt.Println("Vacuumed")
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("stats", "")
+	var cfg Config
+	addDBFlags(fs, &cfg)
+	fs.Parse(args)
+	dbPath, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(dbPath); err == nil {
+		fmt.Printf("Database: %s (%.1f MB)\n", dbPath, float64(fi.Size())/1e6)
+	}
+	if used, free, err := store.storageUsage(ctx); err == nil {
+		fmt.Printf("Pages:    %.1f MB used, %.1f MB free\n", float64(used)/1e6, float64(free)/1e6)
+	}
+	fmt.Printf("Uploads:  %d\n", store.getTotalUploads(ctx))
+	var hourly, daily int
+	store.queryRow(ctx, `SELECT COUNT(*) FROM upload_rollups WHERE tier = ?`, tierHour).Scan(&hourly)
+	store.queryRow(ctx, `SELECT COUNT(*) FROM upload_rollups WHERE tier = ?`, tierDay).Scan(&daily)
+	fmt.Printf("Rollups:  %d hourly, %d daily\n", hourly, daily)
+	var users int
+	store.queryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&users)
+	fmt.Printf("Users:    %d\n\n", users)
+
+	rows, err := store.query(ctx, `
+		SELECT device_id, COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM uploads GROUP BY device_id ORDER BY device_id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	fmt.Printf("%-24s %8s  %-19s  %-19s\n", "DEVICE", "UPLOADS", "FIRST", "LAST")
+	for rows.Next() {
+		var id, first, last string
+		var n int
+		if err := rows.Scan(&id, &n, &first, &last); err != nil {
+			return err
+		}
+		fmt.Printf("%-24s %8d  %-19s  %-19s\n", id, n,
+			parseDBTime(first).Format("2006-01-02 15:04:05"), parseDBTime(last).Format("2006-01-02 15:04:05"))
+	}
+	return rows.Err()
+}
+
+func runCheck(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("check", "")
+	quick := fs.Bool("quick", false, "PRAGMA quick_check instead of the full integrity_check")
+	repair := fs.Bool("repair", false, "rebuild indexes and delete rows that fail a check")
+	if err := openStoreFlags(fs, args); err != nil {
+		return err
+	}
+
+	mode := "full"
+	if *quick {
+		mode = "quick"
+	}
+	report := store.checkIntegrity(ctx, mode, *repair)
+	if report.Error != "" {
+		return errors.New(report.Error)
+	}
+	fmt.Printf("SQLite %s check: %s (%s)\n", mode, strings.Join(report.SQLite, "; "), report.Duration)
+	for _, p := range report.Problems {
+		action := ""
+		if report.Repaired {
+			action = ", deleted"
+		}
+		fmt.Printf("%-45s %d rows%s\n", p.Check, p.Rows, action)
+	}
+	if !report.OK() {
+		return errors.New("problems found (run with -repair, or restore from a backup if SQLite reports corruption)")
+	}
+	return nil
+}
+
+func runAddUser(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("adduser", "username")
+	role := fs.String("role", roleAdmin, "admin or viewer")
+	if err := openStoreFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("one username required")
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if err := store.setUser(ctx, fs.Arg(0), password, *role); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s user %s\n", *role, fs.Arg(0))
+	return nil
+}
+
+// readPassword prompts twice on a terminal, or reads one line from piped stdin
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Again: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passwords don't match")
+	}
+	return string(first), nil
+}
+
+func runGenKey(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("gen-key", "")
+	device := fs.String("device", "", "also install the key as this device's UDP token")
+	sign := fs.Bool("sign", false, "with -device, install it as the device's upload signing key instead")
+	size := fs.Int("bytes", 24, "random bytes in the key")
+	var cfg Config
+	addDBFlags(fs, &cfg)
+	fs.Parse(args)
+
+	b := make([]byte, *size)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b)
+
+	if *device != "" {
+		if _, err := openStore(cfg); err != nil {
+			return err
+		}
+		if *sign {
+			if err := store.setDeviceSigningKey(ctx, *device, key); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Installed as the upload signing key for %s\n", *device)
+		} else {
+			if len(key) > 255 {
+				return errors.New("key too long for a UDP token (max 255 characters)")
+			}
+			if err := store.setDeviceToken(ctx, *device, key); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Installed as the UDP token for %s\n", *device)
+		}
+	}
+	fmt.Println(key)
+	return nil
+}
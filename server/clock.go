@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// timeNow is the server's idea of the current time for anything it stores or
+// shows. The golden run pins it so rendered pages don't depend on when they
+// were rendered; timeouts, deadlines and backoff use time.Now directly.
+var timeNow = time.Now
+
+// maxClockSkew is how far a device clock may drift from the server before its uploads are refused
+func maxClockSkew() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("MAX_CLOCK_SKEW_SEC")); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// checkClockSkew compares a device-reported epoch time against the server clock.
+// Zero means the device didn't report one, which is always accepted.
+func checkClockSkew(deviceTime int64, now time.Time) error {
+	if deviceTime == 0 {
+		return nil
+	}
+	skew := time.Unix(deviceTime, 0).Sub(now)
+	if skew < -maxClockSkew() || skew > maxClockSkew() {
+		return fmt.Errorf("device clock is off by %s (max %s); sync from /api/time", skew.Round(time.Second), maxClockSkew())
+	}
+	return nil
+}
+
+// noteSkewedUpload logs and alerts on an upload refused for clock skew
+func noteSkewedUpload(ctx context.Context, deviceID string, err error) {
+	log.Printf("Rejected upload from %s: %v", deviceID, err)
+	store.raiseAlert(ctx, deviceID, "clock_skew", err.Error())
+}
+
+// rejectSkewedUpload refuses an upload with 422, telling the device the correct time
+func rejectSkewedUpload(ctx context.Context, w http.ResponseWriter, deviceID string, err error, now time.Time) {
+	noteSkewedUpload(ctx, deviceID, err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "error",
+		"message":     err.Error(),
+		"server_time": now.Unix(),
+	})
+}
+
+// handleAPITime returns the server clock so detectors without an RTC can set theirs.
+// A device can pass its own send time as ?t0= (epoch ms) and compute round-trip delay
+// and offset NTP-style from t0, t1 (received) and t2 (replied).
+func handleAPITime(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	resp := map[string]interface{}{
+		"epoch":    received.Unix(),
+		"epoch_ms": received.UnixMilli(),
+		"iso":      received.Format(time.RFC3339),
+	}
+	if t0, err := strconv.ParseInt(r.URL.Query().Get("t0"), 10, 64); err == nil {
+		resp["t0"] = t0
+		resp["t1"] = received.UnixMilli()
+		resp["t2"] = time.Now().UnixMilli()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
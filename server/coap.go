@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// A minimal CoAP (RFC 7252) server: just enough for detectors to POST CBOR
+// stats to coap://host/upload?token=TOKEN. No observe, no block-wise transfer.
+// As over UDP, the device must have been given a token (see udp.go), since the
+// source address is trivially spoofed.
+
+// CoAP message types
+const (
+	coapCON = 0
+	coapNON = 1
+	coapACK = 2
+	coapRST = 3
+)
+
+// CoAP codes, class<<5 | detail
+const (
+	coapPOST                     = 0x02
+	coapChanged                  = 0x44 // 2.04
+	coapBadRequest               = 0x80 // 4.00
+	coapUnauthorized             = 0x81 // 4.01
+	coapNotFound                 = 0x84 // 4.04
+	coapMethodNotAllowed         = 0x85 // 4.05
+	coapUnsupportedContent       = 0x8F // 4.15
+	coapUnprocessable            = 0x96 // 4.22 (RFC 8132)
+	coapTooManyRequests          = 0x9D // 4.29 (RFC 8516)
+	coapInternalError            = 0xA0 // 5.00
+	coapServiceUnavailable       = 0xA3 // 5.03
+	coapOptionURIPath            = 11
+	coapOptionContentFormat      = 12
+	coapOptionURIQuery           = 15
+	coapContentFormatCBOR        = 60
+	coapPayloadMarker            = 0xFF
+	coapExchangeLifetime         = 247 * time.Second
+	coapMaxDatagram              = 1152 // RFC 7252 section 4.6
+	coapDedupeEntriesBeforePrune = 1024
+)
+
+type coapOption struct {
+	num   int
+	value []byte
+}
+
+type coapMessage struct {
+	typ     byte
+	code    byte
+	msgID   uint16
+	token   []byte
+	options []coapOption
+	payload []byte
+}
+
+// path joins the Uri-Path options
+func (m coapMessage) path() string {
+	p := ""
+	for _, o := range m.options {
+		if o.num == coapOptionURIPath {
+			p += "/" + string(o.value)
+		}
+	}
+	return p
+}
+
+// query returns the value of a Uri-Query option name=value, or "" if absent
+func (m coapMessage) query(name string) string {
+	for _, o := range m.options {
+		if o.num != coapOptionURIQuery {
+			continue
+		}
+		if v, ok := strings.CutPrefix(string(o.value), name+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// contentFormat returns the Content-Format option, or -1 if absent
+func (m coapMessage) contentFormat() int {
+	for _, o := range m.options {
+		if o.num == coapOptionContentFormat {
+			v := 0
+			for _, b := range o.value {
+				v = v<<8 | int(b)
+			}
+			return v
+		}
+	}
+	return -1
+}
+
+// coapExtended reads the extended delta/length that follows an option header nibble
+func coapExtended(nibble int, b []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errors.New("truncated option")
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errors.New("truncated option")
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errors.New("reserved option nibble")
+	}
+	return nibble, b, nil
+}
+
+func parseCoAP(b []byte) (coapMessage, error) {
+	var m coapMessage
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return m, errors.New("not a CoAP v1 message")
+	}
+	m.typ = (b[0] >> 4) & 0x3
+	tkl := int(b[0] & 0xF)
+	m.code = b[1]
+	m.msgID = binary.BigEndian.Uint16(b[2:4])
+	if tkl > 8 || len(b) < 4+tkl {
+		return m, errors.New("bad token length")
+	}
+	m.token = b[4 : 4+tkl]
+
+	rest, num := b[4+tkl:], 0
+	for len(rest) > 0 {
+		if rest[0] == coapPayloadMarker {
+			m.payload = rest[1:]
+			break
+		}
+		delta, length, err := 0, 0, error(nil)
+		head := rest[0]
+		rest = rest[1:]
+		if delta, rest, err = coapExtended(int(head>>4), rest); err != nil {
+			return m, err
+		}
+		if length, rest, err = coapExtended(int(head&0xF), rest); err != nil {
+			return m, err
+		}
+		if len(rest) < length {
+			return m, errors.New("truncated option value")
+		}
+		num += delta
+		m.options = append(m.options, coapOption{num: num, value: rest[:length]})
+		rest = rest[length:]
+	}
+	return m, nil
+}
+
+// encode writes the message; options must be sorted and short (< 13 bytes, delta < 13)
+func (m coapMessage) encode() []byte {
+	b := []byte{1<<6 | m.typ<<4 | byte(len(m.token)), m.code, byte(m.msgID >> 8), byte(m.msgID)}
+	b = append(b, m.token...)
+	num := 0
+	for _, o := range m.options {
+		b = append(b, byte(o.num-num)<<4|byte(len(o.value)))
+		b = append(b, o.value...)
+		num = o.num
+	}
+	if len(m.payload) > 0 {
+		b = append(b, coapPayloadMarker)
+		b = append(b, m.payload...)
+	}
+	return b
+}
+
+// coapServer answers upload requests and remembers recent replies so that
+// retransmitted CONs aren't ingested twice
+type coapServer struct {
+	mu     sync.Mutex
+	seen   map[string]coapSeen
+	nextID uint16
+}
+
+type coapSeen struct {
+	reply []byte
+	at    time.Time
+}
+
+// serveCoAP reads CoAP requests until the connection fails
+func serveCoAP(conn net.PacketConn) error {
+	s := &coapServer{seen: make(map[string]coapSeen), nextID: uint16(time.Now().UnixNano())}
+	buf := make([]byte, coapMaxDatagram)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req, err := parseCoAP(buf[:n])
+		if err != nil {
+			log.Printf("Dropped CoAP message from %s: %v", from, err)
+			continue
+		}
+		if reply := s.handle(req, from); reply != nil {
+			if _, err := conn.WriteTo(reply, from); err != nil {
+				log.Printf("Error replying to CoAP request from %s: %v", from, err)
+			}
+		}
+	}
+}
+
+// handle returns the encoded reply for req, or nil if none is due
+func (s *coapServer) handle(req coapMessage, from net.Addr) []byte {
+	if req.typ == coapACK || req.typ == coapRST {
+		return nil
+	}
+	if req.code == 0 { // CoAP ping
+		return coapMessage{typ: coapRST, msgID: req.msgID}.encode()
+	}
+	key := fmt.Sprintf("%s/%d", from, req.msgID)
+	s.mu.Lock()
+	if prev, ok := s.seen[key]; ok && time.Since(prev.at) < coapExchangeLifetime {
+		s.mu.Unlock()
+		return prev.reply
+	}
+	s.mu.Unlock()
+
+	code, body := coapUpload(req, from)
+	resp := coapMessage{typ: coapACK, code: code, msgID: req.msgID, token: req.token}
+	if req.typ == coapNON {
+		s.mu.Lock()
+		s.nextID++
+		resp.typ, resp.msgID = coapNON, s.nextID
+		s.mu.Unlock()
+	}
+	if body != nil {
+		resp.options = []coapOption{{num: coapOptionContentFormat, value: []byte{coapContentFormatCBOR}}}
+		resp.payload, _ = cbor.Marshal(body)
+	}
+	reply := resp.encode()
+
+	s.mu.Lock()
+	if len(s.seen) >= coapDedupeEntriesBeforePrune {
+		for k, v := range s.seen {
+			if time.Since(v.at) >= coapExchangeLifetime {
+				delete(s.seen, k)
+			}
+		}
+	}
+	s.seen[key] = coapSeen{reply: reply, at: time.Now()}
+	s.mu.Unlock()
+	return reply
+}
+
+// coapUpload ingests a POST /upload request, mirroring the HTTP /upload handler.
+// The CBOR map uses the same keys as the JSON upload.
+func coapUpload(req coapMessage, from net.Addr) (byte, map[string]interface{}) {
+	if req.path() != "/upload" {
+		return coapNotFound, nil
+	}
+	if req.code != coapPOST {
+		return coapMethodNotAllowed, nil
+	}
+	if cf := req.contentFormat(); cf != -1 && cf != coapContentFormatCBOR {
+		return coapUnsupportedContent, nil
+	}
+
+	var stats Stats
+	if err := cbor.Unmarshal(req.payload, &stats); err != nil {
+		log.Printf("Error decoding CoAP CBOR from %s: %v", from, err)
+		return coapBadRequest, map[string]interface{}{"status": "error", "message": "Invalid CBOR"}
+	}
+	stats.Timestamp = timeNow()
+	stats.UploaderIP = "coap:" + from.String()
+	if stats.DeviceID == "" {
+		stats.DeviceID = "unknown"
+	}
+	ctx, cancel := ingestContext()
+	defer cancel()
+	want := store.getDeviceToken(ctx, stats.DeviceID)
+	if want == "" || subtle.ConstantTimeCompare([]byte(req.query("token")), []byte(want)) != 1 {
+		log.Printf("Refusing CoAP upload from %s: invalid token for %s", from, stats.DeviceID)
+		return coapUnauthorized, map[string]interface{}{"status": "error", "message": "invalid token"}
+	}
+	if err := refuseUnsignable(ctx, stats.DeviceID, transportCoAP); err != nil {
+		return coapUnauthorized, map[string]interface{}{"status": "error", "message": err.Error()}
+	}
+	if err := validateStats(&stats); err != nil {
+		return coapUnprocessable, map[string]interface{}{"status": "error", "message": err.Error()}
+	}
+	if err := checkClockSkew(stats.DeviceTime, stats.Timestamp); err != nil {
+		noteSkewedUpload(ctx, stats.DeviceID, err)
+		return coapUnprocessable, map[string]interface{}{
+			"status":      "error",
+			"message":     err.Error(),
+			"server_time": stats.Timestamp.Unix(),
+		}
+	}
+
+	missed, err := ingestStats(ctx, &stats)
+	var quota *quotaError
+	if errors.As(err, &quota) {
+		return coapTooManyRequests, map[string]interface{}{"status": "error", "message": err.Error()}
+	} else if errors.Is(err, errSaveUnavailable) {
+		return coapServiceUnavailable, map[string]interface{}{"status": "error", "message": err.Error()}
+	} else if err != nil {
+		return coapInternalError, map[string]interface{}{"status": "error", "message": err.Error()}
+	}
+	resp := map[string]interface{}{"status": "ok", "ack_id": stats.AckID}
+	if missed > 0 {
+		resp["missed_acks"] = missed
+	}
+	return coapChanged, resp
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Compact binary stats, for links where JSON is too big (LoRaWAN, UDP).
+// All fields big-endian:
+//
+//	0      version (1 or 2)
+//	1-4    uptime seconds
+//	5-8    total detections
+//	9-10   detections per minute
+//	11     current activity %
+//	12     peak activity %
+//
+// Version 1 then has:
+//
+//	13-28  8 x uint16 per-frequency detections (saturating)
+//	29-30  battery millivolts (optional; 0 = not measured)
+//
+// Version 2 is for detectors scanning other than 8 channels:
+//
+//	13     channel count n (1-255)
+//	14-    n x uint16 per-frequency detections (saturating)
+//	then   battery millivolts (optional; 0 = not measured)
+const (
+	compactV1Channels = 8
+	compactHeaderLen  = 13
+)
+
+// decodeCompactStats unpacks a compact stats payload. The caller fills in
+// DeviceID and Timestamp, which the transport supplies.
+func decodeCompactStats(b []byte) (Stats, error) {
+	if len(b) < compactHeaderLen {
+		return Stats{}, fmt.Errorf("payload too short: %d bytes", len(b))
+	}
+	var n, base int
+	switch b[0] {
+	case 1:
+		n, base = compactV1Channels, compactHeaderLen
+	case 2:
+		if len(b) < compactHeaderLen+1 || b[compactHeaderLen] == 0 {
+			return Stats{}, fmt.Errorf("payload has no channel count")
+		}
+		n, base = int(b[compactHeaderLen]), compactHeaderLen+1
+	default:
+		return Stats{}, fmt.Errorf("unknown payload version %d", b[0])
+	}
+	end := base + 2*n
+	if len(b) < end {
+		return Stats{}, fmt.Errorf("payload too short: %d bytes, want %d", len(b), end)
+	}
+
+	st := Stats{
+		Uptime:           int(binary.BigEndian.Uint32(b[1:5])),
+		TotalDetections:  int(binary.BigEndian.Uint32(b[5:9])),
+		DetectionsPerMin: int(binary.BigEndian.Uint16(b[9:11])),
+		CurrentActivity:  int(b[11]),
+		PeakActivity:     int(b[12]),
+		FreqDetections:   make([]int, n),
+	}
+	for i := range st.FreqDetections {
+		off := base + 2*i
+		st.FreqDetections[i] = int(binary.BigEndian.Uint16(b[off : off+2]))
+	}
+	if len(b) >= end+2 {
+		if mv := binary.BigEndian.Uint16(b[end : end+2]); mv > 0 {
+			v := float64(mv) / 1000
+			st.BatteryV = &v
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Detectors given the same co-location name hear the same RF environment, so
+// adding their counts together double-counts it. Aggregates over several
+// detectors (dashboard and group summaries, /api/history, /api/activity and
+// the charts built on it) merge co-located members' figures period by period
+// instead: per server-local hour for summaries, per local day and hour for
+// activity. COLOCATED_MERGE picks how:
+//
+//   - max (the default) keeps the loudest member's figures, the one that heard
+//     the most that period;
+//   - mean averages the members that reported;
+//   - sum adds them up, as if they weren't co-located.
+//
+// Only detections, per-channel counts and scan time are merged. Uploads,
+// averages, peaks and distributions describe what each detector reported and
+// still draw on all of them, and a single detector's pages are unaffected.
+const (
+	mergeMax  = "max"
+	mergeMean = "mean"
+	mergeSum  = "sum"
+)
+
+// colocatedMerge reads COLOCATED_MERGE
+func colocatedMerge() string {
+	switch m := os.Getenv("COLOCATED_MERGE"); m {
+	case mergeMean, mergeSum:
+		return m
+	}
+	return mergeMax
+}
+
+// setDeviceColocated names the set of co-located detectors a detector belongs
+// to; empty takes it out of its set
+func (s *Store) setDeviceColocated(ctx context.Context, deviceID, name string) error {
+	var value interface{}
+	if name != "" {
+		value = name
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, colocated) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET colocated = excluded.colocated
+	`, deviceID, value)
+	return err
+}
+
+// colocatedSets maps each of deviceIDs (every device when nil) that shares a
+// co-location name with another of them to that name. It is empty when the
+// merge policy is sum, so callers need only check its length.
+func (s *Store) colocatedSets(ctx context.Context, deviceIDs []string) map[string]string {
+	sets := make(map[string]string)
+	if colocatedMerge() == mergeSum {
+		return sets
+	}
+	cond, args := deviceFilter(deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, colocated FROM devices
+		WHERE colocated IS NOT NULL AND colocated != '' AND `+cond, args...)
+	if err != nil {
+		log.Printf("Error loading co-located detectors: %v", err)
+		return sets
+	}
+	defer rows.Close()
+	members := make(map[string]int)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			continue
+		}
+		sets[id] = name
+		members[name]++
+	}
+	for id, name := range sets {
+		if members[name] < 2 {
+			delete(sets, id)
+		}
+	}
+	return sets
+}
+
+// mergeColocated combines co-located members' figures for one period by the
+// merge policy; loud says how much a member heard, for max to keep the loudest
+func mergeColocated(members [][]int, loud func([]int) int) []int {
+	var merged []int
+	switch colocatedMerge() {
+	case mergeMax:
+		best := -1
+		for _, m := range members {
+			if l := loud(m); l > best {
+				merged, best = m, l
+			}
+		}
+		return slices.Clone(merged)
+	case mergeMean:
+		for _, m := range members {
+			merged = addCounts(merged, m)
+		}
+		n := len(members)
+		for i := range merged {
+			merged[i] = (merged[i] + n/2) / n
+		}
+		return merged
+	}
+	for _, m := range members {
+		merged = addCounts(merged, m)
+	}
+	return merged
+}
+
+// sumCounts is how much a set of counts heard in all
+func sumCounts(c []int) int {
+	n := 0
+	for _, v := range c {
+		n += v
+	}
+	return n
+}
+
+// colocatedCorrection works out what merging co-located detectors takes off a
+// summary's totals between from and to (stored-format bounds): detections,
+// scan time and per-channel counts, each zero or negative under max and mean
+func (s *Store) colocatedCorrection(ctx context.Context, sets map[string]string, from, to string) (detections, scanTime int, freqs []int) {
+	ids := make([]string, 0, len(sets))
+	for id := range sets {
+		ids = append(ids, id)
+	}
+	since, args := uploadsSince(from, ids)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	// One row per member and hour; daily rollups fall in their midnight hour,
+	// which is where the other members' daily rollups are too
+	rows, err := s.query(ctx, `
+		SELECT device_id, substr(timestamp, 1, 13) AS hour,
+			SUM(total_detections), SUM(uptime_seconds), COALESCE(counts_sum(freqs), '')
+		FROM (`+since+`) WHERE timestamp < ?
+		GROUP BY device_id, hour
+	`, append(args, to)...)
+	if err != nil {
+		log.Printf("Error loading co-located detectors' summaries: %v", err)
+		return 0, 0, nil
+	}
+	defer rows.Close()
+
+	// Each member's figures as detections, scan time, then the channels
+	type period struct{ set, hour string }
+	periods := make(map[period][][]int)
+	for rows.Next() {
+		var id, hour, counts string
+		var det, scan int
+		if err := rows.Scan(&id, &hour, &det, &scan, &counts); err != nil {
+			continue
+		}
+		p := period{sets[id], hour}
+		periods[p] = append(periods[p], append([]int{det, scan}, planCounts(counts)...))
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error loading co-located detectors' summaries: %v", err)
+		return 0, 0, nil
+	}
+
+	var correction []int
+	for _, members := range periods {
+		if len(members) < 2 {
+			continue
+		}
+		merged := mergeColocated(members, func(m []int) int { return m[0] })
+		for _, m := range members {
+			for i := range m {
+				m[i] = -m[i]
+			}
+			merged = addCounts(merged, m)
+		}
+		correction = addCounts(correction, merged)
+	}
+	if correction == nil {
+		return 0, 0, nil
+	}
+	return correction[0], correction[1], correction[2:]
+}
+
+// colocatedCell is one co-located set's share of an activity chart: a local
+// day (-1 when outside the range) and hour (-1 for a daily rollup)
+type colocatedCell struct {
+	set       string
+	day, hour int
+}
+
+// colocatedActivity holds co-located members' activity until it is merged
+type colocatedActivity map[colocatedCell]map[string][]int
+
+// add records a member's counts for a cell
+func (c colocatedActivity) add(cell colocatedCell, deviceID string, counts []int) {
+	members, ok := c[cell]
+	if !ok {
+		members = make(map[string][]int)
+		c[cell] = members
+	}
+	members[deviceID] = addCounts(members[deviceID], counts)
+}
+
+// mergeInto adds each cell's merged counts to the charts
+func (c colocatedActivity) mergeInto(b *ActivityBuckets) {
+	for cell, byDevice := range c {
+		members := make([][]int, 0, len(byDevice))
+		for _, id := range slices.Sorted(maps.Keys(byDevice)) {
+			members = append(members, byDevice[id])
+		}
+		merged := fitCounts(mergeColocated(members, sumCounts), len(frequencies()))
+		for i, n := range merged {
+			if cell.day >= 0 {
+				b.Daily[cell.day][i] += n
+			}
+			if cell.hour >= 0 {
+				b.Hourly[cell.hour][i] += n
+			}
+		}
+	}
+}
+
+// colocatedNote says under a summary how co-located detectors were counted,
+// or nothing when none of deviceIDs are
+func colocatedNote(ctx context.Context, l Lang, deviceIDs []string) string {
+	if len(store.colocatedSets(ctx, deviceIDs)) == 0 {
+		return ""
+	}
+	if colocatedMerge() == mergeMean {
+		return l.T("Co-located detectors count once, as their average each hour")
+	}
+	return l.T("Co-located detectors count once, by whichever heard the most each hour")
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Instances can opt in to sharing band activity with a community server
+// (COMMUNITY_URL), which maps US915 activity heard by everyone who takes part,
+// much as ADS-B aggregators map aircraft. Each interval the instance posts one
+// report per detector that uploaded: totals only, under a pseudonymous station
+// ID, with its location rounded to a grid of about 11 km. Device names, raw
+// uploads and uploader addresses never leave the instance.
+//
+// Any instance can act as the community server (COMMUNITY_SERVER), accepting
+// reports at /api/community/report and showing them on /community.
+const (
+	communityReportPath = "/api/community/report"
+	communityGridDeg    = 0.1 // Location rounding, about 11 km north-south
+	communityMaxBatch   = 500
+	communityMaxCatchUp = 24 * time.Hour // Older unsent periods are skipped
+	communityRetry      = 5 * time.Minute
+)
+
+// Settings keys used by the community client
+const (
+	settingInstanceSecret  = "instance_secret"
+	settingCommunityCursor = "community_pushed_until"
+)
+
+// communityStationPattern is what a station ID must look like
+var communityStationPattern = regexp.MustCompile(`^[0-9a-f]{8,32}$`)
+
+// CommunityReport is one station's totals over one period
+type CommunityReport struct {
+	Station        string    `json:"station"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+	Uploads        int       `json:"uploads"`
+	Detections     int       `json:"detections"`
+	AvgActivity    float64   `json:"avg_activity_pct"`
+	PeakActivity   int       `json:"peak_activity_pct"`
+	FreqDetections []int     `json:"freq_detections"`
+	UptimeSeconds  int       `json:"uptime_seconds"`        // Longest uptime reported in the period
+	Leaderboard    bool      `json:"leaderboard,omitempty"` // Opted in to the leaderboard
+}
+
+// CommunityStation sums a station's reports over a window, for the map
+type CommunityStation struct {
+	Station        string    `json:"station"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+	Reports        int       `json:"reports"`
+	Uploads        int       `json:"uploads"`
+	Detections     int       `json:"detections"`
+	AvgActivity    float64   `json:"avg_activity_pct"`
+	PeakActivity   int       `json:"peak_activity_pct"`
+	FreqDetections []int     `json:"freq_detections"`
+	Hours          float64   `json:"hours_reported"` // Time covered by its reports
+	LastReport     time.Time `json:"last_report"`
+}
+
+// communityURL is the community server this instance reports to (COMMUNITY_URL), or ""
+func communityURL() string {
+	return strings.TrimRight(os.Getenv("COMMUNITY_URL"), "/")
+}
+
+// communityInterval is the period each report covers (COMMUNITY_INTERVAL_MIN)
+func communityInterval() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("COMMUNITY_INTERVAL_MIN")); err == nil && v >= 5 && v <= 24*60 {
+		return time.Duration(v) * time.Minute
+	}
+	return time.Hour
+}
+
+// communityServer reports whether this instance accepts community reports (COMMUNITY_SERVER)
+func communityServer() bool {
+	return envBool("COMMUNITY_SERVER")
+}
+
+// roundToGrid coarsens a coordinate to communityGridDeg
+func roundToGrid(v float64) float64 {
+	return math.Round(v/communityGridDeg) * communityGridDeg
+}
+
+// instanceSecret returns this instance's random secret, creating it on first use
+func (s *Store) instanceSecret(ctx context.Context) ([]byte, error) {
+	if v := s.getSetting(ctx, settingInstanceSecret); v != "" {
+		return hex.DecodeString(v)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := s.setSetting(ctx, settingInstanceSecret, hex.EncodeToString(secret)); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// communityStation is a detector's pseudonym: stable for this instance, but
+// not linkable to its device ID without the instance secret
+func communityStation(secret []byte, deviceID string) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, "community:"+deviceID)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// communityReports totals each detector's uploads in [start, end)
+func (s *Store) communityReports(ctx context.Context, start, end time.Time) ([]CommunityReport, error) {
+	secret, err := s.instanceSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	located := make(map[string]DeviceInfo)
+	for _, d := range s.getDevices(ctx) {
+		if d.Located() {
+			located[d.DeviceID] = d
+		}
+	}
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, COUNT(*), SUM(total_detections), AVG(current_activity_pct), MAX(peak_activity_pct), MAX(uptime_seconds),
+			COALESCE(counts_sum(freqs), '')
+		FROM uploads WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY device_id
+	`, start.In(time.Local).Format("2006-01-02 15:04:05"), end.In(time.Local).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []CommunityReport
+	for rows.Next() {
+		var deviceID, freqs string
+		rep := CommunityReport{PeriodStart: start.UTC(), PeriodEnd: end.UTC(), Leaderboard: leaderboardOptIn()}
+		if err := rows.Scan(&deviceID, &rep.Uploads, &rep.Detections, &rep.AvgActivity, &rep.PeakActivity, &rep.UptimeSeconds,
+			&freqs); err != nil {
+			return nil, err
+		}
+		rep.FreqDetections = planCounts(freqs)
+		rep.Station = communityStation(secret, deviceID)
+		rep.AvgActivity = math.Round(rep.AvgActivity*10) / 10
+		if d, ok := located[deviceID]; ok {
+			lat, lon := roundToGrid(*d.Latitude), roundToGrid(*d.Longitude)
+			rep.Latitude, rep.Longitude = &lat, &lon
+		}
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
+
+// pushCommunityReports posts reports to the community server
+func pushCommunityReports(ctx context.Context, client *http.Client, base string, reports []CommunityReport) error {
+	body, err := json.Marshal(reports)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+communityReportPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// shareWithCommunity reports each finished interval to COMMUNITY_URL, resuming
+// from the last period sent so an outage on either side leaves no gap (up to
+// communityMaxCatchUp). Each period is sent a minute after it ends, to let
+// uploads from its last minute arrive.
+func (s *Store) shareWithCommunity(base string) {
+	ctx := context.Background()
+	client := &http.Client{Timeout: 30 * time.Second}
+	interval := communityInterval()
+	log.Printf("Sharing band activity with %s every %s", base, interval)
+
+	for {
+		now := time.Now()
+		start, err := time.Parse(time.RFC3339, s.getSetting(ctx, settingCommunityCursor))
+		if err != nil || now.Sub(start) > communityMaxCatchUp {
+			start = now.Truncate(interval).Add(-interval)
+		}
+		end := start.Add(interval)
+		if wait := time.Until(end.Add(time.Minute)); wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+
+		reports, err := s.communityReports(ctx, start, end)
+		if err == nil && len(reports) > 0 {
+			for i := 0; i < len(reports) && err == nil; i += communityMaxBatch {
+				err = pushCommunityReports(ctx, client, base, reports[i:min(i+communityMaxBatch, len(reports))])
+			}
+		}
+		if err != nil {
+			log.Printf("Error sharing %s with the community server: %v", start.Format(time.RFC3339), err)
+			time.Sleep(communityRetry)
+			continue
+		}
+		if err := s.setSetting(ctx, settingCommunityCursor, end.Format(time.RFC3339)); err != nil {
+			log.Printf("Error saving community cursor: %v", err)
+		}
+	}
+}
+
+// validateCommunityReport checks a received report and coarsens its location,
+// in case the sender didn't
+func validateCommunityReport(rep *CommunityReport, now time.Time) error {
+	switch {
+	case !communityStationPattern.MatchString(rep.Station):
+		return fmt.Errorf("station must be 8-32 lowercase hex digits")
+	case !rep.PeriodEnd.After(rep.PeriodStart) || rep.PeriodEnd.Sub(rep.PeriodStart) > 24*time.Hour:
+		return fmt.Errorf("period must be between 0 and 24 hours")
+	case rep.PeriodEnd.After(now.Add(maxClockSkew())) || rep.PeriodStart.Before(now.Add(-7*24*time.Hour)):
+		return fmt.Errorf("period must be within the last 7 days")
+	case len(rep.FreqDetections) > maxChannels:
+		return fmt.Errorf("freq_detections can have at most %d entries", maxChannels)
+	case rep.Uploads < 0 || rep.Detections < 0 || rep.UptimeSeconds < 0 || rep.PeakActivity < 0 || rep.PeakActivity > 100 ||
+		rep.AvgActivity < 0 || rep.AvgActivity > 100:
+		return fmt.Errorf("counts must be positive and activity 0-100%%")
+	case (rep.Latitude == nil) != (rep.Longitude == nil):
+		return fmt.Errorf("latitude and longitude go together")
+	}
+	for _, n := range rep.FreqDetections {
+		if n < 0 {
+			return fmt.Errorf("counts must be positive and activity 0-100%%")
+		}
+	}
+	if rep.Latitude != nil {
+		if !validLatLon(*rep.Latitude, *rep.Longitude) {
+			return fmt.Errorf("invalid location")
+		}
+		lat, lon := roundToGrid(*rep.Latitude), roundToGrid(*rep.Longitude)
+		rep.Latitude, rep.Longitude = &lat, &lon
+	}
+	return nil
+}
+
+// saveCommunityReports stores received reports, replacing any resent period
+func (s *Store) saveCommunityReports(ctx context.Context, reports []CommunityReport) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	received := time.Now().Format("2006-01-02 15:04:05")
+	for _, rep := range reports {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO community_reports (station, period_start, period_end, received_at,
+				latitude, longitude, uploads, detections, avg_activity_pct, peak_activity_pct,
+				freqs, uptime_seconds, leaderboard)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, rep.Station, rep.PeriodStart.In(time.Local).Format("2006-01-02 15:04:05"),
+			rep.PeriodEnd.In(time.Local).Format("2006-01-02 15:04:05"), received,
+			rep.Latitude, rep.Longitude, rep.Uploads, rep.Detections, rep.AvgActivity, rep.PeakActivity,
+			encodeCounts(rep.FreqDetections), rep.UptimeSeconds, rep.Leaderboard); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getCommunityStations sums each station's reports that ended in the last
+// hours, placing it at its most recent location
+func (s *Store) getCommunityStations(ctx context.Context, hours int) []CommunityStation {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT station, COUNT(*), SUM(uploads), SUM(detections),
+			SUM(avg_activity_pct * uploads) / MAX(SUM(uploads), 1), MAX(peak_activity_pct),
+			COALESCE(counts_sum(freqs), ''), SUM(julianday(period_end) - julianday(period_start)) * 24, MAX(period_end),
+			(SELECT latitude FROM community_reports l WHERE l.station = c.station ORDER BY period_end DESC LIMIT 1),
+			(SELECT longitude FROM community_reports l WHERE l.station = c.station ORDER BY period_end DESC LIMIT 1)
+		FROM community_reports c WHERE period_end >= ?
+		GROUP BY station ORDER BY SUM(detections) DESC
+	`, dbTimeAgo(time.Duration(hours)*time.Hour))
+	if err != nil {
+		log.Printf("Error loading community stations: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	stations := []CommunityStation{}
+	for rows.Next() {
+		var st CommunityStation
+		var freqs, last string
+		var lat, lon *float64
+		if err := rows.Scan(&st.Station, &st.Reports, &st.Uploads, &st.Detections, &st.AvgActivity, &st.PeakActivity,
+			&freqs, &st.Hours, &last, &lat, &lon); err != nil {
+			log.Printf("Error scanning community station: %v", err)
+			continue
+		}
+		st.FreqDetections = planCounts(freqs)
+		st.AvgActivity = math.Round(st.AvgActivity*10) / 10
+		st.Hours = math.Round(st.Hours*100) / 100
+		st.LastReport = parseDBTime(last)
+		st.Latitude, st.Longitude = lat, lon
+		stations = append(stations, st)
+	}
+	return stations
+}
+
+// communityHours reads ?hours= for the community map, a day by default
+func communityHours(r *http.Request) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*30 {
+		return v
+	}
+	return 24
+}
+
+// handleAPICommunityReport accepts reports from community members:
+// POST /api/community/report with a JSON array of CommunityReport
+func handleAPICommunityReport(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var reports []CommunityReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadBody)).Decode(&reports); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(reports) > communityMaxBatch {
+		http.Error(w, fmt.Sprintf("At most %d reports per request", communityMaxBatch), http.StatusRequestEntityTooLarge)
+		return
+	}
+	now := timeNow()
+	for i := range reports {
+		if err := validateCommunityReport(&reports[i], now); err != nil {
+			http.Error(w, fmt.Sprintf("Report %d: %v", i, err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if err := store.saveCommunityReports(r.Context(), reports); err != nil {
+		log.Printf("Error saving community reports: %v", err)
+		http.Error(w, "Failed to save reports", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "accepted": len(reports)})
+}
+
+// handleAPICommunity lists community stations: GET /api/community?hours=24
+func handleAPICommunity(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.getCommunityStations(r.Context(), communityHours(r)))
+}
+
+// handleCommunity maps band activity reported by community stations
+func handleCommunity(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	hours := communityHours(r)
+	stations := store.getCommunityStations(r.Context(), hours)
+	totals := make([]int, len(frequencies()))
+	located := 0
+	for _, st := range stations {
+		for i, n := range st.FreqDetections {
+			totals[i] += n
+		}
+		if st.Latitude != nil {
+			located++
+		}
+	}
+	colors := make([]string, len(frequencies()))
+	labels := make([]string, len(frequencies()))
+	for i, f := range frequencies() {
+		colors[i], labels[i] = f.Color, f.MHz+" MHz "+f.Label
+	}
+	colorJSON, _ := json.Marshal(colors)
+	labelJSON, _ := json.Marshal(labels)
+	stationJSON, _ := json.Marshal(stations)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "LoRa Community Map", `    <link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+    <script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+    <style>
+        #map { height: 600px; border-radius: 12px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🌎 Community Map</h1>
+    <p class="subtitle">US915 band activity from %d stations (%d on the map), last %d hours</p>
+    <div class="card"><div id="map"></div></div>
+    <div class="card">
+        <h2><span class="icon">📶</span> Band Totals</h2>
+`, len(stations), located, hours)
+	rows := make([]FreqRow, len(frequencies()))
+	for i, f := range frequencies() {
+		rows[i] = FreqRow{Freq: f, Count: totals[i]}
+	}
+	renderFreqTable(w, defaultLang, "Community detections per channel", rows)
+	fmt.Fprintf(w, `    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a> · <a href="/community/leaderboard" style="color: #00d4ff;">🏆 Leaderboard</a> · <a href="/api/community?hours=%d" style="color: #00d4ff;">JSON</a></footer>
+    <script>
+    const colors = %s, labels = %s, stations = %s;
+    const map = L.map('map').setView([39.8, -98.6], 4);
+    L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+        attribution: '&copy; OpenStreetMap contributors', maxZoom: 12
+    }).addTo(map);
+    const busiest = Math.max(1, ...stations.map(s => s.detections));
+    stations.filter(s => s.latitude !== undefined).forEach(s => {
+        // Color by the station's busiest frequency, size by its share of the busiest station
+        const top = s.freq_detections.indexOf(Math.max(...s.freq_detections));
+        const lines = s.freq_detections.map((n, i) => n ? labels[i] + ': ' + n : '').filter(Boolean);
+        L.circleMarker([s.latitude, s.longitude], {
+            radius: 5 + 15 * Math.sqrt(s.detections / busiest), color: colors[top], fillOpacity: 0.5
+        }).addTo(map).bindPopup('Station ' + s.station + '<br>' + s.detections + ' detections, ' +
+            s.avg_activity_pct + '%% average activity<br>' + lines.join('<br>') +
+            '<br>Last report ' + new Date(s.last_report).toLocaleString());
+    });
+    </script>
+`, hours, colorJSON, labelJSON, stationJSON)
+	writePageEnd(w)
+}
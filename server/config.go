@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// defaultDBPath is the volume mount used by the Docker image and Fly.io
+const defaultDBPath = "/data/lora.db"
+
+// memoryDBPath keeps the database in memory, for demos and CI
+const memoryDBPath = ":memory:"
+
+// Config holds runtime settings; each flag defaults to its environment variable
+type Config struct {
+	ListenAddr       string
+	DBPath           string
+	AllowDBFallback  bool
+	ReadOnly         bool
+	RepairDB         bool   // Delete impossible rows found by the startup integrity check
+	UDPAddr          string // Empty disables the UDP listener
+	CoAPAddr         string // Empty disables the CoAP listener
+	SNMPAddr         string // Empty disables the SNMP agent
+	SerialPort       string // Empty disables the serial bridge
+	SerialBaud       int
+	ReplicaURL       string // Litestream replica, e.g. s3://bucket/lora.db
+	LitestreamConfig string // Litestream config file, instead of ReplicaURL
+	LitestreamBin    string
+	TLSCert          string // Empty serves plain HTTP (e.g. behind a TLS-terminating proxy)
+	TLSKey           string
+	TLSClientCA      string // Empty disables client certificates
+	TLSClientAuth    string // clientAuthDevices or clientAuthAll
+	TLSUncertified   string // Comma-separated transports exempt from client certificates
+}
+
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(name))
+	return v
+}
+
+// addDBFlags registers the database flags every subcommand shares
+func addDBFlags(fs *flag.FlagSet, cfg *Config) {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = defaultDBPath
+	}
+	fs.StringVar(&cfg.DBPath, "db", dbPath, "SQLite database file (env DB_PATH)")
+	fs.BoolVar(&cfg.AllowDBFallback, "allow-db-fallback", envBool("ALLOW_DB_FALLBACK"),
+		"use ./lora.db if the database directory can't be created (env ALLOW_DB_FALLBACK)")
+}
+
+// loadConfig parses the serve flags, falling back to environment variables and then defaults
+func loadConfig(args []string) Config {
+	listen := os.Getenv("LISTEN_ADDR")
+	if listen == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		listen = ":" + port
+	}
+
+	serialBaud := 115200
+	if v, err := strconv.Atoi(os.Getenv("SERIAL_BAUD")); err == nil && v > 0 {
+		serialBaud = v
+	}
+
+	litestream := os.Getenv("LITESTREAM_BIN")
+	if litestream == "" {
+		litestream = "litestream"
+	}
+
+	clientAuth := os.Getenv("TLS_CLIENT_AUTH")
+	if clientAuth == "" {
+		clientAuth = clientAuthDevices
+	}
+
+	var cfg Config
+	fs := newFlagSet("serve", "")
+	fs.StringVar(&cfg.ListenAddr, "listen", listen, "address to listen on: :8080, 127.0.0.1:8080, unix:/path/to.sock or systemd (env LISTEN_ADDR, PORT)")
+	addDBFlags(fs, &cfg)
+	fs.BoolVar(&cfg.ReadOnly, "read-only", envBool("READ_ONLY"), "open the database read-only (e.g. a replica) and serve the dashboard and APIs, rejecting uploads and changes (env READ_ONLY)")
+	fs.BoolVar(&cfg.RepairDB, "repair-db", envBool("REPAIR_DB"), "repair what the startup integrity check finds: rebuild indexes and delete impossible rows (env REPAIR_DB)")
+	fs.StringVar(&cfg.UDPAddr, "udp", os.Getenv("UDP_LISTEN"), "also accept compact stats datagrams on this UDP address, e.g. :9999 (env UDP_LISTEN)")
+	fs.StringVar(&cfg.CoAPAddr, "coap", os.Getenv("COAP_LISTEN"), "also accept CoAP POSTs of CBOR stats on this UDP address, e.g. :5683 (env COAP_LISTEN)")
+	fs.StringVar(&cfg.SNMPAddr, "snmp", os.Getenv("SNMP_LISTEN"), "also answer SNMP v1/v2c queries for current activity on this UDP address, e.g. :161; the community is SNMP_COMMUNITY, default public (env SNMP_LISTEN)")
+	fs.StringVar(&cfg.SerialPort, "serial", os.Getenv("SERIAL_PORT"), "also read stats lines from a USB-attached detector, e.g. /dev/ttyUSB0 (env SERIAL_PORT)")
+	fs.IntVar(&cfg.SerialBaud, "serial-baud", serialBaud, "serial port speed (env SERIAL_BAUD)")
+	fs.StringVar(&cfg.ReplicaURL, "replica-url", os.Getenv("REPLICA_URL"), "continuously replicate the database with Litestream to this URL, e.g. s3://bucket/lora.db (env REPLICA_URL)")
+	fs.StringVar(&cfg.LitestreamConfig, "litestream-config", os.Getenv("LITESTREAM_CONFIG"), "Litestream config file to replicate with instead of -replica-url (env LITESTREAM_CONFIG)")
+	fs.StringVar(&cfg.LitestreamBin, "litestream", litestream, "Litestream binary (env LITESTREAM_BIN)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", os.Getenv("TLS_CERT"), "serve HTTPS with this certificate (PEM, env TLS_CERT)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", os.Getenv("TLS_KEY"), "private key for -tls-cert (PEM, env TLS_KEY)")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", os.Getenv("TLS_CLIENT_CA"), "CA bundle that issues detector client certificates; uploads must present one whose CN is their device_id (env TLS_CLIENT_CA)")
+	fs.StringVar(&cfg.TLSClientAuth, "tls-client-auth", clientAuth, "who needs a client certificate: devices (uploads only) or all connections (env TLS_CLIENT_AUTH)")
+	fs.StringVar(&cfg.TLSUncertified, "tls-uncertified", os.Getenv("TLS_UNCERTIFIED"), "transports that may still upload without a client certificate: udp, coap and/or lorawan, comma-separated (env TLS_UNCERTIFIED)")
+	ephemeral := fs.Bool("ephemeral", envBool("EPHEMERAL"), "keep the database in memory; everything is lost on exit (same as -db :memory:, env EPHEMERAL)")
+	fs.Parse(args)
+	if *ephemeral {
+		cfg.DBPath = memoryDBPath
+	}
+	return cfg
+}
+
+// resolveDBPath makes sure the database directory exists. A missing volume used
+// to silently fall back to ./lora.db, which lives inside the container and is
+// lost on redeploy, so that now only happens when explicitly allowed.
+func resolveDBPath(cfg Config) (string, error) {
+	if cfg.DBPath == memoryDBPath || cfg.ReadOnly {
+		return cfg.DBPath, nil // Nothing to create
+	}
+	dir := filepath.Dir(cfg.DBPath)
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		return cfg.DBPath, nil
+	}
+	if !cfg.AllowDBFallback {
+		return "", fmt.Errorf("cannot create database directory %s: %v (mount a volume there, set DB_PATH, or set ALLOW_DB_FALLBACK=1)", dir, err)
+	}
+	log.Printf("WARNING: cannot create database directory %s: %v", dir, err)
+	log.Printf("WARNING: falling back to ./lora.db - data will be lost if this container is replaced")
+	return "./lora.db", nil
+}
+
+// openStore resolves the database path and opens the global store
+func openStore(cfg Config) (string, error) {
+	dbPath, err := resolveDBPath(cfg)
+	if err != nil {
+		return "", fmt.Errorf("database path: %w", err)
+	}
+	if cfg.replicating() && !cfg.ReadOnly && dbPath != memoryDBPath {
+		if err := restoreFromReplica(cfg, dbPath); err != nil {
+			return "", fmt.Errorf("restoring from replica: %w", err)
+		}
+	}
+	db, err := initDB(dbPath, cfg.ReadOnly)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize database: %w", err)
+	}
+	readDB := db
+	switch {
+	case cfg.ReadOnly:
+		sizeReaders(db) // Nothing writes, so the one handle is all readers
+	case dbPath != memoryDBPath:
+		if readDB, err = openReaders(dbPath); err != nil {
+			return "", fmt.Errorf("failed to open database readers: %w", err)
+		}
+	}
+	if ipPrivacy, err = ipPrivacyFromEnv(); err != nil {
+		return "", err
+	}
+	if secrets, err = secretsFromEnv(); err != nil {
+		return "", err
+	}
+	store = &Store{
+		latest:    make(map[string]Stats),
+		db:        db,
+		readDB:    readDB,
+		path:      dbPath,
+		ephemeral: dbPath == memoryDBPath,
+		readOnly:  cfg.ReadOnly,
+		uploads:   make(chan uploadWrite, ingestQueueSize()),
+	}
+	if err := store.sealStoredSecrets(context.Background()); err != nil {
+		return "", fmt.Errorf("stored credentials: %w", err)
+	}
+	if !cfg.ReadOnly {
+		go store.writeUploads()
+	}
+	if store.ephemeral {
+		log.Printf("WARNING: database is in memory - all data will be lost when the server stops")
+	}
+	return dbPath, nil
+}
+
+// replicaRefreshInterval is how often a read-only server rereads the latest
+// uploads, matching the dashboard's refresh
+const replicaRefreshInterval = 30 * time.Second
+
+// openReadOnlyDB opens an existing database without write access, such as a copy
+// replicated from the instance that takes uploads. The schema is left alone, so
+// a replica can't be migrated or pruned from here.
+func openReadOnlyDB(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM uploads`).Scan(&n); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s is not a detector database: %w", path, err)
+	}
+	return db, nil
+}
+
+// followReplica keeps the latest-upload cache and frequency plan current while
+// another instance writes the database
+func (s *Store) followReplica(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.loadLatest(context.Background())
+		s.loadFrequencyPlan(context.Background())
+	}
+}
+
+// readOnlyGuard rejects anything that could modify data when running read-only
+func readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// With NATS ingest, a replica passes uploads on to the writer
+		upload := r.URL.Path == "/upload" && natsIngest != nil
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !readOnlyPosts[r.URL.Path] && !upload {
+			http.Error(w, "Server is in read-only mode", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Timeouts for a single database operation. An upload waits for the one writer
+// connection and then for SQLite's write lock (busy_timeout), so writes get
+// longer than the busy timeout; reads cover the dashboard's year of rollups.
+// Retention, size-cap eviction and integrity checks walk whole tables.
+const (
+	dbWriteTimeout       = 10 * time.Second
+	dbReadTimeout        = 15 * time.Second
+	dbMaintenanceTimeout = 30 * time.Minute
+)
+
+// dbContext bounds one database operation, so a hung query fails instead of
+// pinning its goroutine. The request's own deadline applies too, if sooner, and
+// a client hanging up cancels the query.
+func dbContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, timeout)
+}
+
+// dbReadConns sizes the reader pool (DB_READ_CONNS). Readers don't block each
+// other or the writer in WAL mode, so this only bounds concurrent queries and
+// open file handles. Every upload reads too (acks, battery, baselines), and
+// database/sql hands a freed connection to a random waiter, so a pool smaller
+// than the number of busy uploaders leaves some waiting for seconds.
+func dbReadConns() int {
+	if v, err := strconv.Atoi(os.Getenv("DB_READ_CONNS")); err == nil && v > 0 {
+		return v
+	}
+	return max(16, 4*runtime.NumCPU())
+}
+
+// openReaders opens the read pool next to the writer. query_only makes a write
+// sent to the wrong handle fail loudly instead of racing the writer for the lock.
+func openReaders(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+sqliteParams+"&_pragma=query_only(1)")
+	if err != nil {
+		return nil, err
+	}
+	sizeReaders(db)
+	return db, nil
+}
+
+// sizeReaders applies the reader pool limits, keeping idle connections (and the
+// statements prepared on them) warm between dashboard refreshes
+func sizeReaders(db *sql.DB) {
+	n := dbReadConns()
+	db.SetMaxOpenConns(n)
+	db.SetMaxIdleConns(n)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+}
+
+// stmtKey identifies a prepared statement: the same query is prepared once per handle
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+// prepare returns the statement for query on db, preparing it on first use.
+// database/sql re-prepares it on each pooled connection as needed.
+func (s *Store) prepare(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	key := stmtKey{db, query}
+	if st, ok := s.stmts.Load(key); ok {
+		return st.(*sql.Stmt), nil
+	}
+	st, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if prev, loaded := s.stmts.LoadOrStore(key, st); loaded {
+		st.Close() // Another goroutine prepared it first
+		return prev.(*sql.Stmt), nil
+	}
+	return st, nil
+}
+
+// exec runs a write on the writer connection
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	st, err := s.prepare(ctx, s.db, query)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() { observeQuery(query, time.Since(start)) }()
+	return st.ExecContext(ctx, args...)
+}
+
+// query runs a read on the reader pool, timed until the rows are closed
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*timedRows, error) {
+	st, err := s.prepare(ctx, s.readDB, query)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := st.QueryContext(ctx, args...)
+	if err != nil {
+		observeQuery(query, time.Since(start))
+		return nil, err
+	}
+	return &timedRows{Rows: rows, query: query, start: start}, nil
+}
+
+// queryRow runs a single-row read on the reader pool, timed until it is scanned
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *timedRow {
+	st, err := s.prepare(ctx, s.readDB, query)
+	if err != nil {
+		// sql.Row can't be built with an error; running it unprepared reports the same one from Scan
+		return &timedRow{Row: s.readDB.QueryRowContext(ctx, query, args...)}
+	}
+	return &timedRow{Row: st.QueryRowContext(ctx, args...), query: query, start: time.Now()}
+}
+
+// prepareWrites prepares statements for a transaction on the writer. It has to
+// happen before begin: the transaction holds the only writer connection, so
+// preparing inside it would wait for itself. Bind them with tx.StmtContext.
+func (s *Store) prepareWrites(ctx context.Context, queries ...string) ([]*sql.Stmt, error) {
+	stmts := make([]*sql.Stmt, len(queries))
+	for i, q := range queries {
+		st, err := s.prepare(ctx, s.db, q)
+		if err != nil {
+			return nil, err
+		}
+		stmts[i] = st
+	}
+	return stmts, nil
+}
+
+// begin starts a transaction on the writer connection
+func (s *Store) begin(ctx context.Context) (*sql.Tx, error) {
+	return s.db.BeginTx(ctx, nil)
+}
+
+// getSetting returns a value from the settings table, or "" if it isn't set
+func (s *Store) getSetting(ctx context.Context, key string) string {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var value string
+	s.queryRow(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	return value
+}
+
+// setSetting stores a value in the settings table
+func (s *Store) setSetting(ctx context.Context, key, value string) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	return err
+}
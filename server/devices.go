@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceInfo is per-device metadata that isn't part of each upload
+type DeviceInfo struct {
+	DeviceID  string   `json:"device_id"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Group     string   `json:"group,omitempty"`
+	Timezone  string   `json:"timezone,omitempty"` // IANA name; empty uses SITE_TZ
+
+	// Detectors with the same name hear the same RF environment, and
+	// aggregates merge rather than add their counts (see colocation.go)
+	Colocated string `json:"colocated,omitempty"`
+
+	// Expected seconds between uploads; nil expects one every OFFLINE_AFTER_MIN
+	UploadIntervalSec *int `json:"upload_interval_sec,omitempty"`
+
+	// Per-device battery thresholds; nil uses BATTERY_LOW_V / BATTERY_CRITICAL_V
+	BatteryLowV      *float64 `json:"battery_low_v,omitempty"`
+	BatteryCriticalV *float64 `json:"battery_critical_v,omitempty"`
+
+	// Plan frequency (MHz) of each scan slot, "" for one to ignore; nil means
+	// slot i is plan slot i (see channels.go)
+	Channels []string `json:"channels,omitempty"`
+
+	// Set while the detector is quarantined (see quarantine.go)
+	Quarantined *time.Time `json:"quarantined_since,omitempty"`
+}
+
+// Located reports whether the device has a known position
+func (d DeviceInfo) Located() bool {
+	return d.Latitude != nil && d.Longitude != nil
+}
+
+// setDeviceLocation records where a detector is installed
+func (s *Store) setDeviceLocation(ctx context.Context, deviceID string, lat, lon float64) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, latitude, longitude) VALUES (?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET latitude = excluded.latitude, longitude = excluded.longitude
+	`, deviceID, lat, lon)
+	return err
+}
+
+// setBatteryThresholds overrides a device's low and critical battery voltages
+func (s *Store) setBatteryThresholds(ctx context.Context, deviceID string, low, critical *float64) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, battery_low_v, battery_critical_v) VALUES (?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			battery_low_v = COALESCE(excluded.battery_low_v, battery_low_v),
+			battery_critical_v = COALESCE(excluded.battery_critical_v, battery_critical_v)
+	`, deviceID, low, critical)
+	return err
+}
+
+// scanDevice fills in a DeviceInfo from the nullable devices columns
+func scanDevice(d *DeviceInfo, lat, lon, low, crit sql.NullFloat64, group, tz, colocated, channels, quarantined sql.NullString, interval sql.NullInt64) {
+	d.Group, d.Timezone, d.Colocated = group.String, tz.String, colocated.String
+	if quarantined.Valid {
+		t := parseDBTime(quarantined.String)
+		d.Quarantined = &t
+	}
+	if channels.String != "" {
+		d.Channels = strings.Split(channels.String, ",")
+	}
+	if interval.Valid {
+		v := int(interval.Int64)
+		d.UploadIntervalSec = &v
+	}
+	if lat.Valid && lon.Valid {
+		la, lo := lat.Float64, lon.Float64
+		d.Latitude, d.Longitude = &la, &lo
+	}
+	if low.Valid {
+		v := low.Float64
+		d.BatteryLowV = &v
+	}
+	if crit.Valid {
+		v := crit.Float64
+		d.BatteryCriticalV = &v
+	}
+}
+
+func (s *Store) getDevice(ctx context.Context, deviceID string) DeviceInfo {
+	d := DeviceInfo{DeviceID: deviceID}
+	var lat, lon, low, crit sql.NullFloat64
+	var group, tz, colocated, channels, quarantined sql.NullString
+	var interval sql.NullInt64
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	err := s.queryRow(ctx, `
+		SELECT latitude, longitude, battery_low_v, battery_critical_v, group_name, timezone, colocated, upload_interval_sec, channels, quarantined
+		FROM devices WHERE device_id = ?
+	`, deviceID).Scan(&lat, &lon, &low, &crit, &group, &tz, &colocated, &interval, &channels, &quarantined)
+	if err != nil {
+		return d
+	}
+	scanDevice(&d, lat, lon, low, crit, group, tz, colocated, channels, quarantined, interval)
+	return d
+}
+
+func (s *Store) getDevices(ctx context.Context) []DeviceInfo {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, latitude, longitude, battery_low_v, battery_critical_v, group_name, timezone, colocated, upload_interval_sec, channels, quarantined
+		FROM devices ORDER BY device_id
+	`)
+	if err != nil {
+		log.Printf("Error loading devices: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var devices []DeviceInfo
+	for rows.Next() {
+		var d DeviceInfo
+		var lat, lon, low, crit sql.NullFloat64
+		var group, tz, colocated, channels, quarantined sql.NullString
+		var interval sql.NullInt64
+		if err := rows.Scan(&d.DeviceID, &lat, &lon, &low, &crit, &group, &tz, &colocated, &interval, &channels, &quarantined); err != nil {
+			continue
+		}
+		scanDevice(&d, lat, lon, low, crit, group, tz, colocated, channels, quarantined, interval)
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// validLatLon rejects positions that can't be on Earth
+func validLatLon(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// optionalFloat parses a form value that may be omitted
+func optionalFloat(r *http.Request, name string) (*float64, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// handleAPIDevices lists device metadata (GET) or updates it
+// (POST ?device=ID with lat&lon, battery_low_v / battery_critical_v, timezone,
+// colocated, upload_interval_sec, udp_token, signing_key and/or channels)
+func handleAPIDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPost {
+		deviceID := r.FormValue("device")
+		lat, errLat := optionalFloat(r, "lat")
+		lon, errLon := optionalFloat(r, "lon")
+		low, errLow := optionalFloat(r, "battery_low_v")
+		crit, errCrit := optionalFloat(r, "battery_critical_v")
+		hasLocation := lat != nil && lon != nil && validLatLon(*lat, *lon)
+		hasBattery := low != nil || crit != nil
+		_, hasTimezone := r.Form["timezone"]
+		tz := r.FormValue("timezone")
+		_, hasInterval := r.Form["upload_interval_sec"]
+		interval, errInterval := parseUploadInterval(r.FormValue("upload_interval_sec"))
+		_, hasToken := r.Form["udp_token"]
+		_, hasSigningKey := r.Form["signing_key"]
+		_, hasChannels := r.Form["channels"]
+		_, hasColocated := r.Form["colocated"]
+		if deviceID == "" || errLat != nil || errLon != nil || errLow != nil || errCrit != nil || errInterval != nil ||
+			(lat != nil) != (lon != nil) || (lat != nil && !hasLocation) ||
+			(!hasLocation && !hasBattery && !hasTimezone && !hasInterval && !hasToken && !hasSigningKey && !hasChannels && !hasColocated) {
+			http.Error(w, "device and lat+lon, battery_low_v/battery_critical_v, timezone, colocated, upload_interval_sec, udp_token, signing_key and/or channels required", http.StatusBadRequest)
+			return
+		}
+		channels, err := parseChannels(r.FormValue("channels"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if tz != "" {
+			if err := validTimezone(tz); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if hasLocation {
+			if err := store.setDeviceLocation(ctx, deviceID, *lat, *lon); err != nil {
+				log.Printf("Error saving device location: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasBattery {
+			if err := store.setBatteryThresholds(ctx, deviceID, low, crit); err != nil {
+				log.Printf("Error saving battery thresholds: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasTimezone {
+			if err := store.setDeviceTimezone(ctx, deviceID, tz); err != nil {
+				log.Printf("Error saving device timezone: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasColocated {
+			if err := store.setDeviceColocated(ctx, deviceID, strings.TrimSpace(r.FormValue("colocated"))); err != nil {
+				log.Printf("Error saving device co-location: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasInterval {
+			if err := store.setUploadInterval(ctx, deviceID, interval); err != nil {
+				log.Printf("Error saving upload interval: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasToken {
+			if err := store.setDeviceToken(ctx, deviceID, r.FormValue("udp_token")); err != nil {
+				log.Printf("Error saving device token: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasSigningKey {
+			if err := store.setDeviceSigningKey(ctx, deviceID, r.FormValue("signing_key")); err != nil {
+				log.Printf("Error saving device signing key: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		if hasChannels {
+			if err := store.setDeviceChannels(ctx, deviceID, channels); err != nil {
+				log.Printf("Error saving device channels: %v", err)
+				http.Error(w, "Failed to save device", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.getDevice(ctx, deviceID))
+		return
+	}
+
+	devices := store.getDevices(ctx)
+	if devices == nil {
+		devices = []DeviceInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
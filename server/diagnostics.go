@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Diagnostics show where the server spends its time as data grows. Every
+// request is timed per route (the pattern it was registered under, so
+// /group/{name} is one row) into a latency histogram, and every statement run
+// through exec, query and queryRow is timed per query. A query that takes
+// SLOW_QUERY_MS or longer is logged with its SQL and kept in a list of recent
+// slow queries. Statements run inside transactions (upload batches, retention,
+// imports) aren't timed.
+//
+// Figures are since startup and kept in memory; /admin/diagnostics shows them
+// and /api/admin/diagnostics returns them as JSON. A query's time runs until
+// its rows are closed or scanned (see timedRows), so it includes stepping
+// through them, and whatever the caller does between rows.
+const (
+	slowQueryKeep    = 50  // Recent slow queries kept
+	slowQueryShow    = 300 // Characters of SQL kept per slow query
+	diagnosticsShown = 25  // Queries listed by total time
+)
+
+// latencyBuckets are the histogram's upper bounds in milliseconds; the last
+// bucket counts everything slower
+var latencyBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// unmeasured are routes whose requests last as long as the client stays
+var unmeasured = map[string]bool{
+	"/api/stream": true,
+}
+
+// slowQueryThreshold is SLOW_QUERY_MS as a duration; 0 turns the log off
+func slowQueryThreshold() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("SLOW_QUERY_MS")); err == nil && v >= 0 {
+		return time.Duration(v) * time.Millisecond
+	}
+	return 500 * time.Millisecond
+}
+
+// Latency is one histogram's summary; percentiles are bucket upper bounds
+type Latency struct {
+	Count   int     `json:"count"`
+	TotalMs float64 `json:"total_ms"`
+	MeanMs  float64 `json:"mean_ms"`
+	P50Ms   float64 `json:"p50_ms"`
+	P95Ms   float64 `json:"p95_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	Buckets []int   `json:"buckets"` // Counts per latencyBuckets bound, then slower
+}
+
+// histogram accumulates latencies
+type histogram struct {
+	count   int
+	total   time.Duration
+	max     time.Duration
+	buckets []int
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]int, len(latencyBuckets)+1)
+	}
+	h.count++
+	h.total += d
+	h.max = max(h.max, d)
+	ms := float64(d) / float64(time.Millisecond)
+	i := sort.SearchFloat64s(latencyBuckets, ms)
+	h.buckets[i]++
+}
+
+// summary estimates percentiles as the bound of the bucket they fall in,
+// capped at the slowest seen
+func (h *histogram) summary() Latency {
+	maxMs := float64(h.max) / float64(time.Millisecond)
+	l := Latency{Count: h.count, TotalMs: float64(h.total) / float64(time.Millisecond), MaxMs: maxMs,
+		Buckets: append([]int(nil), h.buckets...)}
+	if h.count == 0 {
+		return l
+	}
+	l.MeanMs = l.TotalMs / float64(h.count)
+	quantile := func(q float64) float64 {
+		want, seen := q*float64(h.count), 0
+		for i, n := range h.buckets {
+			seen += n
+			if float64(seen) >= want {
+				if i < len(latencyBuckets) {
+					return min(latencyBuckets[i], maxMs)
+				}
+				break
+			}
+		}
+		return maxMs
+	}
+	l.P50Ms, l.P95Ms, l.P99Ms = quantile(0.5), quantile(0.95), quantile(0.99)
+	return l
+}
+
+// EndpointStats is one route's requests, for /api/admin/diagnostics
+type EndpointStats struct {
+	Route  string `json:"route"`  // Method and pattern
+	Errors int    `json:"errors"` // 5xx responses
+	Latency
+}
+
+// QueryStats is one query's executions
+type QueryStats struct {
+	Query string `json:"query"`
+	Latency
+}
+
+// SlowQuery is one execution over the threshold
+type SlowQuery struct {
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"duration_ms"`
+	Query      string    `json:"query"`
+}
+
+// diagnostics holds the figures since startup
+var diagnostics = struct {
+	sync.Mutex
+	started   time.Time
+	endpoints map[string]*histogram
+	errors    map[string]int
+	queries   map[string]*histogram
+	slow      []SlowQuery // Oldest first, at most slowQueryKeep
+	slowTotal int
+	threshold time.Duration
+}{
+	started:   time.Now(),
+	endpoints: make(map[string]*histogram),
+	errors:    make(map[string]int),
+	queries:   make(map[string]*histogram),
+	threshold: slowQueryThreshold(),
+}
+
+// compactSQL squeezes a query's whitespace onto one line
+func compactSQL(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// observeQuery times one statement, logging it if it was slow
+func observeQuery(query string, d time.Duration) {
+	diagnostics.Lock()
+	defer diagnostics.Unlock()
+	h := diagnostics.queries[query]
+	if h == nil {
+		h = &histogram{}
+		diagnostics.queries[query] = h
+	}
+	h.observe(d)
+	if diagnostics.threshold == 0 || d < diagnostics.threshold {
+		return
+	}
+	sql := compactSQL(query)
+	if len(sql) > slowQueryShow {
+		sql = sql[:slowQueryShow] + "…"
+	}
+	log.Printf("Slow query (%d ms): %s", d.Milliseconds(), sql)
+	diagnostics.slowTotal++
+	diagnostics.slow = append(diagnostics.slow, SlowQuery{Time: time.Now(), DurationMs: float64(d) / float64(time.Millisecond), Query: sql})
+	if len(diagnostics.slow) > slowQueryKeep {
+		diagnostics.slow = diagnostics.slow[len(diagnostics.slow)-slowQueryKeep:]
+	}
+}
+
+// timedRows times a query until its rows are closed, by Close or by Next
+// running out, so the time spent stepping through them is counted
+type timedRows struct {
+	*sql.Rows
+	query string
+	start time.Time
+	done  bool
+}
+
+func (r *timedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.observe()
+	return false
+}
+
+func (r *timedRows) Close() error {
+	err := r.Rows.Close()
+	r.observe()
+	return err
+}
+
+func (r *timedRows) observe() {
+	if !r.done {
+		r.done = true
+		observeQuery(r.query, time.Since(r.start))
+	}
+}
+
+// timedRow times a single-row query until it is scanned; one that failed to
+// prepare has no query and isn't timed
+type timedRow struct {
+	*sql.Row
+	query string
+	start time.Time
+}
+
+func (r *timedRow) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	if r.query != "" {
+		observeQuery(r.query, time.Since(r.start))
+	}
+	return err
+}
+
+// statusRecorder notes the status a handler sent
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush the live stream through the recorder
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// metricsGuard times each request by the route it matched. It has to wrap the
+// mux itself, which sets the pattern on the request it's handed.
+func metricsGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if r.Pattern == "" || unmeasured[r.Pattern] {
+			return
+		}
+		route := r.Method + " " + r.Pattern
+		diagnostics.Lock()
+		defer diagnostics.Unlock()
+		h := diagnostics.endpoints[route]
+		if h == nil {
+			h = &histogram{}
+			diagnostics.endpoints[route] = h
+		}
+		h.observe(time.Since(start))
+		if rec.status >= 500 {
+			diagnostics.errors[route]++
+		}
+	})
+}
+
+// DiagnosticsReport is what /api/admin/diagnostics returns
+type DiagnosticsReport struct {
+	Since           time.Time       `json:"since"`
+	BoundsMs        []float64       `json:"bounds_ms"`
+	Endpoints       []EndpointStats `json:"endpoints"` // Slowest in total first
+	Queries         []QueryStats    `json:"queries"`   // The diagnosticsShown slowest in total
+	SlowThresholdMs int64           `json:"slow_threshold_ms"`
+	SlowQueries     int             `json:"slow_queries"` // Since startup
+	RecentSlow      []SlowQuery     `json:"recent_slow"`  // Newest first
+}
+
+// diagnosticsReport summarizes the figures since startup
+func diagnosticsReport() DiagnosticsReport {
+	diagnostics.Lock()
+	defer diagnostics.Unlock()
+	rep := DiagnosticsReport{
+		Since:           diagnostics.started,
+		BoundsMs:        latencyBuckets,
+		Endpoints:       []EndpointStats{},
+		Queries:         []QueryStats{},
+		SlowThresholdMs: diagnostics.threshold.Milliseconds(),
+		SlowQueries:     diagnostics.slowTotal,
+		RecentSlow:      []SlowQuery{},
+	}
+	for route, h := range diagnostics.endpoints {
+		rep.Endpoints = append(rep.Endpoints, EndpointStats{Route: route, Errors: diagnostics.errors[route], Latency: h.summary()})
+	}
+	sort.Slice(rep.Endpoints, func(i, j int) bool { return rep.Endpoints[i].TotalMs > rep.Endpoints[j].TotalMs })
+	for query, h := range diagnostics.queries {
+		rep.Queries = append(rep.Queries, QueryStats{Query: compactSQL(query), Latency: h.summary()})
+	}
+	sort.Slice(rep.Queries, func(i, j int) bool { return rep.Queries[i].TotalMs > rep.Queries[j].TotalMs })
+	if len(rep.Queries) > diagnosticsShown {
+		rep.Queries = rep.Queries[:diagnosticsShown]
+	}
+	for i := len(diagnostics.slow) - 1; i >= 0; i-- {
+		rep.RecentSlow = append(rep.RecentSlow, diagnostics.slow[i])
+	}
+	return rep
+}
+
+// handleAPIDiagnostics returns request and query timings:
+// GET /api/admin/diagnostics (admin only once an admin user exists)
+func handleAPIDiagnostics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnosticsReport())
+}
+
+// handleDiagnostics shows request and query timings: GET /admin/diagnostics
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	rep := diagnosticsReport()
+	ms := func(v float64) string {
+		if v >= 100 {
+			return fmt.Sprintf("%.0f", v)
+		}
+		return fmt.Sprintf("%.1f", v)
+	}
+	row := func(w io.Writer, name string, l Latency, extra string) {
+		fmt.Fprintf(w, `            <tr><td>%s</td><td>%d</td>%s<td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>
+`, name, l.Count, extra, ms(l.MeanMs), ms(l.P50Ms), ms(l.P95Ms), ms(l.P99Ms), ms(l.MaxMs), ms(l.TotalMs/1000))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Diagnostics", `    <style>
+        .admin-table td, .admin-table th { padding: 4px 12px 4px 0; text-align: right; }
+        .admin-table td:first-child, .admin-table th:first-child { text-align: left; }
+        .admin-table code { font-size: 0.85em; white-space: pre-wrap; word-break: break-all; }
+        .admin-bad { color: #ff5252; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🩺 Diagnostics</h1>
+    <p class="subtitle">Since %s · times in ms, percentiles to the nearest histogram bucket</p>
+    <div class="card">
+        <h2>Requests</h2>
+        <table class="admin-table">
+            <tr><th>Route</th><th>Requests</th><th>5xx</th><th>Mean</th><th>p50</th><th>p95</th><th>p99</th><th>Max</th><th>Total s</th></tr>
+`, rep.Since.Format("2006-01-02 15:04:05"))
+	for _, e := range rep.Endpoints {
+		errors := fmt.Sprintf("<td>%d</td>", e.Errors)
+		if e.Errors > 0 {
+			errors = fmt.Sprintf(`<td class="admin-bad">%d</td>`, e.Errors)
+		}
+		row(w, html.EscapeString(e.Route), e.Latency, errors)
+	}
+	fmt.Fprintf(w, `        </table>
+    </div>
+    <div class="card">
+        <h2>Queries by total time</h2>
+        <table class="admin-table">
+            <tr><th>Query</th><th>Runs</th><th>Mean</th><th>p50</th><th>p95</th><th>p99</th><th>Max</th><th>Total s</th></tr>
+`)
+	for _, q := range rep.Queries {
+		row(w, "<code>"+html.EscapeString(q.Query)+"</code>", q.Latency, "")
+	}
+	fmt.Fprintf(w, `        </table>
+    </div>
+    <div class="card">
+        <h2>Slow queries</h2>
+`)
+	switch {
+	case rep.SlowThresholdMs == 0:
+		fmt.Fprintf(w, `        <p>The slow query log is off (SLOW_QUERY_MS=0).</p>
+`)
+	case len(rep.RecentSlow) == 0:
+		fmt.Fprintf(w, `        <p>No query has taken %d ms or longer.</p>
+`, rep.SlowThresholdMs)
+	default:
+		fmt.Fprintf(w, `        <p>%d queries took %d ms or longer; the latest %d:</p>
+        <table class="admin-table">
+            <tr><th>Time</th><th>ms</th><th>Query</th></tr>
+`, rep.SlowQueries, rep.SlowThresholdMs, len(rep.RecentSlow))
+		for _, q := range rep.RecentSlow {
+			fmt.Fprintf(w, `            <tr><td>%s</td><td>%s</td><td style="text-align: left;"><code>%s</code></td></tr>
+`, q.Time.Format("2006-01-02 15:04:05"), ms(q.DurationMs), html.EscapeString(q.Query))
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+    <footer><a href="/admin" style="color: #00d4ff;">← Admin</a></footer>
+`)
+	writePageEnd(w)
+}
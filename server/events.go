@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DetectionEvent is a single CAD detection reported by a detector.
+// Devices with a clock send Time (unix seconds); devices without one send
+// Ago, the number of seconds before the upload that the detection happened.
+// Detectors that demodulate the header can add the spreading factor and
+// bandwidth (kHz), and the payload length in bytes; out-of-range values are dropped.
+type DetectionEvent struct {
+	Time      int64    `json:"time,omitempty"`
+	Ago       int64    `json:"ago,omitempty"`
+	FreqIndex int      `json:"freq_index"`
+	RSSI      *float64 `json:"rssi,omitempty"`
+	SF        *int     `json:"sf,omitempty"`
+	BW        *float64 `json:"bw,omitempty"`
+	Len       *int     `json:"len,omitempty"`
+}
+
+// MinuteBin is a pre-aggregated minute of per-frequency detection counts
+type MinuteBin struct {
+	Time   int64 `json:"time,omitempty"`
+	Ago    int64 `json:"ago,omitempty"`
+	Counts []int `json:"counts"`
+}
+
+// EventUpload is the payload accepted by /events
+type EventUpload struct {
+	DeviceID   string           `json:"device_id"`
+	DeviceTime int64            `json:"device_time,omitempty"` // Device clock when sending, checked for skew
+	Events     []DetectionEvent `json:"events"`
+	Bins       []MinuteBin      `json:"bins"`
+}
+
+// StoredEvent is a detection event as persisted in the events table
+type StoredEvent struct {
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	FreqIndex int       `json:"freq_index"`
+	RSSI      *float64  `json:"rssi,omitempty"`
+}
+
+// maxEventsPerUpload caps a single /events request
+const maxEventsPerUpload = 10000
+
+// eventTime resolves a device-reported time against the server receive time
+func eventTime(t, ago int64, received time.Time) time.Time {
+	if t > 0 {
+		return time.Unix(t, 0)
+	}
+	return received.Add(-time.Duration(ago) * time.Second)
+}
+
+// saveEvents stores individual events and folds them, plus any bins, into minute_bins
+func (s *Store) saveEvents(ctx context.Context, up EventUpload, received time.Time) (int, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	stmts, err := s.prepareWrites(ctx,
+		`INSERT INTO events (device_id, timestamp, freq_index, rssi, sf, bw, payload_len) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		addMinuteCountSQL)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	insert, bin := tx.StmtContext(ctx, stmts[0]), tx.StmtContext(ctx, stmts[1])
+
+	saved := 0
+	for _, e := range up.Events {
+		if e.FreqIndex < 0 || e.FreqIndex >= maxChannels {
+			continue
+		}
+		ts := eventTime(e.Time, e.Ago, received)
+		sf, bw := e.modulation()
+		_, err := insert.ExecContext(ctx, up.DeviceID, ts.Format("2006-01-02 15:04:05"), e.FreqIndex, e.RSSI, sf, bw, e.payloadLen())
+		if err != nil {
+			return 0, err
+		}
+		if err := addMinuteCount(ctx, bin, up.DeviceID, ts, e.FreqIndex, 1); err != nil {
+			return 0, err
+		}
+		saved++
+	}
+
+	for _, b := range up.Bins {
+		ts := eventTime(b.Time, b.Ago, received)
+		for i, c := range b.Counts {
+			if i >= maxChannels || c <= 0 {
+				continue
+			}
+			if err := addMinuteCount(ctx, bin, up.DeviceID, ts, i, c); err != nil {
+				return 0, err
+			}
+			saved += c
+		}
+	}
+
+	return saved, tx.Commit()
+}
+
+// addMinuteCountSQL increments a per-minute bin, creating it if needed
+const addMinuteCountSQL = `
+	INSERT INTO minute_bins (device_id, minute, freq_index, count) VALUES (?, ?, ?, ?)
+	ON CONFLICT(device_id, minute, freq_index) DO UPDATE SET count = count + excluded.count
+`
+
+// addMinuteCount increments the per-minute bin containing ts, using a
+// statement prepared from addMinuteCountSQL
+func addMinuteCount(ctx context.Context, stmt *sql.Stmt, deviceID string, ts time.Time, freqIndex, count int) error {
+	_, err := stmt.ExecContext(ctx, deviceID, ts.Truncate(time.Minute).Format("2006-01-02 15:04:05"), freqIndex, count)
+	return err
+}
+
+// getMinuteSeries returns per-minute, per-frequency counts for the last n
+// minutes, oldest first. Minutes the device was offline are nil.
+func (s *Store) getMinuteSeries(ctx context.Context, deviceID string, minutes int) ([]time.Time, [][]int) {
+	end := timeNow().Truncate(time.Minute)
+	start := end.Add(-time.Duration(minutes-1) * time.Minute)
+
+	times := make([]time.Time, minutes)
+	series := make([][]int, minutes)
+	bounds := make([]time.Time, minutes+1)
+	for i := range bounds {
+		bounds[i] = start.Add(time.Duration(i) * time.Minute)
+	}
+	online := coverage(s.onlineSpans(ctx, []string{deviceID}, bounds[0], bounds[minutes]), bounds)
+	for i := range times {
+		times[i] = bounds[i]
+		series[i] = make([]int, len(frequencies()))
+	}
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT minute, freq_index, count FROM minute_bins
+		WHERE device_id = ? AND minute >= ?
+	`, deviceID, start.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading minute bins: %v", err)
+		return times, series
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ts string
+		var idx, count int
+		if err := rows.Scan(&ts, &idx, &count); err != nil {
+			continue
+		}
+		i := int(parseDBTime(ts).Sub(start) / time.Minute)
+		if i >= 0 && i < minutes && idx >= 0 && idx < len(frequencies()) {
+			series[i][idx] += count
+		}
+	}
+	for i, bucket := range series {
+		if !online[i] && slices.Max(bucket) == 0 {
+			series[i] = nil
+		}
+	}
+	return times, series
+}
+
+// hasMinuteData reports whether a device has sent any binned data in the window
+func (s *Store) hasMinuteData(ctx context.Context, deviceID string, minutes int) bool {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var n int
+	s.queryRow(ctx, `SELECT COUNT(*) FROM minute_bins WHERE device_id = ? AND minute >= ?`,
+		deviceID, timeNow().Add(-time.Duration(minutes)*time.Minute).Format("2006-01-02 15:04:05")).Scan(&n)
+	return n > 0
+}
+
+// getEvents returns raw events for a device in a time range, oldest first
+func (s *Store) getEvents(ctx context.Context, deviceID string, since, until time.Time) []StoredEvent {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, timestamp, freq_index, rssi FROM events
+		WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp
+	`, deviceID, since.Format("2006-01-02 15:04:05"), until.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading events: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var events []StoredEvent
+	for rows.Next() {
+		var e StoredEvent
+		var ts string
+		var rssi sql.NullFloat64
+		if err := rows.Scan(&e.DeviceID, &ts, &e.FreqIndex, &rssi); err != nil {
+			continue
+		}
+		e.Timestamp = parseDBTime(ts)
+		if rssi.Valid {
+			v := rssi.Float64
+			e.RSSI = &v
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := readUploadBody(w, r)
+	if err != nil {
+		noteAbuse(r, "oversized body")
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var up EventUpload
+	if err := json.Unmarshal(body, &up); err != nil {
+		log.Printf("Error decoding events JSON: %v", err)
+		noteAbuse(r, "invalid JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if up.DeviceID == "" {
+		up.DeviceID = "unknown"
+	}
+	if err := validateEvents(up); err != nil {
+		noteAbuse(r, err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := verifyUpload(ctx, r, up.DeviceID, body); err != nil {
+		noteAbuse(r, err.Error())
+		rejectUnverifiedUpload(ctx, w, up.DeviceID, err)
+		return
+	}
+	if len(up.Events)+len(up.Bins) > maxEventsPerUpload {
+		http.Error(w, fmt.Sprintf("At most %d events per upload", maxEventsPerUpload), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	now := timeNow()
+	if err := checkClockSkew(up.DeviceTime, now); err != nil {
+		rejectSkewedUpload(ctx, w, up.DeviceID, err, now)
+		return
+	}
+	// Absolute event times from the future mean the clock was wrong when they were recorded
+	for _, e := range up.Events {
+		if e.Time > 0 && time.Unix(e.Time, 0).After(now.Add(maxClockSkew())) {
+			rejectSkewedUpload(ctx, w, up.DeviceID, fmt.Errorf("event time %d is in the future; sync from /api/time", e.Time), now)
+			return
+		}
+	}
+	for _, b := range up.Bins {
+		if b.Time > 0 && time.Unix(b.Time, 0).After(now.Add(maxClockSkew())) {
+			rejectSkewedUpload(ctx, w, up.DeviceID, fmt.Errorf("bin time %d is in the future; sync from /api/time", b.Time), now)
+			return
+		}
+	}
+
+	if err := quotas.admit(ctx, up.DeviceID); err != nil {
+		rejectFailedSave(w, err)
+		return
+	}
+	if store.getDevice(ctx, up.DeviceID).Quarantined != nil {
+		if err := store.holdUpload(ctx, up.DeviceID, heldEvents, now, up); err != nil {
+			log.Printf("Error holding events: %v", err)
+			http.Error(w, "Failed to save events", http.StatusInternalServerError)
+			return
+		}
+		quotas.charge(ctx, up.DeviceID, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "saved": 0})
+		return
+	}
+	up = store.channelMap(ctx, up.DeviceID).events(up)
+	saved, err := store.saveEvents(ctx, up, now)
+	if err != nil {
+		log.Printf("Error saving events: %v", err)
+		http.Error(w, "Failed to save events", http.StatusInternalServerError)
+		return
+	}
+	quotas.charge(ctx, up.DeviceID, 1)
+	log.Printf("Events from %s: %d detections (%d events, %d bins)", up.DeviceID, saved, len(up.Events), len(up.Bins))
+	mqtt.detectedEvents(up, now)
+	live.detectedEvents(up, now)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"saved":  saved,
+	})
+}
+
+// handleAPIMinutes returns minute-resolution counts: GET /api/minutes?device=ID&minutes=60
+func handleAPIMinutes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	minutes := 60
+	if v, err := strconv.Atoi(r.URL.Query().Get("minutes")); err == nil && v > 0 && v <= 1440 {
+		minutes = v
+	}
+
+	times, series := store.getMinuteSeries(ctx, deviceID, minutes)
+	out := make([]map[string]interface{}, len(times))
+	for i := range times {
+		out[i] = map[string]interface{}{
+			"minute": times[i].UTC().Format(time.RFC3339),
+			"counts": series[i],
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_id": deviceID,
+		"minutes":   out,
+	})
+}
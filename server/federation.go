@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Federation shows several instances (say home and cabin) on one dashboard
+// without sharing a database. Each instance summarizes its detectors and signs
+// the summary with the shared FEDERATION_KEY, using the same HMAC scheme as
+// signed uploads (see signing.go). An instance collects its peers' summaries by
+// pulling them from FEDERATION_PEERS, or by having them pushed to it by peers
+// that list it in FEDERATION_PUSH (for sites behind NAT), and shows them all
+// on /sites. Summaries are kept in memory; they refill within one interval of
+// a restart.
+const federationPath = "/api/federation/summary"
+
+// federationStaleAfter marks a site whose last summary is older than this
+var federationStaleAfter = 10 * time.Minute
+
+// SiteSummary is what an instance tells its peers about its detectors
+type SiteSummary struct {
+	Site        string       `json:"site"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Devices     []SiteDevice `json:"devices"`
+	Leaderboard bool         `json:"leaderboard,omitempty"` // Devices carry pseudonyms for the leaderboard
+}
+
+// SiteDevice is one detector's latest upload and last 24 hours
+type SiteDevice struct {
+	DeviceID          string    `json:"device_id"`
+	LastSeen          time.Time `json:"last_seen"`
+	DetectionsPerMin  int       `json:"detections_per_min"`
+	CurrentActivity   int       `json:"current_activity_pct"`
+	UptimeSeconds     int       `json:"uptime_seconds"`
+	Pseudonym         string    `json:"pseudonym,omitempty"`
+	Latitude          *float64  `json:"latitude,omitempty"`
+	Longitude         *float64  `json:"longitude,omitempty"`
+	Uploads24h        int       `json:"uploads_24h"`
+	Detections24h     int       `json:"detections_24h"`
+	FreqDetections24h []int     `json:"freq_detections_24h"`
+}
+
+// federatedSite is the last summary heard from a peer
+type federatedSite struct {
+	Summary  *SiteSummary `json:"summary,omitempty"`
+	Received time.Time    `json:"received,omitempty"`
+	Error    string       `json:"error,omitempty"` // Why the last pull failed
+}
+
+// federation holds peers' summaries by site name
+var federation = struct {
+	sync.Mutex
+	sites map[string]*federatedSite
+}{sites: make(map[string]*federatedSite)}
+
+// federationKey is the shared key summaries are signed with (FEDERATION_KEY)
+func federationKey() string {
+	return os.Getenv("FEDERATION_KEY")
+}
+
+// siteName is what this instance calls itself to peers (FEDERATION_NAME, else the hostname)
+func siteName() string {
+	if name := os.Getenv("FEDERATION_NAME"); name != "" {
+		return name
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// federationInterval is how often summaries are pulled and pushed (FEDERATION_INTERVAL_SEC)
+func federationInterval() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("FEDERATION_INTERVAL_SEC")); err == nil && v >= 10 {
+		return time.Duration(v) * time.Second
+	}
+	return time.Minute
+}
+
+// federationURLs reads a comma-separated list of peers' base URLs
+func federationURLs(name string) ([]string, error) {
+	var urls []string
+	for _, raw := range strings.Split(os.Getenv(name), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s: %q is not an http(s) URL", name, raw)
+		}
+		urls = append(urls, strings.TrimRight(raw, "/"))
+	}
+	return urls, nil
+}
+
+// federationEnabled reports whether this instance shows other sites
+func federationEnabled() bool {
+	if federationKey() == "" {
+		return false
+	}
+	federation.Lock()
+	defer federation.Unlock()
+	return os.Getenv("FEDERATION_PEERS") != "" || len(federation.sites) > 0
+}
+
+// signFederation sets the signature headers for a federation request or response
+func signFederation(h http.Header, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	h.Set(signatureTimeHeader, ts)
+	h.Set(signatureHeader, uploadSignature(federationKey(), ts, federationPath, body))
+}
+
+// verifyFederation checks a federation request's or response's signature
+func verifyFederation(h http.Header, body []byte) error {
+	key := federationKey()
+	if key == "" {
+		return errors.New("federation is not configured")
+	}
+	sig, ts := h.Get(signatureHeader), h.Get(signatureTimeHeader)
+	if sig == "" {
+		return errors.New("federation requests must be signed")
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errStaleSignature
+	}
+	if skew := time.Since(time.Unix(secs, 0)); skew > maxClockSkew() || skew < -maxClockSkew() {
+		return errStaleSignature
+	}
+	if !hmac.Equal([]byte(uploadSignature(key, ts, federationPath, body)), []byte(sig)) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// siteSummary summarizes this instance's detectors
+func (s *Store) siteSummary(ctx context.Context) SiteSummary {
+	summary := SiteSummary{Site: siteName(), GeneratedAt: timeNow().UTC(), Devices: []SiteDevice{}}
+	devices := make(map[string]*SiteDevice)
+	for id, st := range s.latestStats() {
+		devices[id] = &SiteDevice{
+			DeviceID:          id,
+			LastSeen:          st.Timestamp,
+			DetectionsPerMin:  st.DetectionsPerMin,
+			CurrentActivity:   st.CurrentActivity,
+			UptimeSeconds:     st.Uptime,
+			FreqDetections24h: make([]int, len(frequencies())),
+		}
+	}
+	if leaderboardOptIn() {
+		if secret, err := s.instanceSecret(ctx); err != nil {
+			log.Printf("Error loading instance secret for pseudonyms: %v", err)
+		} else {
+			summary.Leaderboard = true
+			for id, d := range devices {
+				d.Pseudonym = pseudonym(communityStation(secret, id))
+			}
+		}
+	}
+	for _, info := range s.getDevices(ctx) {
+		if d, ok := devices[info.DeviceID]; ok && info.Located() {
+			d.Latitude, d.Longitude = info.Latitude, info.Longitude
+		}
+	}
+
+	since, args := uploadsSince(dbTimeAgo(24*time.Hour), nil)
+	qctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(qctx, `
+		SELECT device_id, SUM(uploads), SUM(total_detections), COALESCE(counts_sum(freqs), '')
+		FROM (`+since+`) GROUP BY device_id
+	`, args...)
+	if err != nil {
+		log.Printf("Error summarizing site: %v", err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var id, freqs string
+			var uploads, detections int
+			if err := rows.Scan(&id, &uploads, &detections, &freqs); err != nil {
+				continue
+			}
+			if d, ok := devices[id]; ok {
+				d.Uploads24h, d.Detections24h, d.FreqDetections24h = uploads, detections, planCounts(freqs)
+			}
+		}
+	}
+
+	for _, d := range devices {
+		summary.Devices = append(summary.Devices, *d)
+	}
+	sort.Slice(summary.Devices, func(i, j int) bool { return summary.Devices[i].DeviceID < summary.Devices[j].DeviceID })
+	return summary
+}
+
+// recordSite stores a peer's summary, or the error from fetching it
+func recordSite(name string, summary *SiteSummary, err error) {
+	federation.Lock()
+	defer federation.Unlock()
+	site, ok := federation.sites[name]
+	if !ok {
+		site = &federatedSite{}
+		federation.sites[name] = site
+	}
+	if err != nil {
+		site.Error = err.Error()
+		return
+	}
+	site.Summary, site.Received, site.Error = summary, time.Now(), ""
+}
+
+// forgetSite drops a site, when a peer turns out to call itself something else
+func forgetSite(name string) {
+	federation.Lock()
+	defer federation.Unlock()
+	delete(federation.sites, name)
+}
+
+// federatedSites returns a copy of every peer's state by name
+func federatedSites() map[string]federatedSite {
+	federation.Lock()
+	defer federation.Unlock()
+	sites := make(map[string]federatedSite, len(federation.sites))
+	for name, site := range federation.sites {
+		sites[name] = *site
+	}
+	return sites
+}
+
+// pullSummary fetches and verifies a peer's summary
+func pullSummary(ctx context.Context, client *http.Client, peer string) (*SiteSummary, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+federationPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	signFederation(req.Header, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUploadBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := verifyFederation(resp.Header, body); err != nil {
+		return nil, fmt.Errorf("response %w", err)
+	}
+	var summary SiteSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(summary.Site) == "" {
+		return nil, errors.New("summary has no site name")
+	}
+	return &summary, nil
+}
+
+// pushSummary sends this instance's summary to a peer
+func pushSummary(ctx context.Context, client *http.Client, target string, summary SiteSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target+federationPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signFederation(req.Header, body)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// federate pulls peers' summaries and pushes this instance's every interval.
+// Sites are known by the name they give themselves; a peer that has never
+// answered is listed by its host.
+func (s *Store) federate(peers, targets []string) {
+	ctx := context.Background()
+	client := &http.Client{Timeout: 20 * time.Second}
+	failing := make(map[string]bool) // Log each peer's failure once until it recovers
+	siteOf := make(map[string]string)
+	for _, peer := range peers {
+		u, _ := url.Parse(peer)
+		siteOf[peer] = u.Host
+	}
+	for {
+		for _, peer := range peers {
+			summary, err := pullSummary(ctx, client, peer)
+			if err == nil && summary.Site != siteOf[peer] {
+				forgetSite(siteOf[peer])
+				siteOf[peer] = summary.Site
+			}
+			recordSite(siteOf[peer], summary, err)
+			if err != nil && !failing[peer] {
+				log.Printf("Error pulling summary from %s: %v", peer, err)
+			}
+			failing[peer] = err != nil
+		}
+		if len(targets) > 0 {
+			summary := s.siteSummary(ctx)
+			for _, target := range targets {
+				err := pushSummary(ctx, client, target, summary)
+				if err != nil && !failing[target] {
+					log.Printf("Error pushing summary to %s: %v", target, err)
+				}
+				failing[target] = err != nil
+			}
+		}
+		time.Sleep(federationInterval())
+	}
+}
+
+// handleFederationSummary serves this instance's signed summary to a peer
+// (GET) or accepts one pushed by a peer (POST)
+func handleFederationSummary(w http.ResponseWriter, r *http.Request) {
+	if federationKey() == "" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := readUploadBody(w, r)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if err := verifyFederation(r.Header, body); err != nil {
+		noteAbuse(r, "federation: "+err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		out, _ := json.Marshal(store.siteSummary(r.Context()))
+		signFederation(w.Header(), out)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	case http.MethodPost:
+		var summary SiteSummary
+		if err := json.Unmarshal(body, &summary); err != nil || strings.TrimSpace(summary.Site) == "" {
+			http.Error(w, "Invalid summary", http.StatusBadRequest)
+			return
+		}
+		if summary.Site == siteName() {
+			http.Error(w, "Summary has this site's own name", http.StatusConflict)
+			return
+		}
+		recordSite(summary.Site, &summary, nil)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// allSites is this instance's summary followed by its peers' in name order
+func allSites(ctx context.Context) (SiteSummary, []string, map[string]federatedSite) {
+	peers := federatedSites()
+	names := make([]string, 0, len(peers))
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return store.siteSummary(ctx), names, peers
+}
+
+// handleAPISites lists this instance and every peer as JSON: GET /api/sites
+func handleAPISites(w http.ResponseWriter, r *http.Request) {
+	local, names, peers := allSites(r.Context())
+	out := map[string]interface{}{"local": local}
+	sites := make([]federatedSite, 0, len(names))
+	for _, name := range names {
+		site := peers[name]
+		if site.Summary == nil {
+			site.Summary = &SiteSummary{Site: name}
+		}
+		sites = append(sites, site)
+	}
+	out["peers"] = sites
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleSites renders the combined dashboard of this instance and its peers
+func handleSites(w http.ResponseWriter, r *http.Request) {
+	local, names, peers := allSites(r.Context())
+
+	// Band totals across every site that has reported
+	totals := make([]int, len(frequencies()))
+	addTotals := func(s *SiteSummary) {
+		for _, d := range s.Devices {
+			for i, n := range d.FreqDetections24h {
+				if i < len(totals) {
+					totals[i] += n
+				}
+			}
+		}
+	}
+	addTotals(&local)
+	for _, name := range names {
+		if s := peers[name].Summary; s != nil {
+			addTotals(s)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "LoRa Detector Sites", `    <meta http-equiv="refresh" content="60">
+`)
+	fmt.Fprintf(w, `    <h1>🏠 Sites</h1>
+    <p class="subtitle">This server and %d federated sites, last 24 hours</p>
+    <div class="card">
+        <h2><span class="icon">📶</span> All Sites</h2>
+`, len(names))
+	rows := make([]FreqRow, len(frequencies()))
+	for i, f := range frequencies() {
+		rows[i] = FreqRow{Freq: f, Href: "/frequency/" + f.MHz, Count: totals[i]}
+	}
+	renderFreqTable(w, defaultLang, "Detections per channel across sites", rows)
+	io.WriteString(w, "    </div>\n")
+
+	summaries := []*SiteSummary{&local}
+	for _, name := range names {
+		summaries = append(summaries, peers[name].Summary)
+	}
+	if lb := siteLeaderboard(summaries...); len(lb.Detections) > 0 {
+		renderLeaderboard(w, "Leaderboard (24h)", lb)
+	}
+
+	renderSite(w, local, true, time.Time{}, "")
+	for _, name := range names {
+		site := peers[name]
+		summary := site.Summary
+		if summary == nil {
+			summary = &SiteSummary{Site: name}
+		}
+		renderSite(w, *summary, false, site.Received, site.Error)
+	}
+	io.WriteString(w, `    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
+
+// renderSite draws one site's card: its status and a row per detector
+func renderSite(w io.Writer, s SiteSummary, local bool, received time.Time, fetchErr string) {
+	status := "This server"
+	switch {
+	case local:
+	case received.IsZero():
+		status = "⚠️ No summary yet"
+	case timeNow().Sub(received) > federationStaleAfter:
+		status = "⚠️ Last heard " + received.Format("Jan 2 15:04")
+	default:
+		status = "✅ Last heard " + received.Format("Jan 2 15:04")
+	}
+	if fetchErr != "" {
+		status += " · " + html.EscapeString(fetchErr)
+	}
+	fmt.Fprintf(w, `    <div class="card">
+        <h2><span class="icon">📍</span> %s</h2>
+        <p class="timestamp">%s</p>
+`, html.EscapeString(s.Site), status)
+	if len(s.Devices) == 0 {
+		io.WriteString(w, "        <p>No detectors</p>\n    </div>\n")
+		return
+	}
+	io.WriteString(w, `        <table class="event-log">
+            <tr><th>Device</th><th>Last Upload</th><th>Per Minute</th><th>Activity</th><th>Detections (24h)</th></tr>
+`)
+	for _, d := range s.Devices {
+		name := html.EscapeString(d.DeviceID)
+		if local {
+			name = fmt.Sprintf(`<a href="/device?device=%s">%s</a>`, url.QueryEscape(d.DeviceID), name)
+		}
+		fmt.Fprintf(w, "            <tr><td>%s</td><td>%s</td><td>%d</td><td>%d%%</td><td>%d</td></tr>\n",
+			name, d.LastSeen.Local().Format("Jan 2 15:04"), d.DetectionsPerMin, d.CurrentActivity, d.Detections24h)
+	}
+	io.WriteString(w, "        </table>\n    </div>\n")
+}
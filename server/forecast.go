@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Each detector's hourly detections are forecast with additive Holt-Winters
+// over a daily season: a level, a trend and an offset for each hour of the
+// day, updated hour by hour with fixed smoothing factors. The model is fitted
+// to the last forecastFitDays of rollups and raw uploads (getHourlyDetections);
+// offline hours are skipped. An hour far off its one-hour-ahead forecast,
+// measured in standard deviations of the model's past errors, is an anomaly
+// the per-channel baselines can miss, such as a silent night going busy.
+const (
+	forecastSeason    = 24
+	forecastFitDays   = 14
+	forecastMinHours  = 2 * forecastSeason
+	forecastAhead     = 24
+	forecastAlpha     = 0.2  // level
+	forecastBeta      = 0.01 // trend
+	forecastGamma     = 0.3  // season
+	forecastMinError  = 10   // detections; smaller misses never alert
+	forecastCheckTick = time.Hour
+)
+
+// forecastAlertSigma is how many standard deviations off its forecast an hour
+// must be to raise an alert (FORECAST_ALERT_SIGMA); 0 turns the alert off
+func forecastAlertSigma() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("FORECAST_ALERT_SIGMA"), 64); err == nil && v >= 0 {
+		return v
+	}
+	return 4
+}
+
+// Forecast is a device's expected detections for the next hours, and how the
+// last complete hour compared with what was expected of it
+type Forecast struct {
+	DeviceID     string      `json:"device_id"`
+	FitHours     int         `json:"fit_hours"` // online hours the model was fitted to
+	ErrorSD      float64     `json:"error_sd"`  // standard deviation of one-hour-ahead errors
+	Hours        []time.Time `json:"hours"`
+	Expected     []float64   `json:"expected"`
+	Low          []float64   `json:"low"` // ±2 error standard deviations, not below 0
+	High         []float64   `json:"high"`
+	LastHour     time.Time   `json:"last_hour"`
+	LastActual   *float64    `json:"last_actual"` // nil if the detector was offline
+	LastExpected float64     `json:"last_expected"`
+	LastScore    *float64    `json:"last_score"` // (actual − expected) / error_sd
+}
+
+// holtWinters fits the model to hourly values (NaN for offline) and returns
+// the one-step-ahead forecast made before each hour, the standard deviation
+// of its errors and the forecasts for the ahead hours after the last one
+func holtWinters(values []float64, ahead int) (fitted []float64, errSD float64, next []float64, online int) {
+	// Start from the first day's mean, with each hour's offset from it
+	var level float64
+	season := make([]float64, forecastSeason)
+	n := 0
+	for i := 0; i < forecastSeason && i < len(values); i++ {
+		if !math.IsNaN(values[i]) {
+			level += values[i]
+			n++
+		}
+	}
+	if n > 0 {
+		level /= float64(n)
+	}
+	for i := 0; i < forecastSeason && i < len(values); i++ {
+		if !math.IsNaN(values[i]) {
+			season[i] = values[i] - level
+		}
+	}
+
+	trend := 0.0
+	fitted = make([]float64, len(values))
+	var sumSq float64
+	errors := 0
+	for i, v := range values {
+		s := i % forecastSeason
+		fitted[i] = max(level+trend+season[s], 0)
+		if math.IsNaN(v) {
+			// Carry on through the gap as the forecast would
+			level += trend
+			continue
+		}
+		online++
+		if i >= forecastSeason {
+			sumSq += (v - fitted[i]) * (v - fitted[i])
+			errors++
+		}
+		prev := level
+		level = forecastAlpha*(v-season[s]) + (1-forecastAlpha)*(level+trend)
+		trend = forecastBeta*(level-prev) + (1-forecastBeta)*trend
+		season[s] = forecastGamma*(v-level) + (1-forecastGamma)*season[s]
+	}
+	if errors > 0 {
+		errSD = math.Sqrt(sumSq / float64(errors))
+	}
+	next = make([]float64, ahead)
+	for h := range next {
+		next[h] = max(level+float64(h+1)*trend+season[(len(values)+h)%forecastSeason], 0)
+	}
+	return fitted, errSD, next, online
+}
+
+// getForecast fits a device's recent hours and forecasts the next
+// forecastAhead; ok is false with too little history to fit
+func (s *Store) getForecast(ctx context.Context, deviceID string) (Forecast, bool) {
+	hours := forecastFitDays * forecastSeason
+	// The last hour getHourlyDetections returns is still under way
+	values := s.getHourlyDetections(ctx, deviceID, hours+1)
+	if len(values) < hours+1 {
+		return Forecast{}, false
+	}
+	values = values[:hours]
+	fitted, errSD, next, online := holtWinters(values, forecastAhead)
+	if online < forecastMinHours {
+		return Forecast{}, false
+	}
+
+	current := timeNow().Truncate(time.Hour)
+	f := Forecast{DeviceID: deviceID, FitHours: online, ErrorSD: math.Round(errSD*10) / 10,
+		LastHour: current.Add(-time.Hour), LastExpected: math.Round(fitted[hours-1]*10) / 10}
+	if last := values[hours-1]; !math.IsNaN(last) {
+		f.LastActual = &last
+		if errSD > 0 {
+			score := math.Round((last-fitted[hours-1])/errSD*10) / 10
+			f.LastScore = &score
+		}
+	}
+	for h, v := range next {
+		f.Hours = append(f.Hours, current.Add(time.Duration(h)*time.Hour))
+		f.Expected = append(f.Expected, math.Round(v*10)/10)
+		f.Low = append(f.Low, math.Round(max(v-2*errSD, 0)*10)/10)
+		f.High = append(f.High, math.Round((v+2*errSD)*10)/10)
+	}
+	return f, true
+}
+
+// checkForecast raises an alert when the last complete hour was far from its forecast
+func (s *Store) checkForecast(ctx context.Context, deviceID string, sigma float64) {
+	f, ok := s.getForecast(ctx, deviceID)
+	if !ok || f.LastScore == nil || math.Abs(*f.LastScore) < sigma || math.Abs(*f.LastActual-f.LastExpected) < forecastMinError {
+		return
+	}
+	direction := "more"
+	if *f.LastActual < f.LastExpected {
+		direction = "fewer"
+	}
+	s.raiseAlert(ctx, deviceID, "forecast", fmt.Sprintf("%.0f detections in the hour from %s, %s than the %.0f forecast (%.1f standard deviations)",
+		*f.LastActual, f.LastHour.Format("15:04"), direction, f.LastExpected, math.Abs(*f.LastScore)))
+}
+
+// watchForecasts checks every detector against its forecast shortly after
+// each hour ends
+func (s *Store) watchForecasts() {
+	ctx := context.Background()
+	for {
+		next := time.Now().Truncate(forecastCheckTick).Add(forecastCheckTick + time.Minute)
+		time.Sleep(time.Until(next))
+		sigma := forecastAlertSigma()
+		if sigma == 0 {
+			continue
+		}
+		for id := range s.latestStats() {
+			s.checkForecast(ctx, id, sigma)
+		}
+	}
+}
+
+// handleAPIForecast returns a device's expected detections per hour for the
+// next day: GET /api/forecast?device=ID
+func handleAPIForecast(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	f, ok := store.getForecast(r.Context(), deviceID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Not enough history to forecast; it needs %d hours online in the last %d days", forecastMinHours, forecastFitDays), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// frequencyIndex finds a scanned channel by its MHz label ("917.5")
+func frequencyIndex(mhz string) (int, bool) {
+	for i, f := range frequencies() {
+		if f.Scanned() && f.MHz == mhz {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// onlyFrequencies keeps some frequencies' columns of a [bucket][frequency]
+// series, so renderStackedBars draws just those channels in their colours.
+// Gap (nil) buckets stay gaps.
+func onlyFrequencies(series [][]int, keep ...int) [][]int {
+	out := make([][]int, len(series))
+	for b, bucket := range series {
+		if bucket == nil {
+			continue
+		}
+		out[b] = make([]int, len(frequencies()))
+		for _, i := range keep {
+			out[b][i] = bucket[i]
+		}
+	}
+	return out
+}
+
+// handleFrequency renders one channel's history, hour-of-day profile, busiest
+// detectors and category: GET /frequency/{mhz}?days=30
+func handleFrequency(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fi, ok := frequencyIndex(r.PathValue("mhz"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	freq := frequencyAt(fi)
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	loc := store.locationFor(ctx, nil)
+	activity := store.getActivityBuckets(ctx, nil, days, loc)
+
+	// Totals per channel over the period, for this channel's share of its category
+	totals := make([]int, len(frequencies()))
+	for _, day := range activity.Daily {
+		for i, c := range day {
+			totals[i] += c
+		}
+	}
+	total, busiestDay, busiest := totals[fi], "", 0
+	for d, day := range activity.Daily {
+		if day != nil && day[fi] > busiest {
+			busiestDay, busiest = activity.Days[d], day[fi]
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, freq.MHz+" MHz "+freq.Label, `    <style>
+        .freq-detail td { padding: 4px 16px 4px 0; }
+        .freq-detail a { color: #00d4ff; }
+        .freq-links a { color: #00d4ff; margin-right: 12px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>📶 %s MHz · %s</h1>
+    <p class="subtitle">%s · last %d days · %d detections</p>
+    <div class="card">
+        <h2>Daily Detections</h2>
+`, freq.MHz, html.EscapeString(freq.Label), html.EscapeString(freq.Devices), days, total)
+	if busiest > 0 {
+		fmt.Fprintf(w, `        <div class="chart-label">Busiest day %s with %d detections (%s)</div>
+`, busiestDay, busiest, html.EscapeString(activity.Timezone))
+	}
+	fmt.Fprintf(w, `        `)
+	renderStackedBars(w, onlyFrequencies(activity.Daily, fi), 480, 100)
+	if from, to, ok := activitySpan(activity, loc); ok {
+		notes := slices.Concat(store.getAnnotations(ctx, nil, from, to), store.solarAnnotations(ctx, from, to))
+		renderAnnotationStrip(w, notes, from, to, 480)
+		fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>%s</span><span>%s</span></div>
+`, activity.Days[0], activity.Days[len(activity.Days)-1])
+	}
+	fmt.Fprintf(w, `    </div>
+    <div class="card">
+        <h2>Activity by Hour of Day</h2>
+        <div class="chart-label">Detections over the last %d days by local hour (%s)</div>
+        `, days, html.EscapeString(activity.Timezone))
+	renderStackedBars(w, onlyFrequencies(activity.Hourly, fi), 480, 100)
+	fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>00:00</span><span>12:00</span><span>23:00</span></div>
+    </div>
+`)
+
+	// Busiest detectors on this channel
+	type deviceCount struct {
+		id    string
+		count int
+	}
+	var devices []deviceCount
+	for id, t := range activity.Devices {
+		if t[fi] > 0 {
+			devices = append(devices, deviceCount{id, t[fi]})
+		}
+	}
+	sort.Slice(devices, func(a, b int) bool {
+		if devices[a].count != devices[b].count {
+			return devices[a].count > devices[b].count
+		}
+		return devices[a].id < devices[b].id
+	})
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Busiest Detectors</h2>
+`)
+	if len(devices) == 0 {
+		fmt.Fprintf(w, `        <p>No detector heard this channel in the last %d days.</p>
+`, days)
+	} else {
+		fmt.Fprintf(w, `        <table class="freq-detail">
+`)
+		for _, d := range devices[:min(len(devices), 10)] {
+			fmt.Fprintf(w, `            <tr><td><a href="/device?device=%s">%s</a></td><td>%d</td><td>%.0f%%</td></tr>
+`, url.QueryEscape(d.id), html.EscapeString(d.id), d.count, 100*float64(d.count)/float64(total))
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+`)
+
+	// The channel's category and its other channels
+	cat, _ := categoryByName(freq.Category)
+	catTotal := categoryCount(totals, freq.Category)
+	share := 0.0
+	if catTotal > 0 {
+		share = 100 * float64(total) / float64(catTotal)
+	}
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Category</h2>
+        <table class="freq-detail">
+            <tr><td>Category</td><td><a href="/category/%s?days=%d">%s</a></td></tr>
+            <tr><td>Typical devices</td><td>%s</td></tr>
+            <tr><td>Share of category</td><td>%.0f%% of %d detections</td></tr>
+        </table>
+        <p class="freq-links">`, url.PathEscape(cat.Name), days, html.EscapeString(cat.Title), html.EscapeString(freq.Devices), share, catTotal)
+	for _, f := range frequencies() {
+		if f.Category == freq.Category && f.MHz != freq.MHz {
+			fmt.Fprintf(w, `<a href="/frequency/%s?days=%d">%s MHz %s</a>`, f.MHz, days, f.MHz, html.EscapeString(f.Label))
+		}
+	}
+	fmt.Fprintf(w, `</p>
+    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
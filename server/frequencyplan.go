@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The frequency plan says what each scan slot is listening to. Counts are
+// stored by plan slot (see channels.go), so a slot no longer scanned is kept
+// with an empty MHz rather than removed, and new channels go on the end; that
+// way every other slot keeps its history. Edits are stored in the settings
+// table and take effect on the next page load.
+const settingFrequencyPlan = "frequency_plan"
+
+// frequencyPlan is the edited plan; nil until one has been saved
+var frequencyPlan atomic.Pointer[[]FrequencyInfo]
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// frequencies returns the current plan, one entry per scan slot
+func frequencies() []FrequencyInfo {
+	if p := frequencyPlan.Load(); p != nil {
+		return *p
+	}
+	return defaultFrequencies
+}
+
+// frequencyAt returns plan slot i, or an unscanned slot if the plan has none.
+// Events and counts can be indexed past the plan's end when it has shrunk.
+func frequencyAt(i int) FrequencyInfo {
+	if freqs := frequencies(); i >= 0 && i < len(freqs) {
+		return freqs[i]
+	}
+	return FrequencyInfo{}
+}
+
+// Scanned reports whether the firmware scans this slot
+func (f FrequencyInfo) Scanned() bool {
+	return f.MHz != ""
+}
+
+// validateFrequencyPlan checks a plan and normalises its MHz and colours
+func validateFrequencyPlan(plan []FrequencyInfo) ([]FrequencyInfo, error) {
+	if len(plan) > maxChannels {
+		return nil, fmt.Errorf("the plan can have at most %d slots", maxChannels)
+	}
+	plan = slices.Clone(plan)
+	seen := make(map[string]bool)
+	for i := range plan {
+		f := &plan[i]
+		f.MHz = strings.TrimSpace(f.MHz)
+		if !f.Scanned() {
+			plan[i] = FrequencyInfo{}
+			continue
+		}
+		mhz, err := strconv.ParseFloat(f.MHz, 64)
+		if err != nil || mhz <= 0 {
+			return nil, fmt.Errorf("slot %d: %q is not a frequency in MHz", i, f.MHz)
+		}
+		f.MHz = strconv.FormatFloat(mhz, 'f', -1, 64)
+		if seen[f.MHz] {
+			return nil, fmt.Errorf("slot %d: %s MHz is already in the plan", i, f.MHz)
+		}
+		seen[f.MHz] = true
+		if f.Label = strings.TrimSpace(f.Label); f.Label == "" {
+			return nil, fmt.Errorf("slot %d: label required", i)
+		}
+		if !slices.ContainsFunc(categoryInfo, func(c CategoryInfo) bool { return c.Name == f.Category }) {
+			return nil, fmt.Errorf("slot %d: unknown category %q", i, f.Category)
+		}
+		if !colorPattern.MatchString(f.Color) {
+			return nil, fmt.Errorf("slot %d: colour must look like #4CAF50", i)
+		}
+		f.Color = strings.ToUpper(f.Color)
+		f.Devices = strings.TrimSpace(f.Devices)
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("the plan needs at least one scanned frequency")
+	}
+	return plan, nil
+}
+
+// loadFrequencyPlan applies the stored plan, or the built-in one if none is
+// stored or it no longer validates
+func (s *Store) loadFrequencyPlan(ctx context.Context) {
+	value := s.getSetting(ctx, settingFrequencyPlan)
+	if value == "" {
+		frequencyPlan.Store(nil)
+		return
+	}
+	var plan []FrequencyInfo
+	err := json.Unmarshal([]byte(value), &plan)
+	if err == nil {
+		plan, err = validateFrequencyPlan(plan)
+	}
+	if err != nil {
+		log.Printf("Warning: ignoring stored frequency plan: %v", err)
+		frequencyPlan.Store(nil)
+		return
+	}
+	frequencyPlan.Store(&plan)
+}
+
+// setFrequencyPlan validates, stores and applies a plan; nil restores the
+// built-in one
+func (s *Store) setFrequencyPlan(ctx context.Context, plan []FrequencyInfo) error {
+	if plan == nil {
+		if err := s.setSetting(ctx, settingFrequencyPlan, ""); err != nil {
+			return err
+		}
+		frequencyPlan.Store(nil)
+		return nil
+	}
+	plan, err := validateFrequencyPlan(plan)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	if err := s.setSetting(ctx, settingFrequencyPlan, string(data)); err != nil {
+		return err
+	}
+	frequencyPlan.Store(&plan)
+	return nil
+}
+
+// handleAPIFrequencies returns the frequency plan, or replaces it with a JSON
+// array of slots: GET or POST /api/frequencies (POST [] restores the default)
+func handleAPIFrequencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var plan []FrequencyInfo
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if len(plan) == 0 {
+			plan = nil
+		}
+		if err := store.setFrequencyPlan(r.Context(), plan); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(frequencies())
+}
+
+// frequencyPlanFromForm reads the editor's slot_N_field inputs for the number
+// of slots in the slots field. Unticked slots past the current plan's end (the
+// editor's spare row) are dropped.
+func frequencyPlanFromForm(r *http.Request) []FrequencyInfo {
+	n, _ := strconv.Atoi(r.FormValue("slots"))
+	plan := make([]FrequencyInfo, min(max(n, 0), maxChannels))
+	for i := range plan {
+		field := func(name string) string { return r.FormValue(fmt.Sprintf("slot_%d_%s", i, name)) }
+		if field("scanned") != "true" {
+			continue
+		}
+		plan[i] = FrequencyInfo{MHz: field("mhz"), Label: field("label"), Category: field("category"),
+			Devices: field("devices"), Color: field("color")}
+	}
+	for len(plan) > len(frequencies()) && !plan[len(plan)-1].Scanned() {
+		plan = plan[:len(plan)-1]
+	}
+	return plan
+}
+
+// handleFrequencyPlan edits the frequency plan: /admin/frequencies
+func handleFrequencyPlan(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	formError := ""
+	plan := frequencies()
+	if r.Method == http.MethodPost {
+		var err error
+		if r.FormValue("action") == "reset" {
+			err = store.setFrequencyPlan(ctx, nil)
+		} else if b, ok := bandByName(r.FormValue("add_band")); ok {
+			plan = addBandProfile(frequencies(), b)
+			err = store.setFrequencyPlan(ctx, plan)
+		} else {
+			plan = frequencyPlanFromForm(r)
+			err = store.setFrequencyPlan(ctx, plan)
+		}
+		if err == nil {
+			http.Redirect(w, r, "/admin/frequencies", http.StatusSeeOther)
+			return
+		}
+		formError = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Frequency Plan", `    <style>
+        .plan-table td, .plan-table th { padding: 4px 8px 4px 0; text-align: left; }
+        .plan-table input[type=text] { width: 100%; }
+        .plan-table .mhz { width: 6em; }
+        .plan-error { color: #ff5252; }
+        .plan-actions button { margin-right: 10px; padding: 6px 14px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>📶 Frequency Plan</h1>
+    <p class="subtitle">What each scan slot listens to. Match this to SCAN_FREQUENCIES after reflashing; untick a slot no longer scanned and add new channels in the last row. Detectors with a different scan list need a channel map (see /api/devices).</p>
+    <div class="card">
+`)
+	if formError != "" {
+		fmt.Fprintf(w, "        <p class=\"plan-error\">%s</p>\n", html.EscapeString(formError))
+	}
+	fmt.Fprintf(w, `        <form method="post" action="/admin/frequencies">
+        <input type="hidden" name="slots" value="%d">
+        <table class="plan-table">
+            <tr><th>Slot</th><th>Scanned</th><th>MHz</th><th>Label</th><th>Category</th><th>Devices</th><th>Colour</th></tr>
+`, len(plan)+1)
+	// A spare row at the end adds a channel
+	for i, f := range append(slices.Clone(plan), FrequencyInfo{}) {
+		// A slot being switched back on starts from its built-in values
+		shown := f
+		if !f.Scanned() {
+			shown = FrequencyInfo{Category: categoryInfo[0].Name, Color: "#888888"}
+			if i < len(defaultFrequencies) {
+				shown = defaultFrequencies[i]
+			}
+		}
+		checked := ""
+		if f.Scanned() {
+			checked = " checked"
+		}
+		fmt.Fprintf(w, `            <tr>
+                <td>%d</td>
+                <td><input type="hidden" name="slot_%d_scanned" value="false"><input type="checkbox" name="slot_%d_scanned" value="true"%s></td>
+                <td><input type="text" class="mhz" name="slot_%d_mhz" value="%s" inputmode="decimal"></td>
+                <td><input type="text" name="slot_%d_label" value="%s"></td>
+                <td><select name="slot_%d_category">`,
+			i, i, i, checked, i, html.EscapeString(shown.MHz), i, html.EscapeString(shown.Label), i)
+		for _, c := range categoryInfo {
+			selected := ""
+			if c.Name == shown.Category {
+				selected = " selected"
+			}
+			fmt.Fprintf(w, `<option value="%s"%s>%s</option>`, c.Name, selected, html.EscapeString(c.Title))
+		}
+		fmt.Fprintf(w, `</select></td>
+                <td><input type="text" name="slot_%d_devices" value="%s"></td>
+                <td><input type="color" name="slot_%d_color" value="%s"></td>
+            </tr>
+`, i, html.EscapeString(shown.Devices), i, strings.ToLower(shown.Color))
+	}
+	fmt.Fprintf(w, `        </table>
+`)
+	if !store.readOnly {
+		fmt.Fprintf(w, `        <p class="plan-actions">
+            <button name="action" value="save">Save</button>
+            <button name="action" value="reset" formnovalidate>Restore built-in plan</button>
+`)
+		// A band the plan doesn't scan yet can be added from its suggested
+		// channels, e.g. 2.4 GHz for SX1280 detectors
+		scanned := planBands()
+		for _, b := range bandInfo {
+			if !slices.ContainsFunc(scanned, func(s BandInfo) bool { return s.Name == b.Name }) {
+				fmt.Fprintf(w, `            <button name="add_band" value="%s">Add %s channels</button>
+`, b.Name, html.EscapeString(b.Title))
+			}
+		}
+		fmt.Fprintf(w, `        </p>
+`)
+	}
+	fmt.Fprintf(w, `        </form>
+    </div>
+    <footer><a href="/admin" style="color: #00d4ff;">← Admin</a> · <a href="/" style="color: #00d4ff;">Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
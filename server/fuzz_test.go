@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The fuzz targets feed payloads to the upload decoders and to the validation
+// layer behind them, without a database or a listener. An input fails if it
+// panics, or if validation lets through an upload that can't be stored and
+// served faithfully: its counts must survive the freqs column and the upload
+// must encode as JSON for the APIs. Each target is seeded with payloads a
+// detector sends; run one with go test -fuzz FuzzDecodeJSON, and failing
+// inputs are kept under testdata/fuzz and replayed by every go test.
+
+// checkAccepted is what an upload that passed validation must satisfy: its
+// counts survive the freqs column without wrapping the summaries' sums, and
+// it comes back unchanged from the JSON the APIs serve
+func checkAccepted(st Stats) error {
+	if got := parseCounts(encodeCounts(st.FreqDetections)); len(st.FreqDetections) > 0 && !equalCounts(got, st.FreqDetections) {
+		return fmt.Errorf("freq_detections %v don't survive the freqs column (%v)", st.FreqDetections, got)
+	}
+	for _, c := range append([]int{st.Uptime, st.TotalDetections, st.DetectionsPerMin}, st.FreqDetections...) {
+		if c < 0 || c > math.MaxUint32 {
+			return fmt.Errorf("counter %d would break the summaries' sums", c)
+		}
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("accepted upload doesn't encode as JSON: %w", err)
+	}
+	var back Stats
+	if err := json.Unmarshal(b, &back); err != nil || !reflect.DeepEqual(back, st) {
+		return fmt.Errorf("accepted upload changes through JSON: %s", b)
+	}
+	return nil
+}
+
+func equalCounts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptStats runs the validation every transport runs after decoding, with
+// the fields the server fills in overwritten as the transports do
+func acceptStats(t *testing.T, st Stats) {
+	st.Timestamp, st.UploaderIP, st.AckID = time.Unix(1750000000, 0).UTC(), "fuzz", 0
+	if st.DeviceID == "" {
+		st.DeviceID = "unknown"
+	}
+	if validateStats(&st) != nil {
+		return
+	}
+	if err := checkAccepted(st); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fuzzStats are the uploads the JSON and CBOR seeds encode
+func fuzzStats() []Stats {
+	stats := Stats{DeviceID: "lora-detector-1", Uptime: 3600, TotalDetections: 120, DetectionsPerMin: 2,
+		CurrentActivity: 12, PeakActivity: 40, FreqDetections: []int{10, 20, 30, 0, 5, 0, 40, 15}}
+	lat, lon, batt := 40.015, -105.27, 3.92
+	located := stats
+	located.Latitude, located.Longitude, located.BatteryV = &lat, &lon, &batt
+	return []Stats{stats, located}
+}
+
+// fuzzCompactSeeds are a version 1 and a version 2 compact payload
+func fuzzCompactSeeds() [][]byte {
+	v1 := make([]byte, compactHeaderLen+2*compactV1Channels+2)
+	v1[0] = 1
+	binary.BigEndian.PutUint32(v1[1:], 3600)
+	binary.BigEndian.PutUint32(v1[5:], 120)
+	v1[11], v1[12] = 12, 40
+	binary.BigEndian.PutUint16(v1[len(v1)-2:], 3920)
+	v2 := append([]byte{2}, v1[1:compactHeaderLen]...)
+	v2 = append(v2, 3, 0, 10, 0, 20, 0, 30)
+	return [][]byte{v1, v2}
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	for _, st := range fuzzStats() {
+		b, _ := json.Marshal(st)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var st Stats
+		if json.Unmarshal(b, &st) == nil {
+			acceptStats(t, st)
+		}
+	})
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, st := range fuzzStats() {
+		b, _ := cbor.Marshal(st)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var st Stats
+		if cbor.Unmarshal(b, &st) == nil {
+			acceptStats(t, st)
+		}
+	})
+}
+
+func FuzzCompact(f *testing.F) {
+	for _, b := range fuzzCompactSeeds() {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		st, err := decodeCompactStats(b)
+		if err != nil {
+			return
+		}
+		st.DeviceID = "lora-detector-1"
+		acceptStats(t, st)
+	})
+}
+
+// FuzzUDP's inputs are datagrams without their CRC, so mutations reach past the check
+func FuzzUDP(f *testing.F) {
+	for _, payload := range fuzzCompactSeeds() {
+		b := append([]byte{15}, "lora-detector-1"...)
+		b = append(append(b, 5), "token"...)
+		f.Add(append(b, payload...))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		deviceID, _, payload, err := parseDatagram(binary.BigEndian.AppendUint32(bytes.Clone(b), crc32.ChecksumIEEE(b)))
+		if err != nil {
+			return
+		}
+		st, err := decodeCompactStats(payload)
+		if err != nil {
+			return
+		}
+		st.DeviceID = deviceID
+		acceptStats(t, st)
+	})
+}
+
+func FuzzCoAP(f *testing.F) {
+	for _, st := range fuzzStats() {
+		payload, _ := cbor.Marshal(st)
+		m := coapMessage{typ: coapCON, code: coapPOST, msgID: 1, token: []byte{1, 2},
+			options: []coapOption{
+				{num: coapOptionURIPath, value: []byte("upload")},
+				{num: coapOptionContentFormat, value: []byte{coapContentFormatCBOR}},
+				{num: coapOptionURIQuery, value: []byte("token=abc")},
+			},
+			payload: payload}
+		f.Add(m.encode())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		m, err := parseCoAP(b)
+		if err != nil {
+			return
+		}
+		m.path()
+		m.contentFormat()
+		m.query("token")
+		var st Stats
+		if cbor.Unmarshal(m.payload, &st) == nil {
+			acceptStats(t, st)
+		}
+	})
+}
+
+func FuzzEvents(f *testing.F) {
+	f.Add([]byte(`{"device_id":"lora-detector-1","device_time":1750000000,"events":[{"ago":5,"freq_index":2,"rssi":-110.5,"sf":9,"bw":125,"len":23}],"bins":[{"ago":60,"counts":[1,0,3]}]}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var up EventUpload
+		if json.Unmarshal(b, &up) != nil || validateEvents(up) != nil {
+			return
+		}
+		received := time.Unix(1750000000, 0)
+		for _, e := range up.Events {
+			if ts := eventTime(e.Time, e.Ago, received); ts.Year() < 1970 || ts.Year() > 9999 {
+				t.Fatalf("event placed at %v", ts)
+			}
+			e.modulation()
+			e.payloadLen()
+		}
+		for _, bin := range up.Bins {
+			if ts := eventTime(bin.Time, bin.Ago, received); ts.Year() < 1970 || ts.Year() > 9999 {
+				t.Fatalf("bin placed at %v", ts)
+			}
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceCoverage summarises what a detector heard over a period
+type DeviceCoverage struct {
+	Uploads         int            `json:"uploads"`
+	TotalDetections int            `json:"total_detections"`
+	FreqTotals      []int          `json:"freq_totals"`
+	CategoryTotals  map[string]int `json:"category_totals"`
+	LastUpload      *time.Time     `json:"last_upload,omitempty"`
+}
+
+// getDeviceCoverage totals a single device's uploads over the last n days
+func (s *Store) getDeviceCoverage(ctx context.Context, deviceID string, days int) DeviceCoverage {
+	c := DeviceCoverage{CategoryTotals: make(map[string]int)}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var freqs, last string
+	err := s.queryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(total_detections), 0),
+			COALESCE(counts_sum(freqs), ''), COALESCE(MAX(timestamp), '')
+		FROM uploads
+		WHERE device_id = ? AND timestamp > ?
+	`, deviceID, dbTimeAgo(time.Duration(days)*24*time.Hour)).Scan(&c.Uploads, &c.TotalDetections, &freqs, &last)
+	if err != nil {
+		log.Printf("Error getting coverage for %s: %v", deviceID, err)
+	}
+	c.FreqTotals = planCounts(freqs)
+	if last != "" {
+		t := parseDBTime(last)
+		c.LastUpload = &t
+	}
+	for i, f := range frequencies() {
+		if f.Scanned() && i < len(c.FreqTotals) {
+			c.CategoryTotals[f.Category] += c.FreqTotals[i]
+		}
+	}
+	return c
+}
+
+// geoFeature is a GeoJSON Feature; coordinates are [lon, lat] as the spec requires
+type geoFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   map[string]interface{} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoParams reads the shared ?days= and ?hours= export windows
+func geoParams(r *http.Request) (days, hours int) {
+	days, hours = 30, 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+	return days, hours
+}
+
+// handleGeoJSON exports detectors and transmitter fixes: GET /api/geo.json?days=30&hours=24
+func handleGeoJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days, hours := geoParams(r)
+	features := []geoFeature{}
+
+	for _, d := range store.getDevices(ctx) {
+		if !d.Located() {
+			continue
+		}
+		features = append(features, geoFeature{
+			Type: "Feature",
+			Geometry: map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{*d.Longitude, *d.Latitude},
+			},
+			Properties: map[string]interface{}{
+				"kind":        "detector",
+				"device_id":   d.DeviceID,
+				"period_days": days,
+				"coverage":    store.getDeviceCoverage(ctx, d.DeviceID, days),
+			},
+		})
+	}
+
+	for _, f := range store.getTransmitterFixes(ctx, timeNow().Add(-time.Duration(hours)*time.Hour)) {
+		props := map[string]interface{}{
+			"kind":            "transmitter",
+			"time":            f.Time.UTC().Format(time.RFC3339),
+			"mhz":             f.MHz,
+			"category":        frequencyAt(f.FreqIndex).Category,
+			"detectors":       f.Detectors,
+			"semi_major_m":    f.SemiMajorM,
+			"semi_minor_m":    f.SemiMinorM,
+			"orientation_deg": f.OrientationDeg,
+		}
+		features = append(features, geoFeature{
+			Type:       "Feature",
+			Geometry:   map[string]interface{}{"type": "Point", "coordinates": []float64{f.Longitude, f.Latitude}},
+			Properties: props,
+		})
+
+		ring := make([][]float64, len(f.Ellipse))
+		for i, p := range f.Ellipse {
+			ring[i] = []float64{p[1], p[0]}
+		}
+		features = append(features, geoFeature{
+			Type:       "Feature",
+			Geometry:   map[string]interface{}{"type": "Polygon", "coordinates": [][][]float64{ring}},
+			Properties: map[string]interface{}{"kind": "confidence_ellipse", "mhz": f.MHz, "time": props["time"]},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="lora-detector.geojson"`)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+// kmlColor converts "#RRGGBB" into KML's aabbggrr order
+func kmlColor(hex string, alpha string) string {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return alpha + "ffffff"
+	}
+	return alpha + hex[4:6] + hex[2:4] + hex[0:2]
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// handleGeoKML exports the same data as KML for Google Earth: GET /api/geo.kml?days=30&hours=24
+func handleGeoKML(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days, hours := geoParams(r)
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="lora-detector.kml"`)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+  <name>LoRa Detector</name>
+`)
+	for i, f := range frequencies() {
+		fmt.Fprintf(w, `  <Style id="freq%d"><IconStyle><color>%s</color></IconStyle><LineStyle><color>%s</color></LineStyle><PolyStyle><color>%s</color></PolyStyle></Style>
+`, i, kmlColor(f.Color, "ff"), kmlColor(f.Color, "ff"), kmlColor(f.Color, "33"))
+	}
+
+	fmt.Fprintf(w, "  <Folder>\n    <name>Detectors</name>\n")
+	for _, d := range store.getDevices(ctx) {
+		if !d.Located() {
+			continue
+		}
+		c := store.getDeviceCoverage(ctx, d.DeviceID, days)
+		fmt.Fprintf(w, `    <Placemark>
+      <name>%s</name>
+      <description>%s</description>
+      <Point><coordinates>%f,%f</coordinates></Point>
+    </Placemark>
+`, xmlEscape(d.DeviceID), xmlEscape(coverageDescription(c, days)), *d.Longitude, *d.Latitude)
+	}
+	fmt.Fprintf(w, "  </Folder>\n  <Folder>\n    <name>Estimated transmitters (last %dh)</name>\n", hours)
+
+	for _, f := range store.getTransmitterFixes(ctx, timeNow().Add(-time.Duration(hours)*time.Hour)) {
+		desc := fmt.Sprintf("Heard by %s at %s; 95%% ellipse %.0f m × %.0f m",
+			strings.Join(f.Detectors, ", "), f.Time.Format(time.RFC3339), f.SemiMajorM, f.SemiMinorM)
+		fmt.Fprintf(w, `    <Placemark>
+      <name>%s MHz</name>
+      <description>%s</description>
+      <TimeStamp><when>%s</when></TimeStamp>
+      <styleUrl>#freq%d</styleUrl>
+      <MultiGeometry>
+        <Point><coordinates>%f,%f</coordinates></Point>
+        <Polygon><outerBoundaryIs><LinearRing><coordinates>`, f.MHz, xmlEscape(desc),
+			f.Time.UTC().Format(time.RFC3339), f.FreqIndex, f.Longitude, f.Latitude)
+		writeKMLRing(w, f.Ellipse)
+		fmt.Fprintf(w, `</coordinates></LinearRing></outerBoundaryIs></Polygon>
+      </MultiGeometry>
+    </Placemark>
+`)
+	}
+	fmt.Fprintf(w, "  </Folder>\n</Document>\n</kml>\n")
+}
+
+func writeKMLRing(w io.Writer, ring [][2]float64) {
+	for i, p := range ring {
+		if i > 0 {
+			io.WriteString(w, " ")
+		}
+		fmt.Fprintf(w, "%f,%f", p[1], p[0])
+	}
+}
+
+// coverageDescription is the human-readable coverage text used in KML balloons
+func coverageDescription(c DeviceCoverage, days int) string {
+	parts := []string{fmt.Sprintf("%d uploads, %d detections in %d days", c.Uploads, c.TotalDetections, days)}
+	for _, cat := range categories() {
+		parts = append(parts, fmt.Sprintf("%s: %d", cat, c.CategoryTotals[cat]))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -4,7 +4,12 @@ go 1.24.0
 
 toolchain go1.24.2
 
-require modernc.org/sqlite v1.41.0
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	golang.org/x/sys v0.36.0
+	golang.org/x/term v0.35.0
+	modernc.org/sqlite v1.41.0
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -12,8 +17,8 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
-	golang.org/x/sys v0.36.0 // indirect
 	modernc.org/libc v1.66.10 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGolden renders a fixed set of pages and API responses from a seeded
+// fixture and compares them byte for byte with the files in testdata/golden,
+// so a refactor of the templating or charts can't change what is served
+// without the diff showing up in review. The fixture is three simulated
+// detectors with fixed seeds, two of them located and grouped, with a week of
+// 5-minute uploads and an annotation, all ending at goldenNow; timeNow is
+// pinned there and the environment cleared for the test, so the output doesn't
+// depend on when or where it was rendered. After a deliberate change,
+// go test -run TestGolden -update rewrites the files.
+var goldenNow = time.Date(2025, 6, 15, 14, 7, 0, 0, time.UTC)
+
+var update = flag.Bool("update", false, "rewrite the golden files from this build's output")
+
+// goldenPages are the requests compared, by golden file name
+var goldenPages = []struct{ name, path string }{
+	{"home.html", "/"},
+	{"stats.html", "/stats"},
+	{"device-golden-1.html", "/device?device=golden-1"},
+	{"device-golden-3.html", "/device?device=golden-3&days=7"},
+	{"device-golden-2-range.html", "/device?device=golden-2&from=2025-06-10&to=2025-06-12"},
+	{"home-range.html", "/?from=2025-06-09&to=2025-06-11"},
+	{"frequency-906.3.html", "/frequency/906.3"},
+	{"category-lorawan.html", "/category/lorawan"},
+	{"group-rooftops.html", "/group/rooftops"},
+	{"availability.html", "/availability"},
+	{"print.html", "/print?device=golden-1"},
+	{"map.html", "/map"},
+	{"mobile.html", "/m"},
+	{"api-stats.json", "/api/stats"},
+	{"api-devices.json", "/api/devices"},
+	{"api-activity.json", "/api/activity?device=golden-2&days=7"},
+	{"api-activity-range.json", "/api/activity?device=golden-2&from=2025-06-13&to=2025-06-14"},
+	{"api-uptime.json", "/api/uptime?device=golden-1&days=7"},
+	{"api-forecast.json", "/api/forecast?device=golden-2"},
+	{"api-annotations.json", "/api/annotations?device=golden-1"},
+	{"geo.json", "/api/geo.json"},
+}
+
+// seedGolden writes the fixture into the open store
+func seedGolden(ctx context.Context) error {
+	sims := []*simDevice{
+		newSimDevice("golden-1", simSteady, 1),
+		newSimDevice("golden-2", simDiurnal, 2),
+		newSimDevice("golden-3", simBursty, 3),
+	}
+	if _, err := simulateBackfill(sims, 7*24*time.Hour, 5*time.Minute); err != nil {
+		return err
+	}
+	located := []struct {
+		id, tz   string
+		lat, lon float64
+	}{
+		{"golden-1", "America/Denver", 40.015, -105.2705},
+		{"golden-2", "Europe/Berlin", 52.52, 13.405},
+	}
+	for _, d := range located {
+		if err := store.setDeviceLocation(ctx, d.id, d.lat, d.lon); err != nil {
+			return err
+		}
+		if err := store.setDeviceTimezone(ctx, d.id, d.tz); err != nil {
+			return err
+		}
+		if err := store.setDeviceGroup(ctx, d.id, "rooftops"); err != nil {
+			return err
+		}
+	}
+	start := goldenNow.Add(-50 * time.Hour).Truncate(time.Hour)
+	if _, err := store.addAnnotation(ctx, Annotation{DeviceID: "golden-1", Start: start, End: start.Add(3 * time.Hour), Note: "Antenna moved to the roof"}); err != nil {
+		return err
+	}
+	store.loadLatest(ctx)
+	store.loadFrequencyPlan(ctx)
+	return nil
+}
+
+func TestGolden(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join("testdata", "golden")
+
+	env, local, now := os.Environ(), time.Local, timeNow
+	t.Cleanup(func() {
+		os.Clearenv()
+		for _, kv := range env {
+			k, v, _ := strings.Cut(kv, "=")
+			os.Setenv(k, v)
+		}
+		time.Local, timeNow = local, now
+	})
+	os.Clearenv()
+	time.Local = time.UTC
+	timeNow = func() time.Time { return goldenNow }
+
+	if _, err := openStore(Config{DBPath: filepath.Join(t.TempDir(), "golden.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+	if err := seedGolden(ctx); err != nil {
+		t.Fatalf("seeding fixture: %v", err)
+	}
+	registerRoutes()
+	handler := timeoutGuard(adminGuard(http.DefaultServeMux))
+
+	for _, p := range goldenPages {
+		t.Run(p.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status %d: %s", p.path, rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+			file := filepath.Join(dir, p.name)
+			if *update {
+				if err := os.WriteFile(file, rec.Body.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line, got, exp, same := firstDifference(rec.Body.Bytes(), want); !same {
+				t.Errorf("%s differs at line %d; if the change is intended, rerun with -update and review the diff\n  want: %s\n  got:  %s",
+					p.path, line, exp, got)
+			}
+		})
+	}
+}
+
+// firstDifference finds the first line where got and want differ, 1-based
+func firstDifference(got, want []byte) (line int, gotLine, wantLine string, same bool) {
+	if bytes.Equal(got, want) {
+		return 0, "", "", true
+	}
+	g, w := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		var a, b string
+		if i < len(g) {
+			a = g[i]
+		}
+		if i < len(w) {
+			b = w[i]
+		}
+		if a != b || i >= len(g) || i >= len(w) {
+			clip := func(s string) string {
+				if len(s) > 200 {
+					return s[:200] + "..."
+				}
+				return s
+			}
+			return i + 1, clip(a), clip(b), false
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// GroupInfo describes a named set of detectors, usually one site
+type GroupInfo struct {
+	Name      string          `json:"name"`
+	Devices   []string        `json:"devices"`
+	Summaries []PeriodSummary `json:"summaries,omitempty"`
+}
+
+// deviceFilter returns a SQL condition restricting device_id to the given IDs,
+// or an always-true condition when ids is nil (everything, the global view)
+func deviceFilter(ids []string) (string, []interface{}) {
+	if ids == nil {
+		return "1 = 1", nil
+	}
+	if len(ids) == 0 {
+		return "1 = 0", nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "device_id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+// setDeviceGroup assigns a detector to a group; an empty name removes it from its group
+func (s *Store) setDeviceGroup(ctx context.Context, deviceID, group string) error {
+	var value interface{}
+	if group != "" {
+		value = group
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, group_name) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET group_name = excluded.group_name
+	`, deviceID, value)
+	return err
+}
+
+// getGroups returns every group and its members, sorted by name
+func (s *Store) getGroups(ctx context.Context) []GroupInfo {
+	members := make(map[string][]string)
+	for _, d := range s.getDevices(ctx) {
+		if d.Group != "" {
+			members[d.Group] = append(members[d.Group], d.DeviceID)
+		}
+	}
+	groups := make([]GroupInfo, 0, len(members))
+	for name, ids := range members {
+		groups = append(groups, GroupInfo{Name: name, Devices: ids})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// getGroupDevices returns the members of one group, or nil if it doesn't exist
+func (s *Store) getGroupDevices(ctx context.Context, name string) []string {
+	for _, g := range s.getGroups(ctx) {
+		if g.Name == name {
+			return g.Devices
+		}
+	}
+	return nil
+}
+
+// validGroupName keeps group names usable as a URL path segment
+func validGroupName(name string) bool {
+	return len(name) <= 64 && !strings.ContainsAny(name, "/?#%")
+}
+
+// handleGroup renders the dashboard restricted to one group: GET /group/{name}
+func handleGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.PathValue("name")
+	ids := store.getGroupDevices(ctx, name)
+	if ids == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rng, ok, err := parseDateRange(r.URL.Query(), store.locationFor(ctx, ids))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var custom *DateRange
+	if ok {
+		custom = &rng
+	}
+	renderDashboard(ctx, w, requestLang(w, r), requestCalm(w, r), name, ids, custom)
+}
+
+// handleAPIGroups lists groups with 7/30-day aggregates (GET, optional ?name=)
+// or assigns a detector to a group (POST ?device=ID&group=NAME; empty group removes it)
+func handleAPIGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPost {
+		deviceID := r.FormValue("device")
+		group := strings.TrimSpace(r.FormValue("group"))
+		if deviceID == "" || !validGroupName(group) {
+			http.Error(w, "device required; group must not contain / ? # %", http.StatusBadRequest)
+			return
+		}
+		if err := quotas.groupFull(ctx, deviceID, group); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err := store.setDeviceGroup(ctx, deviceID, group); err != nil {
+			log.Printf("Error saving device group: %v", err)
+			http.Error(w, "Failed to save group", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.getDevice(ctx, deviceID))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	groups := []GroupInfo{}
+	for _, g := range store.getGroups(ctx) {
+		if name != "" && g.Name != name {
+			continue
+		}
+		for _, days := range []int{7, 30} {
+			g.Summaries = append(g.Summaries, store.getSummary(ctx, days, g.Devices...))
+		}
+		groups = append(groups, g)
+	}
+	if name != "" && len(groups) == 0 {
+		http.Error(w, "Unknown group", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
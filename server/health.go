@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DeviceHealth is optional telemetry about the detector itself. It is embedded
+// in Stats, so the fields sit at the top level of the upload JSON.
+type DeviceHealth struct {
+	FreeHeap     *int     `json:"free_heap,omitempty"`     // Bytes
+	WiFiRSSI     *int     `json:"wifi_rssi,omitempty"`     // dBm
+	BatteryV     *float64 `json:"battery_v,omitempty"`     // Volts
+	TemperatureC *float64 `json:"temperature_c,omitempty"` // Board temperature
+	ResetReason  string   `json:"reset_reason,omitempty"`  // Reported once after boot
+	LastError    string   `json:"last_error,omitempty"`
+}
+
+// Reported reports whether the upload carried any health fields
+func (h DeviceHealth) Reported() bool {
+	return h.FreeHeap != nil || h.WiFiRSSI != nil || h.BatteryV != nil ||
+		h.TemperatureC != nil || h.ResetReason != "" || h.LastError != ""
+}
+
+// HealthSample is one stored health reading
+type HealthSample struct {
+	Time time.Time `json:"time"`
+	DeviceHealth
+}
+
+func (s *Store) saveHealth(ctx context.Context, deviceID string, ts time.Time, h DeviceHealth) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, insertHealthSQL, healthArgs(deviceID, ts, h)...)
+	return err
+}
+
+const insertHealthSQL = `
+	INSERT INTO device_health (device_id, timestamp, free_heap, wifi_rssi, battery_v,
+		temperature_c, reset_reason, last_error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// healthArgs returns the insertHealthSQL arguments for a health report
+func healthArgs(deviceID string, ts time.Time, h DeviceHealth) []interface{} {
+	return []interface{}{deviceID, ts.Format("2006-01-02 15:04:05"), h.FreeHeap, h.WiFiRSSI, h.BatteryV,
+		h.TemperatureC, h.ResetReason, h.LastError}
+}
+
+// getHealthHistory returns a device's health readings over the last n days, oldest first
+func (s *Store) getHealthHistory(ctx context.Context, deviceID string, days int) []HealthSample {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT timestamp, free_heap, wifi_rssi, battery_v, temperature_c, reset_reason, last_error
+		FROM device_health
+		WHERE device_id = ? AND timestamp > ?
+		ORDER BY timestamp
+	`, deviceID, dbTimeAgo(time.Duration(days)*24*time.Hour))
+	if err != nil {
+		log.Printf("Error loading device health: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var samples []HealthSample
+	for rows.Next() {
+		var ts string
+		var heap, rssi sql.NullInt64
+		var batt, temp sql.NullFloat64
+		var h HealthSample
+		if err := rows.Scan(&ts, &heap, &rssi, &batt, &temp, &h.ResetReason, &h.LastError); err != nil {
+			continue
+		}
+		h.Time = parseDBTime(ts)
+		if heap.Valid {
+			v := int(heap.Int64)
+			h.FreeHeap = &v
+		}
+		if rssi.Valid {
+			v := int(rssi.Int64)
+			h.WiFiRSSI = &v
+		}
+		if batt.Valid {
+			h.BatteryV = &batt.Float64
+		}
+		if temp.Valid {
+			h.TemperatureC = &temp.Float64
+		}
+		samples = append(samples, h)
+	}
+	return samples
+}
+
+// healthSeries pulls one metric out of a history for charting, skipping
+// samples without it. Samples further apart than gapAfter (the device was
+// offline) get a NaN between them, so the line breaks there.
+func healthSeries(samples []HealthSample, get func(DeviceHealth) *float64, gapAfter time.Duration) ([]time.Time, []float64) {
+	var times []time.Time
+	var values []float64
+	for _, s := range samples {
+		if v := get(s.DeviceHealth); v != nil {
+			if n := len(times); n > 0 && s.Time.Sub(times[n-1]) > gapAfter {
+				times = append(times, times[n-1].Add(gapAfter))
+				values = append(values, math.NaN())
+			}
+			times = append(times, s.Time)
+			values = append(values, *v)
+		}
+	}
+	return times, values
+}
+
+func intMetric(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v)
+	return &f
+}
+
+// healthMetrics lists the charted metrics in display order
+var healthMetrics = []struct {
+	Label  string
+	Unit   string
+	Format string
+	Color  string
+	Get    func(DeviceHealth) *float64
+}{
+	{"Battery", "V", "%.2f", "#4CAF50", func(h DeviceHealth) *float64 { return h.BatteryV }},
+	{"Temperature", "°C", "%.1f", "#FF9800", func(h DeviceHealth) *float64 { return h.TemperatureC }},
+	{"Wi-Fi RSSI", "dBm", "%.0f", "#00BCD4", func(h DeviceHealth) *float64 { return intMetric(h.WiFiRSSI) }},
+	{"Free Heap", "KB", "%.0f", "#9C27B0", func(h DeviceHealth) *float64 {
+		if h.FreeHeap == nil {
+			return nil
+		}
+		kb := float64(*h.FreeHeap) / 1024
+		return &kb
+	}},
+}
+
+// handleAPIHealth returns a device's health history: GET /api/health?device=ID&days=7
+func handleAPIHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days := 7
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+
+	samples := store.getHealthHistory(ctx, deviceID, days)
+	if samples == nil {
+		samples = []HealthSample{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+// handleDevice renders the per-device page: GET /device?device=ID&days=7. The
+// detection charts can instead show from=YYYY-MM-DD&to=YYYY-MM-DD, which
+// zooming into the daily chart sets.
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days := 7
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	device := store.getDevice(ctx, deviceID)
+	loc := device.Location()
+	rng, zoomed, err := parseDateRange(r.URL.Query(), loc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Notes are added and deleted from the form at the bottom of the page, the
+	// upload interval set from the uptime card and a quarantine ended from its banner
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		if action := r.FormValue("quarantine"); action != "" {
+			var err error
+			switch action {
+			case "release":
+				_, err = store.releaseDevice(ctx, deviceID)
+			case "discard":
+				_, err = store.discardHeld(ctx, deviceID)
+			default:
+				http.Error(w, "quarantine must be release or discard", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("Error ending quarantine of %s: %v", deviceID, err)
+				http.Error(w, "Failed to update quarantine", http.StatusInternalServerError)
+				return
+			}
+		} else if _, ok := r.Form["upload_interval_sec"]; ok {
+			interval, err := parseUploadInterval(r.FormValue("upload_interval_sec"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := store.setUploadInterval(ctx, deviceID, interval); err != nil {
+				log.Printf("Error saving upload interval: %v", err)
+				http.Error(w, "Failed to save upload interval", http.StatusInternalServerError)
+				return
+			}
+		} else if id, err := strconv.ParseInt(r.FormValue("delete"), 10, 64); err == nil {
+			if _, err := store.deleteAnnotation(ctx, id); err != nil {
+				log.Printf("Error deleting annotation: %v", err)
+				http.Error(w, "Failed to delete note", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			noteDevice := deviceID
+			if r.FormValue("all") != "" {
+				noteDevice = ""
+			}
+			a, err := annotationFromForm(r, noteDevice, loc)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, err := store.addAnnotation(ctx, a); err != nil {
+				log.Printf("Error saving annotation: %v", err)
+				http.Error(w, "Failed to save note", http.StatusInternalServerError)
+				return
+			}
+		}
+		http.Redirect(w, r, fmt.Sprintf("/device?device=%s&days=%d", url.QueryEscape(deviceID), days), http.StatusSeeOther)
+		return
+	}
+
+	samples := store.getHealthHistory(ctx, deviceID, days)
+	notes := store.getAnnotations(ctx, []string{deviceID}, timeNow().AddDate(0, 0, -days), timeNow())
+	// Storm days and nights are shaded on the charts but aren't notes to list or delete
+	chartNotes := slices.Concat(notes, store.solarAnnotations(ctx, timeNow().AddDate(0, 0, -days), timeNow()))
+	lineNotes := slices.Concat(chartNotes, deviceNights(device, timeNow().AddDate(0, 0, -days), timeNow()))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Detector "+deviceID, `    <style>
+        .health-grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(280px, 1fr)); gap: 20px; }
+        .health-now { font-size: 1.6em; font-weight: bold; }
+        .health-range { color: #888; font-size: 0.8em; }
+        .event-log td { padding: 4px 10px 4px 0; font-size: 0.9em; }
+        .annotation-form { display: flex; flex-wrap: wrap; gap: 8px; align-items: center; margin-top: 12px; }
+        .annotation-form input[type=text] { flex: 1; min-width: 200px; }
+        .quarantine-warn { background: rgba(255,82,82,0.15); color: #ff8a80; padding: 8px 12px; border-radius: 8px; }
+        .quarantine-warn button { margin-left: 8px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>📟 Detector Health</h1>
+    <p class="subtitle"><span class="device-id">%s</span> · last %d days · %d health reports</p>
+`, html.EscapeString(deviceID), days, len(samples))
+
+	if note := quarantineNote(ctx, device); note != "" {
+		fmt.Fprintf(w, `    <form method="post" class="quarantine-warn">🚧 %s
+        <button type="submit" name="quarantine" value="release">Release and merge</button>
+        <button type="submit" name="quarantine" value="discard">Discard held</button>
+    </form>
+`, html.EscapeString(note))
+	}
+	if bat, ok := store.getBatteryStatus(ctx, deviceID); ok && bat.Level != batteryOK {
+		fmt.Fprintf(w, `    <p class="battery-warn battery-%s">🔋 Battery %s: %.2f V (low %.2f V, critical %.2f V)</p>
+`, bat.Level, bat.Level, bat.Voltage, bat.LowV, bat.CriticalV)
+	}
+
+	if len(samples) == 0 {
+		fmt.Fprintf(w, `    <div class="card"><div class="no-data"><p>This detector hasn't reported health telemetry
+        (<code>battery_v</code>, <code>temperature_c</code>, <code>wifi_rssi</code>, <code>free_heap</code>).</p></div></div>
+`)
+	} else {
+		fmt.Fprintf(w, `    <div class="card">
+        <div class="health-grid">
+`)
+		for _, m := range healthMetrics {
+			times, values := healthSeries(samples, m.Get, device.Cadence().OfflineAfter)
+			if len(values) == 0 {
+				continue
+			}
+			lo, hi := values[0], values[0]
+			for _, v := range values {
+				if !math.IsNaN(v) {
+					lo, hi = min(lo, v), max(hi, v)
+				}
+			}
+			fmt.Fprintf(w, `            <div>
+                <div class="chart-label">%s</div>
+                <div class="health-now" style="color: %s;">`+m.Format+` %s</div>
+                <div class="health-range">min `+m.Format+` · max `+m.Format+`</div>
+                `, m.Label, m.Color, values[len(values)-1], m.Unit, lo, hi)
+			renderLineChart(w, times, values, m.Color, 280, 60, lineNotes...)
+			fmt.Fprintf(w, `
+            </div>
+`)
+		}
+		fmt.Fprintf(w, `        </div>
+    </div>
+`)
+
+		// Resets and errors are rare and worth listing individually
+		var logged []HealthSample
+		for i := len(samples) - 1; i >= 0 && len(logged) < 20; i-- {
+			if samples[i].ResetReason != "" || samples[i].LastError != "" {
+				logged = append(logged, samples[i])
+			}
+		}
+		if len(logged) > 0 {
+			fmt.Fprintf(w, `    <div class="card">
+        <h2>Resets &amp; Errors</h2>
+        <table class="event-log">
+`)
+			for _, s := range logged {
+				fmt.Fprintf(w, `            <tr><td class="timestamp">%s</td><td>%s</td><td>%s</td></tr>
+`, s.Time.Format("Jan 2 15:04"), html.EscapeString(s.ResetReason), html.EscapeString(s.LastError))
+			}
+			fmt.Fprintf(w, `        </table>
+    </div>
+`)
+		}
+	}
+
+	renderUptime(w, store.getUptime(ctx, deviceID, device.Cadence(), days), days)
+
+	page := fmt.Sprintf("/device?device=%s&days=%d", url.QueryEscape(deviceID), days)
+	hourLabel := fmt.Sprintf("Detections over the last %d days by local hour", days)
+	if zoomed {
+		notes := store.getAnnotations(ctx, []string{deviceID}, rng.Start, rng.End)
+		chartNotes = slices.Concat(notes, store.solarAnnotations(ctx, rng.Start, rng.End))
+		hourLabel = fmt.Sprintf("Detections from %s to %s by local hour", rng.From(), rng.To())
+	} else {
+		rng = lastDays(days, loc)
+	}
+	activity := store.getActivityRange(ctx, []string{deviceID}, rng)
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Daily Detections</h2>
+`)
+	var custom *DateRange
+	if zoomed {
+		custom = &rng
+	}
+	renderRangePicker(w, defaultLang, "/device", url.Values{"device": {deviceID}, "days": {strconv.Itoa(days)}}, custom, page)
+	fmt.Fprintf(w, `        `)
+	renderZoomableBars(w, activity.Days, activity.Daily, page, 480, 100)
+	if from, to, ok := activitySpan(activity, loc); ok {
+		renderAnnotationStrip(w, chartNotes, from, to, 480)
+		fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>%s</span><span>%s</span></div>
+`, activity.Days[0], activity.Days[len(activity.Days)-1])
+	}
+	fmt.Fprintf(w, `    </div>
+    <div class="card">
+        <h2>Activity by Hour of Day</h2>
+        <div class="chart-label">%s (%s)</div>
+        `, hourLabel, html.EscapeString(activity.Timezone))
+	renderStackedBars(w, activity.Hourly, 480, 100)
+	// Today's night under the hours, as a guide to which hours are dark
+	midnight := timeNow().In(loc)
+	midnight = time.Date(midnight.Year(), midnight.Month(), midnight.Day(), 0, 0, 0, 0, loc)
+	renderAnnotationStrip(w, deviceNights(device, midnight, midnight.AddDate(0, 0, 1)), midnight, midnight.AddDate(0, 0, 1), 480)
+	fmt.Fprintf(w, `
+        <div class="chart-label" style="display: flex; justify-content: space-between;"><span>00:00</span><span>12:00</span><span>23:00</span></div>
+    </div>
+`)
+	renderAnnotationList(w, notes, deviceID, days)
+	io.WriteString(w, zoomScript)
+
+	fmt.Fprintf(w, `    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
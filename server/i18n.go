@@ -0,0 +1,670 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lang is a supported dashboard language code. Strings are looked up by their
+// English text, so untranslated strings fall back to English.
+type Lang string
+
+const defaultLang Lang = "en"
+
+// languages lists the selectable languages with their own names, in menu order
+var languages = []struct {
+	Code Lang
+	Name string
+}{
+	{"en", "English"},
+	{"de", "Deutsch"},
+	{"es", "Español"},
+	{"fr", "Français"},
+}
+
+// T translates an English dashboard string
+func (l Lang) T(s string) string {
+	if t, ok := bundles[l][s]; ok {
+		return t
+	}
+	return s
+}
+
+// Tf translates a format string and applies it
+func (l Lang) Tf(format string, args ...interface{}) string {
+	return fmt.Sprintf(l.T(format), args...)
+}
+
+// DateTime formats a full timestamp the way the language's readers expect
+func (l Lang) DateTime(t time.Time) string {
+	switch l {
+	case "de":
+		return t.Format("02.01.2006 15:04 MST")
+	case "es", "fr":
+		return t.Format("02/01/2006 15:04 MST")
+	}
+	return t.Format("Jan 2, 2006 at 3:04 PM MST")
+}
+
+// ShortDateTime formats a timestamp without the year
+func (l Lang) ShortDateTime(t time.Time) string {
+	switch l {
+	case "de":
+		return t.Format("02.01. 15:04")
+	case "es", "fr":
+		return t.Format("02/01 15:04")
+	}
+	return t.Format("Jan 2 3:04 PM")
+}
+
+// Hours renders a projected duration coarsely; battery projections aren't precise
+func (l Lang) Hours(h float64) string {
+	if h < 1 {
+		return l.T("within the hour")
+	}
+	if h < 48 {
+		return l.Tf("in about %.0f hours", h)
+	}
+	return l.Tf("in about %.0f days", h/24)
+}
+
+// LastHeard says how long ago a silent detector last uploaded
+func (l Lang) LastHeard(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return l.Tf("last heard %d min ago", max(1, int(d.Minutes())))
+	case d < 48*time.Hour:
+		return l.Tf("last heard %dh ago", int(d.Hours()))
+	}
+	return l.Tf("last heard %d days ago", int(d.Hours()/24))
+}
+
+func supportedLang(code string) (Lang, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	for _, l := range languages {
+		if string(l.Code) == code {
+			return l.Code, true
+		}
+	}
+	return "", false
+}
+
+// requestLang picks the dashboard language: an explicit ?lang= (remembered in a
+// cookie), then the cookie, then the browser's Accept-Language preferences
+func requestLang(w http.ResponseWriter, r *http.Request) Lang {
+	if l, ok := supportedLang(r.URL.Query().Get("lang")); ok {
+		http.SetCookie(w, &http.Cookie{Name: "lang", Value: string(l), Path: "/", MaxAge: 365 * 24 * 3600})
+		return l
+	}
+	if c, err := r.Cookie("lang"); err == nil {
+		if l, ok := supportedLang(c.Value); ok {
+			return l
+		}
+	}
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// acceptLanguage returns the highest-weighted supported language in an Accept-Language header
+func acceptLanguage(header string) Lang {
+	type pref struct {
+		lang Lang
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if l, ok := supportedLang(tag); ok && q > 0 {
+			prefs = append(prefs, pref{l, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	if len(prefs) > 0 {
+		return prefs[0].lang
+	}
+	return defaultLang
+}
+
+// bundles holds the translations, keyed by the English string
+var bundles = map[Lang]map[string]string{
+	"de": {
+		"LoRa Detector Dashboard":           "LoRa-Detektor-Dashboard",
+		"900 MHz ISM Band Activity Monitor": "Aktivitätsmonitor für das 900-MHz-ISM-Band",
+		"%d uploads stored":                 "%d Uploads gespeichert",
+		"Map":                               "Karte",
+		"Sites":                             "Standorte",
+		"All detectors":                     "Alle Detektoren",
+		"No data received yet":              "Noch keine Daten empfangen",
+		"Double-click the PRG button on your LoRa detector to upload!": "Doppelklicke die PRG-Taste am LoRa-Detektor, um hochzuladen!",
+		"The detector scans 8 frequencies across 903-923 MHz":          "Der Detektor überwacht 8 Frequenzen zwischen 903 und 923 MHz",
+		"detecting Amazon Sidewalk, LoRaWAN, and Meshtastic signals.":  "und erkennt Amazon-Sidewalk-, LoRaWAN- und Meshtastic-Signale.",
+		"Latest Session":   "Letzte Sitzung",
+		"Total Detections": "Erkennungen gesamt",
+		"Per Minute":       "Pro Minute",
+		"Activity":         "Aktivität",
+		"Peak":             "Spitze",
+		"Scan Time":        "Scan-Dauer",
+		"Battery low: %.2f V (low %.2f V, critical %.2f V)":      "Akku schwach: %.2f V (schwach %.2f V, kritisch %.2f V)",
+		"Battery critical: %.2f V (low %.2f V, critical %.2f V)": "Akku kritisch: %.2f V (schwach %.2f V, kritisch %.2f V)",
+		"critical %s":                             "kritisch %s",
+		"within the hour":                         "innerhalb einer Stunde",
+		"in about %.0f hours":                     "in etwa %.0f Stunden",
+		"in about %.0f days":                      "in etwa %.0f Tagen",
+		"Antenna heading %.0f°":                   "Antennenausrichtung %.0f°",
+		"detections by bearing":                   "Erkennungen nach Richtung",
+		"Detections, last 24 hours":               "Erkennungen, letzte 24 Stunden",
+		"Last 60 minutes":                         "Letzte 60 Minuten",
+		"Live: last 60 seconds":                   "Live: letzte 60 Sekunden",
+		"One column per second":                   "Eine Spalte pro Sekunde",
+		"What You Detected":                       "Was erkannt wurde",
+		"Ring doorbells & cameras":                "Ring-Türklingeln & -Kameras",
+		"Echo (4th gen+) speakers":                "Echo-Lautsprecher (ab 4. Gen.)",
+		"Tile trackers":                           "Tile-Tracker",
+		"Level smart locks":                       "Level-Smart-Locks",
+		"Off-grid mesh communicators":             "Netzunabhängige Mesh-Funkgeräte",
+		"Hiker/outdoor devices":                   "Wander-/Outdoor-Geräte",
+		"Emergency comms":                         "Notfallkommunikation",
+		"DIY LoRa nodes":                          "Selbstbau-LoRa-Knoten",
+		"Smart utility meters":                    "Intelligente Zähler",
+		"Parking sensors":                         "Parksensoren",
+		"Agricultural monitors":                   "Landwirtschaftssensoren",
+		"Industrial sensors":                      "Industriesensoren",
+		"%s nearby":                               "%s in der Nähe",
+		"(estimated from the last 24h of events)": "(geschätzt aus den Ereignissen der letzten 24 h)",
+		"Sidewalk device":                         "Sidewalk-Gerät",
+		"Sidewalk devices":                        "Sidewalk-Geräte",
+		"Meshtastic node":                         "Meshtastic-Knoten",
+		"Meshtastic nodes":                        "Meshtastic-Knoten",
+		"LoRaWAN sensor":                          "LoRaWAN-Sensor",
+		"LoRaWAN sensors":                         "LoRaWAN-Sensoren",
+		"Frequency Breakdown":                     "Aufschlüsselung nach Frequenz",
+		"IoT sensors, industrial monitors":        "IoT-Sensoren, Industrieüberwachung",
+		"Smart agriculture, asset trackers":       "Smart Farming, Objekt-Tracker",
+		"Environmental sensors, weather stations": "Umweltsensoren, Wetterstationen",
+		"Off-grid mesh communicators, hikers":     "Mesh-Funkgeräte, Wanderer",
+		"Utility meters, parking sensors":         "Zähler, Parksensoren",
+		"Ring, Echo, Tile, smart locks":           "Ring, Echo, Tile, Smart Locks",
+		"Smart city infrastructure":               "Smart-City-Infrastruktur",
+		"Gateway responses, ACKs":                 "Gateway-Antworten, ACKs",
+		"Percentages show deviation from baseline calibrated %s": "Prozentwerte zeigen die Abweichung von der Basislinie vom %s",
+		"Calibrating baseline until %s":                          "Basislinie wird kalibriert bis %s",
+		"Periodic Transmitters (24h)":                            "Periodische Sender (24 h)",
+		"every %s":                                               "alle %s",
+		"%d hits":                                                "%d Treffer",
+		"Recent Alerts":                                          "Aktuelle Warnungen",
+		"Historical Summary":                                     "Verlauf",
+		"Calendar days in %s":                                    "Kalendertage in %s",
+		"7 Days":                                                 "7 Tage",
+		"30 Days":                                                "30 Tage",
+		"90 Days":                                                "90 Tage",
+		"1 Year":                                                 "1 Jahr",
+		"Uploads":                                                "Uploads",
+		"Detections":                                             "Erkennungen",
+		"Avg Det/min":                                            "Ø Erk./min",
+		"Peak Activity":                                          "Spitzenaktivität",
+		"Auto-refreshes every %d seconds":                        "Aktualisiert sich alle %d Sekunden",
+		"Older data kept as hourly and daily totals": "Ältere Daten als Stunden- und Tagessummen aufbewahrt",
+		"Sound":                      "Ton",
+		"Notify":                     "Benachrichtigen",
+		"Alert at":                   "Alarm ab",
+		"hits/min":                   "Treffer/min",
+		"%d hits in the last minute": "%d Treffer in der letzten Minute",
+		"Meshtastic packet heard by %s on %s MHz": "Meshtastic-Paket von %s auf %s MHz empfangen",
+		"Frequency":                                  "Frequenz",
+		"Band":                                       "Band",
+		"Share of busiest channel":                   "Anteil am aktivsten Kanal",
+		"%d%% of the busiest channel":                "%d%% des aktivsten Kanals",
+		"Detections per frequency from %s":           "Erkennungen pro Frequenz von %s",
+		"Skip to detectors":                          "Zu den Detektoren springen",
+		"Print report":                               "Bericht drucken",
+		"Detectors":                                  "Detektoren",
+		"Turn off animation":                         "Animation ausschalten",
+		"Turn animation back on":                     "Animation wieder einschalten",
+		"Animation and auto-refresh off":             "Animation und automatische Aktualisierung aus",
+		"Detections per minute, recent":              "Erkennungen pro Minute, zuletzt",
+		"Detections per second over the last minute": "Erkennungen pro Sekunde in der letzten Minute",
+		"%.0f detections in the last 24 hours, at most %.0f in one hour": "%.0f Erkennungen in den letzten 24 Stunden, höchstens %.0f in einer Stunde",
+		"Mobile":         "Mobil",
+		"Full dashboard": "Vollständiges Dashboard",
+		"Ephemeral mode: data is kept in memory and will be lost when the server stops.": "Flüchtiger Modus: Die Daten liegen nur im Arbeitsspeicher und gehen verloren, wenn der Server stoppt.",
+		"900 MHz and 2.4 GHz LoRa Activity Monitor":                                      "LoRa-Aktivitätsmonitor für 900 MHz und 2,4 GHz",
+		"2.4 GHz ISM Band Activity Monitor":                                              "Aktivitätsmonitor für das 2,4-GHz-ISM-Band",
+		"2.4 GHz":                                                                        "2,4 GHz",
+		"Other":                                                                          "Sonstige",
+		"ExpressLRS RC links":                                                            "ExpressLRS-Fernsteuerungen",
+		"SX1280 ranging tags":                                                            "SX1280-Ortungs-Tags",
+		"Meshtastic 2.4 GHz nodes":                                                       "Meshtastic-Knoten auf 2,4 GHz",
+		"2.4 GHz LoRaWAN sensors":                                                        "LoRaWAN-Sensoren auf 2,4 GHz",
+		"2.4 GHz LoRa device":                                                            "2,4-GHz-LoRa-Gerät",
+		"2.4 GHz LoRa devices":                                                           "2,4-GHz-LoRa-Geräte",
+		"SX1280 sensors, 2.4 GHz LoRaWAN":                                                "SX1280-Sensoren, LoRaWAN auf 2,4 GHz",
+		"Long-range 2.4 GHz telemetry":                                                   "Telemetrie mit großer Reichweite auf 2,4 GHz",
+		"Ranging tags, RC links":                                                         "Ortungs-Tags, Fernsteuerungen",
+		"ISM Band Activity Monitor: %s":                                                  "Aktivitätsmonitor für ISM-Bänder: %s",
+		"Outdoor temperature/humidity sensors":                                           "Außensensoren für Temperatur/Feuchte",
+		"Rain gauges":                                                                    "Regenmesser",
+		"Anemometers":                                                                    "Windmesser",
+		"Pool and soil thermometers":                                                     "Pool- und Bodenthermometer",
+		"weather sensor":                                                                 "Wettersensor",
+		"weather sensors":                                                                "Wettersensoren",
+		"Car tire pressure monitors (TPMS)":                                              "Reifendrucksensoren (RDKS)",
+		"Truck and trailer tire sensors":                                                 "Reifensensoren für Lkw und Anhänger",
+		"Motorcycle TPMS":                                                                "Motorrad-RDKS",
+		"tire sensor":                                                                    "Reifensensor",
+		"tire sensors":                                                                   "Reifensensoren",
+		"Wireless doorbells":                                                             "Funkklingeln",
+		"Garage and gate remotes":                                                        "Garagen- und Torfernbedienungen",
+		"Car key fobs":                                                                   "Autoschlüssel",
+		"Alarm and door sensors":                                                         "Alarm- und Türsensoren",
+		"remote":                                                                         "Fernbedienung",
+		"remotes":                                                                        "Fernbedienungen",
+		"Doorbells, remotes, tire sensors":                                               "Klingeln, Fernbedienungen, Reifensensoren",
+		"Weather stations, home sensors":                                                 "Wetterstationen, Haussensoren",
+		"Spreading Factor Breakdown (24h)":                                               "Spreizfaktoren (24 h)",
+		"Detections by spreading factor per frequency":                                   "Erkennungen nach Spreizfaktor je Frequenz",
+		"Occupancy report for an interference complaint":                                 "Belegungsbericht für eine Störungsbeschwerde",
+		"%s %s× the regional median":                                                     "%s %s× so viel wie der regionale Median",
+		"%s about the regional median":                                                   "%s etwa beim regionalen Median",
+		"no %s, against a regional median of %.1f an hour":                               "keine %s, bei einem regionalen Median von %.1f pro Stunde",
+		"(last %dh, against %d community stations within %.0f km)":                       "(letzte %d h, verglichen mit %d Community-Stationen im Umkreis von %.0f km)",
+		"Channel":               "Kanal",
+		"Bandwidth":             "Bandbreite",
+		"Likely":                "Vermutlich",
+		"Sidewalk (fixed rate)": "Sidewalk (feste Datenrate)",
+		"LoRaWAN downlink":      "LoRaWAN-Downlink",
+		"Category":              "Kategorie",
+		"Airtime":               "Sendezeit",
+		"Busiest hour":          "Stärkste Stunde",
+		"Duty-cycle limit":      "Duty-Cycle-Grenze",
+		"none":                  "keine",
+		"Estimated from spreading factor, bandwidth and packet length; packets without a length count as %d bytes. The busiest hour is spread across the category's channels.": "Geschätzt aus Spreizfaktor, Bandbreite und Paketlänge; Pakete ohne Länge zählen als %d Bytes. Die stärkste Stunde ist auf die Kanäle der Kategorie verteilt.",
+		"Airtime and Duty Cycle (24h)":        "Sendezeit und Duty Cycle (24 h)",
+		"Estimated airtime per hour":          "Geschätzte Sendezeit pro Stunde",
+		"Airtime and duty cycle per category": "Sendezeit und Duty Cycle je Kategorie",
+		"detections and weather":              "Erkennungen und Wetter",
+		"Auto-refresh off":                    "Automatische Aktualisierung aus",
+		"From":                                "Von",
+		"To":                                  "Bis",
+		"Show range":                          "Zeitraum anzeigen",
+		"Back to the usual periods":           "Zurück zu den üblichen Zeiträumen",
+		"%d of %d detectors":                  "%d von %d Detektoren",
+		"%s: %d of %d uploads today":          "%s: %d von %d Uploads heute",
+		"%s: %d of %d rows":                   "%s: %d von %d Zeilen",
+		"%s: %d of %d detectors":              "%s: %d von %d Detektoren",
+		"%s: %d refused today":                "%s: heute %d abgelehnt",
+		"%d near quota":                       "%d nahe am Kontingent",
+		"%d over quota":                       "%d über dem Kontingent",
+		"Co-located detectors count once, as their average each hour":            "Detektoren am selben Standort zählen einfach, als stündlicher Durchschnitt",
+		"Co-located detectors count once, by whichever heard the most each hour": "Detektoren am selben Standort zählen einfach, jeweils der mit den meisten Erkennungen pro Stunde",
+		"last heard %d min ago":  "zuletzt vor %d Min. gehört",
+		"last heard %dh ago":     "zuletzt vor %d Std. gehört",
+		"last heard %d days ago": "zuletzt vor %d Tagen gehört",
+		"%s: %d waiting":         "%s: %d wartend",
+		"%d waiting to forward":  "%d warten auf Weiterleitung",
+		"Forwarding failing":     "Weiterleitung gestört",
+	},
+	"es": {
+		"LoRa Detector Dashboard":           "Panel del detector LoRa",
+		"900 MHz ISM Band Activity Monitor": "Monitor de actividad de la banda ISM de 900 MHz",
+		"%d uploads stored":                 "%d envíos guardados",
+		"Map":                               "Mapa",
+		"Sites":                             "Sitios",
+		"All detectors":                     "Todos los detectores",
+		"No data received yet":              "Aún no se han recibido datos",
+		"Double-click the PRG button on your LoRa detector to upload!": "¡Pulsa dos veces el botón PRG del detector LoRa para enviar datos!",
+		"The detector scans 8 frequencies across 903-923 MHz":          "El detector explora 8 frecuencias entre 903 y 923 MHz",
+		"detecting Amazon Sidewalk, LoRaWAN, and Meshtastic signals.":  "y detecta señales de Amazon Sidewalk, LoRaWAN y Meshtastic.",
+		"Latest Session":   "Última sesión",
+		"Total Detections": "Detecciones totales",
+		"Per Minute":       "Por minuto",
+		"Activity":         "Actividad",
+		"Peak":             "Pico",
+		"Scan Time":        "Tiempo de escaneo",
+		"Battery low: %.2f V (low %.2f V, critical %.2f V)":      "Batería baja: %.2f V (baja %.2f V, crítica %.2f V)",
+		"Battery critical: %.2f V (low %.2f V, critical %.2f V)": "Batería crítica: %.2f V (baja %.2f V, crítica %.2f V)",
+		"critical %s":                             "crítica %s",
+		"within the hour":                         "en menos de una hora",
+		"in about %.0f hours":                     "en unas %.0f horas",
+		"in about %.0f days":                      "en unos %.0f días",
+		"Antenna heading %.0f°":                   "Orientación de la antena %.0f°",
+		"detections by bearing":                   "detecciones por rumbo",
+		"Detections, last 24 hours":               "Detecciones, últimas 24 horas",
+		"Last 60 minutes":                         "Últimos 60 minutos",
+		"Live: last 60 seconds":                   "En vivo: últimos 60 segundos",
+		"One column per second":                   "Una columna por segundo",
+		"What You Detected":                       "Qué se detectó",
+		"Ring doorbells & cameras":                "Timbres y cámaras Ring",
+		"Echo (4th gen+) speakers":                "Altavoces Echo (4.ª gen. o posterior)",
+		"Tile trackers":                           "Localizadores Tile",
+		"Level smart locks":                       "Cerraduras inteligentes Level",
+		"Off-grid mesh communicators":             "Comunicadores mesh autónomos",
+		"Hiker/outdoor devices":                   "Dispositivos de senderismo/exterior",
+		"Emergency comms":                         "Comunicaciones de emergencia",
+		"DIY LoRa nodes":                          "Nodos LoRa caseros",
+		"Smart utility meters":                    "Contadores inteligentes",
+		"Parking sensors":                         "Sensores de aparcamiento",
+		"Agricultural monitors":                   "Sensores agrícolas",
+		"Industrial sensors":                      "Sensores industriales",
+		"%s nearby":                               "%s cerca",
+		"(estimated from the last 24h of events)": "(estimado con los eventos de las últimas 24 h)",
+		"Sidewalk device":                         "dispositivo Sidewalk",
+		"Sidewalk devices":                        "dispositivos Sidewalk",
+		"Meshtastic node":                         "nodo Meshtastic",
+		"Meshtastic nodes":                        "nodos Meshtastic",
+		"LoRaWAN sensor":                          "sensor LoRaWAN",
+		"LoRaWAN sensors":                         "sensores LoRaWAN",
+		"Frequency Breakdown":                     "Desglose por frecuencia",
+		"IoT sensors, industrial monitors":        "Sensores IoT, monitores industriales",
+		"Smart agriculture, asset trackers":       "Agricultura inteligente, rastreadores",
+		"Environmental sensors, weather stations": "Sensores ambientales, estaciones meteorológicas",
+		"Off-grid mesh communicators, hikers":     "Comunicadores mesh, senderistas",
+		"Utility meters, parking sensors":         "Contadores, sensores de aparcamiento",
+		"Ring, Echo, Tile, smart locks":           "Ring, Echo, Tile, cerraduras inteligentes",
+		"Smart city infrastructure":               "Infraestructura de ciudad inteligente",
+		"Gateway responses, ACKs":                 "Respuestas de gateway, ACK",
+		"Percentages show deviation from baseline calibrated %s": "Los porcentajes muestran la desviación respecto a la línea base calibrada el %s",
+		"Calibrating baseline until %s":                          "Calibrando la línea base hasta el %s",
+		"Periodic Transmitters (24h)":                            "Transmisores periódicos (24 h)",
+		"every %s":                                               "cada %s",
+		"%d hits":                                                "%d aciertos",
+		"Recent Alerts":                                          "Alertas recientes",
+		"Historical Summary":                                     "Resumen histórico",
+		"Calendar days in %s":                                    "Días naturales en %s",
+		"7 Days":                                                 "7 días",
+		"30 Days":                                                "30 días",
+		"90 Days":                                                "90 días",
+		"1 Year":                                                 "1 año",
+		"Uploads":                                                "Envíos",
+		"Detections":                                             "Detecciones",
+		"Avg Det/min":                                            "Media det./min",
+		"Peak Activity":                                          "Actividad máxima",
+		"Auto-refreshes every %d seconds":                        "Se actualiza cada %d segundos",
+		"Older data kept as hourly and daily totals": "Datos antiguos conservados como totales por hora y día",
+		"Sound":                      "Sonido",
+		"Notify":                     "Notificar",
+		"Alert at":                   "Avisar a partir de",
+		"hits/min":                   "aciertos/min",
+		"%d hits in the last minute": "%d aciertos en el último minuto",
+		"Meshtastic packet heard by %s on %s MHz": "Paquete Meshtastic recibido por %s en %s MHz",
+		"Frequency":                                  "Frecuencia",
+		"Band":                                       "Banda",
+		"Share of busiest channel":                   "Proporción del canal más activo",
+		"%d%% of the busiest channel":                "%d%% del canal más activo",
+		"Detections per frequency from %s":           "Detecciones por frecuencia de %s",
+		"Skip to detectors":                          "Saltar a los detectores",
+		"Print report":                               "Imprimir informe",
+		"Detectors":                                  "Detectores",
+		"Turn off animation":                         "Desactivar animaciones",
+		"Turn animation back on":                     "Reactivar animaciones",
+		"Animation and auto-refresh off":             "Animaciones y actualización automática desactivadas",
+		"Detections per minute, recent":              "Detecciones por minuto, recientes",
+		"Detections per second over the last minute": "Detecciones por segundo en el último minuto",
+		"%.0f detections in the last 24 hours, at most %.0f in one hour": "%.0f detecciones en las últimas 24 horas, como máximo %.0f en una hora",
+		"Mobile":         "Móvil",
+		"Full dashboard": "Panel completo",
+		"Ephemeral mode: data is kept in memory and will be lost when the server stops.": "Modo efímero: los datos se guardan solo en memoria y se perderán cuando se detenga el servidor.",
+		"900 MHz and 2.4 GHz LoRa Activity Monitor":                                      "Monitor de actividad LoRa de 900 MHz y 2,4 GHz",
+		"2.4 GHz ISM Band Activity Monitor":                                              "Monitor de actividad de la banda ISM de 2,4 GHz",
+		"2.4 GHz":                                                                        "2,4 GHz",
+		"Other":                                                                          "Otras",
+		"ExpressLRS RC links":                                                            "Radiocontroles ExpressLRS",
+		"SX1280 ranging tags":                                                            "Etiquetas de localización SX1280",
+		"Meshtastic 2.4 GHz nodes":                                                       "Nodos Meshtastic de 2,4 GHz",
+		"2.4 GHz LoRaWAN sensors":                                                        "Sensores LoRaWAN de 2,4 GHz",
+		"2.4 GHz LoRa device":                                                            "dispositivo LoRa de 2,4 GHz",
+		"2.4 GHz LoRa devices":                                                           "dispositivos LoRa de 2,4 GHz",
+		"SX1280 sensors, 2.4 GHz LoRaWAN":                                                "Sensores SX1280, LoRaWAN de 2,4 GHz",
+		"Long-range 2.4 GHz telemetry":                                                   "Telemetría de largo alcance a 2,4 GHz",
+		"Ranging tags, RC links":                                                         "Etiquetas de localización, radiocontroles",
+		"ISM Band Activity Monitor: %s":                                                  "Monitor de actividad de bandas ISM: %s",
+		"Outdoor temperature/humidity sensors":                                           "Sensores exteriores de temperatura/humedad",
+		"Rain gauges":                                                                    "Pluviómetros",
+		"Anemometers":                                                                    "Anemómetros",
+		"Pool and soil thermometers":                                                     "Termómetros de piscina y de suelo",
+		"weather sensor":                                                                 "sensor meteorológico",
+		"weather sensors":                                                                "sensores meteorológicos",
+		"Car tire pressure monitors (TPMS)":                                              "Sensores de presión de neumáticos (TPMS)",
+		"Truck and trailer tire sensors":                                                 "Sensores de neumáticos de camiones y remolques",
+		"Motorcycle TPMS":                                                                "TPMS de motocicletas",
+		"tire sensor":                                                                    "sensor de neumático",
+		"tire sensors":                                                                   "sensores de neumáticos",
+		"Wireless doorbells":                                                             "Timbres inalámbricos",
+		"Garage and gate remotes":                                                        "Mandos de garaje y portones",
+		"Car key fobs":                                                                   "Llaves de coche",
+		"Alarm and door sensors":                                                         "Sensores de alarma y de puertas",
+		"remote":                                                                         "mando",
+		"remotes":                                                                        "mandos",
+		"Doorbells, remotes, tire sensors":                                               "Timbres, mandos, sensores de neumáticos",
+		"Weather stations, home sensors":                                                 "Estaciones meteorológicas, sensores domésticos",
+		"Spreading Factor Breakdown (24h)":                                               "Desglose por factor de dispersión (24 h)",
+		"Detections by spreading factor per frequency":                                   "Detecciones por factor de dispersión y frecuencia",
+		"Occupancy report for an interference complaint":                                 "Informe de ocupación para una queja por interferencias",
+		"%s %s× the regional median":                                                     "%s %s× la mediana regional",
+		"%s about the regional median":                                                   "%s cerca de la mediana regional",
+		"no %s, against a regional median of %.1f an hour":                               "sin %s, frente a una mediana regional de %.1f por hora",
+		"(last %dh, against %d community stations within %.0f km)":                       "(últimas %d h, frente a %d estaciones de la comunidad a menos de %.0f km)",
+		"Channel":               "Canal",
+		"Bandwidth":             "Ancho de banda",
+		"Likely":                "Probable",
+		"Sidewalk (fixed rate)": "Sidewalk (velocidad fija)",
+		"LoRaWAN downlink":      "Enlace descendente LoRaWAN",
+		"Category":              "Categoría",
+		"Airtime":               "Tiempo en el aire",
+		"Busiest hour":          "Hora más activa",
+		"Duty-cycle limit":      "Límite de ciclo de trabajo",
+		"none":                  "ninguno",
+		"Estimated from spreading factor, bandwidth and packet length; packets without a length count as %d bytes. The busiest hour is spread across the category's channels.": "Estimado a partir del factor de dispersión, el ancho de banda y la longitud del paquete; los paquetes sin longitud cuentan como %d bytes. La hora más activa se reparte entre los canales de la categoría.",
+		"Airtime and Duty Cycle (24h)":        "Tiempo en el aire y ciclo de trabajo (24 h)",
+		"Estimated airtime per hour":          "Tiempo en el aire estimado por hora",
+		"Airtime and duty cycle per category": "Tiempo en el aire y ciclo de trabajo por categoría",
+		"detections and weather":              "detecciones y tiempo",
+		"Auto-refresh off":                    "Actualización automática desactivada",
+		"From":                                "Desde",
+		"To":                                  "Hasta",
+		"Show range":                          "Mostrar intervalo",
+		"Back to the usual periods":           "Volver a los periodos habituales",
+		"%d of %d detectors":                  "%d de %d detectores",
+		"%s: %d of %d uploads today":          "%s: %d de %d envíos hoy",
+		"%s: %d of %d rows":                   "%s: %d de %d filas",
+		"%s: %d of %d detectors":              "%s: %d de %d detectores",
+		"%s: %d refused today":                "%s: %d rechazados hoy",
+		"%d near quota":                       "%d cerca de la cuota",
+		"%d over quota":                       "%d por encima de la cuota",
+		"Co-located detectors count once, as their average each hour":            "Los detectores en el mismo lugar cuentan una vez, como su media de cada hora",
+		"Co-located detectors count once, by whichever heard the most each hour": "Los detectores en el mismo lugar cuentan una vez, según el que más detectó cada hora",
+		"last heard %d min ago":  "última vez hace %d min",
+		"last heard %dh ago":     "última vez hace %d h",
+		"last heard %d days ago": "última vez hace %d días",
+		"%s: %d waiting":         "%s: %d en espera",
+		"%d waiting to forward":  "%d pendientes de reenviar",
+		"Forwarding failing":     "Reenvío con fallos",
+	},
+	"fr": {
+		"LoRa Detector Dashboard":           "Tableau de bord du détecteur LoRa",
+		"900 MHz ISM Band Activity Monitor": "Moniteur d'activité de la bande ISM 900 MHz",
+		"%d uploads stored":                 "%d envois enregistrés",
+		"Map":                               "Carte",
+		"Sites":                             "Sites",
+		"All detectors":                     "Tous les détecteurs",
+		"No data received yet":              "Aucune donnée reçue pour l'instant",
+		"Double-click the PRG button on your LoRa detector to upload!": "Appuyez deux fois sur le bouton PRG du détecteur LoRa pour envoyer les données !",
+		"The detector scans 8 frequencies across 903-923 MHz":          "Le détecteur balaie 8 fréquences entre 903 et 923 MHz",
+		"detecting Amazon Sidewalk, LoRaWAN, and Meshtastic signals.":  "et détecte les signaux Amazon Sidewalk, LoRaWAN et Meshtastic.",
+		"Latest Session":   "Dernière session",
+		"Total Detections": "Détections totales",
+		"Per Minute":       "Par minute",
+		"Activity":         "Activité",
+		"Peak":             "Pic",
+		"Scan Time":        "Durée de balayage",
+		"Battery low: %.2f V (low %.2f V, critical %.2f V)":      "Batterie faible : %.2f V (faible %.2f V, critique %.2f V)",
+		"Battery critical: %.2f V (low %.2f V, critical %.2f V)": "Batterie critique : %.2f V (faible %.2f V, critique %.2f V)",
+		"critical %s":                             "critique %s",
+		"within the hour":                         "dans l'heure",
+		"in about %.0f hours":                     "dans environ %.0f heures",
+		"in about %.0f days":                      "dans environ %.0f jours",
+		"Antenna heading %.0f°":                   "Orientation de l'antenne %.0f°",
+		"detections by bearing":                   "détections par direction",
+		"Detections, last 24 hours":               "Détections, dernières 24 heures",
+		"Last 60 minutes":                         "60 dernières minutes",
+		"Live: last 60 seconds":                   "En direct : 60 dernières secondes",
+		"One column per second":                   "Une colonne par seconde",
+		"What You Detected":                       "Ce qui a été détecté",
+		"Ring doorbells & cameras":                "Sonnettes et caméras Ring",
+		"Echo (4th gen+) speakers":                "Enceintes Echo (4e gén. et +)",
+		"Tile trackers":                           "Traceurs Tile",
+		"Level smart locks":                       "Serrures connectées Level",
+		"Off-grid mesh communicators":             "Communicateurs mesh autonomes",
+		"Hiker/outdoor devices":                   "Appareils de randonnée/plein air",
+		"Emergency comms":                         "Communications d'urgence",
+		"DIY LoRa nodes":                          "Nœuds LoRa faits maison",
+		"Smart utility meters":                    "Compteurs communicants",
+		"Parking sensors":                         "Capteurs de stationnement",
+		"Agricultural monitors":                   "Capteurs agricoles",
+		"Industrial sensors":                      "Capteurs industriels",
+		"%s nearby":                               "%s à proximité",
+		"(estimated from the last 24h of events)": "(estimé à partir des événements des dernières 24 h)",
+		"Sidewalk device":                         "appareil Sidewalk",
+		"Sidewalk devices":                        "appareils Sidewalk",
+		"Meshtastic node":                         "nœud Meshtastic",
+		"Meshtastic nodes":                        "nœuds Meshtastic",
+		"LoRaWAN sensor":                          "capteur LoRaWAN",
+		"LoRaWAN sensors":                         "capteurs LoRaWAN",
+		"Frequency Breakdown":                     "Répartition par fréquence",
+		"IoT sensors, industrial monitors":        "Capteurs IoT, surveillance industrielle",
+		"Smart agriculture, asset trackers":       "Agriculture connectée, traceurs",
+		"Environmental sensors, weather stations": "Capteurs environnementaux, stations météo",
+		"Off-grid mesh communicators, hikers":     "Communicateurs mesh, randonneurs",
+		"Utility meters, parking sensors":         "Compteurs, capteurs de stationnement",
+		"Ring, Echo, Tile, smart locks":           "Ring, Echo, Tile, serrures connectées",
+		"Smart city infrastructure":               "Infrastructure de ville intelligente",
+		"Gateway responses, ACKs":                 "Réponses de passerelle, ACK",
+		"Percentages show deviation from baseline calibrated %s": "Les pourcentages indiquent l'écart par rapport à la référence calibrée le %s",
+		"Calibrating baseline until %s":                          "Calibrage de la référence jusqu'au %s",
+		"Periodic Transmitters (24h)":                            "Émetteurs périodiques (24 h)",
+		"every %s":                                               "toutes les %s",
+		"%d hits":                                                "%d occurrences",
+		"Recent Alerts":                                          "Alertes récentes",
+		"Historical Summary":                                     "Historique",
+		"Calendar days in %s":                                    "Jours calendaires en %s",
+		"7 Days":                                                 "7 jours",
+		"30 Days":                                                "30 jours",
+		"90 Days":                                                "90 jours",
+		"1 Year":                                                 "1 an",
+		"Uploads":                                                "Envois",
+		"Detections":                                             "Détections",
+		"Avg Det/min":                                            "Moy. dét./min",
+		"Peak Activity":                                          "Activité maximale",
+		"Auto-refreshes every %d seconds":                        "Actualisation automatique toutes les %d secondes",
+		"Older data kept as hourly and daily totals": "Données anciennes conservées en totaux horaires et journaliers",
+		"Sound":                      "Son",
+		"Notify":                     "Notifier",
+		"Alert at":                   "Alerter à partir de",
+		"hits/min":                   "occurrences/min",
+		"%d hits in the last minute": "%d occurrences dans la dernière minute",
+		"Meshtastic packet heard by %s on %s MHz": "Paquet Meshtastic capté par %s sur %s MHz",
+		"Frequency":                                  "Fréquence",
+		"Band":                                       "Bande",
+		"Share of busiest channel":                   "Part du canal le plus actif",
+		"%d%% of the busiest channel":                "%d%% du canal le plus actif",
+		"Detections per frequency from %s":           "Détections par fréquence de %s",
+		"Skip to detectors":                          "Aller aux détecteurs",
+		"Print report":                               "Imprimer le rapport",
+		"Detectors":                                  "Détecteurs",
+		"Turn off animation":                         "Désactiver les animations",
+		"Turn animation back on":                     "Réactiver les animations",
+		"Animation and auto-refresh off":             "Animations et actualisation automatique désactivées",
+		"Detections per minute, recent":              "Détections par minute, récentes",
+		"Detections per second over the last minute": "Détections par seconde sur la dernière minute",
+		"%.0f detections in the last 24 hours, at most %.0f in one hour": "%.0f détections sur les dernières 24 heures, au plus %.0f en une heure",
+		"Mobile":         "Mobile",
+		"Full dashboard": "Tableau de bord complet",
+		"Ephemeral mode: data is kept in memory and will be lost when the server stops.": "Mode éphémère : les données sont gardées en mémoire et seront perdues à l'arrêt du serveur.",
+		"900 MHz and 2.4 GHz LoRa Activity Monitor":                                      "Moniteur d'activité LoRa 900 MHz et 2,4 GHz",
+		"2.4 GHz ISM Band Activity Monitor":                                              "Moniteur d'activité de la bande ISM 2,4 GHz",
+		"2.4 GHz":                                                                        "2,4 GHz",
+		"Other":                                                                          "Autres",
+		"ExpressLRS RC links":                                                            "Radiocommandes ExpressLRS",
+		"SX1280 ranging tags":                                                            "Balises de localisation SX1280",
+		"Meshtastic 2.4 GHz nodes":                                                       "Nœuds Meshtastic 2,4 GHz",
+		"2.4 GHz LoRaWAN sensors":                                                        "Capteurs LoRaWAN 2,4 GHz",
+		"2.4 GHz LoRa device":                                                            "appareil LoRa 2,4 GHz",
+		"2.4 GHz LoRa devices":                                                           "appareils LoRa 2,4 GHz",
+		"SX1280 sensors, 2.4 GHz LoRaWAN":                                                "Capteurs SX1280, LoRaWAN 2,4 GHz",
+		"Long-range 2.4 GHz telemetry":                                                   "Télémétrie longue portée 2,4 GHz",
+		"Ranging tags, RC links":                                                         "Balises de localisation, radiocommandes",
+		"ISM Band Activity Monitor: %s":                                                  "Moniteur d'activité des bandes ISM : %s",
+		"Outdoor temperature/humidity sensors":                                           "Sondes extérieures de température/humidité",
+		"Rain gauges":                                                                    "Pluviomètres",
+		"Anemometers":                                                                    "Anémomètres",
+		"Pool and soil thermometers":                                                     "Thermomètres de piscine et de sol",
+		"weather sensor":                                                                 "capteur météo",
+		"weather sensors":                                                                "capteurs météo",
+		"Car tire pressure monitors (TPMS)":                                              "Capteurs de pression des pneus (TPMS)",
+		"Truck and trailer tire sensors":                                                 "Capteurs de pneus de camions et remorques",
+		"Motorcycle TPMS":                                                                "TPMS de moto",
+		"tire sensor":                                                                    "capteur de pneu",
+		"tire sensors":                                                                   "capteurs de pneus",
+		"Wireless doorbells":                                                             "Sonnettes sans fil",
+		"Garage and gate remotes":                                                        "Télécommandes de garage et de portail",
+		"Car key fobs":                                                                   "Clés de voiture",
+		"Alarm and door sensors":                                                         "Capteurs d'alarme et de porte",
+		"remote":                                                                         "télécommande",
+		"remotes":                                                                        "télécommandes",
+		"Doorbells, remotes, tire sensors":                                               "Sonnettes, télécommandes, capteurs de pneus",
+		"Weather stations, home sensors":                                                 "Stations météo, capteurs domestiques",
+		"Spreading Factor Breakdown (24h)":                                               "Répartition par facteur d'étalement (24 h)",
+		"Detections by spreading factor per frequency":                                   "Détections par facteur d'étalement et par fréquence",
+		"Occupancy report for an interference complaint":                                 "Rapport d'occupation pour une plainte pour brouillage",
+		"%s %s× the regional median":                                                     "%s %s× la médiane régionale",
+		"%s about the regional median":                                                   "%s proche de la médiane régionale",
+		"no %s, against a regional median of %.1f an hour":                               "pas de %s, contre une médiane régionale de %.1f par heure",
+		"(last %dh, against %d community stations within %.0f km)":                       "(dernières %d h, comparé à %d stations communautaires dans un rayon de %.0f km)",
+		"Channel":               "Canal",
+		"Bandwidth":             "Bande passante",
+		"Likely":                "Probablement",
+		"Sidewalk (fixed rate)": "Sidewalk (débit fixe)",
+		"LoRaWAN downlink":      "Liaison descendante LoRaWAN",
+		"Category":              "Catégorie",
+		"Airtime":               "Temps d'émission",
+		"Busiest hour":          "Heure la plus chargée",
+		"Duty-cycle limit":      "Limite de rapport cyclique",
+		"none":                  "aucune",
+		"Estimated from spreading factor, bandwidth and packet length; packets without a length count as %d bytes. The busiest hour is spread across the category's channels.": "Estimé à partir du facteur d'étalement, de la bande passante et de la longueur des paquets ; les paquets sans longueur comptent pour %d octets. L'heure la plus chargée est répartie sur les canaux de la catégorie.",
+		"Airtime and Duty Cycle (24h)":        "Temps d'émission et rapport cyclique (24 h)",
+		"Estimated airtime per hour":          "Temps d'émission estimé par heure",
+		"Airtime and duty cycle per category": "Temps d'émission et rapport cyclique par catégorie",
+		"detections and weather":              "détections et météo",
+		"Auto-refresh off":                    "Actualisation automatique désactivée",
+		"From":                                "Du",
+		"To":                                  "Au",
+		"Show range":                          "Afficher la période",
+		"Back to the usual periods":           "Revenir aux périodes habituelles",
+		"%d of %d detectors":                  "%d détecteurs sur %d",
+		"%s: %d of %d uploads today":          "%s : %d envois sur %d aujourd'hui",
+		"%s: %d of %d rows":                   "%s : %d lignes sur %d",
+		"%s: %d of %d detectors":              "%s : %d détecteurs sur %d",
+		"%s: %d refused today":                "%s : %d refusés aujourd'hui",
+		"%d near quota":                       "%d proches du quota",
+		"%d over quota":                       "%d au-delà du quota",
+		"Co-located detectors count once, as their average each hour":            "Les détecteurs situés au même endroit comptent une fois, selon leur moyenne horaire",
+		"Co-located detectors count once, by whichever heard the most each hour": "Les détecteurs situés au même endroit comptent une fois, selon celui qui a le plus détecté chaque heure",
+		"last heard %d min ago":  "dernier signal il y a %d min",
+		"last heard %dh ago":     "dernier signal il y a %d h",
+		"last heard %d days ago": "dernier signal il y a %d jours",
+		"%s: %d waiting":         "%s : %d en attente",
+		"%d waiting to forward":  "%d en attente de transfert",
+		"Forwarding failing":     "Transfert en échec",
+	},
+}
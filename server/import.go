@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteMagic starts every SQLite database file
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// uploadColumns is the CSV layout written by export and read by import.
+// Older exports and databases have freq_0..freq_7 instead of freqs.
+var uploadColumns = []string{
+	"device_id", "timestamp", "uptime_seconds", "total_detections", "detections_per_min",
+	"current_activity_pct", "peak_activity_pct", "freqs",
+	"uploader_ip", "bearing", "ack_id",
+}
+
+// Positions in uploadColumns
+const (
+	freqsColumn      = 7
+	uploaderIPColumn = 8
+)
+
+// ImportResult counts what a merge did
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // Already present (same device and timestamp)
+
+	perDevice map[string]int // Imported, per device_id
+}
+
+// importRow is one upload in uploadColumns order; timestamp is replaced by Time
+type importRow struct {
+	Values []interface{}
+	Time   time.Time
+}
+
+// mergeUploads inserts rows that aren't already stored, deduplicating by device
+// and timestamp, in one transaction. next returns io.EOF when done.
+func (s *Store) mergeUploads(ctx context.Context, next func() (importRow, error)) (ImportResult, error) {
+	var res ImportResult
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	placeholders := "?" + strings.Repeat(", ?", len(uploadColumns)-1)
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO uploads (`+strings.Join(uploadColumns, ", ")+`)
+		SELECT `+placeholders+`
+		WHERE NOT EXISTS (SELECT 1 FROM uploads WHERE device_id = ? AND timestamp = ?)
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer stmt.Close()
+
+	for line := 1; ; line++ {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("row %d: %w", line, err)
+		}
+		ts := row.Time.In(time.Local).Format("2006-01-02 15:04:05")
+		row.Values[1] = ts
+		if row.Values[freqsColumn] == nil {
+			row.Values[freqsColumn] = ""
+		}
+		if ip, ok := row.Values[uploaderIPColumn].(string); ok {
+			row.Values[uploaderIPColumn] = anonymizeIP(ip)
+		}
+		r, err := stmt.ExecContext(ctx, append(row.Values, row.Values[0], ts)...)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("row %d: %w", line, err)
+		}
+		if n, _ := r.RowsAffected(); n > 0 {
+			res.Imported++
+			if id, ok := row.Values[0].(string); ok {
+				if res.perDevice == nil {
+					res.perDevice = make(map[string]int)
+				}
+				res.perDevice[id]++
+			}
+		} else {
+			res.Skipped++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, err
+	}
+	if res.Imported > 0 {
+		s.loadLatest(ctx)
+	}
+	return res, nil
+}
+
+// parseImportTime reads an exported timestamp. Exports carry a UTC offset; bare
+// wall-clock times (older exports, other databases) are taken to be in loc.
+func parseImportTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad timestamp %q", v)
+	}
+	return t, nil
+}
+
+// importCSV merges uploads from a CSV written by export
+func (s *Store) importCSV(ctx context.Context, r io.Reader, loc *time.Location) (ImportResult, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"device_id", "timestamp"} {
+		if _, ok := col[name]; !ok {
+			return ImportResult{}, fmt.Errorf("CSV has no %s column", name)
+		}
+	}
+
+	return s.mergeUploads(ctx, func() (importRow, error) {
+		rec, err := cr.Read()
+		if err != nil {
+			return importRow{}, err
+		}
+		row := importRow{Values: make([]interface{}, len(uploadColumns))}
+		for i, name := range uploadColumns {
+			if j, ok := col[name]; ok && j < len(rec) && rec[j] != "" {
+				row.Values[i] = rec[j]
+			}
+		}
+		if _, ok := col["freqs"]; !ok {
+			var counts []int
+			for i := 0; ; i++ {
+				j, ok := col[fmt.Sprintf("freq_%d", i)]
+				if !ok {
+					break
+				}
+				n := 0
+				if j < len(rec) {
+					n, _ = strconv.Atoi(rec[j])
+				}
+				counts = append(counts, n)
+			}
+			row.Values[freqsColumn] = encodeCounts(counts)
+		}
+		row.Time, err = parseImportTime(rec[col["timestamp"]], loc)
+		return row, err
+	})
+}
+
+// importNDJSON merges a raw log (see rawlog.go): uploads as devices sent them,
+// one JSON object per line, each with the time it was received. The channel
+// maps configured now are applied, as ingestion would.
+func (s *Store) importNDJSON(ctx context.Context, r io.Reader) (ImportResult, error) {
+	maps := make(map[string]channelMap)
+	for _, d := range s.getDevices(ctx) {
+		maps[d.DeviceID] = s.channelMap(ctx, d.DeviceID)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxUploadBody)
+
+	return s.mergeUploads(ctx, func() (importRow, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var st Stats
+			if err := json.Unmarshal(line, &st); err != nil {
+				return importRow{}, err
+			}
+			if st.Timestamp.IsZero() {
+				return importRow{}, fmt.Errorf("upload from %q has no timestamp", st.DeviceID)
+			}
+			if err := validateStats(&st); err != nil {
+				return importRow{}, err
+			}
+			var bearing, ackID interface{}
+			if st.Bearing != nil {
+				bearing = normalizeBearing(*st.Bearing)
+			}
+			if st.AckID > 0 {
+				ackID = st.AckID
+			}
+			return importRow{
+				Values: []interface{}{
+					st.DeviceID, nil, st.Uptime, st.TotalDetections, st.DetectionsPerMin,
+					st.CurrentActivity, st.PeakActivity, encodeCounts(maps[st.DeviceID].counts(st.FreqDetections)),
+					st.UploaderIP, bearing, ackID,
+				},
+				Time: st.Timestamp,
+			}, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return importRow{}, err
+		}
+		return importRow{}, io.EOF
+	})
+}
+
+// importSQLite merges the uploads table of another lora.db. Its timestamps are
+// that server's wall clock, so loc should be the timezone it ran in.
+func (s *Store) importSQLite(ctx context.Context, path string, loc *time.Location) (ImportResult, error) {
+	src, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer src.Close()
+
+	// Older databases predate some columns; select NULL for those
+	have := make(map[string]bool)
+	info, err := src.Query(`PRAGMA table_info(uploads)`)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for info.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var def sql.NullString
+		if err := info.Scan(&cid, &name, &typ, &notNull, &def, &pk); err == nil {
+			have[name] = true
+		}
+	}
+	info.Close()
+	if !have["device_id"] || !have["timestamp"] {
+		return ImportResult{}, fmt.Errorf("%s has no uploads table", path)
+	}
+	cols := make([]string, len(uploadColumns))
+	for i, name := range uploadColumns {
+		cols[i] = "NULL"
+		if have[name] {
+			cols[i] = name
+		}
+	}
+	cols[1] = "CAST(timestamp AS TEXT)"
+	if !have["freqs"] {
+		n := 0
+		for have[fmt.Sprintf("freq_%d", n)] {
+			n++
+		}
+		if n > 0 {
+			cols[freqsColumn] = joinCountColumns("freq_", n)
+		}
+	}
+
+	rows, err := src.Query(`SELECT ` + strings.Join(cols, ", ") + ` FROM uploads ORDER BY timestamp, id`)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer rows.Close()
+
+	return s.mergeUploads(ctx, func() (importRow, error) {
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return importRow{}, err
+			}
+			return importRow{}, io.EOF
+		}
+		row := importRow{Values: make([]interface{}, len(uploadColumns))}
+		ptrs := make([]interface{}, len(row.Values))
+		for i := range ptrs {
+			ptrs[i] = &row.Values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return importRow{}, err
+		}
+		// The driver may return the stored wall clock as RFC 3339 "UTC"; strip that
+		// so the time is read in the source server's zone
+		ts := fmt.Sprint(row.Values[1])
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			ts = t.Format("2006-01-02 15:04:05")
+		}
+		var err error
+		row.Time, err = time.ParseInLocation("2006-01-02 15:04:05", ts, loc)
+		return row, err
+	})
+}
+
+// importFile merges a CSV export, a raw log or another lora.db, whichever path holds
+func (s *Store) importFile(ctx context.Context, path string, loc *time.Location) (ImportResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer f.Close()
+	head := make([]byte, len(sqliteMagic))
+	n, _ := io.ReadFull(f, head)
+	if bytes.Equal(head[:n], sqliteMagic) {
+		return s.importSQLite(ctx, path, loc)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ImportResult{}, err
+	}
+	if bytes.HasPrefix(bytes.TrimLeft(head[:n], " \t\r\n"), []byte("{")) {
+		return s.importNDJSON(ctx, f)
+	}
+	return s.importCSV(ctx, f, loc)
+}
+
+// handleAPIImport merges an uploaded CSV export, raw log or lora.db: POST /api/import
+// with the file as the body (or a multipart "file" field), optional ?tz= for
+// the source's timezone
+func handleAPIImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	loc := time.Local
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, fmt.Sprintf("unknown timezone %q", tz), http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := io.Reader(r.Body)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file field required", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	// SQLite needs a real file; CSV could stream, but spooling keeps one path
+	tmp, err := os.CreateTemp("", "lora-import-*")
+	if err != nil {
+		http.Error(w, "Failed to stage import", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, body); err != nil {
+		http.Error(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+
+	res, err := store.importFile(ctx, tmp.Name(), loc)
+	if err != nil {
+		log.Printf("Import failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Imported %d uploads (%d already present)", res.Imported, res.Skipped)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// integrityMessageLimit caps how many corruption messages SQLite reports
+const integrityMessageLimit = 100
+
+// integrityCheckMode reads INTEGRITY_CHECK: full (PRAGMA integrity_check),
+// quick (PRAGMA quick_check, which skips index contents) or off
+func integrityCheckMode() string {
+	switch v := strings.ToLower(os.Getenv("INTEGRITY_CHECK")); v {
+	case "quick", "off":
+		return v
+	}
+	return "full"
+}
+
+// integrityCheck finds rows that can't be right. Repair deletes them: each is
+// either a counter no detector sends or rollup bookkeeping with nothing behind it.
+type integrityCheck struct {
+	name  string
+	table string
+	where string
+	args  func() []interface{}
+}
+
+var integrityChecks = []integrityCheck{
+	{"uploads with negative counters", "uploads",
+		"uptime_seconds < 0 OR total_detections < 0 OR detections_per_min < 0 OR counts_min(freqs) < 0", nil},
+	{"uploads with activity outside 0-100%", "uploads",
+		"current_activity_pct NOT BETWEEN 0 AND 100 OR peak_activity_pct NOT BETWEEN 0 AND 100", nil},
+	{"uploads dated more than a day ahead", "uploads", "timestamp > ?",
+		func() []interface{} { return []interface{}{dbTimeAgo(-24 * time.Hour)} }},
+	{"rollups with an unknown tier", "upload_rollups", "tier NOT IN ('" + tierHour + "', '" + tierDay + "')", nil},
+	{"rollups not aligned to their hour or day", "upload_rollups",
+		"(tier = '" + tierHour + "' AND strftime('%M:%S', period) != '00:00') OR " +
+			"(tier = '" + tierDay + "' AND strftime('%H:%M:%S', period) != '00:00:00')", nil},
+	{"rollups with no uploads or negative totals", "upload_rollups",
+		"uploads <= 0 OR total_detections < 0 OR uptime_seconds < 0 OR counts_min(freqs) < 0 OR counts_min(new_freqs) < 0", nil},
+	{"rollup cursors for devices with no data", "rollup_cursors",
+		"device_id NOT IN (SELECT device_id FROM upload_rollups) AND device_id NOT IN (SELECT device_id FROM uploads)", nil},
+}
+
+// IntegrityProblem is how many rows failed one check
+type IntegrityProblem struct {
+	Check string `json:"check"`
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+}
+
+// IntegrityReport is the outcome of checkIntegrity
+type IntegrityReport struct {
+	CheckedAt time.Time          `json:"checked_at"`
+	Duration  string             `json:"duration"`
+	Mode      string             `json:"mode"`
+	SQLite    []string           `json:"sqlite"` // ["ok"] when the file is sound
+	Problems  []IntegrityProblem `json:"problems"`
+	Repaired  bool               `json:"repaired"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// OK reports whether nothing is wrong (or left wrong after a repair)
+func (r IntegrityReport) OK() bool {
+	return r.Error == "" && len(r.SQLite) == 1 && r.SQLite[0] == "ok" && (r.Repaired || len(r.Problems) == 0)
+}
+
+// checkIntegrity runs SQLite's own check and the data checks. With repair,
+// corrupt indexes are rebuilt (REINDEX) and rows failing a check are deleted;
+// damage to the tables themselves needs a restore from a backup or replica.
+func (s *Store) checkIntegrity(ctx context.Context, mode string, repair bool) (report IntegrityReport) {
+	start := time.Now()
+	report = IntegrityReport{CheckedAt: start, Mode: mode, Problems: []IntegrityProblem{}}
+	defer func() {
+		report.Duration = time.Since(start).Round(time.Millisecond).String()
+		saved := report
+		s.mu.Lock()
+		s.integrity = &saved
+		s.mu.Unlock()
+	}()
+
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	var err error
+	if report.SQLite, err = s.sqliteCheck(ctx, mode); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if repair && report.SQLite[0] != "ok" && !s.readOnly {
+		log.Printf("Database integrity check failed; rebuilding indexes")
+		if _, err := s.db.ExecContext(ctx, `REINDEX`); err != nil {
+			report.Error = "REINDEX: " + err.Error()
+			return report
+		}
+		if report.SQLite, err = s.sqliteCheck(ctx, mode); err != nil {
+			report.Error = err.Error()
+			return report
+		}
+	}
+
+	for _, c := range integrityChecks {
+		var args []interface{}
+		if c.args != nil {
+			args = c.args()
+		}
+		var n int
+		if err := s.queryRow(ctx, `SELECT COUNT(*) FROM `+c.table+` WHERE `+c.where, args...).Scan(&n); err != nil {
+			report.Error = fmt.Sprintf("%s: %v", c.name, err)
+			return report
+		}
+		if n == 0 {
+			continue
+		}
+		report.Problems = append(report.Problems, IntegrityProblem{Check: c.name, Table: c.table, Rows: n})
+		if repair {
+			if _, err := s.exec(ctx, `DELETE FROM `+c.table+` WHERE `+c.where, args...); err != nil {
+				report.Error = fmt.Sprintf("repairing %s: %v", c.name, err)
+				return report
+			}
+		}
+	}
+	report.Repaired = repair
+	return report
+}
+
+// sqliteCheck returns PRAGMA integrity_check (or quick_check) messages
+func (s *Store) sqliteCheck(ctx context.Context, mode string) ([]string, error) {
+	pragma := "integrity_check"
+	if mode == "quick" {
+		pragma = "quick_check"
+	}
+	rows, err := s.readDB.QueryContext(ctx, fmt.Sprintf(`PRAGMA %s(%d)`, pragma, integrityMessageLimit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		msgs = []string{"no result"}
+	}
+	return msgs, rows.Err()
+}
+
+// logIntegrity summarises a report in the server log
+func logIntegrity(r IntegrityReport) {
+	if r.OK() && len(r.Problems) == 0 {
+		log.Printf("Database integrity %s check passed in %s", r.Mode, r.Duration)
+		return
+	}
+	if r.Error != "" {
+		log.Printf("WARNING: database integrity check failed: %s", r.Error)
+	}
+	if len(r.SQLite) > 0 && r.SQLite[0] != "ok" {
+		log.Printf("WARNING: database is corrupt (%d problems, first: %s) - restore from a backup or replica", len(r.SQLite), r.SQLite[0])
+	}
+	for _, p := range r.Problems {
+		action := "run with -repair-db to delete them"
+		if r.Repaired {
+			action = "deleted"
+		}
+		log.Printf("WARNING: %d %s (%s)", p.Rows, p.Check, action)
+	}
+}
+
+// handleAdmin shows database size, retention and the last integrity check, and
+// runs a new check (optionally repairing) on POST: /admin
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPost {
+		if ip := r.FormValue("unban"); ip != "" {
+			if abuse.unban(ip) {
+				log.Printf("Unbanned %s", ip)
+			}
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+			return
+		}
+		mode := integrityCheckMode()
+		if mode == "off" {
+			mode = "full"
+		}
+		logIntegrity(store.checkIntegrity(ctx, mode, r.FormValue("repair") != ""))
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	status := store.storageStatus(ctx)
+	store.mu.RLock()
+	integrity := store.integrity
+	store.mu.RUnlock()
+	mb := func(b int64) string { return fmt.Sprintf("%.1f MB", float64(b)/1e6) }
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Admin", `    <style>
+        .admin-table td { padding: 4px 16px 4px 0; }
+        .admin-ok { color: #4caf50; }
+        .admin-bad { color: #ff5252; }
+        .admin-actions button { margin-right: 10px; padding: 6px 14px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🛠 Admin</h1>
+`)
+	if s, ok := oidc.session(r); ok {
+		fmt.Fprintf(w, `    <p class="subtitle">Signed in as %s · <a href="/auth/logout" style="color: #00d4ff;">Sign out</a></p>
+`, html.EscapeString(s.User))
+	} else if user, _, ok := proxy.identity(ctx, r); ok {
+		fmt.Fprintf(w, `    <p class="subtitle">Signed in as %s</p>
+`, html.EscapeString(user))
+	}
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Database</h2>
+        <table class="admin-table">
+            <tr><td>File</td><td>%s</td></tr>
+            <tr><td>Size</td><td>%s (WAL %s)</td></tr>
+            <tr><td>In use</td><td>%s, %s free</td></tr>
+`, html.EscapeString(status.Path), mb(status.FileBytes), mb(status.WALBytes), mb(status.UsedBytes), mb(status.FreeBytes))
+	limit := "none"
+	if status.Policy.MaxBytes > 0 {
+		limit = mb(status.Policy.MaxBytes)
+	}
+	fmt.Fprintf(w, `            <tr><td>Size cap</td><td>%s (auto_vacuum %s)</td></tr>
+`, limit, status.Policy.AutoVacuum)
+	if status.LastEviction != nil {
+		fmt.Fprintf(w, `            <tr><td>Last eviction</td><td>%s (%d rows evicted since startup)</td></tr>
+`, status.LastEviction.Format("2006-01-02 15:04"), status.EvictedRows)
+	}
+	for _, t := range timedTables {
+		fmt.Fprintf(w, `            <tr><td>%s</td><td>%d rows</td></tr>
+`, t.table, status.Rows[t.table])
+	}
+	ret := retentionPolicy()
+	days := func(n int) string {
+		if n == 0 {
+			return "forever"
+		}
+		return fmt.Sprintf("%d days", n)
+	}
+	fmt.Fprintf(w, `            <tr><td>Retention</td><td>raw %s, hourly %s, daily %s</td></tr>
+`, days(ret.RawDays), days(ret.HourlyDays), days(ret.DailyDays))
+	saves := store.saves.counters()
+	class := "admin-ok"
+	if saves.Rejected > 0 {
+		class = "admin-bad"
+	}
+	fmt.Fprintf(w, `            <tr><td>Failed saves</td><td class="%s">%d (%d deferred, %d rejected)</td></tr>
+        </table>
+    </div>
+    <div class="card">
+        <h2>Integrity</h2>
+`, class, saves.Failures, saves.Deferred, saves.Rejected)
+
+	if integrity == nil {
+		fmt.Fprintf(w, `        <p>Not checked since startup.</p>
+`)
+	} else {
+		class, verdict := "admin-ok", "OK"
+		if !integrity.OK() {
+			class, verdict = "admin-bad", "Problems found"
+		}
+		fmt.Fprintf(w, `        <p class="%s">%s — %s check at %s, took %s</p>
+`, class, verdict, integrity.Mode, integrity.CheckedAt.Format("2006-01-02 15:04:05"), integrity.Duration)
+		if integrity.Error != "" {
+			fmt.Fprintf(w, `        <p class="admin-bad">%s</p>
+`, html.EscapeString(integrity.Error))
+		}
+		if len(integrity.SQLite) > 0 && integrity.SQLite[0] != "ok" {
+			fmt.Fprintf(w, `        <p class="admin-bad">SQLite reports corruption; restore from a backup or replica:</p>
+        <ul>
+`)
+			for _, msg := range integrity.SQLite {
+				fmt.Fprintf(w, `            <li><code>%s</code></li>
+`, html.EscapeString(msg))
+			}
+			fmt.Fprintf(w, `        </ul>
+`)
+		}
+		if len(integrity.Problems) > 0 {
+			fmt.Fprintf(w, `        <table class="admin-table">
+`)
+			for _, p := range integrity.Problems {
+				action := ""
+				if integrity.Repaired {
+					action = " (deleted)"
+				}
+				fmt.Fprintf(w, `            <tr><td>%s</td><td>%d rows%s</td></tr>
+`, p.Check, p.Rows, action)
+			}
+			fmt.Fprintf(w, `        </table>
+`)
+		}
+	}
+	fmt.Fprintf(w, `        <form method="post" action="/admin" class="admin-actions">
+            <button type="submit">Check now</button>
+            <button type="submit" name="repair" value="1">Check and repair</button>
+        </form>
+    </div>
+`)
+	renderAbuseCard(w)
+	fmt.Fprintf(w, `    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a> · <a href="/admin/frequencies" style="color: #00d4ff;">Frequency plan</a> · <a href="/admin/diagnostics" style="color: #00d4ff;">Diagnostics</a></footer>
+`)
+	writePageEnd(w)
+}
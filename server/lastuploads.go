@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The last-uploads endpoint shows a detector's newest raw uploads as stored,
+// each with how its counters moved since the one before, to chase firmware
+// counter bugs without opening the database. Deltas are plain differences and
+// can be negative; problems flags the ones a healthy detector doesn't make.
+const (
+	lastUploadsDefault = 20
+	lastUploadsMax     = 500
+
+	// Slack before uptime advancing faster than the server's clock is flagged:
+	// upload jitter and the time the upload spent queued on the device
+	uptimeSlackSecs = 60
+)
+
+// UploadDiff is one stored upload and its change from the previous one
+type UploadDiff struct {
+	ID               int64     `json:"id"`
+	AckID            int64     `json:"ack_id,omitempty"`
+	Received         time.Time `json:"received"`
+	Uptime           int       `json:"uptime_seconds"`
+	TotalDetections  int       `json:"total_detections"`
+	DetectionsPerMin int       `json:"detections_per_min"`
+	CurrentActivity  int       `json:"current_activity_pct"`
+	PeakActivity     int       `json:"peak_activity_pct"`
+	FreqDetections   []int     `json:"freq_detections"` // In plan order, after the channel map
+	UploaderIP       string    `json:"uploader_ip,omitempty"`
+	Bearing          *float64  `json:"bearing_deg,omitempty"`
+
+	// nil for the oldest upload kept
+	Delta *UploadDelta `json:"delta"`
+}
+
+// UploadDelta is how an upload's counters moved since the previous one
+type UploadDelta struct {
+	Seconds         int      `json:"seconds"` // Between the two receive times
+	Uptime          int      `json:"uptime_seconds"`
+	TotalDetections int      `json:"total_detections"`
+	FreqDetections  []int    `json:"freq_detections"`
+	Reboot          bool     `json:"reboot"` // Uptime went backwards; counters start over
+	Problems        []string `json:"problems,omitempty"`
+}
+
+// getLastUploads returns a device's n newest raw uploads, newest first
+func (s *Store) getLastUploads(ctx context.Context, deviceID string, n int) ([]UploadDiff, error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	// One more than asked for, so the oldest returned has a delta too
+	rows, err := s.query(ctx, `
+		SELECT id, ack_id, timestamp, uptime_seconds, total_detections, detections_per_min,
+			current_activity_pct, peak_activity_pct, freqs, uploader_ip, bearing
+		FROM uploads WHERE device_id = ?
+		ORDER BY timestamp DESC, id DESC LIMIT ?
+	`, deviceID, n+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []UploadDiff
+	for rows.Next() {
+		var u UploadDiff
+		var ack sql.NullInt64
+		var ts, freqs string
+		var ip sql.NullString
+		if err := rows.Scan(&u.ID, &ack, &ts, &u.Uptime, &u.TotalDetections, &u.DetectionsPerMin,
+			&u.CurrentActivity, &u.PeakActivity, &freqs, &ip, &u.Bearing); err != nil {
+			return nil, err
+		}
+		u.AckID, u.UploaderIP = ack.Int64, ip.String
+		u.Received = parseDBTime(ts)
+		u.FreqDetections = planCounts(freqs)
+		uploads = append(uploads, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+1 < len(uploads); i++ {
+		uploads[i].Delta = uploadDelta(uploads[i+1], uploads[i])
+	}
+	if len(uploads) > n {
+		uploads = uploads[:n]
+	}
+	return uploads, nil
+}
+
+// uploadDelta compares an upload with the one before it
+func uploadDelta(prev, cur UploadDiff) *UploadDelta {
+	d := &UploadDelta{
+		Seconds:         int(cur.Received.Sub(prev.Received).Seconds()),
+		Uptime:          cur.Uptime - prev.Uptime,
+		TotalDetections: cur.TotalDetections - prev.TotalDetections,
+		FreqDetections:  make([]int, len(cur.FreqDetections)),
+		Reboot:          cur.Uptime < prev.Uptime,
+	}
+	for i, c := range cur.FreqDetections {
+		d.FreqDetections[i] = c - countAt(prev.FreqDetections, i)
+	}
+	if d.Reboot {
+		return d
+	}
+	if d.TotalDetections < 0 {
+		d.Problems = append(d.Problems, "total_detections went backwards without a reboot")
+	}
+	for i, v := range d.FreqDetections {
+		if v >= 0 {
+			continue
+		}
+		slot := fmt.Sprintf("freq_detections[%d]", i)
+		if mhz := frequencyAt(i).MHz; mhz != "" {
+			slot += " (" + mhz + " MHz)"
+		}
+		d.Problems = append(d.Problems, slot+" went backwards without a reboot")
+	}
+	if d.Uptime > d.Seconds+uptimeSlackSecs {
+		d.Problems = append(d.Problems, fmt.Sprintf("uptime advanced %ds in %ds", d.Uptime, d.Seconds))
+	}
+	return d
+}
+
+// handleAPILastUploads returns a device's newest raw uploads with their
+// deltas: GET /api/devices/{id}/last-uploads?n=20
+func handleAPILastUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	deviceID := r.PathValue("id")
+	n := lastUploadsDefault
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 || n > lastUploadsMax {
+			http.Error(w, fmt.Sprintf("n must be 1-%d", lastUploadsMax), http.StatusBadRequest)
+			return
+		}
+	}
+	uploads, err := store.getLastUploads(ctx, deviceID, n)
+	if err != nil {
+		log.Printf("Error loading last uploads for %s: %v", deviceID, err)
+		http.Error(w, "Failed to load uploads", http.StatusInternalServerError)
+		return
+	}
+	if _, known := store.latestStats()[deviceID]; len(uploads) == 0 && !known {
+		http.Error(w, "Unknown device", http.StatusNotFound)
+		return
+	}
+	if uploads == nil {
+		uploads = []UploadDiff{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_id": deviceID,
+		"uploads":   uploads,
+	})
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+)
+
+// writePageStart writes the shared document head and opens the page container.
+// extraHead is inserted verbatim into <head> (refresh tags, page-specific styles).
+func writePageStart(w io.Writer, title, extraHead string) {
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>%s</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+%s%s    <style>
+`, html.EscapeString(title), pwaHead, extraHead)
+	io.WriteString(w, dashboardCSS)
+	io.WriteString(w, `    </style>
+</head>
+<body>
+<main class="container">
+`)
+}
+
+// writePageEnd closes the container opened by writePageStart
+func writePageEnd(w io.Writer) {
+	io.WriteString(w, `</main>
+</body>
+</html>`)
+}
+
+// requestCalm reports whether the visitor asked for no animation: an explicit
+// ?motion=reduce or ?motion=full (remembered in a cookie, like the language),
+// then the cookie. Calm pages also stop refreshing themselves.
+func requestCalm(w http.ResponseWriter, r *http.Request) bool {
+	switch m := r.URL.Query().Get("motion"); m {
+	case "reduce", "full":
+		http.SetCookie(w, &http.Cookie{Name: "motion", Value: m, Path: "/", MaxAge: 365 * 24 * 3600})
+		return m == "reduce"
+	}
+	c, err := r.Cookie("motion")
+	return err == nil && c.Value == "reduce"
+}
+
+// calmCSS turns off animation for visitors who chose no-animation mode;
+// prefers-reduced-motion does the same for everyone who set it in their OS
+const calmCSS = `    <style>
+        *, *::before, *::after { animation: none !important; transition: none !important; }
+    </style>
+`
+
+// dashboardCSS is the stylesheet shared by every HTML page
+const dashboardCSS = `        * { box-sizing: border-box; }
+        body {
+            font-family: 'Segoe UI', system-ui, sans-serif;
+            background: linear-gradient(135deg, #1a1a2e 0%, #16213e 100%);
+            color: #e0e0e0;
+            padding: 20px;
+            margin: 0;
+            min-height: 100vh;
+        }
+        .container { max-width: 1000px; margin: 0 auto; }
+        h1 {
+            color: #00d4ff;
+            text-align: center;
+            font-size: 2em;
+            margin-bottom: 5px;
+            text-shadow: 0 0 20px rgba(0,212,255,0.5);
+        }
+        .subtitle {
+            text-align: center;
+            color: #888;
+            margin-bottom: 30px;
+        }
+        .nav {
+            text-align: center;
+            margin: -20px 0 25px 0;
+        }
+        .nav a {
+            color: #00d4ff;
+            text-decoration: none;
+            margin: 0 10px;
+            font-size: 0.9em;
+        }
+        .stats-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(150px, 1fr));
+            gap: 15px;
+            margin-bottom: 30px;
+        }
+        .stat-box {
+            background: rgba(255,255,255,0.05);
+            border-radius: 12px;
+            padding: 20px;
+            text-align: center;
+            border: 1px solid rgba(255,255,255,0.1);
+        }
+        .stat-box .value {
+            font-size: 2.5em;
+            font-weight: bold;
+            color: #00d4ff;
+        }
+        .stat-box .label { color: #888; font-size: 0.9em; }
+        .stat-box.hot .value { color: #ff4444; animation: pulse 1s infinite; }
+        @keyframes pulse { 50% { opacity: 0.7; } }
+
+        .card {
+            background: rgba(255,255,255,0.05);
+            border-radius: 16px;
+            padding: 25px;
+            margin-bottom: 25px;
+            border: 1px solid rgba(255,255,255,0.1);
+        }
+        .card h2 {
+            color: #fff;
+            margin: 0 0 20px 0;
+            font-size: 1.3em;
+            display: flex;
+            align-items: center;
+            gap: 10px;
+        }
+        .card h2 .icon { font-size: 1.5em; }
+        .card.stale { opacity: 0.55; }
+        .stale-chip {
+            display: inline-block;
+            background: rgba(255,152,0,0.15);
+            color: #ffb74d;
+            padding: 2px 8px;
+            border-radius: 10px;
+            font-size: 0.85em;
+            margin-left: 8px;
+        }
+
+        /* Accessibility */
+        a:focus-visible, button:focus-visible, input:focus-visible, select:focus-visible,
+        textarea:focus-visible, summary:focus-visible, [tabindex]:focus-visible {
+            outline: 2px solid #00d4ff;
+            outline-offset: 2px;
+        }
+        .sr-only {
+            position: absolute;
+            width: 1px;
+            height: 1px;
+            margin: -1px;
+            overflow: hidden;
+            clip: rect(0, 0, 0, 0);
+            white-space: nowrap;
+        }
+        .skip-link { position: absolute; left: -9999px; }
+        .skip-link:focus { left: 20px; top: 10px; background: #00d4ff; color: #000; padding: 8px 12px; border-radius: 4px; z-index: 1000; }
+        @media (prefers-reduced-motion: reduce) {
+            *, *::before, *::after { animation: none !important; transition: none !important; }
+        }
+
+        /* Frequency breakdown */
+        .freq-table { width: 100%; border-collapse: collapse; }
+        .freq-row th, .freq-row td {
+            padding: 12px 15px 12px 0;
+            border-bottom: 1px solid rgba(255,255,255,0.05);
+            text-align: left;
+            vertical-align: middle;
+        }
+        .freq-row:last-child th, .freq-row:last-child td { border-bottom: none; }
+        .freq-row th { width: 80px; }
+        .freq-row .freq-label { width: 140px; }
+        .freq-row .freq-count { width: 80px; padding-right: 0; }
+        .freq-mhz a { color: inherit; text-decoration: none; }
+        .freq-band th {
+            padding: 14px 0 4px;
+            text-align: left;
+            color: #00d4ff;
+            font-size: 0.85em;
+            text-transform: uppercase;
+            letter-spacing: 0.05em;
+        }
+        .freq-mhz {
+            font-family: 'Courier New', monospace;
+            font-weight: bold;
+            color: #fff;
+            text-decoration: none;
+        }
+        .freq-label { color: #aaa; font-size: 0.9em; }
+        .freq-bar-container {
+            background: rgba(255,255,255,0.1);
+            border-radius: 4px;
+            height: 24px;
+            overflow: hidden;
+        }
+        .freq-bar {
+            height: 100%;
+            border-radius: 4px;
+            display: flex;
+            align-items: center;
+            padding-left: 8px;
+            font-size: 0.8em;
+            font-weight: bold;
+            color: #000;
+            transition: width 0.5s ease;
+        }
+        .freq-count {
+            font-family: 'Courier New', monospace;
+            text-align: right;
+            color: #fff;
+        }
+        .minute-chart { margin-top: 20px; }
+        .trend { margin-top: 15px; }
+        .trend svg { display: block; width: 100%; }
+        .live-total { color: #888; font-size: 0.6em; font-weight: normal; margin-left: 10px; }
+        .live-controls { display: flex; gap: 20px; flex-wrap: wrap; color: #aaa; font-size: 0.85em; margin-top: 10px; }
+        .live-controls input[type=number] { width: 60px; background: #1a1a2e; color: #e0e0e0; border: 1px solid #444; border-radius: 4px; }
+        .range-picker { display: flex; gap: 12px; flex-wrap: wrap; align-items: center; color: #aaa; font-size: 0.85em; margin: 10px 0; }
+        .range-picker input[type=date], .range-picker button { background: #1a1a2e; color: #e0e0e0; border: 1px solid #444; border-radius: 4px; padding: 2px 6px; }
+        .zoomable { position: relative; cursor: crosshair; touch-action: none; }
+        .zoom-select { position: absolute; top: 0; bottom: 0; display: none; background: rgba(0,212,255,0.2); border: 1px solid #00d4ff; pointer-events: none; }
+        .chart-label { color: #888; font-size: 0.8em; margin-bottom: 5px; }
+        .chart { background: rgba(0,0,0,0.2); border-radius: 4px; display: block; }
+        .baseline-dev { display: block; font-size: 0.75em; }
+        .baseline-dev.dev-normal { color: #888; }
+        .baseline-dev.dev-high { color: #ff4444; }
+        .baseline-note { color: #666; font-size: 0.8em; text-align: center; margin: 10px 0 0 0; }
+        .spreading-table th, .spreading-table td { padding: 6px 4px; }
+        .spreading-table thead th { color: #888; font-size: 0.8em; font-weight: normal; text-align: left; }
+        .sf-cell { text-align: center; font-family: 'Courier New', monospace; color: #fff; min-width: 40px; }
+        .spreading-table thead th.sf-cell { text-align: center; }
+        .airtime-table { margin-top: 10px; }
+        .airtime-table thead th { color: #888; font-size: 0.8em; font-weight: normal; text-align: left; }
+        .airtime-table .over-limit { color: #ff4444; font-weight: bold; }
+        .periodic-row {
+            display: grid;
+            grid-template-columns: 80px 140px 1fr auto;
+            gap: 15px;
+            padding: 8px 0;
+            border-bottom: 1px solid rgba(255,255,255,0.05);
+        }
+        .periodic-row:last-child { border-bottom: none; }
+        .alert-row {
+            padding: 8px 0;
+            border-bottom: 1px solid rgba(255,255,255,0.05);
+            color: #ffb3b3;
+        }
+        .alert-row:last-child { border-bottom: none; }
+        .battery-warn { margin: 10px 0 0 0; padding: 8px 12px; border-radius: 8px; font-size: 0.9em; }
+        .battery-warn.battery-low { background: rgba(255,152,0,0.15); color: #ffb74d; }
+        .battery-warn.battery-critical { background: rgba(255,68,68,0.2); color: #ff6b6b; }
+
+        /* Category summary */
+        .category-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(280px, 1fr));
+            gap: 20px;
+        }
+        .category-card {
+            background: rgba(0,0,0,0.3);
+            border-radius: 12px;
+            padding: 20px;
+            border-left: 4px solid;
+        }
+        .category-card h3 {
+            margin: 0 0 10px 0;
+            display: flex;
+            align-items: center;
+            gap: 8px;
+        }
+        .category-card h3 a { color: inherit; text-decoration: none; }
+        .category-card.non-lora { border-left-style: dashed; background: rgba(0,0,0,0.2); }
+        .category-card .modulation {
+            font-size: 0.6em;
+            font-weight: normal;
+            color: #999;
+            border: 1px solid #555;
+            border-radius: 4px;
+            padding: 1px 5px;
+        }
+        .category-card .count {
+            font-size: 2em;
+            font-weight: bold;
+            margin-bottom: 10px;
+        }
+        .nearby { margin: 20px 0 0 0; text-align: center; color: #ccc; }
+        .category-card .devices {
+            font-size: 0.85em;
+            color: #999;
+            line-height: 1.6;
+        }
+
+        /* Device info */
+        .device-header {
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+            flex-wrap: wrap;
+            gap: 10px;
+        }
+        .device-id {
+            background: rgba(0,212,255,0.2);
+            padding: 5px 15px;
+            border-radius: 20px;
+            color: #00d4ff;
+            font-family: monospace;
+            text-decoration: none;
+        }
+        .timestamp { color: #666; font-size: 0.85em; }
+
+        .no-data {
+            text-align: center;
+            padding: 60px 20px;
+            color: #666;
+        }
+        .no-data .icon { font-size: 4em; margin-bottom: 20px; }
+        .no-data p { margin: 10px 0; }
+
+        .legend {
+            display: flex;
+            gap: 20px;
+            flex-wrap: wrap;
+            justify-content: center;
+            margin-top: 20px;
+            padding-top: 20px;
+            border-top: 1px solid rgba(255,255,255,0.1);
+        }
+        .legend-item {
+            display: flex;
+            align-items: center;
+            gap: 6px;
+            font-size: 0.85em;
+            color: #888;
+        }
+        .legend-dot {
+            width: 12px;
+            height: 12px;
+            border-radius: 50%;
+        }
+
+        /* Historical summaries */
+        .summary-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(220px, 1fr));
+            gap: 15px;
+        }
+        .summary-card {
+            background: rgba(0,0,0,0.3);
+            border-radius: 12px;
+            padding: 20px;
+            border-top: 3px solid #00d4ff;
+        }
+        .summary-card h3 {
+            margin: 0 0 15px 0;
+            color: #00d4ff;
+            font-size: 1.1em;
+        }
+        .summary-stat {
+            display: flex;
+            justify-content: space-between;
+            padding: 6px 0;
+            border-bottom: 1px solid rgba(255,255,255,0.05);
+        }
+        .summary-stat:last-child { border-bottom: none; }
+        .summary-stat .label { color: #888; }
+        .summary-stat .value { color: #fff; font-weight: bold; }
+        .summary-card .mini-freq {
+            display: flex;
+            gap: 4px;
+            margin-top: 10px;
+        }
+        .mini-freq .bar {
+            flex: 1;
+            height: 20px;
+            border-radius: 2px;
+            position: relative;
+        }
+        .mini-freq .bar span {
+            position: absolute;
+            bottom: -16px;
+            left: 50%;
+            transform: translateX(-50%);
+            font-size: 0.65em;
+            color: #666;
+        }
+
+        footer {
+            text-align: center;
+            color: #444;
+            margin-top: 40px;
+            padding-top: 20px;
+            border-top: 1px solid rgba(255,255,255,0.05);
+        }
+        .lang-menu { margin-top: 8px; font-size: 0.85em; }
+        .lang-menu a { color: #00d4ff; text-decoration: none; }
+        .ephemeral-banner {
+            background: rgba(255,152,0,0.15);
+            border: 1px solid #ff9800;
+            color: #ffb74d;
+            padding: 10px 15px;
+            border-radius: 10px;
+            margin-bottom: 20px;
+            text-align: center;
+        }
+        .db-badge {
+            display: inline-block;
+            background: rgba(0,212,255,0.1);
+            padding: 3px 10px;
+            border-radius: 10px;
+            font-size: 0.8em;
+            color: #00d4ff;
+            margin-left: 10px;
+        }
+        .db-badge.backlog { background: rgba(255,152,0,0.15); color: #ffb74d; cursor: help; }
+        .db-badge.quota { background: rgba(255,82,82,0.15); color: #ff8a80; cursor: help; }
+`
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Detectors whose owners opt in (LEADERBOARD) are ranked on the community
+// server's leaderboard and on federated sites' dashboards, under a pseudonym
+// derived from their community station ID, so a detector has the same name
+// everywhere without giving away its device ID.
+const leaderboardSize = 10
+
+var (
+	pseudonymAdjectives = []string{
+		"Swift", "Quiet", "Bright", "Patient", "Bold", "Steady", "Keen", "Lucky",
+		"Silver", "Amber", "Crimson", "Misty", "Rapid", "Gentle", "Hidden", "Lofty",
+		"Nimble", "Solar", "Lunar", "Frosty", "Dusty", "Windy", "Sunny", "Stormy",
+		"Clever", "Brave", "Wild", "Calm", "Vivid", "Sly", "Noble", "Humble",
+	}
+	pseudonymAnimals = []string{
+		"Heron", "Otter", "Falcon", "Badger", "Lynx", "Marten", "Osprey", "Coyote",
+		"Raven", "Beaver", "Moose", "Bison", "Plover", "Condor", "Marmot", "Pika",
+		"Wren", "Egret", "Ferret", "Gecko", "Ibis", "Jackal", "Kestrel", "Lemur",
+		"Magpie", "Newt", "Oriole", "Puffin", "Quail", "Salmon", "Tern", "Vole",
+	}
+)
+
+// LeaderboardEntry is one detector's standing
+type LeaderboardEntry struct {
+	Pseudonym         string `json:"pseudonym"`
+	Detections        int    `json:"detections"`
+	UptimeSeconds     int    `json:"uptime_seconds"`
+	FrequenciesActive int    `json:"frequencies_active"`
+}
+
+// Leaderboards ranks entries by each measure, best first
+type Leaderboards struct {
+	Detections  []LeaderboardEntry `json:"detections"`
+	Uptime      []LeaderboardEntry `json:"uptime"`
+	Frequencies []LeaderboardEntry `json:"frequencies"`
+}
+
+// leaderboardOptIn reports whether this instance's detectors take part (LEADERBOARD)
+func leaderboardOptIn() bool {
+	return envBool("LEADERBOARD")
+}
+
+// pseudonym turns a station ID into a name like "Swift Heron 42"
+func pseudonym(station string) string {
+	sum := sha256.Sum256([]byte(station))
+	n := binary.BigEndian.Uint32(sum[:4])
+	adj := pseudonymAdjectives[n%uint32(len(pseudonymAdjectives))]
+	animal := pseudonymAnimals[(n/32)%uint32(len(pseudonymAnimals))]
+	return fmt.Sprintf("%s %s %d", adj, animal, (n/1024)%100)
+}
+
+// activeFrequencies counts the frequencies with any detections
+func activeFrequencies(freqs []int) int {
+	n := 0
+	for _, c := range freqs {
+		if c > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// rankLeaderboards sorts entries by each measure and keeps the top of each
+func rankLeaderboards(entries []LeaderboardEntry) Leaderboards {
+	top := func(less func(a, b LeaderboardEntry) bool) []LeaderboardEntry {
+		ranked := append([]LeaderboardEntry(nil), entries...)
+		sort.SliceStable(ranked, func(i, j int) bool { return less(ranked[i], ranked[j]) })
+		return ranked[:min(len(ranked), leaderboardSize)]
+	}
+	return Leaderboards{
+		Detections: top(func(a, b LeaderboardEntry) bool { return a.Detections > b.Detections }),
+		Uptime:     top(func(a, b LeaderboardEntry) bool { return a.UptimeSeconds > b.UptimeSeconds }),
+		Frequencies: top(func(a, b LeaderboardEntry) bool {
+			if a.FrequenciesActive != b.FrequenciesActive {
+				return a.FrequenciesActive > b.FrequenciesActive
+			}
+			return a.Detections > b.Detections
+		}),
+	}
+}
+
+// getCommunityLeaderboard ranks opted-in community stations over the last days
+func (s *Store) getCommunityLeaderboard(ctx context.Context, days int) Leaderboards {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	// A station counts if its latest report in the window opted in
+	rows, err := s.query(ctx, `
+		SELECT station, SUM(detections), MAX(COALESCE(uptime_seconds, 0)), COALESCE(counts_sum(freqs), '')
+		FROM community_reports c WHERE period_end >= ?
+		GROUP BY station
+		HAVING (SELECT leaderboard FROM community_reports l WHERE l.station = c.station ORDER BY period_end DESC LIMIT 1) = 1
+	`, dbTimeAgo(time.Duration(days)*24*time.Hour))
+	if err != nil {
+		log.Printf("Error loading community leaderboard: %v", err)
+		return rankLeaderboards(nil)
+	}
+	defer rows.Close()
+
+	var entries []LeaderboardEntry
+	for rows.Next() {
+		var station, freqs string
+		var e LeaderboardEntry
+		if err := rows.Scan(&station, &e.Detections, &e.UptimeSeconds, &freqs); err != nil {
+			log.Printf("Error scanning leaderboard row: %v", err)
+			continue
+		}
+		e.Pseudonym = pseudonym(station)
+		e.FrequenciesActive = activeFrequencies(planCounts(freqs))
+		entries = append(entries, e)
+	}
+	return rankLeaderboards(entries)
+}
+
+// siteLeaderboard ranks the detectors of every opted-in federated site
+func siteLeaderboard(sites ...*SiteSummary) Leaderboards {
+	var entries []LeaderboardEntry
+	for _, s := range sites {
+		if s == nil || !s.Leaderboard {
+			continue
+		}
+		for _, d := range s.Devices {
+			if d.Pseudonym == "" {
+				continue
+			}
+			entries = append(entries, LeaderboardEntry{
+				Pseudonym:         d.Pseudonym,
+				Detections:        d.Detections24h,
+				UptimeSeconds:     d.UptimeSeconds,
+				FrequenciesActive: activeFrequencies(d.FreqDetections24h),
+			})
+		}
+	}
+	return rankLeaderboards(entries)
+}
+
+// renderLeaderboard draws the three rankings as one card
+func renderLeaderboard(w io.Writer, title string, lb Leaderboards) {
+	fmt.Fprintf(w, `    <div class="card">
+        <h2><span class="icon">🏆</span> %s</h2>
+`, html.EscapeString(title))
+	if len(lb.Detections) == 0 {
+		io.WriteString(w, "        <p>No detectors have opted in yet.</p>\n    </div>\n")
+		return
+	}
+	board := func(heading string, entries []LeaderboardEntry, value func(LeaderboardEntry) string) {
+		fmt.Fprintf(w, `        <h3>%s</h3>
+        <table class="event-log">
+`, heading)
+		for i, e := range entries {
+			fmt.Fprintf(w, "            <tr><td>%d.</td><td>%s</td><td>%s</td></tr>\n", i+1, html.EscapeString(e.Pseudonym), value(e))
+		}
+		io.WriteString(w, "        </table>\n")
+	}
+	board("Most Detections", lb.Detections, func(e LeaderboardEntry) string { return strconv.Itoa(e.Detections) })
+	board("Longest Uptime", lb.Uptime, func(e LeaderboardEntry) string {
+		return fmt.Sprintf("%dd %02dh", e.UptimeSeconds/86400, e.UptimeSeconds%86400/3600)
+	})
+	board("Most Frequencies Active", lb.Frequencies, func(e LeaderboardEntry) string {
+		return fmt.Sprintf("%d of %d", e.FrequenciesActive, len(frequencies()))
+	})
+	io.WriteString(w, "    </div>\n")
+}
+
+// leaderboardDays reads ?days= for the community leaderboard, a week by default
+func leaderboardDays(r *http.Request) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		return v
+	}
+	return 7
+}
+
+// handleAPICommunityLeaderboard ranks opted-in stations: GET /api/community/leaderboard?days=7
+func handleAPICommunityLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.getCommunityLeaderboard(r.Context(), leaderboardDays(r)))
+}
+
+// handleCommunityLeaderboard renders the community leaderboard
+func handleCommunityLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	days := leaderboardDays(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "LoRa Community Leaderboard", "")
+	fmt.Fprintf(w, `    <h1>🏆 Community Leaderboard</h1>
+    <p class="subtitle">Opted-in stations, last %d days</p>
+`, days)
+	renderLeaderboard(w, "Leaderboard", store.getCommunityLeaderboard(r.Context(), days))
+	io.WriteString(w, `    <footer><a href="/community" style="color: #00d4ff;">← Community Map</a></footer>
+`)
+	writePageEnd(w)
+}
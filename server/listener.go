@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sdListenFDsStart is the first file descriptor systemd passes to socket-activated services
+const sdListenFDsStart = 3
+
+// listen opens the configured listener. The address may be a TCP address
+// (":8080"), "unix:/path/to.sock" for a local reverse proxy, or "systemd" to
+// use a socket inherited through systemd socket activation.
+func listen(addr string) (net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemdListener()
+	case strings.HasPrefix(addr, "unix:"):
+		return unixListener(strings.TrimPrefix(addr, "unix:"))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// systemdListener takes over the first socket passed by systemd (see sd_listen_fds(3))
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_PID not set for this process)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd (LISTEN_FDS=%q)", os.Getenv("LISTEN_FDS"))
+	}
+	// Keep child processes from seeing the activation variables
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(sdListenFDsStart, "systemd-socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited fd %d is not a listening socket: %v", sdListenFDsStart, err)
+	}
+	return l, nil
+}
+
+// unixListener listens on a unix domain socket, replacing a stale socket file
+// left behind by a previous run
+func unixListener(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path required (unix:/path/to.sock)")
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Let a reverse proxy running as another user in the same group connect
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The live feed pushes detections to browsers as they arrive, over Server-Sent
+// Events, for the dashboard's "last 60 seconds" meter. Uploads report what they
+// heard since the previous one, so they arrive as one burst at upload time;
+// detectors that send /events are placed at each event's own second. The last
+// liveWindow of hits is replayed to every new subscriber, so the meter survives
+// the dashboard's page refresh.
+const (
+	liveWindow    = 60 * time.Second
+	liveHeartbeat = 15 * time.Second
+	liveBuffer    = 256              // Hits queued per subscriber before it drops them
+	liveStreamFor = 10 * time.Minute // Then the browser reconnects
+
+	liveAlertThreshold = 20 // Default hits per minute before the dashboard alerts
+	liveAlertCooldown  = time.Minute
+)
+
+// LiveHit is a device's detections per frequency in one second
+type LiveHit struct {
+	DeviceID string    `json:"device_id"`
+	Time     time.Time `json:"time"`
+	Counts   []int     `json:"counts"` // [frequency]
+}
+
+// liveFeed fans hits out to subscribers and remembers the last liveWindow
+type liveFeed struct {
+	mu     sync.Mutex
+	recent []LiveHit
+	subs   map[chan LiveHit]bool
+}
+
+// live is the server's feed; it is always on and costs nothing without subscribers
+var live = &liveFeed{subs: make(map[chan LiveHit]bool)}
+
+// detected publishes new detections per frequency heard at t
+func (f *liveFeed) detected(deviceID string, counts []int, t time.Time) {
+	hit := LiveHit{DeviceID: deviceID, Time: t.Truncate(time.Second), Counts: make([]int, len(frequencies()))}
+	heard := false
+	for i, c := range counts {
+		if i < len(hit.Counts) && c > 0 {
+			hit.Counts[i] = c
+			heard = true
+		}
+	}
+	if !heard {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cutoff := timeNow().Add(-liveWindow)
+	kept := f.recent[:0]
+	for _, h := range f.recent {
+		if h.Time.After(cutoff) {
+			kept = append(kept, h)
+		}
+	}
+	f.recent = kept
+	if hit.Time.After(cutoff) {
+		f.recent = append(f.recent, hit)
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- hit:
+		default: // A slow subscriber misses hits rather than holding up uploads
+		}
+	}
+}
+
+// detectedEvents publishes an event upload: events at their own second and
+// pre-binned minutes at the time they were received
+func (f *liveFeed) detectedEvents(up EventUpload, received time.Time) {
+	seconds := make(map[time.Time][]int)
+	add := func(t time.Time, i, c int) {
+		t = t.Truncate(time.Second)
+		if seconds[t] == nil {
+			seconds[t] = make([]int, len(frequencies()))
+		}
+		seconds[t][i] += c
+	}
+	for _, e := range up.Events {
+		if e.FreqIndex >= 0 && e.FreqIndex < len(frequencies()) {
+			add(eventTime(e.Time, e.Ago, received), e.FreqIndex, 1)
+		}
+	}
+	for _, b := range up.Bins {
+		for i, c := range b.Counts {
+			if i < len(frequencies()) && c > 0 {
+				add(received, i, c)
+			}
+		}
+	}
+	for t, counts := range seconds {
+		f.detected(up.DeviceID, counts, t)
+	}
+}
+
+// subscribe returns the hits from the last liveWindow and a channel for new
+// ones; call unsubscribe when done
+func (f *liveFeed) subscribe() ([]LiveHit, chan LiveHit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan LiveHit, liveBuffer)
+	f.subs[ch] = true
+	cutoff := timeNow().Add(-liveWindow)
+	var backlog []LiveHit
+	for _, h := range f.recent {
+		if h.Time.After(cutoff) {
+			backlog = append(backlog, h)
+		}
+	}
+	return backlog, ch
+}
+
+func (f *liveFeed) unsubscribe(ch chan LiveHit) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+// handleAPIStream streams detections as Server-Sent Events, starting with the
+// last minute: GET /api/stream with optional device=ID or group=NAME
+func handleAPIStream(w http.ResponseWriter, r *http.Request) {
+	var members map[string]bool
+	if device := r.URL.Query().Get("device"); device != "" {
+		members = map[string]bool{device: true}
+	} else if group := r.URL.Query().Get("group"); group != "" {
+		ids := store.getGroupDevices(r.Context(), group)
+		if ids == nil {
+			http.Error(w, "Unknown group", http.StatusNotFound)
+			return
+		}
+		members = make(map[string]bool)
+		for _, id := range ids {
+			members[id] = true
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	backlog, ch := live.subscribe()
+	defer live.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	send := func(h LiveHit) error {
+		if members != nil && !members[h.DeviceID] {
+			return nil
+		}
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: detections\ndata: %s\n\n", data)
+		return err
+	}
+	for _, h := range backlog {
+		if send(h) != nil {
+			return
+		}
+	}
+	fmt.Fprintf(w, "event: caught-up\ndata: {}\n\n")
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case h := <-ch:
+			if send(h) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// renderLiveMeter writes the dashboard's scrolling meter of the last minute of
+// hits per frequency, one stacked column per second, fed by /api/stream. Sound
+// and browser notifications are opt-in per browser and remembered in
+// localStorage; they fire when the last minute's hits cross the threshold and
+// whenever a Meshtastic packet is heard, at most once per liveAlertCooldown each.
+func renderLiveMeter(w io.Writer, l Lang, group string) {
+	stream := "/api/stream"
+	if group != "" {
+		stream += "?group=" + url.QueryEscape(group)
+	}
+	meter := struct {
+		Stream     string   `json:"stream"`
+		Colors     []string `json:"colors"`
+		Categories []string `json:"categories"`
+		MHz        []string `json:"mhz"`
+		Threshold  int      `json:"threshold"`
+		CooldownMs int64    `json:"cooldownMs"`
+		Hits       string   `json:"hits"`
+		Busy       string   `json:"busy"`
+		Meshtastic string   `json:"meshtastic"`
+	}{
+		Stream: stream, Threshold: liveAlertThreshold, CooldownMs: liveAlertCooldown.Milliseconds(),
+		Hits: l.T("%d hits"), Busy: l.T("%d hits in the last minute"), Meshtastic: l.T("Meshtastic packet heard by %s on %s MHz"),
+	}
+	for _, f := range frequencies() {
+		meter.Colors = append(meter.Colors, f.Color)
+		meter.Categories = append(meter.Categories, f.Category)
+		meter.MHz = append(meter.MHz, f.MHz)
+	}
+	meterJSON, _ := json.Marshal(meter)
+	fmt.Fprintf(w, `
+    <div class="card">
+        <h2><span class="icon">⚡</span> %s <span class="live-total" id="live-total"></span></h2>
+        <canvas id="live-meter" class="chart" width="600" height="80" style="width: 100%%; height: 80px;" role="img" aria-label="%s"></canvas>
+        <div class="chart-label">%s</div>
+        <div class="live-controls">
+            <label><input type="checkbox" id="live-sound"> 🔊 %s</label>
+            <label><input type="checkbox" id="live-notify"> 🔔 %s</label>
+            <label>%s <input type="number" id="live-threshold" min="1"> %s</label>
+        </div>
+    </div>
+    <script>
+    (function() {
+        const m = %s, seconds = 60;
+        const canvas = document.getElementById('live-meter'), g = canvas.getContext('2d');
+        const sound = document.getElementById('live-sound'), notify = document.getElementById('live-notify');
+        const threshold = document.getElementById('live-threshold');
+        sound.checked = localStorage.getItem('liveSound') === '1';
+        notify.checked = localStorage.getItem('liveNotify') === '1' && window.Notification && Notification.permission === 'granted';
+        threshold.value = localStorage.getItem('liveThreshold') || m.threshold;
+        sound.onchange = () => localStorage.setItem('liveSound', sound.checked ? '1' : '0');
+        threshold.onchange = () => localStorage.setItem('liveThreshold', threshold.value);
+        notify.onchange = () => {
+            localStorage.setItem('liveNotify', notify.checked ? '1' : '0');
+            if (!notify.checked) return;
+            if (!window.Notification) { notify.checked = false; return; }
+            Notification.requestPermission().then(p => {
+                notify.checked = p === 'granted';
+                localStorage.setItem('liveNotify', notify.checked ? '1' : '0');
+            });
+        };
+
+        let audio;
+        function ping(freq) {
+            audio = audio || new (window.AudioContext || window.webkitAudioContext)();
+            const osc = audio.createOscillator(), gain = audio.createGain(), t = audio.currentTime;
+            osc.frequency.value = freq;
+            gain.gain.setValueAtTime(0.2, t);
+            gain.gain.exponentialRampToValueAtTime(0.001, t + 0.3);
+            osc.connect(gain).connect(audio.destination);
+            osc.start(t);
+            osc.stop(t + 0.3);
+        }
+        const lastAlert = {};
+        function alertOnce(kind, message, tone) {
+            const now = Date.now();
+            if (now - (lastAlert[kind] || 0) < m.cooldownMs) return;
+            lastAlert[kind] = now;
+            if (sound.checked) ping(tone);
+            if (notify.checked && !document.hasFocus()) new Notification('LoRa Detector', {body: message, tag: kind});
+        }
+
+        let hits = [], live = false, busy = false;
+        function draw() {
+            const now = Date.now(), col = canvas.width / seconds, h = canvas.height;
+            hits = hits.filter(x => now - x.t < seconds * 1000);
+            const sums = Array.from({length: seconds}, () => new Array(m.colors.length).fill(0));
+            let total = 0, peak = 1;
+            for (const x of hits) {
+                const i = seconds - 1 - Math.floor((now - x.t) / 1000);
+                if (i < 0 || i >= seconds) continue;
+                x.counts.forEach((c, f) => { sums[i][f] += c; total += c; });
+            }
+            for (const s of sums) peak = Math.max(peak, s.reduce((a, b) => a + b, 0));
+            g.clearRect(0, 0, canvas.width, h);
+            sums.forEach((s, i) => {
+                let y = h;
+                s.forEach((c, f) => {
+                    if (!c) return;
+                    const bh = c * (h - 2) / peak;
+                    y -= bh;
+                    g.fillStyle = m.colors[f];
+                    g.fillRect(i * col + 1, y, col - 2, bh);
+                });
+            });
+            document.getElementById('live-total').textContent = m.hits.replace('%%d', total);
+
+            // Alert on the way up only; the replayed minute isn't news
+            const over = total >= Number(threshold.value || m.threshold);
+            if (live && over && !busy) alertOnce('busy', m.busy.replace('%%d', total), 660);
+            busy = over;
+        }
+        const es = new EventSource(m.stream);
+        es.addEventListener('open', () => { hits = []; live = false; }); // Each connection replays the last minute
+        es.addEventListener('caught-up', () => { draw(); live = true; });
+        es.addEventListener('detections', e => {
+            const d = JSON.parse(e.data);
+            hits.push({t: Date.parse(d.time), counts: d.counts});
+            if (live) {
+                d.counts.forEach((c, f) => {
+                    if (c > 0 && m.categories[f] === 'meshtastic') {
+                        alertOnce('meshtastic', m.meshtastic.replace('%%s', d.device_id).replace('%%s', m.mhz[f]), 880);
+                    }
+                });
+            }
+            draw();
+        });
+        setInterval(draw, 1000);
+        draw();
+    })();
+    </script>
+`, l.T("Live: last 60 seconds"), l.T("Detections per second over the last minute"), l.T("One column per second"), l.T("Sound"), l.T("Notify"), l.T("Alert at"), l.T("hits/min"),
+		meterJSON)
+}
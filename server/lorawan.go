@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// lorawanStatsPort is the FPort detectors send compact stats on; other ports are ignored
+const lorawanStatsPort = 1
+
+// lorawanUplink covers the fields we need from both ChirpStack v4 "up" events
+// and The Things Stack v3 uplink webhooks. []byte fields decode from base64.
+type lorawanUplink struct {
+	// ChirpStack
+	DeviceInfo struct {
+		DeviceName string `json:"deviceName"`
+		DevEUI     string `json:"devEui"`
+	} `json:"deviceInfo"`
+	FPort *int   `json:"fPort"`
+	Data  []byte `json:"data"`
+
+	// The Things Stack
+	EndDeviceIDs struct {
+		DeviceID string `json:"device_id"`
+		DevEUI   string `json:"dev_eui"`
+	} `json:"end_device_ids"`
+	UplinkMessage *struct {
+		FPort      int    `json:"f_port"`
+		FRMPayload []byte `json:"frm_payload"`
+	} `json:"uplink_message"`
+}
+
+// deviceAndPayload normalises either format; ok is false for anything that isn't an uplink
+func (u lorawanUplink) deviceAndPayload() (deviceID string, port int, payload []byte, ok bool) {
+	switch {
+	case u.UplinkMessage != nil:
+		deviceID = u.EndDeviceIDs.DeviceID
+		if deviceID == "" {
+			deviceID = u.EndDeviceIDs.DevEUI
+		}
+		return deviceID, u.UplinkMessage.FPort, u.UplinkMessage.FRMPayload, true
+	case u.FPort != nil:
+		deviceID = u.DeviceInfo.DeviceName
+		if deviceID == "" {
+			deviceID = u.DeviceInfo.DevEUI
+		}
+		return deviceID, *u.FPort, u.Data, true
+	}
+	return "", 0, nil, false
+}
+
+// lorawanAuthorized checks the shared secret configured on the network server's
+// integration, sent as "Authorization: Bearer <token>". No token configured means open.
+func lorawanAuthorized(r *http.Request) bool {
+	token := os.Getenv("LORAWAN_WEBHOOK_TOKEN")
+	if token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// handleLoRaWAN ingests detector stats relayed by a ChirpStack HTTP integration or a
+// TTN webhook: POST /api/lorawan. Non-uplink events (joins, acks, status) are ignored.
+func handleLoRaWAN(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !lorawanAuthorized(r) {
+		noteAbuse(r, "invalid webhook token")
+		http.Error(w, "Invalid webhook token", http.StatusUnauthorized)
+		return
+	}
+	// ChirpStack sends every event type to the same URL, tagged ?event=
+	if event := r.URL.Query().Get("event"); event != "" && event != "up" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var uplink lorawanUplink
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadBody)).Decode(&uplink); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			noteAbuse(r, "oversized body")
+			http.Error(w, "Uplink too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Error decoding LoRaWAN webhook: %v", err)
+		noteAbuse(r, "invalid JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	deviceID, port, payload, ok := uplink.deviceAndPayload()
+	if !ok || port != lorawanStatsPort {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	stats, err := decodeCompactStats(payload)
+	if err != nil {
+		log.Printf("Bad LoRaWAN payload from %s: %v", deviceID, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	stats.DeviceID = deviceID
+	if stats.DeviceID == "" {
+		stats.DeviceID = "unknown"
+	}
+	if err := validateStats(&stats); err != nil {
+		log.Printf("Bad LoRaWAN payload from %s: %v", deviceID, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := refuseUnsignable(r.Context(), stats.DeviceID, transportLoRaWAN); err != nil {
+		log.Printf("Refusing LoRaWAN uplink from %s: %v", deviceID, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	stats.Timestamp = timeNow()
+	stats.UploaderIP = r.RemoteAddr
+
+	if _, err := ingestStats(r.Context(), &stats); err != nil {
+		rejectFailedSave(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"ack_id": stats.AckID,
+	})
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,8 +32,9 @@ type FrequencyInfo struct {
 	Color    string
 }
 
-// Frequency map matching the ESP32 SCAN_FREQUENCIES array
-var frequencies = []FrequencyInfo{
+// defaultFrequencies matches the ESP32 SCAN_FREQUENCIES array; the admin
+// frequency plan can relabel or switch off slots without a rebuild
+var defaultFrequencies = []FrequencyInfo{
 	{"903.9", "LoRaWAN Ch0", "lorawan", "IoT sensors, industrial monitors", "#4CAF50"},
 	{"906.3", "LoRaWAN Uplink", "lorawan", "Smart agriculture, asset trackers", "#8BC34A"},
 	{"909.1", "LoRaWAN Mid", "lorawan", "Environmental sensors, weather stations", "#CDDC39"},
@@ -45,76 +56,339 @@ type Stats struct {
 	FreqDetections   []int     `json:"freq_detections"`
 	Timestamp        time.Time `json:"timestamp"`
 	UploaderIP       string    `json:"uploader_ip"`
+	Bearing          *float64  `json:"bearing_deg,omitempty"` // Antenna heading, for directional rigs
+	Latitude         *float64  `json:"latitude,omitempty"`    // Detector position, if it knows it
+	Longitude        *float64  `json:"longitude,omitempty"`
+	DeviceTime       int64     `json:"device_time,omitempty"` // Device clock (epoch seconds), checked for skew
+	LastAck          *int64    `json:"last_ack,omitempty"`    // Last ack_id the device received
+	AckID            int64     `json:"ack_id,omitempty"`      // Server sequence number for this upload
+	DeviceHealth
 }
 
 // PeriodSummary holds aggregated stats for a time period
 type PeriodSummary struct {
 	Label           string
 	Days            int
+	From, To        string `json:",omitempty"` // A custom range's first and last days, or its bounds
 	TotalUploads    int
 	TotalDetections int
 	TotalScanTime   int // seconds
 	AvgDetPerMin    float64
 	AvgActivity     float64
 	PeakActivity    int
-	FreqTotals      []int // Per-frequency totals
+	FreqTotals      []int         // Per-frequency totals
+	DetPerMinStats  *Distribution // nil without any uploads with a histogram
+	ActivityStats   *Distribution
 }
 
 // Store keeps track of all uploads (in-memory cache + SQLite)
 type Store struct {
-	mu     sync.RWMutex
-	latest map[string]Stats // Latest per device (in-memory)
-	db     *sql.DB
+	mu        sync.RWMutex
+	latest    map[string]Stats // Latest per device (in-memory)
+	db        *sql.DB          // The one writer connection
+	readDB    *sql.DB          // Reader pool; the same handle as db in memory and read-only
+	stmts     sync.Map         // stmtKey -> *sql.Stmt (see database.go)
+	path      string
+	ephemeral bool // Database is in memory and lost on exit
+	readOnly  bool
+	uploads   chan uploadWrite // Uploads waiting for the writer (see writer.go)
+	saves     saveCounters     // Upload save failures (see savefailures.go)
+
+	// Size cap evictions (see storage.go)
+	lastEviction time.Time
+	evictedRows  int64
+
+	integrity *IntegrityReport // Last integrity check, if any
+	webhooks  []Webhook        // Enabled webhooks (see webhooks.go)
+
+	// Whether an admin user exists, as of adminsChecked (see users.go)
+	adminsMu      sync.Mutex
+	admins        bool
+	adminsChecked time.Time
 }
 
 var store *Store
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	os.Exit(runCLI(os.Args[1:]))
+}
 
-	// Initialize database
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/data/lora.db"
-	}
+// registerRoutes adds the pages and APIs to the default mux
+func registerRoutes() {
+	http.HandleFunc("/", handleHome)
+	http.HandleFunc("/upload", handleUpload)
+	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/api/stats", handleAPIStats)
+	http.HandleFunc("/api/history", handleAPIHistory)
+	http.HandleFunc("/api/calibrate", handleAPICalibrate)
+	http.HandleFunc("/api/baselines", handleAPIBaselines)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/api/minutes", handleAPIMinutes)
+	http.HandleFunc("/api/stream", handleAPIStream)
+	http.HandleFunc("/api/periodic", handleAPIPeriodic)
+	http.HandleFunc("/api/transmitters", handleAPITransmitters)
+	http.HandleFunc("/api/spreading", handleAPISpreading)
+	http.HandleFunc("/api/airtime", handleAPIAirtime)
+	http.HandleFunc("/api/forecast", handleAPIForecast)
+	http.HandleFunc("/api/changepoints", handleAPIChangePoints)
+	http.HandleFunc("/api/bearing", handleAPIBearing)
+	http.HandleFunc("/bearing", handleBearing)
+	http.HandleFunc("/api/devices", handleAPIDevices)
+	http.HandleFunc("/api/devices/{id}/last-uploads", handleAPILastUploads)
+	http.HandleFunc("/api/quarantine", handleAPIQuarantine)
+	http.HandleFunc("/api/uptime", handleAPIUptime)
+	http.HandleFunc("/api/availability", handleAPIAvailability)
+	http.HandleFunc("/availability", handleAvailability)
+	http.HandleFunc("/print", handlePrint)
+	http.HandleFunc("/print/regulatory", handleRegulatoryReport)
+	http.HandleFunc("/weather", handleWeather)
+	http.HandleFunc("/api/weather", handleWeather)
+	http.HandleFunc("/api/solar", handleAPISolar)
+	http.HandleFunc("/api/fixes", handleAPIFixes)
+	http.HandleFunc("/map", handleMap)
+	http.HandleFunc("/api/geo.json", handleGeoJSON)
+	http.HandleFunc("/api/geo.kml", handleGeoKML)
+	http.HandleFunc("/api/time", handleAPITime)
+	http.HandleFunc("/api/health", handleAPIHealth)
+	http.HandleFunc("/device", handleDevice)
+	http.HandleFunc("/api/groups", handleAPIGroups)
+	http.HandleFunc("/group/{name}", handleGroup)
+	http.HandleFunc("/frequency/{mhz}", handleFrequency)
+	http.HandleFunc("/category/{name}", handleCategory)
+	http.HandleFunc("/api/activity", handleAPIActivity)
+	http.HandleFunc("/api/seen", handleAPISeen)
+	http.HandleFunc("/api/annotations", handleAPIAnnotations)
+	http.HandleFunc("/api/tags", handleAPITags)
+	http.HandleFunc("/api/search", handleAPISearch)
+	http.HandleFunc("/search", handleSearch)
+	http.HandleFunc("/api/poll/{feed}", handleAPIPoll)
+	http.HandleFunc("/m", handleMobile)
+	http.HandleFunc("/manifest.webmanifest", handleManifest)
+	http.HandleFunc("/sw.js", handleServiceWorker)
+	http.HandleFunc("/icon.svg", handleIcon)
+	http.HandleFunc("/api/lorawan", handleLoRaWAN)
+	http.HandleFunc("/api/import", handleAPIImport)
+	http.HandleFunc("/api/query", handleAPIQuery)
+	http.HandleFunc("/api/admin/status", handleAPIAdminStatus)
+	http.HandleFunc("/api/admin/abuse", handleAPIAdminAbuse)
+	http.HandleFunc("/api/admin/diagnostics", handleAPIDiagnostics)
+	http.HandleFunc("/admin", handleAdmin)
+	http.HandleFunc("/api/frequencies", handleAPIFrequencies)
+	http.HandleFunc("/api/schema", handleAPISchema)
+	http.HandleFunc("/admin/frequencies", handleFrequencyPlan)
+	http.HandleFunc("/admin/diagnostics", handleDiagnostics)
+	http.HandleFunc("/api/webhooks", handleAPIWebhooks)
+	http.HandleFunc("/api/webhooks/deliveries", handleAPIWebhookDeliveries)
+	http.HandleFunc("/api/webhooks/test", handleAPIWebhookTest)
+	http.HandleFunc("/webhooks", handleWebhooks)
+	http.HandleFunc("/auth/login", handleLogin)
+	http.HandleFunc("/auth/callback", handleLoginCallback)
+	http.HandleFunc("/auth/logout", handleLogout)
+	http.HandleFunc(communityReportPath, handleAPICommunityReport)
+	http.HandleFunc("/api/community", handleAPICommunity)
+	http.HandleFunc("/community", handleCommunity)
+	http.HandleFunc("/api/community/leaderboard", handleAPICommunityLeaderboard)
+	http.HandleFunc("/api/community/region", handleAPICommunityRegion)
+	http.HandleFunc("/api/community/compare", handleAPICommunityCompare)
+	http.HandleFunc("/community/leaderboard", handleCommunityLeaderboard)
+	http.HandleFunc(federationPath, handleFederationSummary)
+	http.HandleFunc(relayPath, handleAPIRelay)
+	http.HandleFunc("/api/sites", handleAPISites)
+	http.HandleFunc("/sites", handleSites)
+}
 
-	// Ensure data directory exists
-	if err := os.MkdirAll("/data", 0755); err != nil {
-		// Fall back to current directory if /data isn't available
-		dbPath = "./lora.db"
+// runServe runs the server and its optional listeners; it only returns on failure
+func runServe(args []string) error {
+	ctx := context.Background()
+	cfg := loadConfig(args)
+	dbPath, err := openStore(cfg)
+	if err != nil {
+		return err
 	}
 
-	db, err := initDB(dbPath)
+	// Load latest stats from DB
+	store.loadLatest(ctx)
+	log.Printf("Loaded %d devices from database", len(store.latest))
+	store.loadFrequencyPlan(ctx)
+	if mode := integrityCheckMode(); mode != "off" {
+		go func() { logIntegrity(store.checkIntegrity(ctx, mode, cfg.RepairDB && !cfg.ReadOnly)) }()
+	}
+	if oidc, err = oidcFromEnv(); err != nil {
+		return err
+	} else if oidc != nil {
+		log.Printf("OIDC sign-in enabled with %s", oidc.issuer)
+	}
+	if proxy, err = proxyAuthFromEnv(); err != nil {
+		return err
+	} else if proxy != nil {
+		log.Printf("Trusting user headers from %s", os.Getenv("TRUSTED_PROXIES"))
+	}
+	if mqtt, err = mqttFromEnv(); err != nil {
+		return err
+	} else if mqtt != nil && !cfg.ReadOnly {
+		go mqtt.run()
+	}
+	if natsIngest, err = natsFromEnv(); err != nil {
+		return err
+	} else if natsIngest != nil && !cfg.ReadOnly {
+		for range natsConsumers() {
+			go natsIngest.consume()
+		}
+	}
+	if rawLog, err = rawLogFromEnv(); err != nil {
+		return err
+	} else if rawLog != nil {
+		log.Printf("Appending accepted uploads to %s", rawLog.path)
+	}
+	if syslogOut, err = syslogFromEnv(); err != nil {
+		return err
+	} else if syslogOut != nil && !cfg.ReadOnly {
+		log.Printf("Sending uploads and alerts to syslog at %s", syslogOut.addr)
+		go syslogOut.run()
+	}
+	export, err := exportFromEnv()
+	if err != nil {
+		return err
+	}
+	peers, err := federationURLs("FEDERATION_PEERS")
+	if err != nil {
+		return err
+	}
+	pushTo, err := federationURLs("FEDERATION_PUSH")
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		return err
 	}
+	if (len(peers) > 0 || len(pushTo) > 0) && federationKey() == "" {
+		return errors.New("FEDERATION_PEERS and FEDERATION_PUSH need FEDERATION_KEY")
+	}
+	relayTo, err := relayURL()
+	if err != nil {
+		return err
+	}
+	if cfg.ReadOnly {
+		go store.followReplica(replicaRefreshInterval)
+	} else {
+		go store.enforceRetention()
+		go store.watchStorage()
+		go store.scrubUploaderIPs(ctx)
+		store.startQuotas(ctx)
+		store.loadWebhooks(ctx)
+		go store.deliverWebhooks()
+		go store.watchOffline()
+		go store.watchForecasts()
+		go store.watchChangePoints()
+		if export != nil {
+			log.Printf("Exporting uploads (%s) to %s every %s", export.Format, export.URL, export.Interval)
+			go store.runExports(export)
+		}
+		if base := communityURL(); base != "" {
+			go store.shareWithCommunity(base)
+			go store.followRegionalAverages(base)
+		}
+		switch weatherProvider() {
+		case "":
+		case "open-meteo":
+			go store.followWeather()
+		default:
+			log.Printf("Warning: unknown WEATHER_PROVIDER %q; only open-meteo is supported", weatherProvider())
+		}
+		if solarIndicesEnabled() {
+			go store.followSolarIndices()
+		}
+		if relayTo != "" {
+			go store.relayUploads(relayTo)
+		}
+	}
+	// Summaries are held in memory, so read-only replicas federate too
+	if len(peers) > 0 || len(pushTo) > 0 {
+		go store.federate(peers, pushTo)
+	}
+
+	registerRoutes()
 
-	store = &Store{
-		latest: make(map[string]Stats),
-		db:     db,
+	var handler http.Handler = adminGuard(metricsGuard(http.DefaultServeMux))
+	if cfg.ReadOnly {
+		handler = readOnlyGuard(handler)
+		log.Printf("Read-only mode: uploads and changes are disabled")
 	}
+	handler = abuseGuard(handler)
+	handler = timeoutGuard(handler)
 
-	// Load latest stats from DB
-	store.loadLatest()
+	// Before the UDP and CoAP listeners, which refuse uploads while client
+	// certificates are required
+	tlsConf, err := tlsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.UDPAddr != "" && !cfg.ReadOnly {
+		conn, err := net.ListenPacket("udp", cfg.UDPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on UDP %s: %w", cfg.UDPAddr, err)
+		}
+		log.Printf("Accepting UDP stats on %s", cfg.UDPAddr)
+		go func() { log.Fatal(serveUDP(conn)) }()
+	}
+	if cfg.CoAPAddr != "" && !cfg.ReadOnly {
+		conn, err := net.ListenPacket("udp", cfg.CoAPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on CoAP %s: %w", cfg.CoAPAddr, err)
+		}
+		log.Printf("Accepting CoAP uploads on %s", cfg.CoAPAddr)
+		go func() { log.Fatal(serveCoAP(conn)) }()
+	}
+	if cfg.SNMPAddr != "" {
+		conn, err := net.ListenPacket("udp", cfg.SNMPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on SNMP %s: %w", cfg.SNMPAddr, err)
+		}
+		log.Printf("Answering SNMP queries on %s", cfg.SNMPAddr)
+		go func() { log.Fatal(serveSNMP(conn)) }()
+	}
+	if cfg.SerialPort != "" && !cfg.ReadOnly {
+		go serveSerial(cfg.SerialPort, cfg.SerialBaud)
+	}
+	if cfg.replicating() && !cfg.ReadOnly && !store.ephemeral {
+		go superviseReplication(cfg, dbPath)
+	}
 
-	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/upload", handleUpload)
-	http.HandleFunc("/stats", handleStats)
-	http.HandleFunc("/api/stats", handleAPIStats)
-	http.HandleFunc("/api/history", handleAPIHistory)
+	listener, err := listen(cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", cfg.ListenAddr, err)
+	}
+	if tlsConf != nil {
+		listener = tls.NewListener(listener, tlsConf)
+		if clientCertsRequired {
+			log.Printf("Client certificates required (-tls-client-auth %s)", cfg.TLSClientAuth)
+		}
+	}
 
-	log.Printf("LoRa Detector Server starting on port %s (DB: %s)", port, dbPath)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Printf("LoRa Detector Server listening on %s (DB: %s)", cfg.ListenAddr, dbPath)
+	return http.Serve(listener, handler)
 }
 
-func initDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", path)
+// sqliteParams make concurrent uploads wait for each other instead of failing
+// with "database is locked": WAL lets dashboard reads proceed during writes, the
+// busy timeout queues writers, and immediate transactions take the write lock
+// up front so read-then-write transactions (acks) can't deadlock
+const sqliteParams = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate"
+
+func initDB(path string, readOnly bool) (*sql.DB, error) {
+	if readOnly {
+		return openReadOnlyDB(path)
+	}
+	dsn := path + sqliteParams
+	if path == memoryDBPath {
+		dsn = path // WAL and busy waits don't apply
+	}
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
+	// SQLite allows one writer at a time. Queueing writers for a single
+	// connection here, rather than on the file lock, keeps uploads in order and
+	// leaves the reader pool (see database.go) to the dashboard. Every
+	// connection to :memory: is its own empty database, so that gets only this one.
+	db.SetMaxOpenConns(1)
 
 	// Create tables
 	schema := `
@@ -127,19 +401,231 @@ func initDB(path string) (*sql.DB, error) {
 		detections_per_min INTEGER,
 		current_activity_pct INTEGER,
 		peak_activity_pct INTEGER,
-		freq_0 INTEGER DEFAULT 0,
-		freq_1 INTEGER DEFAULT 0,
-		freq_2 INTEGER DEFAULT 0,
-		freq_3 INTEGER DEFAULT 0,
-		freq_4 INTEGER DEFAULT 0,
-		freq_5 INTEGER DEFAULT 0,
-		freq_6 INTEGER DEFAULT 0,
-		freq_7 INTEGER DEFAULT 0,
+		freqs TEXT NOT NULL DEFAULT '',
 		uploader_ip TEXT
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_uploads_timestamp ON uploads(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_uploads_device ON uploads(device_id);
+	CREATE INDEX IF NOT EXISTS idx_uploads_device_time ON uploads(device_id, timestamp);
+	DROP INDEX IF EXISTS idx_uploads_device;
+
+	CREATE TABLE IF NOT EXISTS calibrations (
+		device_id TEXT PRIMARY KEY,
+		started_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		window_minutes INTEGER NOT NULL,
+		completed INTEGER DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS baselines (
+		device_id TEXT NOT NULL,
+		freq_index INTEGER NOT NULL,
+		rate_per_min REAL NOT NULL,
+		computed_at DATETIME NOT NULL,
+		PRIMARY KEY (device_id, freq_index)
+	);
+
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		kind TEXT NOT NULL,
+		message TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_alerts_timestamp ON alerts(timestamp);
+
+	CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		freq_index INTEGER NOT NULL,
+		rssi REAL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_events_device_time ON events(device_id, timestamp);
+
+	CREATE TABLE IF NOT EXISTS devices (
+		device_id TEXT PRIMARY KEY,
+		latitude REAL,
+		longitude REAL
+	);
+
+	CREATE TABLE IF NOT EXISTS minute_bins (
+		device_id TEXT NOT NULL,
+		minute DATETIME NOT NULL,
+		freq_index INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (device_id, minute, freq_index)
+	);
+
+	CREATE TABLE IF NOT EXISTS device_health (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		free_heap INTEGER,
+		wifi_rssi INTEGER,
+		battery_v REAL,
+		temperature_c REAL,
+		reset_reason TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_device_health_device_time ON device_health(device_id, timestamp);
+
+	CREATE TABLE IF NOT EXISTS weather_hourly (
+		device_id TEXT NOT NULL,
+		hour DATETIME NOT NULL,
+		temperature_c REAL,
+		precipitation_mm REAL,
+		PRIMARY KEY (device_id, hour)
+	);
+
+	CREATE TABLE IF NOT EXISTS solar_daily (
+		day TEXT PRIMARY KEY,
+		f107 REAL,
+		sunspots INTEGER,
+		ap INTEGER,
+		kp_max REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_device_health_battery ON device_health(device_id, timestamp, battery_v) WHERE battery_v IS NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS upload_acks (
+		device_id TEXT PRIMARY KEY,
+		last_ack INTEGER NOT NULL,
+		missed INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_rollups (
+		tier TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		period DATETIME NOT NULL,
+		uploads INTEGER NOT NULL,
+		total_detections INTEGER NOT NULL,
+		uptime_seconds INTEGER NOT NULL,
+		sum_detections_per_min INTEGER NOT NULL,
+		sum_activity_pct INTEGER NOT NULL,
+		peak_activity_pct INTEGER NOT NULL,
+		freqs TEXT NOT NULL DEFAULT '',
+		new_freqs TEXT NOT NULL DEFAULT '',
+		rate_hist TEXT NOT NULL DEFAULT '',
+		activity_hist TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (tier, device_id, period)
+	);
+	CREATE INDEX IF NOT EXISTS idx_upload_rollups_period ON upload_rollups(period);
+
+	CREATE TABLE IF NOT EXISTS rollup_cursors (
+		device_id TEXT PRIMARY KEY,
+		uptime_seconds INTEGER NOT NULL,
+		freqs TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS annotations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL DEFAULT '',
+		start_time DATETIME NOT NULL,
+		end_time DATETIME NOT NULL,
+		note TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_annotations_device_time ON annotations(device_id, start_time);
+
+	CREATE TABLE IF NOT EXISTS change_points (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		mhz TEXT NOT NULL,
+		day TEXT NOT NULL,
+		before_per_day REAL NOT NULL,
+		after_per_day REAL NOT NULL,
+		annotation_id INTEGER,
+		detected_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_change_points_device_day ON change_points(device_id, mhz, day);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		kind TEXT NOT NULL,
+		ref_id INTEGER NOT NULL,
+		device_id TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (kind, ref_id, tag)
+	);
+	CREATE INDEX IF NOT EXISTS idx_tags_tag_time ON tags(tag, timestamp);
+
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS community_reports (
+		station TEXT NOT NULL,
+		period_start DATETIME NOT NULL,
+		period_end DATETIME NOT NULL,
+		received_at DATETIME NOT NULL,
+		latitude REAL,
+		longitude REAL,
+		uploads INTEGER NOT NULL,
+		detections INTEGER NOT NULL,
+		avg_activity_pct REAL NOT NULL,
+		peak_activity_pct INTEGER NOT NULL,
+		freqs TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (station, period_start)
+	);
+	CREATE INDEX IF NOT EXISTS idx_community_reports_end ON community_reports(period_end);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		device_id TEXT,
+		template TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		device_id TEXT,
+		created_at DATETIME NOT NULL,
+		body TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt DATETIME,
+		last_status INTEGER,
+		last_error TEXT,
+		delivered_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook ON webhook_deliveries(webhook_id, id);
+
+	CREATE TABLE IF NOT EXISTS outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sink TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		queued_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbox_sink ON outbox(sink, id);
+
+	CREATE TABLE IF NOT EXISTS quarantine (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		received DATETIME NOT NULL,
+		body TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_quarantine_device ON quarantine(device_id, id);
+	CREATE INDEX IF NOT EXISTS idx_quarantine_received ON quarantine(received);
 	`
 
 	_, err = db.Exec(schema)
@@ -147,22 +633,139 @@ func initDB(path string) (*sql.DB, error) {
 		return nil, err
 	}
 
-	// Clean up old data (older than 1 year)
-	_, err = db.Exec(`DELETE FROM uploads WHERE timestamp < datetime('now', '-365 days')`)
+	// Columns added after the original schema
+	if err := migrateCountColumns(db); err != nil {
+		return nil, fmt.Errorf("moving per-frequency counts: %w", err)
+	}
+	if err := ensureColumn(db, "uploads", "bearing", "REAL"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "uploads", "ack_id", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "battery_low_v", "REAL"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "battery_critical_v", "REAL"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "group_name", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "timezone", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "udp_token", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "signing_key", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "upload_interval_sec", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "colocated", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "channels", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "devices", "quarantined", "DATETIME"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "events", "sf", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "events", "bw", "REAL"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "events", "payload_len", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "upload_rollups", "rate_hist", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "upload_rollups", "activity_hist", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "community_reports", "uptime_seconds", "INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := ensureColumn(db, "community_reports", "leaderboard", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+
+	if path != memoryDBPath {
+		if err := setAutoVacuum(db, storagePolicy().AutoVacuum); err != nil {
+			return nil, fmt.Errorf("setting auto_vacuum: %w", err)
+		}
+	}
+
+	// Uploads age out through the retention tiers (see retention.go). Raw events are bulky; keep 90 days of them and a year of minute bins
+	_, err = db.Exec(`DELETE FROM events WHERE timestamp < ?`, dbTimeAgo(90*24*time.Hour))
 	if err != nil {
-		log.Printf("Warning: failed to clean old data: %v", err)
+		log.Printf("Warning: failed to clean old events: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM minute_bins WHERE minute < ?`, dbTimeAgo(365*24*time.Hour))
+	if err != nil {
+		log.Printf("Warning: failed to clean old minute bins: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM device_health WHERE timestamp < ?`, dbTimeAgo(365*24*time.Hour))
+	if err != nil {
+		log.Printf("Warning: failed to clean old device health: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM community_reports WHERE period_end < ?`, dbTimeAgo(365*24*time.Hour))
+	if err != nil {
+		log.Printf("Warning: failed to clean old community reports: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM weather_hourly WHERE hour < ?`, dbTimeAgo(365*24*time.Hour))
+	if err != nil {
+		log.Printf("Warning: failed to clean old weather: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM webhook_deliveries WHERE created_at < ? AND status != 'pending'`, dbTimeAgo(30*24*time.Hour))
+	if err != nil {
+		log.Printf("Warning: failed to clean old webhook deliveries: %v", err)
 	}
 
 	return db, nil
 }
 
-func (s *Store) loadLatest() {
-	rows, err := s.db.Query(`
+// ensureColumn adds a column to an existing table if it isn't there yet
+func ensureColumn(db *sql.DB, table, column, def string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, def))
+	return err
+}
+
+func (s *Store) loadLatest(ctx context.Context) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
 		SELECT device_id, timestamp, uptime_seconds, total_detections,
 			   detections_per_min, current_activity_pct, peak_activity_pct,
-			   freq_0, freq_1, freq_2, freq_3, freq_4, freq_5, freq_6, freq_7, uploader_ip
+			   freqs, uploader_ip, bearing
 		FROM uploads
-		WHERE id IN (SELECT MAX(id) FROM uploads GROUP BY device_id)
+		WHERE id IN (
+			SELECT (SELECT id FROM uploads u WHERE u.device_id = d.device_id ORDER BY timestamp DESC, id DESC LIMIT 1)
+			FROM uploads d GROUP BY device_id
+		)
 	`)
 	if err != nil {
 		log.Printf("Error loading latest stats: %v", err)
@@ -175,79 +778,90 @@ func (s *Store) loadLatest() {
 
 	for rows.Next() {
 		var stats Stats
-		var ts string
-		var f0, f1, f2, f3, f4, f5, f6, f7 int
+		var ts, freqs string
 		err := rows.Scan(&stats.DeviceID, &ts, &stats.Uptime, &stats.TotalDetections,
 			&stats.DetectionsPerMin, &stats.CurrentActivity, &stats.PeakActivity,
-			&f0, &f1, &f2, &f3, &f4, &f5, &f6, &f7, &stats.UploaderIP)
+			&freqs, &stats.UploaderIP, &stats.Bearing)
 		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
-		stats.FreqDetections = []int{f0, f1, f2, f3, f4, f5, f6, f7}
-		stats.Timestamp, _ = time.Parse("2006-01-02 15:04:05", ts)
+		stats.FreqDetections = parseCounts(freqs)
+		stats.Timestamp = parseDBTime(ts)
 		s.latest[stats.DeviceID] = stats
 	}
-	log.Printf("Loaded %d devices from database", len(s.latest))
 }
 
-func (s *Store) saveUpload(stats Stats) error {
-	freqs := make([]int, 8)
-	for i := 0; i < 8 && i < len(stats.FreqDetections); i++ {
-		freqs[i] = stats.FreqDetections[i]
+// parseDBTime parses a DATETIME column. Timestamps are written as local wall-clock
+// time, but the driver may hand them back as RFC 3339 in UTC, so the wall clock is
+// reinterpreted in the local zone either way.
+func parseDBTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, _ = time.Parse("2006-01-02 15:04:05", s)
 	}
-
-	_, err := s.db.Exec(`
-		INSERT INTO uploads (device_id, timestamp, uptime_seconds, total_detections,
-			detections_per_min, current_activity_pct, peak_activity_pct,
-			freq_0, freq_1, freq_2, freq_3, freq_4, freq_5, freq_6, freq_7, uploader_ip)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, stats.DeviceID, stats.Timestamp.Format("2006-01-02 15:04:05"),
-		stats.Uptime, stats.TotalDetections, stats.DetectionsPerMin,
-		stats.CurrentActivity, stats.PeakActivity,
-		freqs[0], freqs[1], freqs[2], freqs[3], freqs[4], freqs[5], freqs[6], freqs[7],
-		stats.UploaderIP)
-
-	return err
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
 }
 
-func (s *Store) getSummary(days int) PeriodSummary {
-	summary := PeriodSummary{
-		Days:       days,
-		FreqTotals: make([]int, 8),
-	}
+// getSummary totals uploads over the last n calendar days in the devices' timezone,
+// across all devices unless some are given
+func (s *Store) getSummary(ctx context.Context, days int, deviceIDs ...string) PeriodSummary {
+	return s.getSummaryRange(ctx, lastDays(days, s.locationFor(ctx, deviceIDs)), deviceIDs...)
+}
 
-	row := s.db.QueryRow(`
+// getSummaryRange totals uploads over a range of calendar days, or an exact
+// range. Rollups are counted by when their hour or day starts, so an exact
+// bound inside rolled-up history falls on the rollup's boundary.
+func (s *Store) getSummaryRange(ctx context.Context, r DateRange, deviceIDs ...string) PeriodSummary {
+	days := r.Days()
+	summary := PeriodSummary{Days: days}
+
+	from, to := r.dbBounds()
+	since, args := uploadsSince(from, deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	row := s.queryRow(ctx, `
 		SELECT
-			COUNT(*) as uploads,
+			COALESCE(SUM(uploads), 0) as uploads,
 			COALESCE(SUM(total_detections), 0) as total_det,
 			COALESCE(SUM(uptime_seconds), 0) as total_time,
-			COALESCE(AVG(detections_per_min), 0) as avg_dpm,
-			COALESCE(AVG(current_activity_pct), 0) as avg_act,
+			COALESCE(SUM(detections_per_min) * 1.0 / SUM(uploads), 0) as avg_dpm,
+			COALESCE(SUM(current_activity_pct) * 1.0 / SUM(uploads), 0) as avg_act,
 			COALESCE(MAX(peak_activity_pct), 0) as peak,
-			COALESCE(SUM(freq_0), 0), COALESCE(SUM(freq_1), 0),
-			COALESCE(SUM(freq_2), 0), COALESCE(SUM(freq_3), 0),
-			COALESCE(SUM(freq_4), 0), COALESCE(SUM(freq_5), 0),
-			COALESCE(SUM(freq_6), 0), COALESCE(SUM(freq_7), 0)
-		FROM uploads
-		WHERE timestamp > datetime('now', ? || ' days')
-	`, fmt.Sprintf("-%d", days))
+			COALESCE(counts_sum(freqs), ''),
+			COALESCE(counts_sum(rate_hist), ''),
+			COALESCE(counts_sum(activity_hist), '')
+		FROM (`+since+`) WHERE timestamp < ?
+	`, append(args, to)...)
 
+	var freqs, rateHist, activityHist string
 	err := row.Scan(&summary.TotalUploads, &summary.TotalDetections, &summary.TotalScanTime,
-		&summary.AvgDetPerMin, &summary.AvgActivity, &summary.PeakActivity,
-		&summary.FreqTotals[0], &summary.FreqTotals[1], &summary.FreqTotals[2], &summary.FreqTotals[3],
-		&summary.FreqTotals[4], &summary.FreqTotals[5], &summary.FreqTotals[6], &summary.FreqTotals[7])
+		&summary.AvgDetPerMin, &summary.AvgActivity, &summary.PeakActivity, &freqs, &rateHist, &activityHist)
 	if err != nil {
 		log.Printf("Error getting summary for %d days: %v", days, err)
 	}
+	summary.FreqTotals = planCounts(freqs)
+	if sets := s.colocatedSets(ctx, deviceIDs); len(sets) > 0 {
+		det, scan, f := s.colocatedCorrection(ctx, sets, from, to)
+		summary.TotalDetections += det
+		summary.TotalScanTime += scan
+		summary.FreqTotals = fitCounts(addCounts(summary.FreqTotals, f), len(frequencies()))
+	}
+	summary.DetPerMinStats = distribution(parseCounts(rateHist))
+	summary.ActivityStats = distribution(parseCounts(activityHist))
 
 	return summary
 }
 
-func (s *Store) getTotalUploads() int {
-	var count int
-	s.db.QueryRow(`SELECT COUNT(*) FROM uploads`).Scan(&count)
-	return count
+// getTotalUploads counts every upload ever received, including those rolled up
+func (s *Store) getTotalUploads(ctx context.Context, deviceIDs ...string) int {
+	var count, rolled int
+	cond, args := deviceFilter(deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	s.queryRow(ctx, `SELECT COUNT(*) FROM uploads WHERE `+cond, args...).Scan(&count)
+	s.queryRow(ctx, `SELECT COALESCE(SUM(uploads), 0) FROM upload_rollups WHERE `+cond, args...).Scan(&rolled)
+	return count + rolled
 }
 
 func handleHome(w http.ResponseWriter, r *http.Request) {
@@ -255,453 +869,382 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	rng, ok, err := parseDateRange(r.URL.Query(), store.locationFor(r.Context(), nil))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var custom *DateRange
+	if ok {
+		custom = &rng
+	}
+	renderDashboard(r.Context(), w, requestLang(w, r), requestCalm(w, r), "", nil, custom)
+}
+
+// renderDashboard writes the main dashboard. With a group it shows only that
+// group's detectors, and summaries and alerts cover just those devices. A calm
+// dashboard has no animation and doesn't refresh itself. A custom range adds
+// its summary ahead of the fixed periods.
+func renderDashboard(ctx context.Context, w http.ResponseWriter, l Lang, calm bool, group string, deviceIDs []string, custom *DateRange) {
+	members := make(map[string]bool)
+	for _, id := range deviceIDs {
+		members[id] = true
+	}
 
 	store.mu.RLock()
 	latest := make(map[string]Stats)
 	for k, v := range store.latest {
-		latest[k] = v
+		if group == "" || members[k] {
+			latest[k] = v
+		}
 	}
 	store.mu.RUnlock()
 
 	// Get summaries
 	summaries := []PeriodSummary{
-		store.getSummary(7),
-		store.getSummary(30),
-		store.getSummary(90),
-		store.getSummary(365),
+		store.getSummary(ctx, 7, deviceIDs...),
+		store.getSummary(ctx, 30, deviceIDs...),
+		store.getSummary(ctx, 90, deviceIDs...),
+		store.getSummary(ctx, 365, deviceIDs...),
+	}
+	summaries[0].Label = l.T("7 Days")
+	summaries[1].Label = l.T("30 Days")
+	summaries[2].Label = l.T("90 Days")
+	summaries[3].Label = l.T("1 Year")
+	if custom != nil {
+		s := store.getSummaryRange(ctx, *custom, deviceIDs...)
+		s.From, s.To = custom.From(), custom.To()
+		s.Label = s.From + " – " + s.To
+		if s.From == s.To {
+			s.Label = s.From
+		}
+		summaries = append([]PeriodSummary{s}, summaries...)
 	}
-	summaries[0].Label = "7 Days"
-	summaries[1].Label = "30 Days"
-	summaries[2].Label = "90 Days"
-	summaries[3].Label = "1 Year"
 
-	totalUploads := store.getTotalUploads()
+	totalUploads := store.getTotalUploads(ctx, deviceIDs...)
+
+	title, heading := l.T("LoRa Detector Dashboard"), "📡 "+l.T("LoRa Detector Dashboard")
+	if group != "" {
+		title = "LoRa Detector · " + group
+		heading = "📡 " + html.EscapeString(group)
+	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>LoRa Detector Dashboard</title>
-    <meta name="viewport" content="width=device-width, initial-scale=1">
-    <meta http-equiv="refresh" content="30">
-    <style>
-        * { box-sizing: border-box; }
-        body {
-            font-family: 'Segoe UI', system-ui, sans-serif;
-            background: linear-gradient(135deg, #1a1a2e 0%%, #16213e 100%%);
-            color: #e0e0e0;
-            padding: 20px;
-            margin: 0;
-            min-height: 100vh;
-        }
-        .container { max-width: 1000px; margin: 0 auto; }
-        h1 {
-            color: #00d4ff;
-            text-align: center;
-            font-size: 2em;
-            margin-bottom: 5px;
-            text-shadow: 0 0 20px rgba(0,212,255,0.5);
-        }
-        .subtitle {
-            text-align: center;
-            color: #888;
-            margin-bottom: 30px;
-        }
-        .stats-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(150px, 1fr));
-            gap: 15px;
-            margin-bottom: 30px;
-        }
-        .stat-box {
-            background: rgba(255,255,255,0.05);
-            border-radius: 12px;
-            padding: 20px;
-            text-align: center;
-            border: 1px solid rgba(255,255,255,0.1);
-        }
-        .stat-box .value {
-            font-size: 2.5em;
-            font-weight: bold;
-            color: #00d4ff;
-        }
-        .stat-box .label { color: #888; font-size: 0.9em; }
-        .stat-box.hot .value { color: #ff4444; animation: pulse 1s infinite; }
-        @keyframes pulse { 50%% { opacity: 0.7; } }
-
-        .card {
-            background: rgba(255,255,255,0.05);
-            border-radius: 16px;
-            padding: 25px;
-            margin-bottom: 25px;
-            border: 1px solid rgba(255,255,255,0.1);
-        }
-        .card h2 {
-            color: #fff;
-            margin: 0 0 20px 0;
-            font-size: 1.3em;
-            display: flex;
-            align-items: center;
-            gap: 10px;
-        }
-        .card h2 .icon { font-size: 1.5em; }
-
-        /* Frequency breakdown */
-        .freq-table { width: 100%%; }
-        .freq-row {
-            display: grid;
-            grid-template-columns: 80px 140px 1fr 80px;
-            gap: 15px;
-            padding: 12px 0;
-            border-bottom: 1px solid rgba(255,255,255,0.05);
-            align-items: center;
-        }
-        .freq-row:last-child { border-bottom: none; }
-        .freq-mhz {
-            font-family: 'Courier New', monospace;
-            font-weight: bold;
-            color: #fff;
-        }
-        .freq-label { color: #aaa; font-size: 0.9em; }
-        .freq-bar-container {
-            background: rgba(255,255,255,0.1);
-            border-radius: 4px;
-            height: 24px;
-            overflow: hidden;
-        }
-        .freq-bar {
-            height: 100%%;
-            border-radius: 4px;
-            display: flex;
-            align-items: center;
-            padding-left: 8px;
-            font-size: 0.8em;
-            font-weight: bold;
-            color: #000;
-            transition: width 0.5s ease;
-        }
-        .freq-count {
-            font-family: 'Courier New', monospace;
-            text-align: right;
-            color: #fff;
-        }
-
-        /* Category summary */
-        .category-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(280px, 1fr));
-            gap: 20px;
-        }
-        .category-card {
-            background: rgba(0,0,0,0.3);
-            border-radius: 12px;
-            padding: 20px;
-            border-left: 4px solid;
-        }
-        .category-card.sidewalk { border-left-color: #00BCD4; }
-        .category-card.meshtastic { border-left-color: #FF9800; }
-        .category-card.lorawan { border-left-color: #4CAF50; }
-        .category-card h3 {
-            margin: 0 0 10px 0;
-            display: flex;
-            align-items: center;
-            gap: 8px;
-        }
-        .category-card .count {
-            font-size: 2em;
-            font-weight: bold;
-            margin-bottom: 10px;
-        }
-        .category-card.sidewalk .count { color: #00BCD4; }
-        .category-card.meshtastic .count { color: #FF9800; }
-        .category-card.lorawan .count { color: #4CAF50; }
-        .category-card .devices {
-            font-size: 0.85em;
-            color: #999;
-            line-height: 1.6;
-        }
-
-        /* Device info */
-        .device-header {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            flex-wrap: wrap;
-            gap: 10px;
-        }
-        .device-id {
-            background: rgba(0,212,255,0.2);
-            padding: 5px 15px;
-            border-radius: 20px;
-            color: #00d4ff;
-            font-family: monospace;
-        }
-        .timestamp { color: #666; font-size: 0.85em; }
-
-        .no-data {
-            text-align: center;
-            padding: 60px 20px;
-            color: #666;
-        }
-        .no-data .icon { font-size: 4em; margin-bottom: 20px; }
-        .no-data p { margin: 10px 0; }
-
-        .legend {
-            display: flex;
-            gap: 20px;
-            flex-wrap: wrap;
-            justify-content: center;
-            margin-top: 20px;
-            padding-top: 20px;
-            border-top: 1px solid rgba(255,255,255,0.1);
-        }
-        .legend-item {
-            display: flex;
-            align-items: center;
-            gap: 6px;
-            font-size: 0.85em;
-            color: #888;
-        }
-        .legend-dot {
-            width: 12px;
-            height: 12px;
-            border-radius: 50%%;
-        }
-
-        /* Historical summaries */
-        .summary-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(220px, 1fr));
-            gap: 15px;
-        }
-        .summary-card {
-            background: rgba(0,0,0,0.3);
-            border-radius: 12px;
-            padding: 20px;
-            border-top: 3px solid #00d4ff;
-        }
-        .summary-card h3 {
-            margin: 0 0 15px 0;
-            color: #00d4ff;
-            font-size: 1.1em;
-        }
-        .summary-stat {
-            display: flex;
-            justify-content: space-between;
-            padding: 6px 0;
-            border-bottom: 1px solid rgba(255,255,255,0.05);
-        }
-        .summary-stat:last-child { border-bottom: none; }
-        .summary-stat .label { color: #888; }
-        .summary-stat .value { color: #fff; font-weight: bold; }
-        .summary-card .mini-freq {
-            display: flex;
-            gap: 4px;
-            margin-top: 10px;
-        }
-        .mini-freq .bar {
-            flex: 1;
-            height: 20px;
-            border-radius: 2px;
-            position: relative;
-        }
-        .mini-freq .bar span {
-            position: absolute;
-            bottom: -16px;
-            left: 50%%;
-            transform: translateX(-50%%);
-            font-size: 0.65em;
-            color: #666;
-        }
-
-        footer {
-            text-align: center;
-            color: #444;
-            margin-top: 40px;
-            padding-top: 20px;
-            border-top: 1px solid rgba(255,255,255,0.05);
-        }
-        .db-badge {
-            display: inline-block;
-            background: rgba(0,212,255,0.1);
-            padding: 3px 10px;
-            border-radius: 10px;
-            font-size: 0.8em;
-            color: #00d4ff;
-            margin-left: 10px;
-        }
-    </style>
-</head>
-<body>
-<div class="container">
-    <h1>📡 LoRa Detector Dashboard</h1>
-    <p class="subtitle">900 MHz ISM Band Activity Monitor <span class="db-badge">%d uploads stored</span></p>
-`, totalUploads)
+	head := refreshHead(true)
+	if calm {
+		head = calmCSS
+	}
+	writePageStart(w, title, head)
+	fmt.Fprintf(w, `    <a class="skip-link" href="#detectors">%s</a>
+    <h1>%s</h1>
+    <p class="subtitle">%s <span class="db-badge">%s</span>%s</p>
+    <nav class="nav"><a href="/map">🗺️ %s</a> <a href="/m">📱 %s</a>`, l.T("Skip to detectors"), heading, bandSubtitle(l),
+		l.Tf("%d uploads stored", totalUploads), backlogBadge(ctx, l)+quotaBadge(ctx, l, group), l.T("Map"), l.T("Mobile"))
+	if federationEnabled() {
+		fmt.Fprintf(w, ` <a href="/sites">🏠 %s</a>`, l.T("Sites"))
+	}
+	printURL := "/print?period=30d"
+	if group != "" {
+		printURL += "&group=" + url.QueryEscape(group)
+	}
+	fmt.Fprintf(w, ` <a href="%s">🖨️ %s</a>`, html.EscapeString(printURL), l.T("Print report"))
+	if group != "" {
+		fmt.Fprintf(w, ` <a href="/">%s</a>`, l.T("All detectors"))
+	}
+	for _, g := range store.getGroups(ctx) {
+		if g.Name != group {
+			fmt.Fprintf(w, ` <a href="/group/%s">%s</a>`, url.PathEscape(g.Name), html.EscapeString(g.Name))
+		}
+	}
+	fmt.Fprintf(w, `</nav>
+    <h2 class="sr-only" id="detectors" tabindex="-1">%s</h2>
+`, l.T("Detectors"))
+	if store.ephemeral {
+		fmt.Fprintf(w, `    <div class="ephemeral-banner">⚠️ %s</div>
+`, l.T("Ephemeral mode: data is kept in memory and will be lost when the server stops."))
+	}
 
 	if len(latest) == 0 {
 		fmt.Fprintf(w, `
     <div class="no-data">
         <div class="icon">📻</div>
-        <p><strong>No data received yet</strong></p>
-        <p>Double-click the PRG button on your LoRa detector to upload!</p>
+        <p><strong>%s</strong></p>
+        <p>%s</p>
         <p style="margin-top: 30px; font-size: 0.9em;">
-            The detector scans 8 frequencies across 903-923 MHz<br>
-            detecting Amazon Sidewalk, LoRaWAN, and Meshtastic signals.
+            %s<br>
+            %s
         </p>
     </div>
-`)
+`, l.T("No data received yet"), l.T("Double-click the PRG button on your LoRa detector to upload!"),
+			l.T("The detector scans 8 frequencies across 903-923 MHz"),
+			l.T("detecting Amazon Sidewalk, LoRaWAN, and Meshtastic signals."))
 	}
 
-	for deviceID, stats := range latest {
-		// Calculate category totals
-		sidewalkCount := 0
-		meshtasticCount := 0
-		lorawanCount := 0
-
-		if len(stats.FreqDetections) >= 8 {
-			sidewalkCount = stats.FreqDetections[5]
-			meshtasticCount = stats.FreqDetections[3]
-			lorawanCount = stats.FreqDetections[0] + stats.FreqDetections[1] +
-				stats.FreqDetections[2] + stats.FreqDetections[4] +
-				stats.FreqDetections[6] + stats.FreqDetections[7]
-		}
+	if len(latest) > 0 {
+		renderLiveMeter(w, l, group)
+	}
 
-		// Find max for bar scaling
-		maxCount := 1
-		for _, c := range stats.FreqDetections {
-			if c > maxCount {
-				maxCount = c
-			}
+	cadences := store.getCadences(ctx)
+	now := timeNow()
+	for _, deviceID := range byLastHeard(latest) {
+		stats := latest[deviceID]
+		baseline, hasBaseline := store.getBaseline(ctx, deviceID)
+		var deviation []float64
+		if hasBaseline {
+			deviation = baselineDeviation(stats, baseline)
 		}
 
 		hotClass := ""
 		if stats.CurrentActivity >= 10 {
 			hotClass = "hot"
 		}
+		// Silent past the point offline alerts fire: dimmed, with how long
+		cardClass, staleChip := "card", ""
+		if cadences.of(deviceID).Offline(stats.Timestamp, now) {
+			cardClass = "card stale"
+			staleChip = fmt.Sprintf(`<span class="stale-chip">%s</span>`, l.LastHeard(now.Sub(stats.Timestamp)))
+		}
 
 		// Overview stats
 		fmt.Fprintf(w, `
-    <div class="card">
-        <h2><span class="icon">📊</span> Latest Session</h2>
+    <div class="%s" data-device="%s" data-ts="%s">
+        <h2><span class="icon">📊</span> %s</h2>
         <div class="stats-grid">
             <div class="stat-box">
-                <div class="value">%d</div>
-                <div class="label">Total Detections</div>
+                <div class="value" data-field="total_detections">%d</div>
+                <div class="label">%s</div>
             </div>
             <div class="stat-box">
-                <div class="value">%d</div>
-                <div class="label">Per Minute</div>
+                <div class="value" data-field="detections_per_min">%d</div>
+                <div class="label">%s</div>
             </div>
             <div class="stat-box %s">
-                <div class="value">%d%%</div>
-                <div class="label">Activity</div>
+                <div class="value" data-field="current_activity_pct">%d%%</div>
+                <div class="label">%s</div>
             </div>
             <div class="stat-box">
-                <div class="value">%d%%</div>
-                <div class="label">Peak</div>
+                <div class="value" data-field="peak_activity_pct">%d%%</div>
+                <div class="label">%s</div>
             </div>
             <div class="stat-box">
-                <div class="value">%02d:%02d</div>
-                <div class="label">Scan Time</div>
+                <div class="value" data-field="uptime">%02d:%02d</div>
+                <div class="label">%s</div>
             </div>
         </div>
         <div class="device-header" style="margin-top: 15px;">
-            <span class="device-id">%s</span>
-            <span class="timestamp">%s</span>
+            <a class="device-id" href="/device?device=%s">%s</a>
+            <span class="timestamp"><span data-field="timestamp">%s</span>%s</span>
         </div>
-    </div>
-`, stats.TotalDetections, stats.DetectionsPerMin,
-			hotClass, stats.CurrentActivity, stats.PeakActivity,
-			stats.Uptime/3600, (stats.Uptime%3600)/60,
-			deviceID, stats.Timestamp.Format("Jan 2, 2006 at 3:04 PM MST"))
+`, cardClass, html.EscapeString(deviceID), stats.Timestamp.Format(time.RFC3339Nano), l.T("Latest Session"), stats.TotalDetections, l.T("Total Detections"), stats.DetectionsPerMin, l.T("Per Minute"),
+			hotClass, stats.CurrentActivity, l.T("Activity"), stats.PeakActivity, l.T("Peak"),
+			stats.Uptime/3600, (stats.Uptime%3600)/60, l.T("Scan Time"),
+			url.QueryEscape(deviceID), html.EscapeString(deviceID), l.DateTime(stats.Timestamp), staleChip)
+
+		if bat, ok := store.getBatteryStatus(ctx, deviceID); ok && bat.Level != batteryOK {
+			msg := l.Tf("Battery low: %.2f V (low %.2f V, critical %.2f V)", bat.Voltage, bat.LowV, bat.CriticalV)
+			if bat.Level == batteryCritical {
+				msg = l.Tf("Battery critical: %.2f V (low %.2f V, critical %.2f V)", bat.Voltage, bat.LowV, bat.CriticalV)
+			}
+			if bat.HoursToCritical != nil {
+				msg += " · " + l.Tf("critical %s", l.Hours(*bat.HoursToCritical))
+			}
+			fmt.Fprintf(w, `        <p class="battery-warn battery-%s">🔋 %s</p>
+`, bat.Level, msg)
+		}
+
+		if stats.Bearing != nil {
+			fmt.Fprintf(w, `        <p class="baseline-note">%s · <a href="/bearing?device=%s" style="color: #00d4ff;">%s</a></p>
+`, l.Tf("Antenna heading %.0f°", *stats.Bearing), url.QueryEscape(deviceID), l.T("detections by bearing"))
+		}
+
+		// Hourly trend, with the rollups reaching back past raw retention
+		trend := store.getHourlyDetections(ctx, deviceID, trendHours)
+		trendTotal, trendPeak := 0.0, 0.0
+		for _, v := range trend {
+			if !math.IsNaN(v) {
+				trendTotal, trendPeak = trendTotal+v, max(trendPeak, v)
+			}
+		}
+		fmt.Fprintf(w, `        <div class="trend">
+            <div class="chart-label">%s</div>
+            `, l.T("Detections, last 24 hours"))
+		renderSparkline(w, trend, l.Tf("%.0f detections in the last 24 hours, at most %.0f in one hour", trendTotal, trendPeak), 600, 40)
+		trendEnd := timeNow().Truncate(time.Hour)
+		trendStart := trendEnd.Add(-(trendHours - 1) * time.Hour)
+		renderAnnotationStrip(w, deviceNights(store.getDevice(ctx, deviceID), trendStart, trendEnd), trendStart, trendEnd, 600)
+		fmt.Fprintf(w, `
+        </div>
+`)
+		if weatherProvider() != "" {
+			fmt.Fprintf(w, `        <p class="baseline-note"><a href="/weather?device=%s" style="color: #00d4ff;">🌦️ %s</a></p>
+`, url.QueryEscape(deviceID), l.T("detections and weather"))
+		}
+
+		// Minute-resolution chart for detectors that report events
+		if store.hasMinuteData(ctx, deviceID, 60) {
+			_, series := store.getMinuteSeries(ctx, deviceID, 60)
+			fmt.Fprintf(w, `        <div class="minute-chart">
+            <div class="chart-label">%s</div>
+            `, l.T("Last 60 minutes"))
+			renderStackedBars(w, series, 600, 80)
+			fmt.Fprintf(w, `
+        </div>
+`)
+		}
+		fmt.Fprintf(w, `    </div>
+`)
 
 		// Category breakdown
 		fmt.Fprintf(w, `
     <div class="card">
-        <h2><span class="icon">🔍</span> What You Detected</h2>
+        <h2><span class="icon">🔍</span> %s</h2>
         <div class="category-grid">
-            <div class="category-card sidewalk">
-                <h3>🏠 Amazon Sidewalk</h3>
-                <div class="count">%d</div>
-                <div class="devices">
-                    Ring doorbells & cameras<br>
-                    Echo (4th gen+) speakers<br>
-                    Tile trackers<br>
-                    Level smart locks
-                </div>
-            </div>
-            <div class="category-card meshtastic">
-                <h3>🥾 Meshtastic</h3>
-                <div class="count">%d</div>
-                <div class="devices">
-                    Off-grid mesh communicators<br>
-                    Hiker/outdoor devices<br>
-                    Emergency comms<br>
-                    DIY LoRa nodes
-                </div>
-            </div>
-            <div class="category-card lorawan">
-                <h3>🏭 LoRaWAN / IoT</h3>
-                <div class="count">%d</div>
+`, l.T("What You Detected"))
+		for _, c := range planCategoryInfo() {
+			examples := make([]string, len(c.Examples))
+			for i, ex := range c.Examples {
+				examples[i] = html.EscapeString(l.T(ex))
+			}
+			class, modulation := "category-card", ""
+			if c.Modulation != "" {
+				class += " non-lora"
+				modulation = fmt.Sprintf(` <span class="modulation">%s</span>`, html.EscapeString(c.Modulation))
+			}
+			fmt.Fprintf(w, `            <div class="%s" style="border-left-color: %s;">
+                <h3><a href="/category/%s">%s %s</a>%s</h3>
+                <div class="count" style="color: %s;">%d</div>
                 <div class="devices">
-                    Smart utility meters<br>
-                    Parking sensors<br>
-                    Agricultural monitors<br>
-                    Industrial sensors
+                    %s
                 </div>
             </div>
-        </div>
-    </div>
-`, sidewalkCount, meshtasticCount, lorawanCount)
+`, class, c.Color, url.PathEscape(c.Name), c.Icon, html.EscapeString(c.Title), modulation, c.Color,
+				categoryCount(stats.FreqDetections, c.Name), strings.Join(examples, "<br>\n                    "))
+		}
+		fmt.Fprintf(w, `        </div>
+`)
+		if nearby := formatEstimates(l, store.estimateTransmitters(ctx, deviceID, timeNow().Add(-24*time.Hour))); nearby != "" {
+			fmt.Fprintf(w, `        <p class="nearby">%s <span class="timestamp">%s</span></p>
+`, l.Tf("%s nearby", nearby), l.T("(estimated from the last 24h of events)"))
+		}
+		if comparisons, avg, ok := store.compareWithRegion(ctx, store.getDevice(ctx, deviceID)); ok && len(comparisons) > 0 {
+			fmt.Fprintf(w, `        <p class="nearby">🌎 %s <span class="timestamp">%s</span></p>
+`, html.EscapeString(formatComparisons(l, comparisons)),
+				l.Tf("(last %dh, against %d community stations within %.0f km)", avg.Hours, avg.Stations, avg.RadiusKm))
+		}
+		fmt.Fprintf(w, `    </div>
+`)
 
 		// Frequency breakdown table
 		fmt.Fprintf(w, `
     <div class="card">
-        <h2><span class="icon">📶</span> Frequency Breakdown</h2>
-        <div class="freq-table">
-`)
-		for i, freq := range frequencies {
-			count := 0
+        <h2><span class="icon">📶</span> %s</h2>
+`, l.T("Frequency Breakdown"))
+		rows := make([]FreqRow, len(frequencies()))
+		for i, freq := range frequencies() {
+			rows[i] = FreqRow{Freq: freq, Href: "/frequency/" + freq.MHz, Devices: l.T(freq.Devices)}
 			if i < len(stats.FreqDetections) {
-				count = stats.FreqDetections[i]
+				rows[i].Count = stats.FreqDetections[i]
 			}
-			barWidth := 0
-			if maxCount > 0 {
-				barWidth = (count * 100) / maxCount
+			if hasBaseline && baseline.Rates[i] > 0 {
+				devClass := "dev-normal"
+				if deviation[i] >= baselineAlertPct() {
+					devClass = "dev-high"
+				}
+				rows[i].Note = fmt.Sprintf(`<span class="baseline-dev %s">%+.0f%%</span>`, devClass, deviation[i])
 			}
-			if barWidth < 2 && count > 0 {
-				barWidth = 2
+		}
+		renderFreqTable(w, l, l.Tf("Detections per frequency from %s", deviceID), rows)
+		fmt.Fprintf(w, `        <div class="legend">
+`)
+		for _, c := range planCategoryInfo() {
+			fmt.Fprintf(w, `            <div class="legend-item"><div class="legend-dot" style="background: %s;"></div> %s</div>
+`, c.Color, html.EscapeString(c.Title))
+		}
+		fmt.Fprintf(w, `        </div>
+`)
+		if hasBaseline {
+			fmt.Fprintf(w, `        <p class="baseline-note">%s</p>
+`, l.Tf("Percentages show deviation from baseline calibrated %s", l.DateTime(baseline.ComputedAt)))
+		} else if cal, ok := store.getCalibration(ctx, deviceID); ok && !cal.Completed {
+			fmt.Fprintf(w, `        <p class="baseline-note">%s</p>
+`, l.Tf("Calibrating baseline until %s", l.DateTime(cal.EndsAt)))
+		}
+		fmt.Fprintf(w, `    </div>
+`)
+
+		// Spreading factor mix, from detectors that report SF/BW with their events
+		if spreading := store.getSpreadingBreakdown(ctx, deviceID, timeNow().Add(-24*time.Hour)); len(spreading) > 0 {
+			fmt.Fprintf(w, `
+    <div class="card">
+        <h2><span class="icon">📊</span> %s</h2>
+`, l.T("Spreading Factor Breakdown (24h)"))
+			renderSpreadingTable(w, l, spreading)
+			fmt.Fprintf(w, `    </div>
+`)
+
+			if airtime := store.getAirtime(ctx, deviceID, 24); len(airtime.Categories) > 0 {
+				fmt.Fprintf(w, `
+    <div class="card">
+        <h2><span class="icon">⏳</span> %s</h2>
+`, l.T("Airtime and Duty Cycle (24h)"))
+				renderAirtimeCard(w, l, airtime)
+				fmt.Fprintf(w, `        <p class="baseline-note"><a href="/print/regulatory?device=%s&amp;period=30d" style="color: #00d4ff;">📄 %s</a></p>
+    </div>
+`, url.QueryEscape(deviceID), l.T("Occupancy report for an interference complaint"))
 			}
+		}
 
+		// Periodic transmitters inferred from the event stream
+		if periodic := store.getPeriodicTransmitters(ctx, deviceID, timeNow().Add(-24*time.Hour)); len(periodic) > 0 {
 			fmt.Fprintf(w, `
-            <div class="freq-row">
-                <div class="freq-mhz">%s</div>
-                <div class="freq-label">%s</div>
-                <div class="freq-bar-container">
-                    <div class="freq-bar" style="width: %d%%; background: %s;">%s</div>
-                </div>
-                <div class="freq-count">%d</div>
-            </div>
-`, freq.MHz, freq.Label, barWidth, freq.Color, freq.Devices, count)
+    <div class="card">
+        <h2><span class="icon">⏱️</span> %s</h2>
+`, l.T("Periodic Transmitters (24h)"))
+			for _, p := range periodic {
+				fmt.Fprintf(w, `        <div class="periodic-row">
+            <span class="freq-mhz">%s</span>
+            <span class="freq-label">%s</span>
+            <span>%s</span>
+            <span class="timestamp">%s · %s – %s</span>
+        </div>
+`, p.MHz, p.Label, l.Tf("every %s", formatInterval(p.IntervalSec)), l.Tf("%d hits", p.Detections),
+					l.ShortDateTime(p.FirstSeen), l.ShortDateTime(p.LastSeen))
+			}
+			fmt.Fprintf(w, `    </div>
+`)
 		}
+	}
 
+	// Recent alerts
+	if alerts := store.getRecentAlerts(ctx, 10, deviceIDs...); len(alerts) > 0 {
 		fmt.Fprintf(w, `
-        </div>
-        <div class="legend">
-            <div class="legend-item"><div class="legend-dot" style="background: #00BCD4;"></div> Amazon Sidewalk</div>
-            <div class="legend-item"><div class="legend-dot" style="background: #FF9800;"></div> Meshtastic</div>
-            <div class="legend-item"><div class="legend-dot" style="background: #4CAF50;"></div> LoRaWAN</div>
-        </div>
-    </div>
+    <div class="card">
+        <h2><span class="icon">🚨</span> %s</h2>
+`, l.T("Recent Alerts"))
+		for _, a := range alerts {
+			fmt.Fprintf(w, `        <div class="alert-row"><span class="device-id">%s</span> %s <span class="timestamp">%s</span></div>
+`, html.EscapeString(a.DeviceID), html.EscapeString(a.Message), l.ShortDateTime(a.Timestamp))
+		}
+		fmt.Fprintf(w, `    </div>
 `)
 	}
 
 	// Historical Summaries
 	fmt.Fprintf(w, `
     <div class="card">
-        <h2><span class="icon">📈</span> Historical Summary</h2>
-        <div class="summary-grid">
+        <h2><span class="icon">📈</span> %s</h2>
+        <p class="baseline-note">%s</p>
+`, l.T("Historical Summary"), l.Tf("Calendar days in %s", html.EscapeString(locationName(store.locationFor(ctx, deviceIDs)))))
+	if note := colocatedNote(ctx, l, deviceIDs); note != "" {
+		fmt.Fprintf(w, `        <p class="baseline-note">%s</p>
+`, note)
+	}
+	home := "/"
+	if group != "" {
+		home = "/group/" + url.PathEscape(group)
+	}
+	renderRangePicker(w, l, home, nil, custom, home)
+	fmt.Fprintf(w, `        <div class="summary-grid">
 `)
 
 	for _, s := range summaries {
@@ -720,31 +1263,31 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             <div class="summary-card">
                 <h3>%s</h3>
                 <div class="summary-stat">
-                    <span class="label">Uploads</span>
+                    <span class="label">%s</span>
                     <span class="value">%d</span>
                 </div>
                 <div class="summary-stat">
-                    <span class="label">Detections</span>
+                    <span class="label">%s</span>
                     <span class="value">%d</span>
                 </div>
                 <div class="summary-stat">
-                    <span class="label">Scan Time</span>
+                    <span class="label">%s</span>
                     <span class="value">%dh %dm</span>
                 </div>
                 <div class="summary-stat">
-                    <span class="label">Avg Det/min</span>
+                    <span class="label">%s</span>
                     <span class="value">%.1f</span>
                 </div>
                 <div class="summary-stat">
-                    <span class="label">Peak Activity</span>
+                    <span class="label">%s</span>
                     <span class="value">%d%%</span>
                 </div>
                 <div class="mini-freq">
-`, s.Label, s.TotalUploads, s.TotalDetections, scanHours, scanMins,
-			s.AvgDetPerMin, s.PeakActivity)
+`, s.Label, l.T("Uploads"), s.TotalUploads, l.T("Detections"), s.TotalDetections, l.T("Scan Time"), scanHours, scanMins,
+			l.T("Avg Det/min"), s.AvgDetPerMin, l.T("Peak Activity"), s.PeakActivity)
 
 		// Mini frequency bars
-		for i, freq := range frequencies {
+		for i, freq := range frequencies() {
 			height := 0
 			if maxFreq > 0 && i < len(s.FreqTotals) {
 				height = (s.FreqTotals[i] * 100) / maxFreq
@@ -766,13 +1309,32 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
     </div>
 `)
 
+	note, motionToggle, motionLabel := refreshNote(l), "reduce", l.T("Turn off animation")
+	if calm {
+		note, motionToggle, motionLabel = l.T("Animation and auto-refresh off"), "full", l.T("Turn animation back on")
+	}
 	fmt.Fprintf(w, `
     <footer>
-        Auto-refreshes every 30 seconds · Data retained for 1 year · Built with Claude Code
+        %s · %s · Built with Claude Code
+        <div class="lang-menu">`, note, l.T("Older data kept as hourly and daily totals"))
+	for i, lang := range languages {
+		if i > 0 {
+			fmt.Fprintf(w, ` · `)
+		}
+		if lang.Code == l {
+			fmt.Fprintf(w, `<strong>%s</strong>`, lang.Name)
+		} else {
+			fmt.Fprintf(w, `<a href="?lang=%s">%s</a>`, lang.Code, lang.Name)
+		}
+	}
+	fmt.Fprintf(w, `</div>
+        <div class="lang-menu"><a href="?motion=%s">%s</a></div>
     </footer>
-</div>
-</body>
-</html>`)
+`, motionToggle, motionLabel)
+	if !calm {
+		renderPartialRefresh(w, l, group == "")
+	}
+	writePageEnd(w)
 }
 
 func handleUpload(w http.ResponseWriter, r *http.Request) {
@@ -781,65 +1343,246 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body, err := readUploadBody(w, r)
+	if err != nil {
+		noteAbuse(r, "oversized body")
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	var stats Stats
-	if err := json.NewDecoder(r.Body).Decode(&stats); err != nil {
+	if err := json.Unmarshal(body, &stats); err != nil {
 		log.Printf("Error decoding JSON: %v", err)
+		noteAbuse(r, "invalid JSON")
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	stats.Timestamp = time.Now()
+	ctx := r.Context()
+	stats.Timestamp = timeNow()
 	stats.UploaderIP = r.RemoteAddr
 
 	if stats.DeviceID == "" {
 		stats.DeviceID = "unknown"
 	}
+	if err := validateStats(&stats); err != nil {
+		noteAbuse(r, err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := verifyUpload(ctx, r, stats.DeviceID, body); err != nil {
+		noteAbuse(r, err.Error())
+		rejectUnverifiedUpload(ctx, w, stats.DeviceID, err)
+		return
+	}
+	if err := checkClockSkew(stats.DeviceTime, stats.Timestamp); err != nil {
+		rejectSkewedUpload(ctx, w, stats.DeviceID, err, stats.Timestamp)
+		return
+	}
+	if natsIngest != nil {
+		// Stored later by a consumer, which can't answer the device's last_ack
+		stats.LastAck = nil
+		err := natsIngest.publish(stats)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "queued",
+				"message": fmt.Sprintf("Received %d detections", stats.TotalDetections),
+			})
+			return
+		}
+		log.Printf("Error publishing upload from %s to NATS: %v", stats.DeviceID, err)
+		if store.readOnly {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Upload queue unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	missed, err := ingestStats(ctx, &stats)
+	if err != nil {
+		rejectFailedSave(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]interface{}{
+		"status":  "ok",
+		"message": fmt.Sprintf("Received %d detections", stats.TotalDetections),
+	}
+	if stats.AckID > 0 {
+		resp["ack_id"] = stats.AckID
+	}
+	if missed > 0 {
+		resp["missed_acks"] = missed
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ingestStats runs an accepted upload through storage, health, calibration and
+// the in-memory cache; every transport (HTTP, LoRaWAN, UDP) ends up here.
+// It assigns the ack ID and returns how many acks the device missed. An error
+// means the upload wasn't stored and the device should resend it (see
+// rejectFailedSave).
+func ingestStats(ctx context.Context, stats *Stats) (int64, error) {
+	stats.UploaderIP = anonymizeIP(stats.UploaderIP)
+	raw := *stats // as sent, before the channel map and bearing are applied
+	if err := quotas.admit(ctx, stats.DeviceID); err != nil {
+		return 0, err
+	}
+	if store.getDevice(ctx, stats.DeviceID).Quarantined != nil {
+		if err := store.holdUpload(ctx, stats.DeviceID, heldUpload, stats.Timestamp, raw); err != nil {
+			return 0, err
+		}
+		quotas.charge(ctx, stats.DeviceID, 1)
+		return 0, nil
+	}
+	stats.FreqDetections = store.channelMap(ctx, stats.DeviceID).counts(stats.FreqDetections)
+	if stats.Bearing != nil {
+		b := normalizeBearing(*stats.Bearing)
+		stats.Bearing = &b
+	}
+
+	// Save to database, sequenced so the device can spot lost responses
+	ack := &ackRequest{lastAck: stats.LastAck}
+	if err := store.saveOrReject(ctx, *stats, ack); err != nil {
+		return 0, err
+	}
+	quotas.charge(ctx, stats.DeviceID, 1)
+	stats.AckID = ack.ack
+	missed := ack.missed
+	if missed > 0 {
+		store.raiseAlert(ctx, stats.DeviceID, "upload_gap", fmt.Sprintf("%d upload response(s) didn't reach the device", missed))
+	}
+	if rawLog != nil {
+		raw.AckID = stats.AckID
+		rawLog.append(raw)
+	}
+	nudgeRelay()
+
+	if stats.Reported() {
+		if err := store.saveHealth(ctx, stats.DeviceID, stats.Timestamp, stats.DeviceHealth); err != nil {
+			log.Printf("Error saving device health: %v", err)
+		}
+		if stats.BatteryV != nil {
+			store.checkBattery(ctx, stats.DeviceID)
+		}
+	}
 
-	// Save to database
-	if err := store.saveUpload(stats); err != nil {
-		log.Printf("Error saving to database: %v", err)
+	// GPS-equipped detectors report their own position
+	if stats.Latitude != nil && stats.Longitude != nil && validLatLon(*stats.Latitude, *stats.Longitude) {
+		if err := store.setDeviceLocation(ctx, stats.DeviceID, *stats.Latitude, *stats.Longitude); err != nil {
+			log.Printf("Error saving device location: %v", err)
+		}
 	}
 
+	// Complete calibration windows and check against baseline
+	store.processCalibration(ctx, *stats)
+
 	// Update in-memory cache
+	var prev *Stats
 	store.mu.Lock()
-	store.latest[stats.DeviceID] = stats
+	if p, ok := store.latest[stats.DeviceID]; ok {
+		prev = &p
+	}
+	store.latest[stats.DeviceID] = *stats
 	store.mu.Unlock()
+	fresh := newDetections(prev, *stats)
+	mqtt.detected(stats.DeviceID, fresh, stats.Timestamp)
+	syslogOut.upload(*stats, fresh)
+	live.detected(stats.DeviceID, fresh, stats.Timestamp)
+	store.fireWebhooks(ctx, WebhookEvent{
+		Event: webhookUpload, DeviceID: stats.DeviceID, Time: stats.Timestamp,
+		TotalDetections: stats.TotalDetections, DetectionsPerMin: stats.DetectionsPerMin,
+		Activity: stats.CurrentActivity, FreqDetections: stats.FreqDetections,
+	})
 
 	log.Printf("Upload from %s: %d total detections, %d/min, %d%% activity",
 		stats.DeviceID, stats.TotalDetections, stats.DetectionsPerMin, stats.CurrentActivity)
-	if len(stats.FreqDetections) >= 8 {
-		log.Printf("  Frequencies: 903.9=%d, 906.3=%d, 909.1=%d, 911.9=%d, 914.9=%d, 917.5=%d, 920.1=%d, 922.9=%d",
-			stats.FreqDetections[0], stats.FreqDetections[1], stats.FreqDetections[2], stats.FreqDetections[3],
-			stats.FreqDetections[4], stats.FreqDetections[5], stats.FreqDetections[6], stats.FreqDetections[7])
+	var heard []string
+	for i, f := range frequencies() {
+		if f.Scanned() && i < len(stats.FreqDetections) {
+			heard = append(heard, fmt.Sprintf("%s=%d", f.MHz, stats.FreqDetections[i]))
+		}
+	}
+	if len(heard) > 0 {
+		log.Printf("  Frequencies: %s", strings.Join(heard, ", "))
+	}
+	if missed > 0 {
+		log.Printf("  %s missed %d ack(s) before ack %d", stats.DeviceID, missed, stats.AckID)
 	}
+	return missed, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "ok",
-		"message": fmt.Sprintf("Received %d detections", stats.TotalDetections),
-	})
+// newDetections is what an upload heard since the device's previous one, per
+// frequency. Uploads carry counters since boot, so a lower uptime means a reboot.
+func newDetections(prev *Stats, stats Stats) []int {
+	counts := make([]int, len(stats.FreqDetections))
+	for i, c := range stats.FreqDetections {
+		counts[i] = c
+		if prev != nil && stats.Uptime >= prev.Uptime && i < len(prev.FreqDetections) {
+			counts[i] = c - prev.FreqDetections[i]
+		}
+	}
+	return counts
+}
+
+// rejectFailedSave asks the device to resend an upload that couldn't be saved:
+// 429 with Retry-After when it's over a quota, 503 with Retry-After while the
+// database is unavailable, 500 otherwise
+func rejectFailedSave(w http.ResponseWriter, err error) {
+	var quota *quotaError
+	if errors.As(err, &quota) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(quota.retry.Seconds())))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, errSaveUnavailable) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// latestStats copies the in-memory cache, so callers can query the database
+// without holding the lock that every upload needs
+func (s *Store) latestStats() map[string]Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := make(map[string]Stats, len(s.latest))
+	for k, v := range s.latest {
+		latest[k] = v
+	}
+	return latest
 }
 
 func handleStats(w http.ResponseWriter, r *http.Request) {
-	store.mu.RLock()
-	defer store.mu.RUnlock()
+	ctx := r.Context()
+	latest := store.latestStats()
 
 	w.Header().Set("Content-Type", "text/plain")
 	fmt.Fprintf(w, "LoRa Detector Stats\n")
 	fmt.Fprintf(w, "==================\n\n")
-	fmt.Fprintf(w, "Total uploads in database: %d\n\n", store.getTotalUploads())
+	fmt.Fprintf(w, "Total uploads in database: %d\n\n", store.getTotalUploads(ctx))
 
-	for deviceID, stats := range store.latest {
+	ids := make([]string, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, deviceID := range ids {
+		stats := latest[deviceID]
 		fmt.Fprintf(w, "Device: %s\n", deviceID)
 		fmt.Fprintf(w, "  Uptime: %02d:%02d:%02d\n", stats.Uptime/3600, (stats.Uptime%3600)/60, stats.Uptime%60)
 		fmt.Fprintf(w, "  Total Detections: %d\n", stats.TotalDetections)
 		fmt.Fprintf(w, "  Det/min: %d\n", stats.DetectionsPerMin)
 		fmt.Fprintf(w, "  Activity: %d%% (peak: %d%%)\n", stats.CurrentActivity, stats.PeakActivity)
 
-		if len(stats.FreqDetections) >= 8 {
+		if len(stats.FreqDetections) > 0 {
 			fmt.Fprintf(w, "\n  Frequency Breakdown:\n")
-			for i, freq := range frequencies {
-				fmt.Fprintf(w, "    %s MHz %-18s: %d\n", freq.MHz, "("+freq.Label+")", stats.FreqDetections[i])
+			for i, freq := range frequencies() {
+				if freq.Scanned() && i < len(stats.FreqDetections) {
+					fmt.Fprintf(w, "    %s MHz %-18s: %d\n", freq.MHz, "("+freq.Label+")", stats.FreqDetections[i])
+				}
 			}
 		}
 
@@ -848,23 +1591,45 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAPIStats(w http.ResponseWriter, r *http.Request) {
-	store.mu.RLock()
-	defer store.mu.RUnlock()
-
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"total_uploads": store.getTotalUploads(),
-		"devices":       store.latest,
-		"frequencies":   frequencies,
+		"total_uploads": store.getTotalUploads(ctx),
+		"devices":       store.latestStats(),
+		"frequencies":   frequencies(),
 	})
 }
 
+// handleAPIHistory returns period summaries, optionally for one device or
+// group: GET /api/history?device=ID or ?group=NAME. from and to add a "range"
+// summary, of whole days or between two times (see parseTimeRange).
 func handleAPIHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var deviceIDs []string
+	if device := r.URL.Query().Get("device"); device != "" {
+		deviceIDs = []string{device}
+	} else if group := r.URL.Query().Get("group"); group != "" {
+		if deviceIDs = store.getGroupDevices(ctx, group); deviceIDs == nil {
+			http.Error(w, "Unknown group", http.StatusNotFound)
+			return
+		}
+	}
+
 	summaries := map[string]PeriodSummary{
-		"7days":   store.getSummary(7),
-		"30days":  store.getSummary(30),
-		"90days":  store.getSummary(90),
-		"365days": store.getSummary(365),
+		"7days":   store.getSummary(ctx, 7, deviceIDs...),
+		"30days":  store.getSummary(ctx, 30, deviceIDs...),
+		"90days":  store.getSummary(ctx, 90, deviceIDs...),
+		"365days": store.getSummary(ctx, 365, deviceIDs...),
+	}
+	rng, ok, err := parseTimeRange(r.URL.Query(), store.locationFor(ctx, deviceIDs))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ok {
+		s := store.getSummaryRange(ctx, rng, deviceIDs...)
+		s.From, s.To = rng.From(), rng.To()
+		summaries["range"] = s
 	}
 
 	w.Header().Set("Content-Type", "application/json")
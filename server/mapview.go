@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// handleMap renders detector locations and multilaterated transmitters on a Leaflet map
+func handleMap(w http.ResponseWriter, r *http.Request) {
+	colors := make([]string, len(frequencies()))
+	for i, f := range frequencies() {
+		colors[i] = f.Color
+	}
+	colorJSON, _ := json.Marshal(colors)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "LoRa Detector Map", `    <link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+    <script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+    <style>
+        #map { height: 600px; border-radius: 12px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🗺️ Detector Map</h1>
+    <p class="subtitle">Detector locations and estimated transmitter positions (95%% confidence ellipses, last 24h)</p>
+    <div class="card"><div id="map"></div></div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+    <script>
+    const colors = %s;
+    const map = L.map('map').setView([39.8, -98.6], 4);
+    L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+        attribution: '&copy; OpenStreetMap contributors', maxZoom: 19
+    }).addTo(map);
+
+    Promise.all([fetch('/api/devices').then(r => r.json()), fetch('/api/fixes').then(r => r.json())])
+    .then(([devices, fixes]) => {
+        const bounds = [];
+        devices.filter(d => d.latitude !== undefined).forEach(d => {
+            L.marker([d.latitude, d.longitude]).addTo(map).bindPopup('📡 ' + d.device_id);
+            bounds.push([d.latitude, d.longitude]);
+        });
+        fixes.forEach(f => {
+            const c = colors[f.freq_index];
+            L.polygon(f.ellipse, {color: c, weight: 1, fillOpacity: 0.1}).addTo(map);
+            L.circleMarker([f.latitude, f.longitude], {radius: 4, color: c, fillOpacity: 0.9}).addTo(map)
+                .bindPopup(f.mhz + ' MHz at ' + new Date(f.time).toLocaleString() +
+                    '<br>Heard by ' + f.detectors.join(', ') +
+                    '<br>±' + Math.round(f.semi_major_m) + ' m × ' + Math.round(f.semi_minor_m) + ' m');
+        });
+        if (bounds.length) map.fitBounds(bounds, {padding: [40, 40], maxZoom: 15});
+    });
+    </script>
+`, colorJSON)
+	writePageEnd(w)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// sparklinePoints is how many recent samples the mobile sparkline shows
+const sparklinePoints = 60
+
+// mobileCSS is deliberately small; the mobile view is meant to load fast over cellular
+const mobileCSS = `        body { font-family: system-ui, sans-serif; background: #1a1a2e; color: #e0e0e0; margin: 0; padding: 12px; }
+        h1 { font-size: 1.2em; color: #00d4ff; margin: 0 0 12px 0; }
+        .ephemeral-banner { background: rgba(255,152,0,0.15); color: #ffb74d; border-radius: 8px; padding: 8px; font-size: 0.85em; }
+        .dev { background: rgba(255,255,255,0.05); border-radius: 12px; padding: 12px; margin-bottom: 12px; }
+        .dev-head { display: flex; justify-content: space-between; font-size: 0.85em; color: #888; }
+        .dev-head a { color: #00d4ff; font-family: monospace; text-decoration: none; }
+        .dev.stale { opacity: 0.55; }
+        .dev.stale .dev-head span { color: #ffb74d; }
+        .now { display: flex; gap: 16px; margin: 8px 0; }
+        .now b { font-size: 1.8em; display: block; }
+        .now span { font-size: 0.75em; color: #888; }
+        .hot b { color: #ff4444; }
+        .cats { display: flex; gap: 8px; }
+        .cat { flex: 1; text-align: center; border-radius: 8px; padding: 6px 0; background: rgba(0,0,0,0.2); }
+        .cat b { display: block; font-size: 1.3em; }
+        .cat span { font-size: 0.7em; color: #aaa; }
+        svg { display: block; width: 100%; margin-top: 8px; }
+        footer { text-align: center; font-size: 0.8em; margin-top: 16px; }
+        footer a { color: #00d4ff; }
+`
+
+// getRecentRates returns detections per minute for the sparkline: minute bins
+// when the detector reports events, otherwise the rate from recent uploads.
+// Oldest first; NaN marks a gap where the detector was offline.
+func (s *Store) getRecentRates(ctx context.Context, deviceID string) []float64 {
+	if s.hasMinuteData(ctx, deviceID, sparklinePoints) {
+		_, series := s.getMinuteSeries(ctx, deviceID, sparklinePoints)
+		rates := make([]float64, len(series))
+		for i, bucket := range series {
+			if bucket == nil {
+				rates[i] = math.NaN()
+				continue
+			}
+			for _, c := range bucket {
+				rates[i] += float64(c)
+			}
+		}
+		return rates
+	}
+
+	gapAfter := s.getDevice(ctx, deviceID).Cadence().OfflineAfter
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT timestamp, detections_per_min FROM uploads WHERE device_id = ? ORDER BY timestamp DESC, id DESC LIMIT ?
+	`, deviceID, sparklinePoints)
+	if err != nil {
+		log.Printf("Error loading recent rates: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var rates []float64
+	var newer time.Time
+	for rows.Next() {
+		var ts string
+		var r int
+		if err := rows.Scan(&ts, &r); err != nil {
+			continue
+		}
+		t := parseDBTime(ts)
+		if !newer.IsZero() && newer.Sub(t) > gapAfter {
+			rates = append(rates, math.NaN())
+		}
+		rates = append(rates, float64(r))
+		newer = t
+	}
+	slices.Reverse(rates)
+	return rates
+}
+
+// renderSparkline writes a minimal SVG line with no axes or labels, broken
+// wherever a value is NaN; label describes it to screen readers
+func renderSparkline(w io.Writer, values []float64, label string, width, height int) {
+	if len(values) < 2 {
+		return
+	}
+	maxV := 1.0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			maxV = max(maxV, v)
+		}
+	}
+	fmt.Fprintf(w, `<svg viewBox="0 0 %d %d" preserveAspectRatio="none" height="%d" role="img" aria-label="%s"><path fill="none" stroke="#00d4ff" stroke-width="1.5" vector-effect="non-scaling-stroke" d="`, width, height, height, html.EscapeString(label))
+	pen := "M"
+	for i, v := range values {
+		if math.IsNaN(v) {
+			pen = "M"
+			continue
+		}
+		fmt.Fprintf(w, "%s%.1f,%.1f ", pen, float64(i)*float64(width)/float64(len(values)-1),
+			float64(height)-v*float64(height-2)/maxV-1)
+		pen = "L"
+	}
+	fmt.Fprintf(w, `"/></svg>`)
+}
+
+// handleMobile renders the compact phone view: GET /m
+func handleMobile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := requestLang(w, r)
+
+	latest := store.latestStats()
+	ids := byLastHeard(latest)
+	cadences := store.getCadences(ctx)
+	now := timeNow()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>%s</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+%s%s    <style>
+%s    </style>
+</head>
+<body>
+<h1>📡 %s</h1>
+`, html.EscapeString(l.T("LoRa Detector Dashboard")), refreshHead(false), pwaHead, mobileCSS, html.EscapeString(l.T("LoRa Detector Dashboard")))
+	if store.ephemeral {
+		fmt.Fprintf(w, "<p class=\"ephemeral-banner\">⚠️ %s</p>\n", l.T("Ephemeral mode: data is kept in memory and will be lost when the server stops."))
+	}
+
+	if len(ids) == 0 {
+		fmt.Fprintf(w, "<p>%s</p>\n", l.T("No data received yet"))
+	}
+
+	for _, id := range ids {
+		st := latest[id]
+		catCounts := make(map[string]int)
+		for i, f := range frequencies() {
+			if i < len(st.FreqDetections) {
+				catCounts[f.Category] += st.FreqDetections[i]
+			}
+		}
+		hot := ""
+		if st.CurrentActivity >= 10 {
+			hot = "hot"
+		}
+		class, heard := "dev", l.ShortDateTime(st.Timestamp)
+		if cadences.of(id).Offline(st.Timestamp, now) {
+			class, heard = "dev stale", l.LastHeard(now.Sub(st.Timestamp))
+		}
+
+		fmt.Fprintf(w, `<div class="%s">
+    <div class="dev-head"><a href="/device?device=%s">%s</a><span>%s</span></div>
+    <div class="now">
+        <div class="%s"><b>%d%%</b><span>%s</span></div>
+        <div><b>%d</b><span>%s</span></div>
+        <div><b>%d</b><span>%s</span></div>
+    </div>
+    <div class="cats">`, class, url.QueryEscape(id), html.EscapeString(id), heard,
+			hot, st.CurrentActivity, l.T("Activity"), st.DetectionsPerMin, l.T("Per Minute"),
+			st.TotalDetections, l.T("Total Detections"))
+		for _, cat := range categories() {
+			info, _ := categoryByName(cat)
+			fmt.Fprintf(w, `<div class="cat"><b>%d</b><span>%s</span></div>`, catCounts[cat], l.T(info.Nouns[1]))
+		}
+		fmt.Fprintf(w, `</div>
+    `)
+		renderSparkline(w, store.getRecentRates(ctx, id), l.T("Detections per minute, recent"), 300, 40)
+		fmt.Fprintf(w, `
+</div>
+`)
+	}
+
+	fmt.Fprintf(w, `<footer><a href="/">%s</a></footer>
+</body>
+</html>`, l.T("Full dashboard"))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The server can terminate TLS itself and authenticate detectors by client
+// certificate. Certificates not issued by the client CA fail the handshake;
+// uploads to /upload and /events must then come with a certificate whose CN is
+// their device_id. UDP datagrams, CoAP and LoRaWAN uplinks (the last relayed by
+// a network server, whose certificate names no device) can't carry one, so
+// they are refused (see refuseUnsignable) unless the operator names the
+// transport in -tls-uncertified. Relays' batches are vouched for by the relay's
+// signature, and the serial bridge reads a port the operator chose.
+const (
+	clientAuthDevices = "devices" // Browsers need no certificate; /upload and /events do
+	clientAuthAll     = "all"     // Every connection needs one
+)
+
+// Transports that can't carry a client certificate, for -tls-uncertified
+const (
+	transportUDP     = "udp"
+	transportCoAP    = "coap"
+	transportLoRaWAN = "lorawan"
+)
+
+var (
+	errNoClientCert       = errors.New("client certificate required")
+	errClientCertMismatch = errors.New("client certificate is for another device")
+)
+
+// clientCertsRequired is set when uploads must carry a client certificate;
+// uncertifiedTransports may upload without one even so
+var (
+	clientCertsRequired   bool
+	uncertifiedTransports map[string]bool
+)
+
+// tlsConfig builds the listener's TLS settings, or nil when TLS is off
+func tlsConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, errors.New("-tls-client-ca needs -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	uncertified := make(map[string]bool)
+	for _, t := range strings.Split(cfg.TLSUncertified, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case "":
+		case transportUDP, transportCoAP, transportLoRaWAN:
+			uncertified[t] = true
+		default:
+			return nil, fmt.Errorf("-tls-uncertified: unknown transport %q (want %s, %s or %s)", t, transportUDP, transportCoAP, transportLoRaWAN)
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.TLSClientCA == "" {
+		return conf, nil
+	}
+
+	pem, err := os.ReadFile(cfg.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", cfg.TLSClientCA)
+	}
+	conf.ClientCAs = pool
+	switch cfg.TLSClientAuth {
+	case clientAuthDevices:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthAll:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("-tls-client-auth must be %s or %s", clientAuthDevices, clientAuthAll)
+	}
+	// A certificate without a CN can't be matched to a device
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) > 0 && cs.PeerCertificates[0].Subject.CommonName == "" {
+			return errors.New("client certificate has no CN")
+		}
+		return nil
+	}
+	clientCertsRequired, uncertifiedTransports = true, uncertified
+	return conf, nil
+}
+
+// checkClientCert makes sure an upload's client certificate names its device
+func checkClientCert(r *http.Request, deviceID string) error {
+	if !clientCertsRequired || r.TLS == nil {
+		return nil
+	}
+	if len(r.TLS.PeerCertificates) == 0 {
+		return errNoClientCert
+	}
+	if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != deviceID {
+		return fmt.Errorf("%w: certificate CN is %q", errClientCertMismatch, cn)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TransmitterFix is an estimated transmitter position from correlated detections
+type TransmitterFix struct {
+	Time           time.Time    `json:"time"`
+	FreqIndex      int          `json:"freq_index"`
+	MHz            string       `json:"mhz"`
+	Latitude       float64      `json:"latitude"`
+	Longitude      float64      `json:"longitude"`
+	Detectors      []string     `json:"detectors"`
+	SemiMajorM     float64      `json:"semi_major_m"`
+	SemiMinorM     float64      `json:"semi_minor_m"`
+	OrientationDeg float64      `json:"orientation_deg"` // Major axis, clockwise from north
+	Ellipse        [][2]float64 `json:"ellipse"`         // 95% confidence outline as [lat, lon] points
+}
+
+// Multilateration parameters
+const (
+	correlationWindow = 2 * time.Second // detections this close together are treated as one transmission
+	minFixDetectors   = 3
+	shadowingDB       = 6.0   // typical log-normal shadowing spread
+	ellipseChi2       = 5.991 // 95% for two degrees of freedom
+	metersPerDegLat   = 110540.0
+	metersPerDegLon   = 111320.0 // at the equator, scaled by cos(latitude)
+)
+
+// pathLossModel returns the reference RSSI at 1 m and the path-loss exponent
+func pathLossModel() (refDBm, exponent float64) {
+	refDBm, exponent = -30, 2.7
+	if v, err := strconv.ParseFloat(os.Getenv("PATHLOSS_REF_DBM"), 64); err == nil {
+		refDBm = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("PATHLOSS_EXPONENT"), 64); err == nil && v > 0 {
+		exponent = v
+	}
+	return refDBm, exponent
+}
+
+// rssiDistance converts RSSI into an estimated range using the log-distance model
+func rssiDistance(rssi float64) float64 {
+	ref, n := pathLossModel()
+	return math.Pow(10, (ref-rssi)/(10*n))
+}
+
+// rangeObservation is one detector's estimated distance to a transmitter, in local metres
+type rangeObservation struct {
+	x, y, d float64
+}
+
+// solveMultilateration fits a position to range estimates with weighted Gauss-Newton.
+// Range error grows with distance under log-normal shadowing, so far detectors
+// count for less. Returns the position and its 2x2 covariance (xx, xy, yy).
+func solveMultilateration(obs []rangeObservation) (x, y, cxx, cxy, cyy float64, ok bool) {
+	_, n := pathLossModel()
+	weights := make([]float64, len(obs))
+	sumW := 0.0
+	for i, o := range obs {
+		sigma := o.d * math.Ln10 / (10 * n) * shadowingDB
+		weights[i] = 1 / (sigma * sigma)
+		// Start from the centroid weighted toward the nearest detectors
+		x += o.x / o.d
+		y += o.y / o.d
+		sumW += 1 / o.d
+	}
+	x, y = x/sumW, y/sumW
+
+	var a, b, c float64
+	for iter := 0; iter < 50; iter++ {
+		var gx, gy float64
+		a, b, c = 0, 0, 0
+		for i, o := range obs {
+			dx, dy := x-o.x, y-o.y
+			dist := math.Max(math.Hypot(dx, dy), 1e-3)
+			jx, jy := dx/dist, dy/dist
+			r := dist - o.d
+			w := weights[i]
+			a += w * jx * jx
+			b += w * jx * jy
+			c += w * jy * jy
+			gx += w * jx * r
+			gy += w * jy * r
+		}
+		det := a*c - b*b
+		if det <= 0 {
+			return 0, 0, 0, 0, 0, false
+		}
+		// Solve (JᵀWJ) step = -JᵀWr
+		sx := -(c*gx - b*gy) / det
+		sy := -(a*gy - b*gx) / det
+		x, y = x+sx, y+sy
+		if math.Hypot(sx, sy) < 0.01 {
+			break
+		}
+	}
+
+	det := a*c - b*b
+	if det <= 0 {
+		return 0, 0, 0, 0, 0, false
+	}
+	return x, y, c / det, -b / det, a / det, true
+}
+
+// confidenceEllipse turns a covariance into 95% semi-axes and major-axis orientation
+// (radians counter-clockwise from +x, i.e. east)
+func confidenceEllipse(cxx, cxy, cyy float64) (major, minor, angle float64) {
+	mid := (cxx + cyy) / 2
+	diff := math.Sqrt(math.Pow((cxx-cyy)/2, 2) + cxy*cxy)
+	l1, l2 := mid+diff, math.Max(mid-diff, 0)
+	return math.Sqrt(l1 * ellipseChi2), math.Sqrt(l2 * ellipseChi2), 0.5 * math.Atan2(2*cxy, cxx-cyy)
+}
+
+// correlatedGroup is one transmission heard by several detectors
+type correlatedGroup struct {
+	time      time.Time
+	freqIndex int
+	rssi      map[string]float64 // strongest reading per detector
+}
+
+// findCorrelatedGroups clusters RSSI-tagged events on the same frequency that fall
+// within correlationWindow of each other, keeping groups heard by enough detectors
+func findCorrelatedGroups(events []StoredEvent) []correlatedGroup {
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+
+	var groups []correlatedGroup
+	open := make(map[int]*correlatedGroup)
+	flush := func(g *correlatedGroup) {
+		if len(g.rssi) >= minFixDetectors {
+			groups = append(groups, *g)
+		}
+	}
+
+	for _, e := range events {
+		if e.RSSI == nil {
+			continue
+		}
+		g := open[e.FreqIndex]
+		if g != nil && e.Timestamp.Sub(g.time) > correlationWindow {
+			flush(g)
+			g = nil
+		}
+		if g == nil {
+			g = &correlatedGroup{time: e.Timestamp, freqIndex: e.FreqIndex, rssi: make(map[string]float64)}
+			open[e.FreqIndex] = g
+		}
+		if prev, seen := g.rssi[e.DeviceID]; !seen || *e.RSSI > prev {
+			g.rssi[e.DeviceID] = *e.RSSI
+		}
+	}
+	for _, g := range open {
+		flush(g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].time.Before(groups[j].time) })
+	return groups
+}
+
+// getTransmitterFixes multilaterates every correlated transmission since the given time
+func (s *Store) getTransmitterFixes(ctx context.Context, since time.Time) []TransmitterFix {
+	located := make(map[string]DeviceInfo)
+	for _, d := range s.getDevices(ctx) {
+		if d.Located() {
+			located[d.DeviceID] = d
+		}
+	}
+	if len(located) < minFixDetectors {
+		return nil
+	}
+
+	var events []StoredEvent
+	for id := range located {
+		events = append(events, s.getEvents(ctx, id, since, timeNow())...)
+	}
+
+	var fixes []TransmitterFix
+	for _, g := range findCorrelatedGroups(events) {
+		// Project detectors into a local east/north plane around their mean position
+		var lat0, lon0 float64
+		ids := make([]string, 0, len(g.rssi))
+		for id := range g.rssi {
+			ids = append(ids, id)
+			lat0 += *located[id].Latitude
+			lon0 += *located[id].Longitude
+		}
+		sort.Strings(ids)
+		lat0 /= float64(len(ids))
+		lon0 /= float64(len(ids))
+		lonScale := metersPerDegLon * math.Cos(lat0*math.Pi/180)
+
+		obs := make([]rangeObservation, len(ids))
+		for i, id := range ids {
+			d := located[id]
+			obs[i] = rangeObservation{
+				x: (*d.Longitude - lon0) * lonScale,
+				y: (*d.Latitude - lat0) * metersPerDegLat,
+				d: rssiDistance(g.rssi[id]),
+			}
+		}
+
+		x, y, cxx, cxy, cyy, ok := solveMultilateration(obs)
+		if !ok {
+			continue
+		}
+		major, minor, angle := confidenceEllipse(cxx, cxy, cyy)
+
+		fix := TransmitterFix{
+			Time:           g.time,
+			FreqIndex:      g.freqIndex,
+			MHz:            frequencyAt(g.freqIndex).MHz,
+			Latitude:       lat0 + y/metersPerDegLat,
+			Longitude:      lon0 + x/lonScale,
+			Detectors:      ids,
+			SemiMajorM:     major,
+			SemiMinorM:     minor,
+			OrientationDeg: normalizeBearing(90 - angle*180/math.Pi),
+		}
+		for k := 0; k <= 36; k++ {
+			t := 2 * math.Pi * float64(k) / 36
+			ex := x + major*math.Cos(t)*math.Cos(angle) - minor*math.Sin(t)*math.Sin(angle)
+			ey := y + major*math.Cos(t)*math.Sin(angle) + minor*math.Sin(t)*math.Cos(angle)
+			fix.Ellipse = append(fix.Ellipse, [2]float64{lat0 + ey/metersPerDegLat, lon0 + ex/lonScale})
+		}
+		fixes = append(fixes, fix)
+	}
+	return fixes
+}
+
+// handleAPIFixes returns multilaterated transmitter positions: GET /api/fixes?hours=24
+func handleAPIFixes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+
+	fixes := store.getTransmitterFixes(ctx, timeNow().Add(-time.Duration(hours)*time.Hour))
+	if fixes == nil {
+		fixes = []TransmitterFix{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fixes)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Users can sign in with an OpenID Connect provider (Authentik, Keycloak,
+// Google, ...) instead of a local password. The login is the authorization code
+// flow with PKCE; the ID token comes straight from the provider's token endpoint
+// over TLS, which OIDC accepts in place of checking its signature. Sessions are
+// kept in memory, so a restart signs everyone out; signing in again is a
+// redirect while the provider's own session lasts.
+const (
+	sessionCookie   = "lds_session"
+	sessionLifetime = 12 * time.Hour
+	loginLifetime   = 10 * time.Minute // Time allowed at the provider's login page
+	maxPendingLogin = 1000
+)
+
+// oidc is the configured provider, nil when OIDC login is off
+var oidc *oidcProvider
+
+// oidcProvider is an OpenID Connect provider and the sessions it has issued
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string // This server's /auth/callback as the provider sees it
+	scopes       string
+	groupsClaim  string
+	adminGroups  map[string]bool
+	viewerGroups map[string]bool // Empty lets every account at the provider in as a viewer
+	client       *http.Client
+
+	discoverMu sync.Mutex
+	endpoints  *oidcEndpoints // Discovered on first use
+
+	mu       sync.Mutex
+	pending  map[string]oidcLogin   // By state
+	sessions map[string]oidcSession // By SHA-256 of the cookie
+}
+
+// oidcEndpoints is the part of the discovery document the login uses
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcLogin is a sign-in waiting for the provider to redirect back
+type oidcLogin struct {
+	nonce, verifier, next string
+	started               time.Time
+}
+
+// oidcSession is a signed-in user
+type oidcSession struct {
+	User    string
+	Role    string
+	Expires time.Time
+}
+
+// groupSet parses a comma-separated group list
+func groupSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.TrimPrefix(strings.TrimSpace(g), "/"); g != "" {
+			set[g] = true
+		}
+	}
+	return set
+}
+
+// oidcFromEnv configures OIDC login from OIDC_ISSUER, OIDC_CLIENT_ID,
+// OIDC_CLIENT_SECRET and OIDC_REDIRECT_URL, returning nil if it is not set up
+func oidcFromEnv() (*oidcProvider, error) {
+	p := &oidcProvider{
+		issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:       os.Getenv("OIDC_SCOPES"),
+		groupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		adminGroups:  groupSet(os.Getenv("OIDC_ADMIN_GROUPS")),
+		viewerGroups: groupSet(os.Getenv("OIDC_VIEWER_GROUPS")),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pending:      make(map[string]oidcLogin),
+		sessions:     make(map[string]oidcSession),
+	}
+	if p.issuer == "" {
+		return nil, nil
+	}
+	if p.clientID == "" || p.redirectURL == "" {
+		return nil, errors.New("OIDC_ISSUER needs OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	if len(p.adminGroups) == 0 {
+		log.Printf("WARNING: OIDC_ADMIN_GROUPS is empty, so nobody can sign in as admin with OIDC")
+	}
+	if p.scopes == "" {
+		p.scopes = "openid profile email"
+	}
+	if p.groupsClaim == "" {
+		p.groupsClaim = "groups"
+	}
+	return p, nil
+}
+
+// discover fetches and caches the provider's endpoints
+func (p *oidcProvider) discover(ctx context.Context) (*oidcEndpoints, error) {
+	p.discoverMu.Lock()
+	defer p.discoverMu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+	var ep oidcEndpoints
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", "", &ep); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(ep.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, not %q", ep.Issuer, p.issuer)
+	}
+	if ep.AuthorizationEndpoint == "" || ep.TokenEndpoint == "" {
+		return nil, errors.New("discovery: missing authorization or token endpoint")
+	}
+	p.endpoints = &ep
+	return p.endpoints, nil
+}
+
+// getJSON decodes a GET response, with a bearer token if one is given
+func (p *oidcProvider) getJSON(ctx context.Context, u, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// randomToken returns n random bytes, base64url encoded
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sessionKey is how a session cookie is stored, so a memory dump doesn't hold live cookies
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// session returns the request's signed-in user, if any
+func (p *oidcProvider) session(r *http.Request) (oidcSession, bool) {
+	if p == nil {
+		return oidcSession{}, false
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return oidcSession{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sessions[sessionKey(c.Value)]
+	if !ok || time.Now().After(s.Expires) {
+		return oidcSession{}, false
+	}
+	return s, true
+}
+
+// claimStrings reads a claim that may be a string or a list of strings
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// role maps a user's groups to admin or viewer, or "" if they aren't allowed
+// in. A verified email address counts as a group, for providers such as Google
+// that don't send groups.
+func (p *oidcProvider) role(claims map[string]interface{}) string {
+	groups := claimStrings(claims[p.groupsClaim])
+	if email, ok := claims["email"].(string); ok && claims["email_verified"] == true {
+		groups = append(groups, email)
+	}
+	viewer := len(p.viewerGroups) == 0
+	for _, g := range groups {
+		g = strings.TrimPrefix(g, "/") // Keycloak sends full group paths
+		if p.adminGroups[g] {
+			return roleAdmin
+		}
+		viewer = viewer || p.viewerGroups[g]
+	}
+	if viewer {
+		return roleViewer
+	}
+	return ""
+}
+
+// idTokenClaims decodes an ID token's claims and checks they were issued to
+// this client for this login
+func (p *oidcProvider) idTokenClaims(idToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("ID token issuer is %q", iss)
+	}
+	audienceOK := false
+	for _, aud := range claimStrings(claims["aud"]) {
+		audienceOK = audienceOK || aud == p.clientID
+	}
+	if !audienceOK {
+		return nil, errors.New("ID token is for another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+	return claims, nil
+}
+
+// exchange trades an authorization code for the user's claims, asking the
+// userinfo endpoint for groups when the ID token leaves them out
+func (p *oidcProvider) exchange(ctx context.Context, code string, login oidcLogin) (map[string]interface{}, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if tok.Error != "" || tok.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", tok.Error, tok.Description)
+	}
+	claims, err := p.idTokenClaims(tok.IDToken, login.nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims[p.groupsClaim]; !ok && ep.UserinfoEndpoint != "" && tok.AccessToken != "" {
+		var info map[string]interface{}
+		if err := p.getJSON(ctx, ep.UserinfoEndpoint, tok.AccessToken, &info); err != nil {
+			log.Printf("OIDC userinfo: %v", err)
+		} else if info["sub"] == claims["sub"] {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+	return claims, nil
+}
+
+// localRedirect keeps post-login redirects on this server
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin sends the browser to the provider: GET /auth/login?next=/admin
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ep, err := oidc.discover(r.Context())
+	if err != nil {
+		log.Printf("OIDC %v", err)
+		http.Error(w, "Sign-in provider unavailable", http.StatusBadGateway)
+		return
+	}
+	state := randomToken(24)
+	login := oidcLogin{nonce: randomToken(24), verifier: randomToken(32), next: localRedirect(r.URL.Query().Get("next")), started: time.Now()}
+	oidc.mu.Lock()
+	for s, l := range oidc.pending {
+		if time.Since(l.started) > loginLifetime {
+			delete(oidc.pending, s)
+		}
+	}
+	full := len(oidc.pending) >= maxPendingLogin
+	if !full {
+		oidc.pending[state] = login
+	}
+	oidc.mu.Unlock()
+	if full {
+		http.Error(w, "Too many sign-ins in progress, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.clientID},
+		"redirect_uri":          {oidc.redirectURL},
+		"scope":                 {oidc.scopes},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(ep.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, ep.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// handleLoginCallback finishes a sign-in and starts a session: GET /auth/callback
+func handleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	oidc.mu.Lock()
+	login, ok := oidc.pending[q.Get("state")]
+	delete(oidc.pending, q.Get("state"))
+	oidc.mu.Unlock()
+	if !ok || time.Since(login.started) > loginLifetime {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Sign-in refused: "+e+" "+q.Get("error_description"), http.StatusForbidden)
+		return
+	}
+
+	claims, err := oidc.exchange(r.Context(), q.Get("code"), login)
+	if err != nil {
+		log.Printf("OIDC sign-in failed: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusBadGateway)
+		return
+	}
+	user, _ := claims["preferred_username"].(string)
+	if user == "" {
+		user, _ = claims["email"].(string)
+	}
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+	role := oidc.role(claims)
+	if role == "" {
+		log.Printf("OIDC sign-in refused for %s: not in an allowed group", user)
+		http.Error(w, "Your account isn't in a group allowed to use this server", http.StatusForbidden)
+		return
+	}
+
+	token := randomToken(32)
+	s := oidcSession{User: user, Role: role, Expires: time.Now().Add(sessionLifetime)}
+	oidc.mu.Lock()
+	for k, old := range oidc.sessions {
+		if time.Now().After(old.Expires) {
+			delete(oidc.sessions, k)
+		}
+	}
+	oidc.sessions[sessionKey(token)] = s
+	oidc.mu.Unlock()
+	log.Printf("OIDC sign-in: %s as %s", user, role)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(oidc.redirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode, // Cross-site form posts don't carry it
+	})
+	http.Redirect(w, r, login.next, http.StatusSeeOther)
+}
+
+// handleLogout ends the session on this server (not at the provider): GET /auth/logout
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil && oidc != nil {
+		oidc.mu.Lock()
+		delete(oidc.sessions, sessionKey(c.Value))
+		oidc.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forwarders pass what the server hears on to other systems: the upstream in
+// bridge mode (see relay.go) and the MQTT broker. Neither may lose data to an
+// outage on the other end or a restart on this one, so what they haven't sent
+// yet waits on disk. The relay's backlog is the uploads it hasn't sent, behind a
+// cursor. Other forwarders queue their messages in the outbox table and send
+// them in order, deleting each batch once the receiver has taken it. The
+// outbox keeps at most OUTBOX_MAX messages per forwarder, dropping the oldest
+// beyond that, so a receiver that never comes back can't fill the disk.
+//
+// Each forwarder reports its backlog and last error in /api/admin/status, and
+// the dashboard shows a badge while anything is waiting.
+const outboxBatch = 100
+
+// outboxMax is how many messages each forwarder may queue (OUTBOX_MAX)
+func outboxMax() int {
+	if v, err := strconv.Atoi(os.Getenv("OUTBOX_MAX")); err == nil && v > 0 {
+		return v
+	}
+	return 100000
+}
+
+// outboundMessage is one queued message: an MQTT topic and its payload
+type outboundMessage struct {
+	ID      int64
+	Topic   string
+	Payload []byte
+}
+
+// queueOutbound adds messages to a forwarder's queue, dropping its oldest
+// beyond outboxMax, and returns how many were dropped
+func (s *Store) queueOutbound(ctx context.Context, sink string, msgs []outboundMessage) (int, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := timeNow().Format("2006-01-02 15:04:05")
+	for _, m := range msgs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO outbox (sink, topic, payload, queued_at) VALUES (?, ?, ?, ?)
+		`, sink, m.Topic, m.Payload, now); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM outbox WHERE sink = ? AND id <= (
+			SELECT id FROM outbox WHERE sink = ? ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, sink, sink, outboxMax())
+	if err != nil {
+		return 0, err
+	}
+	dropped, _ := res.RowsAffected()
+	return int(dropped), tx.Commit()
+}
+
+// outboundBatch returns the oldest of a forwarder's queued messages
+func (s *Store) outboundBatch(ctx context.Context, sink string) ([]outboundMessage, error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, topic, payload FROM outbox WHERE sink = ? ORDER BY id LIMIT ?
+	`, sink, outboxBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []outboundMessage
+	for rows.Next() {
+		var m outboundMessage
+		if err := rows.Scan(&m.ID, &m.Topic, &m.Payload); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// ackOutbound deletes a forwarder's messages up to and including id, once sent
+func (s *Store) ackOutbound(ctx context.Context, sink string, id int64) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `DELETE FROM outbox WHERE sink = ? AND id <= ?`, sink, id)
+	return err
+}
+
+// outboundDepth counts a forwarder's queued messages
+func (s *Store) outboundDepth(ctx context.Context, sink string) int {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM outbox WHERE sink = ?`, sink).Scan(&n); err != nil {
+		log.Printf("Error counting %s's queued messages: %v", sink, err)
+	}
+	return n
+}
+
+// ForwarderStatus is one forwarder's backlog, for /api/admin/status
+type ForwarderStatus struct {
+	Name      string     `json:"name"`
+	Target    string     `json:"target"`
+	Pending   int        `json:"pending"`           // Waiting to be sent
+	Dropped   int        `json:"dropped,omitempty"` // Discarded from a full queue since startup
+	LastSent  *time.Time `json:"last_sent,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Failing   *time.Time `json:"failing_since,omitempty"`
+}
+
+// forwarder tracks one forwarder's progress; pending counts its backlog
+type forwarder struct {
+	status  ForwarderStatus
+	pending func(ctx context.Context) int
+}
+
+// forwarders are those running, in the order they started
+var forwarders = struct {
+	sync.Mutex
+	list []*forwarder
+}{}
+
+// registerForwarder starts tracking a forwarder
+func registerForwarder(name, target string, pending func(ctx context.Context) int) *forwarder {
+	f := &forwarder{status: ForwarderStatus{Name: name, Target: target}, pending: pending}
+	forwarders.Lock()
+	defer forwarders.Unlock()
+	forwarders.list = append(forwarders.list, f)
+	return f
+}
+
+// sent records a successful send, returning how long the forwarder had been
+// failing before it (zero if it wasn't)
+func (f *forwarder) sent() time.Duration {
+	forwarders.Lock()
+	defer forwarders.Unlock()
+	now := time.Now()
+	var down time.Duration
+	if f.status.Failing != nil {
+		down = now.Sub(*f.status.Failing)
+	}
+	f.status.LastSent, f.status.LastError, f.status.Failing = &now, "", nil
+	return down
+}
+
+// failed records a failed send, reporting whether it's the first since the
+// last success
+func (f *forwarder) failed(err error) bool {
+	forwarders.Lock()
+	defer forwarders.Unlock()
+	first := f.status.Failing == nil
+	if first {
+		now := time.Now()
+		f.status.Failing = &now
+	}
+	f.status.LastError = err.Error()
+	return first
+}
+
+// dropped counts messages discarded from a full queue
+func (f *forwarder) dropped(n int) {
+	forwarders.Lock()
+	defer forwarders.Unlock()
+	f.status.Dropped += n
+}
+
+// forwarderStatuses reports every running forwarder's backlog
+func forwarderStatuses(ctx context.Context) []ForwarderStatus {
+	forwarders.Lock()
+	list := make([]*forwarder, len(forwarders.list))
+	copy(list, forwarders.list)
+	statuses := make([]ForwarderStatus, len(list))
+	for i, f := range list {
+		statuses[i] = f.status
+	}
+	forwarders.Unlock()
+	for i, f := range list {
+		statuses[i].Pending = f.pending(ctx)
+	}
+	return statuses
+}
+
+// backlogBadge is the dashboard's badge for data waiting to be forwarded, or
+// "" while every forwarder is caught up
+func backlogBadge(ctx context.Context, l Lang) string {
+	total := 0
+	var detail []string
+	for _, f := range forwarderStatuses(ctx) {
+		if f.Pending == 0 && f.LastError == "" {
+			continue
+		}
+		total += f.Pending
+		line := l.Tf("%s: %d waiting", f.Name, f.Pending)
+		if f.LastError != "" {
+			line += " · " + f.LastError
+		}
+		detail = append(detail, line)
+	}
+	if len(detail) == 0 {
+		return ""
+	}
+	sort.Strings(detail)
+	label := "⚠️ " + l.T("Forwarding failing")
+	if total > 0 {
+		label = "⏳ " + l.Tf("%d waiting to forward", total)
+	}
+	return ` <span class="db-badge backlog" title="` + html.EscapeString(strings.Join(detail, "\n")) + `">` + label + `</span>`
+}
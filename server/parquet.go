@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// A minimal Parquet writer for exports: flat schemas of INT64, DOUBLE and UTF-8
+// columns, PLAIN encoded and uncompressed, one data page per column per row
+// group. Enough for pandas, DuckDB, Spark and friends to read; nothing more.
+// The footer is Thrift's compact protocol, written by hand below.
+
+// parquetRowGroupRows bounds how many rows are buffered before a row group is written
+const parquetRowGroupRows = 100_000
+
+// Parquet physical types
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet converted types
+const (
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// parquetColumn describes one column of the schema
+type parquetColumn struct {
+	Name      string
+	Type      int  // parquetInt64, parquetDouble or parquetByteArray
+	Optional  bool // Nullable; REQUIRED otherwise
+	Timestamp bool // INT64 milliseconds since the epoch, UTC
+}
+
+// parquetChunk is a written column chunk, for the footer
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// parquetWriter streams rows into a Parquet file
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	values  [][]interface{} // Buffered values per column for the current row group
+	rows    int
+	total   int64
+	groups  [][]parquetChunk
+	groupN  []int64
+	err     error
+}
+
+// newParquetWriter writes the file magic and returns a writer for the columns
+func newParquetWriter(w io.Writer, columns []parquetColumn) *parquetWriter {
+	p := &parquetWriter{w: w, columns: columns, values: make([][]interface{}, len(columns))}
+	p.write([]byte("PAR1"))
+	return p
+}
+
+func (p *parquetWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+// writeRow adds a row: int64 (or int), float64 and string values as the
+// columns declare them, nil for nulls in optional columns
+func (p *parquetWriter) writeRow(row []interface{}) error {
+	if len(row) != len(p.columns) {
+		return errors.New("parquet: row has the wrong number of values")
+	}
+	for i, v := range row {
+		if v == nil && !p.columns[i].Optional {
+			return errors.New("parquet: null in required column " + p.columns[i].Name)
+		}
+		p.values[i] = append(p.values[i], v)
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupRows {
+		p.flushRowGroup()
+	}
+	return p.err
+}
+
+// flushRowGroup writes the buffered rows as a row group
+func (p *parquetWriter) flushRowGroup() {
+	if p.rows == 0 || p.err != nil {
+		return
+	}
+	chunks := make([]parquetChunk, len(p.columns))
+	for i, c := range p.columns {
+		page := encodeParquetPage(c, p.values[i])
+		var header thriftWriter
+		header.fieldI32(1, 0) // DATA_PAGE
+		header.fieldI32(2, int32(len(page)))
+		header.fieldI32(3, int32(len(page)))
+		header.fieldStruct(5, func(t *thriftWriter) {
+			t.fieldI32(1, int32(len(p.values[i])))
+			t.fieldI32(2, 0) // PLAIN
+			t.fieldI32(3, 3) // RLE definition levels
+			t.fieldI32(4, 3) // RLE repetition levels
+		})
+		header.stop()
+		chunks[i] = parquetChunk{offset: p.offset, size: int64(header.buf.Len() + len(page)), numValues: int64(len(p.values[i]))}
+		p.write(header.buf.Bytes())
+		p.write(page)
+		p.values[i] = p.values[i][:0]
+	}
+	p.groups = append(p.groups, chunks)
+	p.groupN = append(p.groupN, int64(p.rows))
+	p.total += int64(p.rows)
+	p.rows = 0
+}
+
+// encodeParquetPage encodes a data page body: definition levels for optional
+// columns, then the non-null values
+func encodeParquetPage(c parquetColumn, values []interface{}) []byte {
+	var page bytes.Buffer
+	if c.Optional {
+		levels := parquetDefinitionLevels(values)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+	var b [8]byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			page.Write(b[:])
+		case int:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			page.Write(b[:])
+		case float64:
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			page.Write(b[:])
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		}
+	}
+	return page.Bytes()
+}
+
+// parquetDefinitionLevels run-length encodes which values are present (bit width 1)
+func parquetDefinitionLevels(values []interface{}) []byte {
+	var out []byte
+	for i := 0; i < len(values); {
+		present := values[i] != nil
+		j := i
+		for j < len(values) && (values[j] != nil) == present {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		if present {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// close writes the last row group and the footer
+func (p *parquetWriter) close() error {
+	p.flushRowGroup()
+	if p.err != nil {
+		return p.err
+	}
+	var meta thriftWriter
+	meta.fieldI32(1, 1) // Version
+	meta.fieldList(2, thriftStruct, len(p.columns)+1, func(t *thriftWriter) {
+		t.structElem(func(t *thriftWriter) {
+			t.fieldString(4, "schema")
+			t.fieldI32(5, int32(len(p.columns)))
+		})
+		for _, c := range p.columns {
+			t.structElem(func(t *thriftWriter) {
+				t.fieldI32(1, int32(c.Type))
+				rep := int32(0) // REQUIRED
+				if c.Optional {
+					rep = 1
+				}
+				t.fieldI32(3, rep)
+				t.fieldString(4, c.Name)
+				switch {
+				case c.Type == parquetByteArray:
+					t.fieldI32(6, parquetUTF8)
+				case c.Timestamp:
+					t.fieldI32(6, parquetTimestampMillis)
+				}
+			})
+		}
+	})
+	meta.fieldI64(3, p.total)
+	meta.fieldList(4, thriftStruct, len(p.groups), func(t *thriftWriter) {
+		for g, chunks := range p.groups {
+			t.structElem(func(t *thriftWriter) {
+				var size int64
+				t.fieldList(1, thriftStruct, len(chunks), func(t *thriftWriter) {
+					for i, ch := range chunks {
+						size += ch.size
+						c := p.columns[i]
+						t.structElem(func(t *thriftWriter) {
+							t.fieldI64(2, ch.offset)
+							t.fieldStruct(3, func(t *thriftWriter) {
+								t.fieldI32(1, int32(c.Type))
+								t.fieldList(2, thriftI32, 2, func(t *thriftWriter) {
+									t.varint(0) // PLAIN
+									t.varint(3) // RLE
+								})
+								t.fieldList(3, thriftBinary, 1, func(t *thriftWriter) { t.binary(c.Name) })
+								t.fieldI32(4, 0) // UNCOMPRESSED
+								t.fieldI64(5, ch.numValues)
+								t.fieldI64(6, ch.size)
+								t.fieldI64(7, ch.size)
+								t.fieldI64(9, ch.offset)
+							})
+						})
+					}
+				})
+				t.fieldI64(2, size)
+				t.fieldI64(3, p.groupN[g])
+			})
+		}
+	})
+	meta.fieldString(6, "lora-detector-server")
+	meta.stop()
+
+	p.write(meta.buf.Bytes())
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(meta.buf.Len()))
+	p.write(n[:])
+	p.write([]byte("PAR1"))
+	return p.err
+}
+
+// Thrift compact protocol type IDs
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the compact protocol, tracking field IDs per struct
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field ID written, per open struct
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64((v<<1)^(v>>63)))) // Zigzag
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	var last int16
+	if len(t.last) > 0 {
+		last = t.last[len(t.last)-1]
+	}
+	if delta := id - last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	t.last[len(t.last)-1] = id
+}
+
+func (t *thriftWriter) fieldI32(id int16, v int32) { t.field(id, thriftI32); t.varint(int64(v)) }
+func (t *thriftWriter) fieldI64(id int16, v int64) { t.field(id, thriftI64); t.varint(v) }
+func (t *thriftWriter) fieldString(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// fieldStruct writes a struct field whose fields body writes
+func (t *thriftWriter) fieldStruct(id int16, body func(*thriftWriter)) {
+	t.field(id, thriftStruct)
+	t.structElem(body)
+}
+
+// structElem writes a struct value (a field's or a list element's)
+func (t *thriftWriter) structElem(body func(*thriftWriter)) {
+	t.last = append(t.last, 0)
+	body(t)
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// fieldList writes a list field of n elements of one type, written by body
+func (t *thriftWriter) fieldList(id int16, elem byte, n int, body func(*thriftWriter)) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xF0 | elem)
+		t.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+	}
+	body(t)
+}
+
+// stop ends the current struct
+func (t *thriftWriter) stop() { t.buf.WriteByte(0) }
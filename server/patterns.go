@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PeriodicTransmitter is an inferred device transmitting at a regular interval
+type PeriodicTransmitter struct {
+	DeviceID    string    `json:"device_id"`
+	FreqIndex   int       `json:"freq_index"`
+	MHz         string    `json:"mhz"`
+	Label       string    `json:"label"`
+	IntervalSec float64   `json:"interval_seconds"`
+	Detections  int       `json:"detections"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Pattern search limits
+const (
+	minPeriodSec     = 10
+	maxPeriodSec     = 3600
+	minRepeats       = 5  // detections needed before calling something periodic
+	maxMissedPeriods = 3  // gaps tolerated inside a sequence (missed CAD hits)
+	maxCandidates    = 50 // periods confirmed per frequency, strongest first
+)
+
+// periodTolerance is how far an arrival may drift from the expected time.
+// CAD hits land within a scan cycle or two of the real transmission, plus a
+// little clock drift on longer periods.
+func periodTolerance(period float64) float64 {
+	return 3 + period*0.002
+}
+
+// findPeriodic looks for regularly spaced arrivals in a set of event times.
+// Candidate periods come from a histogram of pairwise gaps; each candidate is
+// then confirmed by chaining events that land on successive multiples of it.
+func findPeriodic(times []time.Time) []PeriodicTransmitter {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	n := len(times)
+	if n < minRepeats {
+		return nil
+	}
+
+	secs := make([]float64, n)
+	for i, t := range times {
+		secs[i] = float64(t.Unix())
+	}
+
+	hist := make(map[int]int)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := secs[j] - secs[i]
+			if d > maxPeriodSec {
+				break
+			}
+			if d >= minPeriodSec {
+				hist[int(math.Round(d))]++
+			}
+		}
+	}
+
+	// Jittered arrivals spread across neighbouring seconds, so score each
+	// period by the gaps falling within its tolerance
+	support := func(p int) int {
+		tol := int(math.Ceil(periodTolerance(float64(p))))
+		total := 0
+		for d := p - tol; d <= p+tol; d++ {
+			total += hist[d]
+		}
+		return total
+	}
+
+	// Each gap period seeds a group: itself plus the fundamentals it reduces
+	// to. A transmitter every 300s also produces plenty of 600s gaps, so we
+	// walk down while a divisor is comparably supported. Periods are ranked
+	// by the strongest group they belong to, shortest first within a rank, so
+	// the fundamental gets to claim its arrivals before any multiple does.
+	// Originals stay in play in case a divisor's support came from unrelated
+	// traffic and never forms a chain.
+	rank := make(map[int]int)
+	promote := func(p, r int) {
+		if r > rank[p] {
+			rank[p] = r
+		}
+	}
+	for p := range hist {
+		c := support(p)
+		if c < minRepeats-1 {
+			continue
+		}
+		promote(p, c)
+		for reduced := true; reduced; {
+			reduced = false
+			for k := 2; p/k >= minPeriodSec; k++ {
+				if support(p/k)*10 >= c*6 {
+					p = peakNear(hist, p/k)
+					promote(p, c)
+					reduced = true
+					break
+				}
+			}
+		}
+	}
+
+	candidates := make([]int, 0, len(rank))
+	for p := range rank {
+		candidates = append(candidates, p)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if rank[candidates[i]] != rank[candidates[j]] {
+			return rank[candidates[i]] > rank[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	used := make([]bool, n)
+	var found []PeriodicTransmitter
+	var accepted []float64
+
+	for _, c := range candidates {
+		period := float64(c)
+		tol := periodTolerance(period)
+
+		// Skip harmonics and near-duplicates of periods we've already explained
+		harmonic := false
+		for _, a := range accepted {
+			ratio := period / a
+			if math.Abs(ratio-math.Round(ratio))*a <= periodTolerance(a) {
+				harmonic = true
+				break
+			}
+		}
+		if harmonic {
+			continue
+		}
+
+		for start := 0; start < n; start++ {
+			if used[start] {
+				continue
+			}
+			// Expected arrivals are projected from the chain start using a
+			// running period estimate, so a single jittery hit can't drag
+			// the phase off and break the sequence
+			chain := []int{start}
+			slots := 0 // periods spanned by the chain, including missed ones
+			est := period
+			for {
+				next, step := -1, 0
+				for k := 1; k <= maxMissedPeriods+1 && next < 0; k++ {
+					expected := secs[start] + float64(slots+k)*est
+					bestDiff := tol
+					for j := chain[len(chain)-1] + 1; j < n && secs[j] <= expected+tol; j++ {
+						if d := math.Abs(secs[j] - expected); !used[j] && d <= bestDiff {
+							next, step, bestDiff = j, k, d
+						}
+					}
+				}
+				if next < 0 {
+					break
+				}
+				chain = append(chain, next)
+				slots += step
+				est = (secs[next] - secs[start]) / float64(slots)
+			}
+
+			if len(chain) < minRepeats {
+				continue
+			}
+			first, last := secs[chain[0]], secs[chain[len(chain)-1]]
+			if float64(len(chain)-1) < float64(slots)*0.75 {
+				continue // too many missed slots to be convincing
+			}
+			// Reject chains that background traffic would plausibly produce
+			// by chance from any starting point and candidate period
+			span := math.Max(secs[n-1]-secs[0], 1)
+			chance := float64(n-len(chain)) / span * 2 * tol
+			if math.Pow(chance, float64(len(chain)-1))*float64(n*len(candidates)) > 0.01 {
+				continue
+			}
+			for _, idx := range chain {
+				used[idx] = true
+			}
+			accepted = append(accepted, period)
+
+			// A noisy hit can still split one transmitter into two chains;
+			// fold this chain into an earlier one that shares its period and phase
+			interval := (last - first) / float64(slots)
+			merged := false
+			for i := range found {
+				f := &found[i]
+				if math.Abs(f.IntervalSec-interval) > tol/2 {
+					continue
+				}
+				offset := math.Mod(math.Abs(first-float64(f.FirstSeen.Unix())), f.IntervalSec)
+				if math.Min(offset, f.IntervalSec-offset) > 2*tol {
+					continue
+				}
+				total := f.Detections + len(chain)
+				f.IntervalSec = (f.IntervalSec*float64(f.Detections) + interval*float64(len(chain))) / float64(total)
+				f.Detections = total
+				if times[chain[0]].Before(f.FirstSeen) {
+					f.FirstSeen = times[chain[0]]
+				}
+				if times[chain[len(chain)-1]].After(f.LastSeen) {
+					f.LastSeen = times[chain[len(chain)-1]]
+				}
+				merged = true
+				break
+			}
+			if !merged {
+				found = append(found, PeriodicTransmitter{
+					IntervalSec: interval,
+					Detections:  len(chain),
+					FirstSeen:   times[chain[0]],
+					LastSeen:    times[chain[len(chain)-1]],
+				})
+			}
+		}
+	}
+	return found
+}
+
+// peakNear returns the most common gap within tolerance of p
+func peakNear(hist map[int]int, p int) int {
+	tol := int(math.Ceil(periodTolerance(float64(p))))
+	best := p
+	for d := p - tol; d <= p+tol; d++ {
+		if hist[d] > hist[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// formatInterval renders a period like "5m 0s"
+func formatInterval(sec float64) string {
+	d := time.Duration(math.Round(sec)) * time.Second
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	if d >= time.Minute {
+		return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+// getPeriodicTransmitters analyses a device's recent events on every frequency
+func (s *Store) getPeriodicTransmitters(ctx context.Context, deviceID string, since time.Time) []PeriodicTransmitter {
+	byFreq := make([][]time.Time, len(frequencies()))
+	for _, e := range s.getEvents(ctx, deviceID, since, timeNow()) {
+		byFreq[e.FreqIndex] = append(byFreq[e.FreqIndex], e.Timestamp)
+	}
+
+	var result []PeriodicTransmitter
+	for i, times := range byFreq {
+		for _, p := range findPeriodic(times) {
+			p.DeviceID = deviceID
+			p.FreqIndex = i
+			p.MHz = frequencyAt(i).MHz
+			p.Label = frequencyAt(i).Label
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// handleAPIPeriodic lists inferred periodic transmitters: GET /api/periodic?device=ID&hours=24
+func handleAPIPeriodic(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+	since := timeNow().Add(-time.Duration(hours) * time.Hour)
+
+	var devices []string
+	if id := r.URL.Query().Get("device"); id != "" {
+		devices = []string{id}
+	} else {
+		store.mu.RLock()
+		for id := range store.latest {
+			devices = append(devices, id)
+		}
+		store.mu.RUnlock()
+		sort.Strings(devices)
+	}
+
+	result := []PeriodicTransmitter{}
+	for _, id := range devices {
+		result = append(result, store.getPeriodicTransmitters(ctx, id, since)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
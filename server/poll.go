@@ -0,0 +1,270 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Polling feeds for automation platforms (Zapier, n8n, Make) that can't take
+// webhooks: GET /api/poll/{detections,alerts,events}. Every item has a stable
+// integer id. Without ?since= a feed returns its newest items, newest first,
+// which is what Zapier's polling triggers expect; it dedupes on id. With
+// ?since=<id> it returns the next page after that id, oldest first, so a
+// client that passes back the highest id it has seen never misses an item.
+// X-Next-Since carries that id too, even when filters leave a page empty.
+const (
+	pollDefaultLimit = 25
+	pollMaxLimit     = 100
+)
+
+// pollFeeds are the tables behind each feed
+var pollFeeds = map[string]string{
+	"detections": "uploads",
+	"alerts":     "alerts",
+	"events":     "events",
+}
+
+// PollDetection is an upload that heard something new
+type PollDetection struct {
+	ID             int64          `json:"id"`
+	DeviceID       string         `json:"device_id"`
+	Timestamp      time.Time      `json:"timestamp"`
+	Detections     int            `json:"detections"`      // New since the device's previous upload
+	Categories     map[string]int `json:"categories"`      // The same, by category
+	FreqDetections []int          `json:"freq_detections"` // The same, by frequency
+}
+
+// PollAlert is a raised alert
+type PollAlert struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+}
+
+// PollEvent is one detection reported to /events
+type PollEvent struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Frequency string    `json:"frequency_mhz"`
+	Category  string    `json:"category"`
+	RSSI      *float64  `json:"rssi,omitempty"`
+}
+
+// PollQuery is a parsed poll request
+type PollQuery struct {
+	Since     int64 // 0 for the newest items
+	HasSince  bool
+	Limit     int
+	DeviceIDs []string // nil for every device
+	Category  string
+}
+
+// pollWindow picks the ids a page covers: the limit rows after since, or the
+// newest limit rows. Filters that can't be expressed in SQL are applied to the
+// window afterwards, so the cursor still moves past rows they drop.
+func (s *Store) pollWindow(ctx context.Context, table string, q PollQuery) (lo, hi int64, ok bool) {
+	cond, args := deviceFilter(q.DeviceIDs)
+	order := "id DESC"
+	if q.HasSince {
+		cond += " AND id > ?"
+		args = append(args, q.Since)
+		order = "id"
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var first, last sql.NullInt64
+	err := s.queryRow(ctx, `
+		SELECT MIN(id), MAX(id) FROM (SELECT id FROM `+table+` WHERE `+cond+` ORDER BY `+order+` LIMIT ?)
+	`, append(args, q.Limit)...).Scan(&first, &last)
+	if err != nil {
+		log.Printf("Error polling %s: %v", table, err)
+		return 0, 0, false
+	}
+	return first.Int64, last.Int64, first.Valid
+}
+
+// windowFilter restricts a query aliased u to a window and the query's devices
+func windowFilter(q PollQuery, lo, hi int64) (string, []interface{}) {
+	cond, args := deviceFilter(q.DeviceIDs)
+	if q.DeviceIDs != nil {
+		cond = "u." + cond
+	}
+	return cond + " AND u.id BETWEEN ? AND ?", append(args, lo, hi)
+}
+
+// pollDetections returns uploads in the window with new detections (in the
+// category, if one is given)
+func (s *Store) pollDetections(ctx context.Context, q PollQuery, lo, hi int64) []PollDetection {
+	cond, args := windowFilter(q, lo, hi)
+	var out []PollDetection
+	for _, r := range s.searchUploads(ctx, cond, args, q.Category, 0) {
+		d := PollDetection{ID: r.ID, DeviceID: r.DeviceID, Timestamp: r.Timestamp,
+			FreqDetections: r.Detections, Categories: make(map[string]int, len(categoryInfo))}
+		for _, c := range categoryInfo {
+			d.Categories[c.Name] = categoryCount(r.Detections, c.Name)
+			d.Detections += d.Categories[c.Name]
+		}
+		if d.Detections > 0 {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// pollAlerts returns alerts in the window; with a category, only its
+// per-channel (baseline) alerts
+func (s *Store) pollAlerts(ctx context.Context, q PollQuery, lo, hi int64) []PollAlert {
+	cond, args := windowFilter(q, lo, hi)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT u.id, u.device_id, u.timestamp, u.kind, u.message FROM alerts u WHERE `+cond, args...)
+	if err != nil {
+		log.Printf("Error polling alerts: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var out []PollAlert
+	for rows.Next() {
+		var a PollAlert
+		var ts string
+		if err := rows.Scan(&a.ID, &a.DeviceID, &ts, &a.Kind, &a.Message); err != nil {
+			continue
+		}
+		if q.Category != "" && alertCategory(a.Kind) != q.Category {
+			continue
+		}
+		a.Timestamp = parseDBTime(ts)
+		out = append(out, a)
+	}
+	return out
+}
+
+// alertCategory is the category of a per-channel alert kind (baseline:MHz), or ""
+func alertCategory(kind string) string {
+	if mhz, ok := strings.CutPrefix(kind, "baseline:"); ok {
+		if fi, known := frequencyIndex(mhz); known {
+			return frequencyAt(fi).Category
+		}
+	}
+	return ""
+}
+
+// pollEvents returns detection events in the window
+func (s *Store) pollEvents(ctx context.Context, q PollQuery, lo, hi int64) []PollEvent {
+	cond, args := windowFilter(q, lo, hi)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT u.id, u.device_id, u.timestamp, u.freq_index, u.rssi FROM events u WHERE `+cond, args...)
+	if err != nil {
+		log.Printf("Error polling events: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var out []PollEvent
+	for rows.Next() {
+		var e PollEvent
+		var ts string
+		var fi int
+		var rssi sql.NullFloat64
+		if err := rows.Scan(&e.ID, &e.DeviceID, &ts, &fi, &rssi); err != nil || fi < 0 || fi >= len(frequencies()) {
+			continue
+		}
+		if q.Category != "" && frequencyAt(fi).Category != q.Category {
+			continue
+		}
+		e.Timestamp = parseDBTime(ts)
+		e.Frequency, e.Category = frequencyAt(fi).MHz, frequencyAt(fi).Category
+		if rssi.Valid {
+			e.RSSI = &rssi.Float64
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// pollQueryFrom reads since, limit, device or group, and category
+func pollQueryFrom(ctx context.Context, r *http.Request) (PollQuery, int, error) {
+	v := r.URL.Query()
+	q := PollQuery{Limit: pollDefaultLimit, Category: v.Get("category")}
+	if s := v.Get("since"); s != "" {
+		since, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			return q, http.StatusBadRequest, errors.New("since is the highest id already seen")
+		}
+		q.Since, q.HasSince = since, true
+	}
+	if n, err := strconv.Atoi(v.Get("limit")); err == nil && n > 0 {
+		q.Limit = min(n, pollMaxLimit)
+	}
+	if _, ok := categoryByName(q.Category); q.Category != "" && !ok {
+		return q, http.StatusBadRequest, errors.New("unknown category")
+	}
+	if device := v.Get("device"); device != "" {
+		q.DeviceIDs = []string{device}
+	} else if group := v.Get("group"); group != "" {
+		if q.DeviceIDs = store.getGroupDevices(ctx, group); q.DeviceIDs == nil {
+			return q, http.StatusNotFound, errors.New("unknown group")
+		}
+	}
+	return q, 0, nil
+}
+
+// pollOrder sorts a page oldest first when following a cursor, newest first otherwise
+func pollOrder[T any](items []T, id func(T) int64, ascending bool) []T {
+	slices.SortFunc(items, func(a, b T) int {
+		if ascending {
+			return cmp.Compare(id(a), id(b))
+		}
+		return cmp.Compare(id(b), id(a))
+	})
+	if items == nil {
+		items = []T{}
+	}
+	return items
+}
+
+// handleAPIPoll serves a polling feed:
+// GET /api/poll/{detections,alerts,events}?since=ID&limit=25&device=ID&category=NAME
+func handleAPIPoll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	feed := r.PathValue("feed")
+	table, ok := pollFeeds[feed]
+	if !ok {
+		http.Error(w, "feed must be detections, alerts or events", http.StatusNotFound)
+		return
+	}
+	q, status, err := pollQueryFrom(ctx, r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	next := q.Since
+	var items interface{} = []struct{}{}
+	if lo, hi, ok := store.pollWindow(ctx, table, q); ok {
+		next = max(next, hi)
+		switch feed {
+		case "detections":
+			items = pollOrder(store.pollDetections(ctx, q, lo, hi), func(d PollDetection) int64 { return d.ID }, q.HasSince)
+		case "alerts":
+			items = pollOrder(store.pollAlerts(ctx, q, lo, hi), func(a PollAlert) int64 { return a.ID }, q.HasSince)
+		case "events":
+			items = pollOrder(store.pollEvents(ctx, q, lo, hi), func(e PollEvent) int64 { return e.ID }, q.HasSince)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Next-Since", strconv.FormatInt(next, 10))
+	json.NewEncoder(w).Encode(items)
+}
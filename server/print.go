@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// printMaxDays caps /print?period=; a year of daily bars still fits on a page
+const printMaxDays = 365
+
+// printAlerts is how many of the period's alerts the report lists
+const printAlerts = 20
+
+// printCSS is black on white with A4/Letter-friendly margins. Charts keep the
+// frequency colours, and each section stays on one page where it can.
+const printCSS = `        * { box-sizing: border-box; }
+        @page { margin: 15mm; }
+        body { font-family: Georgia, 'Times New Roman', serif; color: #000; background: #fff; margin: 0 auto; padding: 20px; max-width: 800px; font-size: 11pt; }
+        h1 { font-size: 18pt; margin: 0 0 4px 0; }
+        h2 { font-size: 13pt; border-bottom: 1px solid #000; padding-bottom: 2px; margin: 24px 0 8px 0; }
+        .meta { color: #333; font-size: 9pt; margin: 0; }
+        section { break-inside: avoid; page-break-inside: avoid; }
+        table { width: 100%; border-collapse: collapse; font-size: 10pt; }
+        th, td { border-bottom: 1px solid #bbb; padding: 3px 6px; text-align: left; }
+        td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+        .chart { display: block; width: 100%; border: 1px solid #bbb; }
+        .axis { display: flex; justify-content: space-between; font-size: 8pt; color: #333; }
+        .key { font-size: 8pt; margin-top: 4px; }
+        .key span { display: inline-block; width: 10px; height: 10px; margin: 0 3px 0 10px; vertical-align: middle; -webkit-print-color-adjust: exact; print-color-adjust: exact; }
+        svg rect { -webkit-print-color-adjust: exact; print-color-adjust: exact; }
+        .screen-only { margin-top: 30px; font-family: system-ui, sans-serif; font-size: 10pt; }
+        @media print { .screen-only { display: none; } body { padding: 0; max-width: none; } }
+`
+
+// parsePrintPeriod reads a period such as 30d (or plain 30) as a number of days
+func parsePrintPeriod(s string) (int, error) {
+	if s == "" {
+		return 30, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || days < 1 || days > printMaxDays {
+		return 0, fmt.Errorf("period must be 1d to %dd", printMaxDays)
+	}
+	return days, nil
+}
+
+// handlePrint renders a printable report of the last period's summary and charts:
+// GET /print?period=30d with optional device=ID or group=NAME
+func handlePrint(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days, err := parsePrintPeriod(r.URL.Query().Get("period"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scope := "All detectors"
+	var deviceIDs []string
+	if device := r.URL.Query().Get("device"); device != "" {
+		deviceIDs = []string{device}
+		scope = "Detector " + device
+	} else if group := r.URL.Query().Get("group"); group != "" {
+		if deviceIDs = store.getGroupDevices(ctx, group); deviceIDs == nil {
+			http.Error(w, "Unknown group", http.StatusNotFound)
+			return
+		}
+		scope = "Group " + group
+	}
+
+	loc := store.locationFor(ctx, deviceIDs)
+	summary := store.getSummary(ctx, days, deviceIDs...)
+	activity := store.getActivityBuckets(ctx, deviceIDs, days, loc)
+	reportIDs := deviceIDs
+	if reportIDs == nil {
+		for id := range activity.Devices {
+			reportIDs = append(reportIDs, id)
+		}
+		sort.Strings(reportIDs)
+	}
+	var availability []Availability
+	for _, id := range reportIDs {
+		availability = append(availability, store.getAvailability(ctx, store.getDevice(ctx, id), "day", days))
+	}
+	since := timeNow().In(loc).AddDate(0, 0, -(days - 1))
+	since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, loc)
+	var alerts []Alert
+	for _, a := range store.getRecentAlerts(ctx, printAlerts, deviceIDs...) {
+		if !a.Timestamp.Before(since) {
+			alerts = append(alerts, a)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>LoRa Detector Report · %s · %d days</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+%s    </style>
+</head>
+<body>
+<h1>LoRa Detector Report</h1>
+<p class="meta">%s · last %d days (%s – %s, %s) · generated %s</p>
+`, html.EscapeString(scope), days, printCSS, html.EscapeString(scope), days,
+		since.Format("Jan 2, 2006"), timeNow().In(loc).Format("Jan 2, 2006"), html.EscapeString(activity.Timezone),
+		timeNow().In(loc).Format("Jan 2, 2006 15:04"))
+
+	fmt.Fprintf(w, `<section>
+<h2>Summary</h2>
+<table>
+    <tr><th>Uploads</th><td class="num">%d</td></tr>
+    <tr><th>Detections</th><td class="num">%d</td></tr>
+    <tr><th>Scan time</th><td class="num">%dh %dm</td></tr>
+    <tr><th>Average detections per minute</th><td class="num">%.1f</td></tr>
+    <tr><th>Average activity</th><td class="num">%.1f%%</td></tr>
+    <tr><th>Peak activity</th><td class="num">%d%%</td></tr>
+</table>
+</section>
+`, summary.TotalUploads, summary.TotalDetections, summary.TotalScanTime/3600, (summary.TotalScanTime%3600)/60,
+		summary.AvgDetPerMin, summary.AvgActivity, summary.PeakActivity)
+
+	total := 0
+	for _, c := range summary.FreqTotals {
+		total += c
+	}
+	fmt.Fprintf(w, `<section>
+<h2>Detections by Frequency</h2>
+<table>
+    <tr><th>MHz</th><th>Channel</th><th>Category</th><th class="num">Detections</th><th class="num">Share</th></tr>
+`)
+	for i, f := range frequencies() {
+		if !f.Scanned() {
+			continue
+		}
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(summary.FreqTotals[i]) / float64(total)
+		}
+		fmt.Fprintf(w, `    <tr><td>%s</td><td>%s</td><td>%s</td><td class="num">%d</td><td class="num">%.1f%%</td></tr>
+`, f.MHz, html.EscapeString(f.Label), html.EscapeString(f.Category), summary.FreqTotals[i], share)
+	}
+	fmt.Fprintf(w, `</table>
+</section>
+`)
+
+	fmt.Fprintf(w, `<section>
+<h2>Detections per Day</h2>
+`)
+	renderStackedBars(w, activity.Daily, 600, 120)
+	if len(activity.Days) > 0 {
+		fmt.Fprintf(w, `
+<div class="axis"><span>%s</span><span>%s</span></div>
+`, activity.Days[0], activity.Days[len(activity.Days)-1])
+	}
+	writePrintKey(w)
+	fmt.Fprintf(w, `</section>
+<section>
+<h2>Detections by Hour of Day</h2>
+`)
+	renderStackedBars(w, activity.Hourly, 600, 120)
+	fmt.Fprintf(w, `
+<div class="axis"><span>00:00</span><span>12:00</span><span>23:00</span></div>
+`)
+	writePrintKey(w)
+	fmt.Fprintf(w, `</section>
+`)
+
+	if len(availability) > 0 {
+		fmt.Fprintf(w, `<section>
+<h2>Detectors</h2>
+<table>
+    <tr><th>Detector</th><th class="num">Detections</th><th class="num">Uploads</th><th class="num">Expected</th><th class="num">Availability</th></tr>
+`)
+		for _, a := range availability {
+			detections := 0
+			for _, c := range activity.Devices[a.DeviceID] {
+				detections += c
+			}
+			pct := "–"
+			if a.AvailabilityPct != nil {
+				pct = fmt.Sprintf("%.1f%%", *a.AvailabilityPct)
+			}
+			fmt.Fprintf(w, `    <tr><td>%s</td><td class="num">%d</td><td class="num">%d</td><td class="num">%.0f</td><td class="num">%s</td></tr>
+`, html.EscapeString(a.DeviceID), detections, a.Received, a.Expected, pct)
+		}
+		fmt.Fprintf(w, `</table>
+</section>
+`)
+	}
+
+	if len(alerts) > 0 {
+		fmt.Fprintf(w, `<section>
+<h2>Recent Alerts</h2>
+<table>
+    <tr><th>Time</th><th>Detector</th><th>Alert</th></tr>
+`)
+		for _, a := range alerts {
+			fmt.Fprintf(w, `    <tr><td>%s</td><td>%s</td><td>%s</td></tr>
+`, a.Timestamp.In(loc).Format("Jan 2 15:04"), html.EscapeString(a.DeviceID), html.EscapeString(a.Message))
+		}
+		fmt.Fprintf(w, `</table>
+</section>
+`)
+	}
+
+	filter := url.Values{}
+	for _, k := range []string{"device", "group"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			filter.Set(k, v)
+		}
+	}
+	fmt.Fprintf(w, `<p class="screen-only"><button onclick="window.print()">🖨️ Print or save as PDF</button> · Period:`)
+	for _, d := range []int{7, 30, 90, 365} {
+		filter.Set("period", fmt.Sprintf("%dd", d))
+		fmt.Fprintf(w, ` <a href="/print?%s">%dd</a>`, html.EscapeString(filter.Encode()), d)
+	}
+	fmt.Fprintf(w, ` · <a href="/">← Dashboard</a></p>
+</body>
+</html>`)
+}
+
+// writePrintKey writes the colour key for the frequency-coloured charts
+func writePrintKey(w io.Writer) {
+	fmt.Fprintf(w, `<div class="key">`)
+	for _, f := range frequencies() {
+		if !f.Scanned() {
+			continue
+		}
+		fmt.Fprintf(w, `<span style="background: %s;"></span>%s`, f.Color, f.MHz)
+	}
+	fmt.Fprintf(w, "</div>\n")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Community instances shared publicly can keep uploaders' addresses out of the
+// database (IP_ANONYMIZE). New uploads are anonymized as they arrive, and rows
+// stored before the setting was turned on are scrubbed when the server starts.
+const (
+	ipKeep     = ""
+	ipTruncate = "truncate" // Zero the host part: IPv4 to /24, IPv6 to /48
+	ipHash     = "hash"     // Keyed hash, so one uploader's rows still group together
+	ipDrop     = "drop"     // Store no address at all
+)
+
+// ipPrivacy is the configured IP_ANONYMIZE mode
+var ipPrivacy string
+
+// hashedIPPrefix marks an address replaced by its hash
+const hashedIPPrefix = "anon-"
+
+// ipScrubBatch is how many uploads each scrub transaction rewrites
+const ipScrubBatch = 5000
+
+// ipPrivacyFromEnv reads IP_ANONYMIZE
+func ipPrivacyFromEnv() (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("IP_ANONYMIZE"))); mode {
+	case ipKeep, "off", "false":
+		return ipKeep, nil
+	case ipTruncate, ipHash, ipDrop:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("IP_ANONYMIZE must be %s, %s or %s", ipTruncate, ipHash, ipDrop)
+	}
+}
+
+// ipHashSalt keys address hashes. Without IP_HASH_SALT it is random for each
+// run, so hashes can't be linked across restarts (or reversed by hashing every
+// IPv4 address).
+var ipHashSalt = sync.OnceValue(func() []byte {
+	if salt := os.Getenv("IP_HASH_SALT"); salt != "" {
+		return []byte(salt)
+	}
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+})
+
+// anonymizeIP applies ipPrivacy to an uploader address as the transports record
+// it: "host:port", or prefixed with "udp:" or "coap:". Serial port paths and
+// addresses already anonymized are left alone.
+func anonymizeIP(uploader string) string {
+	if ipPrivacy == ipKeep || uploader == "" {
+		return uploader
+	}
+	transport, addr := "", uploader
+	if i := strings.Index(uploader, ":"); i > 0 && (uploader[:i] == "udp" || uploader[:i] == "coap" || uploader[:i] == "serial") {
+		transport, addr = uploader[:i+1], uploader[i+1:]
+	}
+	if transport == "serial:" {
+		return uploader
+	}
+	if ipPrivacy == ipDrop {
+		return ""
+	}
+
+	ip, ok := parseUploaderIP(addr)
+	if !ok {
+		return uploader // Already anonymized, or not an IP (unix socket peers)
+	}
+	ip = ip.Unmap()
+	switch ipPrivacy {
+	case ipTruncate:
+		bits := 24
+		if ip.Is6() {
+			bits = 48
+		}
+		prefix, _ := ip.Prefix(bits)
+		return transport + prefix.Addr().String()
+	default:
+		mac := hmac.New(sha256.New, ipHashSalt())
+		mac.Write(ip.AsSlice())
+		return transport + hashedIPPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+}
+
+// parseUploaderIP reads an address with or without a port
+func parseUploaderIP(addr string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr(), true
+	}
+	ip, err := netip.ParseAddr(addr)
+	return ip, err == nil
+}
+
+// scrubUploaderIPs anonymizes addresses stored before IP_ANONYMIZE was set,
+// a batch per transaction so uploads keep flowing. Rows already in the
+// configured form are left as they are, so after the first run this only reads.
+func (s *Store) scrubUploaderIPs(ctx context.Context) {
+	if ipPrivacy == ipKeep || s.readOnly {
+		return
+	}
+	var lastID int64
+	scrubbed := 0
+	for {
+		n, next, err := s.scrubUploaderIPBatch(ctx, lastID)
+		if err != nil {
+			log.Printf("Error scrubbing uploader IPs: %v", err)
+			return
+		}
+		if next == lastID {
+			break
+		}
+		scrubbed += n
+		lastID = next
+	}
+	if scrubbed > 0 {
+		log.Printf("Anonymized (%s) the uploader IP of %d stored uploads", ipPrivacy, scrubbed)
+		s.loadLatest(ctx) // The dashboard's cached uploads still have the old addresses
+	}
+}
+
+// scrubUploaderIPBatch rewrites the uploads after lastID that need it,
+// returning how many changed and the last ID it looked at
+func (s *Store) scrubUploaderIPBatch(ctx context.Context, lastID int64) (int, int64, error) {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, uploader_ip FROM uploads
+		WHERE id > ? AND uploader_ip IS NOT NULL AND uploader_ip != ''
+		ORDER BY id LIMIT ?
+	`, lastID, ipScrubBatch)
+	if err != nil {
+		return 0, lastID, err
+	}
+	changes := make(map[int64]string)
+	next := lastID
+	for rows.Next() {
+		var id int64
+		var ip string
+		if err := rows.Scan(&id, &ip); err != nil {
+			rows.Close()
+			return 0, lastID, err
+		}
+		next = id
+		if anon := anonymizeIP(ip); anon != ip {
+			changes[id] = anon
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(changes) == 0 {
+		return 0, next, err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, lastID, err
+	}
+	defer tx.Rollback()
+	for id, anon := range changes {
+		if _, err := tx.ExecContext(ctx, `UPDATE uploads SET uploader_ip = ? WHERE id = ?`, anon, id); err != nil {
+			return 0, lastID, err
+		}
+	}
+	return len(changes), next, tx.Commit()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Behind an authenticating reverse proxy (Authelia, oauth2-proxy, Authentik's
+// proxy outpost) the proxy signs users in and passes their name and groups in
+// request headers. Those headers are only believed from TRUSTED_PROXIES; from
+// anywhere else they are ignored, since any client could send them.
+var proxy *proxyAuth
+
+// proxyUserHeaders and proxyGroupsHeaders are tried in order when no header is
+// configured: Authelia's, then oauth2-proxy's
+var (
+	proxyUserHeaders   = []string{"Remote-User", "X-Forwarded-User"}
+	proxyGroupsHeaders = []string{"Remote-Groups", "X-Forwarded-Groups"}
+)
+
+// proxyAuth trusts identity headers from configured proxy addresses
+type proxyAuth struct {
+	trusted      []netip.Prefix
+	trustUnix    bool // Trust peers on a unix socket listener
+	userHeaders  []string
+	groupHeaders []string
+	adminGroups  map[string]bool
+	clientHeader string // Platform header naming the client, e.g. Fly-Client-IP
+}
+
+// proxyAuthFromEnv configures header trust from TRUSTED_PROXIES, returning nil
+// if it is not set
+func proxyAuthFromEnv() (*proxyAuth, error) {
+	list := os.Getenv("TRUSTED_PROXIES")
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	p := &proxyAuth{
+		userHeaders:  proxyUserHeaders,
+		groupHeaders: proxyGroupsHeaders,
+		adminGroups:  groupSet(os.Getenv("PROXY_ADMIN_GROUPS")),
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == "unix":
+			p.trustUnix = true
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			p.trusted = append(p.trusted, prefix.Masked())
+		default:
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			p.trusted = append(p.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	if h := os.Getenv("PROXY_USER_HEADER"); h != "" {
+		p.userHeaders = []string{h}
+	}
+	if h := os.Getenv("PROXY_GROUPS_HEADER"); h != "" {
+		p.groupHeaders = []string{h}
+	}
+	p.clientHeader = strings.TrimSpace(os.Getenv("PROXY_CLIENT_HEADER"))
+	return p, nil
+}
+
+// fromTrustedProxy reports whether the request's peer is a trusted proxy
+func (p *proxyAuth) fromTrustedProxy(r *http.Request) bool {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return p.trustUnix // Unix socket peers have no IP address
+	}
+	return p.trustedAddr(ap.Addr().Unmap())
+}
+
+// trustedAddr reports whether addr is in TRUSTED_PROXIES
+func (p *proxyAuth) trustedAddr(addr netip.Addr) bool {
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr is the address of the client a trusted proxy forwarded the
+// request for: PROXY_CLIENT_HEADER if configured and set, otherwise the last
+// X-Forwarded-For hop that isn't itself a trusted proxy, since earlier hops are
+// whatever the client sent. Platform headers such as Fly-Client-IP are only
+// read when configured, because a proxy that doesn't set one passes along
+// whatever the client sent. It reports false when neither names a valid address.
+func (p *proxyAuth) clientAddr(r *http.Request) (netip.Addr, bool) {
+	if p.clientHeader != "" {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(p.clientHeader))); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if addr = addr.Unmap(); !p.trustedAddr(addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// firstHeader returns the first of the headers that is set
+func firstHeader(r *http.Request, names []string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(r.Header.Get(name)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// identity returns the user a trusted proxy signed in and their role: a local
+// user's own role, otherwise admin for PROXY_ADMIN_GROUPS and viewer for the rest
+func (p *proxyAuth) identity(ctx context.Context, r *http.Request) (string, string, bool) {
+	if p == nil || !p.fromTrustedProxy(r) {
+		return "", "", false
+	}
+	user := firstHeader(r, p.userHeaders)
+	if user == "" {
+		return "", "", false
+	}
+	if role := store.userRole(ctx, user); role != "" {
+		return user, role, true
+	}
+	for _, g := range strings.Split(firstHeader(r, p.groupHeaders), ",") {
+		if p.adminGroups[strings.TrimPrefix(strings.TrimSpace(g), "/")] {
+			return user, roleAdmin, true
+		}
+	}
+	return user, roleViewer, true
+}
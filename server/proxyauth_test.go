@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// The proxy tests check which address a request is counted against: headers
+// naming the client are only believed from TRUSTED_PROXIES, and then only the
+// hops a trusted proxy added, so a client can't pick the address it is banned
+// or rate limited by.
+
+func TestProxyAuthFromEnv(t *testing.T) {
+	tests := []struct {
+		name, proxies string
+		wantNil       bool
+		wantErr       bool
+	}{
+		{"unset", "", true, false},
+		{"addresses and prefixes", "10.0.0.1, 192.168.0.0/16,unix", false, false},
+		{"bad address", "10.0.0.300", false, true},
+		{"bad prefix", "10.0.0.0/33", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			p, err := proxyAuthFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (p == nil) != tt.wantNil {
+				t.Errorf("proxyAuth = %+v, want nil %v", p, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	saved := proxy
+	t.Cleanup(func() { proxy = saved })
+
+	tests := []struct {
+		name         string
+		clientHeader string // PROXY_CLIENT_HEADER
+		peer         string
+		headers      map[string][]string
+		want         string // "" when no client can be named
+	}{
+		{"untrusted peer's forwarded header ignored", "", "203.0.113.7:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"untrusted peer's platform header ignored", "Fly-Client-IP", "203.0.113.7:5000",
+			map[string][]string{"Fly-Client-IP": {"198.51.100.1"}}, "203.0.113.7"},
+		{"last hop from the proxy", "", "10.0.0.1:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.9"}}, "203.0.113.9"},
+		{"spoofed hops before trusted ones", "", "10.0.0.1:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.9", "10.0.0.2"}}, "203.0.113.9"},
+		{"every hop trusted", "", "10.0.0.1:5000",
+			map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, ""},
+		{"unparseable hop", "", "10.0.0.1:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, not-an-ip"}}, ""},
+		{"no forwarded header", "", "10.0.0.1:5000", nil, ""},
+		{"mapped IPv4 hop", "", "10.0.0.1:5000",
+			map[string][]string{"X-Forwarded-For": {"::ffff:203.0.113.9"}}, "203.0.113.9"},
+		{"unconfigured platform header ignored", "", "10.0.0.1:5000",
+			map[string][]string{"Fly-Client-IP": {"198.51.100.1"}, "X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"configured platform header", "Fly-Client-IP", "10.0.0.1:5000",
+			map[string][]string{"Fly-Client-IP": {"198.51.100.1"}, "X-Forwarded-For": {"203.0.113.9"}}, "198.51.100.1"},
+		{"invalid platform header falls back", "Fly-Client-IP", "10.0.0.1:5000",
+			map[string][]string{"Fly-Client-IP": {"bogus"}, "X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"trusted unix socket peer", "", "@",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,unix")
+			t.Setenv("PROXY_CLIENT_HEADER", tt.clientHeader)
+			p, err := proxyAuthFromEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy = p
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			got := ""
+			if addr, ok := clientIP(r); ok {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbuseIP(t *testing.T) {
+	saved := proxy
+	t.Cleanup(func() { proxy = saved })
+	proxy = nil
+
+	tests := []struct {
+		peer string
+		want netip.Addr
+		ok   bool
+	}{
+		{"203.0.113.7:5000", netip.MustParseAddr("203.0.113.7"), true},
+		{"[2001:db8::1]:5000", netip.MustParseAddr("2001:db8::1"), true},
+		{"127.0.0.1:5000", netip.Addr{}, false},
+		{"[::1]:5000", netip.Addr{}, false},
+		{"@", netip.Addr{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.peer, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			if got, ok := abuseIP(r); got != tt.want || ok != tt.ok {
+				t.Errorf("abuseIP = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// pwaHead links the manifest and registers the service worker; every page includes it
+const pwaHead = `    <link rel="manifest" href="/manifest.webmanifest">
+    <link rel="icon" href="/icon.svg" type="image/svg+xml">
+    <meta name="theme-color" content="#1a1a2e">
+    <script>if ('serviceWorker' in navigator) navigator.serviceWorker.register('/sw.js');</script>
+`
+
+// serviceWorkerJS caches every page and API response it sees (network first),
+// so the last-seen data stays viewable when the server is unreachable.
+// Bump the cache name when the caching rules change.
+const serviceWorkerJS = `const CACHE = 'lora-detector-v1';
+
+self.addEventListener('install', () => self.skipWaiting());
+
+self.addEventListener('activate', event => {
+    event.waitUntil(caches.keys()
+        .then(keys => Promise.all(keys.filter(k => k !== CACHE).map(k => caches.delete(k))))
+        .then(() => self.clients.claim()));
+});
+
+self.addEventListener('fetch', event => {
+    const req = event.request;
+    if (req.method !== 'GET' || new URL(req.url).origin !== self.location.origin ||
+        req.headers.get('Accept') === 'text/event-stream') {
+        return;
+    }
+    event.respondWith(fetch(req).then(resp => {
+        if (resp.ok) {
+            const copy = resp.clone();
+            caches.open(CACHE).then(cache => cache.put(req, copy));
+        }
+        return resp;
+    }).catch(() => caches.match(req).then(cached =>
+        cached || (req.mode === 'navigate' ? caches.match('/') : undefined) ||
+        new Response('Offline and nothing cached yet', {status: 503, headers: {'Content-Type': 'text/plain'}}))));
+});
+`
+
+// appIcon is a simple antenna glyph; SVG scales to every size the manifest needs
+const appIcon = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 512 512">
+<rect width="512" height="512" rx="96" fill="#1a1a2e"/>
+<g fill="none" stroke="#00d4ff" stroke-width="28" stroke-linecap="round">
+<path d="M176 176a112 112 0 0 0 0 160M336 176a112 112 0 0 1 0 160"/>
+<path d="M120 120a192 192 0 0 0 0 272M392 120a192 192 0 0 1 0 272"/>
+<path d="M256 256v184"/>
+</g>
+<circle cx="256" cy="256" r="36" fill="#00d4ff"/>
+</svg>
+`
+
+// handleManifest serves the web app manifest: GET /manifest.webmanifest
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	l := requestLang(w, r)
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":             l.T("LoRa Detector Dashboard"),
+		"short_name":       "LoRa",
+		"start_url":        "/",
+		"display":          "standalone",
+		"background_color": "#1a1a2e",
+		"theme_color":      "#1a1a2e",
+		"icons": []map[string]string{
+			{"src": "/icon.svg", "sizes": "any", "type": "image/svg+xml", "purpose": "any maskable"},
+		},
+	})
+}
+
+// handleServiceWorker serves the offline-caching service worker: GET /sw.js
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Header().Set("Cache-Control", "no-cache") // so updates are picked up promptly
+	io.WriteString(w, serviceWorkerJS)
+}
+
+// handleIcon serves the app icon: GET /icon.svg
+func handleIcon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	io.WriteString(w, appIcon)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A quarantined detector's uploads and events are still accepted, so a
+// misbehaving device doesn't retry them in a loop and a spoofer isn't told,
+// but they are held in the quarantine table as they arrived instead of being
+// stored. Nothing held reaches dashboards, aggregates, the live stream, MQTT
+// or webhooks, and the detector isn't reported offline while quarantined.
+// What it stored before being quarantined is left as it was.
+//
+// Releasing the detector merges what was held, as an import of a raw log
+// would (see import.go): the channel maps configured then are applied and
+// uploads already present are skipped. Uploads relayed by a bridge had the
+// relay's channel maps applied before they were sent, so they are merged as
+// they are (see relay.go). Held data can be discarded instead.
+const (
+	heldUpload  = "upload"
+	heldRelayed = "relayed"
+	heldEvents  = "events"
+)
+
+// quarantineListMax bounds how many held payloads /api/quarantine lists
+const quarantineListMax = 500
+
+// HeldUpload is one payload held from a quarantined detector, as it arrived
+type HeldUpload struct {
+	ID       int64           `json:"id"`
+	DeviceID string          `json:"device_id"`
+	Kind     string          `json:"kind"` // upload, relayed or events
+	Received time.Time       `json:"received"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// ReleaseResult is what releasing a detector merged
+type ReleaseResult struct {
+	ImportResult
+	Events int `json:"events"` // Detections from held event uploads
+}
+
+// setDeviceQuarantined quarantines a detector from now, keeping the time it
+// was first quarantined if it already is
+func (s *Store) setDeviceQuarantined(ctx context.Context, deviceID string) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, quarantined) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET quarantined = COALESCE(quarantined, excluded.quarantined)
+	`, deviceID, timeNow().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// quarantinedDevices returns the detectors currently quarantined
+func (s *Store) quarantinedDevices(ctx context.Context) map[string]bool {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	ids := make(map[string]bool)
+	rows, err := s.query(ctx, `SELECT device_id FROM devices WHERE quarantined IS NOT NULL`)
+	if err != nil {
+		log.Printf("Error loading quarantined detectors: %v", err)
+		return ids
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// holdUpload keeps a payload from a quarantined detector
+func (s *Store) holdUpload(ctx context.Context, deviceID, kind string, received time.Time, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err = s.exec(ctx, `
+		INSERT INTO quarantine (device_id, kind, received, body) VALUES (?, ?, ?, ?)
+	`, deviceID, kind, received.Format("2006-01-02 15:04:05"), string(body))
+	if err == nil {
+		log.Printf("Held %s from quarantined %s", kind, deviceID)
+	}
+	return err
+}
+
+// getHeld returns a detector's held payloads (every detector's when
+// deviceID is ""), oldest first, up to limit
+func (s *Store) getHeld(ctx context.Context, deviceID string, limit int) ([]HeldUpload, error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, device_id, kind, received, body FROM quarantine
+		WHERE ? = '' OR device_id = ?
+		ORDER BY id LIMIT ?
+	`, deviceID, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var held []HeldUpload
+	for rows.Next() {
+		var h HeldUpload
+		var received, body string
+		if err := rows.Scan(&h.ID, &h.DeviceID, &h.Kind, &received, &body); err != nil {
+			return nil, err
+		}
+		h.Received = parseDBTime(received)
+		h.Body = json.RawMessage(body)
+		held = append(held, h)
+	}
+	return held, rows.Err()
+}
+
+// heldCounts counts each detector's held payloads
+func (s *Store) heldCounts(ctx context.Context) map[string]int {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	counts := make(map[string]int)
+	rows, err := s.query(ctx, `SELECT device_id, COUNT(*) FROM quarantine GROUP BY device_id`)
+	if err != nil {
+		log.Printf("Error counting held uploads: %v", err)
+		return counts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var n int
+		if rows.Scan(&id, &n) == nil {
+			counts[id] = n
+		}
+	}
+	return counts
+}
+
+// releaseDevice takes a detector out of quarantine and merges what was held.
+// New uploads are stored as usual from the start, so none are caught between
+// the two. Each held payload is deleted once merged, so a release that fails
+// part way can be retried without counting anything twice.
+func (s *Store) releaseDevice(ctx context.Context, deviceID string) (ReleaseResult, error) {
+	var res ReleaseResult
+	if err := func() error {
+		ctx, cancel := dbContext(ctx, dbWriteTimeout)
+		defer cancel()
+		_, err := s.exec(ctx, `UPDATE devices SET quarantined = NULL WHERE device_id = ?`, deviceID)
+		return err
+	}(); err != nil {
+		return res, err
+	}
+	held, err := s.getHeld(ctx, deviceID, -1)
+	if err != nil {
+		return res, err
+	}
+	forget := func(kind string, upTo int64) error {
+		ctx, cancel := dbContext(ctx, dbWriteTimeout)
+		defer cancel()
+		_, err := s.exec(ctx, `DELETE FROM quarantine WHERE device_id = ? AND kind = ? AND id <= ?`, deviceID, kind, upTo)
+		return err
+	}
+
+	// Uploads in one merge, which skips any already present
+	var uploads bytes.Buffer
+	var lastUpload int64
+	for _, h := range held {
+		if h.Kind == heldUpload {
+			uploads.Write(h.Body)
+			uploads.WriteByte('\n')
+			lastUpload = h.ID
+		}
+	}
+	if uploads.Len() > 0 {
+		if res.ImportResult, err = s.importNDJSON(ctx, &uploads); err != nil {
+			return res, err
+		}
+		if err := forget(heldUpload, lastUpload); err != nil {
+			return res, err
+		}
+	}
+	var relayed []Stats
+	var lastRelayed int64
+	for _, h := range held {
+		if h.Kind != heldRelayed {
+			continue
+		}
+		var st Stats
+		if err := json.Unmarshal(h.Body, &st); err != nil {
+			log.Printf("Skipping held relayed upload %d from %s: %v", h.ID, deviceID, err)
+		} else {
+			relayed = append(relayed, st)
+		}
+		lastRelayed = h.ID
+	}
+	if lastRelayed > 0 {
+		merged, err := s.mergeRelayed(ctx, relayed)
+		if err != nil {
+			return res, err
+		}
+		res.Imported += merged.Imported
+		res.Skipped += merged.Skipped
+		if err := forget(heldRelayed, lastRelayed); err != nil {
+			return res, err
+		}
+	}
+
+	for _, h := range held {
+		if h.Kind != heldEvents {
+			continue
+		}
+		var up EventUpload
+		if err := json.Unmarshal(h.Body, &up); err != nil {
+			log.Printf("Skipping held events %d from %s: %v", h.ID, deviceID, err)
+		} else {
+			n, err := s.saveEvents(ctx, s.channelMap(ctx, deviceID).events(up), h.Received)
+			if err != nil {
+				return res, err
+			}
+			res.Events += n
+		}
+		if err := forget(heldEvents, h.ID); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// discardHeld deletes a detector's held payloads, returning how many
+func (s *Store) discardHeld(ctx context.Context, deviceID string) (int64, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	r, err := s.exec(ctx, `DELETE FROM quarantine WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+// handleAPIQuarantine lists held payloads (GET /api/quarantine, optionally
+// ?device=ID), quarantines or releases a detector (POST device=ID and
+// action=quarantine or release) or discards its held payloads
+// (POST action=discard). Admin only once an admin user exists.
+func handleAPIQuarantine(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		deviceID := r.URL.Query().Get("device")
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > quarantineListMax {
+				http.Error(w, fmt.Sprintf("limit must be 1-%d", quarantineListMax), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		held, err := store.getHeld(ctx, deviceID, limit)
+		if err != nil {
+			log.Printf("Error loading held uploads: %v", err)
+			http.Error(w, "Failed to load held uploads", http.StatusInternalServerError)
+			return
+		}
+		if held == nil {
+			held = []HeldUpload{}
+		}
+		devices := make(map[string]int)
+		counts := store.heldCounts(ctx)
+		for id := range store.quarantinedDevices(ctx) {
+			devices[id] = counts[id]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"devices": devices, // Quarantined detectors and how much each has held
+			"held":    held,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceID := r.FormValue("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	var resp interface{}
+	switch action := r.FormValue("action"); action {
+	case "quarantine":
+		if err := store.setDeviceQuarantined(ctx, deviceID); err != nil {
+			log.Printf("Error quarantining %s: %v", deviceID, err)
+			http.Error(w, "Failed to quarantine device", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Quarantined %s", deviceID)
+		resp = store.getDevice(ctx, deviceID)
+	case "release":
+		res, err := store.releaseDevice(ctx, deviceID)
+		if err != nil {
+			log.Printf("Error releasing %s: %v", deviceID, err)
+			http.Error(w, "Failed to release device", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Released %s: merged %d held uploads (%d already present) and %d event detections",
+			deviceID, res.Imported, res.Skipped, res.Events)
+		resp = res
+	case "discard":
+		n, err := store.discardHeld(ctx, deviceID)
+		if err != nil {
+			log.Printf("Error discarding held uploads from %s: %v", deviceID, err)
+			http.Error(w, "Failed to discard held uploads", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Discarded %d held payloads from %s", n, deviceID)
+		resp = map[string]int64{"discarded": n}
+	default:
+		http.Error(w, "action must be quarantine, release or discard", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// quarantineNote describes a quarantined detector on its page, or "" if it isn't
+func quarantineNote(ctx context.Context, d DeviceInfo) string {
+	if d.Quarantined == nil {
+		return ""
+	}
+	return fmt.Sprintf("Quarantined since %s: %d payloads held, left out of every chart and total until released",
+		d.Quarantined.Format("2006-01-02 15:04"), store.heldCounts(ctx)[d.DeviceID])
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query limits
+const (
+	queryMinBucket   = time.Minute
+	queryMaxBuckets  = 1440
+	queryDefaultSpan = time.Hour
+)
+
+// Query is the body of POST /api/query. Bucket and Window are Go durations
+// ("5m", "24h"); no devices means every known device.
+type Query struct {
+	Devices []string `json:"devices"`
+	Metrics []string `json:"metrics"`
+	Bucket  string   `json:"bucket"`
+	Window  string   `json:"window"`
+}
+
+// QueryResult holds one array per device and metric, each aligned with Times
+type QueryResult struct {
+	Start         int64                            `json:"start"`
+	BucketSeconds int64                            `json:"bucket_seconds"`
+	Times         []int64                          `json:"times"` // Bucket starts, epoch seconds
+	Devices       map[string]map[string][]*float64 `json:"devices"`
+}
+
+// queryBucket is one device's uploads within one bucket. Rates and activity
+// are summed, since rolled-up uploads only keep sums.
+type queryBucket struct {
+	uploads, detections, peak int
+	rate, activity            float64
+	freqs                     []int
+}
+
+// queryMetrics maps each metric name to its value for a bucket; nil means no
+// data, which only applies to averages and peaks (counts are 0)
+var queryMetrics = map[string]func(b *queryBucket) *float64{
+	"uploads":    func(b *queryBucket) *float64 { return queryValue(float64(b.uploads)) },
+	"detections": func(b *queryBucket) *float64 { return queryValue(float64(b.detections)) },
+	"rate":       func(b *queryBucket) *float64 { return queryAvg(b, b.rate) },
+	"activity":   func(b *queryBucket) *float64 { return queryAvg(b, b.activity) },
+	"peak": func(b *queryBucket) *float64 {
+		if b.uploads == 0 {
+			return nil
+		}
+		return queryValue(float64(b.peak))
+	},
+}
+
+// queryMetric returns a metric's function: one of queryMetrics, freq_N for
+// plan slot N, or a category total. Both follow the current frequency plan.
+func queryMetric(name string) func(b *queryBucket) *float64 {
+	if m := queryMetrics[name]; m != nil {
+		return m
+	}
+	if v, ok := strings.CutPrefix(name, "freq_"); ok {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(frequencies()) || v != strconv.Itoa(i) {
+			return nil
+		}
+		return func(b *queryBucket) *float64 { return queryValue(float64(countAt(b.freqs, i))) }
+	}
+	if !slices.ContainsFunc(frequencies(), func(f FrequencyInfo) bool { return f.Scanned() && f.Category == name }) {
+		return nil
+	}
+	return func(b *queryBucket) *float64 {
+		n := 0
+		for j, g := range frequencies() {
+			if g.Category == name {
+				n += countAt(b.freqs, j)
+			}
+		}
+		return queryValue(float64(n))
+	}
+}
+
+func queryValue(v float64) *float64 { return &v }
+
+func queryAvg(b *queryBucket, v float64) *float64 {
+	if b.uploads == 0 {
+		return nil
+	}
+	return queryValue(v / float64(b.uploads))
+}
+
+// queryMetricNames lists the metrics for error messages
+func queryMetricNames() string {
+	names := make([]string, 0, len(queryMetrics))
+	for name := range queryMetrics {
+		names = append(names, name)
+	}
+	for i, f := range frequencies() {
+		names = append(names, fmt.Sprintf("freq_%d", i))
+		if f.Scanned() && !slices.Contains(names, f.Category) {
+			names = append(names, f.Category)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// resolve checks the query and fills in defaults, returning the bucket size
+// and how many buckets the window covers
+func (q *Query) resolve() (time.Duration, int, error) {
+	bucket, window := time.Minute, queryDefaultSpan
+	var err error
+	if q.Bucket != "" {
+		if bucket, err = time.ParseDuration(q.Bucket); err != nil {
+			return 0, 0, fmt.Errorf("bad bucket %q", q.Bucket)
+		}
+	}
+	if q.Window != "" {
+		if window, err = time.ParseDuration(q.Window); err != nil {
+			return 0, 0, fmt.Errorf("bad window %q", q.Window)
+		}
+	}
+	if bucket < queryMinBucket || bucket%time.Minute != 0 {
+		return 0, 0, fmt.Errorf("bucket must be a whole number of minutes")
+	}
+	n := int((window + bucket - 1) / bucket)
+	if n < 1 || n > queryMaxBuckets {
+		return 0, 0, fmt.Errorf("window must cover 1 to %d buckets", queryMaxBuckets)
+	}
+	if len(q.Metrics) == 0 {
+		q.Metrics = []string{"detections"}
+	}
+	for _, m := range q.Metrics {
+		if queryMetric(m) == nil {
+			return 0, 0, fmt.Errorf("unknown metric %q (have %s)", m, queryMetricNames())
+		}
+	}
+	return bucket, n, nil
+}
+
+// runQuery buckets uploads for a resolved query, ending with the bucket that
+// contains now. Rolled-up uploads land in the bucket holding their hour or day.
+func (s *Store) runQuery(ctx context.Context, q Query, bucket time.Duration, n int, now time.Time) (QueryResult, error) {
+	if len(q.Devices) == 0 {
+		s.mu.RLock()
+		for id := range s.latest {
+			q.Devices = append(q.Devices, id)
+		}
+		s.mu.RUnlock()
+		sort.Strings(q.Devices)
+	}
+
+	end := now.Truncate(bucket).Add(bucket)
+	start := end.Add(-time.Duration(n) * bucket)
+	res := QueryResult{
+		Start:         start.Unix(),
+		BucketSeconds: int64(bucket / time.Second),
+		Times:         make([]int64, n),
+		Devices:       make(map[string]map[string][]*float64),
+	}
+	for i := range res.Times {
+		res.Times[i] = start.Add(time.Duration(i) * bucket).Unix()
+	}
+	buckets := make(map[string][]queryBucket)
+	for _, id := range q.Devices {
+		buckets[id] = make([]queryBucket, n)
+	}
+
+	startTS := start.Format("2006-01-02 15:04:05")
+	since, args := uploadsSince(startTS, q.Devices)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, (CAST(strftime('%s', timestamp) AS INTEGER) - CAST(strftime('%s', ?) AS INTEGER)) / ? AS b,
+			SUM(uploads), COALESCE(SUM(total_detections), 0), COALESCE(SUM(detections_per_min), 0),
+			COALESCE(SUM(current_activity_pct), 0), COALESCE(MAX(peak_activity_pct), 0),
+			COALESCE(counts_sum(freqs), '')
+		FROM (`+since+`)
+		WHERE timestamp < ?
+		GROUP BY device_id, b
+	`, append(append([]interface{}{startTS, res.BucketSeconds}, args...), end.Format("2006-01-02 15:04:05"))...)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var i int
+		var freqs string
+		var b queryBucket
+		if err := rows.Scan(&id, &i, &b.uploads, &b.detections, &b.rate, &b.activity, &b.peak, &freqs); err != nil {
+			return QueryResult{}, err
+		}
+		b.freqs = parseCounts(freqs)
+		if i >= 0 && i < n {
+			buckets[id][i] = b
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return QueryResult{}, err
+	}
+
+	for id, bs := range buckets {
+		series := make(map[string][]*float64, len(q.Metrics))
+		for _, m := range q.Metrics {
+			metric := queryMetric(m)
+			values := make([]*float64, n)
+			for i := range bs {
+				values[i] = metric(&bs[i])
+			}
+			series[m] = values
+		}
+		res.Devices[id] = series
+	}
+	return res, nil
+}
+
+// handleAPIQuery answers a bulk time-series query with aligned arrays, for
+// dashboards such as Node-RED flows: POST /api/query with a Query body, or
+// GET /api/query?q=<the same JSON>
+func handleAPIQuery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var q Query
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		if v := r.URL.Query().Get("q"); v != "" {
+			err = json.Unmarshal([]byte(v), &q)
+		}
+	case http.MethodPost:
+		err = json.NewDecoder(r.Body).Decode(&q)
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid query JSON", http.StatusBadRequest)
+		return
+	}
+
+	bucket, n, err := q.resolve()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := store.runQuery(ctx, q, bucket, n, timeNow())
+	if err != nil {
+		log.Printf("Error running query: %v", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
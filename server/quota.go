@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quotas keep a shared instance, such as a community server that several
+// households' detectors report to, from being exhausted by any one of them.
+// A tenant is a group of detectors (see groups.go), or a detector on its own
+// when it isn't in one. Each limit is off unless set:
+//
+//   - MAX_DEVICES: detectors the server takes uploads from in all; a new
+//     device_id beyond it is refused
+//   - QUOTA_GROUP_DEVICES: detectors in one group, checked when one is added
+//   - QUOTA_UPLOADS_PER_DAY: uploads and event posts per tenant per day,
+//     by the server's clock
+//   - QUOTA_ROWS: rows a tenant has stored in the tables that grow with its
+//     uploads, recounted every quotaRecount and counted up as posts arrive
+//
+// A post over a quota is answered 429 with Retry-After (4.29 over CoAP) and
+// not stored, whichever transport it came by. Quotas are checked before
+// quarantine, so a quarantined spoofer can't fill the quarantine table either.
+// A post counts once it is stored (or held), so one that fails to save or
+// duplicates a relayed upload already here uses none of the quota; posts in flight together, such as a relayed batch, can take a
+// tenant past a limit by that many.
+const (
+	quotaRecount    = 10 * time.Minute
+	quotaRetry      = time.Hour // Retry-After when waiting for midnight won't help
+	quotaNearFactor = 0.9       // Share of a quota at which the dashboard warns
+)
+
+// quotaTables are the tables whose rows count toward QUOTA_ROWS
+var quotaTables = []string{"uploads", "events", "device_health", "minute_bins", "upload_rollups"}
+
+// QuotaLimits are the configured quotas; zero means no limit
+type QuotaLimits struct {
+	Devices       int `json:"max_devices,omitempty"`
+	GroupDevices  int `json:"group_devices,omitempty"`
+	UploadsPerDay int `json:"uploads_per_day,omitempty"`
+	Rows          int `json:"rows,omitempty"`
+}
+
+func quotaLimits() QuotaLimits {
+	limit := func(name string) int {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			return v
+		}
+		return 0
+	}
+	return QuotaLimits{
+		Devices:       limit("MAX_DEVICES"),
+		GroupDevices:  limit("QUOTA_GROUP_DEVICES"),
+		UploadsPerDay: limit("QUOTA_UPLOADS_PER_DAY"),
+		Rows:          limit("QUOTA_ROWS"),
+	}
+}
+
+// quotaError refuses a post over a quota; the sender should wait retry
+type quotaError struct {
+	msg   string
+	retry time.Duration
+}
+
+func (e *quotaError) Error() string { return e.msg }
+
+// tenantOf names the tenant a detector's usage counts against
+func tenantOf(d DeviceInfo) string {
+	if d.Group != "" {
+		return "group:" + d.Group
+	}
+	return d.DeviceID
+}
+
+// TenantUsage is one tenant's use of the quotas, for /api/admin/status
+type TenantUsage struct {
+	Tenant       string `json:"tenant"`
+	Devices      int    `json:"devices"`
+	UploadsToday int    `json:"uploads_today"`
+	Rows         int    `json:"rows"`
+	Refused      int    `json:"refused,omitempty"` // Posts refused today
+}
+
+// QuotaStatus is the configured quotas and every tenant's usage
+type QuotaStatus struct {
+	Limits      QuotaLimits   `json:"limits"`
+	Devices     int           `json:"devices"` // Detectors uploading, against MAX_DEVICES
+	Tenants     []TenantUsage `json:"tenants"`
+	RowsCounted *time.Time    `json:"rows_counted,omitempty"`
+}
+
+// quotaTracker counts each tenant's usage against the limits
+type quotaTracker struct {
+	limits QuotaLimits
+
+	mu      sync.Mutex
+	day     string         // Server-local date uploads and refused are for
+	uploads map[string]int // Posts stored today, per tenant
+	refused map[string]int // Posts refused today, per tenant
+	rows    map[string]int // Stored rows, as last counted plus posts stored since
+	counted time.Time
+}
+
+// quotas enforces the quotas in serve, or is nil when none are set
+var quotas *quotaTracker
+
+// startQuotas begins enforcing the configured quotas, seeding today's upload
+// counts from the database so a restart doesn't reset them
+func (s *Store) startQuotas(ctx context.Context) {
+	limits := quotaLimits()
+	if limits == (QuotaLimits{}) {
+		return
+	}
+	q := &quotaTracker{limits: limits, day: timeNow().Format("2006-01-02"),
+		uploads: make(map[string]int), refused: make(map[string]int), rows: make(map[string]int)}
+	today, err := s.countPerTenant(ctx, []string{"uploads"}, "timestamp >= ?", q.day+" 00:00:00")
+	if err != nil {
+		log.Printf("Error counting today's uploads for quotas: %v", err)
+	}
+	for t, n := range today {
+		q.uploads[t] = n
+	}
+	quotas = q
+	log.Printf("Quotas: %+v", limits)
+	if limits.Rows > 0 {
+		go s.recountQuotaRows(q)
+	}
+}
+
+// countPerTenant counts rows in tables, optionally filtered by where, per tenant
+func (s *Store) countPerTenant(ctx context.Context, tables []string, where string, args ...interface{}) (map[string]int, error) {
+	tenants := make(map[string]string)
+	for _, d := range s.getDevices(ctx) {
+		tenants[d.DeviceID] = tenantOf(d)
+	}
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	if where == "" {
+		where = "1 = 1"
+	}
+	counts := make(map[string]int)
+	for _, table := range tables {
+		rows, err := s.query(ctx, `SELECT device_id, COUNT(*) FROM `+table+` WHERE `+where+` GROUP BY device_id`, args...)
+		if err != nil {
+			return counts, fmt.Errorf("%s: %w", table, err)
+		}
+		for rows.Next() {
+			var id string
+			var n int
+			if rows.Scan(&id, &n) == nil {
+				if t, ok := tenants[id]; ok {
+					id = t
+				}
+				counts[id] += n
+			}
+		}
+		rows.Close()
+	}
+	return counts, nil
+}
+
+// recountQuotaRows recounts each tenant's stored rows for the life of the
+// server, so rows retention and eviction remove are given back
+func (s *Store) recountQuotaRows(q *quotaTracker) {
+	for {
+		counts, err := s.countPerTenant(context.Background(), quotaTables, "")
+		if err != nil {
+			log.Printf("Error counting rows for quotas: %v", err)
+		} else {
+			q.mu.Lock()
+			q.rows, q.counted = counts, timeNow()
+			q.mu.Unlock()
+		}
+		time.Sleep(quotaRecount)
+	}
+}
+
+// rollDay starts a new day's counts once the date changes; q.mu must be held
+func (q *quotaTracker) rollDay(now time.Time) {
+	if day := now.Format("2006-01-02"); day != q.day {
+		q.day, q.uploads, q.refused = day, make(map[string]int), make(map[string]int)
+	}
+}
+
+// admit checks a post from a detector against the quotas; charge counts it once
+// it is stored. A nil tracker admits everything.
+func (q *quotaTracker) admit(ctx context.Context, deviceID string) error {
+	if q == nil {
+		return nil
+	}
+	tenant := tenantOf(store.getDevice(ctx, deviceID))
+	var refusal *quotaError
+	if q.limits.Devices > 0 {
+		store.mu.RLock()
+		_, known := store.latest[deviceID]
+		n := len(store.latest)
+		store.mu.RUnlock()
+		if !known && n >= q.limits.Devices {
+			refusal = &quotaError{fmt.Sprintf("device quota reached: %d detectors", q.limits.Devices), quotaRetry}
+		}
+	}
+
+	now := timeNow()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollDay(now)
+	switch {
+	case refusal != nil:
+	case q.limits.UploadsPerDay > 0 && q.uploads[tenant] >= q.limits.UploadsPerDay:
+		y, m, d := now.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+		refusal = &quotaError{fmt.Sprintf("upload quota reached: %d a day", q.limits.UploadsPerDay), midnight.Sub(now)}
+	case q.limits.Rows > 0 && q.rows[tenant] >= q.limits.Rows:
+		refusal = &quotaError{fmt.Sprintf("storage quota reached: %d rows", q.limits.Rows), quotaRetry}
+	default:
+		return nil
+	}
+	if q.refused[tenant] == 0 {
+		log.Printf("Refusing uploads from %s (%s): %v", deviceID, tenant, refusal)
+	}
+	q.refused[tenant]++
+	return refusal
+}
+
+// charge counts n posts from a detector that were stored
+func (q *quotaTracker) charge(ctx context.Context, deviceID string, n int) {
+	if q == nil || n == 0 {
+		return
+	}
+	tenant := tenantOf(store.getDevice(ctx, deviceID))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollDay(timeNow())
+	q.uploads[tenant] += n
+	q.rows[tenant] += n
+}
+
+// groupFull reports an error if adding a detector to a group would take it
+// over QUOTA_GROUP_DEVICES
+func (q *quotaTracker) groupFull(ctx context.Context, deviceID, group string) error {
+	if q == nil || q.limits.GroupDevices == 0 || group == "" {
+		return nil
+	}
+	members := 0
+	for _, d := range store.getDevices(ctx) {
+		if d.Group == group && d.DeviceID != deviceID {
+			members++
+		}
+	}
+	if members >= q.limits.GroupDevices {
+		return fmt.Errorf("group quota reached: %d detectors in %s", q.limits.GroupDevices, group)
+	}
+	return nil
+}
+
+// status reports every tenant's usage, sorted by name
+func (q *quotaTracker) status(ctx context.Context) *QuotaStatus {
+	if q == nil {
+		return nil
+	}
+	devices := make(map[string]int)
+	configured := make(map[string]bool)
+	for _, d := range store.getDevices(ctx) {
+		devices[tenantOf(d)]++
+		configured[d.DeviceID] = true
+	}
+	store.mu.RLock()
+	st := &QuotaStatus{Limits: q.limits, Devices: len(store.latest)}
+	for id := range store.latest {
+		if !configured[id] {
+			devices[id]++ // Uploading, but never configured
+		}
+	}
+	store.mu.RUnlock()
+
+	q.mu.Lock()
+	q.rollDay(timeNow())
+	seen := make(map[string]bool)
+	for _, m := range []map[string]int{devices, q.uploads, q.rows, q.refused} {
+		for t := range m {
+			if !seen[t] {
+				seen[t] = true
+				st.Tenants = append(st.Tenants, TenantUsage{Tenant: t, Devices: devices[t],
+					UploadsToday: q.uploads[t], Rows: q.rows[t], Refused: q.refused[t]})
+			}
+		}
+	}
+	if !q.counted.IsZero() {
+		counted := q.counted
+		st.RowsCounted = &counted
+	}
+	q.mu.Unlock()
+	sort.Slice(st.Tenants, func(i, j int) bool { return st.Tenants[i].Tenant < st.Tenants[j].Tenant })
+	return st
+}
+
+// quotaBadge is the dashboard's badge for tenants over or near a quota (just
+// the group's own on a group dashboard), or "" while all are well within them
+func quotaBadge(ctx context.Context, l Lang, group string) string {
+	st := quotas.status(ctx)
+	if st == nil {
+		return ""
+	}
+	near := func(used, limit int) bool {
+		return limit > 0 && float64(used) >= quotaNearFactor*float64(limit)
+	}
+	over, warned := 0, 0
+	var detail []string
+	if group == "" && near(st.Devices, st.Limits.Devices) {
+		warned++
+		detail = append(detail, l.Tf("%d of %d detectors", st.Devices, st.Limits.Devices))
+	}
+	for _, t := range st.Tenants {
+		if group != "" && t.Tenant != "group:"+group {
+			continue
+		}
+		var lines []string
+		if near(t.UploadsToday, st.Limits.UploadsPerDay) {
+			lines = append(lines, l.Tf("%s: %d of %d uploads today", t.Tenant, t.UploadsToday, st.Limits.UploadsPerDay))
+		}
+		if near(t.Rows, st.Limits.Rows) {
+			lines = append(lines, l.Tf("%s: %d of %d rows", t.Tenant, t.Rows, st.Limits.Rows))
+		}
+		if near(t.Devices, st.Limits.GroupDevices) && strings.HasPrefix(t.Tenant, "group:") {
+			lines = append(lines, l.Tf("%s: %d of %d detectors", t.Tenant, t.Devices, st.Limits.GroupDevices))
+		}
+		if t.Refused > 0 {
+			lines = append(lines, l.Tf("%s: %d refused today", t.Tenant, t.Refused))
+			over++
+		} else if len(lines) > 0 {
+			warned++
+		}
+		detail = append(detail, lines...)
+	}
+	if len(detail) == 0 {
+		return ""
+	}
+	label := "📈 " + l.Tf("%d near quota", warned)
+	if over > 0 {
+		label = "⛔ " + l.Tf("%d over quota", over)
+	}
+	return ` <span class="db-badge quota" title="` + html.EscapeString(strings.Join(detail, "\n")) + `">` + label + `</span>`
+}
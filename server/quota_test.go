@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// The quota tests drive a tracker against a scratch database with timeNow
+// pinned, checking who is refused, what Retry-After they are given, and that
+// only stored posts count: admit checks without charging, so a post refused
+// further on (a duplicate, a failed save) doesn't use up the tenant's day.
+
+// quotaNow is late enough in the day that the midnight Retry-After is short
+var quotaNow = time.Date(2025, 6, 15, 22, 30, 0, 0, time.UTC)
+
+// pinQuotas pins the clock and installs a tracker with limits for one test
+func pinQuotas(t *testing.T, limits QuotaLimits) *quotaTracker {
+	t.Helper()
+	now, saved := timeNow, quotas
+	t.Cleanup(func() { timeNow, quotas = now, saved })
+	timeNow = func() time.Time { return quotaNow }
+	quotas = &quotaTracker{limits: limits, day: quotaNow.Format("2006-01-02"),
+		uploads: make(map[string]int), refused: make(map[string]int), rows: make(map[string]int)}
+	return quotas
+}
+
+func TestQuotaAdmit(t *testing.T) {
+	ctx := context.Background()
+	type post struct {
+		device string
+		charge bool          // Stored, so counted
+		retry  time.Duration // Retry-After if refused; zero if admitted
+	}
+	tests := []struct {
+		name   string
+		limits QuotaLimits
+		known  []string // Detectors already uploading
+		posts  []post
+	}{
+		{"uploads per day", QuotaLimits{UploadsPerDay: 2}, nil, []post{
+			{"d1", true, 0}, {"d1", true, 0}, {"d1", true, 90 * time.Minute}, {"d2", true, 0},
+		}},
+		{"refused and uncharged posts don't count", QuotaLimits{UploadsPerDay: 2}, nil, []post{
+			{"d1", false, 0}, {"d1", false, 0}, {"d1", false, 0}, {"d1", true, 0}, {"d1", true, 0}, {"d1", false, 90 * time.Minute},
+		}},
+		{"group shares a day's uploads", QuotaLimits{UploadsPerDay: 2}, nil, []post{
+			{"g1", true, 0}, {"g2", true, 0}, {"g1", false, 90 * time.Minute}, {"g2", false, 90 * time.Minute}, {"d1", false, 0},
+		}},
+		{"device quota", QuotaLimits{Devices: 2}, []string{"d1", "d2"}, []post{
+			{"d1", true, 0}, {"d3", false, quotaRetry}, {"d2", true, 0},
+		}},
+		{"rows", QuotaLimits{Rows: 3}, nil, []post{
+			{"d1", true, 0}, {"d1", true, 0}, {"d1", true, 0}, {"d1", false, quotaRetry}, {"d2", true, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scratchStore(t)
+			for _, id := range []string{"g1", "g2"} {
+				if err := store.setDeviceGroup(ctx, id, "roof"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store.mu.Lock()
+			for _, id := range tt.known {
+				store.latest[id] = Stats{DeviceID: id}
+			}
+			store.mu.Unlock()
+			q := pinQuotas(t, tt.limits)
+
+			for i, p := range tt.posts {
+				err := q.admit(ctx, p.device)
+				var refusal *quotaError
+				switch {
+				case p.retry == 0 && err != nil:
+					t.Fatalf("post %d from %s refused: %v", i, p.device, err)
+				case p.retry != 0 && !errors.As(err, &refusal):
+					t.Fatalf("post %d from %s: err = %v, want a quota refusal", i, p.device, err)
+				case refusal != nil && refusal.retry != p.retry:
+					t.Errorf("post %d from %s: retry after %s, want %s", i, p.device, refusal.retry, p.retry)
+				}
+				if err == nil && p.charge {
+					q.charge(ctx, p.device, 1)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaNewDay(t *testing.T) {
+	scratchStore(t)
+	ctx := context.Background()
+	q := pinQuotas(t, QuotaLimits{UploadsPerDay: 1})
+	q.charge(ctx, "d1", 1)
+	if err := q.admit(ctx, "d1"); err == nil {
+		t.Fatal("second post of the day admitted")
+	}
+	timeNow = func() time.Time { return quotaNow.Add(2 * time.Hour) }
+	if err := q.admit(ctx, "d1"); err != nil {
+		t.Errorf("first post of the next day refused: %v", err)
+	}
+}
+
+func TestNilQuotaTracker(t *testing.T) {
+	var q *quotaTracker
+	q.charge(context.Background(), "d1", 1)
+	if err := q.admit(context.Background(), "d1"); err != nil {
+		t.Errorf("nil tracker refused a post: %v", err)
+	}
+}
+
+// TestIngestQuotaRefusal checks a refused upload isn't stored or counted
+func TestIngestQuotaRefusal(t *testing.T) {
+	scratchStore(t)
+	ctx := context.Background()
+	q := pinQuotas(t, QuotaLimits{UploadsPerDay: 1})
+	upload := func() error {
+		st := fuzzStats()[0]
+		st.Timestamp = timeNow()
+		_, err := ingestStats(ctx, &st)
+		return err
+	}
+	if err := upload(); err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	var refusal *quotaError
+	if err := upload(); !errors.As(err, &refusal) {
+		t.Fatalf("second upload: err = %v, want a quota refusal", err)
+	}
+	var stored int
+	if err := store.queryRow(ctx, `SELECT COUNT(*) FROM uploads`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 1 || q.uploads["lora-detector-1"] != 1 || q.refused["lora-detector-1"] != 1 {
+		t.Errorf("stored %d, counted %d, refused %d; want 1 of each", stored, q.uploads["lora-detector-1"], q.refused["lora-detector-1"])
+	}
+}
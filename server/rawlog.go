@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The raw log appends every accepted upload, as the device sent it, to an
+// NDJSON file outside SQLite: a record to rebuild from if the database is lost
+// or corrupted, or to reprocess after a change to how uploads are stored.
+// Lines carry the server-assigned timestamp, ack ID and (anonymized) uploader
+// address but the device's own channel order, so import and replay apply the
+// channel map in force when they run. The file is rotated by size, the rotated
+// files named by when they were closed and the oldest deleted past a count.
+
+// rawLog appends accepted uploads; nil when RAW_LOG isn't set
+var rawLog *rawLogger
+
+type rawLogger struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int // rotated files kept; 0 keeps them all
+	file    *os.File
+	size    int64
+}
+
+// rawLogFromEnv reads RAW_LOG (the file to append to), RAW_LOG_MAX_MB (rotate
+// past this size, default 100) and RAW_LOG_KEEP (rotated files kept, default 10)
+func rawLogFromEnv() (*rawLogger, error) {
+	path := os.Getenv("RAW_LOG")
+	if path == "" {
+		return nil, nil
+	}
+	l := &rawLogger{path: path, maxSize: 100 << 20, keep: 10}
+	if v := os.Getenv("RAW_LOG_MAX_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 {
+			return nil, fmt.Errorf("invalid RAW_LOG_MAX_MB %q", v)
+		}
+		l.maxSize = int64(mb) << 20
+	}
+	if v := os.Getenv("RAW_LOG_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid RAW_LOG_KEEP %q", v)
+		}
+		l.keep = n
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens (or creates) the current file for appending
+func (l *rawLogger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// append writes one upload as a line, rotating first if the file is full.
+// Failures are logged rather than returned: the upload is already stored.
+func (l *rawLogger) append(stats Stats) {
+	line, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Error encoding upload from %s for the raw log: %v", stats.DeviceID, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("Error rotating raw log %s: %v", l.path, err)
+		}
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			log.Printf("Error opening raw log %s: %v", l.path, err)
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Error writing upload from %s to raw log: %v", stats.DeviceID, err)
+	}
+}
+
+// rotatedName is where the current file goes when closed:
+// uploads.ndjson becomes uploads-20250615T140700.000.ndjson
+func (l *rawLogger) rotatedName() string {
+	ext := filepath.Ext(l.path)
+	return strings.TrimSuffix(l.path, ext) + "-" + timeNow().UTC().Format("20060102T150405.000") + ext
+}
+
+// rotate closes the current file under its rotated name and deletes the
+// oldest rotated files past l.keep; the next append opens a new one
+func (l *rawLogger) rotate() error {
+	l.file.Close()
+	l.file = nil
+	if err := os.Rename(l.path, l.rotatedName()); err != nil {
+		return err
+	}
+	if l.keep == 0 {
+		return nil
+	}
+	rotated, err := l.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	for len(rotated) > l.keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// rotatedFiles lists the rotated files, oldest first
+func (l *rawLogger) rotatedFiles() ([]string, error) {
+	ext := filepath.Ext(l.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(l.path, ext) + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
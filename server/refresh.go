@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Dashboard refresh strategies (DASHBOARD_REFRESH). A partial refresh polls
+// /api/stats and rewrites each detector's latest-session figures in place, so
+// the visitor keeps their scroll position, open menus and anything they've
+// selected; charts catch up the next time the page loads, which it does by
+// itself when a detector appears or disappears. A reload is the old
+// <meta refresh> of the whole page.
+const (
+	refreshPartial = "partial"
+	refreshReload  = "reload"
+	refreshOff     = "off"
+)
+
+// dashboardRefresh reads the strategy and its interval in seconds
+// (DASHBOARD_REFRESH_SEC, default 30)
+func dashboardRefresh() (mode string, seconds int) {
+	mode, seconds = refreshPartial, 30
+	switch m := os.Getenv("DASHBOARD_REFRESH"); m {
+	case refreshReload, refreshOff:
+		mode = m
+	}
+	if v, err := strconv.Atoi(os.Getenv("DASHBOARD_REFRESH_SEC")); err == nil && v > 0 {
+		seconds = max(v, 5)
+	}
+	return mode, seconds
+}
+
+// refreshHead is the <head> tag for a page that reloads itself, or nothing.
+// Pages without a partial refresh (the mobile view) reload instead.
+func refreshHead(partial bool) string {
+	mode, seconds := dashboardRefresh()
+	if mode == refreshOff || (mode == refreshPartial && partial) {
+		return ""
+	}
+	return fmt.Sprintf("    <meta http-equiv=\"refresh\" content=\"%d\">\n", seconds)
+}
+
+// refreshNote says in the footer how the page keeps itself current
+func refreshNote(l Lang) string {
+	mode, seconds := dashboardRefresh()
+	if mode == refreshOff {
+		return l.T("Auto-refresh off")
+	}
+	return l.Tf("Auto-refreshes every %d seconds", seconds)
+}
+
+// renderPartialRefresh writes the script that keeps the dashboard's device
+// cards current. all is false on a group's dashboard, which shows only some
+// detectors and counts only their uploads.
+func renderPartialRefresh(w io.Writer, l Lang, all bool) {
+	mode, seconds := dashboardRefresh()
+	if mode != refreshPartial {
+		return
+	}
+	fmt.Fprintf(w, `    <script>
+    (function () {
+        var all = %t, lang = %q;
+        function set(card, field, text) {
+            var el = card.querySelector('[data-field="' + field + '"]');
+            if (el && el.textContent !== text) el.textContent = text;
+        }
+        function pad(n) { return (n < 10 ? '0' : '') + n; }
+        function update() {
+            if (document.hidden) return;
+            fetch('/api/stats', {cache: 'no-store'}).then(function (r) {
+                if (!r.ok) throw new Error(r.status);
+                return r.json();
+            }).then(function (s) {
+                var devices = s.devices || {}, shown = {};
+                var cards = document.querySelectorAll('.card[data-device]');
+                for (var i = 0; i < cards.length; i++) {
+                    var card = cards[i], d = devices[card.dataset.device];
+                    if (!d) { location.reload(); return; }
+                    shown[card.dataset.device] = true;
+                    if (card.dataset.ts === d.timestamp) continue;
+                    card.dataset.ts = d.timestamp;
+                    card.classList.remove('stale');
+                    var chip = card.querySelector('.stale-chip');
+                    if (chip) chip.remove();
+                    set(card, 'total_detections', String(d.total_detections));
+                    set(card, 'detections_per_min', String(d.detections_per_min));
+                    set(card, 'current_activity_pct', d.current_activity_pct + '%%');
+                    set(card, 'peak_activity_pct', d.peak_activity_pct + '%%');
+                    set(card, 'uptime', pad(Math.floor(d.uptime_seconds / 3600)) + ':' + pad(Math.floor(d.uptime_seconds %% 3600 / 60)));
+                    set(card, 'timestamp', new Date(d.timestamp).toLocaleString(lang, {dateStyle: 'medium', timeStyle: 'short'}));
+                    var box = card.querySelector('[data-field="current_activity_pct"]').parentNode;
+                    box.classList.toggle('hot', d.current_activity_pct >= 10);
+                }
+                if (!all) return;
+                for (var id in devices) {
+                    if (!shown[id]) { location.reload(); return; }
+                }
+                var badge = document.querySelector('.db-badge');
+                if (badge) badge.textContent = badge.textContent.replace(/\d+/, s.total_uploads);
+            }).catch(function () {});
+        }
+        setInterval(update, %d);
+        document.addEventListener('visibilitychange', update);
+    })();
+    </script>
+`, all, string(l), seconds*1000)
+}
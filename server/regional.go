@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Community members can see how their detectors compare with others nearby.
+// The community server answers /api/community/region with the median hourly
+// detections per category among stations within communityRegionKm of a grid
+// point; it never returns any one station's figures, and leaves out a category
+// until communityRegionMin stations have reported it. Instances ask about each
+// located detector's grid cell (never the detector itself) and keep the answers
+// in memory.
+const (
+	communityRegionPath = "/api/community/region"
+	communityRegionKm   = 150
+	communityRegionMin  = 3
+	communityRegionHrs  = 24
+)
+
+// RegionalAverages is what the community server says about the stations around a point
+type RegionalAverages struct {
+	Latitude   float64                     `json:"latitude"`
+	Longitude  float64                     `json:"longitude"`
+	RadiusKm   float64                     `json:"radius_km"`
+	Hours      int                         `json:"hours"`
+	Stations   int                         `json:"stations"`
+	Categories map[string]RegionalCategory `json:"categories"`
+}
+
+// RegionalCategory is one category's median among the stations that heard it
+type RegionalCategory struct {
+	MedianPerHour float64 `json:"median_per_hour"`
+	Stations      int     `json:"stations"`
+}
+
+// CategoryComparison is a detector's hourly rate in one category against the regional median
+type CategoryComparison struct {
+	Category      string  `json:"category"`
+	PerHour       float64 `json:"per_hour"`
+	MedianPerHour float64 `json:"median_per_hour"`
+	Ratio         float64 `json:"ratio"`
+	Stations      int     `json:"stations"`
+}
+
+// communityRegions holds the community server's answers by grid cell
+var communityRegions = struct {
+	sync.Mutex
+	cells map[string]RegionalAverages
+}{cells: make(map[string]RegionalAverages)}
+
+// gridCell keys a location by its community grid cell
+func gridCell(lat, lon float64) string {
+	return fmt.Sprintf("%.1f,%.1f", roundToGrid(lat), roundToGrid(lon))
+}
+
+// distanceKm is the distance between two nearby points, on a flat projection
+// like multilateration's, which is plenty at regional scale
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	x := (lon2 - lon1) * metersPerDegLon * math.Cos((lat1+lat2)/2*math.Pi/180)
+	y := (lat2 - lat1) * metersPerDegLat
+	return math.Hypot(x, y) / 1000
+}
+
+// median of a non-empty slice, which it sorts
+func median(v []float64) float64 {
+	slices.Sort(v)
+	if n := len(v); n%2 == 0 {
+		return (v[n/2-1] + v[n/2]) / 2
+	}
+	return v[len(v)/2]
+}
+
+// categoryRates turns per-frequency detections over some hours into detections per hour by category
+func categoryRates(freqs []int, hours float64) map[string]float64 {
+	rates := make(map[string]float64)
+	if hours <= 0 {
+		return rates
+	}
+	for _, c := range categories() {
+		rates[c] = float64(categoryCount(freqs, c)) / hours
+	}
+	return rates
+}
+
+// regionalAverages computes medians among the stations near a point, on the community server
+func (s *Store) regionalAverages(ctx context.Context, lat, lon float64, hours int) RegionalAverages {
+	avg := RegionalAverages{Latitude: roundToGrid(lat), Longitude: roundToGrid(lon), RadiusKm: communityRegionKm,
+		Hours: hours, Categories: make(map[string]RegionalCategory)}
+	perCategory := make(map[string][]float64)
+	for _, st := range s.getCommunityStations(ctx, hours) {
+		if st.Latitude == nil || st.Hours <= 0 || distanceKm(avg.Latitude, avg.Longitude, *st.Latitude, *st.Longitude) > communityRegionKm {
+			continue
+		}
+		avg.Stations++
+		for c, rate := range categoryRates(st.FreqDetections, st.Hours) {
+			if rate > 0 {
+				perCategory[c] = append(perCategory[c], rate)
+			}
+		}
+	}
+	for c, rates := range perCategory {
+		if len(rates) >= communityRegionMin {
+			avg.Categories[c] = RegionalCategory{MedianPerHour: math.Round(median(rates)*100) / 100, Stations: len(rates)}
+		}
+	}
+	return avg
+}
+
+// deviceCategoryRates is a detector's detections per hour by category over the
+// last hours, counting only hours it uploaded in, as the community server does
+func (s *Store) deviceCategoryRates(ctx context.Context, deviceID string, hours int) map[string]float64 {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var freqs string
+	var online int
+	err := s.queryRow(ctx, `
+		SELECT COALESCE(counts_sum(freqs), ''), COUNT(DISTINCT substr(timestamp, 1, 13))
+		FROM uploads WHERE device_id = ? AND timestamp >= ?
+	`, deviceID, dbTimeAgo(time.Duration(hours)*time.Hour)).Scan(&freqs, &online)
+	if err != nil {
+		log.Printf("Error loading category rates: %v", err)
+		return nil
+	}
+	return categoryRates(planCounts(freqs), float64(online))
+}
+
+// compareWithRegion sets a detector's category rates against its cell's medians
+func (s *Store) compareWithRegion(ctx context.Context, d DeviceInfo) ([]CategoryComparison, RegionalAverages, bool) {
+	if !d.Located() {
+		return nil, RegionalAverages{}, false
+	}
+	communityRegions.Lock()
+	avg, ok := communityRegions.cells[gridCell(*d.Latitude, *d.Longitude)]
+	communityRegions.Unlock()
+	if !ok {
+		return nil, avg, false
+	}
+	rates := s.deviceCategoryRates(ctx, d.DeviceID, avg.Hours)
+	var out []CategoryComparison
+	for _, c := range categories() {
+		region, ok := avg.Categories[c]
+		if !ok || region.MedianPerHour <= 0 {
+			continue
+		}
+		out = append(out, CategoryComparison{Category: c, PerHour: math.Round(rates[c]*100) / 100,
+			MedianPerHour: region.MedianPerHour, Ratio: math.Round(rates[c]/region.MedianPerHour*10) / 10,
+			Stations: region.Stations})
+	}
+	return out, avg, true
+}
+
+// formatComparisons renders "Sidewalk 3× the regional median, Meshtastic about the median"
+func formatComparisons(l Lang, comparisons []CategoryComparison) string {
+	var parts []string
+	for _, c := range comparisons {
+		info, _ := categoryByName(c.Category)
+		switch {
+		case c.Ratio >= 1.5 || (c.Ratio < 0.7 && c.Ratio > 0):
+			parts = append(parts, l.Tf("%s %s× the regional median", info.Title, strconv.FormatFloat(c.Ratio, 'f', -1, 64)))
+		case c.Ratio == 0:
+			parts = append(parts, l.Tf("no %s, against a regional median of %.1f an hour", l.T(info.Nouns[1]), c.MedianPerHour))
+		default:
+			parts = append(parts, l.Tf("%s about the regional median", info.Title))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fetchRegionalAverages asks the community server about one grid cell
+func fetchRegionalAverages(ctx context.Context, client *http.Client, base string, lat, lon float64) (RegionalAverages, error) {
+	q := url.Values{
+		"lat":   {strconv.FormatFloat(roundToGrid(lat), 'f', 1, 64)},
+		"lon":   {strconv.FormatFloat(roundToGrid(lon), 'f', 1, 64)},
+		"hours": {strconv.Itoa(communityRegionHrs)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+communityRegionPath+"?"+q.Encode(), nil)
+	if err != nil {
+		return RegionalAverages{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return RegionalAverages{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RegionalAverages{}, fmt.Errorf("%s", resp.Status)
+	}
+	var avg RegionalAverages
+	err = json.NewDecoder(resp.Body).Decode(&avg)
+	return avg, err
+}
+
+// followRegionalAverages refreshes the regional medians for every located
+// detector's grid cell once per community interval
+func (s *Store) followRegionalAverages(base string) {
+	ctx := context.Background()
+	client := &http.Client{Timeout: 30 * time.Second}
+	for {
+		cells := make(map[string]DeviceInfo)
+		for _, d := range s.getDevices(ctx) {
+			if d.Located() {
+				cells[gridCell(*d.Latitude, *d.Longitude)] = d
+			}
+		}
+		for cell, d := range cells {
+			avg, err := fetchRegionalAverages(ctx, client, base, *d.Latitude, *d.Longitude)
+			if err != nil {
+				log.Printf("Error fetching regional averages for %s: %v", cell, err)
+				continue
+			}
+			communityRegions.Lock()
+			communityRegions.cells[cell] = avg
+			communityRegions.Unlock()
+		}
+		time.Sleep(communityInterval())
+	}
+}
+
+// handleAPICommunityRegion returns medians among stations near a point, on the
+// community server: GET /api/community/region?lat=40.0&lon=-105.3&hours=24
+func handleAPICommunityRegion(w http.ResponseWriter, r *http.Request) {
+	if !communityServer() {
+		http.NotFound(w, r)
+		return
+	}
+	lat, err1 := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if err1 != nil || err2 != nil || !validLatLon(lat, lon) {
+		http.Error(w, "lat and lon required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.regionalAverages(r.Context(), lat, lon, communityHours(r)))
+}
+
+// handleAPICommunityCompare compares a detector with its region, on a
+// community member: GET /api/community/compare?device=ID
+func handleAPICommunityCompare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	comparisons, avg, ok := store.compareWithRegion(ctx, store.getDevice(ctx, deviceID))
+	if !ok {
+		http.Error(w, "No regional averages for this detector; it needs a location and COMMUNITY_URL", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_id":  deviceID,
+		"radius_km":  avg.RadiusKm,
+		"hours":      avg.Hours,
+		"stations":   avg.Stations,
+		"categories": comparisons,
+	})
+}
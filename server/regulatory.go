@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// regulatoryBusiestHours is how many of the busiest channel-hours the report lists
+const regulatoryBusiestHours = 10
+
+// ChannelOccupancy is one channel's observed occupancy over a report period
+type ChannelOccupancy struct {
+	Freq       FrequencyInfo
+	Detections int
+	Events     int       // events with SF and bandwidth, which airtime is estimated from
+	Airtime    float64   // seconds
+	MeanPct    float64   // airtime over the whole period
+	PeakHour   time.Time // zero if there was no airtime
+	PeakPct    float64
+	LimitPct   float64 // band duty-cycle limit; 0 for none
+	HoursOver  int     // hours the channel was busier than LimitPct
+	Hourly     []float64
+}
+
+// getChannelOccupancy builds the per-channel rows for a device's report
+func (s *Store) getChannelOccupancy(ctx context.Context, deviceID string, days int, since time.Time) []ChannelOccupancy {
+	hours := int(timeNow().Sub(since)/time.Hour) + 1
+	summary := s.getSummary(ctx, days, deviceID)
+	airtime := s.getChannelAirtime(ctx, deviceID, since, hours)
+
+	var rows []ChannelOccupancy
+	for i, f := range frequencies() {
+		if !f.Scanned() {
+			continue
+		}
+		o := ChannelOccupancy{Freq: f, Events: airtime[i].Events, LimitPct: f.band().DutyCycle, Hourly: airtime[i].Seconds}
+		if i < len(summary.FreqTotals) {
+			o.Detections = summary.FreqTotals[i]
+		}
+		for h, secs := range airtime[i].Seconds {
+			o.Airtime += secs
+			pct := secs * 100 / 3600
+			if pct > o.PeakPct {
+				o.PeakPct, o.PeakHour = pct, since.Add(time.Duration(h)*time.Hour)
+			}
+			if o.LimitPct > 0 && pct > o.LimitPct {
+				o.HoursOver++
+			}
+		}
+		o.MeanPct = o.Airtime * 100 / (float64(hours) * 3600)
+		rows = append(rows, o)
+	}
+	return rows
+}
+
+// formatLimit renders a duty-cycle limit, or "none" for bands without one
+func formatLimit(pct float64) string {
+	if pct == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%g%%", pct)
+}
+
+// handleRegulatoryReport renders per-channel occupancy and duty cycle laid out
+// for attaching to an FCC or ISED interference complaint:
+// GET /print/regulatory?device=ID&period=30d, with format=csv for a spreadsheet
+func handleRegulatoryReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days, err := parsePrintPeriod(r.URL.Query().Get("period"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	device := store.getDevice(ctx, deviceID)
+	loc := device.Location()
+	since := timeNow().In(loc).AddDate(0, 0, -(days - 1))
+	since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, loc)
+	rows := store.getChannelOccupancy(ctx, deviceID, days, since)
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeRegulatoryCSV(w, deviceID, days, loc, rows)
+		return
+	}
+
+	availability := "–"
+	if a := store.getAvailability(ctx, device, "day", days); a.AvailabilityPct != nil {
+		availability = fmt.Sprintf("%.1f%%", *a.AvailabilityPct)
+	}
+	location := "not recorded"
+	if device.Located() {
+		location = fmt.Sprintf("%.5f, %.5f", *device.Latitude, *device.Longitude)
+	}
+	now := timeNow().In(loc)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Radio Occupancy Report · %s · %d days</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+%s        .blank { height: 28px; }
+        .over { font-weight: bold; }
+    </style>
+</head>
+<body>
+<h1>Radio-Frequency Occupancy Report</h1>
+<p class="meta">Observed channel occupancy and duty cycle, for an FCC or ISED interference complaint · generated %s</p>
+`, html.EscapeString(deviceID), days, printCSS, now.Format("Jan 2, 2006 15:04 MST"))
+
+	fmt.Fprintf(w, `<section>
+<h2>Monitoring Station</h2>
+<table>
+    <tr><th>Detector</th><td>%s</td></tr>
+    <tr><th>Location</th><td>%s</td></tr>
+    <tr><th>Observation period</th><td>%s – %s (%d days, %s)</td></tr>
+    <tr><th>Monitoring availability</th><td>%s</td></tr>
+    <tr><th>Receiver</th><td>LoRa channel activity detector (CAD), one receiver scanning each channel in turn</td></tr>
+</table>
+</section>
+`, html.EscapeString(deviceID), location, since.Format("Jan 2, 2006"), now.Format("Jan 2, 2006"), days,
+		html.EscapeString(locationName(loc)), availability)
+
+	fmt.Fprintf(w, `<section>
+<h2>Occupancy and Duty Cycle by Channel</h2>
+<table>
+    <tr><th>MHz</th><th>Channel</th><th class="num">Detections</th><th class="num">Airtime</th><th class="num">Mean occupancy</th><th>Busiest hour</th><th class="num">Occupancy</th><th class="num">Duty-cycle limit</th><th class="num">Hours over</th></tr>
+`)
+	for _, o := range rows {
+		peak, over := "–", ""
+		if !o.PeakHour.IsZero() {
+			peak = o.PeakHour.In(loc).Format("Jan 2 15:04")
+		}
+		if o.HoursOver > 0 {
+			over = ` over`
+		}
+		fmt.Fprintf(w, `    <tr><td>%s</td><td>%s</td><td class="num">%d</td><td class="num">%.1f s</td><td class="num">%.3f%%</td><td>%s</td><td class="num">%.2f%%</td><td class="num">%s</td><td class="num%s">%d</td></tr>
+`, html.EscapeString(o.Freq.MHz), html.EscapeString(o.Freq.Label), o.Detections, o.Airtime, o.MeanPct, peak, o.PeakPct,
+			formatLimit(o.LimitPct), over, o.HoursOver)
+	}
+	fmt.Fprintf(w, `</table>
+</section>
+`)
+
+	// The channel-hours that would back a complaint best
+	type channelHour struct {
+		freq  FrequencyInfo
+		start time.Time
+		pct   float64
+	}
+	var busiest []channelHour
+	for _, o := range rows {
+		for h, secs := range o.Hourly {
+			if secs > 0 {
+				busiest = append(busiest, channelHour{o.Freq, since.Add(time.Duration(h) * time.Hour), secs * 100 / 3600})
+			}
+		}
+	}
+	slices.SortFunc(busiest, func(a, b channelHour) int { return cmp.Compare(b.pct, a.pct) })
+	if len(busiest) > 0 {
+		fmt.Fprintf(w, `<section>
+<h2>Busiest Hours</h2>
+<table>
+    <tr><th>Hour starting</th><th>MHz</th><th>Channel</th><th class="num">Occupancy</th><th class="num">Duty-cycle limit</th></tr>
+`)
+		for _, b := range busiest[:min(len(busiest), regulatoryBusiestHours)] {
+			fmt.Fprintf(w, `    <tr><td>%s</td><td>%s</td><td>%s</td><td class="num">%.2f%%</td><td class="num">%s</td></tr>
+`, b.start.In(loc).Format("Jan 2, 2006 15:04"), html.EscapeString(b.freq.MHz), html.EscapeString(b.freq.Label), b.pct,
+				formatLimit(b.freq.band().DutyCycle))
+		}
+		fmt.Fprintf(w, `</table>
+</section>
+`)
+	}
+
+	fmt.Fprintf(w, `<section>
+<h2>Method</h2>
+<p>Detections are LoRa preambles found by channel activity detection. Airtime is estimated for packets whose
+spreading factor and bandwidth the detector decoded, using Semtech's LoRa time-on-air formula (8-symbol preamble,
+explicit header, CRC, coding rate 4/5); packets without a reported length are counted as %d bytes. Occupancy is
+airtime as a share of the hour or of the whole period. The detector scans one channel at a time, so it misses
+packets on other channels while it listens elsewhere: these figures are a lower bound. Duty-cycle limits are the
+ETSI EN 300 220 limits where a band has one; US 902–928 MHz and 2.4 GHz devices are limited by dwell time
+instead. Packet-level records are kept for 90 days.</p>
+</section>
+<section>
+<h2>Complainant</h2>
+<table>
+    <tr><th>Name</th><td class="blank"></td></tr>
+    <tr><th>Licence or call sign (if any)</th><td class="blank"></td></tr>
+    <tr><th>Address</th><td class="blank"></td></tr>
+    <tr><th>Phone and email</th><td class="blank"></td></tr>
+    <tr><th>Affected service or equipment</th><td class="blank"></td></tr>
+    <tr><th>Description of the interference</th><td class="blank"></td></tr>
+    <tr><th>Signature and date</th><td class="blank"></td></tr>
+</table>
+</section>
+`, defaultPayloadBytes)
+
+	q := url.Values{"device": {deviceID}}
+	csvQuery := url.Values{"device": {deviceID}, "period": {fmt.Sprintf("%dd", days)}, "format": {"csv"}}
+	fmt.Fprintf(w, `<p class="screen-only"><button onclick="window.print()">🖨️ Print or save as PDF</button> · <a href="/print/regulatory?%s">Download CSV</a> · Period:`,
+		html.EscapeString(csvQuery.Encode()))
+	for _, d := range []int{7, 30, 90} {
+		q.Set("period", fmt.Sprintf("%dd", d))
+		fmt.Fprintf(w, ` <a href="/print/regulatory?%s">%dd</a>`, html.EscapeString(q.Encode()), d)
+	}
+	fmt.Fprintf(w, ` · <a href="/?device=%s">← Detector</a></p>
+</body>
+</html>`, url.QueryEscape(deviceID))
+}
+
+// writeRegulatoryCSV writes the per-channel table as a CSV attachment
+func writeRegulatoryCSV(w http.ResponseWriter, deviceID string, days int, loc *time.Location, rows []ChannelOccupancy) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="occupancy-%s-%dd.csv"`, url.PathEscape(deviceID), days))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"device_id", "frequency_mhz", "channel", "category", "band", "detections", "events_with_sf_bw",
+		"airtime_seconds", "mean_occupancy_pct", "busiest_hour", "busiest_hour_occupancy_pct", "duty_cycle_limit_pct", "hours_over_limit"})
+	for _, o := range rows {
+		peak := ""
+		if !o.PeakHour.IsZero() {
+			peak = o.PeakHour.In(loc).Format(time.RFC3339)
+		}
+		cw.Write([]string{deviceID, o.Freq.MHz, o.Freq.Label, o.Freq.Category, o.Freq.band().Title,
+			strconv.Itoa(o.Detections), strconv.Itoa(o.Events),
+			strconv.FormatFloat(o.Airtime, 'f', 2, 64), strconv.FormatFloat(o.MeanPct, 'f', 4, 64), peak,
+			strconv.FormatFloat(o.PeakPct, 'f', 3, 64), strconv.FormatFloat(o.LimitPct, 'f', -1, 64), strconv.Itoa(o.HoursOver)})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Bridge mode is for sites with a flaky WAN link: a local instance takes the
+// detectors' uploads on the LAN and stores them as usual, and relays them to an
+// upstream instance (RELAY_URL) as the link allows. The uploads table is the
+// buffer. The relay sends uploads in the order they were stored, from a cursor
+// kept in the settings table, so an outage or a restart on either side leaves
+// no gap. Batches are signed with the shared RELAY_KEY, using the same HMAC
+// scheme as signed uploads (see signing.go), and the upstream merges them as an
+// import would (see import.go), keeping each upload's receive time and
+// skipping ones it already has, so a batch resent after a lost response isn't
+// counted twice.
+//
+// Uploads are sent as stored, with the relay's channel maps already applied, so
+// the upstream stores their counts as they are rather than mapping them again;
+// a detector's channel map belongs on the relay. The upstream applies its
+// quotas and quarantine as it would to an upload. Health reports, positions and
+// acks stay with the relay, and uploads that retention rolls up before the link
+// comes back (RETAIN_RAW_DAYS) are not sent.
+const (
+	relayPath       = "/api/relay"
+	relayBatch      = 200
+	relayMaxBody    = 8 << 20
+	relayTimeout    = 30 * time.Second
+	relayPoll       = 30 * time.Second // Checked even without a nudge, for imported and released uploads
+	relayFirstRetry = 30 * time.Second // Doubling up to relayMaxRetry
+	relayMaxRetry   = 10 * time.Minute
+)
+
+// settingRelayCursor is the id of the last upload the upstream accepted
+const settingRelayCursor = "relay_forwarded_id"
+
+// relayWake nudges the relay when an upload is stored
+var relayWake = make(chan struct{}, 1)
+
+// RelayResult is what the upstream did with a batch
+type RelayResult struct {
+	ImportResult
+	Rejected  int `json:"rejected"`             // Failed validation, and won't be sent again
+	OverQuota int `json:"over_quota,omitempty"` // Refused by the upstream's quotas, and dropped
+	Held      int `json:"held,omitempty"`       // From detectors quarantined upstream (see quarantine.go)
+}
+
+// relayURL reads the upstream's base URL (RELAY_URL), or "" when not relaying
+func relayURL() (string, error) {
+	raw := strings.TrimSpace(os.Getenv("RELAY_URL"))
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("RELAY_URL: %q is not an http(s) URL", raw)
+	}
+	if relayKey() == "" {
+		return "", errors.New("RELAY_URL needs RELAY_KEY")
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
+
+// relayKey is the key batches are signed with (RELAY_KEY), on both ends
+func relayKey() string {
+	return os.Getenv("RELAY_KEY")
+}
+
+// nudgeRelay wakes the relay, if it's waiting, to send a new upload
+func nudgeRelay() {
+	select {
+	case relayWake <- struct{}{}:
+	default:
+	}
+}
+
+// relayBacklog returns up to relayBatch stored uploads after the cursor, in
+// the order they were stored, and the id of the last one
+func (s *Store) relayBacklog(ctx context.Context, after int64) ([]Stats, int64, error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, device_id, timestamp, uptime_seconds, total_detections, detections_per_min,
+			current_activity_pct, peak_activity_pct, freqs, uploader_ip, bearing
+		FROM uploads WHERE id > ?
+		ORDER BY id LIMIT ?
+	`, after, relayBatch)
+	if err != nil {
+		return nil, after, err
+	}
+	defer rows.Close()
+
+	var uploads []Stats
+	last := after
+	for rows.Next() {
+		var st Stats
+		var ts, freqs string
+		var ip sql.NullString
+		if err := rows.Scan(&last, &st.DeviceID, &ts, &st.Uptime, &st.TotalDetections, &st.DetectionsPerMin,
+			&st.CurrentActivity, &st.PeakActivity, &freqs, &ip, &st.Bearing); err != nil {
+			return nil, after, err
+		}
+		st.Timestamp = parseDBTime(ts)
+		st.FreqDetections = planCounts(freqs)
+		st.UploaderIP = ip.String
+		uploads = append(uploads, st)
+	}
+	return uploads, last, rows.Err()
+}
+
+// relayPending counts stored uploads after the cursor
+func (s *Store) relayPending(ctx context.Context, after int64) int {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM uploads WHERE id > ?`, after).Scan(&n); err != nil {
+		log.Printf("Error counting uploads to relay: %v", err)
+	}
+	return n
+}
+
+// signRelay sets the signature headers for a batch
+func signRelay(h http.Header, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	h.Set(signatureTimeHeader, ts)
+	h.Set(signatureHeader, uploadSignature(relayKey(), ts, relayPath, body))
+}
+
+// verifyRelay checks a batch's signature
+func verifyRelay(h http.Header, body []byte) error {
+	sig, ts := h.Get(signatureHeader), h.Get(signatureTimeHeader)
+	if sig == "" {
+		return errUnsigned
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errStaleSignature
+	}
+	if skew := time.Since(time.Unix(secs, 0)); skew > maxClockSkew() || skew < -maxClockSkew() {
+		return errStaleSignature
+	}
+	if !hmac.Equal([]byte(uploadSignature(relayKey(), ts, relayPath, body)), []byte(sig)) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// pushRelay posts a batch to the upstream
+func pushRelay(ctx context.Context, client *http.Client, base string, uploads []Stats) (RelayResult, error) {
+	var res RelayResult
+	body, err := json.Marshal(uploads)
+	if err != nil {
+		return res, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+relayPath, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRelay(req.Header, body)
+	resp, err := client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return res, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, json.NewDecoder(resp.Body).Decode(&res)
+}
+
+// relayUploads sends stored uploads to the upstream for the life of the
+// server, a batch at a time, backing off while it can't be reached
+func (s *Store) relayUploads(base string) {
+	ctx := context.Background()
+	client := &http.Client{Timeout: relayTimeout}
+	var cursor atomic.Int64
+	start, _ := strconv.ParseInt(s.getSetting(ctx, settingRelayCursor), 10, 64)
+	cursor.Store(start)
+	fwd := registerForwarder("relay", base, func(ctx context.Context) int {
+		return s.relayPending(ctx, cursor.Load())
+	})
+	log.Printf("Relaying uploads to %s from upload %d", base, start)
+
+	retry := relayFirstRetry
+	for {
+		uploads, last, err := s.relayBacklog(ctx, cursor.Load())
+		var res RelayResult
+		if err == nil && len(uploads) > 0 {
+			res, err = pushRelay(ctx, client, base, uploads)
+		}
+		switch {
+		case err != nil:
+			if fwd.failed(err) {
+				log.Printf("Error relaying uploads to %s: %v", base, err)
+			}
+		case len(uploads) > 0:
+			cursor.Store(last)
+			if err := s.setSetting(ctx, settingRelayCursor, strconv.FormatInt(last, 10)); err != nil {
+				log.Printf("Error saving relay cursor: %v", err)
+			}
+			if res.Rejected > 0 {
+				log.Printf("Upstream rejected %d relayed upload(s) up to %d", res.Rejected, last)
+			}
+			if res.OverQuota > 0 {
+				log.Printf("Upstream refused %d relayed upload(s) up to %d over its quotas", res.OverQuota, last)
+			}
+			if down := fwd.sent(); down > 0 {
+				log.Printf("Relaying to %s again after %s", base, down.Round(time.Second))
+			}
+		}
+
+		if err != nil {
+			time.Sleep(retry)
+			retry = min(retry*2, relayMaxRetry)
+			continue
+		}
+		retry = relayFirstRetry
+		if len(uploads) == relayBatch {
+			continue // More waiting
+		}
+		select {
+		case <-relayWake:
+		case <-time.After(relayPoll):
+		}
+	}
+}
+
+// mergeRelayed merges relayed uploads as they were sent, since the relay has
+// already applied its channel maps
+func (s *Store) mergeRelayed(ctx context.Context, uploads []Stats) (ImportResult, error) {
+	var next int
+	return s.mergeUploads(ctx, func() (importRow, error) {
+		for next < len(uploads) {
+			st := uploads[next]
+			next++
+			var bearing interface{}
+			if st.Bearing != nil {
+				bearing = normalizeBearing(*st.Bearing)
+			}
+			return importRow{
+				Values: []interface{}{
+					st.DeviceID, nil, st.Uptime, st.TotalDetections, st.DetectionsPerMin,
+					st.CurrentActivity, st.PeakActivity, encodeCounts(st.FreqDetections),
+					st.UploaderIP, bearing, nil,
+				},
+				Time: st.Timestamp,
+			}, nil
+		}
+		return importRow{}, io.EOF
+	})
+}
+
+// handleAPIRelay merges a batch of uploads from a relay: POST /api/relay,
+// signed with RELAY_KEY. Uploads that fail validation are counted and dropped
+// rather than refusing the batch, which the relay would only resend.
+func handleAPIRelay(w http.ResponseWriter, r *http.Request) {
+	if relayKey() == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, relayMaxBody))
+	if err != nil {
+		http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := verifyRelay(r.Header, body); err != nil {
+		noteAbuse(r, "relay: "+err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var uploads []Stats
+	if err := json.Unmarshal(body, &uploads); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Checked as ingestion would before the merge, which holds the write lock
+	var res RelayResult
+	quarantined := store.quarantinedDevices(r.Context())
+	accepted := uploads[:0]
+	for _, st := range uploads {
+		if st.Timestamp.IsZero() || validateStats(&st) != nil {
+			res.Rejected++
+			continue
+		}
+		if err := quotas.admit(r.Context(), st.DeviceID); err != nil {
+			res.OverQuota++
+			continue
+		}
+		if quarantined[st.DeviceID] {
+			st.UploaderIP = anonymizeIP(st.UploaderIP)
+			if err := store.holdUpload(r.Context(), st.DeviceID, heldRelayed, st.Timestamp, st); err != nil {
+				log.Printf("Error holding relayed upload: %v", err)
+				rejectFailedSave(w, err)
+				return
+			}
+			quotas.charge(r.Context(), st.DeviceID, 1)
+			res.Held++
+			continue
+		}
+		accepted = append(accepted, st)
+	}
+
+	res.ImportResult, err = store.mergeRelayed(r.Context(), accepted)
+	if err != nil {
+		log.Printf("Error merging relayed uploads: %v", err)
+		rejectFailedSave(w, err)
+		return
+	}
+	// Only what was stored counts, not uploads the merge found already here
+	for id, n := range res.perDevice {
+		quotas.charge(r.Context(), id, n)
+	}
+	if res.Imported > 0 || res.Rejected > 0 || res.OverQuota > 0 || res.Held > 0 {
+		log.Printf("Relayed %d uploads (%d already present, %d rejected, %d over quota, %d held)",
+			res.Imported, res.Skipped, res.Rejected, res.OverQuota, res.Held)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
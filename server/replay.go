@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The replay command posts captured uploads to a server again, spaced as they
+// were recorded (scaled by -speed) or as fast as -concurrency allows, to
+// reproduce a bug with the traffic that caused it or to load-test with real
+// traffic shapes rather than simulate's patterns. It reads, in file order:
+//
+//   - NDJSON, one upload per line as posted to /upload, with its "timestamp"
+//     when it has one (lines without are spaced -interval apart);
+//   - firmware or serial logs, from their "Payload: {...}" lines;
+//   - CSV written by export, detected by its header.
+//
+// device_time and last_ack are dropped: the capture's clock would be refused
+// as skewed, and its acks belong to another server. Uploads aren't signed, so
+// replay into a server that doesn't require signatures for those devices.
+
+// replayUpload is one captured upload and when it was originally received
+type replayUpload struct {
+	stats Stats
+	at    time.Time // zero if the capture doesn't say
+}
+
+// replayReader returns a function yielding the uploads in r, then io.EOF
+func replayReader(r io.Reader) (func() (replayUpload, error), error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(len("device_id,timestamp"))
+	if string(head) == "device_id,timestamp" {
+		return replayCSV(br)
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), maxUploadBody)
+	return func() (replayUpload, error) {
+		for scanner.Scan() {
+			// NDJSON is bare JSON lines, which parseSerialLine also takes
+			st, ok := parseSerialLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			up := replayUpload{stats: st, at: st.Timestamp}
+			return up, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return replayUpload{}, err
+		}
+		return replayUpload{}, io.EOF
+	}, nil
+}
+
+// replayCSV reads an export's rows as uploads
+func replayCSV(r io.Reader) (func() (replayUpload, error), error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	return func() (replayUpload, error) {
+		rec, err := cr.Read()
+		if err != nil {
+			return replayUpload{}, err
+		}
+		field := func(name string) string {
+			if j, ok := col[name]; ok && j < len(rec) {
+				return rec[j]
+			}
+			return ""
+		}
+		num := func(name string) int {
+			n, _ := strconv.Atoi(field(name))
+			return n
+		}
+		st := Stats{
+			DeviceID:         field("device_id"),
+			Uptime:           num("uptime_seconds"),
+			TotalDetections:  num("total_detections"),
+			DetectionsPerMin: num("detections_per_min"),
+			CurrentActivity:  num("current_activity_pct"),
+			PeakActivity:     num("peak_activity_pct"),
+			FreqDetections:   parseCounts(field("freqs")),
+		}
+		if _, ok := col["freqs"]; !ok {
+			for i := 0; ; i++ {
+				if _, ok := col[fmt.Sprintf("freq_%d", i)]; !ok {
+					break
+				}
+				st.FreqDetections = append(st.FreqDetections, num(fmt.Sprintf("freq_%d", i)))
+			}
+		}
+		if b, err := strconv.ParseFloat(field("bearing"), 64); err == nil {
+			st.Bearing = &b
+		}
+		at, err := parseImportTime(field("timestamp"), time.Local)
+		if err != nil {
+			return replayUpload{}, err
+		}
+		return replayUpload{stats: st, at: at}, nil
+	}, nil
+}
+
+// replayStats counts what the server said, and how quickly
+type replayStats struct {
+	mu        sync.Mutex
+	sent      int
+	failed    int // no response at all
+	codes     map[int]int
+	latencies []time.Duration
+}
+
+func (r *replayStats) record(code int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent++
+	if code == 0 {
+		r.failed++
+		return
+	}
+	r.codes[code]++
+	r.latencies = append(r.latencies, d)
+}
+
+// report prints progress, or the totals once done
+func (r *replayStats) report(label string, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lat := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(lat, func(a, b int) bool { return lat[a] < lat[b] })
+	pct := func(p int) time.Duration {
+		if len(lat) == 0 {
+			return 0
+		}
+		return lat[min(len(lat)-1, len(lat)*p/100)].Round(time.Microsecond)
+	}
+	var codes []string
+	for _, c := range sortedKeys(r.codes) {
+		codes = append(codes, fmt.Sprintf("%d×%d", r.codes[c], c))
+	}
+	if r.failed > 0 {
+		codes = append(codes, fmt.Sprintf("%d×no response", r.failed))
+	}
+	fmt.Printf("%s %s: %d uploads (%.0f/s), p50 %s, p99 %s, %s\n", label, elapsed.Round(time.Second), r.sent,
+		float64(r.sent)/max(elapsed.Seconds(), 0.001), pct(50), pct(99), strings.Join(codes, " "))
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// runReplay posts the uploads in the given files to -url
+func runReplay(args []string) error {
+	fs := newFlagSet("replay", "uploads.ndjson|export.csv|- ...")
+	target := fs.String("url", "http://localhost:8080/upload", "upload URL to post to")
+	speed := fs.Float64("speed", 1, "playback speed: 1 keeps the captured spacing, 60 plays an hour a minute, 0 sends as fast as possible")
+	interval := fs.Duration("interval", time.Minute, "spacing for uploads the capture has no time for")
+	concurrency := fs.Int("concurrency", 8, "uploads in flight at once")
+	prefix := fs.String("prefix", "", "prepended to every device ID, to keep replayed devices apart from real ones")
+	limit := fs.Int("limit", 0, "stop after this many uploads (0 = all)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no capture to replay")
+	}
+	if *speed < 0 || *concurrency < 1 || *interval <= 0 {
+		return errors.New("-speed can't be negative; -concurrency and -interval must be positive")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	results := &replayStats{codes: make(map[int]int)}
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	nextReport := start.Add(10 * time.Second)
+
+	// The capture's clock: the first upload's time, and where we are in it
+	var origin, captured time.Time
+	n := 0
+	for _, path := range fs.Args() {
+		var f io.Reader = os.Stdin
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			f = file
+		}
+		next, err := replayReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for *limit == 0 || n < *limit {
+			up, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: upload %d: %w", path, n+1, err)
+			}
+			switch {
+			case origin.IsZero():
+				captured = up.at
+				if captured.IsZero() {
+					captured = time.Unix(0, 0)
+				}
+				origin = captured
+			case up.at.IsZero():
+				captured = captured.Add(*interval)
+			case up.at.After(captured):
+				captured = up.at
+			}
+			if *speed > 0 {
+				due := start.Add(time.Duration(float64(captured.Sub(origin)) / *speed))
+				time.Sleep(time.Until(due))
+			}
+
+			st := up.stats
+			st.DeviceID = *prefix + st.DeviceID
+			st.DeviceTime, st.LastAck, st.AckID = 0, nil, 0
+			st.Timestamp, st.UploaderIP = time.Time{}, ""
+			body, _ := json.Marshal(st)
+			n++
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				sent := time.Now()
+				resp, err := client.Post(*target, "application/json", bytes.NewReader(body))
+				if err != nil {
+					results.record(0, 0)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				results.record(resp.StatusCode, time.Since(sent))
+			}()
+
+			if now := time.Now(); now.After(nextReport) {
+				results.report("progress", now.Sub(start))
+				nextReport = now.Add(10 * time.Second)
+			}
+		}
+	}
+	wg.Wait()
+	results.report("replayed", time.Since(start))
+	if n == 0 {
+		return errors.New("no uploads found in the capture")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Continuous replication is delegated to Litestream (https://litestream.io),
+// which ships WAL frames to S3-compatible storage. The server restores the
+// database from the replica when the local file is missing and keeps
+// "litestream replicate" running alongside itself.
+
+// replicationRestartDelay is how long to wait before restarting a failed Litestream
+const replicationRestartDelay = 10 * time.Second
+
+// replicating reports whether continuous replication is configured
+func (c Config) replicating() bool {
+	return c.ReplicaURL != "" || c.LitestreamConfig != ""
+}
+
+// litestreamArgs builds a Litestream command line: with a config file the
+// database and replicas come from it, otherwise from the path and URL
+func litestreamArgs(cfg Config, dbPath, command string, flags ...string) []string {
+	args := append([]string{command}, flags...)
+	if cfg.LitestreamConfig != "" {
+		args = append(args, "-config", cfg.LitestreamConfig)
+		if command == "restore" {
+			args = append(args, dbPath)
+		}
+		return args
+	}
+	if command == "restore" {
+		return append(args, "-o", dbPath, cfg.ReplicaURL)
+	}
+	return append(args, dbPath, cfg.ReplicaURL)
+}
+
+// restoreFromReplica fetches the latest replicated copy when there is no local
+// database, e.g. after an SD card is replaced. A replica that doesn't exist yet
+// (first run) is not an error.
+func restoreFromReplica(cfg Config, dbPath string) error {
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	log.Printf("No database at %s; restoring from replica if one exists", dbPath)
+	cmd := exec.Command(cfg.LitestreamBin, litestreamArgs(cfg, dbPath, "restore", "-if-replica-exists")...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
+
+// superviseReplication runs "litestream replicate" for the life of the server,
+// restarting it whenever it exits
+func superviseReplication(cfg Config, dbPath string) {
+	for {
+		cmd := exec.Command(cfg.LitestreamBin, litestreamArgs(cfg, dbPath, "replicate")...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		stopWithServer(cmd)
+		log.Printf("Replicating %s with %s", dbPath, cfg.LitestreamBin)
+		err := cmd.Run()
+		log.Printf("Litestream exited: %v; restarting in %s", err, replicationRestartDelay)
+		time.Sleep(replicationRestartDelay)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// stopWithServer makes the kernel stop cmd if the server dies, so a restarted
+// server doesn't end up with two replicators
+func stopWithServer(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// stopWithServer is a no-op here; a Litestream left behind by a crashed server
+// has to be stopped by hand
+func stopWithServer(cmd *exec.Cmd) {}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The reprocess command rebuilds a database from the raw upload log (see
+// rawlog.go) by running every upload through ingestion again, with the
+// server's clock (timeNow) set to when it was received. Everything derived
+// from uploads is recomputed by this build's code, so a new way of counting
+// deltas, classifying channels or raising alerts applies to the whole history:
+// the uploads themselves, health, acks, alerts, change points found as each
+// day passed, and, once the log is in, the hourly and daily rollups the
+// retention policy calls for. Devices, users, annotations and everything else
+// the raw log doesn't hold are copied from -from.
+//
+// The database is written to a new file; swap it in with the server stopped.
+
+// reprocessDerived are the tables rebuilt from the raw log rather than copied
+var reprocessDerived = []string{
+	"uploads", "upload_rollups", "rollup_cursors", "device_health", "upload_acks",
+	"alerts", "change_points", "webhook_deliveries",
+}
+
+// copyUnderived copies every table of the database at path that the raw log
+// can't rebuild, by the columns both schemas have. Annotations that mark a
+// change point are left behind with it, to be pinned again when it is found.
+func copyUnderived(ctx context.Context, path string) error {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	// The writer is one connection, so the attachment lasts for the copy
+	if _, err := store.db.ExecContext(ctx, `ATTACH DATABASE ? AS old`, "file:"+path+"?mode=ro"); err != nil {
+		return err
+	}
+	defer store.db.ExecContext(context.Background(), `DETACH DATABASE old`)
+
+	tables, err := tableNames(ctx, "old")
+	if err != nil {
+		return err
+	}
+	have, err := tableNames(ctx, "main")
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if slices.Contains(reprocessDerived, t) || !slices.Contains(have, t) {
+			continue
+		}
+		cols, err := sharedColumns(ctx, t)
+		if err != nil {
+			return err
+		}
+		query := `INSERT OR REPLACE INTO main.` + t + ` (` + cols + `) SELECT ` + cols + ` FROM old.` + t
+		if t == "annotations" && slices.Contains(tables, "change_points") {
+			query += ` WHERE id NOT IN (SELECT annotation_id FROM old.change_points WHERE annotation_id IS NOT NULL)`
+		}
+		if _, err := store.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("copying %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// tableNames lists a schema's tables
+func tableNames(ctx context.Context, schema string) ([]string, error) {
+	rows, err := store.db.QueryContext(ctx, `SELECT name FROM `+schema+`.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// sharedColumns lists the columns a table has in both main and old, comma-separated
+func sharedColumns(ctx context.Context, table string) (string, error) {
+	columns := func(schema string) ([]string, error) {
+		rows, err := store.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?, ?)`, table, schema)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+		return names, rows.Err()
+	}
+	mine, err := columns("main")
+	if err != nil {
+		return "", err
+	}
+	theirs, err := columns("old")
+	if err != nil {
+		return "", err
+	}
+	var shared []string
+	for _, c := range mine {
+		if slices.Contains(theirs, c) {
+			shared = append(shared, c)
+		}
+	}
+	return strings.Join(shared, ", "), nil
+}
+
+// reprocessResult counts what a rebuild did
+type reprocessResult struct {
+	uploads, rejected, malformed, failed int
+	checks                               int // days change points were searched
+}
+
+// reprocessFile ingests one raw log's uploads in order. Before the first
+// upload of each new day, change points are searched as watchChangePoints
+// would have, with the clock at that upload.
+func reprocessFile(ctx context.Context, path string, res *reprocessResult, lastDay *string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxUploadBody)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var st Stats
+		if err := json.Unmarshal(line, &st); err != nil || st.Timestamp.IsZero() {
+			// Most likely the last line of a file cut short by a crash
+			res.malformed++
+			continue
+		}
+		if err := validateStats(&st); err != nil {
+			res.rejected++
+			continue
+		}
+
+		at := st.Timestamp
+		timeNow = func() time.Time { return at }
+		if day := at.UTC().Format("2006-01-02"); day != *lastDay {
+			if *lastDay != "" {
+				for id := range store.latestStats() {
+					store.detectChangePoints(ctx, id)
+				}
+				res.checks++
+			}
+			*lastDay = day
+		}
+		if _, err := ingestStats(ctx, &st); err != nil {
+			res.failed++
+			continue
+		}
+		res.uploads++
+		if res.uploads%10000 == 0 {
+			fmt.Printf("  %d uploads, up to %s\n", res.uploads, at.Format(time.DateTime))
+		}
+	}
+	return scanner.Err()
+}
+
+// runReprocess rebuilds a database from raw logs into a new file
+func runReprocess(args []string) error {
+	ctx := context.Background()
+	fs := newFlagSet("reprocess", "-o rebuilt.db [-from lora.db] raw/uploads-*.ndjson raw/uploads.ndjson")
+	out := fs.String("o", "", "database file to write; must not exist")
+	from := fs.String("from", "", "database to copy devices, users, annotations, settings and other data the raw log doesn't hold from")
+	fs.Parse(args)
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("-o and at least one raw log are required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists; reprocess writes a new database", *out)
+	}
+	if *from != "" {
+		if _, err := os.Stat(*from); err != nil {
+			return err
+		}
+	}
+
+	if _, err := openStore(Config{DBPath: *out}); err != nil {
+		return err
+	}
+	defer store.db.Close()
+	if *from != "" {
+		if err := copyUnderived(ctx, *from); err != nil {
+			return fmt.Errorf("copying from %s: %w", *from, err)
+		}
+		store.loadFrequencyPlan(ctx)
+		fmt.Printf("Copied devices, settings and other data from %s\n", *from)
+	}
+
+	// ingestStats logs every upload; keep only the count of errors
+	var logged errorLog
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	defer func() { timeNow = time.Now }()
+
+	start := time.Now()
+	var res reprocessResult
+	var lastDay string
+	for _, path := range fs.Args() {
+		fmt.Printf("%s\n", path)
+		if err := reprocessFile(ctx, path, &res, &lastDay); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	// Retention is applied as of now, folding old uploads into rollups
+	timeNow = time.Now
+	if err := store.applyRetention(ctx, retentionPolicy()); err != nil {
+		return fmt.Errorf("applying retention: %w", err)
+	}
+	log.SetOutput(os.Stderr)
+
+	fmt.Printf("Reprocessed %d uploads in %s; change points searched on %d days\n",
+		res.uploads, time.Since(start).Round(time.Second), res.checks)
+	if res.rejected > 0 {
+		fmt.Printf("  %d uploads fail this build's validation and were left out\n", res.rejected)
+	}
+	if res.malformed > 0 {
+		fmt.Printf("  %d lines couldn't be read (cut short, or no timestamp)\n", res.malformed)
+	}
+	if n := logged.n.Load(); n > 0 {
+		fmt.Printf("  %d errors logged while ingesting\n", n)
+	}
+	if res.failed > 0 {
+		return fmt.Errorf("%d uploads couldn't be stored; %s is incomplete", res.failed, *out)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention tiers: raw uploads are folded into hourly rollups before they are
+// deleted, and hourly rollups into daily ones. Every upload is counted in
+// exactly one tier, so period totals add the raw table and the rollups.
+
+// Rollup tiers in upload_rollups
+const (
+	tierHour = "hour"
+	tierDay  = "day"
+)
+
+// retentionInterval is how often the server applies the retention policy
+const retentionInterval = time.Hour
+
+// retentionDays reads a tier's retention in days from the environment; 0 keeps forever
+func retentionDays(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// RetentionPolicy is how many days each tier is kept; 0 means forever
+type RetentionPolicy struct {
+	RawDays    int `json:"raw_days"`
+	HourlyDays int `json:"hourly_days"`
+	DailyDays  int `json:"daily_days"`
+}
+
+func retentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		RawDays:    retentionDays("RETAIN_RAW_DAYS", 30),
+		HourlyDays: retentionDays("RETAIN_HOURLY_DAYS", 365),
+		DailyDays:  retentionDays("RETAIN_DAILY_DAYS", 0),
+	}
+}
+
+// rollupSumColumns are added together when rollups merge
+var rollupSumColumns = []string{
+	"uploads", "total_detections", "uptime_seconds", "sum_detections_per_min", "sum_activity_pct",
+}
+
+// rollupCountColumns hold per-frequency counts and histograms, added
+// element-wise when rollups merge (see channels.go and stats.go)
+var rollupCountColumns = []string{"freqs", "new_freqs", "rate_hist", "activity_hist"}
+
+// rollup accumulates one device's uploads over one period
+type rollup struct {
+	uploads, detections, uptime, sumRate, sumActivity, peak int
+	freqs, increases, rateHist, activityHist                []int
+}
+
+// rollupCursor is the last upload folded into a device's rollups, so the next
+// one's increase can be worked out after the raw row is gone
+type rollupCursor struct {
+	uptime int
+	freqs  []int
+}
+
+// applyRetention rolls up and deletes data that has aged out of each tier
+func (s *Store) applyRetention(ctx context.Context, p RetentionPolicy) error {
+	if p.RawDays > 0 {
+		n, err := s.rollupRaw(ctx, dbTimeAgo(time.Duration(p.RawDays)*24*time.Hour))
+		if err != nil {
+			return fmt.Errorf("rolling up uploads: %w", err)
+		}
+		if n > 0 {
+			log.Printf("Rolled %d uploads older than %d days into hourly totals", n, p.RawDays)
+		}
+	}
+	if p.HourlyDays > 0 {
+		if err := s.rollupHourly(ctx, dbTimeAgo(time.Duration(p.HourlyDays)*24*time.Hour)); err != nil {
+			return fmt.Errorf("rolling up hourly totals: %w", err)
+		}
+	}
+	if p.DailyDays > 0 {
+		ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+		defer cancel()
+		if _, err := s.exec(ctx, `DELETE FROM upload_rollups WHERE tier = ? AND period < ?`,
+			tierDay, dbTimeAgo(time.Duration(p.DailyDays)*24*time.Hour)); err != nil {
+			return fmt.Errorf("deleting daily totals: %w", err)
+		}
+	}
+	return nil
+}
+
+// enforceRetention applies the policy now and then every retentionInterval
+func (s *Store) enforceRetention() {
+	for {
+		if err := s.applyRetention(context.Background(), retentionPolicy()); err != nil {
+			log.Printf("Error applying retention: %v", err)
+		}
+		time.Sleep(retentionInterval)
+	}
+}
+
+// rollupRaw folds uploads older than cutoff into hourly rollups and deletes
+// them. Rows stream in device and time order, so only one hour is held at once.
+func (s *Store) rollupRaw(ctx context.Context, cutoff string) (int, error) {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT device_id, timestamp, COALESCE(uptime_seconds, 0), COALESCE(total_detections, 0),
+			COALESCE(detections_per_min, 0), COALESCE(current_activity_pct, 0), COALESCE(peak_activity_pct, 0),
+			freqs
+		FROM uploads WHERE timestamp < ?
+		ORDER BY device_id, timestamp, id
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var device, hour string
+	var cur rollup
+	var cursor rollupCursor
+	haveCursor := false
+	n := 0
+	flush := func() error {
+		if cur.uploads == 0 {
+			return nil
+		}
+		err := addRollup(ctx, tx, tierHour, device, hour, cur)
+		cur = rollup{}
+		return err
+	}
+	for rows.Next() {
+		var id, ts string
+		var det, rate, act, peak int
+		var freqs string
+		var next rollupCursor
+		if err := rows.Scan(&id, &ts, &next.uptime, &det, &rate, &act, &peak, &freqs); err != nil {
+			return 0, err
+		}
+		f := parseCounts(freqs)
+		next.freqs = f
+		h := parseDBTime(ts).Truncate(time.Hour).Format("2006-01-02 15:04:05")
+		if id != device || h != hour {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			if id != device {
+				if haveCursor {
+					if err := saveRollupCursor(ctx, tx, device, cursor); err != nil {
+						return 0, err
+					}
+				}
+				cursor, haveCursor = loadRollupCursor(ctx, tx, id)
+			}
+			device, hour = id, h
+		}
+
+		// Counters are cumulative per boot (see getActivityBuckets)
+		sameSession := haveCursor && next.uptime >= cursor.uptime
+		var prev []int
+		if sameSession {
+			prev = cursor.freqs
+		}
+		cur.increases = addCounts(cur.increases, countIncreases(prev, f))
+		cur.freqs = addCounts(cur.freqs, f)
+		cur.uploads++
+		cur.detections += det
+		cur.uptime += next.uptime
+		cur.sumRate += rate
+		cur.sumActivity += act
+		cur.rateHist = addCounts(cur.rateHist, histOf(rate))
+		cur.activityHist = addCounts(cur.activityHist, histOf(act))
+		cur.peak = max(cur.peak, peak)
+		cursor, haveCursor = next, true
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	if haveCursor {
+		if err := saveRollupCursor(ctx, tx, device, cursor); err != nil {
+			return 0, err
+		}
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM uploads WHERE timestamp < ?`, cutoff); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// rollupHourly folds hourly rollups older than cutoff into daily ones
+func (s *Store) rollupHourly(ctx context.Context, cutoff string) error {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sums []string
+	for _, c := range rollupSumColumns {
+		sums = append(sums, "SUM("+c+")")
+	}
+	for _, c := range rollupCountColumns {
+		sums = append(sums, "COALESCE(counts_sum("+c+"), '')")
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO upload_rollups (tier, device_id, period, peak_activity_pct, `+rollupColumns+`)
+		SELECT ?, device_id, date(period) || ' 00:00:00', MAX(peak_activity_pct), `+strings.Join(sums, ", ")+`
+		FROM upload_rollups WHERE tier = ? AND period < ?
+		GROUP BY device_id, date(period)
+		`+rollupConflict,
+		tierDay, tierHour, cutoff)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM upload_rollups WHERE tier = ? AND period < ?`, tierHour, cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollupColumns lists the summed columns of upload_rollups, counts last
+var rollupColumns = strings.Join(append(slices.Clone(rollupSumColumns), rollupCountColumns...), ", ")
+
+// rollupConflict merges into an existing rollup for the same period
+var rollupConflict = func() string {
+	set := []string{"peak_activity_pct = MAX(peak_activity_pct, excluded.peak_activity_pct)"}
+	for _, c := range rollupSumColumns {
+		set = append(set, c+" = "+c+" + excluded."+c)
+	}
+	for _, c := range rollupCountColumns {
+		set = append(set, c+" = counts_add("+c+", excluded."+c+")")
+	}
+	return "ON CONFLICT(tier, device_id, period) DO UPDATE SET " + strings.Join(set, ", ")
+}()
+
+// addRollup merges r into the device's rollup for period
+func addRollup(ctx context.Context, tx *sql.Tx, tier, deviceID, period string, r rollup) error {
+	args := []interface{}{tier, deviceID, period, r.peak,
+		r.uploads, r.detections, r.uptime, r.sumRate, r.sumActivity,
+		encodeCounts(r.freqs), encodeCounts(r.increases), encodeCounts(r.rateHist), encodeCounts(r.activityHist)}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO upload_rollups (tier, device_id, period, peak_activity_pct, `+rollupColumns+`)
+		VALUES (?, ?, ?, ?`+strings.Repeat(", ?", len(args)-4)+`)
+		`+rollupConflict, args...)
+	return err
+}
+
+func loadRollupCursor(ctx context.Context, tx *sql.Tx, deviceID string) (rollupCursor, bool) {
+	var c rollupCursor
+	var freqs string
+	err := tx.QueryRowContext(ctx, `
+		SELECT uptime_seconds, freqs FROM rollup_cursors WHERE device_id = ?
+	`, deviceID).Scan(&c.uptime, &freqs)
+	c.freqs = parseCounts(freqs)
+	return c, err == nil
+}
+
+func saveRollupCursor(ctx context.Context, tx *sql.Tx, deviceID string, c rollupCursor) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO rollup_cursors (device_id, uptime_seconds, freqs) VALUES (?, ?, ?)
+	`, deviceID, c.uptime, encodeCounts(c.freqs))
+	return err
+}
+
+// uploadsSince is a subquery over raw uploads and rollups from start on,
+// one row per upload or rollup. Averages are carried as sums, so readers
+// divide SUM(detections_per_min) by SUM(uploads) rather than using AVG.
+func uploadsSince(start string, deviceIDs []string) (string, []interface{}) {
+	cond, args := deviceFilter(deviceIDs)
+	query := `
+		SELECT device_id, timestamp, 1 AS uploads, total_detections, uptime_seconds,
+			detections_per_min, current_activity_pct, peak_activity_pct, freqs,
+			hist_of(detections_per_min) AS rate_hist, hist_of(current_activity_pct) AS activity_hist
+		FROM uploads WHERE timestamp >= ? AND ` + cond + `
+		UNION ALL
+		SELECT device_id, period, uploads, total_detections, uptime_seconds,
+			sum_detections_per_min, sum_activity_pct, peak_activity_pct, freqs, rate_hist, activity_hist
+		FROM upload_rollups WHERE period >= ? AND ` + cond
+	all := append([]interface{}{start}, args...)
+	all = append(all, start)
+	return query, append(all, args...)
+}
+
+// rawCursor is a device's rollup cursor and the timestamp of its oldest raw
+// upload, the one that follows the cursor
+type rawCursor struct {
+	rollupCursor
+	next string
+}
+
+// rollupCursors loads every device's cursor, for readers that work out
+// increases from raw uploads
+func (s *Store) rollupCursors(ctx context.Context) map[string]rawCursor {
+	cursors := make(map[string]rawCursor)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT c.device_id, c.uptime_seconds, c.freqs,
+			(SELECT MIN(timestamp) FROM uploads u WHERE u.device_id = c.device_id)
+		FROM rollup_cursors c
+	`)
+	if err != nil {
+		log.Printf("Error loading rollup cursors: %v", err)
+		return cursors
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var freqs string
+		var next sql.NullString
+		var c rawCursor
+		if err := rows.Scan(&id, &c.uptime, &freqs, &next); err != nil {
+			continue
+		}
+		c.freqs = parseCounts(freqs)
+		c.next = next.String
+		cursors[id] = c
+	}
+	return cursors
+}
+
+// increaseTracker works out each upload's increase over the previous one from
+// uploads read in device and time order. Counters are cumulative per boot, and
+// when the previous upload has been rolled up its cursor stands in for it.
+type increaseTracker struct {
+	cursors map[string]rawCursor
+	before  map[string]rollupCursor // Each device's last upload before the window, if read
+	device  string
+	uptime  int
+	prev    []int
+}
+
+// next returns an upload's per-frequency increase; none is ever negative
+func (t *increaseTracker) next(deviceID, ts string, uptime int, f []int) []int {
+	if c, ok := t.before[deviceID]; ok && deviceID != t.device {
+		t.device, t.uptime, t.prev = deviceID, c.uptime, c.freqs
+	}
+	if c, ok := t.cursors[deviceID]; ok && deviceID != t.device && parseDBTime(ts).Equal(parseDBTime(c.next)) {
+		// The upload before this one has been rolled up
+		t.device, t.uptime, t.prev = deviceID, c.uptime, c.freqs
+	}
+	var prev []int
+	if deviceID == t.device && uptime >= t.uptime {
+		prev = t.prev
+	}
+	t.device, t.uptime, t.prev = deviceID, uptime, f
+	return countIncreases(prev, f)
+}
+
+// uploadsBefore reads each device's last raw upload before ts, so a window
+// starting part-way through history counts its first upload's increase rather
+// than everything since boot. No device IDs means every known device.
+func (s *Store) uploadsBefore(ctx context.Context, deviceIDs []string, ts string) map[string]rollupCursor {
+	if len(deviceIDs) == 0 {
+		deviceIDs = slices.Collect(maps.Keys(s.latestStats()))
+	}
+	before := make(map[string]rollupCursor)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	for _, id := range deviceIDs {
+		var c rollupCursor
+		var freqs string
+		err := s.queryRow(ctx, `
+			SELECT uptime_seconds, freqs FROM uploads
+			WHERE device_id = ? AND timestamp < ? ORDER BY timestamp DESC, id DESC LIMIT 1
+		`, id, ts).Scan(&c.uptime, &freqs)
+		if err == nil {
+			c.freqs = parseCounts(freqs)
+			before[id] = c
+		}
+	}
+	return before
+}
+
+// countIncreases returns how far each count has risen since prev, or the count
+// itself with no prev; none is ever negative
+func countIncreases(prev, counts []int) []int {
+	delta := make([]int, len(counts))
+	for i, c := range counts {
+		if i < len(prev) {
+			c -= prev[i]
+		}
+		delta[i] = max(c, 0)
+	}
+	return delta
+}
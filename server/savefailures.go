@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// An upload that can't be saved is handed back to the device to resend: 503
+// with Retry-After when the failure may clear up (the write lock is held too
+// long, a timeout, a full disk before the size cap catches up), 500 when
+// retrying won't fix it. Nothing is kept here to retry later. The database
+// that refused the upload is the only durable place to keep it, and one held
+// in memory would be lost with the process after the device had been told it
+// was safe and dropped its copy.
+var (
+	// errSaveUnavailable means the save failed but may succeed if resent later
+	errSaveUnavailable = errors.New("database unavailable; resend later")
+	// errSaveFailed means the save failed in a way retrying won't fix
+	errSaveFailed = errors.New("database rejected the upload")
+)
+
+// SaveCounters counts upload save failures since startup, for /api/admin/status
+type SaveCounters struct {
+	Failures int64 `json:"failures"` // Failed saveUpload calls
+	Deferred int64 `json:"deferred"` // Of those, answered 503 for the device to resend
+	Rejected int64 `json:"rejected"` // Of those, answered 500: retrying won't help
+}
+
+// saveCounters are the live counts behind SaveCounters
+type saveCounters struct {
+	failures, deferred, rejects atomic.Int64
+}
+
+// retryableSaveError reports whether a failed save might succeed later
+func retryableSaveError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		switch se.Code() & 0xff { // Extended codes keep the primary code in the low byte
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_FULL, sqlite3.SQLITE_IOERR:
+			return true
+		}
+	}
+	return false
+}
+
+// saveOrReject saves an upload, issuing its ack if ack is set. If the save
+// fails it returns errSaveUnavailable or errSaveFailed, and the device should
+// resend the upload.
+func (s *Store) saveOrReject(ctx context.Context, stats Stats, ack *ackRequest) error {
+	err := s.saveUpload(ctx, stats, ack)
+	if err == nil {
+		return nil
+	}
+	c := &s.saves
+	c.failures.Add(1)
+	log.Printf("Error saving upload from %s: %v", stats.DeviceID, err)
+	if !retryableSaveError(err) {
+		c.rejects.Add(1)
+		return errSaveFailed
+	}
+	c.deferred.Add(1)
+	return errSaveUnavailable
+}
+
+// counters snapshots the counts
+func (c *saveCounters) counters() SaveCounters {
+	return SaveCounters{
+		Failures: c.failures.Load(),
+		Deferred: c.deferred.Load(),
+		Rejected: c.rejects.Load(),
+	}
+}
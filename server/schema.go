@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// /api/schema describes the JSON this server accepts, as JSON Schema (2020-12),
+// for firmware authors to check payloads against the server they upload to.
+// It is built from the same limits validateStats and validateEvents apply, so
+// it can't drift from them. Limits a payload is refused for (422) are schema
+// keywords; values that are only dropped are described instead. Fields the server fills in (timestamp,
+// uploader_ip, ack_id) are left out; anything a device sends for them is
+// overwritten. Every field is optional and unknown ones are ignored, so the
+// schema allows them too.
+
+// jsonSchema is one node of a JSON Schema document
+type jsonSchema map[string]interface{}
+
+func counterSchema(desc string) jsonSchema {
+	return jsonSchema{"type": "integer", "minimum": 0, "maximum": maxCounter, "description": desc}
+}
+
+func percentSchema(desc string) jsonSchema {
+	return jsonSchema{"type": "integer", "minimum": 0, "maximum": 100, "description": desc}
+}
+
+func deviceIDSchema() jsonSchema {
+	return jsonSchema{
+		"type": "string", "maxLength": maxDeviceIDLen,
+		"description": fmt.Sprintf("At most %d bytes of UTF-8 without control characters; empty is stored as \"unknown\"", maxDeviceIDLen),
+	}
+}
+
+func deviceTimeSchema() jsonSchema {
+	return jsonSchema{
+		"type": "integer", "minimum": 0,
+		"description": fmt.Sprintf("Device clock in unix seconds; uploads more than %d seconds off the server's clock are refused (sync from /api/time)", int(maxClockSkew().Seconds())),
+	}
+}
+
+// uploadSchema describes the body of POST /upload. slots is how many
+// freq_detections entries the device is expected to send.
+func uploadSchema(slots int) jsonSchema {
+	plan := frequencies()
+	mhz := make([]string, len(plan))
+	for i, f := range plan {
+		mhz[i] = f.MHz
+	}
+	return jsonSchema{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Detector upload (POST /upload)",
+		"type":        "object",
+		"description": "Cumulative counters since boot. A payload that fails these limits is answered with 422 and shouldn't be resent.",
+		"properties": jsonSchema{
+			"device_id":            deviceIDSchema(),
+			"uptime_seconds":       counterSchema("Seconds since boot; going backwards marks a reboot"),
+			"total_detections":     counterSchema("Detections since boot"),
+			"detections_per_min":   counterSchema("Detections in the last minute"),
+			"current_activity_pct": percentSchema("Share of recent scans with a detection"),
+			"peak_activity_pct":    percentSchema("Highest activity since boot"),
+			"freq_detections": jsonSchema{
+				"type": "array", "maxItems": maxChannels, "items": counterSchema(""),
+				"description":       fmt.Sprintf("Detections since boot per scan slot, in the device's channel order; %d expected", slots),
+				"x-expected-items":  slots,
+				"x-frequencies-mhz": mhz,
+			},
+			"bearing_deg": jsonSchema{"type": "number", "description": "Antenna heading in degrees, for directional rigs"},
+			"latitude":    jsonSchema{"type": "number", "minimum": -90, "maximum": 90},
+			"longitude":   jsonSchema{"type": "number", "minimum": -180, "maximum": 180},
+			"device_time": deviceTimeSchema(),
+			"last_ack": jsonSchema{
+				"type": "integer", "minimum": 0,
+				"description": "The last ack_id the server answered with, so it can report uploads it never got",
+			},
+			"free_heap":     jsonSchema{"type": "integer", "description": "Bytes"},
+			"wifi_rssi":     jsonSchema{"type": "integer", "description": "dBm"},
+			"battery_v":     jsonSchema{"type": "number", "minimum": -100, "maximum": 100, "description": "Volts"},
+			"temperature_c": jsonSchema{"type": "number", "minimum": -1000, "maximum": 1000, "description": "Board temperature"},
+			"reset_reason":  jsonSchema{"type": "string", "description": fmt.Sprintf("Sent once after boot; cut to %d bytes", maxHealthText)},
+			"last_error":    jsonSchema{"type": "string", "description": fmt.Sprintf("Cut to %d bytes", maxHealthText)},
+		},
+	}
+}
+
+// eventsSchema describes the body of POST /events
+func eventsSchema() jsonSchema {
+	when := jsonSchema{
+		"time": jsonSchema{"type": "integer", "minimum": 0, "description": "Unix seconds, for devices with a clock"},
+		"ago": jsonSchema{
+			"type": "integer", "minimum": 0, "maximum": maxEventAgeSecs,
+			"description": "Seconds before the upload, for devices without a clock",
+		},
+	}
+	// Out-of-range event details are dropped rather than refused, so their
+	// ranges are only described
+	event := jsonSchema{
+		"freq_index": jsonSchema{
+			"type":        "integer",
+			"description": fmt.Sprintf("Scan slot, 0-%d; events outside that are dropped", maxChannels-1),
+		},
+		"rssi": jsonSchema{"type": "number", "description": "dBm"},
+		"sf": jsonSchema{
+			"type":        "integer",
+			"description": fmt.Sprintf("Spreading factor %d-%d, if the header was demodulated; others are dropped", minSpreadingFactor, maxSpreadingFactor),
+		},
+		"bw": jsonSchema{
+			"type":             "number",
+			"description":      "Bandwidth in kHz; kept when within 1% of a LoRa bandwidth",
+			"x-bandwidths-khz": loraBandwidths,
+		},
+		"len": jsonSchema{
+			"type":        "integer",
+			"description": fmt.Sprintf("Payload length in bytes, 0-%d; others are dropped", maxPayloadBytes),
+		},
+	}
+	for k, v := range when {
+		event[k] = v
+	}
+	bin := jsonSchema{
+		"counts": jsonSchema{
+			"type": "array", "maxItems": maxChannels, "items": jsonSchema{"type": "integer"},
+			"description": "Detections in the minute per scan slot",
+		},
+	}
+	for k, v := range when {
+		bin[k] = v
+	}
+	return jsonSchema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Detection events (POST /events)",
+		"type":    "object",
+		"properties": jsonSchema{
+			"device_id":   deviceIDSchema(),
+			"device_time": deviceTimeSchema(),
+			"events": jsonSchema{
+				"type":  "array",
+				"items": jsonSchema{"type": "object", "properties": event},
+			},
+			"bins": jsonSchema{
+				"type":  "array",
+				"items": jsonSchema{"type": "object", "properties": bin},
+			},
+		},
+	}
+}
+
+// handleAPISchema returns the upload schema: GET /api/schema, with
+// ?payload=events for /events and ?device=ID for that detector's channel map
+func handleAPISchema(w http.ResponseWriter, r *http.Request) {
+	var schema jsonSchema
+	switch r.URL.Query().Get("payload") {
+	case "", "upload":
+		slots := len(frequencies())
+		if id := r.URL.Query().Get("device"); id != "" {
+			if ch := store.getDevice(r.Context(), id).Channels; ch != nil {
+				slots = len(ch)
+			}
+		}
+		schema = uploadSchema(slots)
+	case "events":
+		schema = eventsSchema()
+	default:
+		http.Error(w, "payload must be upload or events", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(schema)
+}
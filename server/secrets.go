@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Credentials stored in the database (UDP tokens, upload signing keys, and
+// webhook URLs and body templates, which often carry the receiver's key) are
+// sealed with AES-256-GCM when SECRETS_KEY or SECRETS_KEY_FILE is set, so a
+// copy of the database or a backup doesn't give them away. Sealed values look
+// like
+//
+//	enc:v1:<key id>:<base64 nonce + ciphertext>
+//
+// and are bound to their column and row, so one can't be pasted into another
+// row. Values from before a key was set are sealed on startup, and
+// values sealed with a key listed in SECRETS_OLD_KEYS are resealed with the
+// current one. With only SECRETS_OLD_KEYS set, they are stored in the clear
+// again.
+const sealedPrefix = "enc:v1:"
+
+// secrets holds the configured keys; nil stores credentials in the clear
+var secrets *secretKeyring
+
+// sealedColumns are the credential columns and the key of their rows
+var sealedColumns = []struct{ table, key, column string }{
+	{"devices", "device_id", "udp_token"},
+	{"devices", "device_id", "signing_key"},
+	{"webhooks", "id", "url"},
+	{"webhooks", "id", "template"},
+}
+
+var errSecretsKeyMissing = errors.New("sealed with a key that isn't configured")
+
+// secretKeyring seals with the current key and opens with any known key
+type secretKeyring struct {
+	current string // ID of the key new values are sealed with; "" stores them in the clear
+	keys    map[string]cipher.AEAD
+}
+
+// secretsFromEnv loads SECRETS_KEY (or the file named by SECRETS_KEY_FILE, as
+// mounted by a KMS or secrets manager) and SECRETS_OLD_KEYS. Keys are 32 bytes,
+// base64 or hex encoded, as made by `openssl rand -base64 32`.
+func secretsFromEnv() (*secretKeyring, error) {
+	current := strings.TrimSpace(os.Getenv("SECRETS_KEY"))
+	if file := os.Getenv("SECRETS_KEY_FILE"); file != "" {
+		if current != "" {
+			return nil, errors.New("set SECRETS_KEY or SECRETS_KEY_FILE, not both")
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("SECRETS_KEY_FILE: %w", err)
+		}
+		current = strings.TrimSpace(string(b))
+	}
+	old := os.Getenv("SECRETS_OLD_KEYS")
+	if current == "" && strings.TrimSpace(old) == "" {
+		return nil, nil
+	}
+
+	k := &secretKeyring{keys: make(map[string]cipher.AEAD)}
+	add := func(encoded string) (string, error) {
+		id, aead, err := parseSecretKey(encoded)
+		if err != nil {
+			return "", err
+		}
+		k.keys[id] = aead
+		return id, nil
+	}
+	if current != "" {
+		id, err := add(current)
+		if err != nil {
+			return nil, fmt.Errorf("SECRETS_KEY: %w", err)
+		}
+		k.current = id
+	}
+	for _, encoded := range strings.Split(old, ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		if _, err := add(encoded); err != nil {
+			return nil, fmt.Errorf("SECRETS_OLD_KEYS: %w", err)
+		}
+	}
+	return k, nil
+}
+
+// parseSecretKey decodes a 32-byte key, returning its ID (the first bytes of
+// its SHA-256, so the key itself is never written anywhere) and cipher
+func parseSecretKey(encoded string) (string, cipher.AEAD, error) {
+	var key []byte
+	for _, decode := range []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	} {
+		if b, err := decode(encoded); err == nil && len(b) == 32 {
+			key = b
+			break
+		}
+	}
+	if key == nil {
+		return "", nil, errors.New("key must be 32 bytes, base64 or hex encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4]), aead, nil
+}
+
+// secretContext binds a sealed value to where it is stored
+func secretContext(table, column, id string) []byte {
+	return []byte(table + "." + column + ":" + id)
+}
+
+// seal encrypts a credential for storage, or returns it unchanged without a current key
+func (k *secretKeyring) seal(value string, where []byte) (string, error) {
+	if k == nil || k.current == "" || value == "" {
+		return value, nil
+	}
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), where)
+	return sealedPrefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a stored credential; values stored in the clear pass through
+func (k *secretKeyring) open(stored string, where []byte) (string, error) {
+	id, data, ok := sealedParts(stored)
+	if !ok {
+		return stored, nil
+	}
+	var aead cipher.AEAD
+	if k != nil {
+		aead = k.keys[id]
+	}
+	if aead == nil {
+		return "", fmt.Errorf("%w (key id %s)", errSecretsKeyMissing, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed sealed value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], where)
+	if err != nil {
+		return "", errors.New("sealed value doesn't decrypt; it was altered or moved")
+	}
+	return string(plain), nil
+}
+
+// sealedParts splits a sealed value into its key ID and payload
+func sealedParts(stored string) (id, data string, ok bool) {
+	rest, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// isCurrent reports whether a stored value is already as seal would store it
+func (k *secretKeyring) isCurrent(stored string) bool {
+	id, _, sealed := sealedParts(stored)
+	if k == nil || k.current == "" {
+		return !sealed
+	}
+	return sealed && id == k.current
+}
+
+// sealStoredSecrets brings every stored credential up to the configured key:
+// sealing values from before encryption was enabled, resealing ones under an
+// old key, or unsealing them when only old keys remain. A credential sealed
+// with a key that isn't configured stops startup, since without it the device
+// could no longer authenticate (or, for signing keys, would be let in unsigned)
+// and the webhook could no longer be delivered.
+func (s *Store) sealStoredSecrets(ctx context.Context) error {
+	changed := 0
+	for _, c := range sealedColumns {
+		rows, err := s.query(ctx, `SELECT `+c.key+`, `+c.column+` FROM `+c.table+` WHERE `+c.column+` IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+		stale := make(map[string]string)
+		for rows.Next() {
+			var id, stored string
+			if err := rows.Scan(&id, &stored); err != nil {
+				rows.Close()
+				return err
+			}
+			if !secrets.isCurrent(stored) {
+				stale[id] = stored
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, stored := range stale {
+			where := secretContext(c.table, c.column, id)
+			plain, err := secrets.open(stored, where)
+			if err != nil {
+				return fmt.Errorf("%s.%s for %s: %w", c.table, c.column, id, err)
+			}
+			if s.readOnly {
+				continue
+			}
+			value, err := secrets.seal(plain, where)
+			if err != nil {
+				return err
+			}
+			if _, err := s.exec(ctx, `UPDATE `+c.table+` SET `+c.column+` = ? WHERE `+c.key+` = ?`, value, id); err != nil {
+				return err
+			}
+			changed++
+		}
+	}
+	if changed > 0 {
+		if secrets != nil && secrets.current != "" {
+			log.Printf("Sealed %d stored credentials with key %s", changed, secrets.current)
+		} else {
+			log.Printf("Unsealed %d stored credentials", changed)
+		}
+	}
+	return nil
+}
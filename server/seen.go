@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// FrequencySeen is when activity was first and most recently observed on one
+// channel. Times from rolled-up data are the start of the hour or day.
+type FrequencySeen struct {
+	MHz       string     `json:"mhz"`
+	Label     string     `json:"label"`
+	Category  string     `json:"category"`
+	FirstSeen *time.Time `json:"first_seen"`
+	LastSeen  *time.Time `json:"last_seen"`
+}
+
+// DeviceSeen lists FrequencySeen for every channel of one detector
+type DeviceSeen struct {
+	DeviceID    string          `json:"device_id"`
+	Frequencies []FrequencySeen `json:"frequencies"`
+}
+
+// seenSpan is the first and last time a channel's counter went up
+type seenSpan struct {
+	first, last time.Time
+}
+
+func (s *seenSpan) add(t time.Time) {
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+}
+
+// getFrequencySeen finds, per device and channel, the first and last upload or
+// rollup period in which detections went up, across everything still retained
+func (s *Store) getFrequencySeen(ctx context.Context, deviceIDs []string) map[string][]seenSpan {
+	seen := make(map[string][]seenSpan)
+	spans := func(deviceID string) []seenSpan {
+		sp, ok := seen[deviceID]
+		if !ok {
+			sp = make([]seenSpan, len(frequencies()))
+			seen[deviceID] = sp
+		}
+		return sp
+	}
+
+	// Loaded first, as in getActivityBuckets: the reader pool may be one connection
+	cursors := s.rollupCursors(ctx)
+
+	cond, args := deviceFilter(deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, period, new_freqs
+		FROM upload_rollups
+		WHERE `+cond, args...)
+	if err != nil {
+		log.Printf("Error loading rollups for first/last seen: %v", err)
+		return seen
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deviceID, period, freqs string
+		if err := rows.Scan(&deviceID, &period, &freqs); err != nil {
+			continue
+		}
+		f := planCounts(freqs)
+		sp := spans(deviceID)
+		for i := range f {
+			if f[i] > 0 {
+				sp[i].add(parseDBTime(period))
+			}
+		}
+	}
+	rows.Close()
+
+	// Raw uploads carry cumulative counters, so look for increases
+	rows, err = s.query(ctx, `
+		SELECT device_id, timestamp, uptime_seconds, freqs
+		FROM uploads
+		WHERE `+cond+`
+		ORDER BY device_id, timestamp, id
+	`, args...)
+	if err != nil {
+		log.Printf("Error loading uploads for first/last seen: %v", err)
+		return seen
+	}
+	defer rows.Close()
+	increases := increaseTracker{cursors: cursors}
+	for rows.Next() {
+		var deviceID, ts, freqs string
+		var uptime int
+		if err := rows.Scan(&deviceID, &ts, &uptime, &freqs); err != nil {
+			continue
+		}
+		t := parseDBTime(ts)
+		delta := fitCounts(increases.next(deviceID, ts, uptime, parseCounts(freqs)), len(frequencies()))
+		sp := spans(deviceID)
+		for i := range delta {
+			if delta[i] > 0 {
+				sp[i].add(t)
+			}
+		}
+	}
+	return seen
+}
+
+// frequencySeenList turns spans into the API's per-channel list
+func frequencySeenList(sp []seenSpan) []FrequencySeen {
+	out := make([]FrequencySeen, len(frequencies()))
+	for i, f := range frequencies() {
+		out[i] = FrequencySeen{MHz: f.MHz, Label: f.Label, Category: f.Category}
+		if !sp[i].first.IsZero() {
+			first, last := sp[i].first, sp[i].last
+			out[i].FirstSeen, out[i].LastSeen = &first, &last
+		}
+	}
+	return out
+}
+
+// handleAPISeen returns when each channel was first and last active, per device
+// and across all of them: GET /api/seen with optional device=ID or group=NAME
+func handleAPISeen(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var deviceIDs []string
+	if device := r.URL.Query().Get("device"); device != "" {
+		deviceIDs = []string{device}
+	} else if group := r.URL.Query().Get("group"); group != "" {
+		if deviceIDs = store.getGroupDevices(ctx, group); deviceIDs == nil {
+			http.Error(w, "Unknown group", http.StatusNotFound)
+			return
+		}
+	}
+
+	seen := store.getFrequencySeen(ctx, deviceIDs)
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	all := make([]seenSpan, len(frequencies()))
+	devices := make([]DeviceSeen, 0, len(ids))
+	for _, id := range ids {
+		for i, sp := range seen[id] {
+			if !sp.first.IsZero() {
+				all[i].add(sp.first)
+				all[i].add(sp.last)
+			}
+		}
+		devices = append(devices, DeviceSeen{DeviceID: id, Frequencies: frequencySeenList(seen[id])})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"all":     frequencySeenList(all),
+		"devices": devices,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// serialRetryInterval is how long to wait before reopening a port that failed or was unplugged
+const serialRetryInterval = 5 * time.Second
+
+// parseSerialLine picks stats out of the detector's serial output. The firmware
+// prints "Payload: {...}" with the same JSON it uploads; bare JSON lines work too.
+// Everything else on the console is ignored.
+func parseSerialLine(line string) (Stats, bool) {
+	line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "Payload:"))
+	if !strings.HasPrefix(line, "{") {
+		return Stats{}, false
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(line), &stats); err != nil {
+		log.Printf("Ignoring malformed serial stats line: %v", err)
+		return Stats{}, false
+	}
+	return stats, true
+}
+
+// readSerial ingests stats lines from an open port until it fails
+func readSerial(port *os.File, path string) error {
+	scanner := bufio.NewScanner(port)
+	for scanner.Scan() {
+		stats, ok := parseSerialLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		stats.Timestamp = timeNow()
+		stats.UploaderIP = "serial:" + path
+		if stats.DeviceID == "" {
+			stats.DeviceID = "unknown"
+		}
+		if err := validateStats(&stats); err != nil {
+			log.Printf("Ignoring serial stats line: %v", err)
+			continue
+		}
+		ctx, cancel := ingestContext()
+		if _, err := ingestStats(ctx, &stats); err != nil {
+			log.Printf("Lost serial stats from %s: %v", stats.DeviceID, err)
+		}
+		cancel()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return os.ErrClosed
+}
+
+// serveSerial reads a USB-attached detector forever, reopening the port
+// whenever it disappears (unplugged, detector reset)
+func serveSerial(path string, baud int) {
+	for {
+		port, err := openSerial(path, baud)
+		if err == nil {
+			log.Printf("Reading detector stats from %s at %d baud", path, baud)
+			err = readSerial(port, path)
+			port.Close()
+		}
+		log.Printf("Serial port %s: %v; retrying in %s", path, err, serialRetryInterval)
+		time.Sleep(serialRetryInterval)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// openSerial opens the port as it is; set it up beforehand, e.g.
+// stty -f /dev/cu.usbserial-0001 115200 raw
+func openSerial(path string, baud int) (*os.File, error) {
+	log.Printf("Not configuring %s on this OS; make sure it is raw at %d baud", path, baud)
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
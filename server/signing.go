@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Uploads to /upload and /events can be signed with a per-device shared key,
+// which authenticates detectors without the cost of TLS client certificates:
+//
+//	X-Signature-Time: unix seconds when the upload was signed
+//	X-Signature:      hex HMAC-SHA256(key, time + "\n" + path + "\n" + body)
+//
+// Once a device has a key its unsigned or badly signed uploads are refused.
+// The time must be within MAX_CLOCK_SKEW_SEC of the server's, and a signature
+// is only accepted once, so a captured upload can't be replayed.
+const (
+	signatureHeader     = "X-Signature"
+	signatureTimeHeader = "X-Signature-Time"
+	maxUploadBody       = 1 << 20
+)
+
+var (
+	errUnsigned       = errors.New("upload must be signed")
+	errBadSignature   = errors.New("signature doesn't match")
+	errStaleSignature = errors.New("signature time is too far from the server clock; sync from /api/time")
+	errReplayed       = errors.New("signature already used")
+)
+
+// requireSignedUploads refuses unsigned uploads even from devices without a key (REQUIRE_SIGNED_UPLOADS)
+func requireSignedUploads() bool {
+	return envBool("REQUIRE_SIGNED_UPLOADS")
+}
+
+// setDeviceSigningKey sets the key a detector signs uploads with; empty removes it
+func (s *Store) setDeviceSigningKey(ctx context.Context, deviceID, key string) error {
+	var value interface{}
+	if key != "" {
+		sealed, err := secrets.seal(key, secretContext("devices", "signing_key", deviceID))
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, signing_key) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET signing_key = excluded.signing_key
+	`, deviceID, value)
+	return err
+}
+
+// getDeviceSigningKey returns a detector's signing key, or "" if it has none.
+// A key that can't be decrypted is an error, so the device isn't let in unsigned.
+func (s *Store) getDeviceSigningKey(ctx context.Context, deviceID string) (string, error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var key sql.NullString
+	err := s.queryRow(ctx, `SELECT signing_key FROM devices WHERE device_id = ?`, deviceID).Scan(&key)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading device signing key: %v", err)
+	}
+	return secrets.open(key.String, secretContext("devices", "signing_key", deviceID))
+}
+
+// uploadSignature is the hex signature of a body posted to path at time ts
+func uploadSignature(key, ts, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	io.WriteString(mac, ts+"\n"+path+"\n")
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// usedSignatures remembers accepted signatures until their time falls out of
+// the skew window, after which the time check refuses them anyway
+var usedSignatures = struct {
+	sync.Mutex
+	seen   map[string]time.Time // Signature to when it can be forgotten
+	pruned time.Time
+}{seen: make(map[string]time.Time)}
+
+// useSignature records a signature, reporting false if it was already used
+func useSignature(sig string, expires time.Time) bool {
+	u := &usedSignatures
+	u.Lock()
+	defer u.Unlock()
+	now := time.Now()
+	if now.Sub(u.pruned) > time.Minute {
+		for s, exp := range u.seen {
+			if now.After(exp) {
+				delete(u.seen, s)
+			}
+		}
+		u.pruned = now
+	}
+	if _, ok := u.seen[sig]; ok {
+		return false
+	}
+	u.seen[sig] = expires
+	return true
+}
+
+// readUploadBody reads a device upload's body, which signature checks need whole
+func readUploadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadBody))
+}
+
+// refuseUnsignable refuses an upload over a transport that can't carry a
+// signature or client certificate (UDP, CoAP, LoRaWAN) from a device that has a
+// signing key, from any device with REQUIRE_SIGNED_UPLOADS set, or while client
+// certificates are required unless the transport is exempt (see mtls.go);
+// otherwise a key or certificate could be sidestepped by switching transports.
+// The serial bridge reads a port the operator chose, so it isn't checked.
+func refuseUnsignable(ctx context.Context, deviceID, transport string) error {
+	if clientCertsRequired && !uncertifiedTransports[transport] {
+		return errNoClientCert
+	}
+	if requireSignedUploads() {
+		return errUnsigned
+	}
+	key, err := store.getDeviceSigningKey(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error opening signing key for %s: %v", deviceID, err)
+		return errBadSignature
+	}
+	if key != "" {
+		return errUnsigned
+	}
+	return nil
+}
+
+// verifyUpload checks an upload's client certificate (see mtls.go) and its
+// signature against the device's key. Devices without a key may send unsigned
+// uploads unless REQUIRE_SIGNED_UPLOADS is set.
+func verifyUpload(ctx context.Context, r *http.Request, deviceID string, body []byte) error {
+	if err := checkClientCert(r, deviceID); err != nil {
+		return err
+	}
+	key, err := store.getDeviceSigningKey(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error opening signing key for %s: %v", deviceID, err)
+		return errBadSignature
+	}
+	sig, ts := r.Header.Get(signatureHeader), r.Header.Get(signatureTimeHeader)
+	if sig == "" && ts == "" {
+		if key != "" || requireSignedUploads() {
+			return errUnsigned
+		}
+		return nil
+	}
+	if key == "" {
+		return fmt.Errorf("%w: %s has no signing key", errBadSignature, deviceID)
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errStaleSignature
+	}
+	signed := time.Unix(secs, 0)
+	if skew := time.Since(signed); skew > maxClockSkew() || skew < -maxClockSkew() {
+		return errStaleSignature
+	}
+	want := uploadSignature(key, ts, r.URL.Path, body)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return errBadSignature
+	}
+	if !useSignature(sig, signed.Add(maxClockSkew())) {
+		return errReplayed
+	}
+	return nil
+}
+
+// rejectUnverifiedUpload refuses an upload with 401 and alerts, since a bad
+// signature or another device's certificate may be someone posing as the device
+func rejectUnverifiedUpload(ctx context.Context, w http.ResponseWriter, deviceID string, err error) {
+	log.Printf("Rejected upload from %s: %v", deviceID, err)
+	switch {
+	case errors.Is(err, errClientCertMismatch):
+		store.raiseAlert(ctx, deviceID, "bad_certificate", err.Error())
+	case errors.Is(err, errBadSignature), errors.Is(err, errReplayed):
+		store.raiseAlert(ctx, deviceID, "bad_signature", err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "error",
+		"message":     err.Error(),
+		"server_time": time.Now().Unix(),
+	})
+}
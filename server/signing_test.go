@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The signing tests run uploads through verifyUpload and the UDP, CoAP and
+// LoRaWAN ingest paths against a scratch database, checking that a device's
+// key can't be sidestepped: signed uploads are accepted once and only while
+// fresh, and transports that can't carry a signature refuse keyed devices.
+
+// scratchStore opens an empty store for one test, with its logging discarded
+func scratchStore(t *testing.T) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if _, err := openStore(Config{DBPath: filepath.Join(t.TempDir(), "test.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+}
+
+// signedRequest is an upload of body to path, signed with key at ts unless key is empty
+func signedRequest(key string, ts time.Time, path string, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if key != "" {
+		unix := strconv.FormatInt(ts.Unix(), 10)
+		r.Header.Set(signatureTimeHeader, unix)
+		r.Header.Set(signatureHeader, uploadSignature(key, unix, path, body))
+	}
+	return r
+}
+
+func TestVerifyUpload(t *testing.T) {
+	scratchStore(t)
+	ctx := context.Background()
+	if err := store.setDeviceSigningKey(ctx, "keyed", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	moved := signedRequest("s3cret", now, "/events", []byte(`{"n":8}`))
+	moved.URL.Path = "/upload"
+	replayed := signedRequest("s3cret", now, "/upload", []byte(`{"n":2}`))
+	if err := verifyUpload(ctx, replayed, "keyed", []byte(`{"n":2}`)); err != nil {
+		t.Fatalf("first use of the replayed signature: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		device  string
+		req     *http.Request
+		body    string
+		require bool // REQUIRE_SIGNED_UPLOADS
+		want    error
+	}{
+		{"valid signature", "keyed", signedRequest("s3cret", now, "/upload", []byte(`{"n":1}`)), `{"n":1}`, false, nil},
+		{"stale timestamp", "keyed", signedRequest("s3cret", now.Add(-10*time.Minute), "/upload", []byte(`{"n":3}`)), `{"n":3}`, false, errStaleSignature},
+		{"future timestamp", "keyed", signedRequest("s3cret", now.Add(10*time.Minute), "/upload", []byte(`{"n":4}`)), `{"n":4}`, false, errStaleSignature},
+		{"replay", "keyed", replayed, `{"n":2}`, false, errReplayed},
+		{"wrong key", "keyed", signedRequest("guess", now, "/upload", []byte(`{"n":5}`)), `{"n":5}`, false, errBadSignature},
+		{"body altered", "keyed", signedRequest("s3cret", now, "/upload", []byte(`{"n":6}`)), `{"n":7}`, false, errBadSignature},
+		{"signed for another path", "keyed", moved, `{"n":8}`, false, errBadSignature},
+		{"unsigned from keyed device", "keyed", signedRequest("", now, "/upload", nil), `{}`, false, errUnsigned},
+		{"unsigned from device without key", "open", signedRequest("", now, "/upload", nil), `{}`, false, nil},
+		{"unsigned when all must be signed", "open", signedRequest("", now, "/upload", nil), `{}`, true, errUnsigned},
+		{"signed by device without key", "open", signedRequest("s3cret", now, "/upload", []byte(`{}`)), `{}`, false, errBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.require {
+				t.Setenv("REQUIRE_SIGNED_UPLOADS", "true")
+			}
+			err := verifyUpload(ctx, tt.req, tt.device, []byte(tt.body))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("verifyUpload = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// udpDatagram frames a compact payload for deviceID with its token and CRC
+func udpDatagram(deviceID, token string, payload []byte) []byte {
+	b := append([]byte{byte(len(deviceID))}, deviceID...)
+	b = append(append(b, byte(len(token))), token...)
+	b = append(b, payload...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+// ingestOver sends one upload from deviceID over a transport that can't carry
+// a signature, returning the refusal or nil if it was stored
+func ingestOver(t *testing.T, transport, deviceID string) error {
+	t.Helper()
+	compact := fuzzCompactSeeds()[0]
+	switch transport {
+	case transportUDP:
+		_, err := ingestDatagram(udpDatagram(deviceID, "tok", compact), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000})
+		return err
+	case transportCoAP:
+		st := fuzzStats()[0]
+		st.DeviceID = deviceID
+		payload, _ := cbor.Marshal(st)
+		req := coapMessage{typ: coapCON, code: coapPOST, msgID: 1,
+			options: []coapOption{
+				{num: coapOptionURIPath, value: []byte("upload")},
+				{num: coapOptionURIQuery, value: []byte("token=tok")},
+			},
+			payload: payload}
+		code, body := coapUpload(req, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5683})
+		if code != coapChanged {
+			return errors.New(body["message"].(string))
+		}
+		return nil
+	case transportLoRaWAN:
+		port := lorawanStatsPort
+		var up lorawanUplink
+		up.DeviceInfo.DeviceName, up.FPort, up.Data = deviceID, &port, compact
+		b, _ := json.Marshal(up)
+		rec := httptest.NewRecorder()
+		handleLoRaWAN(rec, httptest.NewRequest(http.MethodPost, "/api/lorawan", bytes.NewReader(b)))
+		if rec.Code != http.StatusOK {
+			return errors.New(string(bytes.TrimSpace(rec.Body.Bytes())))
+		}
+		return nil
+	}
+	t.Fatalf("unknown transport %s", transport)
+	return nil
+}
+
+func TestUnsignableTransports(t *testing.T) {
+	scratchStore(t)
+	ctx := context.Background()
+	for _, id := range []string{"keyed", "open"} {
+		if err := store.setDeviceToken(ctx, id, "tok"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.setDeviceSigningKey(ctx, "keyed", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	required, uncertified := clientCertsRequired, uncertifiedTransports
+	t.Cleanup(func() { clientCertsRequired, uncertifiedTransports = required, uncertified })
+
+	tests := []struct {
+		name        string
+		device      string
+		require     bool            // REQUIRE_SIGNED_UPLOADS
+		certs       bool            // Client certificates required
+		uncertified map[string]bool // -tls-uncertified
+		want        error           // Why the upload is refused, or nil if it is stored
+	}{
+		{"keyed device", "keyed", false, false, nil, errUnsigned},
+		{"device without key", "open", false, false, nil, nil},
+		{"all must be signed", "open", true, false, nil, errUnsigned},
+		{"certificates required", "open", false, true, nil, errNoClientCert},
+		{"transport exempt from certificates", "open", false, true,
+			map[string]bool{transportUDP: true, transportCoAP: true, transportLoRaWAN: true}, nil},
+		{"exempt transport, keyed device", "keyed", false, true,
+			map[string]bool{transportUDP: true, transportCoAP: true, transportLoRaWAN: true}, errUnsigned},
+	}
+	for _, tt := range tests {
+		for _, transport := range []string{transportUDP, transportCoAP, transportLoRaWAN} {
+			t.Run(tt.name+"/"+transport, func(t *testing.T) {
+				if tt.require {
+					t.Setenv("REQUIRE_SIGNED_UPLOADS", "true")
+				}
+				clientCertsRequired, uncertifiedTransports = tt.certs, tt.uncertified
+				err := ingestOver(t, transport, tt.device)
+				if (err == nil) != (tt.want == nil) || err != nil && !strings.Contains(err.Error(), tt.want.Error()) {
+					t.Errorf("upload over %s: err = %v, want %v", transport, err, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Activity patterns for simulated detectors
+const (
+	simSteady  = "steady"  // Constant background rate
+	simDiurnal = "diurnal" // Busy by day, quiet at night, like a city neighbourhood
+	simBursty  = "bursty"  // Quiet with occasional minutes-long bursts, like a Meshtastic mesh
+	simMixed   = "mixed"   // Each device picks one of the above
+)
+
+// simFreqWeights is the relative traffic per scan frequency: LoRaWAN channels
+// and Sidewalk dominate, Meshtastic is occasional (see frequencies)
+var simFreqWeights = []float64{3, 1, 1, 1.5, 2.5, 3, 2, 1.5}
+
+// simDevice is one fake detector with cumulative, per-boot counters like the firmware
+type simDevice struct {
+	id       string
+	pattern  string
+	rng      *rand.Rand
+	baseRate float64 // Detections per minute at pattern multiplier 1
+	burstEnd time.Time
+
+	uptime  int
+	total   int
+	freq    []int
+	peak    int
+	battery float64
+}
+
+func newSimDevice(id, pattern string, seed uint64) *simDevice {
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	if pattern == simMixed {
+		pattern = []string{simSteady, simDiurnal, simBursty}[rng.IntN(3)]
+	}
+	return &simDevice{
+		id:       id,
+		pattern:  pattern,
+		rng:      rng,
+		baseRate: 1 + rng.Float64()*6,
+		uptime:   rng.IntN(3600),
+		freq:     make([]int, len(simFreqWeights)),
+		battery:  3.7 + rng.Float64()*0.4,
+	}
+}
+
+// rate returns the expected detections per minute at t
+func (d *simDevice) rate(t time.Time) float64 {
+	switch d.pattern {
+	case simDiurnal:
+		hour := float64(t.Hour()) + float64(t.Minute())/60
+		return d.baseRate * (1 + 0.9*math.Sin(2*math.Pi*(hour-9)/24))
+	case simBursty:
+		if t.Before(d.burstEnd) {
+			return d.baseRate * 8
+		}
+		if d.rng.Float64() < 0.02 {
+			d.burstEnd = t.Add(time.Duration(2+d.rng.IntN(10)) * time.Minute)
+		}
+		return d.baseRate * 0.2
+	}
+	return d.baseRate
+}
+
+// poisson draws a Poisson count, switching to a normal approximation for large means
+func poisson(rng *rand.Rand, mean float64) int {
+	if mean <= 0 {
+		return 0
+	}
+	if mean > 50 {
+		return max(0, int(math.Round(mean+rng.NormFloat64()*math.Sqrt(mean))))
+	}
+	l, k, p := math.Exp(-mean), 0, 1.0
+	for {
+		p *= rng.Float64()
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
+
+// step advances the device by interval and returns the upload it would send at t
+func (d *simDevice) step(t time.Time, interval time.Duration) Stats {
+	// An occasional reboot resets the counters, as real detectors do
+	if d.rng.Float64() < 0.002 {
+		d.uptime, d.total, d.peak = 0, 0, 0
+		d.freq = make([]int, len(simFreqWeights))
+	}
+
+	minutes := interval.Minutes()
+	rate := d.rate(t)
+	n := poisson(d.rng, rate*minutes)
+	var weightSum float64
+	for _, w := range simFreqWeights {
+		weightSum += w
+	}
+	for i := 0; i < n; i++ {
+		r := d.rng.Float64() * weightSum
+		for f, w := range simFreqWeights {
+			if r -= w; r < 0 {
+				d.freq[f]++
+				break
+			}
+		}
+	}
+	d.total += n
+	d.uptime += int(interval.Seconds())
+	perMin := int(math.Round(float64(n) / math.Max(minutes, 1.0/60)))
+	activity := min(100, int(math.Round(rate*1.5)))
+	d.peak = max(d.peak, activity)
+
+	// Solar-charged battery: up by day, down at night
+	hour := float64(t.Hour())
+	d.battery += (math.Sin(2*math.Pi*(hour-6)/24)*0.02 - 0.004) * minutes / 60
+	d.battery = math.Max(3.2, math.Min(4.2, d.battery))
+	battery := math.Round(d.battery*1000) / 1000
+	rssi := -55 - d.rng.IntN(30)
+	temp := math.Round((15+10*math.Sin(2*math.Pi*(hour-9)/24)+d.rng.NormFloat64())*10) / 10
+
+	return Stats{
+		DeviceID:         d.id,
+		Uptime:           d.uptime,
+		TotalDetections:  d.total,
+		DetectionsPerMin: perMin,
+		CurrentActivity:  activity,
+		PeakActivity:     d.peak,
+		FreqDetections:   append([]int(nil), d.freq...),
+		Timestamp:        t,
+		DeviceHealth:     DeviceHealth{BatteryV: &battery, WiFiRSSI: &rssi, TemperatureC: &temp},
+	}
+}
+
+func runSimulate(args []string) error {
+	fs := newFlagSet("simulate", "")
+	devices := fs.Int("devices", 5, "number of fake detectors")
+	prefix := fs.String("prefix", "sim-", "device ID prefix")
+	pattern := fs.String("pattern", simMixed, "activity pattern: steady, diurnal, bursty or mixed")
+	interval := fs.Duration("interval", time.Minute, "time between uploads per device")
+	target := fs.String("url", "http://localhost:8080/upload", "upload URL for live mode")
+	backfill := fs.Duration("backfill", 0, "instead of uploading live, write this much history (e.g. 168h) straight into -db")
+	rounds := fs.Int("rounds", 0, "live mode: stop after this many rounds (0 = run until interrupted)")
+	seed := fs.Uint64("seed", 1, "random seed, for repeatable data")
+	var cfg Config
+	addDBFlags(fs, &cfg)
+	fs.Parse(args)
+
+	switch *pattern {
+	case simSteady, simDiurnal, simBursty, simMixed:
+	default:
+		return fmt.Errorf("unknown pattern %q", *pattern)
+	}
+	if *devices < 1 || *interval <= 0 {
+		return errors.New("-devices and -interval must be positive")
+	}
+	sims := make([]*simDevice, *devices)
+	for i := range sims {
+		sims[i] = newSimDevice(fmt.Sprintf("%s%d", *prefix, i+1), *pattern, *seed+uint64(i))
+	}
+
+	if *backfill > 0 {
+		if _, err := openStore(cfg); err != nil {
+			return err
+		}
+		n, err := simulateBackfill(sims, *backfill, *interval)
+		if err == nil {
+			fmt.Printf("Wrote %d uploads for %d devices over %s\n", n, len(sims), *backfill)
+		}
+		return err
+	}
+	return simulateLive(sims, *target, *interval, *rounds)
+}
+
+// simulateBackfill writes history directly, bypassing the upload writer, alerts
+// and calibration, and returns how many uploads it wrote
+func simulateBackfill(sims []*simDevice, span, interval time.Duration) (int, error) {
+	ctx := context.Background()
+	// One transaction for the lot; per-row commits take minutes
+	stmts, err := store.prepareWrites(ctx, insertUploadSQL, insertHealthSQL)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := store.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	insertUpload, insertHealth := tx.StmtContext(ctx, stmts[0]), tx.StmtContext(ctx, stmts[1])
+	n := 0
+	for t := timeNow().Add(-span); !t.After(timeNow()); t = t.Add(interval) {
+		for _, d := range sims {
+			st := d.step(t, interval)
+			if _, err := insertUpload.ExecContext(ctx, uploadArgs(st)...); err != nil {
+				return 0, err
+			}
+			if _, err := insertHealth.ExecContext(ctx, healthArgs(st.DeviceID, st.Timestamp, st.DeviceHealth)...); err != nil {
+				return 0, err
+			}
+			n++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// simulateLive posts uploads to a running server each interval and reports latency
+func simulateLive(sims []*simDevice, target string, interval time.Duration, rounds int) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	fmt.Printf("Simulating %d devices against %s every %s\n", len(sims), target, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for round := 1; rounds == 0 || round <= rounds; round++ {
+		now := time.Now()
+		latencies := make([]time.Duration, len(sims))
+		var errs int
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, 16)
+		for i, d := range sims {
+			st := d.step(now, interval)
+			body, _ := json.Marshal(st)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				start := time.Now()
+				resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+				latencies[i] = time.Since(start)
+				if err == nil {
+					resp.Body.Close()
+				}
+				if err != nil || resp.StatusCode != http.StatusOK {
+					mu.Lock()
+					errs++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+		fmt.Printf("round %d: %d uploads, %d errors, p50 %s, p99 %s\n", round, len(sims), errs,
+			latencies[len(latencies)/2].Round(time.Microsecond), latencies[len(latencies)*99/100].Round(time.Microsecond))
+		if errs == len(sims) {
+			fmt.Fprintln(os.Stderr, "every upload failed; is the server running?")
+		}
+		if rounds == 0 || round < rounds {
+			<-ticker.C
+		}
+	}
+	return nil
+}
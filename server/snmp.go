@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// A minimal read-only SNMP agent (v1 and v2c: Get, GetNext, GetBulk) so that
+// network management systems can poll detectors like any other sensor. It
+// serves the system group and a detector table under the documentation
+// enterprise number:
+//
+//	1.3.6.1.4.1.32473.1.1.0          loraDeviceCount       Gauge32
+//	1.3.6.1.4.1.32473.1.2.1.C.I      loraDeviceTable, column C, row I (1-based, by device ID):
+//	  .1 loraDeviceIndex             INTEGER
+//	  .2 loraDeviceID                OCTET STRING
+//	  .3 loraCurrentActivity         Gauge32, percent
+//	  .4 loraPeakActivity            Gauge32, percent
+//	  .5 loraDetectionsPerMin        Gauge32
+//	  .6 loraTotalDetections         Gauge32, since the detector booted
+//	  .7 loraDeviceUptime            TimeTicks
+//	  .8 loraSecondsSinceUpload      Gauge32
+//	  .9 loraDeviceOnline            TruthValue (1 true, 2 false), by the device's cadence
+//
+// Rows are numbered afresh on each request, so a new detector can shift the
+// ones after it; NMS tooling should key on loraDeviceID.
+const (
+	snmpMaxDatagram = 65507
+	snmpMaxVarBinds = 100      // Most varbinds in one response, however many GetBulk asks for
+	snmpCommunity   = "public" // Unless SNMP_COMMUNITY is set
+	snmpTruthTrue   = 1
+	snmpTruthFalse  = 2
+	snmpColumnCount = 9
+	snmpVersion1    = 0
+	snmpVersion2c   = 1
+	snmpNoSuchName  = 2  // v1 error-status
+	snmpNotWritable = 17 // v2c error-status
+)
+
+// BER and SNMP tags
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	snmpGauge32    = 0x42
+	snmpTimeTicks  = 0x43
+	snmpNoSuchObj  = 0x80
+	snmpEndOfView  = 0x82
+	snmpGet        = 0xA0
+	snmpGetNext    = 0xA1
+	snmpResponse   = 0xA2
+	snmpSet        = 0xA3
+	snmpGetBulk    = 0xA5
+)
+
+// snmpBase is the detector subtree
+var snmpBase = []uint32{1, 3, 6, 1, 4, 1, 32473, 1}
+
+// snmpSystem is MIB-2's system group
+var snmpSystem = []uint32{1, 3, 6, 1, 2, 1, 1}
+
+// snmpVar is one object instance and its BER-encoded value
+type snmpVar struct {
+	oid   []uint32
+	value []byte
+}
+
+// snmpAgent answers requests that carry its community string
+type snmpAgent struct {
+	community string
+	hostname  string
+	started   time.Time
+}
+
+// serveSNMP answers SNMP requests until the connection fails. Requests with the
+// wrong community, and anything unparseable, are dropped without a reply.
+func serveSNMP(conn net.PacketConn) error {
+	a := &snmpAgent{community: os.Getenv("SNMP_COMMUNITY"), started: time.Now()}
+	if a.community == "" {
+		a.community = snmpCommunity
+	}
+	a.hostname, _ = os.Hostname()
+	buf := make([]byte, snmpMaxDatagram)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := a.handle(buf[:n])
+		if err != nil {
+			log.Printf("Dropped SNMP request from %s: %v", from, err)
+			continue
+		}
+		if _, err := conn.WriteTo(reply, from); err != nil {
+			log.Printf("Error replying to SNMP request from %s: %v", from, err)
+		}
+	}
+}
+
+// handle parses a request and encodes its response
+func (a *snmpAgent) handle(b []byte) ([]byte, error) {
+	tag, msg, _, err := berRead(b)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("not an SNMP message")
+	}
+	tag, v, msg, err := berRead(msg)
+	if err != nil || tag != berInteger {
+		return nil, errors.New("missing version")
+	}
+	version := berInt(v)
+	if version != snmpVersion1 && version != snmpVersion2c {
+		return nil, errors.New("only SNMP v1 and v2c are supported")
+	}
+	tag, community, msg, err := berRead(msg)
+	if err != nil || tag != berOctetString {
+		return nil, errors.New("missing community")
+	}
+	if string(community) != a.community {
+		return nil, errors.New("wrong community")
+	}
+	pduType, pdu, _, err := berRead(msg)
+	if err != nil {
+		return nil, err
+	}
+	var fields [3]int64 // request-id, error-status/non-repeaters, error-index/max-repetitions
+	for i := range fields {
+		if tag, v, pdu, err = berRead(pdu); err != nil || tag != berInteger {
+			return nil, errors.New("malformed PDU")
+		}
+		fields[i] = berInt(v)
+	}
+	if tag, pdu, _, err = berRead(pdu); err != nil || tag != berSequence {
+		return nil, errors.New("malformed varbind list")
+	}
+	var oids [][]uint32
+	for len(pdu) > 0 {
+		var vb, o []byte
+		if tag, vb, pdu, err = berRead(pdu); err != nil || tag != berSequence {
+			return nil, errors.New("malformed varbind")
+		}
+		if tag, o, _, err = berRead(vb); err != nil || tag != berOID {
+			return nil, errors.New("malformed OID")
+		}
+		oids = append(oids, berParseOID(o))
+	}
+
+	mib := a.snapshot()
+	var out []snmpVar
+	errStatus, errIndex := 0, 0
+	switch pduType {
+	case snmpGet:
+		for i, oid := range oids {
+			if v, ok := mib.get(oid); ok {
+				out = append(out, v)
+			} else if version == snmpVersion1 {
+				errStatus, errIndex = snmpNoSuchName, i+1
+				break
+			} else {
+				out = append(out, snmpVar{oid, []byte{snmpNoSuchObj, 0}})
+			}
+		}
+	case snmpGetNext:
+		for i, oid := range oids {
+			if v, ok := mib.next(oid); ok {
+				out = append(out, v)
+			} else if version == snmpVersion1 {
+				errStatus, errIndex = snmpNoSuchName, i+1
+				break
+			} else {
+				out = append(out, snmpVar{oid, []byte{snmpEndOfView, 0}})
+			}
+		}
+	case snmpGetBulk:
+		if version == snmpVersion1 {
+			return nil, errors.New("GetBulk in an SNMPv1 message")
+		}
+		out = mib.bulk(oids, int(fields[1]), int(fields[2]))
+	case snmpSet:
+		errStatus, errIndex = snmpNotWritable, 1
+		if version == snmpVersion1 {
+			errStatus = snmpNoSuchName
+		}
+	default:
+		return nil, errors.New("unsupported PDU type")
+	}
+	if errStatus != 0 {
+		// Errors echo the request's varbinds
+		out = out[:0]
+		for _, oid := range oids {
+			out = append(out, snmpVar{oid, []byte{berNull, 0}})
+		}
+	}
+
+	var vbs []byte
+	for _, v := range out {
+		vbs = append(vbs, berTLV(berSequence, append(berTLV(berOID, berEncodeOID(v.oid)), v.value...))...)
+	}
+	body := berTLV(berInteger, berEncodeInt(fields[0]))
+	body = append(body, berTLV(berInteger, berEncodeInt(int64(errStatus)))...)
+	body = append(body, berTLV(berInteger, berEncodeInt(int64(errIndex)))...)
+	body = append(body, berTLV(berSequence, vbs)...)
+	reply := berTLV(berInteger, berEncodeInt(version))
+	reply = append(reply, berTLV(berOctetString, community)...)
+	reply = append(reply, berTLV(snmpResponse, body)...)
+	return berTLV(berSequence, reply), nil
+}
+
+// snmpMIB is every object instance, sorted by OID
+type snmpMIB []snmpVar
+
+// snapshot builds the MIB from the in-memory cache
+func (a *snmpAgent) snapshot() snmpMIB {
+	latest := store.latestStats()
+	ids := make([]string, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	oid := func(base []uint32, sub ...uint32) []uint32 { return append(slices.Clone(base), sub...) }
+	gauge := func(n int) []byte { return berTLV(snmpGauge32, berEncodeInt(int64(max(n, 0)))) }
+	mib := snmpMIB{
+		{oid(snmpSystem, 1, 0), berTLV(berOctetString, []byte("LoRa detector server"))},
+		{oid(snmpSystem, 2, 0), berTLV(berOID, berEncodeOID(snmpBase))},
+		{oid(snmpSystem, 3, 0), berTLV(snmpTimeTicks, berEncodeInt(int64(time.Since(a.started)/(10*time.Millisecond))&0xFFFFFFFF))},
+		{oid(snmpSystem, 5, 0), berTLV(berOctetString, []byte(a.hostname))},
+		{oid(snmpBase, 1, 0), gauge(len(ids))},
+	}
+	c := store.getCadences(context.Background())
+	for col := uint32(1); col <= snmpColumnCount; col++ {
+		for i, id := range ids {
+			st := latest[id]
+			var v []byte
+			switch col {
+			case 1:
+				v = berTLV(berInteger, berEncodeInt(int64(i+1)))
+			case 2:
+				v = berTLV(berOctetString, []byte(id))
+			case 3:
+				v = gauge(st.CurrentActivity)
+			case 4:
+				v = gauge(st.PeakActivity)
+			case 5:
+				v = gauge(st.DetectionsPerMin)
+			case 6:
+				v = gauge(st.TotalDetections)
+			case 7:
+				v = berTLV(snmpTimeTicks, berEncodeInt(int64(max(st.Uptime, 0))*100&0xFFFFFFFF))
+			case 8:
+				v = gauge(int(timeNow().Sub(st.Timestamp).Seconds()))
+			case 9:
+				online := int64(snmpTruthTrue)
+				if c.of(id).Offline(st.Timestamp, timeNow()) {
+					online = snmpTruthFalse
+				}
+				v = berTLV(berInteger, berEncodeInt(online))
+			}
+			mib = append(mib, snmpVar{oid(snmpBase, 2, 1, col, uint32(i+1)), v})
+		}
+	}
+	slices.SortFunc(mib, func(a, b snmpVar) int { return slices.Compare(a.oid, b.oid) })
+	return mib
+}
+
+// get returns the instance with exactly this OID
+func (m snmpMIB) get(oid []uint32) (snmpVar, bool) {
+	i, found := slices.BinarySearchFunc(m, oid, func(v snmpVar, oid []uint32) int { return slices.Compare(v.oid, oid) })
+	if !found {
+		return snmpVar{}, false
+	}
+	return m[i], true
+}
+
+// next returns the first instance after oid
+func (m snmpMIB) next(oid []uint32) (snmpVar, bool) {
+	i, found := slices.BinarySearchFunc(m, oid, func(v snmpVar, oid []uint32) int { return slices.Compare(v.oid, oid) })
+	if found {
+		i++
+	}
+	if i >= len(m) {
+		return snmpVar{}, false
+	}
+	return m[i], true
+}
+
+// bulk answers GetBulk: one GetNext for each of the first nonRepeaters OIDs,
+// then up to maxRepetitions walks of the rest, capped at snmpMaxVarBinds
+func (m snmpMIB) bulk(oids [][]uint32, nonRepeaters, maxRepetitions int) []snmpVar {
+	nonRepeaters = min(max(nonRepeaters, 0), len(oids))
+	var out []snmpVar
+	step := func(oid []uint32) ([]uint32, bool) {
+		if v, ok := m.next(oid); ok {
+			out = append(out, v)
+			return v.oid, true
+		}
+		out = append(out, snmpVar{oid, []byte{snmpEndOfView, 0}})
+		return oid, false
+	}
+	for _, oid := range oids[:nonRepeaters] {
+		step(oid)
+	}
+	cursors := slices.Clone(oids[nonRepeaters:])
+	for r := 0; r < maxRepetitions && len(cursors) > 0 && len(out)+len(cursors) <= snmpMaxVarBinds; r++ {
+		more := false
+		for i, oid := range cursors {
+			var ok bool
+			cursors[i], ok = step(oid)
+			more = more || ok
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// berRead splits the first TLV off b
+func berRead(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER")
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		octets := n & 0x7F
+		if octets == 0 || octets > 3 || len(b) < octets {
+			return 0, nil, nil, errors.New("bad BER length")
+		}
+		n = 0
+		for _, c := range b[:octets] {
+			n = n<<8 | int(c)
+		}
+		b = b[octets:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errors.New("truncated BER")
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// berTLV encodes a tag, length and value
+func berTLV(tag byte, value []byte) []byte {
+	n := len(value)
+	var b []byte
+	switch {
+	case n < 0x80:
+		b = []byte{tag, byte(n)}
+	case n < 0x100:
+		b = []byte{tag, 0x81, byte(n)}
+	case n < 0x10000:
+		b = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		b = []byte{tag, 0x83, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(b, value...)
+}
+
+// berInt decodes a two's complement integer
+func berInt(b []byte) int64 {
+	var n int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(c)
+	}
+	return n
+}
+
+// berEncodeInt encodes the shortest two's complement form of n
+func berEncodeInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
+
+// berParseOID decodes an OBJECT IDENTIFIER
+func berParseOID(b []byte) []uint32 {
+	if len(b) == 0 {
+		return nil
+	}
+	var oid []uint32
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7F)
+		if c&0x80 != 0 {
+			continue
+		}
+		if oid == nil {
+			// The first subidentifier packs the first two arcs
+			first := min(n/40, 2)
+			oid = append(oid, first, n-first*40)
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+	return oid
+}
+
+// berEncodeOID encodes an OBJECT IDENTIFIER of at least two arcs
+func berEncodeOID(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	var b bytes.Buffer
+	arcs := append([]uint32{oid[0]*40 + oid[1]}, oid[2:]...)
+	for _, arc := range arcs {
+		var group []byte
+		for group = []byte{byte(arc & 0x7F)}; arc > 0x7F; {
+			arc >>= 7
+			group = append([]byte{byte(arc&0x7F) | 0x80}, group...)
+		}
+		b.Write(group)
+	}
+	return b.Bytes()
+}
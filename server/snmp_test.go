@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+)
+
+// The SNMP tests round-trip values through the BER codec at the boundaries
+// where its encodings change length, check it refuses truncated input, and
+// send a whole GET through the agent, decoding the response the way an NMS
+// would.
+
+func TestBERLength(t *testing.T) {
+	for _, n := range []int{0, 1, 0x7F, 0x80, 0xFF, 0x100, 0xFFFF, 0x10000} {
+		value := bytes.Repeat([]byte{0xAB}, n)
+		b := append(berTLV(berOctetString, value), 0x99)
+		tag, got, rest, err := berRead(b)
+		if err != nil || tag != berOctetString || !bytes.Equal(got, value) || !bytes.Equal(rest, []byte{0x99}) {
+			t.Errorf("length %d: tag %#x, %d bytes, rest %x, err %v", n, tag, len(got), rest, err)
+		}
+	}
+}
+
+func TestBERReadMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"tag only", []byte{berInteger}},
+		{"value short", []byte{berOctetString, 3, 'a', 'b'}},
+		{"long form without octets", []byte{berOctetString, 0x80}},
+		{"long form too long", []byte{berOctetString, 0x84, 0, 0, 0, 1, 'a'}},
+		{"long form length short", []byte{berOctetString, 0x82, 0x01}},
+		{"long form value short", []byte{berOctetString, 0x81, 0x05, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := berRead(tt.b); err == nil {
+				t.Errorf("berRead(%x) accepted malformed input", tt.b)
+			}
+		})
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x00, 0x80}},
+		{256, []byte{0x01, 0x00}},
+		{-1, []byte{0xFF}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xFF, 0x7F}},
+		{0xFFFFFFFF, []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{math.MaxInt64, []byte{0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{math.MinInt64, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got := berEncodeInt(tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("berEncodeInt(%d) = %x, want %x", tt.n, got, tt.want)
+		}
+		if back := berInt(got); back != tt.n {
+			t.Errorf("berInt(%x) = %d, want %d", got, back, tt.n)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid  []uint32
+		want []byte
+	}{
+		{[]uint32{1, 3, 6, 1, 2, 1, 1, 1, 0}, []byte{0x2B, 6, 1, 2, 1, 1, 1, 0}},
+		{snmpBase, []byte{0x2B, 6, 1, 4, 1, 0x81, 0xFD, 0x59, 1}},
+		{[]uint32{2, 100, 3}, []byte{0x81, 0x34, 3}},
+		{[]uint32{1, 3, 0xFFFFFFFF}, []byte{0x2B, 0x8F, 0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, tt := range tests {
+		got := berEncodeOID(tt.oid)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("berEncodeOID(%v) = %x, want %x", tt.oid, got, tt.want)
+		}
+		if back := berParseOID(got); !slices.Equal(back, tt.oid) {
+			t.Errorf("berParseOID(%x) = %v, want %v", got, back, tt.oid)
+		}
+	}
+}
+
+// snmpRequest encodes a request PDU for oids
+func snmpRequest(version int64, community string, pduType byte, oids ...[]uint32) []byte {
+	var vbs []byte
+	for _, oid := range oids {
+		vbs = append(vbs, berTLV(berSequence, append(berTLV(berOID, berEncodeOID(oid)), berNull, 0))...)
+	}
+	pdu := berTLV(berInteger, berEncodeInt(42))
+	pdu = append(pdu, berTLV(berInteger, []byte{0})...)
+	pdu = append(pdu, berTLV(berInteger, []byte{0})...)
+	pdu = append(pdu, berTLV(berSequence, vbs)...)
+	msg := berTLV(berInteger, berEncodeInt(version))
+	msg = append(msg, berTLV(berOctetString, []byte(community))...)
+	msg = append(msg, berTLV(pduType, pdu)...)
+	return berTLV(berSequence, msg)
+}
+
+func TestSNMPGet(t *testing.T) {
+	scratchStore(t)
+	a := &snmpAgent{community: "public", hostname: "test"}
+	sysName := append(slices.Clone(snmpSystem), 5, 0)
+	if _, err := a.handle(snmpRequest(snmpVersion2c, "wrong", snmpGet, sysName)); err == nil {
+		t.Error("answered a request with the wrong community")
+	}
+
+	reply, err := a.handle(snmpRequest(snmpVersion2c, "public", snmpGet, sysName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Walk down to the one varbind: message, response PDU, varbind list, varbind
+	_, msg, _, err := berRead(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make([][]byte, 3)
+	for i := range fields {
+		if _, fields[i], msg, err = berRead(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if berInt(fields[0]) != snmpVersion2c || string(fields[1]) != "public" {
+		t.Fatalf("reply version %d, community %q", berInt(fields[0]), fields[1])
+	}
+	pdu := fields[2]
+	var id, status []byte
+	if _, id, pdu, err = berRead(pdu); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, pdu, err = berRead(pdu); err != nil {
+		t.Fatal(err)
+	}
+	if berInt(id) != 42 || berInt(status) != 0 {
+		t.Fatalf("reply request-id %d, error-status %d", berInt(id), berInt(status))
+	}
+	if _, _, pdu, err = berRead(pdu); err != nil { // error-index
+		t.Fatal(err)
+	}
+	_, vbs, _, err := berRead(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, vb, _, err := berRead(vbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, oid, vb, err := berRead(vb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, value, _, err := berRead(vb)
+	if err != nil || !slices.Equal(berParseOID(oid), sysName) || tag != berOctetString || string(value) != "test" {
+		t.Errorf("varbind %v = tag %#x %q (err %v), want sysName \"test\"", berParseOID(oid), tag, value, err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With SOLAR_INDICES=true the server pulls the daily solar and geomagnetic
+// indices (10.7 cm flux, sunspot number, Ap and the eight 3-hourly Kp values)
+// from GFZ Potsdam, so long-term charts can mark geomagnetic storms next to
+// changes in detection rates. The nowcast file covers the last month and is
+// revised as definitive values come in, so every fetch replaces what it covers.
+const (
+	solarFetchInterval = 6 * time.Hour
+	solarStormKp       = 5 // NOAA G1
+	solarStormColor    = "#b388ff"
+	solarMaxDays       = 365
+)
+
+// solarIndicesEnabled reports whether daily indices are fetched (SOLAR_INDICES)
+func solarIndicesEnabled() bool {
+	return envBool("SOLAR_INDICES")
+}
+
+// solarURL is the GFZ Kp/Ap/SN/F10.7 file to fetch (SOLAR_URL), for a mirror
+func solarURL() string {
+	if v := os.Getenv("SOLAR_URL"); v != "" {
+		return v
+	}
+	return "https://kp.gfz-potsdam.de/app/files/Kp_ap_Ap_SN_F107_nowcast.txt"
+}
+
+// SolarDay is one UTC day's solar and geomagnetic indices; nil where GFZ
+// hasn't published a value yet
+type SolarDay struct {
+	Day      string   `json:"day"` // YYYY-MM-DD, UTC
+	Flux     *float64 `json:"f107"`
+	Sunspots *int     `json:"sunspot_number"`
+	Ap       *int     `json:"ap"`
+	KpMax    *float64 `json:"kp_max"`
+}
+
+// parseSolarIndices reads GFZ's fixed-column file: date, day counts, Bartels
+// rotation, eight Kp, eight ap, Ap, SN, observed and adjusted F10.7 and a
+// definitive flag. Missing values are negative.
+func parseSolarIndices(r io.Reader) ([]SolarDay, error) {
+	var days []SolarDay
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 26 {
+			return nil, fmt.Errorf("short line %q", line)
+		}
+		year, err1 := strconv.Atoi(f[0])
+		month, err2 := strconv.Atoi(f[1])
+		day, err3 := strconv.Atoi(f[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("bad date in %q", line)
+		}
+		d := SolarDay{Day: time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")}
+		for _, s := range f[7:15] {
+			if kp, err := strconv.ParseFloat(s, 64); err == nil && kp >= 0 && (d.KpMax == nil || kp > *d.KpMax) {
+				d.KpMax = &kp
+			}
+		}
+		if ap, err := strconv.Atoi(f[23]); err == nil && ap >= 0 {
+			d.Ap = &ap
+		}
+		if sn, err := strconv.Atoi(f[24]); err == nil && sn >= 0 {
+			d.Sunspots = &sn
+		}
+		if flux, err := strconv.ParseFloat(f[25], 64); err == nil && flux > 0 {
+			d.Flux = &flux
+		}
+		days = append(days, d)
+	}
+	return days, scanner.Err()
+}
+
+// fetchSolarIndices downloads and parses the indices file
+func fetchSolarIndices(ctx context.Context, client *http.Client) ([]SolarDay, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, solarURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return parseSolarIndices(resp.Body)
+}
+
+// saveSolarIndices stores daily indices, replacing days already held
+func (s *Store) saveSolarIndices(ctx context.Context, days []SolarDay) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, d := range days {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO solar_daily (day, f107, sunspots, ap, kp_max)
+			VALUES (?, ?, ?, ?, ?)
+		`, d.Day, d.Flux, d.Sunspots, d.Ap, d.KpMax); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getSolarIndices returns the stored days from since to until, oldest first
+func (s *Store) getSolarIndices(ctx context.Context, since, until time.Time) []SolarDay {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT day, f107, sunspots, ap, kp_max FROM solar_daily
+		WHERE day >= ? AND day <= ? ORDER BY day
+	`, since.UTC().Format("2006-01-02"), until.UTC().Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Error loading solar indices: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var days []SolarDay
+	for rows.Next() {
+		var d SolarDay
+		if err := rows.Scan(&d.Day, &d.Flux, &d.Sunspots, &d.Ap, &d.KpMax); err != nil {
+			log.Printf("Error scanning solar indices: %v", err)
+			continue
+		}
+		days = append(days, d)
+	}
+	return days
+}
+
+// followSolarIndices refreshes the daily indices every few hours
+func (s *Store) followSolarIndices() {
+	ctx := context.Background()
+	client := &http.Client{Timeout: 30 * time.Second}
+	log.Printf("Fetching daily solar indices from %s", solarURL())
+	for {
+		days, err := fetchSolarIndices(ctx, client)
+		if err == nil {
+			err = s.saveSolarIndices(ctx, days)
+		}
+		if err != nil {
+			log.Printf("Error updating solar indices: %v", err)
+		}
+		time.Sleep(solarFetchInterval)
+	}
+}
+
+// stormScale is NOAA's G scale for a Kp value: G1 at Kp 5 up to G5 at Kp 9
+func stormScale(kp float64) int {
+	return min(int(math.Floor(kp))-4, 5)
+}
+
+// solarAnnotations turns geomagnetic storm days between since and until into
+// chart annotations, shaded apart from the user's notes
+func (s *Store) solarAnnotations(ctx context.Context, since, until time.Time) []Annotation {
+	if !solarIndicesEnabled() {
+		return nil
+	}
+	var notes []Annotation
+	for _, d := range s.getSolarIndices(ctx, since, until) {
+		if d.KpMax == nil || *d.KpMax < solarStormKp {
+			continue
+		}
+		start, err := time.Parse("2006-01-02", d.Day)
+		if err != nil {
+			continue
+		}
+		note := fmt.Sprintf("G%d geomagnetic storm (UTC day), Kp %.1f", stormScale(*d.KpMax), *d.KpMax)
+		if d.Ap != nil {
+			note += fmt.Sprintf(", Ap %d", *d.Ap)
+		}
+		if d.Flux != nil {
+			note += fmt.Sprintf(", F10.7 %.0f", *d.Flux)
+		}
+		notes = append(notes, Annotation{Start: start, End: start.Add(24*time.Hour - time.Minute), Note: note, Color: solarStormColor})
+	}
+	return notes
+}
+
+// handleAPISolar returns the stored daily indices: GET /api/solar?days=30
+func handleAPISolar(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= solarMaxDays {
+		days = v
+	}
+	indices := store.getSolarIndices(r.Context(), timeNow().AddDate(0, 0, -days), timeNow())
+	if indices == nil {
+		indices = []SolarDay{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(indices)
+}
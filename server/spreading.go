@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spreading factors the breakdown covers; SF5 and SF6 are only on newer radios and are dropped
+const (
+	minSpreadingFactor = 7
+	maxSpreadingFactor = 12
+)
+
+// loraBandwidths are the LoRa bandwidths in kHz: SX126x/SX127x, then the SX1280's
+var loraBandwidths = []float64{7.8, 10.4, 15.6, 20.8, 31.25, 41.7, 62.5, 125, 250, 500, 203.125, 406.25, 812.5, 1625}
+
+// meshtasticPresets are the Meshtastic modem presets at 250 kHz, by spreading factor
+var meshtasticPresets = map[int]string{
+	7:  "ShortFast",
+	8:  "ShortSlow",
+	9:  "MediumFast",
+	10: "MediumSlow",
+	11: "LongFast",
+}
+
+// minSpreadingHint is how many detections with metadata a channel needs before
+// the breakdown guesses what's on it
+const minSpreadingHint = 10
+
+// modulation returns the event's spreading factor and bandwidth as stored:
+// nil when not reported or not a LoRa setting. Bandwidths are snapped to the
+// nearest LoRa bandwidth, so 203 and 203.125 are the same.
+func (e DetectionEvent) modulation() (sf, bw interface{}) {
+	if e.SF != nil && *e.SF >= minSpreadingFactor && *e.SF <= maxSpreadingFactor {
+		sf = *e.SF
+	}
+	if e.BW != nil {
+		for _, b := range loraBandwidths {
+			if math.Abs(*e.BW-b) <= b*0.01 {
+				bw = b
+				break
+			}
+		}
+	}
+	return sf, bw
+}
+
+// SpreadingRow is one channel's detections by spreading factor and bandwidth
+type SpreadingRow struct {
+	FreqIndex int            `json:"freq_index"`
+	MHz       string         `json:"mhz"`
+	Label     string         `json:"label"`
+	SF        []int          `json:"sf"` // SF7 to SF12
+	BW        map[string]int `json:"bw"` // by kHz
+	Total     int            `json:"total"`
+	Hint      string         `json:"hint,omitempty"`
+}
+
+// getSpreadingBreakdown returns per-channel SF/BW counts for events with
+// metadata since a time, in plan order; channels without any are left out
+func (s *Store) getSpreadingBreakdown(ctx context.Context, deviceID string, since time.Time) []SpreadingRow {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT freq_index, sf, bw, COUNT(*) FROM events
+		WHERE device_id = ? AND timestamp >= ? AND (sf IS NOT NULL OR bw IS NOT NULL)
+		GROUP BY freq_index, sf, bw
+	`, deviceID, since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading spreading breakdown: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	byIndex := make(map[int]*SpreadingRow)
+	for rows.Next() {
+		var fi, count int
+		var sf sql.NullInt64
+		var bw sql.NullFloat64
+		if err := rows.Scan(&fi, &sf, &bw, &count); err != nil {
+			continue
+		}
+		if fi < 0 || fi >= len(frequencies()) {
+			continue
+		}
+		r, ok := byIndex[fi]
+		if !ok {
+			f := frequencyAt(fi)
+			r = &SpreadingRow{FreqIndex: fi, MHz: f.MHz, Label: f.Label,
+				SF: make([]int, maxSpreadingFactor-minSpreadingFactor+1), BW: make(map[string]int)}
+			byIndex[fi] = r
+		}
+		if sf.Valid {
+			r.SF[sf.Int64-minSpreadingFactor] += count
+		}
+		if bw.Valid {
+			r.BW[formatBandwidth(bw.Float64)] += count
+		}
+		r.Total += count
+	}
+
+	var result []SpreadingRow
+	for i := range frequencies() {
+		if r, ok := byIndex[i]; ok {
+			r.Hint = spreadingHint(*r)
+			result = append(result, *r)
+		}
+	}
+	return result
+}
+
+// formatBandwidth renders a bandwidth in kHz without trailing zeros
+func formatBandwidth(khz float64) string {
+	return strconv.FormatFloat(khz, 'f', -1, 64)
+}
+
+// spreadingHint guesses what's transmitting on a channel from its SF/BW mix:
+// Meshtastic presets use 250 kHz and one SF, LoRaWAN ADR moves uplinks across
+// several SFs at 125 kHz, and Sidewalk and LoRaWAN downlinks sit on one SF
+func spreadingHint(r SpreadingRow) string {
+	sfTotal := 0
+	for _, n := range r.SF {
+		sfTotal += n
+	}
+	if sfTotal < minSpreadingHint {
+		return ""
+	}
+	topSF := minSpreadingFactor + slices.Index(r.SF, slices.Max(r.SF))
+	var topBW string
+	for bw, n := range r.BW {
+		if n > r.BW[topBW] || (n == r.BW[topBW] && bw < topBW) {
+			topBW = bw
+		}
+	}
+	spread := 0
+	for _, n := range r.SF {
+		if n*10 >= sfTotal {
+			spread++
+		}
+	}
+	single := slices.Max(r.SF)*10 >= sfTotal*9
+
+	switch {
+	case topBW == "250" && single && meshtasticPresets[topSF] != "":
+		return "Meshtastic " + meshtasticPresets[topSF]
+	case topBW == "125" && spread >= 3:
+		return "LoRaWAN ADR"
+	case single && frequencyAt(r.FreqIndex).Category == "sidewalk":
+		return "Sidewalk (fixed rate)"
+	case single && topBW == "500":
+		return "LoRaWAN downlink"
+	}
+	return ""
+}
+
+// renderSpreadingTable draws the SF histogram per channel, each cell shaded by
+// its share of the channel's detections
+func renderSpreadingTable(w io.Writer, l Lang, rows []SpreadingRow) {
+	fmt.Fprintf(w, `        <table class="freq-table spreading-table">
+            <caption class="sr-only">%s</caption>
+            <thead><tr><th scope="col">MHz</th><th scope="col" class="freq-label">%s</th>`,
+		html.EscapeString(l.T("Detections by spreading factor per frequency")), l.T("Channel"))
+	for sf := minSpreadingFactor; sf <= maxSpreadingFactor; sf++ {
+		fmt.Fprintf(w, `<th scope="col" class="sf-cell">SF%d</th>`, sf)
+	}
+	fmt.Fprintf(w, `<th scope="col">%s</th><th scope="col">%s</th></tr></thead>
+`, l.T("Bandwidth"), l.T("Likely"))
+	fmt.Fprintf(w, `            <tbody>
+`)
+	for _, r := range rows {
+		color := frequencyAt(r.FreqIndex).Color
+		fmt.Fprintf(w, `            <tr class="freq-row"><th scope="row" class="freq-mhz" style="color: %s;">%s</th><td class="freq-label">%s</td>`,
+			color, html.EscapeString(r.MHz), html.EscapeString(r.Label))
+		top := slices.Max(r.SF)
+		for _, n := range r.SF {
+			if n == 0 {
+				fmt.Fprintf(w, `<td class="sf-cell"></td>`)
+				continue
+			}
+			alpha := 0x30 + 0xcf*n/top
+			fmt.Fprintf(w, `<td class="sf-cell" style="background: %s%02x;">%d</td>`, color, alpha, n)
+		}
+		bws := make([]string, 0, len(r.BW))
+		for bw := range r.BW {
+			bws = append(bws, bw)
+		}
+		slices.SortFunc(bws, func(a, b string) int { return r.BW[b] - r.BW[a] })
+		bw := ""
+		if len(bws) > 0 {
+			bw = l.Tf("%s kHz", strings.Join(bws, "/"))
+		}
+		fmt.Fprintf(w, `<td class="freq-label">%s</td><td>%s</td></tr>
+`, bw, html.EscapeString(l.T(r.Hint)))
+	}
+	fmt.Fprintf(w, `            </tbody>
+        </table>
+`)
+}
+
+// handleAPISpreading returns per-channel SF/BW counts: GET /api/spreading?device=ID&hours=24
+func handleAPISpreading(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+	since := timeNow().Add(-time.Duration(hours) * time.Hour)
+
+	var devices []string
+	if id := r.URL.Query().Get("device"); id != "" {
+		devices = []string{id}
+	} else {
+		store.mu.RLock()
+		for id := range store.latest {
+			devices = append(devices, id)
+		}
+		store.mu.RUnlock()
+	}
+
+	result := make(map[string][]SpreadingRow)
+	for _, id := range devices {
+		result[id] = store.getSpreadingBreakdown(ctx, id, since)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql/driver"
+	"math"
+
+	"modernc.org/sqlite"
+)
+
+// Summaries describe how detections per minute and activity are spread, not
+// just their average, so a bursty channel can be told from a steady one. Raw
+// uploads can't be kept forever, so the spread is held as a histogram in
+// the counts format (see channels.go): rollups carry rate_hist and
+// activity_hist, added up like freqs, and the raw side of uploadsSince makes
+// a one-upload histogram with hist_of(value). Values below histExact get a
+// bucket each; above it buckets are histPerDoubling to a doubling, about 9%
+// wide. Rollups made before the histograms existed have none and are left out.
+const (
+	histExact       = 64
+	histPerDoubling = 8
+)
+
+// Distribution is the spread of a per-upload value over a period
+type Distribution struct {
+	Samples int // uploads with a histogram
+	Median  float64
+	P95     float64
+	StdDev  float64
+}
+
+// histBucket is the histogram bucket a value falls in; negatives count as 0
+func histBucket(v int) int {
+	if v < histExact {
+		return max(v, 0)
+	}
+	return histExact + int(math.Log2(float64(v)/histExact)*histPerDoubling)
+}
+
+// bucketValue is a bucket's representative value: the value itself below
+// histExact, the geometric middle of the bucket above it
+func bucketValue(i int) float64 {
+	if i < histExact {
+		return float64(i)
+	}
+	return histExact * math.Pow(2, (float64(i-histExact)+0.5)/histPerDoubling)
+}
+
+// histOf is the histogram of a single value
+func histOf(v int) []int {
+	hist := make([]int, histBucket(v)+1)
+	hist[len(hist)-1] = 1
+	return hist
+}
+
+// distribution summarizes a histogram, or is nil if it's empty
+func distribution(hist []int) *Distribution {
+	var d Distribution
+	var sum, sumSq float64
+	for i, n := range hist {
+		v := bucketValue(i)
+		d.Samples += n
+		sum += float64(n) * v
+		sumSq += float64(n) * v * v
+	}
+	if d.Samples == 0 {
+		return nil
+	}
+	mean := sum / float64(d.Samples)
+	d.StdDev = math.Round(math.Sqrt(max(sumSq/float64(d.Samples)-mean*mean, 0))*100) / 100
+	d.Median, d.P95 = histQuantile(hist, d.Samples, 0.5), histQuantile(hist, d.Samples, 0.95)
+	return &d
+}
+
+// histQuantile is the value below which fraction q of the samples fall
+func histQuantile(hist []int, samples int, q float64) float64 {
+	rank := int(math.Ceil(q * float64(samples)))
+	seen := 0
+	for i, n := range hist {
+		if seen += n; seen >= max(rank, 1) {
+			return math.Round(bucketValue(i)*10) / 10
+		}
+	}
+	return 0
+}
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("hist_of", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		v, ok := args[0].(int64)
+		if !ok {
+			return nil, nil
+		}
+		return encodeCounts(histOf(int(v))), nil
+	})
+}
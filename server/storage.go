@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storageCheckInterval is how often the server measures the database against its cap
+const storageCheckInterval = 5 * time.Minute
+
+// storageCapHeadroom is the fraction of the cap eviction brings usage down to,
+// so a full database isn't trimmed again on every check
+const storageCapHeadroom = 0.9
+
+// SQLite auto_vacuum modes, as PRAGMA auto_vacuum reports them
+var autoVacuumModes = []string{"none", "full", "incremental"}
+
+// timedTables are the tables that grow with time, with the column that dates each row
+var timedTables = []struct{ table, column string }{
+	{"uploads", "timestamp"},
+	{"upload_rollups", "period"},
+	{"events", "timestamp"},
+	{"minute_bins", "minute"},
+	{"device_health", "timestamp"},
+	{"alerts", "timestamp"},
+	{"quarantine", "received"},
+}
+
+// StoragePolicy bounds how much space the database may use
+type StoragePolicy struct {
+	MaxBytes   int64  `json:"max_bytes"`   // 0 means no cap
+	AutoVacuum string `json:"auto_vacuum"` // none, incremental or full
+}
+
+func storagePolicy() StoragePolicy {
+	p := StoragePolicy{AutoVacuum: "incremental"}
+	if v, err := strconv.ParseFloat(os.Getenv("DB_MAX_MB"), 64); err == nil && v > 0 {
+		p.MaxBytes = int64(v * 1e6)
+	}
+	v := strings.ToLower(os.Getenv("DB_AUTO_VACUUM"))
+	for _, mode := range autoVacuumModes {
+		if v == mode {
+			p.AutoVacuum = v
+		}
+	}
+	return p
+}
+
+// setAutoVacuum switches the database to the given auto_vacuum mode. The file
+// has to be rewritten with VACUUM once for that, on the same connection as the
+// PRAGMA; opening in WAL mode has already fixed the header even for a new file.
+func setAutoVacuum(db *sql.DB, mode string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var current int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&current); err != nil {
+		return err
+	}
+	if current >= 0 && current < len(autoVacuumModes) && autoVacuumModes[current] == mode {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = `+mode); err != nil {
+		return err
+	}
+	log.Printf("Switching database to auto_vacuum=%s (rewrites the file once)", mode)
+	_, err = conn.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+// StorageStatus is how much space the database takes and what has been done to bound it
+type StorageStatus struct {
+	Path         string         `json:"path"`
+	FileBytes    int64          `json:"file_bytes"`
+	WALBytes     int64          `json:"wal_bytes"`
+	UsedBytes    int64          `json:"used_bytes"` // Pages holding data
+	FreeBytes    int64          `json:"free_bytes"` // Free pages, reused before the file grows
+	Policy       StoragePolicy  `json:"policy"`
+	Rows         map[string]int `json:"rows"`
+	LastEviction *time.Time     `json:"last_eviction,omitempty"`
+	EvictedRows  int64          `json:"evicted_rows"`
+}
+
+// storageUsage returns the bytes in use and on the free list
+func (s *Store) storageUsage(ctx context.Context) (used, free int64, err error) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var pages, freePages, pageSize int64
+	if err = s.queryRow(ctx, `PRAGMA page_count`).Scan(&pages); err != nil {
+		return
+	}
+	if err = s.queryRow(ctx, `PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return
+	}
+	if err = s.queryRow(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return
+	}
+	return (pages - freePages) * pageSize, freePages * pageSize, nil
+}
+
+// storageStatus measures the database now
+func (s *Store) storageStatus(ctx context.Context) StorageStatus {
+	st := StorageStatus{Path: s.path, Policy: storagePolicy(), Rows: make(map[string]int)}
+	if !s.ephemeral {
+		if fi, err := os.Stat(s.path); err == nil {
+			st.FileBytes = fi.Size()
+		}
+		if fi, err := os.Stat(s.path + "-wal"); err == nil {
+			st.WALBytes = fi.Size()
+		}
+	}
+	var err error
+	if st.UsedBytes, st.FreeBytes, err = s.storageUsage(ctx); err != nil {
+		log.Printf("Error measuring database: %v", err)
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var mode int
+	if s.queryRow(ctx, `PRAGMA auto_vacuum`).Scan(&mode) == nil && mode >= 0 && mode < len(autoVacuumModes) {
+		st.Policy.AutoVacuum = autoVacuumModes[mode] // What the file actually uses
+	}
+	for _, t := range timedTables {
+		var n int
+		s.queryRow(ctx, `SELECT COUNT(*) FROM `+t.table).Scan(&n)
+		st.Rows[t.table] = n
+	}
+
+	s.mu.RLock()
+	if !s.lastEviction.IsZero() {
+		t := s.lastEviction
+		st.LastEviction = &t
+	}
+	st.EvictedRows = s.evictedRows
+	s.mu.RUnlock()
+	return st
+}
+
+// watchStorage reclaims free pages and enforces the size cap every storageCheckInterval
+func (s *Store) watchStorage() {
+	for {
+		ctx := context.Background()
+		p := storagePolicy()
+		if p.AutoVacuum == "incremental" {
+			if err := s.incrementalVacuum(ctx); err != nil {
+				log.Printf("Error running incremental vacuum: %v", err)
+			}
+		}
+		if p.MaxBytes > 0 {
+			if err := s.enforceSizeCap(ctx, p.MaxBytes); err != nil {
+				log.Printf("Error enforcing database size cap: %v", err)
+			}
+		}
+		time.Sleep(storageCheckInterval)
+	}
+}
+
+// enforceSizeCap deletes the oldest day of data across every timed table until
+// the database is back under the cap (with headroom). Today's data is kept
+// even if it alone is over the cap.
+func (s *Store) enforceSizeCap(ctx context.Context, maxBytes int64) error {
+	used, _, err := s.storageUsage(ctx)
+	if err != nil || used <= maxBytes {
+		return err
+	}
+	target := int64(float64(maxBytes) * storageCapHeadroom)
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	var total int64
+	for used > target {
+		oldest, ok := s.oldestData(ctx)
+		if !ok {
+			break
+		}
+		t := parseDBTime(oldest)
+		cutoff := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+		if cutoff.After(today) {
+			log.Printf("WARNING: database uses %.1f MB, over its %.1f MB cap, with only today's data left",
+				float64(used)/1e6, float64(maxBytes)/1e6)
+			break
+		}
+		n, err := s.deleteBefore(ctx, cutoff.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		total += n
+		if used, _, err = s.storageUsage(ctx); err != nil {
+			return err
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	if err := s.incrementalVacuum(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	s.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	log.Printf("Database over its %.1f MB cap: evicted %d oldest rows, now %.1f MB in use",
+		float64(maxBytes)/1e6, total, float64(used)/1e6)
+
+	s.mu.Lock()
+	s.lastEviction = time.Now()
+	s.evictedRows += total
+	s.mu.Unlock()
+	return nil
+}
+
+// incrementalVacuum returns free pages to the filesystem. The PRAGMA frees one
+// page per step, so its rows have to be read to the end; it does nothing unless
+// auto_vacuum is incremental.
+func (s *Store) incrementalVacuum(ctx context.Context) error {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `PRAGMA incremental_vacuum`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// oldestData returns the earliest timestamp in any timed table
+func (s *Store) oldestData(ctx context.Context) (string, bool) {
+	parts := make([]string, len(timedTables))
+	for i, t := range timedTables {
+		parts[i] = `SELECT MIN(` + t.column + `) AS t FROM ` + t.table
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var oldest sql.NullString
+	err := s.queryRow(ctx, `SELECT MIN(t) FROM (`+strings.Join(parts, " UNION ALL ")+`)`).Scan(&oldest)
+	return oldest.String, err == nil && oldest.Valid
+}
+
+// deleteBefore deletes rows older than cutoff from every timed table
+func (s *Store) deleteBefore(ctx context.Context, cutoff string) (int64, error) {
+	ctx, cancel := dbContext(ctx, dbMaintenanceTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var total int64
+	for _, t := range timedTables {
+		res, err := tx.ExecContext(ctx, `DELETE FROM `+t.table+` WHERE `+t.column+` < ?`, cutoff)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", t.table, err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, tx.Commit()
+}
+
+// AdminStatus is the server's housekeeping state, for operators
+type AdminStatus struct {
+	ReadOnly   bool              `json:"read_only"`
+	Ephemeral  bool              `json:"ephemeral"`
+	Devices    int               `json:"devices"`
+	Retention  RetentionPolicy   `json:"retention"`
+	Storage    StorageStatus     `json:"storage"`
+	Saves      SaveCounters      `json:"saves"`
+	Integrity  *IntegrityReport  `json:"integrity,omitempty"`
+	Forwarders []ForwarderStatus `json:"forwarders,omitempty"`
+	Quotas     *QuotaStatus      `json:"quotas,omitempty"`
+}
+
+// handleAPIAdminStatus reports database size, retention, the size cap, upload
+// save failures, the last integrity check, forwarders' backlogs and quota use:
+// GET /api/admin/status (admin only once an admin user exists)
+func handleAPIAdminStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	store.mu.RLock()
+	devices, integrity := len(store.latest), store.integrity
+	store.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminStatus{
+		ReadOnly:   store.readOnly,
+		Ephemeral:  store.ephemeral,
+		Devices:    devices,
+		Retention:  retentionPolicy(),
+		Storage:    store.storageStatus(ctx),
+		Saves:      store.saves.counters(),
+		Integrity:  integrity,
+		Forwarders: forwarderStatuses(ctx),
+		Quotas:     quotas.status(ctx),
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Night is shaded on a located detector's hourly charts, since much of what
+// it hears (irrigation controllers, streetlights, people's phones) follows the
+// sun. Over more than nightMaxDays the nights merge into stripes, so longer
+// charts go unshaded.
+const (
+	nightColor   = "#3f51b5"
+	nightMaxDays = 31
+	sunAltitude  = -0.833 // degrees: refraction and the sun's radius at rise and set
+)
+
+// sunriseSunset is when the sun rises and sets on the UTC day containing day,
+// by the NOAA sunrise equation. ok is false in polar day or night, when up
+// says which.
+func sunriseSunset(day time.Time, lat, lon float64) (rise, set time.Time, up, ok bool) {
+	rad := math.Pi / 180
+	j2000 := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	noon := time.Date(day.UTC().Year(), day.UTC().Month(), day.UTC().Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(noon.Sub(j2000).Hours() / 24)
+
+	mean := n - lon/360
+	anomaly := math.Mod(357.5291+0.98560028*mean, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := mean + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*ecliptic*rad)
+	declination := math.Asin(math.Sin(ecliptic*rad) * math.Sin(23.4397*rad))
+
+	cosHour := (math.Sin(sunAltitude*rad) - math.Sin(lat*rad)*math.Sin(declination)) / (math.Cos(lat*rad) * math.Cos(declination))
+	if cosHour > 1 || cosHour < -1 {
+		return time.Time{}, time.Time{}, cosHour < -1, false
+	}
+	hourAngle := math.Acos(cosHour) / rad / 360
+	at := func(days float64) time.Time {
+		return j2000.Add(time.Duration(days * 24 * float64(time.Hour)))
+	}
+	return at(transit - hourAngle), at(transit + hourAngle), false, true
+}
+
+// nightAnnotations marks each night between from and to at a location as a
+// chart annotation, with times in loc. Polar nights cover the whole day.
+func nightAnnotations(lat, lon float64, loc *time.Location, from, to time.Time) []Annotation {
+	if to.Sub(from) > nightMaxDays*24*time.Hour {
+		return nil
+	}
+	var notes []Annotation
+	night := func(start, end time.Time) {
+		start, end = start.In(loc), end.In(loc)
+		if end.After(from) && start.Before(to) {
+			notes = append(notes, Annotation{Start: start, End: end, Note: "Night", Color: nightColor})
+		}
+	}
+
+	// Each night runs from one UTC day's sunset to the next day's sunrise; the
+	// days either side of the range supply the nights running over its ends
+	var sunset time.Time
+	for day := from.UTC().AddDate(0, 0, -1); !day.After(to.UTC().AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+		rise, set, up, ok := sunriseSunset(day, lat, lon)
+		switch {
+		case ok:
+			if !sunset.IsZero() && rise.After(sunset) {
+				night(sunset, rise)
+			}
+			sunset = set
+		case !up:
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+			if !sunset.IsZero() {
+				start = sunset
+			}
+			sunset = start
+		default:
+			if !sunset.IsZero() {
+				night(sunset, time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC))
+			}
+			sunset = time.Time{}
+		}
+	}
+	if !sunset.IsZero() {
+		night(sunset, to) // a polar night still going
+	}
+	return notes
+}
+
+// deviceNights is nightAnnotations at a detector, or nil if it has no location
+func deviceNights(d DeviceInfo, from, to time.Time) []Annotation {
+	if !d.Located() {
+		return nil
+	}
+	return nightAnnotations(*d.Latitude, *d.Longitude, d.Location(), from, to)
+}
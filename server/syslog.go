@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// With SYSLOG_URL set, every upload and alert is also sent to a syslog
+// collector as an RFC 5424 message, for SIEMs that treat unexpected RF activity
+// as a physical-security signal:
+//
+//	<134>1 2026-10-15T21:46:38.000Z pi lora-detector - upload [lora@32473 device="lora-detector-1" new="3" sidewalk="0" meshtastic="3" lorawan="0" ...] lora-detector-1: 3 new detections
+//
+// UDP sends one message per datagram; TCP and TLS use octet-counting framing
+// (RFC 6587). Messages are queued and dropped, not blocked on, when the
+// collector can't keep up.
+const (
+	syslogQueue       = 1000
+	syslogDialTimeout = 10 * time.Second
+	syslogRetry       = 10 * time.Second
+	syslogEnterprise  = "lora@32473" // SD-ID under the documentation enterprise number (RFC 5612)
+)
+
+// Syslog severities used
+const (
+	syslogWarning = 4
+	syslogInfo    = 6
+)
+
+// syslogOut sends messages to the collector; nil when SYSLOG_URL isn't set
+var syslogOut *syslogWriter
+
+// syslogWriter formats messages and sends them from a queue
+type syslogWriter struct {
+	network  string // udp, tcp or tls
+	addr     string
+	facility int
+	hostname string
+	appName  string
+	queue    chan []byte
+	dropped  atomic.Int64
+}
+
+// syslogFacilities are the facility names SYSLOG_FACILITY accepts
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "security": 13,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogFromEnv reads SYSLOG_URL (udp://host:514, tcp://host:601 or
+// tls://host:6514), SYSLOG_FACILITY (default local0) and SYSLOG_APP_NAME
+func syslogFromEnv() (*syslogWriter, error) {
+	raw := os.Getenv("SYSLOG_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("SYSLOG_URL: %w", err)
+	}
+	defaultPort := map[string]string{"udp": "514", "tcp": "601", "tls": "6514"}[u.Scheme]
+	if defaultPort == "" {
+		return nil, errors.New("SYSLOG_URL must start with udp://, tcp:// or tls://")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("SYSLOG_URL has no host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+
+	facility := syslogFacilities["local0"]
+	if name := strings.ToLower(os.Getenv("SYSLOG_FACILITY")); name != "" {
+		f, ok := syslogFacilities[name]
+		if !ok {
+			return nil, errors.New("SYSLOG_FACILITY must be local0-local7, user, daemon, auth or security")
+		}
+		facility = f
+	}
+	appName := os.Getenv("SYSLOG_APP_NAME")
+	if appName == "" {
+		appName = "lora-detector"
+	}
+	hostname, _ := os.Hostname()
+	return &syslogWriter{
+		network:  u.Scheme,
+		addr:     net.JoinHostPort(u.Hostname(), port),
+		facility: facility,
+		hostname: syslogToken(hostname, 255),
+		appName:  syslogToken(appName, 48),
+		queue:    make(chan []byte, syslogQueue),
+	}, nil
+}
+
+// syslogToken makes a header field printable ASCII without spaces, as RFC 5424 requires
+func syslogToken(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	return s[:min(len(s), maxLen)]
+}
+
+// syslogParam is one SD-PARAM
+type syslogParam struct{ name, value string }
+
+// format builds an RFC 5424 message
+func (s *syslogWriter) format(severity int, t time.Time, msgID string, params []syslogParam, msg string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s - %s ", s.facility*8+severity,
+		t.UTC().Format("2006-01-02T15:04:05.000Z"), s.hostname, s.appName, msgID)
+	if len(params) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + syslogEnterprise)
+		for _, p := range params {
+			// PARAM-VALUE escapes ", \ and ]
+			v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(p.value)
+			fmt.Fprintf(&b, ` %s="%s"`, p.name, v)
+		}
+		b.WriteString("]")
+	}
+	if msg != "" {
+		b.WriteString(" " + msg)
+	}
+	return []byte(b.String())
+}
+
+// enqueue hands a message to the sender, dropping it if the queue is full
+func (s *syslogWriter) enqueue(m []byte) {
+	select {
+	case s.queue <- m:
+	default:
+		if s.dropped.Add(1)%100 == 1 {
+			log.Printf("Syslog: queue full, dropped %d messages so far", s.dropped.Load())
+		}
+	}
+}
+
+// upload sends an upload with its new detections per category and frequency
+func (s *syslogWriter) upload(stats Stats, fresh []int) {
+	if s == nil {
+		return
+	}
+	total := 0
+	for _, c := range fresh {
+		total += max(c, 0)
+	}
+	params := []syslogParam{
+		{"device", stats.DeviceID},
+		{"new", strconv.Itoa(total)},
+	}
+	for _, c := range categoryInfo {
+		params = append(params, syslogParam{c.Name, strconv.Itoa(max(categoryCount(fresh, c.Name), 0))})
+	}
+	params = append(params,
+		syslogParam{"total", strconv.Itoa(stats.TotalDetections)},
+		syslogParam{"per_min", strconv.Itoa(stats.DetectionsPerMin)},
+		syslogParam{"activity_pct", strconv.Itoa(stats.CurrentActivity)},
+	)
+	for i, c := range fresh {
+		if i < len(frequencies()) && c > 0 {
+			params = append(params, syslogParam{"f" + strings.ReplaceAll(frequencyAt(i).MHz, ".", "_"), strconv.Itoa(c)})
+		}
+	}
+	s.enqueue(s.format(syslogInfo, stats.Timestamp, "upload", params,
+		fmt.Sprintf("%s: %d new detections", stats.DeviceID, total)))
+}
+
+// alert sends a raised alert at warning severity
+func (s *syslogWriter) alert(deviceID, kind, message string, t time.Time) {
+	if s == nil {
+		return
+	}
+	params := []syslogParam{{"device", deviceID}, {"kind", kind}}
+	s.enqueue(s.format(syslogWarning, t, "alert", params, deviceID+": "+message))
+}
+
+// run sends queued messages for the life of the server, reconnecting stream
+// transports when they fail
+func (s *syslogWriter) run() {
+	var conn net.Conn
+	for m := range s.queue {
+		for {
+			if conn == nil {
+				var err error
+				if conn, err = s.dial(); err != nil {
+					log.Printf("Syslog: connecting to %s: %v (retrying in %s)", s.addr, err, syslogRetry)
+					time.Sleep(syslogRetry)
+					continue
+				}
+			}
+			frame := m
+			if s.network != "udp" {
+				frame = append([]byte(strconv.Itoa(len(m))+" "), m...) // Octet counting
+			}
+			conn.SetWriteDeadline(time.Now().Add(syslogDialTimeout))
+			if _, err := conn.Write(frame); err != nil {
+				log.Printf("Syslog: sending to %s: %v", s.addr, err)
+				conn.Close()
+				conn = nil
+				if s.network == "udp" {
+					break // A lost datagram isn't worth retrying
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// dial connects to the collector
+func (s *syslogWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	if s.network == "tls" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		return tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: host})
+	}
+	return dialer.Dial(s.network, s.addr)
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploads and alerts can be tagged with short labels ("storm", "ring-install")
+// and found again through /api/search and /search. A tag keeps the device and
+// time of what it labels, so it stays searchable after retention rolls the
+// upload itself into hourly totals.
+const (
+	tagUpload = "upload"
+	tagAlert  = "alert"
+)
+
+// tagTables maps a taggable kind to the table holding it
+var tagTables = map[string]string{
+	tagUpload: "uploads",
+	tagAlert:  "alerts",
+}
+
+// errNotTagged means the upload or alert to tag doesn't exist
+var errNotTagged = errors.New("no such upload or alert")
+
+// normalizeTag lower-cases a tag and checks it is 1-40 letters, digits or - _ . :
+func normalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > 40 {
+		return "", false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return "", false
+		}
+	}
+	return tag, true
+}
+
+// TagCount is a tag and how many items carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// addTag labels an upload or alert; tagging twice is a no-op
+func (s *Store) addTag(ctx context.Context, kind string, id int64, tag string) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	res, err := s.exec(ctx, `
+		INSERT OR IGNORE INTO tags (kind, ref_id, device_id, timestamp, tag)
+		SELECT ?, id, device_id, timestamp, ? FROM `+tagTables[kind]+` WHERE id = ?
+	`, kind, tag, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Either already tagged or not there; only the latter is an error
+		var exists int
+		s.queryRow(ctx, `SELECT COUNT(*) FROM tags WHERE kind = ? AND ref_id = ? AND tag = ?`, kind, id, tag).Scan(&exists)
+		if exists == 0 {
+			return errNotTagged
+		}
+	}
+	return nil
+}
+
+// removeTag takes a tag off an item
+func (s *Store) removeTag(ctx context.Context, kind string, id int64, tag string) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `DELETE FROM tags WHERE kind = ? AND ref_id = ? AND tag = ?`, kind, id, tag)
+	return err
+}
+
+// getTagCounts lists every tag in use, most used first
+func (s *Store) getTagCounts(ctx context.Context) []TagCount {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT tag, COUNT(*) FROM tags GROUP BY tag ORDER BY COUNT(*) DESC, tag`)
+	if err != nil {
+		log.Printf("Error loading tags: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var tags []TagCount
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Count); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// SearchQuery filters the upload and alert history. Empty fields match anything.
+type SearchQuery struct {
+	Tag       string
+	Kind      string   // tagUpload or tagAlert
+	DeviceIDs []string // nil for every device
+	Category  string   // Uploads with new detections in it; per-channel alerts on it
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// SearchResult is one matching upload or alert, newest first
+type SearchResult struct {
+	Kind       string    `json:"kind"`
+	ID         int64     `json:"id"`
+	DeviceID   string    `json:"device_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Summary    string    `json:"summary"`
+	Detections []int     `json:"detections,omitempty"` // Uploads: increase per frequency since the previous upload
+	Tags       []string  `json:"tags"`
+}
+
+// search runs a SearchQuery over raw uploads, alerts and, for tag searches,
+// tags whose upload has since been rolled up
+func (s *Store) search(ctx context.Context, q SearchQuery) []SearchResult {
+	var results []SearchResult
+	timeArgs := []interface{}{q.Since.Format("2006-01-02 15:04:05"), q.Until.Format("2006-01-02 15:04:05")}
+
+	// With a tag, the tags table picks the items; otherwise scan the window
+	var tagged map[string]map[int64]bool
+	if q.Tag != "" {
+		tagged = map[string]map[int64]bool{tagUpload: {}, tagAlert: {}}
+		cond, args := deviceFilter(q.DeviceIDs)
+		rctx, cancel := dbContext(ctx, dbReadTimeout)
+		rows, err := s.query(rctx, `
+			SELECT kind, ref_id, device_id, timestamp FROM tags
+			WHERE tag = ? AND `+cond+` AND timestamp BETWEEN ? AND ?
+		`, append(append([]interface{}{q.Tag}, args...), timeArgs...)...)
+		if err != nil {
+			log.Printf("Error searching tags: %v", err)
+			cancel()
+			return nil
+		}
+		var orphans []SearchResult
+		for rows.Next() {
+			var r SearchResult
+			var ts string
+			if err := rows.Scan(&r.Kind, &r.ID, &r.DeviceID, &ts); err != nil || tagged[r.Kind] == nil {
+				continue
+			}
+			r.Timestamp = parseDBTime(ts)
+			tagged[r.Kind][r.ID] = true
+			orphans = append(orphans, r)
+		}
+		rows.Close()
+		cancel()
+
+		found := make(map[string]map[int64]bool)
+		for _, kind := range []string{tagUpload, tagAlert} {
+			if q.Kind != "" && q.Kind != kind {
+				continue
+			}
+			found[kind] = make(map[int64]bool)
+			for _, r := range s.searchKind(ctx, kind, q, tagged[kind], 0) {
+				found[kind][r.ID] = true
+				results = append(results, r)
+			}
+		}
+		// Tagged items retention has since removed: only their time and device remain
+		if q.Category == "" {
+			for _, r := range orphans {
+				if f, ok := found[r.Kind]; ok && !f[r.ID] {
+					r.Summary = "No longer stored (removed by retention)"
+					results = append(results, r)
+				}
+			}
+		}
+	} else {
+		for _, kind := range []string{tagUpload, tagAlert} {
+			if q.Kind == "" || q.Kind == kind {
+				results = append(results, s.searchKind(ctx, kind, q, nil, q.Limit)...)
+			}
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if !results[a].Timestamp.Equal(results[b].Timestamp) {
+			return results[a].Timestamp.After(results[b].Timestamp)
+		}
+		return results[a].ID > results[b].ID
+	})
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	s.attachTags(ctx, results)
+	return results
+}
+
+// searchKind finds uploads or alerts in the query's window and devices, newest
+// first. ids, if not nil, restricts it to those items; limit 0 is no limit.
+func (s *Store) searchKind(ctx context.Context, kind string, q SearchQuery, ids map[int64]bool, limit int) []SearchResult {
+	if ids != nil && len(ids) == 0 {
+		return nil
+	}
+	cond, args := deviceFilter(q.DeviceIDs)
+	if len(q.DeviceIDs) > 0 {
+		cond = "u." + cond
+	}
+	cond += " AND u.timestamp BETWEEN ? AND ?"
+	args = append(args, q.Since.Format("2006-01-02 15:04:05"), q.Until.Format("2006-01-02 15:04:05"))
+	if ids != nil {
+		// One JSON parameter, so the statement is the same for any number of ids
+		// and a large set can't pass SQLite's limit on variables
+		list := make([]int64, 0, len(ids))
+		for id := range ids {
+			list = append(list, id)
+		}
+		b, _ := json.Marshal(list)
+		cond += " AND u.id IN (SELECT value FROM json_each(?))"
+		args = append(args, string(b))
+	}
+	if kind == tagAlert {
+		return s.searchAlerts(ctx, cond, args, q.Category, limit)
+	}
+	return s.searchUploads(ctx, cond, args, q.Category, limit)
+}
+
+// searchUploads loads matching uploads with their increase over the previous
+// upload, so a category filter can keep the ones with new detections in it
+func (s *Store) searchUploads(ctx context.Context, cond string, args []interface{}, category string, limit int) []SearchResult {
+	// Loaded first, as in getActivityBuckets: the reader pool may be one connection
+	cursors := s.rollupCursors(ctx)
+
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT u.id, u.device_id, u.timestamp, COALESCE(u.uptime_seconds, 0),
+			u.freqs, p.id IS NOT NULL, COALESCE(p.uptime_seconds, 0), COALESCE(p.freqs, '')
+		FROM uploads u
+		LEFT JOIN uploads p ON p.id = (
+			SELECT id FROM uploads WHERE device_id = u.device_id AND (timestamp, id) < (u.timestamp, u.id)
+			ORDER BY timestamp DESC, id DESC LIMIT 1)
+		WHERE `+cond+`
+		ORDER BY u.timestamp DESC, u.id DESC
+	`, args...)
+	if err != nil {
+		log.Printf("Error searching uploads: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() && (limit == 0 || len(results) < limit) {
+		var r SearchResult
+		var ts, freqs, prevFreqs string
+		var uptime, prevUptime int
+		var hasPrev bool
+		if err := rows.Scan(&r.ID, &r.DeviceID, &ts, &uptime, &freqs, &hasPrev, &prevUptime, &prevFreqs); err != nil {
+			continue
+		}
+		r.Kind, r.Timestamp = tagUpload, parseDBTime(ts)
+		prev := parseCounts(prevFreqs)
+		if c, ok := cursors[r.DeviceID]; !hasPrev && ok && r.Timestamp.Equal(parseDBTime(c.next)) {
+			hasPrev, prevUptime, prev = true, c.uptime, c.freqs
+		}
+		if !hasPrev || uptime < prevUptime {
+			prev = nil
+		}
+		r.Detections = fitCounts(countIncreases(prev, parseCounts(freqs)), len(frequencies()))
+		total := 0
+		for _, n := range r.Detections {
+			total += n
+		}
+		if category != "" && categoryCount(r.Detections, category) == 0 {
+			continue
+		}
+		r.Summary = fmt.Sprintf("%d new detections", total)
+		results = append(results, r)
+	}
+	return results
+}
+
+// searchAlerts loads matching alerts. Only per-channel alerts (baseline:MHz)
+// belong to a category.
+func (s *Store) searchAlerts(ctx context.Context, cond string, args []interface{}, category string, limit int) []SearchResult {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT u.id, u.device_id, u.timestamp, u.kind, u.message
+		FROM alerts u WHERE `+cond+`
+		ORDER BY u.timestamp DESC, u.id DESC
+	`, args...)
+	if err != nil {
+		log.Printf("Error searching alerts: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() && (limit == 0 || len(results) < limit) {
+		var r SearchResult
+		var ts, kind string
+		if err := rows.Scan(&r.ID, &r.DeviceID, &ts, &kind, &r.Summary); err != nil {
+			continue
+		}
+		if category != "" && alertCategory(kind) != category {
+			continue
+		}
+		r.Kind, r.Timestamp = tagAlert, parseDBTime(ts)
+		results = append(results, r)
+	}
+	return results
+}
+
+// attachTags fills in each result's tags
+func (s *Store) attachTags(ctx context.Context, results []SearchResult) {
+	if len(results) == 0 {
+		return
+	}
+	index := make(map[string]int, len(results))
+	var conds []string
+	var args []interface{}
+	for i := range results {
+		results[i].Tags = []string{}
+		index[fmt.Sprintf("%s:%d", results[i].Kind, results[i].ID)] = i
+		conds = append(conds, "(kind = ? AND ref_id = ?)")
+		args = append(args, results[i].Kind, results[i].ID)
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT kind, ref_id, tag FROM tags WHERE `+strings.Join(conds, " OR ")+` ORDER BY tag`, args...)
+	if err != nil {
+		log.Printf("Error loading tags: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, tag string
+		var id int64
+		if err := rows.Scan(&kind, &id, &tag); err != nil {
+			continue
+		}
+		if i, ok := index[fmt.Sprintf("%s:%d", kind, id)]; ok {
+			results[i].Tags = append(results[i].Tags, tag)
+		}
+	}
+}
+
+// searchQueryFrom reads tag, kind, device or group, category, since/until or
+// days (default 30) and limit (default 100). The error is for the caller to show.
+func searchQueryFrom(ctx context.Context, v url.Values) (SearchQuery, int, error) {
+	q := SearchQuery{Kind: v.Get("kind"), Category: v.Get("category"), Until: timeNow(), Limit: 100}
+	if t := v.Get("tag"); t != "" {
+		tag, ok := normalizeTag(t)
+		if !ok {
+			return q, http.StatusBadRequest, errors.New("tags are 1-40 letters, digits, - _ . or :")
+		}
+		q.Tag = tag
+	}
+	if _, ok := tagTables[q.Kind]; q.Kind != "" && !ok {
+		return q, http.StatusBadRequest, errors.New("kind must be upload or alert")
+	}
+	if _, ok := categoryByName(q.Category); q.Category != "" && !ok {
+		return q, http.StatusBadRequest, errors.New("unknown category")
+	}
+	if device := v.Get("device"); device != "" {
+		q.DeviceIDs = []string{device}
+	} else if group := v.Get("group"); group != "" {
+		if q.DeviceIDs = store.getGroupDevices(ctx, group); q.DeviceIDs == nil {
+			return q, http.StatusNotFound, errors.New("unknown group")
+		}
+	}
+	days := 30
+	if d, err := strconv.Atoi(v.Get("days")); err == nil && d > 0 && d <= 3650 {
+		days = d
+	}
+	q.Since = timeNow().AddDate(0, 0, -days)
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = parseFormTime(s, time.Local); err != nil {
+			return q, http.StatusBadRequest, err
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = parseFormTime(s, time.Local); err != nil {
+			return q, http.StatusBadRequest, err
+		}
+	}
+	if n, err := strconv.Atoi(v.Get("limit")); err == nil && n > 0 {
+		q.Limit = min(n, 1000)
+	}
+	return q, 0, nil
+}
+
+// tagFromForm reads kind, id and tag for adding or removing a tag
+func tagFromForm(r *http.Request) (string, int64, string, error) {
+	kind := r.FormValue("kind")
+	if _, ok := tagTables[kind]; !ok {
+		return "", 0, "", errors.New("kind must be upload or alert")
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return "", 0, "", errors.New("id required")
+	}
+	tag, ok := normalizeTag(r.FormValue("tag"))
+	if !ok {
+		return "", 0, "", errors.New("tags are 1-40 letters, digits, - _ . or :")
+	}
+	return kind, id, tag, nil
+}
+
+// applyTagForm adds (or with remove set, removes) the tag a form describes,
+// writing the error response itself and reporting whether it succeeded
+func applyTagForm(w http.ResponseWriter, r *http.Request) bool {
+	kind, id, tag, err := tagFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if r.Method == http.MethodDelete || r.FormValue("remove") != "" {
+		err = store.removeTag(r.Context(), kind, id, tag)
+	} else {
+		err = store.addTag(r.Context(), kind, id, tag)
+	}
+	if errors.Is(err, errNotTagged) {
+		http.Error(w, "Unknown "+kind, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error saving tag: %v", err)
+		http.Error(w, "Failed to save tag", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// handleAPITags lists tags in use (GET), tags an upload or alert (POST kind,
+// id, tag) or removes a tag (DELETE ?kind=&id=&tag=): /api/tags
+func handleAPITags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		if !applyTagForm(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	tags := store.getTagCounts(r.Context())
+	if tags == nil {
+		tags = []TagCount{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// handleAPISearch searches uploads and alerts: GET /api/search with optional
+// tag, kind, device or group, category, since/until or days, limit
+func handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	q, status, err := searchQueryFrom(r.Context(), r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	results := store.search(r.Context(), q)
+	if results == nil {
+		results = []SearchResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleSearch renders the search form and results, and adds or removes tags
+// on POST: /search
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPost {
+		if applyTagForm(w, r) {
+			http.Redirect(w, r, "/search?"+r.URL.RawQuery, http.StatusSeeOther)
+		}
+		return
+	}
+	v := r.URL.Query()
+	q, status, err := searchQueryFrom(ctx, v)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	results := store.search(ctx, q)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Search", `    <style>
+        .search-form { display: flex; flex-wrap: wrap; gap: 8px; align-items: center; }
+        .search-results td { padding: 4px 10px 4px 0; font-size: 0.9em; vertical-align: top; }
+        .search-results a, .tag-cloud a { color: #00d4ff; }
+        .tag { display: inline-block; background: rgba(0,212,255,0.15); border-radius: 10px; padding: 1px 8px; margin: 0 4px 2px 0; }
+        .tag form, .tag-add { display: inline; }
+        .tag button { background: none; border: none; color: #888; cursor: pointer; padding: 0 0 0 4px; }
+        .tag-add input { width: 90px; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🔎 Search History</h1>
+    <p class="subtitle">Uploads and alerts by tag, detector, category and time</p>
+    <div class="card">
+        <form class="search-form" method="get" action="/search">
+            <input type="text" name="tag" placeholder="tag" value="%s" list="tags">
+            <input type="text" name="device" placeholder="device" value="%s">
+            <select name="category"><option value="">any category</option>`,
+		html.EscapeString(q.Tag), html.EscapeString(v.Get("device")))
+	for _, c := range categoryInfo {
+		selected := ""
+		if c.Name == q.Category {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option value="%s"%s>%s</option>`, c.Name, selected, html.EscapeString(c.Title))
+	}
+	fmt.Fprintf(w, `</select>
+            <select name="kind">`)
+	for _, k := range []struct{ value, label string }{{"", "uploads and alerts"}, {tagUpload, "uploads"}, {tagAlert, "alerts"}} {
+		selected := ""
+		if k.value == q.Kind {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option value="%s"%s>%s</option>`, k.value, selected, k.label)
+	}
+	fmt.Fprintf(w, `</select>
+            <input type="datetime-local" name="since" value="%s">
+            <input type="datetime-local" name="until" value="%s">
+            <button>Search</button>
+        </form>
+        <datalist id="tags">`, q.Since.Format("2006-01-02T15:04"), q.Until.Format("2006-01-02T15:04"))
+	tags := store.getTagCounts(ctx)
+	for _, t := range tags {
+		fmt.Fprintf(w, `<option value="%s">`, html.EscapeString(t.Tag))
+	}
+	fmt.Fprintf(w, `</datalist>
+`)
+	if len(tags) > 0 {
+		fmt.Fprintf(w, `        <p class="tag-cloud">`)
+		for _, t := range tags {
+			fmt.Fprintf(w, `<a class="tag" href="/search?tag=%s&amp;days=3650">%s (%d)</a>`, url.QueryEscape(t.Tag), html.EscapeString(t.Tag), t.Count)
+		}
+		fmt.Fprintf(w, `</p>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+    <div class="card">
+        <h2>%d Results</h2>
+`, len(results))
+	if len(results) == 0 {
+		fmt.Fprintf(w, `        <p>Nothing matches.</p>
+`)
+	} else {
+		action := html.EscapeString("/search?" + r.URL.RawQuery)
+		fmt.Fprintf(w, `        <table class="search-results">
+`)
+		for _, res := range results {
+			summary := html.EscapeString(res.Summary)
+			if res.Kind == tagUpload && res.Detections != nil {
+				var parts []string
+				for i, n := range res.Detections {
+					if n > 0 {
+						parts = append(parts, fmt.Sprintf(`<span style="color: %s;">%s ×%d</span>`, frequencyAt(i).Color, frequencyAt(i).MHz, n))
+					}
+				}
+				if len(parts) > 0 {
+					summary += ": " + strings.Join(parts, " ")
+				}
+			}
+			fmt.Fprintf(w, `            <tr><td class="timestamp">%s</td><td><a href="/device?device=%s">%s</a></td><td>%s</td><td>%s</td><td>`,
+				res.Timestamp.Format("Jan 2 15:04:05"), url.QueryEscape(res.DeviceID), html.EscapeString(res.DeviceID), res.Kind, summary)
+			for _, t := range res.Tags {
+				fmt.Fprintf(w, `<span class="tag"><a href="/search?tag=%s&amp;days=3650">%s</a>`, url.QueryEscape(t), html.EscapeString(t))
+				if !store.readOnly {
+					fmt.Fprintf(w, `<form method="post" action="%s"><input type="hidden" name="kind" value="%s"><input type="hidden" name="id" value="%d"><input type="hidden" name="tag" value="%s"><input type="hidden" name="remove" value="1"><button title="Remove tag">×</button></form>`,
+						action, res.Kind, res.ID, html.EscapeString(t))
+				}
+				fmt.Fprintf(w, `</span>`)
+			}
+			if !store.readOnly {
+				fmt.Fprintf(w, `<form class="tag-add" method="post" action="%s"><input type="hidden" name="kind" value="%s"><input type="hidden" name="id" value="%d"><input type="text" name="tag" placeholder="add tag" list="tags"></form>`,
+					action, res.Kind, res.ID)
+			}
+			fmt.Fprintf(w, `</td></tr>
+`)
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
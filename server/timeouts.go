@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// defaultRequestTimeout bounds a whole HTTP request, including every database
+// call it makes, so a hung SQLite operation can't pin handler goroutines
+const defaultRequestTimeout = 20 * time.Second
+
+// ingestTimeout bounds one upload arriving over UDP, CoAP or serial, which have
+// no request context of their own
+const ingestTimeout = 15 * time.Second
+
+// requestTimeouts overrides defaultRequestTimeout for endpoints that legitimately
+// run long: merging an uploaded database, the admin integrity check and the
+// live detection stream
+var requestTimeouts = map[string]time.Duration{
+	"/api/import": dbMaintenanceTimeout,
+	"/admin":      dbMaintenanceTimeout,
+	"/api/stream": liveStreamFor,
+}
+
+// requestTimeout returns the deadline for requests to path
+func requestTimeout(path string) time.Duration {
+	if d, ok := requestTimeouts[path]; ok {
+		return d
+	}
+	return defaultRequestTimeout
+}
+
+// timeoutGuard gives every request a deadline; handlers pass r.Context() down
+// to the Store, so the SQL calls are cancelled with it
+func timeoutGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r.URL.Path))
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ingestContext bounds an upload from a transport without requests
+func ingestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), ingestTimeout)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+	_ "time/tzdata" // the Alpine image has no zoneinfo
+)
+
+// siteLocation is the timezone used for day and hour bucketing when a device
+// has none of its own. Defaults to the server's local zone.
+func siteLocation() *time.Location {
+	if name := os.Getenv("SITE_TZ"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+		log.Printf("Warning: unknown SITE_TZ %q, using %s", name, time.Local)
+	}
+	return time.Local
+}
+
+// Location returns the device's timezone, falling back to the site timezone
+func (d DeviceInfo) Location() *time.Location {
+	if d.Timezone != "" {
+		if loc, err := time.LoadLocation(d.Timezone); err == nil {
+			return loc
+		}
+	}
+	return siteLocation()
+}
+
+// locationName labels a timezone for display; the server zone reports itself as "Local"
+func locationName(loc *time.Location) string {
+	if loc == time.Local {
+		if tz := os.Getenv("TZ"); tz != "" {
+			return tz
+		}
+		return timeNow().Format("MST")
+	}
+	return loc.String()
+}
+
+// locationFor picks the timezone for a set of devices: theirs if they all
+// agree, otherwise the site timezone. nil means every device.
+func (s *Store) locationFor(ctx context.Context, deviceIDs []string) *time.Location {
+	if len(deviceIDs) == 0 {
+		return siteLocation()
+	}
+	loc := s.getDevice(ctx, deviceIDs[0]).Location()
+	for _, id := range deviceIDs[1:] {
+		if s.getDevice(ctx, id).Location().String() != loc.String() {
+			return siteLocation()
+		}
+	}
+	return loc
+}
+
+// setDeviceTimezone records a detector's IANA timezone; empty clears it
+func (s *Store) setDeviceTimezone(ctx context.Context, deviceID, tz string) error {
+	var value interface{}
+	if tz != "" {
+		value = tz
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, timezone) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET timezone = excluded.timezone
+	`, deviceID, value)
+	return err
+}
+
+// dbTimeAgo formats a rolling cutoff the same way timestamps are stored
+// (server local time); SQLite's datetime('now') is UTC and doesn't compare
+func dbTimeAgo(d time.Duration) string {
+	return timeNow().Add(-d).Format("2006-01-02 15:04:05")
+}
+
+// periodStart returns the stored-format cutoff for "the last n days" as calendar
+// days in loc: midnight n-1 days ago, so "7 days" is today plus the previous six
+func periodStart(days int, loc *time.Location) string {
+	now := timeNow().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day()-(days-1), 0, 0, 0, 0, loc)
+	return start.In(time.Local).Format("2006-01-02 15:04:05")
+}
+
+// maxRangeDays caps a custom date range, like the days= of the fixed windows
+const maxRangeDays = 365
+
+// DateRange is a run of whole calendar days in a timezone: Start is the first
+// day's midnight and End the midnight after the last. An exact range is any
+// span of time, Start inclusive and End exclusive.
+type DateRange struct {
+	Start, End time.Time
+	Exact      bool
+}
+
+// lastDays is today and the days-1 before it, in loc
+func lastDays(days int, loc *time.Location) DateRange {
+	now := timeNow().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day()-(days-1), 0, 0, 0, 0, loc)
+	return DateRange{Start: start, End: start.AddDate(0, 0, days)}
+}
+
+// Days counts the calendar days in the range
+func (d DateRange) Days() int {
+	n := 0
+	for t := d.Start; t.Before(d.End); t = t.AddDate(0, 0, 1) {
+		n++
+	}
+	return n
+}
+
+// From and To are the first and last days as YYYY-MM-DD, or an exact range's
+// start and end in RFC 3339
+func (d DateRange) From() string {
+	if d.Exact {
+		return d.Start.Format(time.RFC3339)
+	}
+	return d.Start.Format("2006-01-02")
+}
+
+func (d DateRange) To() string {
+	if d.Exact {
+		return d.End.Format(time.RFC3339)
+	}
+	return d.End.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// dbBounds formats the range the way timestamps are stored (server local time)
+func (d DateRange) dbBounds() (string, string) {
+	return d.Start.In(time.Local).Format("2006-01-02 15:04:05"), d.End.In(time.Local).Format("2006-01-02 15:04:05")
+}
+
+// parseDateRange reads from=YYYY-MM-DD and to=YYYY-MM-DD, both inclusive, as
+// days in loc; to defaults to today. ok is false when neither is given, for
+// callers to fall back on their fixed windows.
+func parseDateRange(q url.Values, loc *time.Location) (r DateRange, ok bool, err error) {
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" && to == "" {
+		return DateRange{}, false, nil
+	}
+	today := timeNow().In(loc).Format("2006-01-02")
+	if to == "" {
+		to = today
+	}
+	if from == "" {
+		from = to
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, loc)
+	if err != nil {
+		return DateRange{}, false, fmt.Errorf("from must be a date like %s", today)
+	}
+	last, err := time.ParseInLocation("2006-01-02", to, loc)
+	if err != nil {
+		return DateRange{}, false, fmt.Errorf("to must be a date like %s", today)
+	}
+	r = DateRange{Start: start, End: last.AddDate(0, 0, 1)}
+	switch n := r.Days(); {
+	case n < 1:
+		return DateRange{}, false, fmt.Errorf("from must not be after to")
+	case n > maxRangeDays:
+		return DateRange{}, false, fmt.Errorf("a range can be at most %d days", maxRangeDays)
+	}
+	return r, true, nil
+}
+
+// parseTimeRange is parseDateRange for the API, where from and to may also be
+// times: RFC 3339, or 2006-01-02T15:04[:05] in loc. A date from is that day's
+// midnight and a date to the midnight after it; a time to is exclusive. With
+// a time in either the range is exact, and to defaults to now.
+func parseTimeRange(q url.Values, loc *time.Location) (DateRange, bool, error) {
+	from, to := q.Get("from"), q.Get("to")
+	if isDate(from) && isDate(to) {
+		return parseDateRange(q, loc)
+	}
+	if from == "" {
+		return DateRange{}, false, fmt.Errorf("from is required with a time for to")
+	}
+	r := DateRange{Exact: true, End: timeNow().In(loc)}
+	var err error
+	if r.Start, err = parseRangeBound(from, loc, false); err != nil {
+		return DateRange{}, false, fmt.Errorf("from: %w", err)
+	}
+	if to != "" {
+		if r.End, err = parseRangeBound(to, loc, true); err != nil {
+			return DateRange{}, false, fmt.Errorf("to: %w", err)
+		}
+	}
+	switch span := r.End.Sub(r.Start); {
+	case span <= 0:
+		return DateRange{}, false, fmt.Errorf("from must be before to")
+	case span > maxRangeDays*24*time.Hour:
+		return DateRange{}, false, fmt.Errorf("a range can be at most %d days", maxRangeDays)
+	}
+	return r, true, nil
+}
+
+// isDate reports whether v is empty or a bare YYYY-MM-DD
+func isDate(v string) bool {
+	if v == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", v)
+	return err == nil
+}
+
+// parseRangeBound reads one end of an exact range. A date is its midnight in
+// loc, or the midnight after it for the end of the range.
+func parseRangeBound(v string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date or time like 2025-06-14 or 2025-06-14T09:30", v)
+}
+
+// ActivityBuckets holds detections per local calendar day and per hour of day
+type ActivityBuckets struct {
+	Timezone string   `json:"timezone"`
+	Days     []string `json:"days"`   // YYYY-MM-DD, oldest first
+	Daily    [][]int  `json:"daily"`  // [day][frequency]; null for days no detector was online
+	Hourly   [][]int  `json:"hourly"` // [hour 0-23][frequency]
+
+	Devices map[string][]int `json:"-"` // Whole-period totals by device, [frequency]
+}
+
+// getActivityBuckets buckets the last n calendar days' detections in local time
+func (s *Store) getActivityBuckets(ctx context.Context, deviceIDs []string, days int, loc *time.Location) ActivityBuckets {
+	return s.getActivityRange(ctx, deviceIDs, lastDays(days, loc))
+}
+
+// getActivityRange buckets a range's detections in its timezone. Counters are
+// cumulative per boot, so each upload contributes its increase over the
+// previous one.
+func (s *Store) getActivityRange(ctx context.Context, deviceIDs []string, r DateRange) ActivityBuckets {
+	loc := r.Start.Location()
+	b := ActivityBuckets{Timezone: locationName(loc), Hourly: make([][]int, 24), Devices: make(map[string][]int)}
+	for h := range b.Hourly {
+		b.Hourly[h] = make([]int, len(frequencies()))
+	}
+	dayIndex := make(map[string]int)
+	var bounds []time.Time
+	for start := r.Start; start.Before(r.End); start = start.AddDate(0, 0, 1) {
+		bounds = append(bounds, start)
+		day := start.Format("2006-01-02")
+		dayIndex[day] = len(b.Days)
+		b.Days = append(b.Days, day)
+		b.Daily = append(b.Daily, make([]int, len(frequencies())))
+	}
+	bounds = append(bounds, r.End)
+	days := len(b.Days)
+	from, to := r.dbBounds()
+
+	// Loaded first: the reader pool may be a single connection (in memory),
+	// which the queries below hold until they are done
+	cursors := s.rollupCursors(ctx)
+	online := coverage(s.onlineSpans(ctx, deviceIDs, bounds[0], bounds[days]), bounds)
+	before := s.uploadsBefore(ctx, deviceIDs, from)
+	sets := s.colocatedSets(ctx, deviceIDs)
+	colocated := make(colocatedActivity)
+
+	cond, args := deviceFilter(deviceIDs)
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT device_id, timestamp, uptime_seconds, freqs
+		FROM uploads
+		WHERE timestamp >= ? AND timestamp < ? AND `+cond+`
+		ORDER BY device_id, timestamp, id
+	`, append([]interface{}{from, to}, args...)...)
+	if err != nil {
+		log.Printf("Error loading activity buckets: %v", err)
+		return b
+	}
+	defer rows.Close()
+
+	increases := increaseTracker{cursors: cursors, before: before}
+	for rows.Next() {
+		var deviceID, ts, freqs string
+		var uptime int
+		if err := rows.Scan(&deviceID, &ts, &uptime, &freqs); err != nil {
+			continue
+		}
+		delta := fitCounts(increases.next(deviceID, ts, uptime, parseCounts(freqs)), len(frequencies()))
+		local := parseDBTime(ts).In(loc)
+		day, ok := dayIndex[local.Format("2006-01-02")]
+		totals := b.deviceTotals(deviceID)
+		if set, in := sets[deviceID]; in {
+			if !ok {
+				day = -1
+			}
+			colocated.add(colocatedCell{set, day, local.Hour()}, deviceID, delta)
+			addCounts(totals, delta)
+			continue
+		}
+		for i := range delta {
+			if delta[i] == 0 {
+				continue
+			}
+			if ok {
+				b.Daily[day][i] += delta[i]
+			}
+			b.Hourly[local.Hour()][i] += delta[i]
+			totals[i] += delta[i]
+		}
+	}
+	rows.Close()
+
+	// Rolled-up periods already hold their increases. Daily totals don't say
+	// which hour they happened in, so they only count towards the days.
+	rows, err = s.query(ctx, `
+		SELECT device_id, tier, period, new_freqs
+		FROM upload_rollups
+		WHERE period >= ? AND period < ? AND `+cond,
+		append([]interface{}{from, to}, args...)...)
+	if err != nil {
+		log.Printf("Error loading activity rollups: %v", err)
+		return b
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deviceID, tier, period, freqs string
+		if err := rows.Scan(&deviceID, &tier, &period, &freqs); err != nil {
+			continue
+		}
+		f := planCounts(freqs)
+		local := parseDBTime(period).In(loc)
+		day, ok := dayIndex[local.Format("2006-01-02")]
+		totals := b.deviceTotals(deviceID)
+		if set, in := sets[deviceID]; in {
+			hour := -1
+			if tier == tierHour {
+				hour = local.Hour()
+			}
+			if !ok {
+				day = -1
+			}
+			colocated.add(colocatedCell{set, day, hour}, deviceID, f)
+			addCounts(totals, f)
+			continue
+		}
+		for i := range f {
+			if ok {
+				b.Daily[day][i] += f[i]
+			}
+			if tier == tierHour {
+				b.Hourly[local.Hour()][i] += f[i]
+			}
+			totals[i] += f[i]
+		}
+	}
+
+	colocated.mergeInto(&b)
+
+	// Days no detector was online are gaps, not quiet days
+	for d, day := range b.Daily {
+		if !online[d] && slices.Max(day) == 0 {
+			b.Daily[d] = nil
+		}
+	}
+	return b
+}
+
+// deviceTotals returns a device's per-frequency totals, adding it if new
+func (b *ActivityBuckets) deviceTotals(deviceID string) []int {
+	t, ok := b.Devices[deviceID]
+	if !ok {
+		t = make([]int, len(frequencies()))
+		b.Devices[deviceID] = t
+	}
+	return t
+}
+
+// handleAPIActivity returns local-time daily and hour-of-day detections:
+// GET /api/activity?days=7 (or from=YYYY-MM-DD&to=YYYY-MM-DD) with optional
+// device=ID or group=NAME
+func handleAPIActivity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days := 7
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+
+	var deviceIDs []string
+	if device := r.URL.Query().Get("device"); device != "" {
+		deviceIDs = []string{device}
+	} else if group := r.URL.Query().Get("group"); group != "" {
+		if deviceIDs = store.getGroupDevices(ctx, group); deviceIDs == nil {
+			http.Error(w, "Unknown group", http.StatusNotFound)
+			return
+		}
+	}
+
+	loc := store.locationFor(ctx, deviceIDs)
+	rng, ok, err := parseDateRange(r.URL.Query(), loc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		rng = lastDays(days, loc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store.getActivityRange(ctx, deviceIDs, rng))
+}
+
+// validTimezone reports whether name is a loadable IANA zone
+func validTimezone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("unknown timezone %q", name)
+	}
+	return nil
+}
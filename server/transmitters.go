@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransmitterEstimate is the estimated number of distinct transmitters heard in a category
+type TransmitterEstimate struct {
+	Category     string `json:"category"`
+	Estimate     int    `json:"estimate"`
+	RSSIClusters int    `json:"rssi_clusters"`
+	Periodic     int    `json:"periodic"`
+	Events       int    `json:"events"`
+}
+
+// RSSI clustering parameters
+const (
+	rssiClusterGapDB   = 6.0 // a wider gap between sorted RSSI values starts a new cluster
+	rssiMinClusterSize = 3   // fewer hits than this is treated as noise
+)
+
+// categories returns each category once, in frequency-table order
+func categories() []string {
+	var cats []string
+	seen := make(map[string]bool)
+	for _, f := range frequencies() {
+		if f.Scanned() && !seen[f.Category] {
+			seen[f.Category] = true
+			cats = append(cats, f.Category)
+		}
+	}
+	return cats
+}
+
+// countRSSIClusters groups RSSI readings that sit close together. Transmitters
+// at different distances arrive at distinct signal levels, so each cluster is
+// a rough stand-in for one device.
+func countRSSIClusters(rssi []float64) int {
+	if len(rssi) == 0 {
+		return 0
+	}
+	sort.Float64s(rssi)
+
+	clusters := 0
+	size := 1
+	for i := 1; i <= len(rssi); i++ {
+		if i == len(rssi) || rssi[i]-rssi[i-1] > rssiClusterGapDB {
+			if size >= rssiMinClusterSize {
+				clusters++
+			}
+			size = 0
+		}
+		size++
+	}
+	return clusters
+}
+
+// estimateTransmitters combines RSSI clusters and periodic trains per category.
+// LoRaWAN devices hop channels, so RSSI is clustered across a whole category
+// rather than per frequency; periodic trains are already per channel.
+func (s *Store) estimateTransmitters(ctx context.Context, deviceID string, since time.Time) []TransmitterEstimate {
+	rssiByCat := make(map[string][]float64)
+	eventsByCat := make(map[string]int)
+	for _, e := range s.getEvents(ctx, deviceID, since, timeNow()) {
+		cat := frequencyAt(e.FreqIndex).Category
+		eventsByCat[cat]++
+		if e.RSSI != nil {
+			rssiByCat[cat] = append(rssiByCat[cat], *e.RSSI)
+		}
+	}
+
+	periodicByCat := make(map[string]int)
+	for _, p := range s.getPeriodicTransmitters(ctx, deviceID, since) {
+		periodicByCat[frequencyAt(p.FreqIndex).Category]++
+	}
+
+	var result []TransmitterEstimate
+	for _, cat := range categories() {
+		est := TransmitterEstimate{
+			Category:     cat,
+			RSSIClusters: countRSSIClusters(rssiByCat[cat]),
+			Periodic:     periodicByCat[cat],
+			Events:       eventsByCat[cat],
+		}
+		est.Estimate = est.RSSIClusters
+		if est.Periodic > est.Estimate {
+			est.Estimate = est.Periodic
+		}
+		if est.Estimate == 0 && est.Events > 0 {
+			est.Estimate = 1
+		}
+		result = append(result, est)
+	}
+	return result
+}
+
+// formatEstimates renders "≈ 6 Sidewalk devices, ≈ 2 Meshtastic nodes" for non-zero categories
+func formatEstimates(l Lang, estimates []TransmitterEstimate) string {
+	var parts []string
+	for _, e := range estimates {
+		if e.Estimate == 0 {
+			continue
+		}
+		info, _ := categoryByName(e.Category)
+		noun := info.Nouns[1]
+		if e.Estimate == 1 {
+			noun = info.Nouns[0]
+		}
+		parts = append(parts, fmt.Sprintf("≈ %d %s", e.Estimate, l.T(noun)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// handleAPITransmitters returns per-category estimates: GET /api/transmitters?device=ID&hours=24
+func handleAPITransmitters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 && v <= 24*7 {
+		hours = v
+	}
+	since := timeNow().Add(-time.Duration(hours) * time.Hour)
+
+	var devices []string
+	if id := r.URL.Query().Get("device"); id != "" {
+		devices = []string{id}
+	} else {
+		store.mu.RLock()
+		for id := range store.latest {
+			devices = append(devices, id)
+		}
+		store.mu.RUnlock()
+	}
+
+	result := make(map[string][]TransmitterEstimate)
+	for _, id := range devices {
+		result[id] = store.estimateTransmitters(ctx, id, since)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+// trendHours is how many hours the dashboard sparkline covers
+const trendHours = 24
+
+// getHourlyDetections returns a device's new detections per hour over the last
+// hours whole hours, oldest first, for the dashboard sparkline. Rolled-up hours
+// come straight from upload_rollups; hours still held as raw uploads add up each
+// upload's increase. NaN marks an hour the detector was offline.
+func (s *Store) getHourlyDetections(ctx context.Context, deviceID string, hours int) []float64 {
+	end := timeNow().Truncate(time.Hour).Add(time.Hour)
+	start := end.Add(-time.Duration(hours) * time.Hour)
+	bounds := make([]time.Time, hours+1)
+	for i := range bounds {
+		bounds[i] = start.Add(time.Duration(i) * time.Hour)
+	}
+	counts := make([]float64, hours)
+	index := func(ts string) (int, bool) {
+		t := parseDBTime(ts)
+		if t.Before(start) || !t.Before(end) {
+			return 0, false
+		}
+		return int(t.Sub(start) / time.Hour), true
+	}
+
+	// Loaded first, as in getActivityBuckets: the reader pool may be one connection
+	cursors := s.rollupCursors(ctx)
+	online := coverage(s.onlineSpans(ctx, []string{deviceID}, start, end), bounds)
+
+	from := start.Format("2006-01-02 15:04:05")
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT period, counts_total(new_freqs)
+		FROM upload_rollups WHERE device_id = ? AND tier = ? AND period >= ?
+	`, deviceID, tierHour, from)
+	if err != nil {
+		log.Printf("Error loading hourly rollups: %v", err)
+		return nil
+	}
+	for rows.Next() {
+		var period string
+		var n int
+		if rows.Scan(&period, &n) != nil {
+			continue
+		}
+		if i, ok := index(period); ok {
+			counts[i] += float64(n)
+		}
+	}
+	rows.Close()
+
+	// The last upload before the window is read too, so the first one inside it
+	// has something to be compared with
+	rows, err = s.query(ctx, `
+		SELECT timestamp, uptime_seconds, freqs
+		FROM uploads
+		WHERE device_id = ? AND timestamp >= COALESCE(
+			(SELECT MAX(timestamp) FROM uploads WHERE device_id = ? AND timestamp < ?), ?)
+		ORDER BY timestamp, id
+	`, deviceID, deviceID, from, from)
+	if err != nil {
+		log.Printf("Error loading hourly uploads: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	increases := increaseTracker{cursors: cursors}
+	for rows.Next() {
+		var ts, freqs string
+		var uptime int
+		if rows.Scan(&ts, &uptime, &freqs) != nil {
+			continue
+		}
+		delta := increases.next(deviceID, ts, uptime, parseCounts(freqs))
+		if i, ok := index(ts); ok {
+			for _, d := range delta {
+				counts[i] += float64(d)
+			}
+		}
+	}
+
+	for i := range counts {
+		if !online[i] && counts[i] == 0 {
+			counts[i] = math.NaN()
+		}
+	}
+	return counts
+}
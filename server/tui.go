@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// The tui command is a live dashboard in the terminal, for keeping an eye on a
+// server over SSH without a browser. Detections come from /api/stream, the
+// feed behind the web dashboard's live meter, and are totalled per channel
+// over a sliding -window; each detector's latest activity comes from polling
+// /api/stats. Only the server's public read APIs are used, so it works against
+// any server the machine can reach. -once prints a single plain frame and
+// exits, for scripts and cron mail.
+
+// tuiState is what the dashboard shows, fed by the stream and the poller
+type tuiState struct {
+	mu      sync.Mutex
+	plan    []FrequencyInfo
+	devices map[string]Stats
+	uploads int
+	hits    []LiveHit // Oldest first, trimmed to the window when drawn
+	stream  string    // The stream's state, shown in the header
+	polled  error     // The last /api/stats poll's error, if it failed
+}
+
+// addHit records detections from the stream
+func (s *tuiState) addHit(h LiveHit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = append(s.hits, h)
+}
+
+func (s *tuiState) setStream(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = status
+}
+
+// tuiClient talks to one server for the dashboard
+type tuiClient struct {
+	base    *url.URL
+	filter  url.Values // device= or group= for the stream
+	members map[string]bool
+	http    *http.Client
+}
+
+// getJSON fetches path from the server into v
+func (c *tuiClient) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.base.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// poll refreshes the plan and the detectors' latest uploads
+func (c *tuiClient) poll(ctx context.Context, s *tuiState) {
+	var stats struct {
+		TotalUploads int              `json:"total_uploads"`
+		Devices      map[string]Stats `json:"devices"`
+		Frequencies  []FrequencyInfo  `json:"frequencies"`
+	}
+	err := c.getJSON(ctx, "/api/stats", nil, &stats)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polled = err
+	if err != nil {
+		return
+	}
+	s.plan, s.uploads = stats.Frequencies, stats.TotalUploads
+	s.devices = make(map[string]Stats)
+	for id, st := range stats.Devices {
+		if c.members == nil || c.members[id] {
+			s.devices[id] = st
+		}
+	}
+}
+
+// stream follows /api/stream until ctx ends, reconnecting after failures.
+// caughtUp is closed once the first connection has replayed its backlog.
+func (c *tuiClient) stream(ctx context.Context, s *tuiState, caughtUp chan struct{}) {
+	var once sync.Once
+	for ctx.Err() == nil {
+		s.setStream("connecting")
+		err := c.streamOnce(ctx, s, func() {
+			s.setStream("live")
+			once.Do(func() { close(caughtUp) })
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue // The server ends streams every few minutes
+		}
+		s.setStream("disconnected: " + err.Error())
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// streamOnce reads one connection's events
+func (c *tuiClient) streamOnce(ctx context.Context, s *tuiState, caughtUp func()) error {
+	u := c.base.JoinPath("/api/stream")
+	u.RawQuery = c.filter.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// No client timeout: the stream is meant to stay open
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	// The backlog replays the last minute, which the next connection would
+	// repeat; only the first connection's backlog is kept
+	replaying := true
+	s.mu.Lock()
+	var last time.Time
+	if len(s.hits) > 0 {
+		last = s.hits[len(s.hits)-1].Time
+	}
+	s.mu.Unlock()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "":
+			switch event {
+			case "detections":
+				var h LiveHit
+				if json.Unmarshal([]byte(data), &h) == nil && !(replaying && !h.Time.After(last)) {
+					s.addHit(h)
+				}
+			case "caught-up":
+				replaying = false
+				caughtUp()
+			}
+			event, data = "", ""
+		}
+	}
+	return scanner.Err()
+}
+
+// renderTUI draws one frame. color adds ANSI colors for a terminal.
+func renderTUI(w io.Writer, s *tuiState, server string, window time.Duration, width int, color bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := now.Add(-window)
+	for len(s.hits) > 0 && !s.hits[0].Time.After(cutoff) {
+		s.hits = s.hits[1:]
+	}
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return "\x1b[" + code + "m" + text + "\x1b[0m"
+	}
+
+	status := s.stream
+	if status == "live" {
+		status = paint("32", "● live")
+	} else {
+		status = paint("33", "○ "+status)
+	}
+	fmt.Fprintf(w, "%s  %s  %s\n", paint("1", "📡 LoRa Detector"), server, status)
+	if s.polled != nil {
+		fmt.Fprintf(w, "%s\n", paint("31", "stats: "+s.polled.Error()))
+	}
+	fmt.Fprintf(w, "%d uploads stored · %s\n\n", s.uploads, now.Format("15:04:05"))
+
+	// Per-channel detections over the window
+	counts := make([]int, len(s.plan))
+	total := 0
+	for _, h := range s.hits {
+		for i, c := range h.Counts {
+			if i < len(counts) {
+				counts[i] += c
+				total += c
+			}
+		}
+	}
+	most := 1
+	for _, c := range counts {
+		most = max(most, c)
+	}
+	span := strings.TrimSuffix(window.String(), "0s") // 5m0s reads as 5m
+	if strings.HasSuffix(span, "h0m") {
+		span = strings.TrimSuffix(span, "0m")
+	}
+	fmt.Fprintf(w, "%s  %d (%.1f/min)\n", paint("1", "Detections, last "+span), total, float64(total)/window.Minutes())
+	barWidth := max(10, width-34)
+	for i, f := range s.plan {
+		if f.MHz == "" {
+			continue
+		}
+		n := counts[i] * barWidth / most
+		fmt.Fprintf(w, " %7s %-14s %s%s %6d\n", f.MHz, tuiClip(f.Label, 14),
+			paint("36", strings.Repeat("█", n)), strings.Repeat("·", barWidth-n), counts[i])
+	}
+
+	// Each detector's latest upload, most recent first
+	fmt.Fprintf(w, "\n%s\n", paint("1", "Detectors"))
+	if len(s.devices) == 0 {
+		fmt.Fprintf(w, " none yet\n")
+	}
+	gauge := max(10, width-60)
+	for _, id := range byLastHeard(s.devices) {
+		st := s.devices[id]
+		n := st.CurrentActivity * gauge / 100
+		bar := strings.Repeat("█", n) + strings.Repeat("·", gauge-n)
+		if st.CurrentActivity >= 10 {
+			bar = paint("31", bar)
+		}
+		fmt.Fprintf(w, " %-18s %s %3d%%  peak %3d%%  %4d/min  %s\n", tuiClip(id, 18), bar,
+			st.CurrentActivity, st.PeakActivity, st.DetectionsPerMin, tuiAgo(now.Sub(st.Timestamp)))
+	}
+}
+
+// tuiClip cuts s to n runes
+func tuiClip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// tuiAgo says how long ago an upload was, coarsely
+func tuiAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", max(0, int(d.Seconds())))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// runTUI shows the live dashboard until interrupted
+func runTUI(args []string) error {
+	fs := newFlagSet("tui", "[-url http://server:8080] [-device ID | -group NAME]")
+	server := fs.String("url", "http://localhost:8080", "server to watch")
+	device := fs.String("device", "", "show one detector")
+	group := fs.String("group", "", "show one group's detectors")
+	window := fs.Duration("window", 5*time.Minute, "how far back the channel bars count")
+	refresh := fs.Duration("refresh", 10*time.Second, "how often detectors' latest uploads are fetched")
+	once := fs.Bool("once", false, "print one frame without colors and exit")
+	fs.Parse(args)
+	if *window < time.Minute || *refresh < time.Second {
+		return errors.New("-window must be at least 1m and -refresh at least 1s")
+	}
+	base, err := url.Parse(strings.TrimSuffix(*server, "/"))
+	if err != nil || base.Host == "" {
+		return fmt.Errorf("invalid -url %q", *server)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := &tuiClient{base: base, filter: url.Values{}, http: &http.Client{Timeout: 15 * time.Second}}
+	switch {
+	case *device != "":
+		c.filter.Set("device", *device)
+		c.members = map[string]bool{*device: true}
+	case *group != "":
+		c.filter.Set("group", *group)
+		var groups []GroupInfo
+		if err := c.getJSON(ctx, "/api/groups", url.Values{"name": {*group}}, &groups); err != nil {
+			return err
+		}
+		c.members = make(map[string]bool)
+		for _, g := range groups {
+			for _, id := range g.Devices {
+				c.members[id] = true
+			}
+		}
+	}
+
+	s := &tuiState{stream: "connecting"}
+	c.poll(ctx, s)
+	if s.polled != nil {
+		return s.polled
+	}
+	caughtUp := make(chan struct{})
+	go c.stream(ctx, s, caughtUp)
+
+	if *once {
+		select {
+		case <-caughtUp:
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+		}
+		renderTUI(os.Stdout, s, base.String(), *window, 80, false, time.Now())
+		return nil
+	}
+
+	// Drawn on the alternate screen, which the terminal restores on exit
+	fd := int(os.Stdout.Fd())
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+	draw := time.NewTicker(time.Second)
+	defer draw.Stop()
+	polling := time.NewTicker(*refresh)
+	defer polling.Stop()
+	for {
+		width := 80
+		if w, _, err := term.GetSize(fd); err == nil {
+			width = w
+		}
+		var frame strings.Builder
+		renderTUI(&frame, s, base.String(), *window, width, true, time.Now())
+		// Overwritten in place, clearing what each line and the screen leave
+		fmt.Print("\x1b[H" + strings.ReplaceAll(frame.String(), "\n", "\x1b[K\n") + "\x1b[J")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-polling.C:
+			c.poll(ctx, s)
+		case <-draw.C:
+		}
+	}
+}
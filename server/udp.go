@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+)
+
+// UDP stats datagram, for links where TCP+TLS+HTTP costs too much:
+//
+//	1 byte   device ID length n, then n bytes device ID
+//	1 byte   token length m, then m bytes device token
+//	29/31    compact stats payload (see codec.go)
+//	4 bytes  CRC-32 (IEEE, big-endian) of everything before it
+//
+// Accepted datagrams are answered with 0x01 followed by the uint32 ack ID.
+const (
+	udpMaxDatagram = 512
+	udpAckOK       = 0x01
+)
+
+// setDeviceToken sets the shared secret a detector must send with UDP datagrams; empty revokes it
+func (s *Store) setDeviceToken(ctx context.Context, deviceID, token string) error {
+	var value interface{}
+	if token != "" {
+		sealed, err := secrets.seal(token, secretContext("devices", "udp_token", deviceID))
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err := s.exec(ctx, `
+		INSERT INTO devices (device_id, udp_token) VALUES (?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET udp_token = excluded.udp_token
+	`, deviceID, value)
+	return err
+}
+
+// getDeviceToken returns a detector's UDP token, or "" if it has none
+func (s *Store) getDeviceToken(ctx context.Context, deviceID string) string {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var token sql.NullString
+	err := s.queryRow(ctx, `SELECT udp_token FROM devices WHERE device_id = ?`, deviceID).Scan(&token)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading device token: %v", err)
+	}
+	plain, err := secrets.open(token.String, secretContext("devices", "udp_token", deviceID))
+	if err != nil {
+		log.Printf("Error opening device token for %s: %v", deviceID, err)
+	}
+	return plain
+}
+
+// parseDatagram checks the CRC and splits out the device ID, token and stats payload
+func parseDatagram(b []byte) (deviceID, token string, payload []byte, err error) {
+	if len(b) < 2+compactHeaderLen+4 {
+		return "", "", nil, fmt.Errorf("datagram too short: %d bytes", len(b))
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return "", "", nil, errors.New("CRC mismatch")
+	}
+
+	n := int(body[0])
+	if n == 0 || 1+n >= len(body) {
+		return "", "", nil, errors.New("bad device ID length")
+	}
+	deviceID = string(body[1 : 1+n])
+	m := int(body[1+n])
+	if 2+n+m > len(body) {
+		return "", "", nil, errors.New("bad token length")
+	}
+	token = string(body[2+n : 2+n+m])
+	return deviceID, token, body[2+n+m:], nil
+}
+
+// ingestDatagram authenticates and stores one datagram, returning the ack ID
+func ingestDatagram(b []byte, from net.Addr) (int64, error) {
+	deviceID, token, payload, err := parseDatagram(b)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := ingestContext()
+	defer cancel()
+
+	// Devices must be given a token before they can report over UDP; the
+	// source address is trivially spoofed, so there is no open mode here
+	want := store.getDeviceToken(ctx, deviceID)
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return 0, fmt.Errorf("invalid token for %s", deviceID)
+	}
+	if err := refuseUnsignable(ctx, deviceID, transportUDP); err != nil {
+		return 0, fmt.Errorf("%s: %w", deviceID, err)
+	}
+
+	stats, err := decodeCompactStats(payload)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", deviceID, err)
+	}
+	stats.DeviceID = deviceID
+	if err := validateStats(&stats); err != nil {
+		return 0, fmt.Errorf("%s: %w", deviceID, err)
+	}
+	stats.Timestamp = timeNow()
+	stats.UploaderIP = "udp:" + from.String()
+
+	// No reply on a failed save, so the device resends as if the datagram was lost
+	if _, err := ingestStats(ctx, &stats); err != nil {
+		return 0, err
+	}
+	return stats.AckID, nil
+}
+
+// serveUDP reads stats datagrams until the connection fails. Bad datagrams are
+// logged and dropped without a reply.
+func serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		ackID, err := ingestDatagram(buf[:n], from)
+		if err != nil {
+			log.Printf("Dropped UDP datagram from %s: %v", from, err)
+			continue
+		}
+		reply := make([]byte, 5)
+		reply[0] = udpAckOK
+		binary.BigEndian.PutUint32(reply[1:], uint32(ackID))
+		if _, err := conn.WriteTo(reply, from); err != nil {
+			log.Printf("Error acking UDP datagram to %s: %v", from, err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User roles
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+// passwordIterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+const passwordIterations = 600000
+
+// Each Basic login costs a PBKDF2 hash, so an address with loginMaxFailures
+// wrong passwords within loginWindow gets 429 until the oldest ages out.
+// Whether admins exist is rechecked every adminsCacheTTL, since the CLI adds
+// users from another process.
+const (
+	loginMaxFailures = 10
+	loginWindow      = 15 * time.Minute
+	adminsCacheTTL   = 30 * time.Second
+)
+
+// deviceIngestPaths are posted to by detectors, which authenticate (if at all)
+// per transport rather than as users
+var deviceIngestPaths = map[string]bool{
+	"/upload":      true,
+	"/events":      true,
+	"/api/lorawan": true,
+
+	// Other instances' community reports, which are public by design, and
+	// federation peers' summaries and relays' batches, which carry their own
+	// signatures
+	communityReportPath: true,
+	federationPath:      true,
+	relayPath:           true,
+}
+
+// adminReads are GETs that still need an admin login, because they describe
+// the server rather than the detectors
+var adminReads = map[string]bool{
+	"/admin":                   true,
+	"/admin/frequencies":       true,
+	"/api/admin/status":        true,
+	"/api/admin/abuse":         true,
+	"/admin/diagnostics":       true,
+	"/api/admin/diagnostics":   true,
+	"/webhooks":                true, // Hook URLs often carry the receiver's key
+	"/api/webhooks":            true,
+	"/api/webhooks/deliveries": true,
+	"/api/quarantine":          true, // Held payloads may be a spoofer's, and aren't public yet
+}
+
+// readOnlyPosts only read, but take a POST body because the request is too
+// structured for a query string
+var readOnlyPosts = map[string]bool{
+	"/api/query": true,
+}
+
+// hashPassword returns "pbkdf2-sha256$iterations$salt$hash" with base64 salt and hash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches a hash from hashPassword
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err1 := enc.DecodeString(parts[2])
+	want, err2 := enc.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// setUser creates a user or replaces their password and role
+func (s *Store) setUser(ctx context.Context, username, password, role string) error {
+	if role != roleAdmin && role != roleViewer {
+		return fmt.Errorf("role must be %s or %s", roleAdmin, roleViewer)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	_, err = s.exec(ctx, `
+		INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET password_hash = excluded.password_hash, role = excluded.role
+	`, username, hash, role, timeNow().Format("2006-01-02 15:04:05"))
+	s.adminsMu.Lock()
+	s.adminsChecked = time.Time{}
+	s.adminsMu.Unlock()
+	return err
+}
+
+// authenticate returns the user's role, or "" if the credentials are wrong
+func (s *Store) authenticate(ctx context.Context, username, password string) string {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var hash, role string
+	err := s.queryRow(ctx, `SELECT password_hash, role FROM users WHERE username = ?`, username).Scan(&hash, &role)
+	if err != nil || !checkPassword(hash, password) {
+		return ""
+	}
+	return role
+}
+
+// hasAdmins reports whether any admin account exists; until one does, the server
+// stays open. The answer is cached for adminsCacheTTL.
+func (s *Store) hasAdmins(ctx context.Context) bool {
+	s.adminsMu.Lock()
+	defer s.adminsMu.Unlock()
+	if now := timeNow(); now.Sub(s.adminsChecked) < adminsCacheTTL && !now.Before(s.adminsChecked) {
+		return s.admins
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM users WHERE role = ?`, roleAdmin).Scan(&n); err != nil {
+		log.Printf("Error checking for admin users: %v", err)
+		return true // fail closed
+	}
+	s.admins, s.adminsChecked = n > 0, timeNow()
+	return s.admins
+}
+
+// signedInUser returns who a trusted proxy or an OIDC session says made the
+// request, and their role
+func signedInUser(ctx context.Context, r *http.Request) (string, string, bool) {
+	if user, role, ok := proxy.identity(ctx, r); ok {
+		return user, role, true
+	}
+	if s, ok := oidc.session(r); ok {
+		return s.User, s.Role, true
+	}
+	return "", "", false
+}
+
+// userRole returns a local user's role, or "" if there is no such user
+func (s *Store) userRole(ctx context.Context, username string) string {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	var role string
+	s.queryRow(ctx, `SELECT role FROM users WHERE username = ?`, username).Scan(&role)
+	return role
+}
+
+// loginLimiter counts wrong Basic passwords per client address
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[netip.Addr][]time.Time // Unix socket peers share the zero Addr
+}
+
+var logins = &loginLimiter{failures: make(map[netip.Addr][]time.Time)}
+
+// recent drops failures older than the window and returns the rest; l.mu must be held
+func (l *loginLimiter) recent(addr netip.Addr, now time.Time) []time.Time {
+	times := l.failures[addr]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > loginWindow {
+		i++
+	}
+	if i == len(times) {
+		delete(l.failures, addr)
+		return nil
+	}
+	l.failures[addr] = times[i:]
+	return times[i:]
+}
+
+// wait is how long addr must wait before trying another password
+func (l *loginLimiter) wait(addr netip.Addr) time.Duration {
+	now := timeNow()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	times := l.recent(addr, now)
+	if len(times) < loginMaxFailures {
+		return 0
+	}
+	return times[len(times)-loginMaxFailures].Add(loginWindow).Sub(now)
+}
+
+// failed counts a wrong password from addr
+func (l *loginLimiter) failed(addr netip.Addr) {
+	now := timeNow()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.failures[addr]; !ok && len(l.failures) >= abuseMaxTracked {
+		for a := range l.failures {
+			l.recent(a, now)
+		}
+		if len(l.failures) >= abuseMaxTracked {
+			return
+		}
+	}
+	l.failures[addr] = append(l.recent(addr, now), now)
+}
+
+// succeeded forgets addr's failures
+func (l *loginLimiter) succeeded(addr netip.Addr) {
+	l.mu.Lock()
+	delete(l.failures, addr)
+	l.mu.Unlock()
+}
+
+// crossSite reports whether a browser sent the request from another site's
+// page, going by Sec-Fetch-Site or, from browsers without it, Origin. Clients
+// that send neither (curl, detectors, scripts) aren't browsers and pass.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site":
+		return true
+	case "same-origin", "same-site", "none":
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
+
+// adminGuard requires an admin login (HTTP Basic, an OIDC session or a trusted
+// proxy's user header) for changes such as device settings and calibration,
+// and for server status, once an admin user exists or OIDC or proxy trust is
+// configured. Other reads and detector uploads are unaffected. Browsers send
+// Basic credentials and pass proxy headers along on cross-site requests too,
+// so changes from another site's page are refused outright.
+func adminGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !deviceIngestPaths[r.URL.Path] && crossSite(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+		isRead := (r.Method == http.MethodGet || r.Method == http.MethodHead) && !adminReads[r.URL.Path]
+		localAdmins := store.hasAdmins(ctx)
+		if isRead || deviceIngestPaths[r.URL.Path] || readOnlyPosts[r.URL.Path] || (!localAdmins && oidc == nil && proxy == nil) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, role, signedIn := signedInUser(ctx, r)
+		if signedIn && role == roleAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if ok && localAdmins {
+			addr, _ := clientIP(r)
+			if wait := logins.wait(addr); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				http.Error(w, "Too many failed logins; try again later", http.StatusTooManyRequests)
+				return
+			}
+			role := store.authenticate(ctx, user, pass)
+			if role == "" {
+				logins.failed(addr)
+				noteAbuse(r, "wrong admin password")
+			} else {
+				logins.succeeded(addr)
+			}
+			if role == roleAdmin {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if signedIn {
+			http.Error(w, "Signed in as "+name+", who isn't an admin", http.StatusForbidden)
+			return
+		}
+		// Browsers opening an admin page go to the sign-in provider
+		if oidc != nil && r.Method == http.MethodGet && !ok {
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		if localAdmins {
+			w.Header().Set("WWW-Authenticate", `Basic realm="LoRa Detector admin"`)
+		}
+		http.Error(w, "Admin login required", http.StatusUnauthorized)
+	})
+}
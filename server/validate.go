@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Every transport checks a decoded upload here before anything is stored, so
+// a malformed or hostile payload is refused rather than becoming a row the
+// summaries can't make sense of: negative counters, activity over 100%, a
+// NaN position (CBOR can carry one, JSON can't, and encoding/json then fails
+// on every API response that includes it). Free text from the device is
+// trimmed rather than refused.
+const (
+	maxDeviceIDLen  = 64
+	maxHealthText   = 256
+	maxEventAgeSecs = 7 * 24 * 3600  // an event's "ago"; older ones were buffered too long to place
+	maxCounter      = math.MaxUint32 // the widest counter the compact format carries
+)
+
+// errInvalidUpload is wrapped by every validation error, which callers answer
+// with 422 (or the transport's equivalent) so the device doesn't resend
+var errInvalidUpload = errors.New("invalid upload")
+
+func invalidUpload(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errInvalidUpload, fmt.Sprintf(format, args...))
+}
+
+// validateDeviceID refuses IDs that would be awkward in URLs, logs and CSV:
+// too long, not UTF-8, or with control characters
+func validateDeviceID(id string) error {
+	switch {
+	case len(id) > maxDeviceIDLen:
+		return invalidUpload("device_id is longer than %d bytes", maxDeviceIDLen)
+	case !utf8.ValidString(id):
+		return invalidUpload("device_id is not UTF-8")
+	case strings.IndexFunc(id, unicode.IsControl) >= 0:
+		return invalidUpload("device_id contains control characters")
+	}
+	return nil
+}
+
+// finite reports whether an optional value is absent or a real number within limit
+func finite(v *float64, limit float64) bool {
+	return v == nil || (!math.IsNaN(*v) && math.Abs(*v) <= limit)
+}
+
+// counter reports whether v fits a device counter; larger values would wrap
+// the sums in the summaries
+func counter(v int) bool {
+	return v >= 0 && v <= maxCounter
+}
+
+// validateStats refuses values no detector could report and trims overlong
+// health text
+func validateStats(st *Stats) error {
+	if err := validateDeviceID(st.DeviceID); err != nil {
+		return err
+	}
+	switch {
+	case !counter(st.Uptime) || !counter(st.TotalDetections) || !counter(st.DetectionsPerMin):
+		return invalidUpload("uptime_seconds, total_detections and detections_per_min must be 0-%d", maxCounter)
+	case st.CurrentActivity < 0 || st.CurrentActivity > 100 || st.PeakActivity < 0 || st.PeakActivity > 100:
+		return invalidUpload("activity must be 0-100%%")
+	case len(st.FreqDetections) > maxChannels:
+		return invalidUpload("freq_detections has %d channels; at most %d", len(st.FreqDetections), maxChannels)
+	case !finite(st.Latitude, 90) || !finite(st.Longitude, 180):
+		return invalidUpload("latitude or longitude out of range")
+	case !finite(st.Bearing, math.MaxFloat64):
+		return invalidUpload("bearing_deg is not a number")
+	case !finite(st.BatteryV, 100) || !finite(st.TemperatureC, 1000):
+		return invalidUpload("battery_v or temperature_c out of range")
+	case st.LastAck != nil && *st.LastAck < 0:
+		return invalidUpload("last_ack can't be negative")
+	}
+	for _, c := range st.FreqDetections {
+		if !counter(c) {
+			return invalidUpload("freq_detections must be 0-%d", maxCounter)
+		}
+	}
+	st.ResetReason = clipText(st.ResetReason, maxHealthText)
+	st.LastError = clipText(st.LastError, maxHealthText)
+	return nil
+}
+
+// validateEvents refuses an event upload whose device ID or times can't be placed
+func validateEvents(up EventUpload) error {
+	if err := validateDeviceID(up.DeviceID); err != nil {
+		return err
+	}
+	when := func(t, ago int64) error {
+		if t < 0 || ago < 0 || ago > maxEventAgeSecs {
+			return invalidUpload("event times must be positive and at most %d seconds ago", maxEventAgeSecs)
+		}
+		return nil
+	}
+	for _, e := range up.Events {
+		if err := when(e.Time, e.Ago); err != nil {
+			return err
+		}
+	}
+	for _, b := range up.Bins {
+		if err := when(b.Time, b.Ago); err != nil {
+			return err
+		}
+		if len(b.Counts) > maxChannels {
+			return invalidUpload("a bin has %d channels; at most %d", len(b.Counts), maxChannels)
+		}
+	}
+	return nil
+}
+
+// clipText cuts s to at most n bytes without splitting a character, and drops
+// invalid UTF-8
+func clipText(s string, n int) string {
+	s = strings.ToValidUTF8(s, "")
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With WEATHER_PROVIDER=open-meteo the server fetches hourly temperature and
+// rain for each located detector from Open-Meteo (no API key needed) and keeps
+// them next to the hourly rollups, so /weather can set detection rates against
+// the weather. Each fetch covers the last two days, which fills gaps left by
+// an outage of either side.
+const (
+	weatherFetchInterval = time.Hour
+	weatherPastDays      = 2
+	weatherMaxDays       = 90
+)
+
+// weatherProvider is the configured provider (WEATHER_PROVIDER), or "" for none
+func weatherProvider() string {
+	return strings.ToLower(os.Getenv("WEATHER_PROVIDER"))
+}
+
+// openMeteoURL is the Open-Meteo API base (WEATHER_URL), for self-hosted instances
+func openMeteoURL() string {
+	if v := os.Getenv("WEATHER_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "https://api.open-meteo.com"
+}
+
+// WeatherHour is one hour's weather at a detector
+type WeatherHour struct {
+	Hour            time.Time `json:"hour"`
+	TemperatureC    *float64  `json:"temperature_c"`
+	PrecipitationMM *float64  `json:"precipitation_mm"`
+}
+
+// openMeteoResponse is the part of an Open-Meteo forecast response we use
+type openMeteoResponse struct {
+	Hourly struct {
+		Time          []int64    `json:"time"`
+		Temperature   []*float64 `json:"temperature_2m"`
+		Precipitation []*float64 `json:"precipitation"`
+	} `json:"hourly"`
+}
+
+// fetchOpenMeteo returns the past few days of hourly weather at a location
+func fetchOpenMeteo(ctx context.Context, client *http.Client, lat, lon float64) ([]WeatherHour, error) {
+	q := url.Values{
+		"latitude":      {strconv.FormatFloat(lat, 'f', 4, 64)},
+		"longitude":     {strconv.FormatFloat(lon, 'f', 4, 64)},
+		"hourly":        {"temperature_2m,precipitation"},
+		"past_days":     {strconv.Itoa(weatherPastDays)},
+		"forecast_days": {"1"},
+		"timeformat":    {"unixtime"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, openMeteoURL()+"/v1/forecast?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open-meteo: %s", resp.Status)
+	}
+	var body openMeteoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("open-meteo: %w", err)
+	}
+	h := body.Hourly
+	if len(h.Temperature) != len(h.Time) || len(h.Precipitation) != len(h.Time) {
+		return nil, fmt.Errorf("open-meteo: hourly series differ in length")
+	}
+
+	// The forecast day is left out: only weather that has happened is stored
+	var hours []WeatherHour
+	now := timeNow()
+	for i, t := range h.Time {
+		hour := time.Unix(t, 0)
+		if hour.After(now) {
+			break
+		}
+		hours = append(hours, WeatherHour{Hour: hour, TemperatureC: h.Temperature[i], PrecipitationMM: h.Precipitation[i]})
+	}
+	return hours, nil
+}
+
+// saveWeather stores a detector's hourly weather, replacing hours already held
+func (s *Store) saveWeather(ctx context.Context, deviceID string, hours []WeatherHour) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, h := range hours {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO weather_hourly (device_id, hour, temperature_c, precipitation_mm)
+			VALUES (?, ?, ?, ?)
+		`, deviceID, h.Hour.In(time.Local).Format("2006-01-02 15:04:05"), h.TemperatureC, h.PrecipitationMM); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getWeather returns a detector's stored weather for the hours starting at
+// start, oldest first; hours with no record have nil readings
+func (s *Store) getWeather(ctx context.Context, deviceID string, start time.Time, hours int) []WeatherHour {
+	out := make([]WeatherHour, hours)
+	for i := range out {
+		out[i].Hour = start.Add(time.Duration(i) * time.Hour)
+	}
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT hour, temperature_c, precipitation_mm FROM weather_hourly
+		WHERE device_id = ? AND hour >= ?
+	`, deviceID, start.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Error loading weather: %v", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts string
+		var temp, rain *float64
+		if err := rows.Scan(&ts, &temp, &rain); err != nil {
+			continue
+		}
+		if i := int(parseDBTime(ts).Sub(start) / time.Hour); i >= 0 && i < hours {
+			out[i].TemperatureC, out[i].PrecipitationMM = temp, rain
+		}
+	}
+	return out
+}
+
+// followWeather fetches weather for every located detector each hour
+func (s *Store) followWeather() {
+	ctx := context.Background()
+	client := &http.Client{Timeout: 30 * time.Second}
+	log.Printf("Fetching hourly weather from %s", openMeteoURL())
+	for {
+		for _, d := range s.getDevices(ctx) {
+			if !d.Located() {
+				continue
+			}
+			hours, err := fetchOpenMeteo(ctx, client, *d.Latitude, *d.Longitude)
+			if err == nil {
+				err = s.saveWeather(ctx, d.DeviceID, hours)
+			}
+			if err != nil {
+				log.Printf("Error updating weather for %s: %v", d.DeviceID, err)
+			}
+		}
+		time.Sleep(weatherFetchInterval)
+	}
+}
+
+// WeatherCorrelation sets a detector's hourly detections against the weather
+type WeatherCorrelation struct {
+	DeviceID    string            `json:"device_id"`
+	Days        int               `json:"days"`
+	Hours       int               `json:"hours"` // Hours with both detections and weather
+	Temperature *float64          `json:"temperature_r"`
+	Rain        *float64          `json:"precipitation_r"`
+	DryRate     *float64          `json:"dry_per_hour"`
+	WetRate     *float64          `json:"wet_per_hour"`
+	Bands       []TemperatureBand `json:"temperature_bands"`
+}
+
+// TemperatureBand is the mean hourly detections within a 10 °C range
+type TemperatureBand struct {
+	FromC   float64 `json:"from_c"`
+	ToC     float64 `json:"to_c"`
+	Hours   int     `json:"hours"`
+	PerHour float64 `json:"per_hour"`
+}
+
+// pearson is the correlation coefficient of two equal-length series, or nil
+// if either doesn't vary
+func pearson(x, y []float64) *float64 {
+	n := float64(len(x))
+	if n < 3 {
+		return nil
+	}
+	var sx, sy, sxx, syy, sxy float64
+	for i := range x {
+		sx, sy = sx+x[i], sy+y[i]
+		sxx, syy, sxy = sxx+x[i]*x[i], syy+y[i]*y[i], sxy+x[i]*y[i]
+	}
+	den := math.Sqrt(n*sxx-sx*sx) * math.Sqrt(n*syy-sy*sy)
+	if den == 0 {
+		return nil
+	}
+	r := math.Round((n*sxy-sx*sy)/den*100) / 100
+	return &r
+}
+
+// correlateWeather matches hourly detections with weather hour by hour,
+// skipping hours the detector was offline or the weather is missing
+func correlateWeather(deviceID string, days int, detections []float64, weather []WeatherHour) WeatherCorrelation {
+	c := WeatherCorrelation{DeviceID: deviceID, Days: days}
+	var det, temp, rain []float64
+	var dry, wet []float64
+	bands := make(map[int][]float64)
+	for i, d := range detections {
+		if i >= len(weather) || math.IsNaN(d) || weather[i].TemperatureC == nil || weather[i].PrecipitationMM == nil {
+			continue
+		}
+		t, p := *weather[i].TemperatureC, *weather[i].PrecipitationMM
+		det, temp, rain = append(det, d), append(temp, t), append(rain, p)
+		if p > 0 {
+			wet = append(wet, d)
+		} else {
+			dry = append(dry, d)
+		}
+		band := int(math.Floor(t / 10))
+		bands[band] = append(bands[band], d)
+	}
+	c.Hours = len(det)
+	c.Temperature, c.Rain = pearson(det, temp), pearson(det, rain)
+	mean := func(v []float64) *float64 {
+		if len(v) == 0 {
+			return nil
+		}
+		sum := 0.0
+		for _, x := range v {
+			sum += x
+		}
+		m := math.Round(sum/float64(len(v))*10) / 10
+		return &m
+	}
+	c.DryRate, c.WetRate = mean(dry), mean(wet)
+	for b := -5; b <= 5; b++ {
+		if v, ok := bands[b]; ok {
+			c.Bands = append(c.Bands, TemperatureBand{FromC: float64(b * 10), ToC: float64(b*10 + 10), Hours: len(v), PerHour: *mean(v)})
+		}
+	}
+	return c
+}
+
+// weatherDays reads ?days= for the weather view, two weeks by default
+func weatherDays(r *http.Request) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && v > 0 && v <= weatherMaxDays {
+		return v
+	}
+	return 14
+}
+
+// formatCorrelation describes a correlation coefficient in words
+func formatCorrelation(r *float64) string {
+	if r == nil {
+		return "–"
+	}
+	strength := "no"
+	switch a := math.Abs(*r); {
+	case a >= 0.7:
+		strength = "strong"
+	case a >= 0.4:
+		strength = "moderate"
+	case a >= 0.2:
+		strength = "weak"
+	}
+	if strength != "no" {
+		if *r > 0 {
+			strength += " positive"
+		} else {
+			strength += " negative"
+		}
+	}
+	return fmt.Sprintf("%+.2f (%s correlation)", *r, strength)
+}
+
+// handleWeather charts a detector's hourly detections against temperature and
+// rain: GET /weather?device=ID&days=14
+func handleWeather(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deviceID := r.URL.Query().Get("device")
+	if deviceID == "" {
+		http.Error(w, "device required", http.StatusBadRequest)
+		return
+	}
+	days := weatherDays(r)
+	hours := days * 24
+	start := timeNow().Truncate(time.Hour).Add(time.Hour).Add(-time.Duration(hours) * time.Hour)
+	detections := store.getHourlyDetections(ctx, deviceID, hours)
+	weather := store.getWeather(ctx, deviceID, start, hours)
+	c := correlateWeather(deviceID, days, detections, weather)
+
+	if r.URL.Path == "/api/weather" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+
+	times := make([]time.Time, hours)
+	temps := make([]float64, hours)
+	rain := make([]float64, hours)
+	for i, h := range weather {
+		times[i], temps[i], rain[i] = h.Hour, math.NaN(), math.NaN()
+		if h.TemperatureC != nil {
+			temps[i] = *h.TemperatureC
+		}
+		if h.PrecipitationMM != nil {
+			rain[i] = *h.PrecipitationMM
+		}
+	}
+
+	nights := deviceNights(store.getDevice(ctx, deviceID), times[0], times[len(times)-1])
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Detections and Weather", "")
+	fmt.Fprintf(w, `    <h1>🌦️ Detections and Weather</h1>
+    <p class="subtitle"><span class="device-id">%s</span> · last %d days · %d hours with detections and weather</p>
+    <div class="card">
+`, html.EscapeString(deviceID), days, c.Hours)
+	if c.Hours == 0 {
+		msg := "No weather recorded for this detector yet. It needs a location, and the server needs <code>WEATHER_PROVIDER=open-meteo</code>."
+		if weatherProvider() != "" {
+			msg = "No weather recorded for this detector yet. Weather is fetched hourly for detectors with a location."
+		}
+		fmt.Fprintf(w, `        <div class="no-data"><p>%s</p></div>
+`, msg)
+	} else {
+		fmt.Fprintf(w, `        <div class="chart-label">Detections per hour</div>
+        `)
+		renderLineChart(w, times, detections, "#00d4ff", 600, 80, nights...)
+		fmt.Fprintf(w, `
+        <div class="chart-label">Temperature (°C)</div>
+        `)
+		renderLineChart(w, times, temps, "#FF9800", 600, 60, nights...)
+		fmt.Fprintf(w, `
+        <div class="chart-label">Precipitation (mm)</div>
+        `)
+		renderLineChart(w, times, rain, "#2196F3", 600, 40, nights...)
+		dry, wet := "–", "–"
+		if c.DryRate != nil {
+			dry = fmt.Sprintf("%.1f", *c.DryRate)
+		}
+		if c.WetRate != nil {
+			wet = fmt.Sprintf("%.1f", *c.WetRate)
+		}
+		fmt.Fprintf(w, `
+        <table class="freq-table weather-table">
+            <tr class="freq-row"><th scope="row">Temperature</th><td>%s</td></tr>
+            <tr class="freq-row"><th scope="row">Precipitation</th><td>%s</td></tr>
+            <tr class="freq-row"><th scope="row">Detections per hour, dry</th><td>%s</td></tr>
+            <tr class="freq-row"><th scope="row">Detections per hour, rain</th><td>%s</td></tr>
+        </table>
+    </div>
+    <div class="card">
+        <h2><span class="icon">🌡️</span> By Temperature</h2>
+        <table class="freq-table weather-table">
+            <tr class="freq-row"><th scope="col">°C</th><th scope="col">Hours</th><th scope="col">Detections per hour</th></tr>
+`, formatCorrelation(c.Temperature), formatCorrelation(c.Rain), dry, wet)
+		for _, b := range c.Bands {
+			fmt.Fprintf(w, `            <tr class="freq-row"><th scope="row">%.0f to %.0f</th><td class="freq-count">%d</td><td class="freq-count">%.1f</td></tr>
+`, b.FromC, b.ToC, b.Hours, b.PerHour)
+		}
+		fmt.Fprintf(w, `        </table>
+`)
+	}
+	fmt.Fprintf(w, `    </div>
+    <footer>`)
+	for _, d := range []int{7, 14, 30, 90} {
+		fmt.Fprintf(w, `<a href="/weather?device=%s&amp;days=%d" style="color: #00d4ff;">%dd</a> · `, url.QueryEscape(deviceID), d, d)
+	}
+	fmt.Fprintf(w, `<a href="/api/weather?device=%s&amp;days=%d" style="color: #00d4ff;">JSON</a> · <a href="/?device=%s" style="color: #00d4ff;">← Detector</a></footer>
+`, url.QueryEscape(deviceID), days, url.QueryEscape(deviceID))
+	writePageEnd(w)
+}
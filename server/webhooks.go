@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Outbound webhooks POST a JSON body to a URL (IFTTT, Home Assistant, Slack,
+// n8n...) when something happens. Each has a text/template body, so it can be
+// shaped to what the receiver expects:
+//
+//	{"value1": {{json .DeviceID}}, "value2": {{json .Message}}}
+//
+// Deliveries are queued in the database and retried with backoff, and the
+// outcome of each is kept for /webhooks.
+const (
+	webhookUpload  = "upload"  // Every upload from a detector
+	webhookAlert   = "alert"   // An alert was raised (battery or baseline threshold crossed, upload gaps...)
+	webhookOffline = "offline" // A detector stopped uploading
+	webhookTest    = "test"    // Sent from /webhooks to try a hook
+)
+
+// webhookEvents are the events a hook can subscribe to
+var webhookEvents = []string{webhookUpload, webhookAlert, webhookOffline}
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+const (
+	webhookMaxAttempts = 6
+	webhookFirstRetry  = 30 * time.Second // Doubling: 30s, 1m, 2m, 4m, 8m
+	webhookTimeout     = 15 * time.Second
+	webhookBatch       = 20
+	webhookMaxBody     = 64 << 10
+	webhookDefaultBody = "{{json .}}"
+)
+
+// webhookWake nudges the delivery loop when something is queued
+var webhookWake = make(chan struct{}, 1)
+
+// Webhook is a configured outbound integration
+type Webhook struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	DeviceID  string    `json:"device_id,omitempty"` // Only this detector; empty for all
+	Template  string    `json:"template"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+
+	body *template.Template
+}
+
+// WebhookEvent is what a body template is executed with. Fields that don't
+// apply to the event are left empty.
+type WebhookEvent struct {
+	Event            string     `json:"event"`
+	DeviceID         string     `json:"device_id"`
+	Time             time.Time  `json:"time"`
+	Kind             string     `json:"kind,omitempty"`    // Alert kind
+	Message          string     `json:"message,omitempty"` // Alert or offline message
+	TotalDetections  int        `json:"total_detections,omitempty"`
+	DetectionsPerMin int        `json:"detections_per_min,omitempty"`
+	Activity         int        `json:"current_activity_pct,omitempty"`
+	FreqDetections   []int      `json:"freq_detections,omitempty"`
+	LastUpload       *time.Time `json:"last_upload,omitempty"` // Offline: when the detector was last heard
+}
+
+// WebhookDelivery is one queued or attempted POST
+type WebhookDelivery struct {
+	ID          int64      `json:"id"`
+	WebhookID   int64      `json:"webhook_id"`
+	Event       string     `json:"event"`
+	DeviceID    string     `json:"device_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	HTTPStatus  int        `json:"http_status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	Body        string     `json:"body"`
+}
+
+// offlineAfter is how long a detector can go without uploading before it
+// counts as offline (OFFLINE_AFTER_MIN, default 10)
+func offlineAfter() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("OFFLINE_AFTER_MIN")); err == nil && v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+// parseWebhookTemplate compiles a body template and checks that it renders
+// valid JSON for a sample event
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	t, err := template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := WebhookEvent{
+		Event: webhookAlert, DeviceID: "lora-detector-1", Time: timeNow(),
+		Kind: "battery_low", Message: "Battery low: 3.40 V (threshold 3.50 V)",
+		TotalDetections: 386, DetectionsPerMin: 15, Activity: 5,
+		FreqDetections: make([]int, len(frequencies())),
+	}
+	body, err := renderWebhook(t, sample)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("template doesn't produce valid JSON; use {{json .Field}} to quote values: %s", body)
+	}
+	return t, nil
+}
+
+// renderWebhook executes a body template
+func renderWebhook(t *template.Template, ev WebhookEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ev); err != nil {
+		return nil, err
+	}
+	if buf.Len() > webhookMaxBody {
+		return nil, fmt.Errorf("body is over %d bytes", webhookMaxBody)
+	}
+	return buf.Bytes(), nil
+}
+
+// subscribes reports whether a hook wants an event
+func (h Webhook) subscribes(ev WebhookEvent) bool {
+	return h.Enabled && slices.Contains(h.Events, ev.Event) && (h.DeviceID == "" || h.DeviceID == ev.DeviceID)
+}
+
+// scanWebhook reads a row selected with webhookColumns
+func scanWebhook(rows interface{ Scan(...any) error }) (Webhook, error) {
+	var h Webhook
+	var events, created string
+	var device sql.NullString
+	if err := rows.Scan(&h.ID, &h.Name, &h.URL, &events, &device, &h.Template, &h.Enabled, &created); err != nil {
+		return h, err
+	}
+	var err error
+	if h.URL, err = openWebhookSecret(h.ID, "url", h.URL); err != nil {
+		return h, err
+	}
+	if h.Template, err = openWebhookSecret(h.ID, "template", h.Template); err != nil {
+		return h, err
+	}
+	h.Events = strings.Split(events, ",")
+	h.DeviceID = device.String
+	h.CreatedAt = parseDBTime(created)
+	return h, nil
+}
+
+const webhookColumns = `id, name, url, events, device_id, template, enabled, created_at`
+
+// sealWebhookSecret seals a hook's url or template for storage (see secrets.go)
+func sealWebhookSecret(id int64, column, value string) (string, error) {
+	return secrets.seal(value, secretContext("webhooks", column, strconv.FormatInt(id, 10)))
+}
+
+// openWebhookSecret opens a hook's stored url or template
+func openWebhookSecret(id int64, column, stored string) (string, error) {
+	plain, err := secrets.open(stored, secretContext("webhooks", column, strconv.FormatInt(id, 10)))
+	if err != nil {
+		return "", fmt.Errorf("webhook %d %s: %w", id, column, err)
+	}
+	return plain, nil
+}
+
+// getWebhooks lists every configured hook
+func (s *Store) getWebhooks(ctx context.Context) []Webhook {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		log.Printf("Error loading webhooks: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var hooks []Webhook
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			log.Printf("Error scanning webhook: %v", err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks
+}
+
+// getWebhook looks up one hook
+func (s *Store) getWebhook(ctx context.Context, id int64) (Webhook, bool) {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	h, err := scanWebhook(s.queryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	return h, err == nil
+}
+
+// loadWebhooks refreshes the enabled hooks cached for ingest
+func (s *Store) loadWebhooks(ctx context.Context) {
+	var active []Webhook
+	for _, h := range s.getWebhooks(ctx) {
+		if !h.Enabled {
+			continue
+		}
+		t, err := parseWebhookTemplate(h.Template)
+		if err != nil {
+			log.Printf("Webhook %d (%s) has a bad template and is skipped: %v", h.ID, h.Name, err)
+			continue
+		}
+		h.body = t
+		active = append(active, h)
+	}
+	s.mu.Lock()
+	s.webhooks = active
+	s.mu.Unlock()
+}
+
+// saveWebhook adds a hook (ID 0) or replaces one, returning its ID
+func (s *Store) saveWebhook(ctx context.Context, h Webhook) (int64, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	var device interface{}
+	if h.DeviceID != "" {
+		device = h.DeviceID
+	}
+	events := strings.Join(h.Events, ",")
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// The url and template are sealed to the hook's ID, so a new hook is
+	// inserted first to get one
+	if h.ID == 0 {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO webhooks (name, url, events, device_id, template, enabled, created_at) VALUES (?, '', ?, ?, '', ?, ?)
+		`, h.Name, events, device, h.Enabled, timeNow().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return 0, err
+		}
+		if h.ID, err = res.LastInsertId(); err != nil {
+			return 0, err
+		}
+	}
+	sealedURL, err := sealWebhookSecret(h.ID, "url", h.URL)
+	if err != nil {
+		return 0, err
+	}
+	sealedTemplate, err := sealWebhookSecret(h.ID, "template", h.Template)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE webhooks SET name = ?, url = ?, events = ?, device_id = ?, template = ?, enabled = ? WHERE id = ?
+	`, h.Name, sealedURL, events, device, sealedTemplate, h.Enabled, h.ID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return h.ID, tx.Commit()
+}
+
+// deleteWebhook removes a hook and its delivery log
+func (s *Store) deleteWebhook(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, tx.Commit()
+}
+
+// fireWebhooks queues a delivery for every enabled hook subscribed to the event
+func (s *Store) fireWebhooks(ctx context.Context, ev WebhookEvent) {
+	if s.readOnly {
+		return
+	}
+	s.mu.RLock()
+	var hooks []Webhook
+	for _, h := range s.webhooks {
+		if h.subscribes(ev) {
+			hooks = append(hooks, h)
+		}
+	}
+	s.mu.RUnlock()
+	for _, h := range hooks {
+		s.queueDelivery(ctx, h, ev)
+	}
+}
+
+// queueDelivery renders a hook's body for an event and queues it. A body that
+// fails to render is logged as a failed delivery.
+func (s *Store) queueDelivery(ctx context.Context, h Webhook, ev WebhookEvent) {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	status, errText := deliveryPending, ""
+	body, err := renderWebhook(h.body, ev)
+	if err != nil {
+		status, errText = deliveryFailed, err.Error()
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err = s.exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, device_id, created_at, body, status, attempts, next_attempt, last_error)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`, h.ID, ev.Event, ev.DeviceID, now, string(body), status, now, errText)
+	if err != nil {
+		log.Printf("Error queueing webhook %d: %v", h.ID, err)
+		return
+	}
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// getWebhookDeliveries returns the newest deliveries first, for one hook or (0) all
+func (s *Store) getWebhookDeliveries(ctx context.Context, webhookID int64, limit int) []WebhookDelivery {
+	ctx, cancel := dbContext(ctx, dbReadTimeout)
+	defer cancel()
+	rows, err := s.query(ctx, `
+		SELECT id, webhook_id, event, COALESCE(device_id, ''), created_at, status, attempts,
+			COALESCE(last_status, 0), COALESCE(last_error, ''), next_attempt, delivered_at, body
+		FROM webhook_deliveries WHERE ? = 0 OR webhook_id = ?
+		ORDER BY id DESC LIMIT ?
+	`, webhookID, webhookID, limit)
+	if err != nil {
+		log.Printf("Error loading webhook deliveries: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var created string
+		var next, delivered sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.DeviceID, &created, &d.Status, &d.Attempts,
+			&d.HTTPStatus, &d.Error, &next, &delivered, &d.Body); err != nil {
+			log.Printf("Error scanning webhook delivery: %v", err)
+			continue
+		}
+		d.CreatedAt = parseDBTime(created)
+		if next.Valid && d.Status == deliveryPending {
+			t := parseDBTime(next.String)
+			d.NextAttempt = &t
+		}
+		if delivered.Valid {
+			t := parseDBTime(delivered.String)
+			d.DeliveredAt = &t
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// deliverWebhooks sends queued deliveries as they come due, for the life of the server
+func (s *Store) deliverWebhooks() {
+	client := &http.Client{Timeout: webhookTimeout}
+	tick := time.NewTicker(10 * time.Second)
+	defer tick.Stop()
+	for {
+		for s.deliverDue(context.Background(), client) == webhookBatch {
+		}
+		select {
+		case <-tick.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// deliverDue attempts a batch of due deliveries, returning how many it tried
+func (s *Store) deliverDue(ctx context.Context, client *http.Client) int {
+	type due struct {
+		id       int64
+		hook     int64
+		event    string
+		url      string
+		body     string
+		attempts int
+	}
+	var batch []due
+	func() {
+		ctx, cancel := dbContext(ctx, dbReadTimeout)
+		defer cancel()
+		rows, err := s.query(ctx, `
+			SELECT d.id, d.webhook_id, d.event, w.url, d.body, d.attempts
+			FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = ? AND d.next_attempt <= ?
+			ORDER BY d.next_attempt LIMIT ?
+		`, deliveryPending, time.Now().Format("2006-01-02 15:04:05"), webhookBatch)
+		if err != nil {
+			log.Printf("Error loading due webhook deliveries: %v", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var d due
+			if err := rows.Scan(&d.id, &d.hook, &d.event, &d.url, &d.body, &d.attempts); err != nil {
+				continue
+			}
+			var err error
+			if d.url, err = openWebhookSecret(d.hook, "url", d.url); err != nil {
+				log.Printf("Error opening webhook URL: %v", err)
+				continue
+			}
+			batch = append(batch, d)
+		}
+	}()
+
+	for _, d := range batch {
+		code, err := postWebhook(ctx, client, d.url, d.event, []byte(d.body))
+		attempts := d.attempts + 1
+		status, errText := deliveryDelivered, ""
+		var next, delivered interface{}
+		now := time.Now()
+		if err != nil {
+			errText = err.Error()
+			// Client errors won't go away by retrying, except timeouts and rate limits
+			permanent := code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+			if permanent || attempts >= webhookMaxAttempts {
+				status = deliveryFailed
+				log.Printf("Webhook delivery %d failed after %d attempt(s): %v", d.id, attempts, err)
+			} else {
+				status = deliveryPending
+				next = now.Add(webhookFirstRetry << (attempts - 1)).Format("2006-01-02 15:04:05")
+			}
+		} else {
+			delivered = now.Format("2006-01-02 15:04:05")
+		}
+		func() {
+			ctx, cancel := dbContext(ctx, dbWriteTimeout)
+			defer cancel()
+			_, err := s.exec(ctx, `
+				UPDATE webhook_deliveries
+				SET status = ?, attempts = ?, last_status = ?, last_error = ?, next_attempt = ?, delivered_at = ?
+				WHERE id = ?
+			`, status, attempts, code, errText, next, delivered, d.id)
+			if err != nil {
+				log.Printf("Error recording webhook delivery %d: %v", d.id, err)
+			}
+		}()
+	}
+	return len(batch)
+}
+
+// postWebhook sends one body, returning the HTTP status (0 if there was no
+// response) and an error unless the receiver answered 2xx
+func postWebhook(ctx context.Context, client *http.Client, target, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lora-detector-webhook")
+	req.Header.Set("X-Webhook-Event", event)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.StatusCode, nil
+}
+
+// watchOffline fires offline webhooks for detectors that stop uploading, once
+// per outage, going by each detector's cadence. Detectors already silent at
+// startup don't fire.
+func (s *Store) watchOffline() {
+	ctx := context.Background()
+	c := s.getCadences(ctx)
+	offline := make(map[string]bool)
+	for id, st := range s.latestStats() {
+		if c.of(id).Offline(st.Timestamp, timeNow()) {
+			offline[id] = true
+		}
+	}
+	for range time.Tick(time.Minute) {
+		c = s.getCadences(ctx)
+		quarantined := s.quarantinedDevices(ctx)
+		for id, st := range s.latestStats() {
+			if quarantined[id] || !c.of(id).Offline(st.Timestamp, timeNow()) {
+				delete(offline, id)
+				continue
+			}
+			if offline[id] {
+				continue
+			}
+			offline[id] = true
+			last, silent := st.Timestamp, timeNow().Sub(st.Timestamp)
+			log.Printf("Detector %s is offline (no upload for %s)", id, silent.Round(time.Minute))
+			s.fireWebhooks(ctx, WebhookEvent{
+				Event: webhookOffline, DeviceID: id, Time: timeNow(), LastUpload: &last,
+				Message: fmt.Sprintf("No upload from %s for %s", id, silent.Round(time.Minute)),
+			})
+		}
+	}
+}
+
+// webhookFromForm reads a hook from form fields: id (to update), name, url,
+// events (repeated or comma-separated), device, template and enabled
+func webhookFromForm(r *http.Request) (Webhook, error) {
+	r.ParseForm()
+	h := Webhook{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		URL:      strings.TrimSpace(r.FormValue("url")),
+		DeviceID: strings.TrimSpace(r.FormValue("device")),
+		Template: strings.TrimSpace(r.FormValue("template")),
+		Enabled:  true,
+	}
+	// The page sends a hidden "false" before the checkbox, so the last value wins
+	if v := r.Form["enabled"]; len(v) > 0 {
+		h.Enabled = v[len(v)-1] != "false" && v[len(v)-1] != "0"
+	}
+	if v := r.FormValue("id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return h, errors.New("bad id")
+		}
+		h.ID = id
+	}
+	if h.Name == "" {
+		return h, errors.New("name required")
+	}
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return h, errors.New("url must be an http:// or https:// URL")
+	}
+	for _, v := range r.Form["events"] {
+		for _, ev := range strings.Split(v, ",") {
+			if ev = strings.TrimSpace(ev); ev == "" || slices.Contains(h.Events, ev) {
+				continue
+			}
+			if !slices.Contains(webhookEvents, ev) {
+				return h, fmt.Errorf("unknown event %q; use %s", ev, strings.Join(webhookEvents, ", "))
+			}
+			h.Events = append(h.Events, ev)
+		}
+	}
+	if len(h.Events) == 0 {
+		return h, errors.New("at least one event required")
+	}
+	if h.Template == "" {
+		h.Template = webhookDefaultBody
+	}
+	if _, err := parseWebhookTemplate(h.Template); err != nil {
+		return h, fmt.Errorf("template: %w", err)
+	}
+	return h, nil
+}
+
+// sendTestWebhook queues a test event for one hook, enabled or not
+func (s *Store) sendTestWebhook(ctx context.Context, id int64) error {
+	h, ok := s.getWebhook(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	t, err := parseWebhookTemplate(h.Template)
+	if err != nil {
+		return err
+	}
+	h.body = t
+	s.queueDelivery(ctx, h, WebhookEvent{
+		Event: webhookTest, DeviceID: h.DeviceID, Time: timeNow(), Message: "Test from " + h.Name,
+		FreqDetections: make([]int, len(frequencies())),
+	})
+	return nil
+}
+
+// handleAPIWebhooks lists hooks (GET), adds or updates one (POST, form fields
+// as webhookFromForm) or deletes one (DELETE ?id=): /api/webhooks
+func handleAPIWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodPost:
+		h, err := webhookFromForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if h.ID, err = store.saveWebhook(ctx, h); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Unknown webhook", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error saving webhook: %v", err)
+			http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+			return
+		}
+		store.loadWebhooks(ctx)
+		saved, _ := store.getWebhook(ctx, h.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+		return
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id required", http.StatusBadRequest)
+			return
+		}
+		found, err := store.deleteWebhook(ctx, id)
+		if err != nil {
+			log.Printf("Error deleting webhook: %v", err)
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Unknown webhook", http.StatusNotFound)
+			return
+		}
+		store.loadWebhooks(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": id})
+		return
+	}
+
+	hooks := store.getWebhooks(ctx)
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// handleAPIWebhookDeliveries returns the delivery log, newest first:
+// GET /api/webhooks/deliveries?webhook=ID&limit=50
+func handleAPIWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("webhook"), 10, 64)
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+	deliveries := store.getWebhookDeliveries(r.Context(), id, limit)
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// handleAPIWebhookTest queues a test event for a hook: POST /api/webhooks/test?id=ID
+func handleAPIWebhookTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	if err := store.sendTestWebhook(r.Context(), id); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Unknown webhook", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "queued", "id": id})
+}
+
+// handleWebhooks is the integration builder: the configured hooks with test,
+// edit and delete buttons, a form to add or edit one, and the delivery log.
+// POSTs (action=save, test or delete) redirect back: /webhooks?edit=ID
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	formError := ""
+	if r.Method == http.MethodPost {
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		var err error
+		switch r.FormValue("action") {
+		case "delete":
+			_, err = store.deleteWebhook(ctx, id)
+			store.loadWebhooks(ctx)
+		case "test":
+			err = store.sendTestWebhook(ctx, id)
+		default:
+			var h Webhook
+			if h, err = webhookFromForm(r); err == nil {
+				_, err = store.saveWebhook(ctx, h)
+				store.loadWebhooks(ctx)
+			}
+		}
+		if err == nil {
+			http.Redirect(w, r, "/webhooks", http.StatusSeeOther)
+			return
+		}
+		formError = err.Error()
+	}
+
+	hooks := store.getWebhooks(ctx)
+	names := make(map[int64]string, len(hooks))
+	for _, h := range hooks {
+		names[h.ID] = h.Name
+	}
+	// The form starts from the hook being edited, or what was just submitted
+	edit := Webhook{Enabled: true, Events: []string{webhookAlert}, Template: webhookDefaultBody}
+	if id, err := strconv.ParseInt(r.URL.Query().Get("edit"), 10, 64); err == nil {
+		if h, ok := store.getWebhook(ctx, id); ok {
+			edit = h
+		}
+	}
+	if formError != "" && r.FormValue("action") == "save" {
+		edit, _ = webhookFromForm(r)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writePageStart(w, "Webhooks", `    <style>
+        .event-log td { padding: 4px 10px 4px 0; font-size: 0.9em; vertical-align: top; }
+        .webhook-form { display: grid; grid-template-columns: max-content 1fr; gap: 8px 12px; align-items: center; }
+        .webhook-form textarea { min-height: 90px; font-family: monospace; }
+        .webhook-form .error { grid-column: 1 / -1; color: #ff5252; }
+        .inline-form { display: inline; }
+        .delivery-ok { color: #4caf50; }
+        .delivery-bad { color: #ff5252; }
+    </style>
+`)
+	fmt.Fprintf(w, `    <h1>🔗 Webhooks</h1>
+    <p class="subtitle">POST templated JSON to IFTTT, Home Assistant, Slack or any URL when detectors upload, raise alerts or go offline</p>
+    <div class="card">
+        <h2>Configured</h2>
+`)
+	if len(hooks) == 0 {
+		io.WriteString(w, "        <p>No webhooks yet.</p>\n")
+	} else {
+		io.WriteString(w, "        <table class=\"event-log\">\n")
+		for _, h := range hooks {
+			scope := "all detectors"
+			if h.DeviceID != "" {
+				scope = h.DeviceID
+			}
+			state := ""
+			if !h.Enabled {
+				state = " (disabled)"
+			}
+			fmt.Fprintf(w, `            <tr><td>%s%s</td><td>%s</td><td>%s</td><td><code>%s</code></td><td>`,
+				html.EscapeString(h.Name), state, html.EscapeString(strings.Join(h.Events, ", ")), html.EscapeString(scope), html.EscapeString(h.URL))
+			if !store.readOnly {
+				fmt.Fprintf(w, `<a href="/webhooks?edit=%d" style="color: #00d4ff;">Edit</a> `, h.ID)
+				for _, action := range []string{"test", "delete"} {
+					fmt.Fprintf(w, `<form class="inline-form" method="post" action="/webhooks"><input type="hidden" name="id" value="%d"><button name="action" value="%s">%s</button></form> `,
+						h.ID, action, strings.ToUpper(action[:1])+action[1:])
+				}
+			}
+			io.WriteString(w, "</td></tr>\n")
+		}
+		io.WriteString(w, "        </table>\n")
+	}
+	io.WriteString(w, "    </div>\n")
+
+	if !store.readOnly {
+		title := "Add Webhook"
+		if edit.ID > 0 {
+			title = "Edit " + edit.Name
+		}
+		fmt.Fprintf(w, `    <div class="card">
+        <h2>%s</h2>
+        <form class="webhook-form" method="post" action="/webhooks">
+`, html.EscapeString(title))
+		if formError != "" {
+			fmt.Fprintf(w, "            <p class=\"error\">%s</p>\n", html.EscapeString(formError))
+		}
+		if edit.ID > 0 {
+			fmt.Fprintf(w, "            <input type=\"hidden\" name=\"id\" value=\"%d\">\n", edit.ID)
+		}
+		fmt.Fprintf(w, `            <label>Name</label><input type="text" name="name" value="%s" required>
+            <label>URL</label><input type="url" name="url" value="%s" placeholder="https://maker.ifttt.com/trigger/lora/with/key/..." required>
+            <label>Events</label><span>`, html.EscapeString(edit.Name), html.EscapeString(edit.URL))
+		for _, ev := range webhookEvents {
+			checked := ""
+			if slices.Contains(edit.Events, ev) {
+				checked = " checked"
+			}
+			fmt.Fprintf(w, `<label><input type="checkbox" name="events" value="%s"%s> %s</label> `, ev, checked, ev)
+		}
+		enabled := ""
+		if edit.Enabled {
+			enabled = " checked"
+		}
+		fmt.Fprintf(w, `</span>
+            <label>Detector</label><input type="text" name="device" value="%s" placeholder="all detectors">
+            <label>Body</label><textarea name="template">%s</textarea>
+            <label>Enabled</label><span><input type="hidden" name="enabled" value="false"><input type="checkbox" name="enabled" value="true"%s></span>
+            <span></span><span><button name="action" value="save">Save</button></span>
+        </form>
+        <p class="chart-label">The body is a Go template over the event: <code>.Event</code>, <code>.DeviceID</code>, <code>.Time</code>,
+        <code>.Kind</code>, <code>.Message</code>, <code>.TotalDetections</code>, <code>.DetectionsPerMin</code>, <code>.Activity</code>,
+        <code>.FreqDetections</code>, <code>.LastUpload</code>. Quote values with <code>json</code>, e.g.
+        <code>{"value1": {{json .DeviceID}}, "value2": {{json .Message}}}</code>; <code>{{json .}}</code> sends the whole event.</p>
+    </div>
+`, html.EscapeString(edit.DeviceID), html.EscapeString(edit.Template), enabled)
+	}
+
+	fmt.Fprintf(w, `    <div class="card">
+        <h2>Recent Deliveries</h2>
+`)
+	deliveries := store.getWebhookDeliveries(ctx, 0, 50)
+	if len(deliveries) == 0 {
+		io.WriteString(w, "        <p>Nothing sent yet.</p>\n")
+	} else {
+		io.WriteString(w, "        <table class=\"event-log\">\n")
+		for _, d := range deliveries {
+			class, outcome := "", d.Status
+			switch d.Status {
+			case deliveryDelivered:
+				class, outcome = "delivery-ok", fmt.Sprintf("delivered (%d)", d.HTTPStatus)
+			case deliveryFailed:
+				class = "delivery-bad"
+			case deliveryPending:
+				if d.NextAttempt != nil && d.Attempts > 0 {
+					outcome = "retrying at " + d.NextAttempt.Format("15:04:05")
+				}
+			}
+			fmt.Fprintf(w, `            <tr><td class="timestamp">%s</td><td>%s</td><td>%s</td><td>%s</td><td class="%s">%s</td><td>%d attempt(s)</td><td>%s</td></tr>
+`, d.CreatedAt.Format("Jan 2 15:04:05"), html.EscapeString(names[d.WebhookID]), d.Event, html.EscapeString(d.DeviceID),
+				class, outcome, d.Attempts, html.EscapeString(d.Error))
+		}
+		io.WriteString(w, "        </table>\n")
+	}
+	io.WriteString(w, `    </div>
+    <footer><a href="/" style="color: #00d4ff;">← Dashboard</a></footer>
+`)
+	writePageEnd(w)
+}
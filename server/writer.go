@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+)
+
+// Uploads are inserted by one writer goroutine, which takes everything waiting
+// in its queue (up to uploadBatchMax) and commits it in one transaction. A lone
+// upload is written straight away; when dozens of detectors report on the same
+// minute boundary they share commits instead of each paying for its own.
+const uploadBatchMax = 128
+
+const insertUploadSQL = `
+	INSERT INTO uploads (device_id, timestamp, uptime_seconds, total_detections,
+		detections_per_min, current_activity_pct, peak_activity_pct,
+		freqs, uploader_ip, bearing, ack_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// ingestQueueSize bounds the uploads waiting for the writer (INGEST_QUEUE_SIZE)
+func ingestQueueSize() int {
+	if v, err := strconv.Atoi(os.Getenv("INGEST_QUEUE_SIZE")); err == nil && v > 0 {
+		return v
+	}
+	return 1024
+}
+
+// uploadWrite is one upload waiting for the writer, with the ack to issue for
+// it if any. done gets the result.
+type uploadWrite struct {
+	ctx   context.Context
+	stats Stats
+	ack   *ackRequest
+	done  chan error
+}
+
+// saveUpload queues an upload for the writer and waits for it to be committed.
+// With ack set, the writer issues the upload's ack in the same transaction (see
+// acks.go) and fills it in.
+func (s *Store) saveUpload(ctx context.Context, stats Stats, ack *ackRequest) error {
+	ctx, cancel := dbContext(ctx, dbWriteTimeout)
+	defer cancel()
+	w := uploadWrite{ctx, stats, ack, make(chan error, 1)}
+	select {
+	case s.uploads <- w:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Once queued, wait for the writer even past the deadline: it skips uploads
+	// whose caller gave up, but one already in a transaction may still commit,
+	// and returning early would have the device resend an upload that was stored
+	return <-w.done
+}
+
+// writeUploads runs the upload writer until the queue is closed
+func (s *Store) writeUploads() {
+	for w := range s.uploads {
+		batch := []uploadWrite{w}
+	fill:
+		for len(batch) < uploadBatchMax {
+			select {
+			case w := <-s.uploads:
+				batch = append(batch, w)
+			default:
+				break fill
+			}
+		}
+		s.writeBatch(batch)
+	}
+}
+
+// writeBatch commits a batch and reports to each caller. If the transaction
+// fails for a reason that isn't transient, the uploads are written one at a
+// time so a single bad row doesn't fail the rest.
+func (s *Store) writeBatch(batch []uploadWrite) {
+	live := batch[:0]
+	for _, w := range batch {
+		if err := w.ctx.Err(); err != nil {
+			w.done <- err
+			continue
+		}
+		live = append(live, w)
+	}
+	if len(live) == 0 {
+		return
+	}
+	err := s.insertUploads(live)
+	if err != nil && len(live) > 1 && !retryableSaveError(err) {
+		for _, w := range live {
+			w.done <- s.insertUploads([]uploadWrite{w})
+		}
+		return
+	}
+	for _, w := range live {
+		w.done <- err
+	}
+}
+
+// insertUploads inserts uploads, and issues their acks, in one transaction
+func (s *Store) insertUploads(batch []uploadWrite) error {
+	ctx, cancel := dbContext(context.Background(), dbWriteTimeout)
+	defer cancel()
+	if len(batch) == 1 && batch[0].ack == nil {
+		_, err := s.exec(ctx, insertUploadSQL, uploadArgs(batch[0].stats)...)
+		return err
+	}
+	stmts, err := s.prepareWrites(ctx, insertUploadSQL, selectAckSQL, upsertAckSQL)
+	if err != nil {
+		return err
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert := tx.StmtContext(ctx, stmts[0])
+	acks := make([]ackRequest, len(batch))
+	for i, w := range batch {
+		if w.ack != nil {
+			acks[i].ack, acks[i].missed, err = issueAck(ctx, tx, stmts[1:], w.stats.DeviceID, w.ack.lastAck)
+			if err != nil {
+				return err
+			}
+			w.stats.AckID = acks[i].ack
+		}
+		if _, err := insert.ExecContext(ctx, uploadArgs(w.stats)...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i, w := range batch {
+		if w.ack != nil {
+			w.ack.ack, w.ack.missed = acks[i].ack, acks[i].missed
+		}
+	}
+	return nil
+}
+
+// uploadArgs returns the insertUploadSQL arguments for an upload
+func uploadArgs(st Stats) []interface{} {
+	return []interface{}{st.DeviceID, st.Timestamp.Format("2006-01-02 15:04:05"),
+		st.Uptime, st.TotalDetections, st.DetectionsPerMin,
+		st.CurrentActivity, st.PeakActivity, encodeCounts(st.FreqDetections),
+		st.UploaderIP, st.Bearing, st.AckID}
+}